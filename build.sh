@@ -17,9 +17,10 @@ git pull || exit 2
 cd .. || exit 2
 
 echo "go build ..."
+# DBUP_PACKAGE_PUBKEY: 内置的安装包签名公钥(dbup package keygen 生成的 .pub 文件内容), 为空时不内置
 go mod tidy || exit 2
-CGO_ENABLED=0 GOOS=linux GOARCH=amd64 go build -ldflags "-X dbup/cmd._version=${version}" -o oasis/oasis-linux-amd64/bin/dbup || exit 2
-CGO_ENABLED=0 GOOS=linux GOARCH=arm64 go build -ldflags "-X dbup/cmd._version=${version}" -o oasis/oasis-linux-arm64/bin/dbup || exit 2
+CGO_ENABLED=0 GOOS=linux GOARCH=amd64 go build -ldflags "-X dbup/cmd._version=${version} -X dbup/internal/global.PackagePublicKey=${DBUP_PACKAGE_PUBKEY}" -o oasis/oasis-linux-amd64/bin/dbup || exit 2
+CGO_ENABLED=0 GOOS=linux GOARCH=arm64 go build -ldflags "-X dbup/cmd._version=${version} -X dbup/internal/global.PackagePublicKey=${DBUP_PACKAGE_PUBKEY}" -o oasis/oasis-linux-arm64/bin/dbup || exit 2
 cd cmd/dbupbackup/ || exit 2
 CGO_ENABLED=0 GOOS=linux GOARCH=amd64 go build -ldflags "-X dbup/cmd/dbupbackup/backupcmd._version=${version}" -o ../../oasis/oasis-linux-amd64/bin/dbupbackup || exit 2
 CGO_ENABLED=0 GOOS=linux GOARCH=arm64 go build -ldflags "-X dbup/cmd/dbupbackup/backupcmd._version=${version}" -o ../../oasis/oasis-linux-arm64/bin/dbupbackup || exit 2
@@ -0,0 +1,90 @@
+package cmd
+
+import (
+	"context"
+	"dbup/internal/output"
+	"dbup/pkg/dbup"
+	"sort"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+// 按部署配置检查时各引擎支持的部署方式
+var deployChecks = map[string]map[string]func(ctx context.Context, cfgFile string) error{
+	"pgsql":   {"master-slave": dbup.PgsqlDeployCheck},
+	"redis":   {"master-slave": dbup.RedisDeployCheck, "cluster": dbup.RedisClusterDeployCheck},
+	"mariadb": {"replication": dbup.MariadbDeployCheck, "galera": dbup.MariadbGaleraDeployCheck},
+	"mongodb": {"replication": dbup.MongodbDeployCheck, "cluster": dbup.MongodbClusterDeployCheck},
+}
+
+// 没有指定 --mode 时的部署方式
+var defaultDeployModes = map[string]string{
+	"pgsql":   "master-slave",
+	"redis":   "master-slave",
+	"mariadb": "replication",
+	"mongodb": "replication",
+}
+
+// dbup check
+func checkCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "check",
+		Short: "部署前环境检查",
+	}
+	// 装载命令
+	cmd.AddCommand(
+		checkHostCmd(),
+	)
+	return cmd
+}
+
+// dbup check host
+func checkHostCmd() *cobra.Command {
+	var engine, mode, config string
+	var ports []int
+	var dirs []string
+	cmd := &cobra.Command{
+		Use:   "host",
+		Short: "检查内核版本、透明大页、swappiness、ulimit、时钟同步、磁盘空间、共享库、端口、SELinux 和防火墙, 按节点和引擎输出检查结果",
+		Long: `检查内核版本、透明大页、swappiness、ulimit、时钟同步、磁盘空间、共享库、端口、SELinux 和防火墙, 按节点和引擎输出 pass/warn/fail 检查结果.
+不指定 --config 时检查本机, 指定时按部署配置文件连接所有节点检查. 检查只读取节点状态, 不做任何修改, 有 fail 项时返回错误.`,
+		Example: `  dbup check host --engine pgsql --port 5432 --dir /opt/pgsql5432
+  dbup check host --engine redis --mode cluster --config redis-cluster.yaml`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			modes, ok := deployChecks[engine]
+			if !ok {
+				return output.Errorf(output.CodeInvalidArgument, "不支持的引擎: %s, 支持: %s", engine, strings.Join(checkEngines(), ", "))
+			}
+			// 检查项失败时只输出错误, 不打印用法
+			if config == "" {
+				cmd.SilenceUsage = true
+				return dbup.CheckHost(cmd.Context(), engine, ports, dirs)
+			}
+			if mode == "" {
+				mode = defaultDeployModes[engine]
+			}
+			check, ok := modes[mode]
+			if !ok {
+				return output.Errorf(output.CodeInvalidArgument, "%s 不支持部署方式 %s", engine, mode)
+			}
+			cmd.SilenceUsage = true
+			return check(cmd.Context(), config)
+		},
+	}
+	cmd.Flags().StringVarP(&engine, "engine", "e", "pgsql", "引擎: "+strings.Join(checkEngines(), ", "))
+	cmd.Flags().IntSliceVarP(&ports, "port", "p", nil, "检查本机时要使用的端口, 多个用逗号分隔")
+	cmd.Flags().StringSliceVarP(&dirs, "dir", "d", nil, "检查本机时要使用的目录, 检查所在磁盘的剩余空间, 多个用逗号分隔")
+	cmd.Flags().StringVarP(&config, "config", "c", "", "部署配置文件, 指定时检查配置中的所有节点")
+	cmd.Flags().StringVarP(&mode, "mode", "m", "", "部署方式: pgsql 为 master-slave; redis 为 master-slave, cluster; mariadb 为 replication, galera; mongodb 为 replication, cluster")
+	return cmd
+}
+
+func checkEngines() []string {
+	var engines []string
+	for engine := range deployChecks {
+		engines = append(engines, engine)
+	}
+	sort.Strings(engines)
+	return engines
+}
@@ -0,0 +1,61 @@
+package cmd
+
+import (
+	"dbup/internal/global"
+	"dbup/pkg/dbup"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+// dbup config
+func configCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "config",
+		Short: "部署配置文件的检查和格式转换",
+	}
+	// 装载命令
+	cmd.AddCommand(
+		configValidateCmd(),
+		configConvertCmd(),
+	)
+	return cmd
+}
+
+// dbup config validate
+func configValidateCmd() *cobra.Command {
+	var engine string
+	cmd := &cobra.Command{
+		Use:   "validate <file>",
+		Short: "检查部署配置文件(ini 或 YAML), 一次输出所有问题及其所在的行",
+		Long: `检查 pgsql、redis 主从部署和 mariadb 部署的配置文件, 支持旧的 ini 格式和 YAML 格式(扩展名为 .yaml 或 .yml).
+检查未知的配置项、配置项的类型、主机地址、端口以及从节点的名称、上游和同步设置, 只读取配置文件, 不连接节点.`,
+		Example: `  dbup config validate pgsql-deploy.yaml
+  dbup config validate redis-deploy.ini --engine redis`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cmd.SilenceUsage = true
+			return dbup.ConfigValidate(cmd.Context(), args[0], engine)
+		},
+	}
+	cmd.Flags().StringVarP(&engine, "engine", "e", "", "引擎: "+strings.Join(global.DeployEngines, ", ")+", 默认按配置文件推断")
+	return cmd
+}
+
+// dbup config convert
+func configConvertCmd() *cobra.Command {
+	var engine, out string
+	cmd := &cobra.Command{
+		Use:   "convert <file>",
+		Short: "把旧的 ini 部署配置文件转换成 YAML",
+		Example: `  dbup config convert pgsql-deploy.ini -o pgsql-deploy.yaml
+  dbup config convert mariadb-deploy.ini`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return dbup.ConfigConvert(cmd.Context(), args[0], engine, out)
+		},
+	}
+	cmd.Flags().StringVarP(&engine, "engine", "e", "", "引擎: "+strings.Join(global.DeployEngines, ", ")+", 默认按配置文件推断")
+	cmd.Flags().StringVarP(&out, "output-file", "o", "", "写入的 YAML 文件, 默认输出到标准输出")
+	return cmd
+}
@@ -1,7 +1,8 @@
 package backupcmd
 
 import (
-	"dbup/internal/mongodb/service"
+	"dbup/internal/mariadb/service"
+	"dbup/pkg/dbup"
 
 	"github.com/spf13/cobra"
 )
@@ -13,7 +14,7 @@ func mariadbBackupCmd() *cobra.Command {
 		Use:   "mariadb",
 		Short: "mariadb 进行备份",
 		RunE: func(cmd *cobra.Command, args []string) error {
-			return backup.Run()
+			return dbup.MariadbBackup(cmd.Context(), backup)
 		},
 	}
 	cmd.Flags().StringVarP(&backup.Password, "password", "p", "", "密码")
@@ -22,5 +23,9 @@ func mariadbBackupCmd() *cobra.Command {
 	cmd.Flags().StringVarP(&backup.Username, "username", "u", "", "用户名")
 	cmd.Flags().StringVarP(&backup.BackupCmd, "command", "c", "mariadb-dump", "mariadb 备份命令")
 	cmd.Flags().StringVarP(&backup.BackupFile, "backupfile", "f", "", "mariadb 备份目录")
+	cmd.Flags().StringVar(&backup.Type, "type", service.BackupTypeLogical, "备份类型: logical(mariadb-dump 逻辑备份), physical(mariabackup 物理备份)")
+	cmd.Flags().StringVarP(&backup.Dir, "dir", "d", "", "物理备份: mariadb 安装目录, 使用其中的 mariabackup 和配置文件, 默认: /opt/mariadb$PORT")
+	cmd.Flags().StringVar(&backup.BackupDir, "backup-dir", "", "物理备份: 备份链目录")
+	cmd.Flags().BoolVar(&backup.Incremental, "incremental", false, "物理备份: 基于备份链目录中最新的备份做增量备份")
 	return cmd
 }
@@ -0,0 +1,27 @@
+package backupcmd
+
+import (
+	"context"
+	"dbup/internal/global/backuptask"
+	"dbup/internal/mariadb/config"
+	"dbup/internal/mariadb/service"
+	"dbup/pkg/dbup"
+
+	"github.com/spf13/cobra"
+)
+
+// dbup-backup mariadb-backup-task
+func mariadbBackupTaskCmd() *cobra.Command {
+	return backuptask.Command(backuptask.Engine{
+		Kind: config.Kinds,
+		Use:  "mariadb-backup-task",
+		// 定时任务通过 dbup-backup 执行
+		RunCmd:      "mariadb-backup-task run",
+		DefaultName: config.BackupTaskDefaultTaskName,
+		DefaultTime: config.BackupTaskDefaultTaskTime,
+		New:         func() backuptask.Task { return service.NewBackupTask() },
+		Do: func(ctx context.Context, action string, task backuptask.Task) error {
+			return dbup.MariadbBackupTask(ctx, action, task.(*service.BackupTask))
+		},
+	})
+}
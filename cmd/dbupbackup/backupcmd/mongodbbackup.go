@@ -4,6 +4,7 @@ package backupcmd
 
 import (
 	"dbup/internal/mongodb/service"
+	"dbup/pkg/dbup"
 
 	"github.com/spf13/cobra"
 )
@@ -15,7 +16,7 @@ func mongodbBackupCmd() *cobra.Command {
 		Use:   "mongodb",
 		Short: "mongodb 进行备份",
 		RunE: func(cmd *cobra.Command, args []string) error {
-			return backup.Run()
+			return dbup.MongodbBackup(cmd.Context(), backup)
 		},
 	}
 	cmd.Flags().StringVarP(&backup.Password, "password", "p", "", "密码")
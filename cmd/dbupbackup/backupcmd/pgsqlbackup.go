@@ -5,6 +5,7 @@ package backupcmd
 import (
 	"dbup/internal/pgsql"
 	"dbup/internal/pgsql/services"
+	"dbup/pkg/dbup"
 
 	"github.com/spf13/cobra"
 )
@@ -16,8 +17,7 @@ func pgsqlBackupCmd() *cobra.Command {
 		Use:   "pgsql",
 		Short: "pgsql 进行备份",
 		RunE: func(cmd *cobra.Command, args []string) error {
-			pg := pgsql.NewPgsql()
-			return pg.Backup(backup)
+			return dbup.PgsqlBackup(cmd.Context(), backup)
 		},
 	}
 	cmd.Flags().StringVarP(&backup.Username, "username", "u", "pguser", "用户名")
@@ -3,8 +3,8 @@
 package backupcmd
 
 import (
-	"dbup/internal/redis"
 	"dbup/internal/redis/services"
+	"dbup/pkg/dbup"
 
 	"github.com/spf13/cobra"
 )
@@ -16,8 +16,7 @@ func redisBackupCmd() *cobra.Command {
 		Use:   "redis",
 		Short: "redis 进行备份",
 		RunE: func(cmd *cobra.Command, args []string) error {
-			rs := redis.NewRedis()
-			return rs.Backup(backup)
+			return dbup.RedisBackup(cmd.Context(), backup)
 		},
 	}
 	cmd.Flags().StringVarP(&backup.Password, "password", "p", "", "密码")
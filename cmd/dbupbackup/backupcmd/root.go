@@ -4,15 +4,24 @@ package backupcmd
 
 import (
 	"dbup/internal/utils/logger"
+	"dbup/internal/utils/secretfile"
+	"fmt"
+	"os"
 
 	"github.com/spf13/cobra"
 )
 
-var logFile string
+var (
+	logFile      string
+	passwordFile string
+)
 
 var rootCmd = &cobra.Command{
 	Use:   "dbup-backup",
 	Short: "数据库备份工具",
+	PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+		return loadSecrets(cmd)
+	},
 	Run: func(cmd *cobra.Command, args []string) {
 		cmd.Help()
 	},
@@ -21,12 +30,28 @@ var rootCmd = &cobra.Command{
 func Execute() {
 	if err := rootCmd.Execute(); err != nil {
 		logger.Errorf("%v\n", err)
-		//os.Exit(1)
+		os.Exit(1)
+	}
+}
+
+// loadSecrets 从密码文件中读取参数, 备份任务把密码写在密码文件中而不是定时任务里
+func loadSecrets(cmd *cobra.Command) error {
+	if passwordFile == "" {
+		return nil
+	}
+	secrets, err := secretfile.Load(passwordFile)
+	if err != nil {
+		return err
+	}
+	if err := secretfile.SetFlags(cmd.Flags(), secrets); err != nil {
+		return fmt.Errorf("命令 %s: %v", cmd.CommandPath(), err)
 	}
+	return nil
 }
 
 func init() {
 	rootCmd.PersistentFlags().StringVar(&logFile, "log", "", "标准输出写入日志文件")
+	rootCmd.PersistentFlags().StringVar(&passwordFile, "password-file", "", "从 json 文件读取密码等参数, 例: {\"password\": \"xxx\"}")
 
 	// 装载子命令
 	rootCmd.AddCommand(
@@ -35,5 +60,8 @@ func init() {
 		pgsqlBackupTablesCmd(),
 		redisBackupCmd(),
 		mongodbBackupCmd(),
+		mariadbBackupCmd(),
+		mariadbBackupTaskCmd(),
+		mongodbBackupTaskCmd(),
 	)
 }
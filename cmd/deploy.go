@@ -0,0 +1,100 @@
+package cmd
+
+import (
+	"dbup/internal/journal"
+	"dbup/internal/output"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+)
+
+// dbup deploy
+func deployCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "deploy",
+		Short: "集群部署记录",
+	}
+	// 装载命令
+	cmd.AddCommand(
+		deployHistoryCmd(),
+	)
+	return cmd
+}
+
+// dbup deploy history
+func deployHistoryCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "history [id]",
+		Short: "列出集群部署记录及结果, 指定部署记录时列出每个节点上的部署步骤",
+		Args:  cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(args) == 1 {
+				j, err := journal.Load(args[0])
+				if err != nil {
+					return err
+				}
+				if output.IsJSON() {
+					output.Set("deploy", j)
+					return nil
+				}
+				printJournal(j)
+				return nil
+			}
+
+			list, err := journal.List()
+			if err != nil {
+				return err
+			}
+			if output.IsJSON() {
+				output.Set("deploys", list)
+				return nil
+			}
+			printJournals(list)
+			return nil
+		},
+	}
+	return cmd
+}
+
+func printJournals(list []*journal.Journal) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tENGINE\tMODE\tSTATUS\tSTARTED\tFINISHED\tFAILED STEP")
+	for _, j := range list {
+		failed := "-"
+		if s := j.FailedStep(); s != nil && j.Status != journal.StatusSuccess {
+			failed = fmt.Sprintf("%s %s", s.Host, s.Name)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n", j.ID, j.Engine, j.Mode, j.Status, j.StartedAt.Format("2006-01-02 15:04:05"), finishedAt(j), failed)
+	}
+	w.Flush()
+}
+
+func printJournal(j *journal.Journal) {
+	fmt.Printf("部署记录: %s\n", j.ID)
+	fmt.Printf("引擎: %s\n", j.Engine)
+	fmt.Printf("部署方式: %s\n", j.Mode)
+	fmt.Printf("配置文件: %s\n", j.Config)
+	fmt.Printf("状态: %s\n", j.Status)
+	if j.Error != "" {
+		fmt.Printf("错误: %s\n", j.Error)
+	}
+	fmt.Printf("继续次数: %d\n", j.Resumes)
+	fmt.Printf("开始时间: %s\n", j.StartedAt.Format("2006-01-02 15:04:05"))
+	fmt.Printf("结束时间: %s\n", finishedAt(j))
+	fmt.Println("部署步骤:")
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "  HOST\tSTEP\tSTATUS\tTIME\tERROR")
+	for _, s := range j.Steps {
+		fmt.Fprintf(w, "  %s\t%s\t%s\t%s\t%s\n", s.Host, s.Name, s.Status, s.At.Format("2006-01-02 15:04:05"), dash(s.Error))
+	}
+	w.Flush()
+}
+
+func finishedAt(j *journal.Journal) string {
+	if j.FinishedAt.IsZero() {
+		return "-"
+	}
+	return j.FinishedAt.Format("2006-01-02 15:04:05")
+}
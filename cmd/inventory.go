@@ -0,0 +1,267 @@
+package cmd
+
+import (
+	"dbup/internal/environment"
+	"dbup/internal/global"
+	"dbup/internal/inventory"
+	"dbup/internal/output"
+	"dbup/internal/utils/command"
+	"dbup/internal/utils/logger"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+)
+
+// localHost 本机实例在列表中显示的主机名
+const localHost = "localhost"
+
+// inventoryOptions 查看实例清单的参数
+type inventoryOptions struct {
+	hosts []string
+	all   bool
+	ssh   global.SSHConfig
+}
+
+func (o *inventoryOptions) addFlags(cmd *cobra.Command) {
+	cmd.Flags().StringSliceVar(&o.hosts, "host", nil, "通过 ssh 汇总远程机器上的实例, 多个以逗号分隔")
+	cmd.Flags().BoolVar(&o.all, "all", false, "汇总本机以及本机部署过的所有集群节点上的实例")
+	cmd.Flags().StringVar(&o.ssh.Username, "ssh-user", "root", "ssh 用户")
+	cmd.Flags().IntVar(&o.ssh.Port, "ssh-port", 22, "ssh 端口")
+	cmd.Flags().StringVar(&o.ssh.Password, "ssh-password", "", "ssh 密码, 建议通过 --password-file 传递")
+	cmd.Flags().StringVar(&o.ssh.KeyFile, "ssh-keyfile", "", "ssh 私钥, 不指定密码和私钥时使用 ~/.ssh/id_rsa")
+	cmd.Flags().StringVar(&o.ssh.HostKeyCheck, "ssh-host-key-check", "", "主机密钥校验策略: strict(默认), tofu, insecure")
+	cmd.Flags().StringVar(&o.ssh.KnownHosts, "ssh-known-hosts", "", "known_hosts 文件")
+	cmd.Flags().Var(&o.ssh.ProxyJump, "ssh-proxy-jump", "跳板机, 多个以逗号分隔: [user[:password]@]host[:port][?keyfile=/path/to/key]")
+	cmd.Flags().StringVar(&o.ssh.Auth, "ssh-auth", "", "认证方式及尝试顺序, 逗号分隔: agent, publickey, password")
+	cmd.Flags().StringVar(&o.ssh.PassphraseFile, "ssh-passphrase-file", "", "私钥密码文件")
+}
+
+// inventoryResult 汇总结果
+type inventoryResult struct {
+	Instances []inventory.Instance `json:"instances"`
+	Clusters  []inventory.Cluster  `json:"clusters"`
+}
+
+// gather 汇总本机和远程机器上的实例, 并根据本机记录的集群成员填写实例所属集群
+func (o *inventoryOptions) gather() (*inventoryResult, error) {
+	local, err := inventory.LoadDefault()
+	if err != nil {
+		return nil, err
+	}
+	result := &inventoryResult{Clusters: local.Clusters}
+
+	hosts := o.hosts
+	if o.all {
+		for _, c := range local.Clusters {
+			for _, m := range c.Members {
+				hosts = append(hosts, m.Host)
+			}
+		}
+	}
+	if len(o.hosts) == 0 {
+		for _, inst := range local.Instances {
+			inst.Host = localHost
+			result.Instances = append(result.Instances, inst)
+		}
+	}
+
+	seen := make(map[string]bool)
+	for _, host := range hosts {
+		if seen[host] {
+			continue
+		}
+		seen[host] = true
+		inv, err := o.remote(host)
+		if err != nil {
+			return nil, err
+		}
+		for _, inst := range inv.Instances {
+			inst.Cluster = local.ClusterOf(inst.Host, inst.Port)
+			result.Instances = append(result.Instances, inst)
+		}
+	}
+	return result, nil
+}
+
+// remote 通过 ssh 读取远程机器上的清单
+func (o *inventoryOptions) remote(host string) (*inventory.Inventory, error) {
+	cfg := o.ssh
+	cfg.Host = host
+	if err := cfg.Validator(); err != nil {
+		return nil, err
+	}
+
+	logger.Infof("读取机器 %s 上的实例清单\n", host)
+	var conn *command.Connection
+	var err error
+	if cfg.Password != "" {
+		conn, err = command.NewConnection(host, cfg.Username, cfg.Password, cfg.Port, 30, cfg.SSHOptions())
+	} else {
+		if cfg.KeyFile == "" {
+			cfg.KeyFile = filepath.Join(environment.GlobalEnv().HomePath, ".ssh", "id_rsa")
+		}
+		conn, err = command.NewConnectionUseKeyFile(host, cfg.Username, cfg.KeyFile, cfg.Port, 30, cfg.SSHOptions())
+	}
+	if err != nil {
+		return nil, output.Errorf(output.CodeSSH, "在机器: %s 上, 建立ssh连接失败: %v", host, err)
+	}
+	defer conn.Client.Close()
+	return inventory.Gather(conn)
+}
+
+// dbup inventory
+func inventoryCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "inventory",
+		Short: "实例清单, 查看由 dbup 安装的实例和部署的集群",
+	}
+	// 装载命令
+	cmd.AddCommand(
+		inventoryListCmd(),
+		inventoryShowCmd(),
+	)
+	return cmd
+}
+
+// dbup inventory list
+func inventoryListCmd() *cobra.Command {
+	var o inventoryOptions
+	cmd := &cobra.Command{
+		Use:   "list",
+		Short: "列出实例和集群",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			result, err := o.gather()
+			if err != nil {
+				return err
+			}
+			if output.IsJSON() {
+				output.Set("instances", result.Instances)
+				output.Set("clusters", result.Clusters)
+				return nil
+			}
+			printInstances(result.Instances)
+			if len(result.Clusters) > 0 {
+				fmt.Println()
+				printClusters(result.Clusters)
+			}
+			return nil
+		},
+	}
+	o.addFlags(cmd)
+	return cmd
+}
+
+// dbup inventory show
+func inventoryShowCmd() *cobra.Command {
+	var o inventoryOptions
+	cmd := &cobra.Command{
+		Use:   "show <name>",
+		Short: "查看实例或集群的详细信息, 实例名称例如 pgsql5432, 集群名称例如 pgsql-10.0.0.1:5432",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			result, err := o.gather()
+			if err != nil {
+				return err
+			}
+			var found []interface{}
+			for _, c := range result.Clusters {
+				if c.Name == args[0] {
+					found = append(found, c)
+					output.Append("clusters", c)
+				}
+			}
+			for _, inst := range result.Instances {
+				if inst.Name() == args[0] {
+					found = append(found, inst)
+					output.Append("instances", inst)
+				}
+			}
+			if len(found) == 0 {
+				return output.Errorf(output.CodeNotFound, "实例清单中没有 %s", args[0])
+			}
+			if output.IsJSON() {
+				return nil
+			}
+
+			for n, v := range found {
+				if n > 0 {
+					fmt.Println()
+				}
+				switch v := v.(type) {
+				case inventory.Cluster:
+					printCluster(v)
+				case inventory.Instance:
+					printInstance(v)
+				}
+			}
+			return nil
+		},
+	}
+	o.addFlags(cmd)
+	return cmd
+}
+
+func printInstances(instances []inventory.Instance) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "HOST\tENGINE\tVERSION\tPORT\tROLE\tCLUSTER\tSERVICE\tDIR")
+	for _, i := range instances {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%s\t%s\t%s\t%s\n", i.Host, i.Engine, i.Version, i.Port, dash(i.Role), dash(i.Cluster), i.Service, i.Dir)
+	}
+	w.Flush()
+}
+
+func printClusters(clusters []inventory.Cluster) {
+	sort.Slice(clusters, func(a, b int) bool { return clusters[a].Name < clusters[b].Name })
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "CLUSTER\tENGINE\tMODE\tMEMBERS\tDEPLOYED")
+	for _, c := range clusters {
+		var members []string
+		for _, m := range c.Members {
+			members = append(members, fmt.Sprintf("%s:%d", m.Host, m.Port))
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", c.Name, c.Engine, c.Mode, strings.Join(members, ","), c.DeployedAt.Format("2006-01-02 15:04:05"))
+	}
+	w.Flush()
+}
+
+func printInstance(i inventory.Instance) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "名称:\t%s\n", i.Name())
+	fmt.Fprintf(w, "主机:\t%s\n", i.Host)
+	fmt.Fprintf(w, "引擎:\t%s\n", i.Engine)
+	fmt.Fprintf(w, "版本:\t%s\n", i.Version)
+	fmt.Fprintf(w, "端口:\t%d\n", i.Port)
+	fmt.Fprintf(w, "角色:\t%s\n", dash(i.Role))
+	fmt.Fprintf(w, "所属集群:\t%s\n", dash(i.Cluster))
+	fmt.Fprintf(w, "安装目录:\t%s\n", i.Dir)
+	fmt.Fprintf(w, "数据目录:\t%s\n", dash(i.DataDir))
+	fmt.Fprintf(w, "启动文件:\t%s\n", i.Service)
+	fmt.Fprintf(w, "安装时间:\t%s\n", i.InstalledAt.Format("2006-01-02 15:04:05"))
+	w.Flush()
+}
+
+func printCluster(c inventory.Cluster) {
+	fmt.Printf("集群名称: %s\n", c.Name)
+	fmt.Printf("引擎: %s\n", c.Engine)
+	fmt.Printf("部署方式: %s\n", c.Mode)
+	fmt.Printf("部署时间: %s\n", c.DeployedAt.Format("2006-01-02 15:04:05"))
+	fmt.Println("集群成员:")
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "  HOST\tPORT\tROLE\tDIR")
+	for _, m := range c.Members {
+		fmt.Fprintf(w, "  %s\t%d\t%s\t%s\n", m.Host, m.Port, dash(m.Role), dash(m.Dir))
+	}
+	w.Flush()
+}
+
+func dash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
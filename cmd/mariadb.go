@@ -3,13 +3,11 @@
 package cmd
 
 import (
-	"dbup/internal/environment"
 	"dbup/internal/mariadb/config"
 	"dbup/internal/mariadb/service"
 	"dbup/internal/utils"
-	"dbup/internal/utils/logger"
+	"dbup/pkg/dbup"
 	"fmt"
-	"os"
 	"strings"
 
 	"github.com/spf13/cobra"
@@ -31,6 +29,8 @@ func mariadbCmd() *cobra.Command {
 		mariadbDeployCmd(),
 		mariadbRemoveDeployCmd(),
 		mariadbBackupCmd(),
+		mariadbRestoreCmd(),
+		mariadbBackupTaskCmd(),
 		mariadbAddSlaveCmd(),
 		mariadbGaleraDeployCmd(),
 		MariadbUPgradeCmd(),
@@ -47,24 +47,8 @@ func mariadbInstallCmd() *cobra.Command {
 	cmd := &cobra.Command{
 		Use:   "install",
 		Short: "mariadb 单机版安装",
-		PreRunE: func(cmd *cobra.Command, args []string) error {
-			return environment.MustRoot()
-		},
 		RunE: func(cmd *cobra.Command, args []string) error {
-			option.Parameter()
-			if err := option.Validator(); err != nil {
-				return err
-			}
-			if err := option.Environment(); err != nil {
-				return err
-			}
-
-			if onlyCheck {
-				return nil
-			}
-
-			rs := service.NewMariaDBInstall(&option)
-			return rs.Run()
+			return dbup.MariadbInstall(cmd.Context(), option, onlyCheck)
 		},
 	}
 	cmd.Flags().StringVar(&option.SystemUser, "system-user", config.DefaultMariaDBSystemUser, "mariadb安装的操作系统用户")
@@ -72,6 +56,7 @@ func mariadbInstallCmd() *cobra.Command {
 	cmd.Flags().StringVar(&option.Repluser, "repluser", "", "指定 mariadb 主从复制用户,初始化单实例无需指定")
 	cmd.Flags().StringVar(&option.ReplPassword, "replpassword", "", fmt.Sprintf("指定 mariadb 主从复制用户密码, 建议使用: %s, 初始化单实例无需指定", utils.GeneratePasswd(16)))
 	cmd.Flags().IntVarP(&option.Port, "port", "P", 0, "mariadb 数据库监听端口")
+	cmd.Flags().StringVar(&option.Version, "version", "", fmt.Sprintf("mariadb 版本, 支持 %s, 只指定大版本时使用该大版本的默认版本, 默认: %s", strings.Join(config.SupportedVersions, ", "), config.DefaultMariaDBVersion))
 	cmd.Flags().StringVarP(&option.Dir, "dir", "d", "", "mariadb安装目录, 默认: /opt/mariadb$PORT")
 	cmd.Flags().StringVarP(&option.Password, "password", "p", "", fmt.Sprintf("指定 mariadb root用户密码, 建议使用: %s", utils.GeneratePasswd(16)))
 	cmd.Flags().StringVarP(&option.Memory, "memory", "m", "128M", "内存")
@@ -79,8 +64,11 @@ func mariadbInstallCmd() *cobra.Command {
 	cmd.Flags().StringVarP(&option.OwnerIP, "owner-ip", "o", "", "当机器上有多个IP时, 指定以哪个IP创建实例")
 	cmd.Flags().StringVarP(&option.TxIsolation, "transaction_isolation", "i", "RC", "事务隔离级别(可选择 RR 或 RC)")
 	cmd.Flags().StringVar(&option.ResourceLimit, "resource-limit", "", "资源限制清单, 通过执行 systemctl set-property 实现. 例: --resource-limit='MemoryLimit=512M CPUShares=500'")
+	cmd.Flags().BoolVar(&option.Tune, "tune", false, "安装时按 mariadb 的内置配置调优操作系统(sysctl, limits.d, LimitNOFILE), 可以用 dbup tune revert 恢复")
 	cmd.Flags().StringVar(&option.Backupuser, "bakuser", "", "指定备份数据的用户名，添加从库时使用")
 	cmd.Flags().StringVar(&option.BackupPassword, "bakpassword", "", "指定备份数据的用户密码，添加从库时使用")
+	cmd.Flags().StringVar(&option.SeedBackup, "seed-backup", "", "用本机上的 mariabackup 物理备份初始化从库数据, 代替从主库导出, 需要同时指定 --join")
+	cmd.Flags().StringVar(&option.SeedRootPassword, "seed-root-password", "", "--seed-backup 备份所在主库的 root 密码, 恢复后从库使用这个密码, 默认与 --password 相同")
 	cmd.Flags().StringVar(&option.Wsrepclusteraddress, "cluster_address", "", "galera 集群所有节点ip, 例: ip1,ip2,ip3")
 	cmd.Flags().BoolVarP(&option.Galera, "galera", "", false, "安装的实例是否为 galera 实例, 默认为否")
 	cmd.Flags().BoolVarP(&option.Onenode, "onenode", "", false, "安装的实例是否为 galera 第一个实例, 默认为否")
@@ -100,27 +88,8 @@ func mariadbUNInstallCmd() *cobra.Command {
 	cmd := &cobra.Command{
 		Use:   "uninstall",
 		Short: "mariadb 单机版卸载",
-		PreRunE: func(cmd *cobra.Command, args []string) error {
-			return environment.MustRoot()
-		},
 		RunE: func(cmd *cobra.Command, args []string) error {
-			if uninst.Port == 0 || uninst.BasePath == "" {
-				return fmt.Errorf("必须手动指定 --port and --dir 参数")
-			}
-
-			if !yes {
-				var s string
-				logger.Successf("端口: %d\n", uninst.Port)
-				logger.Successf("数据路径: %s\n", uninst.BasePath)
-				logger.Successf("是否确认卸载[y|n]:")
-				if _, err := fmt.Scanln(&s); err != nil {
-					return err
-				}
-				if strings.ToUpper(s) != "Y" && strings.ToUpper(s) != "YES" {
-					os.Exit(0)
-				}
-			}
-			return uninst.Uninstall()
+			return dbup.MariadbUninstall(cmd.Context(), &uninst, yes)
 		},
 	}
 	cmd.Flags().IntVarP(&uninst.Port, "port", "P", 0, "MariaDB 数据库监听端口")
@@ -139,8 +108,7 @@ func mariadbDeployCmd() *cobra.Command {
 			if config == "" {
 				return fmt.Errorf("请指定部署配置文件")
 			}
-			rs := service.NewmariadbDeploy()
-			return rs.Run(config)
+			return dbup.MariadbDeploy(cmd.Context(), config)
 		},
 	}
 	cmd.Flags().StringVarP(&config, "config", "c", "", "安装配置文件")
@@ -150,6 +118,7 @@ func mariadbDeployCmd() *cobra.Command {
 // dbup mariadb galera-deploy
 func mariadbGaleraDeployCmd() *cobra.Command {
 	var config string
+	var dryRun bool
 	cmd := &cobra.Command{
 		Use:   "galera",
 		Short: "mariadb Galera 集群部署",
@@ -157,11 +126,16 @@ func mariadbGaleraDeployCmd() *cobra.Command {
 			if config == "" {
 				return fmt.Errorf("请指定部署配置文件")
 			}
-			rs := service.NewGaleraDeploy()
-			return rs.Run(config)
+			if dryRun {
+				// 存在冲突时只输出错误, 不打印用法
+				cmd.SilenceUsage = true
+				return dbup.MariadbGaleraDeployPlan(cmd.Context(), config)
+			}
+			return dbup.MariadbGaleraDeploy(cmd.Context(), config)
 		},
 	}
 	cmd.Flags().StringVarP(&config, "config", "c", "", "安装配置文件")
+	cmd.Flags().BoolVar(&dryRun, "plan", false, "只检查配置并只读连接各节点, 输出部署计划和冲突, 不做任何修改")
 	return cmd
 }
 
@@ -176,8 +150,7 @@ func mariadbRemoveDeployCmd() *cobra.Command {
 			if config == "" {
 				return fmt.Errorf("请指定部署配置文件")
 			}
-			rs := service.NewmariadbDeploy()
-			return rs.RemoveCluster(config, yes)
+			return dbup.MariadbRemoveDeploy(cmd.Context(), config, yes)
 		},
 	}
 	cmd.Flags().StringVarP(&config, "config", "c", "", "安装配置文件")
@@ -192,40 +165,7 @@ func mariadbAddSlaveCmd() *cobra.Command {
 		Use:   "add-slave",
 		Short: "mariadb 添加从库",
 		RunE: func(cmd *cobra.Command, args []string) error {
-			if sshOption.Address == "" {
-				return fmt.Errorf("请指定要创建从库的远程机器IP地址")
-			}
-
-			if option.Port == 0 {
-				return fmt.Errorf("请指定要创建从库的端口号")
-			}
-
-			if option.Join == "" {
-				return fmt.Errorf("请指定要加入的主库实例信息:<IP:PORT>")
-			}
-
-			if option.Password == "" {
-				return fmt.Errorf("请指定 Mariadb 的超级管理员 root 用户密码")
-			}
-
-			if option.Repluser == "" {
-				return fmt.Errorf("请指定 Mariadb 复制用户名")
-			}
-
-			if option.ReplPassword == "" {
-				return fmt.Errorf("请指定 Mariadb 复制用户密码")
-			}
-
-			if option.Backupuser == "" {
-				return fmt.Errorf("请指定 Mariadb 备份数据的用户名")
-			}
-
-			if option.BackupPassword == "" {
-				return fmt.Errorf("请指定 Mariadb 备份数据的用户密码")
-			}
-
-			mm := service.NewMariaDBManager()
-			return mm.AddSlaveNode(sshOption, option)
+			return dbup.MariadbAddSlave(cmd.Context(), sshOption, option)
 		},
 	}
 
@@ -233,19 +173,28 @@ func mariadbAddSlaveCmd() *cobra.Command {
 	cmd.Flags().StringVar(&sshOption.User, "ssh-username", "", "ssh 用户名")
 	cmd.Flags().StringVar(&sshOption.Password, "ssh-password", "", "ssh 密码")
 	cmd.Flags().StringVar(&sshOption.KeyFile, "ssh-keyfile", "", "ssh 密钥")
+	cmd.Flags().StringVar(&sshOption.HostKeyCheck, "ssh-host-key-check", "", "ssh 主机密钥校验策略: strict(默认), tofu, insecure")
+	cmd.Flags().StringVar(&sshOption.KnownHosts, "ssh-known-hosts", "", "ssh known_hosts 文件, 默认 ~/.ssh/known_hosts 与 ~/.dbup/known_hosts")
+	cmd.Flags().StringVar(&sshOption.ProxyJump, "ssh-proxy-jump", "", "ssh 跳板机, 多个以逗号分隔, 格式: [user[:password]@]host[:port][?keyfile=/path/to/key]")
+	cmd.Flags().StringVar(&sshOption.Auth, "ssh-auth", "", "ssh 认证方式及尝试顺序, 逗号分隔: agent, publickey, password")
+	cmd.Flags().StringVar(&sshOption.PassphraseFile, "ssh-passphrase-file", "", "ssh 私钥密码文件, 也可以通过环境变量 DBUP_SSH_PASSPHRASE 指定")
 	cmd.Flags().StringVar(&option.SystemUser, "system-user", config.DefaultMariaDBSystemUser, "mariadb安装的操作系统用户")
 	cmd.Flags().StringVar(&option.SystemGroup, "system-group", config.DefaultMariaDBSystemGroup, "mariadb 安装的操作系统用户组")
 	cmd.Flags().StringVarP(&sshOption.Address, "host", "H", "", "新增从节点IP地址, 必填项")
 	cmd.Flags().IntVarP(&option.Port, "port", "P", 0, "新增从节点端口, 必填项")
+	cmd.Flags().StringVar(&option.Version, "version", "", "mariadb 版本, 需要与主库一致, 默认: "+config.DefaultMariaDBVersion)
 	cmd.Flags().StringVarP(&option.Dir, "dir", "d", "", "mariadb 安装目录, 必填项")
 	cmd.Flags().StringVarP(&option.Password, "password", "p", "", "root 密码")
 	cmd.Flags().StringVar(&option.Repluser, "repluser", "", "指定 mariadb 主从复制用户")
 	cmd.Flags().StringVar(&option.ReplPassword, "replpassword", "", "指定 mariadb 主从复制用户密码")
 	cmd.Flags().StringVar(&option.Backupuser, "bakuser", "", "指定备份数据的用户名")
 	cmd.Flags().StringVar(&option.BackupPassword, "bakpassword", "", "指定备份数据的用户密码")
+	cmd.Flags().StringVar(&option.SeedBackup, "seed-backup", "", "远程机器上的 mariabackup 物理备份目录, 用它初始化从库数据, 代替从主库导出")
+	cmd.Flags().StringVar(&option.SeedRootPassword, "seed-root-password", "", "--seed-backup 备份所在主库的 root 密码, 恢复后从库使用这个密码, 默认与 --password 相同")
 	cmd.Flags().StringVarP(&option.Memory, "memory", "m", "1G", "内存")
 	cmd.Flags().StringVarP(&option.Join, "join", "j", "", "从库同步主库的主库地址<IP:PORT>")
 	cmd.Flags().StringVar(&option.ResourceLimit, "resource-limit", "", "资源限制清单, 通过执行 systemctl set-property 实现. 例: --resource-limit='MemoryLimit=512M CPUShares=500'")
+	cmd.Flags().BoolVar(&option.Tune, "tune", false, "安装时按 mariadb 的内置配置调优操作系统(sysctl, limits.d, LimitNOFILE), 可以用 dbup tune revert 恢复")
 	cmd.Flags().BoolVarP(&option.Yes, "yes", "y", false, "直接安装, 否则需要交互确认")
 	return cmd
 }
@@ -257,7 +206,7 @@ func mariadbBackupCmd() *cobra.Command {
 		Use:   "backup",
 		Short: "mariadb 进行备份",
 		RunE: func(cmd *cobra.Command, args []string) error {
-			return backup.Run()
+			return dbup.MariadbBackup(cmd.Context(), backup)
 		},
 	}
 	cmd.Flags().StringVarP(&backup.Password, "password", "p", "", "密码")
@@ -266,6 +215,45 @@ func mariadbBackupCmd() *cobra.Command {
 	cmd.Flags().StringVarP(&backup.Username, "username", "u", "", "用户名")
 	cmd.Flags().StringVarP(&backup.BackupCmd, "command", "c", "mariadb-dump", "mariadb 备份命令")
 	cmd.Flags().StringVarP(&backup.BackupFile, "backupfile", "f", "", "mariadb 备份目录")
+	mariadbPhysicalBackupFlags(cmd, backup)
+	return cmd
+}
+
+// mariadbPhysicalBackupFlags mariabackup 物理备份参数
+func mariadbPhysicalBackupFlags(cmd *cobra.Command, backup *service.Backup) {
+	cmd.Flags().StringVar(&backup.Type, "type", service.BackupTypeLogical, "备份类型: logical(mariadb-dump 逻辑备份), physical(mariabackup 物理备份)")
+	cmd.Flags().StringVarP(&backup.Dir, "dir", "d", "", "物理备份: mariadb 安装目录, 使用其中的 mariabackup 和配置文件, 默认: /opt/mariadb$PORT")
+	cmd.Flags().StringVar(&backup.Mariabackup, "mariabackup", "", "物理备份: mariabackup 命令, 默认使用安装目录中的")
+	cmd.Flags().StringVar(&backup.BackupDir, "backup-dir", "", "物理备份: 备份链目录, 每次备份在其中生成 full_* 或 incremental_* 目录; prepare 时也可以指定链中的一个备份")
+	cmd.Flags().BoolVar(&backup.Incremental, "incremental", false, "物理备份: 基于备份链目录中最新的备份做增量备份")
+	cmd.Flags().BoolVar(&backup.Prepare, "prepare", false, "物理备份: 不备份, 把 --backup-dir 的备份链 prepare 到 --prepare-dir, 生成可以直接恢复的目录")
+	cmd.Flags().StringVar(&backup.PrepareDir, "prepare-dir", "", "物理备份: prepare 的目标目录, 必须不存在")
+}
+
+// dbup mariadb restore
+func mariadbRestoreCmd() *cobra.Command {
+	var restore = service.NewRestore()
+	cmd := &cobra.Command{
+		Use:   "restore",
+		Short: "mariadb 从逻辑备份恢复",
+		Long:  "把 mariadb backup 或 mariadb-dump --all-databases 生成的备份文件(.gz 结尾的先解压)导入到新安装的或已有的实例. 导入时关闭会话的 binlog, 导入后检查所有表. 指定 --physical 时从 mariadb backup --type physical 的备份恢复",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return dbup.MariadbRestore(cmd.Context(), restore)
+		},
+	}
+	cmd.Flags().StringVarP(&restore.File, "file", "f", "", "mariadb 备份文件")
+	cmd.Flags().StringVarP(&restore.Databases, "databases", "D", "", "只恢复的库, 用逗号分割, 默认恢复备份中的全部库")
+	cmd.Flags().StringVarP(&restore.Password, "password", "p", "", "密码")
+	cmd.Flags().StringVarP(&restore.Host, "host", "H", "127.0.0.1", "mariadb 地址")
+	cmd.Flags().IntVarP(&restore.Port, "port", "P", 3306, "mariadb 数据库监听端口")
+	cmd.Flags().StringVarP(&restore.Username, "username", "u", "root", "用户名")
+	cmd.Flags().StringVarP(&restore.RestoreCmd, "command", "c", "mariadb", "mariadb 客户端命令")
+	cmd.Flags().BoolVar(&restore.NoCheck, "no-check", false, "恢复后不检查表")
+	cmd.Flags().BoolVarP(&restore.Yes, "yes", "y", false, "直接恢复, 否则需要交互确认")
+	cmd.Flags().BoolVar(&restore.Physical, "physical", false, "从 mariabackup 物理备份恢复: 应用增量备份链后替换实例的数据目录, 原数据目录改名保留")
+	cmd.Flags().StringVar(&restore.BackupDir, "backup-dir", "", "物理备份: 备份链目录(使用最新的备份), 链中的一个备份或已经 prepare 的目录")
+	cmd.Flags().StringVarP(&restore.Dir, "dir", "d", "", "物理备份: mariadb 安装目录, 默认: /opt/mariadb$PORT")
+	cmd.Flags().StringVar(&restore.Mariabackup, "mariabackup", "", "物理备份: mariabackup 命令, 默认使用安装目录中的")
 	return cmd
 }
 
@@ -297,7 +285,7 @@ func MariadbUPgradeCmd() *cobra.Command {
 		Use:   "upgrade",
 		Short: "mariadb 升级",
 		RunE: func(cmd *cobra.Command, args []string) error {
-			return upgrade.Run()
+			return dbup.MariadbUpgrade(cmd.Context(), upgrade)
 		},
 	}
 	cmd.Flags().StringVarP(&upgrade.Password, "password", "p", "", "旧版本实例密码")
@@ -306,6 +294,7 @@ func MariadbUPgradeCmd() *cobra.Command {
 	cmd.Flags().StringVarP(&upgrade.Username, "username", "u", "", "旧版本实例管理用户名")
 	cmd.Flags().StringVarP(&upgrade.SourceDir, "old", "o", "", "旧版本 mariadb 安装主目录")
 	cmd.Flags().StringVarP(&upgrade.EmoloyDir, "new", "n", "/tmp", "新版本 mariadb 临时解压目录")
+	cmd.Flags().StringVar(&upgrade.Version, "version", "", "使用安装包升级时升级到的 mariadb 版本, 默认: "+config.DefaultMariaDBVersion)
 	cmd.Flags().StringVarP(&upgrade.TxIsolation, "transaction_isolation", "i", "RC", "事务隔离级别(可选择 RR 或 RC)")
 	cmd.Flags().BoolVarP(&upgrade.NoBackup, "nobak", "", false, "不进行基础文件备份")
 	cmd.Flags().BoolVarP(&upgrade.Yes, "yes", "y", false, "直接安装, 否则需要交互确认")
@@ -12,8 +12,9 @@ import (
 	"dbup/internal/mongodb/service"
 	"dbup/internal/utils"
 	"dbup/internal/utils/logger"
+	"dbup/internal/utils/prompt"
+	"dbup/pkg/dbup"
 	"fmt"
-	"os"
 	"strings"
 
 	"github.com/go-playground/validator"
@@ -37,6 +38,7 @@ func mongodbCmd() *cobra.Command {
 		mongodbDeployCmd(),
 		mongodbRemoveDeployCmd(),
 		mongodbBackupCmd(),
+		mongodbBackupTaskCmd(),
 		mongodbClusterDeployCmd(),
 		mongoSinstallCmd(),
 		mongoSUNInstallCmd(),
@@ -53,44 +55,14 @@ func mongodbInstallCmd() *cobra.Command {
 	cmd := &cobra.Command{
 		Use:   "install",
 		Short: "mongodb 单机版安装",
-		PreRunE: func(cmd *cobra.Command, args []string) error {
-			return environment.MustRoot()
-		},
 		RunE: func(cmd *cobra.Command, args []string) error {
-			if option.Username == "" || option.Password == "" {
-				return fmt.Errorf("请指定 MongoDB 的超级管理员用户名和密码")
-			}
-			if err := utils.CheckPasswordLever(option.Password); err != nil {
-				return err
-			}
-			if err := option.CheckSpecialChar(); err != nil {
-				return err
-			}
-
-			option.InitArgs()
-			validate := validator.New()
-			if err := validate.RegisterValidation("ipPort", config.ValidateIPPort); err != nil {
-				return err
-			}
-			if err := validate.Struct(option); err != nil {
-				return err
-			}
-
-			rs := service.NewMongoDBInstall(&option)
-			if err := rs.CheckEnv(); err != nil {
-				return err
-			}
-
-			if onlyCheck {
-				return nil
-			}
-
-			return rs.Run()
+			return dbup.MongodbInstall(cmd.Context(), option, onlyCheck)
 		},
 	}
 	cmd.Flags().StringVar(&option.SystemUser, "system-user", config.DefaultMongoDBSystemUser, "mongodb安装的操作系统用户")
 	cmd.Flags().StringVar(&option.SystemGroup, "system-group", config.DefaultMongoDBSystemGroup, "mongodb安装的操作系统用户组")
 	cmd.Flags().IntVarP(&option.Port, "port", "P", 0, "mongodb 数据库监听端口")
+	cmd.Flags().StringVar(&option.Version, "version", "", fmt.Sprintf("mongodb 版本, 支持 %s, 只指定大版本时使用该大版本的默认版本, 默认: %s", strings.Join(config.SupportedVersions, ", "), config.DefaultMongoDBVersion))
 	cmd.Flags().StringVarP(&option.Dir, "dir", "d", "", "mongodb安装目录, 默认: /opt/mongodb$PORT")
 	cmd.Flags().StringVarP(&option.Username, "username", "u", "", "mongodb用户名")
 	cmd.Flags().StringVarP(&option.Password, "password", "p", "", fmt.Sprintf("指定 MongoDB 用户密码, 建议使用: %s", utils.GeneratePasswd(16)))
@@ -102,6 +74,7 @@ func mongodbInstallCmd() *cobra.Command {
 	cmd.Flags().StringVarP(&option.Join, "join", "j", "", "做为从库, 要加入的副本集群的任意一个节点<IP:PORT>")
 	cmd.Flags().StringVarP(&option.Owner, "owner", "o", "", "当机器上有多个IP时, 指定以哪个IP创建实例")
 	cmd.Flags().StringVar(&option.ResourceLimit, "resource-limit", "", "资源限制清单, 通过执行 systemctl set-property 实现. 例: --resource-limit='MemoryLimit=512M CPUShares=500'")
+	cmd.Flags().BoolVar(&option.Tune, "tune", false, "安装时按 mongodb 的内置配置调优操作系统(sysctl, 透明大页, limits.d, LimitNOFILE), 可以用 dbup tune revert 恢复")
 	cmd.Flags().BoolVarP(&option.Yes, "yes", "y", false, "直接安装, 否则需要交互确认")
 	cmd.Flags().BoolVarP(&option.NoRollback, "no-rollback", "n", false, "安装失败不回滚")
 	cmd.Flags().BoolVar(&onlyCheck, "only-check", false, "只检查配置和环境, 不进行实际安装操作")
@@ -128,6 +101,9 @@ func mongoSinstallCmd() *cobra.Command {
 			if err := option.CheckConfigDB(); err != nil {
 				return err
 			}
+			if err := option.CheckVersion(); err != nil {
+				return err
+			}
 			validate := validator.New()
 			if err := validate.RegisterValidation("ipPort", config.ValidateIPPort); err != nil {
 				return err
@@ -152,6 +128,7 @@ func mongoSinstallCmd() *cobra.Command {
 	cmd.Flags().StringVar(&option.SystemUser, "system-user", config.DefaultMongoDBSystemUser, "mongos安装的操作系统用户")
 	cmd.Flags().StringVar(&option.SystemGroup, "system-group", config.DefaultMongoDBSystemGroup, "mongos安装的操作系统用户组")
 	cmd.Flags().IntVarP(&option.Port, "port", "P", 0, "mongos 数据库监听端口")
+	cmd.Flags().StringVar(&option.Version, "version", "", "mongodb 版本, 需要与集群一致, 默认: "+config.DefaultMongoDBVersion)
 	cmd.Flags().StringVarP(&option.ConfigDB, "ConfigDB", "C", "", "需要指定Config集群地址: 示例: 副本集名称/IP:PORT,IP:PORT,IP:PORT ")
 	cmd.Flags().StringVarP(&option.Dir, "dir", "d", "", "mongos安装目录, 默认: /opt/mongodb$PORT")
 	cmd.Flags().StringVarP(&option.Username, "username", "u", "", "mongodb 用户名")
@@ -160,6 +137,7 @@ func mongoSinstallCmd() *cobra.Command {
 	cmd.Flags().StringVarP(&option.BindIP, "bind-ip", "b", "", "mongodb 数据库监听地址")
 	cmd.Flags().StringVarP(&option.Owner, "owner", "o", "", "当机器上有多个IP时, 指定以哪个IP创建实例")
 	cmd.Flags().StringVar(&option.ResourceLimit, "resource-limit", "", "资源限制清单, 通过执行 systemctl set-property 实现. 例: --resource-limit='MemoryLimit=512M CPUShares=500'")
+	cmd.Flags().BoolVar(&option.Tune, "tune", false, "安装时按 mongodb 的内置配置调优操作系统(sysctl, 透明大页, limits.d, LimitNOFILE), 可以用 dbup tune revert 恢复")
 	cmd.Flags().BoolVarP(&option.Yes, "yes", "y", false, "直接安装, 否则需要交互确认")
 	cmd.Flags().BoolVarP(&option.NoRollback, "no-rollback", "n", false, "安装失败不回滚")
 	cmd.Flags().BoolVar(&onlyCheck, "only-check", false, "只检查配置和环境, 不进行实际安装操作")
@@ -182,16 +160,11 @@ func mongoSUNInstallCmd() *cobra.Command {
 			}
 
 			if !yes {
-				var s string
 				logger.Successf("端口: %d\n", uninst.Port)
 				logger.Successf("数据路径: %s\n", uninst.BasePath)
-				logger.Successf("是否确认卸载[y|n]:")
-				if _, err := fmt.Scanln(&s); err != nil {
+				if err := prompt.Confirm("是否确认卸载"); err != nil {
 					return err
 				}
-				if strings.ToUpper(s) != "Y" && strings.ToUpper(s) != "YES" {
-					os.Exit(0)
-				}
 			}
 			return uninst.MSuninstall()
 		},
@@ -211,33 +184,7 @@ func mongodbAddSlaveCmd() *cobra.Command {
 		Use:   "add-slave",
 		Short: "mongodb 添加从库",
 		RunE: func(cmd *cobra.Command, args []string) error {
-			if sshOption.Host == "" {
-				return fmt.Errorf("请指定要创建从库的远程机器IP地址")
-			}
-
-			if option.Port == 0 {
-				return fmt.Errorf("请指定要创建从库的端口号")
-			}
-
-			if option.Join == "" {
-				return fmt.Errorf("请指定要加入的主库实例信息:<IP:PORT>")
-			}
-
-			if option.Username == "" || option.Password == "" {
-				return fmt.Errorf("请指定 MongoDB 的超级管理员用户名和密码")
-			}
-
-			option.InitArgs()
-			validate := validator.New()
-			if err := validate.RegisterValidation("ipPort", config.ValidateIPPort); err != nil {
-				return err
-			}
-			if err := validate.Struct(option); err != nil {
-				return err
-			}
-
-			mm := service.NewMongoDBManager()
-			return mm.AddSlaveNode(sshOption, option)
+			return dbup.MongodbAddSlave(cmd.Context(), sshOption, option)
 		},
 	}
 	cmd.Flags().StringVar(&sshOption.Host, "host", "", "新节点IP")
@@ -245,9 +192,15 @@ func mongodbAddSlaveCmd() *cobra.Command {
 	cmd.Flags().StringVar(&sshOption.Username, "ssh-username", "", "ssh 用户名")
 	cmd.Flags().StringVar(&sshOption.Password, "ssh-password", "", "ssh 密码")
 	cmd.Flags().StringVar(&sshOption.KeyFile, "ssh-keyfile", "", "ssh 密钥")
+	cmd.Flags().StringVar(&sshOption.HostKeyCheck, "ssh-host-key-check", "", "ssh 主机密钥校验策略: strict(默认), tofu, insecure")
+	cmd.Flags().StringVar(&sshOption.KnownHosts, "ssh-known-hosts", "", "ssh known_hosts 文件, 默认 ~/.ssh/known_hosts 与 ~/.dbup/known_hosts")
+	cmd.Flags().Var(&sshOption.ProxyJump, "ssh-proxy-jump", "ssh 跳板机, 多个以逗号分隔, 格式: [user[:password]@]host[:port][?keyfile=/path/to/key]")
+	cmd.Flags().StringVar(&sshOption.Auth, "ssh-auth", "", "ssh 认证方式及尝试顺序, 逗号分隔: agent, publickey, password")
+	cmd.Flags().StringVar(&sshOption.PassphraseFile, "ssh-passphrase-file", "", "ssh 私钥密码文件, 也可以通过环境变量 DBUP_SSH_PASSPHRASE 指定")
 	cmd.Flags().StringVar(&option.SystemUser, "system-user", config.DefaultMongoDBSystemUser, "mongodb安装的操作系统用户")
 	cmd.Flags().StringVar(&option.SystemGroup, "system-group", config.DefaultMongoDBSystemGroup, "mongodb安装的操作系统用户组")
 	cmd.Flags().IntVarP(&option.Port, "port", "P", 0, "mongodb 数据库监听端口")
+	cmd.Flags().StringVar(&option.Version, "version", "", "mongodb 版本, 需要与副本集一致, 默认: "+config.DefaultMongoDBVersion)
 	cmd.Flags().StringVarP(&option.Dir, "dir", "d", "", "mongodb安装目录, 默认: /opt/mongodb$PORT")
 	cmd.Flags().StringVarP(&option.Username, "username", "u", "", "mongodb用户名")
 	cmd.Flags().StringVarP(&option.Password, "password", "p", "", "mongodb密码")
@@ -257,6 +210,7 @@ func mongodbAddSlaveCmd() *cobra.Command {
 	cmd.Flags().StringVarP(&option.Join, "join", "j", "", "做为从库, 要加入的副本集群的任意一个节点<IP:PORT>")
 	cmd.Flags().StringVarP(&option.Owner, "owner", "o", "", "当机器上有多个IP时, 指定以哪个IP创建实例")
 	cmd.Flags().StringVar(&option.ResourceLimit, "resource-limit", "", "资源限制清单, 通过执行 systemctl set-property 实现. 例: --resource-limit='MemoryLimit=512M CPUShares=500'")
+	cmd.Flags().BoolVar(&option.Tune, "tune", false, "安装时按 mongodb 的内置配置调优操作系统(sysctl, 透明大页, limits.d, LimitNOFILE), 可以用 dbup tune revert 恢复")
 	cmd.Flags().BoolVarP(&option.Yes, "yes", "y", false, "直接安装, 否则需要交互确认")
 	cmd.Flags().BoolVarP(&option.NoRollback, "no-rollback", "n", false, "安装失败不回滚")
 	cmd.Flags().BoolVar(&onlyCheck, "only-check", false, "只检查配置和环境, 不进行实际安装操作")
@@ -270,27 +224,8 @@ func mongodbUNInstallCmd() *cobra.Command {
 	cmd := &cobra.Command{
 		Use:   "uninstall",
 		Short: "mongodb 单机版卸载",
-		PreRunE: func(cmd *cobra.Command, args []string) error {
-			return environment.MustRoot()
-		},
 		RunE: func(cmd *cobra.Command, args []string) error {
-			if uninst.Port == 0 || uninst.BasePath == "" {
-				return fmt.Errorf("必须手动指定 --port and --dir 参数")
-			}
-
-			if !yes {
-				var s string
-				logger.Successf("端口: %d\n", uninst.Port)
-				logger.Successf("数据路径: %s\n", uninst.BasePath)
-				logger.Successf("是否确认卸载[y|n]:")
-				if _, err := fmt.Scanln(&s); err != nil {
-					return err
-				}
-				if strings.ToUpper(s) != "Y" && strings.ToUpper(s) != "YES" {
-					os.Exit(0)
-				}
-			}
-			return uninst.Uninstall()
+			return dbup.MongodbUninstall(cmd.Context(), &uninst, yes)
 		},
 	}
 	cmd.Flags().IntVarP(&uninst.Port, "port", "P", 0, "MongoDB 数据库监听端口")
@@ -302,18 +237,32 @@ func mongodbUNInstallCmd() *cobra.Command {
 // dbup mongodb cluster-deploy
 func mongodbClusterDeployCmd() *cobra.Command {
 	var config string
+	var dryRun bool
+	var resume string
 	cmd := &cobra.Command{
 		Use:   "cluster-deploy",
 		Short: "mongodb 分片集群部署",
 		RunE: func(cmd *cobra.Command, args []string) error {
+			if resume != "" {
+				if dryRun {
+					return fmt.Errorf("--resume 不能与 --plan 同时使用")
+				}
+				return dbup.MongodbClusterDeployResume(cmd.Context(), resume)
+			}
 			if config == "" {
 				return fmt.Errorf("请指定部署配置文件")
 			}
-			rs := service.NewMongoClusterDeploy()
-			return rs.Run(config)
+			if dryRun {
+				// 存在冲突时只输出错误, 不打印用法
+				cmd.SilenceUsage = true
+				return dbup.MongodbClusterDeployPlan(cmd.Context(), config)
+			}
+			return dbup.MongodbClusterDeploy(cmd.Context(), config)
 		},
 	}
 	cmd.Flags().StringVarP(&config, "config", "c", "", "安装配置文件")
+	cmd.Flags().BoolVar(&dryRun, "plan", false, "只检查配置并只读连接各节点, 输出部署计划和冲突, 不做任何修改")
+	cmd.Flags().StringVar(&resume, "resume", "", "根据部署记录继续失败的部署, 跳过已经完成的步骤, 部署记录由 dbup deploy history 查看")
 	return cmd
 }
 
@@ -328,8 +277,7 @@ func mongodbRemoveClusterCmd() *cobra.Command {
 			if config == "" {
 				return fmt.Errorf("请指定部署配置文件")
 			}
-			rs := service.NewMongoClusterDeploy()
-			return rs.RemoveCluster(config, yes)
+			return dbup.MongodbClusterRemoveDeploy(cmd.Context(), config, yes)
 		},
 	}
 	cmd.Flags().StringVarP(&config, "config", "c", "", "安装配置文件")
@@ -348,8 +296,7 @@ func mongodbDeployCmd() *cobra.Command {
 			if config == "" {
 				return fmt.Errorf("请指定部署配置文件")
 			}
-			rs := service.NewMongoDBDeploy()
-			return rs.Run(config, noRollback)
+			return dbup.MongodbDeploy(cmd.Context(), config, noRollback)
 		},
 	}
 	cmd.Flags().StringVarP(&config, "config", "c", "", "安装配置文件")
@@ -368,8 +315,7 @@ func mongodbRemoveDeployCmd() *cobra.Command {
 			if config == "" {
 				return fmt.Errorf("请指定部署配置文件")
 			}
-			rs := service.NewMongoDBDeploy()
-			return rs.RemoveCluster(config, yes)
+			return dbup.MongodbRemoveDeploy(cmd.Context(), config, yes)
 		},
 	}
 	cmd.Flags().StringVarP(&config, "config", "c", "", "安装配置文件")
@@ -384,7 +330,7 @@ func mongodbBackupCmd() *cobra.Command {
 		Use:   "backup",
 		Short: "mongodb 进行备份",
 		RunE: func(cmd *cobra.Command, args []string) error {
-			return backup.Run()
+			return dbup.MongodbBackup(cmd.Context(), backup)
 		},
 	}
 	cmd.Flags().StringVarP(&backup.Password, "password", "p", "", "密码")
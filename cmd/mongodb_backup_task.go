@@ -0,0 +1,30 @@
+package cmd
+
+import (
+	"context"
+	"dbup/internal/credential"
+	"dbup/internal/global/backuptask"
+	"dbup/internal/mongodb/config"
+	"dbup/internal/mongodb/service"
+	"dbup/pkg/dbup"
+
+	"github.com/spf13/cobra"
+)
+
+// dbup mongodb backup-task
+func mongodbBackupTaskCmd() *cobra.Command {
+	return backuptask.Command(backuptask.Engine{
+		Kind:        config.Kinds,
+		Use:         "backup-task",
+		DefaultName: config.BackupTaskDefaultTaskName,
+		DefaultTime: config.BackupTaskDefaultTaskTime,
+		New:         func() backuptask.Task { return service.NewBackupTask() },
+		Do: func(ctx context.Context, action string, task backuptask.Task) error {
+			return dbup.MongodbBackupTask(ctx, action, task.(*service.BackupTask))
+		},
+		PreRun: func(cmd *cobra.Command, task backuptask.Task) error {
+			port := task.(*service.BackupTask).Backup.Port
+			return defaultCredential(cmd, credential.Name(config.Kinds, port), "username", credential.FieldUsername, "password", credential.FieldPassword)
+		},
+	})
+}
@@ -0,0 +1,236 @@
+package cmd
+
+import (
+	"crypto/ed25519"
+	"dbup/internal/bundle"
+	"dbup/internal/environment"
+	"dbup/internal/global"
+	"dbup/internal/output"
+	"dbup/internal/utils/logger"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+// dbup package
+func packageCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "package",
+		Short: "安装包签名清单管理",
+	}
+	// 装载命令
+	cmd.AddCommand(
+		packageKeygenCmd(),
+		packageSignCmd(),
+		packageVerifyCmd(),
+		packageBuildCmd(),
+		packageInspectCmd(),
+	)
+	return cmd
+}
+
+// 默认的安装包目录: dbup 所在目录的 ../package
+func defaultPackageDir() string {
+	return filepath.Join(environment.GlobalEnv().ProgramPath, global.PackagePath)
+}
+
+// dbup package keygen
+func packageKeygenCmd() *cobra.Command {
+	var out string
+	cmd := &cobra.Command{
+		Use:   "keygen",
+		Short: "生成 Ed25519 签名密钥, 私钥保存到 <out>.key, 公钥保存到 <out>.pub",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			pub, priv, err := global.GenerateSigningKey()
+			if err != nil {
+				return err
+			}
+			if err := ioutil.WriteFile(out+".key", []byte(priv+"\n"), 0600); err != nil {
+				return err
+			}
+			if err := ioutil.WriteFile(out+".pub", []byte(pub+"\n"), 0644); err != nil {
+				return err
+			}
+			output.Set("private_key_file", out+".key")
+			output.Set("public_key_file", out+".pub")
+			logger.Successf("私钥: %s, 公钥: %s\n", out+".key", out+".pub")
+			logger.Infof("将公钥加入安装机器的 %s, 或编译时通过 -ldflags 内置\n", global.PackageKeyFile)
+			return nil
+		},
+	}
+	cmd.Flags().StringVarP(&out, "out", "o", "dbup-package", "密钥文件名前缀")
+	return cmd
+}
+
+// dbup package sign
+func packageSignCmd() *cobra.Command {
+	var keyFile, dir string
+	cmd := &cobra.Command{
+		Use:   "sign",
+		Short: "计算安装包目录下所有安装包的 sha256, 生成清单并用私钥签名",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if keyFile == "" {
+				return output.Errorf(output.CodeInvalidArgument, "必须指定 --key 私钥文件")
+			}
+			if dir == "" {
+				dir = defaultPackageDir()
+			}
+			key, err := global.LoadSigningKey(keyFile)
+			if err != nil {
+				return output.Errorf(output.CodeCredential, "%v", err)
+			}
+			m, err := global.SignManifest(dir, key)
+			if err != nil {
+				return err
+			}
+			for _, name := range m.Names() {
+				output.Item("packages", map[string]string{"name": name, "sha256": m.Digests[name]}, "%s  %s\n", m.Digests[name], name)
+			}
+			logger.Successf("已生成清单 %s 和签名 %s\n", filepath.Join(dir, global.ManifestFileName), filepath.Join(dir, global.SignatureFileName))
+			return nil
+		},
+	}
+	cmd.Flags().StringVarP(&keyFile, "key", "k", "", "私钥文件")
+	cmd.Flags().StringVarP(&dir, "dir", "d", "", "安装包目录, 默认为 dbup 所在目录的 ../package")
+	return cmd
+}
+
+// dbup package verify
+func packageVerifyCmd() *cobra.Command {
+	var keyFile, dir string
+	cmd := &cobra.Command{
+		Use:   "verify",
+		Short: "验证安装包目录的清单签名和每个安装包的 sha256, 没有清单且没有配置公钥时按旧的 md5 文件校验",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if dir == "" {
+				dir = defaultPackageDir()
+			}
+			var keys []ed25519.PublicKey
+			var err error
+			if keyFile != "" {
+				data, e := ioutil.ReadFile(keyFile)
+				if e != nil {
+					return output.Errorf(output.CodeInvalidArgument, "读取公钥文件失败: %v", e)
+				}
+				keys, err = global.ParsePublicKeys(string(data))
+			} else {
+				keys, err = global.TrustedPackageKeys()
+			}
+			if err != nil {
+				return output.Errorf(output.CodeCredential, "%v", err)
+			}
+
+			checks, err := global.VerifyPackageDir(dir, keys)
+			if err != nil {
+				return output.Errorf(output.CodeCredential, "%v", err)
+			}
+			failed := 0
+			for _, c := range checks {
+				result := "OK"
+				if c.Error != "" {
+					failed++
+					result = c.Error
+				}
+				output.Item("packages", c, "%-6s %s: %s\n", c.Method, c.Name, result)
+			}
+			if failed > 0 {
+				return fmt.Errorf("%d 个安装包校验失败", failed)
+			}
+			logger.Successf("%d 个安装包校验通过\n", len(checks))
+			return nil
+		},
+	}
+	cmd.Flags().StringVarP(&keyFile, "key", "k", "", "公钥文件, 默认使用内置公钥和 "+global.PackageKeyFile)
+	cmd.Flags().StringVarP(&dir, "dir", "d", "", "安装包目录, 默认为 dbup 所在目录的 ../package")
+	return cmd
+}
+
+// dbup package build
+func packageBuildCmd() *cobra.Command {
+	var o bundle.Options
+	var engines []string
+	var keyFile string
+	cmd := &cobra.Command{
+		Use:   "build",
+		Short: "生成离线安装包: 按引擎版本和架构收集安装包、systemd 模板和 dbuplib, 生成校验清单并打包为一个 tar.gz",
+		Example: `  dbup package build --source /data/oasis --version v1.2.0 --arch amd64,arm64 \
+    --engine pgsql=12 --engine mariadb=10.11.8,10.6.15 --engine redis --key release.key`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var err error
+			if o.Engines, err = bundle.ParseEngines(engines); err != nil {
+				return output.Errorf(output.CodeInvalidArgument, "%v", err)
+			}
+			if o.Source == "" {
+				o.Source = filepath.Dir(environment.GlobalEnv().ProgramPath)
+			}
+			if keyFile != "" {
+				if o.Key, err = global.LoadSigningKey(keyFile); err != nil {
+					return output.Errorf(output.CodeCredential, "%v", err)
+				}
+			} else {
+				logger.Warningf("没有指定 --key, 清单不签名, 配置了签名公钥的机器上无法使用\n")
+			}
+			if o.Out == "" {
+				o.Out = fmt.Sprintf("dbup-%s.tar.gz", o.Version)
+			}
+
+			meta, err := bundle.Build(o)
+			if err != nil {
+				return err
+			}
+			for _, p := range meta.Packages {
+				output.Item("packages", p, "%-6s %s/%s\n", p.Arch, p.Kind, p.Name)
+			}
+			output.Set("file", o.Out)
+			logger.Successf("已生成离线安装包 %s, 共 %d 个安装包\n", o.Out, len(meta.Packages))
+			return nil
+		},
+	}
+	cmd.Flags().StringVarP(&o.Source, "source", "s", "", "安装包来源: oasis 仓库目录(包含 oasis-linux-<arch>)或 dbup 安装目录, 默认为 dbup 所在目录的上一级")
+	cmd.Flags().StringVarP(&o.Version, "version", "v", "", "离线安装包版本")
+	cmd.Flags().StringSliceVarP(&o.Arches, "arch", "a", []string{"amd64"}, "架构, 多个架构用逗号分隔")
+	cmd.Flags().StringArrayVarP(&engines, "engine", "e", nil, "引擎及版本: <类型>[=<版本>,<版本>], 不指定版本时包含所有版本, 可以指定多次")
+	cmd.Flags().StringVarP(&o.Out, "out", "o", "", "生成的文件, 默认为 dbup-<version>.tar.gz")
+	cmd.Flags().StringVarP(&keyFile, "key", "k", "", "清单签名私钥文件")
+	return cmd
+}
+
+// dbup package inspect
+func packageInspectCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "inspect <file>",
+		Short: "列出离线安装包中的内容, 并校验每个安装包的 sha256",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			c, err := bundle.Inspect(args[0])
+			if err != nil {
+				return err
+			}
+			if output.IsJSON() {
+				output.Set("bundle", c)
+			} else {
+				m := c.Meta
+				fmt.Printf("版本: %s\n生成时间: %s\n架构: %s\n签名: %v\n", m.Version, m.CreatedAt.Format("2006-01-02 15:04:05"), strings.Join(m.Arches, ", "), m.Signed)
+				fmt.Printf("\n安装包:\n")
+				for _, p := range m.Packages {
+					fmt.Printf("  %-6s %-10s %-12s %-45s %d\n", p.Arch, p.Kind, p.Version, p.Name, p.Size)
+				}
+				fmt.Printf("\n文件:\n")
+				for _, e := range c.Entries {
+					fmt.Printf("  %s\n", e.Name)
+				}
+			}
+			if len(c.Problems) > 0 {
+				for _, p := range c.Problems {
+					logger.Errorf("%s\n", p)
+				}
+				return fmt.Errorf("离线安装包 %s 校验失败", args[0])
+			}
+			return nil
+		},
+	}
+	return cmd
+}
@@ -6,6 +6,7 @@
 package cmd
 
 import (
+	"dbup/internal/credential"
 	"dbup/internal/environment"
 	"dbup/internal/global"
 	"dbup/internal/pgsql"
@@ -13,9 +14,10 @@ import (
 	"dbup/internal/pgsql/services"
 	"dbup/internal/utils"
 	"dbup/internal/utils/logger"
+	"dbup/internal/utils/prompt"
+	"dbup/pkg/dbup"
 	"errors"
 	"fmt"
-	"os"
 	"strings"
 
 	"github.com/spf13/cobra"
@@ -38,6 +40,8 @@ func pgsqlCmd() *cobra.Command {
 		pgsqlAddSlaveCmd(),
 		pgsqlUNInstallCmd(),
 		pgsqlBackupCmd(),
+		pgsqlRestoreCmd(),
+		pgsqlWalArchiveCmd(),
 		pgsqlBackupTablesCmd(),
 		pgsqlBackupTaskCmd(),
 		pgsqlDeployCmd(),
@@ -46,6 +50,7 @@ func pgsqlCmd() *cobra.Command {
 		pgsqlUserCmd(),
 		pgsqlDatabaseCmd(),
 		pgsqlCheckSlavesCmd(),
+		pgsqlSyncStandbyCmd(),
 		pgsqlCheckSelectCmd(),
 		// pgpoolUNInstallCmd(),
 		// pgsqlPGPoolClusterDeployCmd(),
@@ -92,12 +97,8 @@ func pgsqlInstallCmd() *cobra.Command {
 	cmd := &cobra.Command{
 		Use:   "install",
 		Short: "pgsql 单机版安装",
-		PreRunE: func(cmd *cobra.Command, args []string) error {
-			return environment.MustRoot()
-		},
 		RunE: func(cmd *cobra.Command, args []string) error {
-			pg := pgsql.NewPgsql()
-			return pg.Install(pre, cfgFile, packageName, onlyCheck, onlyInstall)
+			return dbup.PgsqlInstall(cmd.Context(), pre, cfgFile, packageName, onlyCheck, onlyInstall)
 		},
 	}
 	cmd.Flags().StringVar(&pre.SystemUser, "system-user", config.DefaultPGAdminUser, "pgsql安装的操作系统用户")
@@ -113,8 +114,11 @@ func pgsqlInstallCmd() *cobra.Command {
 	cmd.Flags().StringVarP(&pre.Address, "address", "a", "", "pgsql 数据库授权IP列表, 默认 0.0.0.0/0")
 	cmd.Flags().StringVarP(&pre.BindIP, "bind-ip", "b", "", "pgsql 数据库监听地址, 默认: *")
 	cmd.Flags().StringVar(&pre.Libraries, "libraries", "", "pgsql启用的插件,以逗号分割, 目前只支持 [timescaledb]")
+	cmd.Flags().StringVar(&pre.Version, "version", "", fmt.Sprintf("pgsql 大版本, 支持 %s, 默认: %s", strings.Join(config.SupportedVersions, ", "), config.DefaultPGVersion))
 	cmd.Flags().BoolVar(&pre.Ipv6, "ipv6", false, "是否开启IPV6功能,默认不开启")
 	cmd.Flags().StringVar(&pre.ResourceLimit, "resource-limit", "", "资源限制清单, 通过执行 systemctl set-property 实现. 例: --resource-limit='MemoryLimit=512M CPUShares=500'")
+	cmd.Flags().BoolVar(&pre.Tune, "tune", false, "安装时按 pgsql 的内置配置调优操作系统(sysctl, 透明大页, limits.d, LimitNOFILE), 可以用 dbup tune revert 恢复")
+	walArchiveFlags(cmd, &pre)
 	cmd.Flags().BoolVarP(&pre.Yes, "yes", "y", false, "是否确认安装")
 	cmd.Flags().BoolVarP(&pre.NoRollback, "no-rollback", "n", false, "安装失败不回滚")
 	cmd.Flags().StringVarP(&cfgFile, "config", "c", "", "安装配置文件, 默认不使用配置文件")
@@ -124,6 +128,16 @@ func pgsqlInstallCmd() *cobra.Command {
 	return cmd
 }
 
+// walArchiveFlags WAL 归档参数
+func walArchiveFlags(cmd *cobra.Command, pre *config.Prepare) {
+	cmd.Flags().StringVar(&pre.WalArchive, "wal-archive", "", "开启 WAL 归档, 归档到本地目录或 s3://bucket/前缀")
+	cmd.Flags().BoolVar(&pre.WalArchiveCompress, "wal-archive-compress", false, "WAL 归档时 gzip 压缩")
+	cmd.Flags().StringVar(&pre.WalArchiveEndpoint, "wal-archive-endpoint", "", "WAL 归档到 S3 时的 S3 地址")
+	cmd.Flags().StringVar(&pre.WalArchiveAccessKey, "wal-archive-access-key", "", "WAL 归档到 S3 时的 S3 accesskey")
+	cmd.Flags().StringVar(&pre.WalArchiveSecretKey, "wal-archive-secret-key", "", "WAL 归档到 S3 时的 S3 secretkey")
+	cmd.Flags().StringVar(&pre.WalArchiveS3Mode, "wal-archive-s3-mode", "normal", "WAL 归档到 S3 时的连接模式, <normal|SkipVerify>")
+}
+
 // dbup pgsql install
 func pgsqlInstallSlaveCmd() *cobra.Command {
 	var pre config.Prepare
@@ -131,12 +145,8 @@ func pgsqlInstallSlaveCmd() *cobra.Command {
 	cmd := &cobra.Command{
 		Use:   "install-slave",
 		Short: "pgsql 从库安装",
-		PreRunE: func(cmd *cobra.Command, args []string) error {
-			return environment.MustRoot()
-		},
 		RunE: func(cmd *cobra.Command, args []string) error {
-			pg := pgsql.NewPgsql()
-			return pg.InstallSlave(pre, master)
+			return dbup.PgsqlInstallSlave(cmd.Context(), pre, master)
 		},
 	}
 	cmd.Flags().StringVar(&pre.SystemUser, "system-user", config.DefaultPGAdminUser, "pgsql安装的操作系统用户")
@@ -146,7 +156,9 @@ func pgsqlInstallSlaveCmd() *cobra.Command {
 	cmd.Flags().StringVarP(&pre.Dir, "dir", "d", "", "pgsql安装目录, 默认: /opt/pgsql$PORT")
 	cmd.Flags().IntVarP(&pre.Port, "port", "P", 0, "pgsql 数据库监听端口, 默认: 5432")
 	cmd.Flags().StringVarP(&master, "master", "m", "", "要同步的主库的<地址:端口>")
+	cmd.Flags().StringVar(&pre.Version, "version", "", "pgsql 大版本, 必须与主库一致, 默认: "+config.DefaultPGVersion)
 	cmd.Flags().StringVar(&pre.ResourceLimit, "resource-limit", "", "资源限制清单, 通过执行 systemctl set-property 实现. 例: --resource-limit='MemoryLimit=512M CPUShares=500'")
+	cmd.Flags().BoolVar(&pre.Tune, "tune", false, "安装时按 pgsql 的内置配置调优操作系统(sysctl, 透明大页, limits.d, LimitNOFILE), 可以用 dbup tune revert 恢复")
 	cmd.Flags().BoolVarP(&pre.Yes, "yes", "y", false, "是否确认安装")
 	cmd.Flags().BoolVarP(&pre.NoRollback, "no-rollback", "n", false, "安装失败不回滚")
 
@@ -162,20 +174,7 @@ func pgsqlAddSlaveCmd() *cobra.Command {
 		Use:   "add-slave",
 		Short: "pgsql 从库安装",
 		RunE: func(cmd *cobra.Command, args []string) error {
-			if sshOption.Host == "" {
-				return fmt.Errorf("请指定要创建从库的远程机器IP地址")
-			}
-
-			if master == "" {
-				return fmt.Errorf("请指定要要同步的主库的节点信息<IP:PORT>")
-			}
-
-			if pre.Port == 0 {
-				return errors.New("请指定 --port 端口号")
-			}
-
-			pg := pgsql.NewPgsql()
-			return pg.AddSlave(sshOption, pre, master)
+			return dbup.PgsqlAddSlave(cmd.Context(), sshOption, pre, master)
 		},
 	}
 	cmd.Flags().StringVar(&sshOption.Host, "host", "", "新节点IP")
@@ -183,6 +182,11 @@ func pgsqlAddSlaveCmd() *cobra.Command {
 	cmd.Flags().StringVar(&sshOption.Username, "ssh-username", "", "ssh 用户名")
 	cmd.Flags().StringVar(&sshOption.Password, "ssh-password", "", "ssh 密码")
 	cmd.Flags().StringVar(&sshOption.KeyFile, "ssh-keyfile", "", "ssh 密钥")
+	cmd.Flags().StringVar(&sshOption.HostKeyCheck, "ssh-host-key-check", "", "ssh 主机密钥校验策略: strict(默认), tofu, insecure")
+	cmd.Flags().StringVar(&sshOption.KnownHosts, "ssh-known-hosts", "", "ssh known_hosts 文件, 默认 ~/.ssh/known_hosts 与 ~/.dbup/known_hosts")
+	cmd.Flags().Var(&sshOption.ProxyJump, "ssh-proxy-jump", "ssh 跳板机, 多个以逗号分隔, 格式: [user[:password]@]host[:port][?keyfile=/path/to/key]")
+	cmd.Flags().StringVar(&sshOption.Auth, "ssh-auth", "", "ssh 认证方式及尝试顺序, 逗号分隔: agent, publickey, password")
+	cmd.Flags().StringVar(&sshOption.PassphraseFile, "ssh-passphrase-file", "", "ssh 私钥密码文件, 也可以通过环境变量 DBUP_SSH_PASSPHRASE 指定")
 	cmd.Flags().StringVar(&pre.SystemUser, "system-user", config.DefaultPGAdminUser, "pgsql安装的操作系统用户")
 	cmd.Flags().StringVar(&pre.SystemGroup, "system-group", config.DefaultPGAdminUser, "pgsql安装的操作系统用户组")
 	cmd.Flags().StringVarP(&pre.Username, "username", "u", "", "要同步的主库上创建的主从同步用户")
@@ -190,7 +194,9 @@ func pgsqlAddSlaveCmd() *cobra.Command {
 	cmd.Flags().StringVarP(&pre.Dir, "dir", "d", "", "pgsql安装目录, 默认: /opt/pgsql$PORT")
 	cmd.Flags().IntVarP(&pre.Port, "port", "P", 0, "pgsql 数据库监听端口, 默认: 5432")
 	cmd.Flags().StringVarP(&master, "master", "m", "", "要同步的主库的<地址:端口>")
+	cmd.Flags().StringVar(&pre.Version, "version", "", "pgsql 大版本, 必须与主库一致, 默认: "+config.DefaultPGVersion)
 	cmd.Flags().StringVar(&pre.ResourceLimit, "resource-limit", "", "资源限制清单, 通过执行 systemctl set-property 实现. 例: --resource-limit='MemoryLimit=512M CPUShares=500'")
+	cmd.Flags().BoolVar(&pre.Tune, "tune", false, "安装时按 pgsql 的内置配置调优操作系统(sysctl, 透明大页, limits.d, LimitNOFILE), 可以用 dbup tune revert 恢复")
 	cmd.Flags().BoolVarP(&pre.Yes, "yes", "y", false, "是否确认安装")
 	cmd.Flags().BoolVarP(&pre.NoRollback, "no-rollback", "n", false, "安装失败不回滚")
 
@@ -204,29 +210,8 @@ func pgsqlUNInstallCmd() *cobra.Command {
 	cmd := &cobra.Command{
 		Use:   "uninstall",
 		Short: "pgsql 卸载",
-		PreRunE: func(cmd *cobra.Command, args []string) error {
-			return environment.MustRoot()
-		},
 		RunE: func(cmd *cobra.Command, args []string) error {
-			if uninst.Port == 0 || uninst.BasePath == "" {
-				return fmt.Errorf("必须手动指定 --port and --dir 参数")
-			}
-
-			if !yes {
-				var s string
-				logger.Successf("端口: %d\n", uninst.Port)
-				logger.Successf("数据路径: %s\n", uninst.BasePath)
-				logger.Successf("是否确认卸载[y|n]:")
-				if _, err := fmt.Scanln(&s); err != nil {
-					return err
-				}
-				if strings.ToUpper(s) != "Y" && strings.ToUpper(s) != "YES" {
-					os.Exit(0)
-				}
-			}
-
-			pg := pgsql.NewPgsql()
-			return pg.UNInstall(&uninst)
+			return dbup.PgsqlUninstall(cmd.Context(), &uninst, yes)
 		},
 	}
 	cmd.Flags().IntVarP(&uninst.Port, "port", "P", 0, "pgsql 数据库监听端口")
@@ -244,8 +229,10 @@ func pgsqlBackupCmd() *cobra.Command {
 		Use:   "backup",
 		Short: "pgsql 进行备份",
 		RunE: func(cmd *cobra.Command, args []string) error {
-			pg := pgsql.NewPgsql()
-			return pg.Backup(backup)
+			if err := defaultCredential(cmd, credential.Name(config.Kinds, backup.Port), "username", credential.FieldUsername, "password", credential.FieldPassword); err != nil {
+				return err
+			}
+			return dbup.PgsqlBackup(cmd.Context(), backup)
 		},
 	}
 	cmd.Flags().StringVarP(&backup.Username, "username", "u", "pguser", "用户名")
@@ -257,6 +244,53 @@ func pgsqlBackupCmd() *cobra.Command {
 	return cmd
 }
 
+// dbup pgsql restore
+func pgsqlRestoreCmd() *cobra.Command {
+	var sshOption global.SSHConfig
+	var restore = services.NewRestore()
+	cmd := &cobra.Command{
+		Use:   "restore",
+		Short: "pgsql 从 pg_basebackup 备份目录恢复实例",
+		Long:  "把 pg_basebackup -Fp 生成的备份目录恢复成新实例, 或恢复到一个已经停止的实例(原数据目录移走保留). 指定 --host 时在远程机器上恢复, 备份目录为远程机器上的目录",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return dbup.PgsqlRestore(cmd.Context(), sshOption, restore)
+		},
+	}
+	cmd.Flags().StringVar(&restore.BackupDir, "backup-dir", "", "要恢复的备份目录, 如 dbup pgsql backup 生成的 pg_backup_* 目录")
+	cmd.Flags().BoolVar(&restore.KeepStandby, "keep-standby", false, "保留备份中的 standby.signal 和 primary_conninfo, 恢复后作为从库启动; 默认删除, 作为主库启动")
+	cmd.Flags().IntVar(&restore.Wait, "wait", 120, "启动后等待实例可以接受连接的秒数")
+	cmd.Flags().StringVar(&restore.WalArchiveConfig, "wal-archive-config", "", "源实例的 WAL 归档配置文件, 如 /opt/pgsql5432/wal_archive.conf; 指定后用归档的 WAL 恢复到最新或 --target-* 指定的位置")
+	cmd.Flags().StringVar(&restore.TargetTime, "target-time", "", "恢复到的时间点, 如 '2021-01-02 15:04:05+08'")
+	cmd.Flags().StringVar(&restore.TargetLSN, "target-lsn", "", "恢复到的 LSN, 如 0/3000148")
+	cmd.Flags().StringVar(&restore.TargetXID, "target-xid", "", "恢复到的事务号")
+	cmd.Flags().StringVar(&restore.TargetAction, "target-action", "promote", "到达恢复目标后的动作: promote, pause, shutdown")
+	walArchiveFlags(cmd, &restore.Prepare)
+	cmd.Flags().StringVar(&sshOption.Host, "host", "", "远程机器IP, 不指定时在本机恢复")
+	cmd.Flags().IntVar(&sshOption.Port, "ssh-port", 22, "ssh 端口号")
+	cmd.Flags().StringVar(&sshOption.Username, "ssh-username", "", "ssh 用户名")
+	cmd.Flags().StringVar(&sshOption.Password, "ssh-password", "", "ssh 密码")
+	cmd.Flags().StringVar(&sshOption.KeyFile, "ssh-keyfile", "", "ssh 密钥")
+	cmd.Flags().StringVar(&sshOption.HostKeyCheck, "ssh-host-key-check", "", "ssh 主机密钥校验策略: strict(默认), tofu, insecure")
+	cmd.Flags().StringVar(&sshOption.KnownHosts, "ssh-known-hosts", "", "ssh known_hosts 文件, 默认 ~/.ssh/known_hosts 与 ~/.dbup/known_hosts")
+	cmd.Flags().Var(&sshOption.ProxyJump, "ssh-proxy-jump", "ssh 跳板机, 多个以逗号分隔, 格式: [user[:password]@]host[:port][?keyfile=/path/to/key]")
+	cmd.Flags().StringVar(&sshOption.Auth, "ssh-auth", "", "ssh 认证方式及尝试顺序, 逗号分隔: agent, publickey, password")
+	cmd.Flags().StringVar(&sshOption.PassphraseFile, "ssh-passphrase-file", "", "ssh 私钥密码文件, 也可以通过环境变量 DBUP_SSH_PASSPHRASE 指定")
+	cmd.Flags().StringVar(&restore.Prepare.SystemUser, "system-user", config.DefaultPGAdminUser, "pgsql安装的操作系统用户")
+	cmd.Flags().StringVar(&restore.Prepare.SystemGroup, "system-group", config.DefaultPGAdminUser, "pgsql安装的操作系统用户组")
+	cmd.Flags().StringVarP(&restore.Prepare.Dir, "dir", "d", "", "pgsql安装目录, 默认: /opt/pgsql$PORT")
+	cmd.Flags().IntVarP(&restore.Prepare.Port, "port", "P", 0, "pgsql 数据库监听端口")
+	cmd.Flags().StringVar(&restore.Prepare.Version, "version", "", "pgsql 大版本, 默认按备份中的 PG_VERSION")
+	cmd.Flags().StringVarP(&restore.Prepare.MemorySize, "memory-size", "m", "", "pgsql 数据库内存大小, 默认: 512M")
+	cmd.Flags().StringVarP(&restore.Prepare.BindIP, "bind-ip", "b", "", "pgsql 数据库监听地址, 默认: *")
+	cmd.Flags().StringVar(&restore.Prepare.ResourceLimit, "resource-limit", "", "资源限制清单, 通过执行 systemctl set-property 实现. 例: --resource-limit='MemoryLimit=512M CPUShares=500'")
+	cmd.Flags().BoolVar(&restore.Prepare.Tune, "tune", false, "恢复时按 pgsql 的内置配置调优操作系统(sysctl, 透明大页, limits.d, LimitNOFILE), 可以用 dbup tune revert 恢复")
+	cmd.Flags().BoolVarP(&restore.Prepare.Yes, "yes", "y", false, "是否确认恢复")
+	cmd.Flags().BoolVarP(&restore.Prepare.NoRollback, "no-rollback", "n", false, "恢复成新实例失败时不回滚")
+	_ = cmd.MarkFlagRequired("backup-dir")
+	_ = cmd.MarkFlagRequired("port")
+	return cmd
+}
+
 // dbup pgsql backup-tables
 func pgsqlBackupTablesCmd() *cobra.Command {
 	var tables string
@@ -266,6 +300,9 @@ func pgsqlBackupTablesCmd() *cobra.Command {
 		Use:   "backup-tables",
 		Short: "pgsql 表备份",
 		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := defaultCredential(cmd, credential.Name(config.Kinds, backup.Port), "username", credential.FieldUsername, "password", credential.FieldPassword); err != nil {
+				return err
+			}
 			pg := pgsql.NewPgsql()
 			return pg.BackupTables(backup, tables, list)
 		},
@@ -286,18 +323,32 @@ func pgsqlBackupTablesCmd() *cobra.Command {
 // dbup pgsql cluster-deploy
 func pgsqlDeployCmd() *cobra.Command {
 	var config string
+	var dryRun bool
+	var resume string
 	cmd := &cobra.Command{
 		Use:   "cluster-deploy",
 		Short: "pgsql 主从部署",
 		RunE: func(cmd *cobra.Command, args []string) error {
+			if resume != "" {
+				if dryRun {
+					return fmt.Errorf("--resume 不能与 --plan 同时使用")
+				}
+				return dbup.PgsqlDeployResume(cmd.Context(), resume)
+			}
 			if config == "" {
 				return fmt.Errorf("请指定部署配置文件")
 			}
-			pg := pgsql.NewPgsql()
-			return pg.Deploy(config)
+			if dryRun {
+				// 存在冲突时只输出错误, 不打印用法
+				cmd.SilenceUsage = true
+				return dbup.PgsqlDeployPlan(cmd.Context(), config)
+			}
+			return dbup.PgsqlDeploy(cmd.Context(), config)
 		},
 	}
 	cmd.Flags().StringVarP(&config, "config", "c", "", "安装配置文件")
+	cmd.Flags().BoolVar(&dryRun, "plan", false, "只检查配置并只读连接各节点, 输出部署计划和冲突, 不做任何修改")
+	cmd.Flags().StringVar(&resume, "resume", "", "根据部署记录继续失败的部署, 跳过已经完成的步骤, 部署记录由 dbup deploy history 查看")
 	return cmd
 }
 
@@ -312,8 +363,7 @@ func pgsqlRemoveDeployCmd() *cobra.Command {
 			if config == "" {
 				return fmt.Errorf("请指定部署配置文件")
 			}
-			pg := pgsql.NewPgsql()
-			return pg.RemoveDeploy(config, yes)
+			return dbup.PgsqlRemoveDeploy(cmd.Context(), config, yes)
 		},
 	}
 	cmd.Flags().StringVarP(&config, "config", "c", "", "安装配置文件")
@@ -377,16 +427,11 @@ func pgpoolUNInstallCmd() *cobra.Command {
 			}
 
 			if !yes {
-				var s string
 				logger.Successf("端口: %d\n", uninst.Port)
 				logger.Successf("数据路径: %s\n", uninst.BasePath)
-				logger.Successf("是否确认卸载[y|n]:")
-				if _, err := fmt.Scanln(&s); err != nil {
+				if err := prompt.Confirm("是否确认卸载"); err != nil {
 					return err
 				}
-				if strings.ToUpper(s) != "Y" && strings.ToUpper(s) != "YES" {
-					os.Exit(0)
-				}
 			}
 
 			pg := pgsql.NewPgsql()
@@ -446,6 +491,9 @@ func pgsqlPromoteCmd() *cobra.Command {
 		Use:   "promote",
 		Short: "pgsql 从库提升为主库",
 		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := defaultCredential(cmd, credential.Name(config.Kinds, pgm.Port), "username", credential.FieldAdminUser, "password", credential.FieldAdminPassword); err != nil {
+				return err
+			}
 			if wait != "true" && wait != "false" {
 				return errors.New("wait 只能是 true 和 false")
 			}
@@ -469,7 +517,7 @@ func PGsqlUPgradeCmd() *cobra.Command {
 		Use:   "upgrade",
 		Short: "pgsql 升级",
 		RunE: func(cmd *cobra.Command, args []string) error {
-			return upgrade.Run()
+			return dbup.PgsqlUpgrade(cmd.Context(), upgrade)
 		},
 	}
 
@@ -6,6 +6,7 @@
 package cmd
 
 import (
+	"dbup/internal/credential"
 	"dbup/internal/pgsql"
 	"dbup/internal/pgsql/config"
 	"dbup/internal/pgsql/services"
@@ -51,6 +52,9 @@ func pgsqlBackupTaskAddCmd() *cobra.Command {
 		Use:   "add",
 		Short: "pgsql 运行定时任务",
 		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := defaultCredential(cmd, credential.Name(config.Kinds, task.Backup.Port), "user", credential.FieldUsername, "password", credential.FieldPassword); err != nil {
+				return err
+			}
 			pg := pgsql.NewPgsql()
 			return pg.BackupTask("add", task)
 		},
@@ -62,6 +66,7 @@ func pgsqlBackupTaskAddCmd() *cobra.Command {
 	cmd.Flags().StringVarP(&task.Backup.BackupCmd, "command", "c", "pg_basebackup", "pgsql 备份命令")
 	cmd.Flags().StringVarP(&task.BackupDir, "backupdir", "d", "", "pgsql 备份基目录")
 	cmd.Flags().IntVarP(&task.Expire, "expire", "e", 0, "备份过期天数")
+	cmd.Flags().StringVar(&task.WalArchiveConfig, "wal-archive-config", "", "实例的 WAL 归档配置, 如 /opt/pgsql5432/wal_archive.conf; 指定后删除过期备份时一起清理比最早的备份还早的 WAL 归档")
 	cmd.Flags().StringVarP(&task.TaskName, "taskname", "n", config.BackupTaskDefaultTaskName, "任务名称")
 	cmd.Flags().StringVarP(&task.TaskTime, "tasktime", "t", config.BackupTaskDefaultTaskTime, "任务每天开始时间")
 	cmd.Flags().StringVar(&task.SysUser, "sysuser", config.BackupTaskDefaultSysUser, "操作系统用户")
@@ -95,6 +100,9 @@ func pgsqlBackupTaskRunCmd() *cobra.Command {
 		Use:   "run",
 		Short: "pgsql 运行定时任务",
 		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := defaultCredential(cmd, credential.Name(config.Kinds, task.Backup.Port), "user", credential.FieldUsername, "password", credential.FieldPassword); err != nil {
+				return err
+			}
 			pg := pgsql.NewPgsql()
 			return pg.BackupTask("run", task)
 		},
@@ -106,5 +114,6 @@ func pgsqlBackupTaskRunCmd() *cobra.Command {
 	cmd.Flags().StringVarP(&task.Backup.BackupCmd, "command", "c", "pg_basebackup", "pgsql 备份命令")
 	cmd.Flags().StringVarP(&task.BackupDir, "backupdir", "d", "", "pgsql 备份基目录")
 	cmd.Flags().IntVarP(&task.Expire, "expire", "e", 0, "备份过期天数")
+	cmd.Flags().StringVar(&task.WalArchiveConfig, "wal-archive-config", "", "实例的 WAL 归档配置, 如 /opt/pgsql5432/wal_archive.conf; 指定后删除过期备份时一起清理比最早的备份还早的 WAL 归档")
 	return cmd
 }
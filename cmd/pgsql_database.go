@@ -6,10 +6,10 @@
 package cmd
 
 import (
-	"dbup/internal/pgsql"
+	"dbup/internal/credential"
 	"dbup/internal/pgsql/config"
 	"dbup/internal/pgsql/services"
-	"fmt"
+	"dbup/pkg/dbup"
 
 	"github.com/spf13/cobra"
 )
@@ -34,12 +34,10 @@ func pgsqlDatabaseCreateCmd() *cobra.Command {
 		Use:   "create",
 		Short: "pgsql 创建库",
 		RunE: func(cmd *cobra.Command, args []string) error {
-			if m.DBName == "" {
-				return fmt.Errorf("请指定要创建的库名\n")
+			if err := defaultCredential(cmd, credential.Name(config.Kinds, m.Port), "admin-user", credential.FieldAdminUser, "admin-password", credential.FieldAdminPassword); err != nil {
+				return err
 			}
-
-			pg := pgsql.NewPgsql()
-			return pg.DatabaseCreate(m)
+			return dbup.PgsqlDatabaseCreate(cmd.Context(), m)
 		},
 	}
 	cmd.Flags().StringVarP(&m.Host, "host", "H", config.DefaultPGSocketPath, "pgsql 地址")
@@ -6,6 +6,7 @@
 package cmd
 
 import (
+	"dbup/internal/credential"
 	"dbup/internal/pgsql"
 	"dbup/internal/pgsql/config"
 	"dbup/internal/pgsql/services"
@@ -21,6 +22,9 @@ func pgsqlCheckSlavesCmd() *cobra.Command {
 		Use:   "check-slaves",
 		Short: "pgsql 检查从库信息",
 		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := defaultCredential(cmd, credential.Name(config.Kinds, m.Port), "admin-user", credential.FieldAdminUser, "admin-password", credential.FieldAdminPassword); err != nil {
+				return err
+			}
 			if len(args) == 0 {
 				return fmt.Errorf("请输入从库IP")
 			}
@@ -38,6 +42,32 @@ func pgsqlCheckSlavesCmd() *cobra.Command {
 	return cmd
 }
 
+// dbup pgsql sync-standby
+func pgsqlSyncStandbyCmd() *cobra.Command {
+	var m = services.NewPGManager()
+	var names string
+	cmd := &cobra.Command{
+		Use:   "sync-standby",
+		Short: "pgsql 设置同步从库(synchronous_standby_names)",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := defaultCredential(cmd, credential.Name(config.Kinds, m.Port), "admin-user", credential.FieldAdminUser, "admin-password", credential.FieldAdminPassword); err != nil {
+				return err
+			}
+
+			pg := pgsql.NewPgsql()
+			return pg.SyncStandby(m, names)
+		},
+	}
+
+	cmd.Flags().StringVarP(&m.Host, "host", "H", config.DefaultPGSocketPath, "pgsql 地址")
+	cmd.Flags().IntVarP(&m.Port, "port", "P", 5432, "pgsql 端口")
+	cmd.Flags().StringVarP(&m.AdminUser, "admin-user", "u", config.DefaultPGAdminUser, "管理员用户")
+	cmd.Flags().StringVarP(&m.AdminPassword, "admin-password", "p", "", "管理员密码")
+	cmd.Flags().StringVarP(&m.AdminDatabase, "admin-database", "d", "", "管理员登录库, 默认与用户名同名")
+	cmd.Flags().StringVarP(&names, "standby-names", "", "", "同步从库, 如: FIRST 1 (\"slave1\", \"slave2\"), 为空时关闭同步复制")
+	return cmd
+}
+
 // dbup pgsql check-slaves
 func pgsqlCheckSelectCmd() *cobra.Command {
 	var m = services.NewPGManager()
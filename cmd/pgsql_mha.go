@@ -12,9 +12,8 @@ import (
 	"dbup/internal/pgsql/services"
 	"dbup/internal/utils"
 	"dbup/internal/utils/logger"
+	"dbup/internal/utils/prompt"
 	"fmt"
-	"os"
-	"strings"
 
 	"github.com/spf13/cobra"
 )
@@ -109,6 +108,7 @@ func PgAutoFailoverPGdataAdd() *cobra.Command {
 	cmd.Flags().StringVarP(&d.BindIP, "bind-ip", "b", "", "pgsql 数据库监听地址, 默认: *")
 	cmd.Flags().StringVar(&d.Libraries, "libraries", "", "pgsql启用的插件, 目前只支持 [timescaledb]")
 	cmd.Flags().StringVar(&d.ResourceLimit, "resource-limit", "", "资源限制清单, 通过执行 systemctl set-property 实现. 例: --resource-limit='MemoryLimit=512M CPUShares=500'")
+	cmd.Flags().BoolVar(&d.Tune, "tune", false, "安装时按 pgsql 的内置配置调优操作系统(sysctl, 透明大页, limits.d, LimitNOFILE), 可以用 dbup tune revert 恢复")
 	cmd.Flags().StringVarP(&cfgFile, "config", "c", "", "安装配置文件, 默认不使用配置文件")
 	cmd.Flags().BoolVarP(&d.Onenode, "onenode", "o", false, "是否为安装的第一个节点,第一个数据节点必须指定此参数")
 	cmd.Flags().BoolVarP(&d.Yes, "yes", "y", false, "是否确认安装")
@@ -135,16 +135,11 @@ func PgAutoFailoverUNInstallCmd() *cobra.Command {
 			}
 
 			if !yes {
-				var s string
 				logger.Successf("端口: %d\n", uninst.Port)
 				logger.Successf("数据路径: %s\n", uninst.BasePath)
-				logger.Successf("是否确认卸载[y|n]:")
-				if _, err := fmt.Scanln(&s); err != nil {
+				if err := prompt.Confirm("是否确认卸载"); err != nil {
 					return err
 				}
-				if strings.ToUpper(s) != "Y" && strings.ToUpper(s) != "YES" {
-					os.Exit(0)
-				}
 			}
 
 			pg := pgsql.NewPgsql()
@@ -6,10 +6,10 @@
 package cmd
 
 import (
-	"dbup/internal/pgsql"
+	"dbup/internal/credential"
 	"dbup/internal/pgsql/config"
 	"dbup/internal/pgsql/services"
-	"fmt"
+	"dbup/pkg/dbup"
 
 	"github.com/spf13/cobra"
 )
@@ -35,12 +35,10 @@ func pgsqlUserAddCmd() *cobra.Command {
 		Use:   "create",
 		Short: "pgsql 添加用户",
 		RunE: func(cmd *cobra.Command, args []string) error {
-			if m.User == "" || m.Password == "" {
-				return fmt.Errorf("请指定要创建的用户名和密码")
+			if err := defaultCredential(cmd, credential.Name(config.Kinds, m.Port), "admin-user", credential.FieldAdminUser, "admin-password", credential.FieldAdminPassword); err != nil {
+				return err
 			}
-
-			pg := pgsql.NewPgsql()
-			return pg.UserCreate(m)
+			return dbup.PgsqlUserCreate(cmd.Context(), m)
 		},
 	}
 	cmd.Flags().StringVarP(&m.Host, "host", "H", config.DefaultPGSocketPath, "pgsql 地址")
@@ -62,12 +60,10 @@ func pgsqlUserGrantCmd() *cobra.Command {
 		Use:   "grant",
 		Short: "pgsql 用户授权",
 		RunE: func(cmd *cobra.Command, args []string) error {
-			if m.User == "" || m.DBName == "" || m.Address == "" {
-				return fmt.Errorf("请指定要授权的用户名,库名,IP地址")
+			if err := defaultCredential(cmd, credential.Name(config.Kinds, m.Port), "admin-user", credential.FieldAdminUser, "admin-password", credential.FieldAdminPassword); err != nil {
+				return err
 			}
-
-			pg := pgsql.NewPgsql()
-			return pg.UserGrant(m)
+			return dbup.PgsqlUserGrant(cmd.Context(), m)
 		},
 	}
 	cmd.Flags().StringVarP(&m.Host, "host", "H", config.DefaultPGSocketPath, "pgsql 地址")
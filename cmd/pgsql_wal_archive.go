@@ -0,0 +1,94 @@
+package cmd
+
+import (
+	"dbup/pkg/dbup"
+
+	"github.com/spf13/cobra"
+)
+
+// dbup pgsql wal-archive
+func pgsqlWalArchiveCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "wal-archive",
+		Short: "pgsql WAL 归档管理",
+	}
+	// 装载命令
+	cmd.AddCommand(
+		pgsqlWalArchivePushCmd(),
+		pgsqlWalArchiveFetchCmd(),
+		pgsqlWalArchiveStatusCmd(),
+		pgsqlWalArchivePruneCmd(),
+	)
+	return cmd
+}
+
+// dbup pgsql wal-archive push
+func pgsqlWalArchivePushCmd() *cobra.Command {
+	var cfgFile string
+	cmd := &cobra.Command{
+		Use:   "push <wal-path> <wal-name>",
+		Short: "归档一个 WAL 文件, 供 archive_command 调用(%p %f)",
+		Args:  cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return dbup.PgsqlWalArchivePush(cmd.Context(), cfgFile, args[0], args[1])
+		},
+	}
+	cmd.Flags().StringVar(&cfgFile, "config", "", "归档配置文件")
+	_ = cmd.MarkFlagRequired("config")
+	return cmd
+}
+
+// dbup pgsql wal-archive fetch
+func pgsqlWalArchiveFetchCmd() *cobra.Command {
+	var cfgFile string
+	cmd := &cobra.Command{
+		Use:   "fetch <wal-name> <target-path>",
+		Short: "从归档中取回一个 WAL 文件, 供 restore_command 调用(%f %p)",
+		Args:  cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return dbup.PgsqlWalArchiveFetch(cmd.Context(), cfgFile, args[0], args[1])
+		},
+	}
+	cmd.Flags().StringVar(&cfgFile, "config", "", "归档配置文件")
+	_ = cmd.MarkFlagRequired("config")
+	return cmd
+}
+
+// dbup pgsql wal-archive status
+func pgsqlWalArchiveStatusCmd() *cobra.Command {
+	var cfgFile, dir string
+	var port int
+	cmd := &cobra.Command{
+		Use:   "status",
+		Short: "查看 WAL 归档状态",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return dbup.PgsqlWalArchiveStatus(cmd.Context(), cfgFile, dir, port)
+		},
+	}
+	cmd.Flags().StringVar(&cfgFile, "config", "", "归档配置文件, 默认: 安装目录下的 wal_archive.conf")
+	cmd.Flags().StringVarP(&dir, "dir", "d", "", "pgsql安装目录, 默认: /opt/pgsql$PORT")
+	cmd.Flags().IntVarP(&port, "port", "P", 0, "pgsql 数据库监听端口")
+	return cmd
+}
+
+// dbup pgsql wal-archive prune
+func pgsqlWalArchivePruneCmd() *cobra.Command {
+	var cfgFile, dir, backupDir string
+	var port int
+	var dryRun bool
+	cmd := &cobra.Command{
+		Use:   "prune",
+		Short: "删除比最早的基础备份还早的 WAL 归档",
+		Long:  "按备份目录中最早的 pg_backup_* 基础备份(backup_label 中的 START WAL LOCATION)删除更早的 WAL 归档, 时间线历史文件全部保留. 一般配合 backup-task 的 --wal-archive-config 在删除过期备份后自动执行",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return dbup.PgsqlWalArchivePrune(cmd.Context(), cfgFile, dir, port, backupDir, dryRun)
+		},
+	}
+	cmd.Flags().StringVar(&cfgFile, "config", "", "归档配置文件, 默认: 安装目录下的 wal_archive.conf")
+	cmd.Flags().StringVarP(&dir, "dir", "d", "", "pgsql安装目录, 默认: /opt/pgsql$PORT")
+	cmd.Flags().IntVarP(&port, "port", "P", 0, "pgsql 数据库监听端口")
+	cmd.Flags().StringVar(&backupDir, "backupdir", "", "基础备份目录, 同 backup-task 的 --backupdir")
+	cmd.Flags().BoolVar(&dryRun, "dry-run", false, "只列出要删除的 WAL, 不删除")
+	_ = cmd.MarkFlagRequired("backupdir")
+	return cmd
+}
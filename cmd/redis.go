@@ -6,15 +6,12 @@
 package cmd
 
 import (
-	"dbup/internal/environment"
-	"dbup/internal/redis"
+	"dbup/internal/credential"
 	"dbup/internal/redis/config"
 	"dbup/internal/redis/services"
 	"dbup/internal/utils"
-	"dbup/internal/utils/logger"
-	"errors"
+	"dbup/pkg/dbup"
 	"fmt"
-	"os"
 	"strings"
 
 	"github.com/spf13/cobra"
@@ -53,12 +50,8 @@ func redisInstallCmd() *cobra.Command {
 	cmd := &cobra.Command{
 		Use:   "install",
 		Short: "redis 单机版安装",
-		PreRunE: func(cmd *cobra.Command, args []string) error {
-			return environment.MustRoot()
-		},
 		RunE: func(cmd *cobra.Command, args []string) error {
-			rs := redis.NewRedis()
-			return rs.Install(param, cfgFile, onlyCheck)
+			return dbup.RedisInstall(cmd.Context(), param, cfgFile, onlyCheck)
 		},
 	}
 	cmd.Flags().StringVar(&param.SystemUser, "system-user", config.DefaultRedisSystemUser, "redis安装的操作系统用户")
@@ -69,6 +62,7 @@ func redisInstallCmd() *cobra.Command {
 	cmd.Flags().StringVarP(&param.Module, "module", "M", "", "redis模块,多个模块以逗号分割, 目前仅支持[redisbloom,redisearch,redisgraph]")
 	cmd.Flags().StringVar(&param.Master, "master", "", "要同步的主库节点<IP:PORT>, 为空则默认安装单机单实例主库")
 	cmd.Flags().IntVarP(&param.Port, "port", "P", 0, "redis 数据库监听端口")
+	cmd.Flags().StringVar(&param.Version, "version", "", fmt.Sprintf("redis 大版本, 支持 %s, 默认: %s", strings.Join(config.SupportedVersions, ", "), config.DefaultRedisVersion))
 	cmd.Flags().BoolVar(&param.Ipv6, "ipv6", false, "是否开启IPV6功能,默认不开启")
 	// cmd.Flags().StringVar(&param.Appendonly, "appendonly", "yes", "是否开启aof,可选值:<yes|no>")
 	cmd.Flags().StringVar(&param.MaxmemoryPolicy, "maxmemory-policy", "noeviction", "key淘汰策略,可选值:<volatile-lru|allkeys-lru|volatile-random|allkeys-random|volatile-ttl|noeviction>")
@@ -76,6 +70,7 @@ func redisInstallCmd() *cobra.Command {
 	cmd.Flags().BoolVarP(&param.Yes, "yes", "y", false, "直接安装, 否则需要交互确认")
 	cmd.Flags().BoolVar(&param.Cluster, "cluster", false, "是否为集群模式")
 	cmd.Flags().StringVar(&param.ResourceLimit, "resource-limit", "", "资源限制清单, 通过执行 systemctl set-property 实现. 例: --resource-limit='MemoryLimit=512M CPUShares=500'")
+	cmd.Flags().BoolVar(&param.Tune, "tune", false, "安装时按 redis 的内置配置调优操作系统(sysctl, 透明大页, limits.d, LimitNOFILE), 可以用 dbup tune revert 恢复")
 	cmd.Flags().BoolVarP(&param.NoRollback, "no-rollback", "n", false, "安装失败不回滚")
 	cmd.Flags().StringVarP(&cfgFile, "config", "c", "", "安装配置文件, 默认不使用配置文件")
 	return cmd
@@ -89,18 +84,18 @@ func redisAddSlaveCmd() *cobra.Command {
 		Use:   "add-slave",
 		Short: "redis 添加从节库",
 		RunE: func(cmd *cobra.Command, args []string) error {
-			if option.Parameter.Port == 0 {
-				return errors.New("请指定 --port 端口号")
-			}
-
-			rs := redis.NewRedis()
-			return rs.RedisAddSlave(option)
+			return dbup.RedisAddSlave(cmd.Context(), option)
 		},
 	}
 	cmd.Flags().IntVar(&option.SSHConfig.Port, "ssh-port", 22, "ssh 端口号")
 	cmd.Flags().StringVar(&option.SSHConfig.Username, "ssh-username", "", "ssh 用户名")
 	cmd.Flags().StringVar(&option.SSHConfig.Password, "ssh-password", "", "ssh 密码")
 	cmd.Flags().StringVar(&option.SSHConfig.KeyFile, "ssh-keyfile", "", "ssh 密钥")
+	cmd.Flags().StringVar(&option.SSHConfig.HostKeyCheck, "ssh-host-key-check", "", "ssh 主机密钥校验策略: strict(默认), tofu, insecure")
+	cmd.Flags().StringVar(&option.SSHConfig.KnownHosts, "ssh-known-hosts", "", "ssh known_hosts 文件, 默认 ~/.ssh/known_hosts 与 ~/.dbup/known_hosts")
+	cmd.Flags().Var(&option.SSHConfig.ProxyJump, "ssh-proxy-jump", "ssh 跳板机, 多个以逗号分隔, 格式: [user[:password]@]host[:port][?keyfile=/path/to/key]")
+	cmd.Flags().StringVar(&option.SSHConfig.Auth, "ssh-auth", "", "ssh 认证方式及尝试顺序, 逗号分隔: agent, publickey, password")
+	cmd.Flags().StringVar(&option.SSHConfig.PassphraseFile, "ssh-passphrase-file", "", "ssh 私钥密码文件, 也可以通过环境变量 DBUP_SSH_PASSPHRASE 指定")
 	cmd.Flags().StringVar(&option.Host, "host", "", "新节点IP")
 	cmd.Flags().BoolVar(&option.IPV6, "ipv6", false, "是否开启IPV6功能,默认不开启")
 	cmd.Flags().StringVar(&option.TmpDir, "tmp-dir", config.RedisClusterDeployTmpDir, "远程机器的临时目录")
@@ -111,7 +106,9 @@ func redisAddSlaveCmd() *cobra.Command {
 	cmd.Flags().StringVarP(&option.Parameter.Dir, "dir", "d", "", "redis安装目录, 默认: /opt/redis$PORT")
 	cmd.Flags().StringVarP(&option.Parameter.MemorySize, "memory-size", "m", "", "redis 数据库内存大小, 默认操作系统最大内存的四分之一")
 	cmd.Flags().IntVarP(&option.Parameter.Port, "port", "P", 0, "redis 数据库监听端口")
+	cmd.Flags().StringVar(&option.Parameter.Version, "version", "", "redis 大版本, 需要与主库保持一致, 默认: "+config.DefaultRedisVersion)
 	cmd.Flags().StringVar(&option.Parameter.ResourceLimit, "resource-limit", "", "资源限制清单, 通过执行 systemctl set-property 实现. 例: --resource-limit='MemoryLimit=512M CPUShares=500'")
+	cmd.Flags().BoolVar(&option.Parameter.Tune, "tune", false, "安装时按 redis 的内置配置调优操作系统(sysctl, 透明大页, limits.d, LimitNOFILE), 可以用 dbup tune revert 恢复")
 	cmd.Flags().BoolVarP(&option.NoRollback, "no-rollback", "n", false, "安装失败不回滚")
 	return cmd
 }
@@ -123,29 +120,8 @@ func redisUNInstallCmd() *cobra.Command {
 	cmd := &cobra.Command{
 		Use:   "uninstall",
 		Short: "redis 单机版卸载",
-		PreRunE: func(cmd *cobra.Command, args []string) error {
-			return environment.MustRoot()
-		},
 		RunE: func(cmd *cobra.Command, args []string) error {
-			if uninst.Port == 0 || uninst.BasePath == "" {
-				return fmt.Errorf("必须手动指定 --port and --dir 参数")
-			}
-
-			if !yes {
-				var s string
-				logger.Successf("端口: %d\n", uninst.Port)
-				logger.Successf("数据路径: %s\n", uninst.BasePath)
-				logger.Successf("是否确认卸载[y|n]:")
-				if _, err := fmt.Scanln(&s); err != nil {
-					return err
-				}
-				if strings.ToUpper(s) != "Y" && strings.ToUpper(s) != "YES" {
-					os.Exit(0)
-				}
-			}
-
-			rs := redis.NewRedis()
-			return rs.UNInstall(&uninst)
+			return dbup.RedisUninstall(cmd.Context(), &uninst, yes)
 		},
 	}
 	cmd.Flags().IntVarP(&uninst.Port, "port", "P", 0, "Redis 数据库监听端口")
@@ -164,8 +140,7 @@ func redisDeployCmd() *cobra.Command {
 			if config == "" {
 				return fmt.Errorf("请指定部署配置文件")
 			}
-			rs := redis.NewRedis()
-			return rs.Deploy(config)
+			return dbup.RedisDeploy(cmd.Context(), config)
 		},
 	}
 	cmd.Flags().StringVarP(&config, "config", "c", "", "安装配置文件")
@@ -183,8 +158,7 @@ func redisRemoveDeployCmd() *cobra.Command {
 			if config == "" {
 				return fmt.Errorf("请指定部署配置文件")
 			}
-			rs := redis.NewRedis()
-			return rs.RemoveDeploy(config, yes)
+			return dbup.RedisRemoveDeploy(cmd.Context(), config, yes)
 		},
 	}
 	cmd.Flags().StringVarP(&config, "config", "c", "", "安装配置文件")
@@ -199,8 +173,10 @@ func redisBackupCmd() *cobra.Command {
 		Use:   "backup",
 		Short: "redis 进行备份",
 		RunE: func(cmd *cobra.Command, args []string) error {
-			rs := redis.NewRedis()
-			return rs.Backup(backup)
+			if err := defaultCredential(cmd, credential.Name(config.Kinds, backup.Port), "", "", "password", credential.FieldPassword); err != nil {
+				return err
+			}
+			return dbup.RedisBackup(cmd.Context(), backup)
 		},
 	}
 	cmd.Flags().StringVarP(&backup.Password, "password", "p", "", "密码")
@@ -218,7 +194,10 @@ func RedisUPgradeCmd() *cobra.Command {
 		Use:   "upgrade",
 		Short: "redis 升级",
 		RunE: func(cmd *cobra.Command, args []string) error {
-			return upgrade.Run()
+			if err := defaultCredential(cmd, credential.Name(config.Kinds, upgrade.Port), "", "", "password", credential.FieldPassword); err != nil {
+				return err
+			}
+			return dbup.RedisUpgrade(cmd.Context(), upgrade)
 		},
 	}
 	cmd.Flags().StringVarP(&upgrade.Password, "password", "p", "", "旧版本实例密码")
@@ -6,6 +6,7 @@
 package cmd
 
 import (
+	"dbup/internal/credential"
 	"dbup/internal/redis"
 	"dbup/internal/redis/config"
 	"dbup/internal/redis/services"
@@ -50,6 +51,9 @@ func redisBackupTaskAddCmd() *cobra.Command {
 		Use:   "add",
 		Short: "redis 运行定时任务",
 		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := defaultCredential(cmd, credential.Name(config.Kinds, task.Backup.Port), "", "", "password", credential.FieldPassword); err != nil {
+				return err
+			}
 			pg := redis.NewRedis()
 			return pg.BackupTask("add", task)
 		},
@@ -91,6 +95,9 @@ func redisBackupTaskRunCmd() *cobra.Command {
 		Use:   "run",
 		Short: "redis 运行定时任务",
 		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := defaultCredential(cmd, credential.Name(config.Kinds, task.Backup.Port), "", "", "password", credential.FieldPassword); err != nil {
+				return err
+			}
 			pg := redis.NewRedis()
 			return pg.BackupTask("run", task)
 		},
@@ -9,6 +9,7 @@ import (
 	"dbup/internal/redis"
 	"dbup/internal/redis/config"
 	"dbup/internal/redis/services"
+	"dbup/pkg/dbup"
 	"errors"
 	"fmt"
 
@@ -39,6 +40,7 @@ func redisClusterCmd() *cobra.Command {
 // dbup redis-cluster deploy
 func redisClusterDeployCmd() *cobra.Command {
 	var config string
+	var dryRun bool
 	cmd := &cobra.Command{
 		Use:   "deploy",
 		Short: "redis cluster 集群部署",
@@ -46,11 +48,16 @@ func redisClusterDeployCmd() *cobra.Command {
 			if config == "" {
 				return fmt.Errorf("请指定部署配置文件")
 			}
-			rs := redis.NewRedis()
-			return rs.RedisClusterDeploy(config)
+			if dryRun {
+				// 存在冲突时只输出错误, 不打印用法
+				cmd.SilenceUsage = true
+				return dbup.RedisClusterDeployPlan(cmd.Context(), config)
+			}
+			return dbup.RedisClusterDeploy(cmd.Context(), config)
 		},
 	}
 	cmd.Flags().StringVarP(&config, "config", "c", "", "安装配置文件")
+	cmd.Flags().BoolVar(&dryRun, "plan", false, "只检查配置并只读连接各节点, 输出部署计划和冲突, 不做任何修改")
 	return cmd
 }
 
@@ -64,8 +71,7 @@ func redisClusterRemoveDeployCmd() *cobra.Command {
 			if config == "" {
 				return fmt.Errorf("请指定部署配置文件")
 			}
-			rs := redis.NewRedis()
-			return rs.RedisClusterRemoveDeploy(config, yes)
+			return dbup.RedisClusterRemoveDeploy(cmd.Context(), config, yes)
 		},
 	}
 	cmd.Flags().StringVarP(&config, "config", "c", "", "安装配置文件")
@@ -92,6 +98,11 @@ func redisClusterAddMasterCmd() *cobra.Command {
 	cmd.Flags().StringVar(&option.SSHConfig.Username, "ssh-username", "", "ssh 用户名")
 	cmd.Flags().StringVar(&option.SSHConfig.Password, "ssh-password", "", "ssh 密码")
 	cmd.Flags().StringVar(&option.SSHConfig.KeyFile, "ssh-keyfile", "", "ssh 密钥")
+	cmd.Flags().StringVar(&option.SSHConfig.HostKeyCheck, "ssh-host-key-check", "", "ssh 主机密钥校验策略: strict(默认), tofu, insecure")
+	cmd.Flags().StringVar(&option.SSHConfig.KnownHosts, "ssh-known-hosts", "", "ssh known_hosts 文件, 默认 ~/.ssh/known_hosts 与 ~/.dbup/known_hosts")
+	cmd.Flags().Var(&option.SSHConfig.ProxyJump, "ssh-proxy-jump", "ssh 跳板机, 多个以逗号分隔, 格式: [user[:password]@]host[:port][?keyfile=/path/to/key]")
+	cmd.Flags().StringVar(&option.SSHConfig.Auth, "ssh-auth", "", "ssh 认证方式及尝试顺序, 逗号分隔: agent, publickey, password")
+	cmd.Flags().StringVar(&option.SSHConfig.PassphraseFile, "ssh-passphrase-file", "", "ssh 私钥密码文件, 也可以通过环境变量 DBUP_SSH_PASSPHRASE 指定")
 	cmd.Flags().StringVar(&option.Host, "host", "", "新节点IP")
 	cmd.Flags().BoolVar(&option.IPV6, "ipv6", false, "是否开启IPV6功能,默认不开启")
 	cmd.Flags().StringVar(&option.Cluster, "cluster", "", "要加入的集群任意一节点的<IP:PORT>")
@@ -102,7 +113,9 @@ func redisClusterAddMasterCmd() *cobra.Command {
 	cmd.Flags().StringVarP(&option.Parameter.Dir, "dir", "d", "", "redis安装目录, 默认: /opt/redis$PORT")
 	cmd.Flags().StringVarP(&option.Parameter.MemorySize, "memory-size", "m", "", "redis 数据库内存大小, 默认操作系统最大内存的四分之一")
 	cmd.Flags().IntVarP(&option.Parameter.Port, "port", "P", 0, "redis 数据库监听端口")
+	cmd.Flags().StringVar(&option.Parameter.Version, "version", "", "redis 大版本, 需要与集群保持一致, 默认: "+config.DefaultRedisVersion)
 	cmd.Flags().StringVar(&option.Parameter.ResourceLimit, "resource-limit", "", "资源限制清单, 通过执行 systemctl set-property 实现. 例: --resource-limit='MemoryLimit=512M CPUShares=500'")
+	cmd.Flags().BoolVar(&option.Parameter.Tune, "tune", false, "安装时按 redis 的内置配置调优操作系统(sysctl, 透明大页, limits.d, LimitNOFILE), 可以用 dbup tune revert 恢复")
 	cmd.Flags().BoolVarP(&option.NoRollback, "no-rollback", "n", false, "安装失败不回滚")
 	return cmd
 }
@@ -126,6 +139,11 @@ func redisClusterAddSlaveCmd() *cobra.Command {
 	cmd.Flags().StringVar(&option.SSHConfig.Username, "ssh-username", "", "ssh 用户名")
 	cmd.Flags().StringVar(&option.SSHConfig.Password, "ssh-password", "", "ssh 密码")
 	cmd.Flags().StringVar(&option.SSHConfig.KeyFile, "ssh-keyfile", "", "ssh 密码")
+	cmd.Flags().StringVar(&option.SSHConfig.HostKeyCheck, "ssh-host-key-check", "", "ssh 主机密钥校验策略: strict(默认), tofu, insecure")
+	cmd.Flags().StringVar(&option.SSHConfig.KnownHosts, "ssh-known-hosts", "", "ssh known_hosts 文件, 默认 ~/.ssh/known_hosts 与 ~/.dbup/known_hosts")
+	cmd.Flags().Var(&option.SSHConfig.ProxyJump, "ssh-proxy-jump", "ssh 跳板机, 多个以逗号分隔, 格式: [user[:password]@]host[:port][?keyfile=/path/to/key]")
+	cmd.Flags().StringVar(&option.SSHConfig.Auth, "ssh-auth", "", "ssh 认证方式及尝试顺序, 逗号分隔: agent, publickey, password")
+	cmd.Flags().StringVar(&option.SSHConfig.PassphraseFile, "ssh-passphrase-file", "", "ssh 私钥密码文件, 也可以通过环境变量 DBUP_SSH_PASSPHRASE 指定")
 	cmd.Flags().StringVar(&option.Host, "host", "", "新节点IP")
 	cmd.Flags().BoolVar(&option.IPV6, "ipv6", false, "是否开启IPV6功能,默认不开启")
 	cmd.Flags().StringVar(&option.Cluster, "cluster", "", "要加入的集群任意一节点的<IP:PORT>")
@@ -137,7 +155,9 @@ func redisClusterAddSlaveCmd() *cobra.Command {
 	cmd.Flags().StringVarP(&option.Parameter.Dir, "dir", "d", "", "redis安装目录, 默认: /opt/redis$PORT")
 	cmd.Flags().StringVarP(&option.Parameter.MemorySize, "memory-size", "m", "", "redis 数据库内存大小, 默认操作系统最大内存的四分之一")
 	cmd.Flags().IntVarP(&option.Parameter.Port, "port", "P", 0, "redis 数据库监听端口")
+	cmd.Flags().StringVar(&option.Parameter.Version, "version", "", "redis 大版本, 需要与集群保持一致, 默认: "+config.DefaultRedisVersion)
 	cmd.Flags().StringVar(&option.Parameter.ResourceLimit, "resource-limit", "", "资源限制清单, 通过执行 systemctl set-property 实现. 例: --resource-limit='MemoryLimit=512M CPUShares=500'")
+	cmd.Flags().BoolVar(&option.Parameter.Tune, "tune", false, "安装时按 redis 的内置配置调优操作系统(sysctl, 透明大页, limits.d, LimitNOFILE), 可以用 dbup tune revert 恢复")
 	cmd.Flags().BoolVarP(&option.NoRollback, "no-rollback", "n", false, "安装失败不回滚")
 	return cmd
 }
@@ -6,13 +6,27 @@
 package cmd
 
 import (
+	"dbup/internal/credential"
 	"dbup/internal/environment"
+	"dbup/internal/output"
 	"dbup/internal/utils/logger"
+	"dbup/internal/utils/parallel"
+	"dbup/internal/utils/secretfile"
+	"dbup/internal/utils/sshutil"
+	"fmt"
+	"os"
 
+	"github.com/fatih/color"
 	"github.com/spf13/cobra"
 )
 
 var logFile string
+var insecureSkipHostKey bool
+var passwordFile string
+var secretsStdin bool
+var masterKeyFile string
+var outputFormat string
+var parallelHosts int
 
 var rootCmd = &cobra.Command{
 	Use:   "dbup",
@@ -21,6 +35,19 @@ var rootCmd = &cobra.Command{
 		if logFile != "" {
 			logger.SetLogFile(logFile)
 		}
+		if err := output.SetFormat(outputFormat); err != nil {
+			return err
+		}
+		if output.IsJSON() {
+			// 标准输出只保留结果文档
+			logger.SetOutput(color.Error)
+		}
+		sshutil.SetInsecureSkipHostKey(insecureSkipHostKey)
+		parallel.SetLimit(parallelHosts)
+		credential.SetMasterKeyFile(masterKeyFile)
+		if err := loadSecrets(cmd); err != nil {
+			return err
+		}
 		e, err := environment.NewEnvironment()
 		if err != nil {
 			return err
@@ -33,15 +60,67 @@ var rootCmd = &cobra.Command{
 	},
 }
 
+// loadSecrets 从 --password-file 或标准输入读取密码, 设置到对应的命令行参数上
+func loadSecrets(cmd *cobra.Command) error {
+	if passwordFile == "" && !secretsStdin {
+		return nil
+	}
+	if passwordFile != "" && secretsStdin {
+		return output.Errorf(output.CodeInvalidArgument, "--password-file 和 --secrets-stdin 不能同时使用")
+	}
+
+	var secrets map[string]string
+	var err error
+	if passwordFile != "" {
+		secrets, err = secretfile.Load(passwordFile)
+	} else {
+		secrets, err = secretfile.Read(os.Stdin)
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := secretfile.SetFlags(cmd.Flags(), secrets); err != nil {
+		return output.Errorf(output.CodeInvalidArgument, "命令 %s: %v", cmd.CommandPath(), err)
+	}
+	return nil
+}
+
 func Execute() {
-	if err := rootCmd.Execute(); err != nil {
+	cmd, err := rootCmd.ExecuteC()
+	if err != nil && outputFormat == output.JSON {
+		// 参数解析失败时没有执行 PersistentPreRunE, 仍然按 json 格式输出错误
+		output.SetFormat(outputFormat)
+	}
+	if output.IsJSON() {
+		output.Print(cmd.CommandPath(), err)
+		if err != nil {
+			os.Exit(1)
+		}
+		return
+	}
+	if output.Code(err) == output.CodeCanceled {
+		// 交互确认时选择不继续, 不作为错误
+		return
+	}
+	if err != nil {
 		logger.Errorf("%v\n", err)
-		//os.Exit(1)
+		os.Exit(1)
 	}
 }
 
 func init() {
 	rootCmd.PersistentFlags().StringVar(&logFile, "log", "", "标准输出写入日志文件")
+	rootCmd.PersistentFlags().BoolVar(&insecureSkipHostKey, "insecure-skip-host-key", false, "跳过 ssh 主机密钥校验(存在中间人攻击风险, 仅用于测试环境)")
+	rootCmd.PersistentFlags().StringVar(&passwordFile, "password-file", "", "从文件读取密码, 文件内容为 json 对象时按 key 设置同名参数, 否则作为 --password 的值")
+	rootCmd.PersistentFlags().BoolVar(&secretsStdin, "secrets-stdin", false, "从标准输入读取密码, 格式同 --password-file")
+	rootCmd.PersistentFlags().StringVar(&outputFormat, "output", output.Text, "输出格式: text, json. json 格式时提示信息输出到标准错误输出, 标准输出只打印结果文档")
+	rootCmd.PersistentFlags().IntVar(&parallelHosts, "parallel", parallel.DefaultLimit, "集群部署时同时操作的节点数, 1 为按顺序执行")
+	rootCmd.PersistentFlags().StringVar(&masterKeyFile, "master-key-file", "", fmt.Sprintf("凭据库主密钥文件, 默认为 ~/.dbup/%s, 也可以通过环境变量 %s 或 %s 指定", credential.MasterKeyFile, credential.MasterKeyFileEnv, credential.MasterKeyEnv))
+
+	rootCmd.SetFlagErrorFunc(func(cmd *cobra.Command, err error) error {
+		return output.Errorf(output.CodeInvalidArgument, "%v", err)
+	})
 
 	// 装载子命令
 	rootCmd.AddCommand(
@@ -53,5 +132,33 @@ func init() {
 		mongodbCmd(),
 		prometheusCmd(),
 		mariadbCmd(),
+		secretCmd(),
+		inventoryCmd(),
+		deployCmd(),
+		serveCmd(),
+		packageCmd(),
+		checkCmd(),
+		tuneCmd(),
+		configCmd(),
 	)
+	silenceCanceled(rootCmd)
+}
+
+// silenceCanceled 交互确认时选择不继续, cobra 不打印错误和用法
+func silenceCanceled(c *cobra.Command) {
+	for _, sub := range c.Commands() {
+		silenceCanceled(sub)
+	}
+	if c.RunE == nil {
+		return
+	}
+	runE := c.RunE
+	c.RunE = func(cmd *cobra.Command, args []string) error {
+		err := runE(cmd, args)
+		if err != nil && output.Code(err) == output.CodeCanceled {
+			cmd.SilenceErrors = true
+			cmd.SilenceUsage = true
+		}
+		return err
+	}
 }
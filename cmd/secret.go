@@ -0,0 +1,248 @@
+package cmd
+
+import (
+	"bufio"
+	"dbup/internal/credential"
+	"dbup/internal/environment"
+	"dbup/internal/output"
+	"dbup/internal/utils/logger"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"golang.org/x/crypto/ssh/terminal"
+)
+
+// dbup secret
+func secretCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "secret",
+		Short: "凭据库管理, 安装时生成的密码加密保存在凭据库中",
+	}
+	// 装载命令
+	cmd.AddCommand(
+		secretGetCmd(),
+		secretSetCmd(),
+		secretRotateCmd(),
+		secretMigrateCmd(),
+	)
+	return cmd
+}
+
+// dbup secret get
+func secretGetCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "get [name] [field]",
+		Short: "查看凭据, 不指定实例名称时列出所有实例, 不指定字段时列出实例的所有字段",
+		Args:  cobra.MaximumNArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			store, err := credential.OpenDefault(false)
+			if err != nil {
+				return err
+			}
+			if len(args) == 0 {
+				for _, name := range store.Names() {
+					output.Item("names", name, "%s\n", name)
+				}
+				return nil
+			}
+
+			name := args[0]
+			if len(args) == 2 {
+				v, ok := store.Get(name, args[1])
+				if !ok {
+					return output.Errorf(output.CodeNotFound, "凭据库中没有 %s 的 %s", name, args[1])
+				}
+				if output.IsJSON() {
+					output.Set(args[1], v)
+					return nil
+				}
+				fmt.Println(v)
+				return nil
+			}
+
+			entry := store.Entry(name)
+			if len(entry) == 0 {
+				return output.Errorf(output.CodeNotFound, "凭据库中没有 %s", name)
+			}
+			if output.IsJSON() {
+				output.Set(name, entry)
+				return nil
+			}
+			var fields []string
+			for field := range entry {
+				fields = append(fields, field)
+			}
+			sort.Strings(fields)
+			for _, field := range fields {
+				fmt.Printf("%s=%s\n", field, entry[field])
+			}
+			return nil
+		},
+	}
+	return cmd
+}
+
+// dbup secret set
+func secretSetCmd() *cobra.Command {
+	var valueFile string
+	cmd := &cobra.Command{
+		Use:   "set <name> <field>",
+		Short: "设置凭据, 值从 --value-file、标准输入或终端输入读取, 不通过命令行参数传递",
+		Args:  cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			value, err := readSecretValue(valueFile, args[0], args[1])
+			if err != nil {
+				return err
+			}
+			store, err := credential.OpenDefault(true)
+			if err != nil {
+				return err
+			}
+			store.Set(args[0], args[1], value)
+			if err := store.Save(); err != nil {
+				return err
+			}
+			logger.Successf("设置 %s 的 %s 成功\n", args[0], args[1])
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&valueFile, "value-file", "", "从文件读取凭据的值")
+	return cmd
+}
+
+// dbup secret rotate
+func secretRotateCmd() *cobra.Command {
+	var newKeyFile string
+	cmd := &cobra.Command{
+		Use:   "rotate",
+		Short: "更换主密钥并重新加密凭据库",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			dir := environment.GlobalEnv().DbupInfoPath
+			store, err := credential.Open(dir, true)
+			if err != nil {
+				return err
+			}
+			old, err := credential.LoadMasterKey(dir, false)
+			if err != nil {
+				return err
+			}
+
+			var key *credential.MasterKey
+			if newKeyFile != "" {
+				b, err := ioutil.ReadFile(newKeyFile)
+				if err != nil {
+					return fmt.Errorf("读取新的主密钥文件 %s 失败: %v", newKeyFile, err)
+				}
+				k := strings.TrimRight(string(b), "\r\n")
+				if k == "" {
+					return fmt.Errorf("新的主密钥文件 %s 内容为空", newKeyFile)
+				}
+				key = &credential.MasterKey{Key: []byte(k), File: newKeyFile}
+			} else {
+				// 没有指定新的主密钥时, 生成随机密钥替换原主密钥文件
+				if old.File == "" {
+					return fmt.Errorf("主密钥来自环境变量 %s, 请通过 --new-key-file 指定新的主密钥", credential.MasterKeyEnv)
+				}
+				if key, err = credential.GenerateMasterKey(old.File + ".new"); err != nil {
+					return err
+				}
+			}
+
+			if err := store.Rotate(key); err != nil {
+				return err
+			}
+			if newKeyFile == "" {
+				if err := os.Rename(key.File, old.File); err != nil {
+					return fmt.Errorf("凭据库已使用新的主密钥 %s 加密, 但替换主密钥文件 %s 失败: %v", key.File, old.File, err)
+				}
+				logger.Successf("更换主密钥成功, 新的主密钥已写入 %s\n", old.File)
+				return nil
+			}
+			logger.Successf("更换主密钥成功, 之后请使用 --master-key-file=%s 或环境变量 %s 指定主密钥\n", newKeyFile, credential.MasterKeyFileEnv)
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&newKeyFile, "new-key-file", "", "新的主密钥文件, 不指定时随机生成并替换原主密钥文件")
+	return cmd
+}
+
+// dbup secret migrate
+func secretMigrateCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "migrate",
+		Short: "将 info 文件中明文保存的密码迁移到凭据库",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			names, err := credential.Migrate(environment.GlobalEnv().DbupInfoPath)
+			for _, name := range names {
+				logger.Successf("迁移 %s 成功\n", name)
+				output.Append("migrated", name)
+			}
+			if err != nil {
+				return err
+			}
+			if len(names) == 0 {
+				logger.Infof("没有需要迁移的 info 文件\n")
+			}
+			return nil
+		},
+	}
+	return cmd
+}
+
+// readSecretValue 依次从文件, 终端输入, 标准输入读取凭据的值
+func readSecretValue(file, name, field string) (string, error) {
+	if file != "" {
+		b, err := ioutil.ReadFile(file)
+		if err != nil {
+			return "", fmt.Errorf("读取文件 %s 失败: %v", file, err)
+		}
+		return strings.TrimRight(string(b), "\r\n"), nil
+	}
+	if terminal.IsTerminal(int(os.Stdin.Fd())) {
+		fmt.Fprintf(os.Stderr, "请输入 %s 的 %s: ", name, field)
+		b, err := terminal.ReadPassword(int(os.Stdin.Fd()))
+		fmt.Fprintln(os.Stderr)
+		if err != nil {
+			return "", err
+		}
+		return string(b), nil
+	}
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && line == "" {
+		return "", fmt.Errorf("从标准输入读取失败: %v", err)
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+// defaultCredential 未指定密码参数时, 从凭据库中读取实例 name 的密码.
+// userFlag 不为空时, 未指定用户名则同时使用凭据库中的用户名; 指定的用户名与凭据库中的不一致时不读取密码
+func defaultCredential(cmd *cobra.Command, name, userFlag, userField, passwordFlag, passwordField string) error {
+	if cmd.Flags().Changed(passwordFlag) {
+		return nil
+	}
+	store, err := credential.OpenDefault(false)
+	if err != nil {
+		return err
+	}
+	password, ok := store.Get(name, passwordField)
+	if !ok {
+		return nil
+	}
+	if userFlag != "" {
+		if user, ok := store.Get(name, userField); ok {
+			if !cmd.Flags().Changed(userFlag) {
+				if err := cmd.Flags().Set(userFlag, user); err != nil {
+					return err
+				}
+			} else if cmd.Flags().Lookup(userFlag).Value.String() != user {
+				return nil
+			}
+		}
+	}
+	logger.Infof("使用凭据库中 %s 的 %s\n", name, passwordField)
+	return cmd.Flags().Set(passwordFlag, password)
+}
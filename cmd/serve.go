@@ -0,0 +1,67 @@
+package cmd
+
+import (
+	"context"
+	"dbup/internal/output"
+	"dbup/internal/server"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	"github.com/spf13/cobra"
+)
+
+// 未指定 --token-file 时从环境变量读取 token
+const serveTokenEnv = "DBUP_SERVE_TOKEN"
+
+// dbup serve
+func serveCmd() *cobra.Command {
+	var cfg server.Config
+	var tokenFile string
+	cmd := &cobra.Command{
+		Use:   "serve",
+		Short: "以 HTTP API 的方式提交安装、部署、备份、添加从库和升级任务",
+		Long: `以 HTTP API 的方式提交安装、部署、备份、添加从库和升级任务, 任务在后台依次执行.
+认证方式为静态 token(--token-file 或环境变量 ` + serveTokenEnv + `) 或 mTLS 客户端证书(--client-ca), 至少需要指定一种.
+提交任务即视为确认, 不会再交互确认.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cfg.Token = os.Getenv(serveTokenEnv)
+			if tokenFile != "" {
+				b, err := ioutil.ReadFile(tokenFile)
+				if err != nil {
+					return output.Errorf(output.CodeInvalidArgument, "读取 token 文件失败: %v", err)
+				}
+				cfg.Token = strings.TrimSpace(string(b))
+				if cfg.Token == "" {
+					return output.Errorf(output.CodeInvalidArgument, "token 文件 %s 为空", tokenFile)
+				}
+			}
+			srv, err := server.New(cfg)
+			if err != nil {
+				return err
+			}
+
+			ctx, cancel := context.WithCancel(cmd.Context())
+			defer cancel()
+			sig := make(chan os.Signal, 1)
+			signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
+			defer signal.Stop(sig)
+			go func() {
+				select {
+				case <-sig:
+					cancel()
+				case <-ctx.Done():
+				}
+			}()
+			return srv.Serve(ctx)
+		},
+	}
+	cmd.Flags().StringVarP(&cfg.Listen, "listen", "l", "127.0.0.1:8700", "监听地址")
+	cmd.Flags().StringVarP(&tokenFile, "token-file", "", "", "保存 token 的文件, 请求时使用 Authorization: Bearer <token>")
+	cmd.Flags().StringVarP(&cfg.CertFile, "cert", "", "", "服务端证书, 指定后使用 https")
+	cmd.Flags().StringVarP(&cfg.KeyFile, "key", "", "", "服务端证书私钥")
+	cmd.Flags().StringVarP(&cfg.ClientCA, "client-ca", "", "", "客户端证书的 CA 证书, 指定后要求客户端提供证书(mTLS)")
+	return cmd
+}
@@ -0,0 +1,64 @@
+package cmd
+
+import (
+	"dbup/internal/tune"
+	"dbup/pkg/dbup"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+// dbup tune
+func tuneCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "tune",
+		Short: "按引擎调优操作系统参数",
+	}
+	// 装载命令
+	cmd.AddCommand(
+		tuneApplyCmd(),
+		tuneRevertCmd(),
+	)
+	return cmd
+}
+
+// dbup tune apply
+func tuneApplyCmd() *cobra.Command {
+	var o tune.Options
+	cmd := &cobra.Command{
+		Use:   "apply",
+		Short: "按引擎的内置配置设置 sysctl、透明大页、limits.d 和 systemd LimitNOFILE, 重启后仍然生效",
+		Long: `按引擎的内置配置调优本机:
+  pgsql:   vm.swappiness, kernel.sem(信号量), 按 --memory-size 预留大页, 关闭透明大页
+  redis:   vm.overcommit_memory=1, vm.swappiness, net.core.somaxconn, 关闭透明大页
+  mariadb: vm.swappiness, fs.aio-max-nr, net.core.somaxconn
+  mongodb: vm.swappiness, vm.max_map_count, net.core.somaxconn, 关闭透明大页
+同时写入 limits.d 的 nofile/nproc, 并为引擎的所有实例(或 --service 指定的服务)设置 LimitNOFILE 和 LimitNPROC.
+修改前的值记录在 ~/.dbup/tune 下, 可以用 dbup tune revert 恢复.`,
+		Example: `  dbup tune apply --engine redis
+  dbup tune apply --engine pgsql --memory-size 8GB --service postgres5432.service`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return dbup.TuneApply(cmd.Context(), o)
+		},
+	}
+	cmd.Flags().StringVarP(&o.Engine, "engine", "e", "", "引擎: "+strings.Join(tune.Engines(), ", "))
+	cmd.Flags().StringSliceVarP(&o.Services, "service", "s", nil, "设置 LimitNOFILE 的服务, 多个用逗号分隔, 默认为本机上该引擎的所有实例")
+	cmd.Flags().StringVarP(&o.MemorySize, "memory-size", "m", "", "pgsql 的 shared_buffers 大小, 按该大小预留大页, 单位后缀可以为{MB,GB}, 默认不预留")
+	_ = cmd.MarkFlagRequired("engine")
+	return cmd
+}
+
+// dbup tune revert
+func tuneRevertCmd() *cobra.Command {
+	var engine string
+	cmd := &cobra.Command{
+		Use:   "revert",
+		Short: "删除 dbup tune apply 生成的配置文件, 恢复调优前的内核参数和透明大页设置",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return dbup.TuneRevert(cmd.Context(), engine)
+		},
+	}
+	cmd.Flags().StringVarP(&engine, "engine", "e", "", "引擎: "+strings.Join(tune.Engines(), ", "))
+	_ = cmd.MarkFlagRequired("engine")
+	return cmd
+}
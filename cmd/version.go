@@ -6,6 +6,7 @@
 package cmd
 
 import (
+	"dbup/internal/output"
 	"fmt"
 
 	"github.com/spf13/cobra"
@@ -20,6 +21,10 @@ func versionCmd() *cobra.Command {
 		Use:   "version",
 		Short: "dbup 的版本",
 		Run: func(cmd *cobra.Command, args []string) {
+			if output.IsJSON() {
+				output.Set("version", _version)
+				return
+			}
 			fmt.Printf("dbup version %s\n", _version)
 		},
 	}
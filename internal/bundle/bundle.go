@@ -0,0 +1,480 @@
+package bundle
+
+// 离线安装包: 将各引擎的安装包、systemd 模板和 dbuplib 打包为一个 tar.gz, 解压后每个架构一个目录,
+// 目录结构与 dbup 的安装目录相同(bin, package/<类型>/*.tar.gz, systemd), 可以放在任意路径下使用.
+// package 目录下生成旧的 md5 文件和 sha256 清单, 指定私钥时同时生成清单签名; 根目录下的 bundle.json 记录包含的内容
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/ed25519"
+	"crypto/md5"
+	"crypto/sha256"
+	"dbup/internal/global"
+	"dbup/internal/utils"
+	"debug/elf"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"gopkg.in/ini.v1"
+)
+
+// MetaFile 离线安装包根目录下的说明文件
+const MetaFile = "bundle.json"
+
+// Package 离线安装包中的一个引擎安装包
+type Package struct {
+	Arch    string `json:"arch"`
+	Kind    string `json:"kind"`
+	Name    string `json:"name"`
+	Version string `json:"version,omitempty"`
+	Size    int64  `json:"size"`
+	Sha256  string `json:"sha256"`
+}
+
+// Meta 离线安装包说明
+type Meta struct {
+	Version   string              `json:"version"`
+	CreatedAt time.Time           `json:"created_at"`
+	Arches    []string            `json:"arches"`
+	Engines   map[string][]string `json:"engines"`
+	Signed    bool                `json:"signed"`
+	Packages  []Package           `json:"packages"`
+}
+
+// Options 生成离线安装包的参数
+type Options struct {
+	Source  string              // 安装包来源: oasis 仓库目录(包含 oasis-linux-<arch>)或 dbup 安装目录(包含 package)
+	Version string              // 离线安装包版本
+	Arches  []string            // 架构, 如 amd64, arm64
+	Engines map[string][]string // 引擎类型及版本, 版本为空时包含该类型的所有安装包
+	Out     string              // 生成的文件, 默认为 dbup-<version>.tar.gz
+	Key     ed25519.PrivateKey  // 清单签名私钥, 为空时不签名
+}
+
+// ParseEngines 解析 <类型>[=<版本>,<版本>] 格式的引擎参数
+func ParseEngines(values []string) (map[string][]string, error) {
+	engines := make(map[string][]string)
+	for _, v := range values {
+		kind, versions := v, ""
+		if i := strings.Index(v, "="); i >= 0 {
+			kind, versions = v[:i], v[i+1:]
+		}
+		kind = strings.TrimSpace(kind)
+		if kind == "" {
+			return nil, fmt.Errorf("引擎参数 %q 格式错误, 应为 <类型>[=<版本>,<版本>]", v)
+		}
+		list := engines[kind]
+		for _, ver := range strings.Split(versions, ",") {
+			if ver = strings.TrimSpace(ver); ver != "" {
+				list = append(list, ver)
+			}
+		}
+		engines[kind] = list
+	}
+	return engines, nil
+}
+
+// sourceDir 返回架构对应的来源目录.
+// 来源是 dbup 安装目录时只有一个架构, 其中的 bin/dbup 与要打包的架构不同时报错, 避免把其他架构的程序放进离线安装包
+func sourceDir(source, arch string) (string, error) {
+	dir := filepath.Join(source, fmt.Sprintf(global.PlatformDirFormat, arch))
+	if utils.IsDir(filepath.Join(dir, "package")) {
+		return dir, nil
+	}
+	if utils.IsDir(filepath.Join(source, "package")) {
+		bin := filepath.Join(source, "bin", "dbup")
+		if !utils.IsExists(bin) {
+			return source, nil
+		}
+		binArch, err := binaryArch(bin)
+		if err != nil {
+			return "", err
+		}
+		if binArch != arch {
+			return "", fmt.Errorf("%s 是 %s 架构的安装目录, 没有找到 %s 架构的安装包目录 %s", source, binArch, arch, dir)
+		}
+		return source, nil
+	}
+	return "", fmt.Errorf("在 %s 下没有找到 %s 架构的安装包目录", source, arch)
+}
+
+// binaryArch 读取 ELF 文件头, 返回程序的架构
+func binaryArch(file string) (string, error) {
+	f, err := elf.Open(file)
+	if err != nil {
+		return "", fmt.Errorf("读取 %s 失败: %v", file, err)
+	}
+	defer f.Close()
+	switch f.Machine {
+	case elf.EM_X86_64:
+		return "amd64", nil
+	case elf.EM_AARCH64:
+		return "arm64", nil
+	case elf.EM_386:
+		return "386", nil
+	case elf.EM_S390:
+		return "s390x", nil
+	case elf.EM_PPC64:
+		if f.ByteOrder == binary.LittleEndian {
+			return "ppc64le", nil
+		}
+		return "ppc64", nil
+	}
+	return "", fmt.Errorf("无法识别 %s 的架构: %v", file, f.Machine)
+}
+
+// parseName 从安装包文件名中解析版本, 文件名格式为 <类型><版本>[_-]linux[_-]<架构>.tar.gz;
+// 名称与类型不同的安装包(如 prometheus 下的各组件)返回组件名称
+func parseName(kind, name, arch string) (string, bool) {
+	base := strings.TrimSuffix(name, ".tar.gz")
+	for _, suffix := range []string{"_linux_" + arch, "-linux-" + arch} {
+		if strings.HasSuffix(base, suffix) {
+			prefix := strings.TrimSuffix(base, suffix)
+			if strings.HasPrefix(prefix, kind) && prefix != kind {
+				return strings.Trim(strings.TrimPrefix(prefix, kind), "_-"), true
+			}
+			return prefix, true
+		}
+	}
+	return "", false
+}
+
+// selectPackages 选择来源目录中符合架构和版本的安装包, 指定的版本不存在时报错
+func selectPackages(dir, kind, arch string, versions []string) ([]Package, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "package", kind, "*.tar.gz"))
+	if err != nil {
+		return nil, err
+	}
+	found := make(map[string]bool)
+	var pkgs []Package
+	for _, f := range files {
+		ver, ok := parseName(kind, filepath.Base(f), arch)
+		if !ok {
+			continue
+		}
+		if len(versions) > 0 && !contains(versions, ver) {
+			continue
+		}
+		found[ver] = true
+		pkgs = append(pkgs, Package{Arch: arch, Kind: kind, Name: filepath.Base(f), Version: ver})
+	}
+	for _, v := range versions {
+		if !found[v] {
+			return nil, fmt.Errorf("在 %s 中没有找到 %s %s 的 %s 安装包", filepath.Join(dir, "package", kind), kind, v, arch)
+		}
+	}
+	if len(pkgs) == 0 {
+		return nil, fmt.Errorf("在 %s 中没有找到 %s 的 %s 安装包", filepath.Join(dir, "package", kind), kind, arch)
+	}
+	return pkgs, nil
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// Build 生成离线安装包, 返回包含的内容
+func Build(o Options) (*Meta, error) {
+	if o.Version == "" {
+		return nil, fmt.Errorf("必须指定离线安装包版本")
+	}
+	if len(o.Arches) == 0 {
+		return nil, fmt.Errorf("必须指定至少一种架构")
+	}
+	if len(o.Engines) == 0 {
+		return nil, fmt.Errorf("必须指定至少一种引擎")
+	}
+	if o.Out == "" {
+		o.Out = fmt.Sprintf("dbup-%s.tar.gz", o.Version)
+	}
+	root := strings.TrimSuffix(filepath.Base(o.Out), ".tar.gz")
+
+	f, err := os.Create(o.Out)
+	if err != nil {
+		return nil, err
+	}
+	w := newWriter(f)
+	meta, err := build(w, root, o)
+	if err == nil {
+		err = w.Close()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(o.Out)
+		return nil, err
+	}
+	return meta, nil
+}
+
+func build(w *writer, root string, o Options) (*Meta, error) {
+	meta := &Meta{
+		Version:   o.Version,
+		CreatedAt: time.Now(),
+		Arches:    o.Arches,
+		Engines:   o.Engines,
+		Signed:    o.Key != nil,
+	}
+	kinds := make([]string, 0, len(o.Engines))
+	for kind := range o.Engines {
+		kinds = append(kinds, kind)
+	}
+	sort.Strings(kinds)
+
+	for _, arch := range o.Arches {
+		src, err := sourceDir(o.Source, arch)
+		if err != nil {
+			return nil, err
+		}
+		dst := path.Join(root, fmt.Sprintf(global.PlatformDirFormat, arch))
+
+		var pkgs []Package
+		for _, kind := range kinds {
+			list, err := selectPackages(src, kind, arch, o.Engines[kind])
+			if err != nil {
+				return nil, err
+			}
+			pkgs = append(pkgs, list...)
+		}
+		// dbuplib 是安装时依赖的公共库
+		if _, ok := o.Engines[global.DbuplibName]; !ok {
+			lib := fmt.Sprintf(global.DbuplibPackageName, "linux", arch)
+			if utils.IsExists(filepath.Join(src, "package", global.DbuplibName, lib)) {
+				pkgs = append(pkgs, Package{Arch: arch, Kind: global.DbuplibName, Name: lib})
+			}
+		}
+
+		manifest := &global.Manifest{Digests: make(map[string]string)}
+		md5s := make(map[string]string)
+		for i := range pkgs {
+			p := &pkgs[i]
+			sums, err := w.addFile(filepath.Join(src, "package", p.Kind, p.Name), path.Join(dst, "package", p.Kind, p.Name))
+			if err != nil {
+				return nil, err
+			}
+			p.Size, p.Sha256 = sums.size, sums.sha256
+			manifest.Digests[path.Join(p.Kind, p.Name)] = sums.sha256
+			md5s[path.Join(p.Kind, p.Name)] = utils.CheckMd5sumByByte([]byte(global.Salt + sums.md5 + "\n"))
+		}
+		meta.Packages = append(meta.Packages, pkgs...)
+
+		md5File, err := legacyMd5(filepath.Join(src, "package", global.Md5FileName), md5s)
+		if err != nil {
+			return nil, err
+		}
+		if err := w.addBytes(md5File, path.Join(dst, "package", global.Md5FileName)); err != nil {
+			return nil, err
+		}
+		if err := w.addBytes(manifest.Bytes(), path.Join(dst, "package", global.ManifestFileName)); err != nil {
+			return nil, err
+		}
+		if o.Key != nil {
+			if err := w.addBytes(manifest.Sign(o.Key), path.Join(dst, "package", global.SignatureFileName)); err != nil {
+				return nil, err
+			}
+		}
+
+		for _, dir := range []string{"systemd", "bin"} {
+			files, err := ioutil.ReadDir(filepath.Join(src, dir))
+			if os.IsNotExist(err) {
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+			for _, fi := range files {
+				if fi.Mode().IsRegular() {
+					if _, err := w.addFile(filepath.Join(src, dir, fi.Name()), path.Join(dst, dir, fi.Name())); err != nil {
+						return nil, err
+					}
+				}
+			}
+		}
+	}
+
+	data, err := json.MarshalIndent(meta, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return meta, w.addBytes(append(data, '\n'), path.Join(root, MetaFile))
+}
+
+// legacyMd5 生成旧格式的 md5 文件: 保留来源 md5 文件中的其他配置(如 mongodb 的 key_file), 安装包的 md5 按包含的安装包重新生成
+func legacyMd5(source string, md5s map[string]string) ([]byte, error) {
+	cfg := ini.Empty()
+	if utils.IsExists(source) {
+		var err error
+		if cfg, err = ini.LoadSources(ini.LoadOptions{SpaceBeforeInlineComment: true}, source); err != nil {
+			return nil, fmt.Errorf("读取md5文件失败: %v", err)
+		}
+	}
+	for _, s := range cfg.Sections() {
+		for _, k := range s.KeyStrings() {
+			if strings.HasSuffix(k, ".tar.gz") {
+				s.DeleteKey(k)
+			}
+		}
+	}
+	for name, sum := range md5s {
+		kind, file := path.Split(name)
+		cfg.Section(strings.TrimSuffix(kind, "/")).Key(file).SetValue(sum)
+	}
+	var b bytes.Buffer
+	if _, err := cfg.WriteTo(&b); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+// writer 写入 tar.gz
+type writer struct {
+	gz *gzip.Writer
+	tw *tar.Writer
+}
+
+type fileSums struct {
+	size   int64
+	sha256 string
+	md5    string
+}
+
+func newWriter(w io.Writer) *writer {
+	gz := gzip.NewWriter(w)
+	return &writer{gz: gz, tw: tar.NewWriter(gz)}
+}
+
+func (w *writer) addFile(src, name string) (fileSums, error) {
+	f, err := os.Open(src)
+	if err != nil {
+		return fileSums{}, err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return fileSums{}, err
+	}
+	hdr := &tar.Header{Name: name, Mode: int64(fi.Mode().Perm()), Size: fi.Size(), ModTime: fi.ModTime(), Typeflag: tar.TypeReg}
+	if err := w.tw.WriteHeader(hdr); err != nil {
+		return fileSums{}, err
+	}
+	s, m := sha256.New(), md5.New()
+	n, err := io.Copy(io.MultiWriter(w.tw, s, m), f)
+	if err != nil {
+		return fileSums{}, fmt.Errorf("打包文件 %s 失败: %v", src, err)
+	}
+	return fileSums{size: n, sha256: hex.EncodeToString(s.Sum(nil)), md5: hex.EncodeToString(m.Sum(nil))}, nil
+}
+
+func (w *writer) addBytes(data []byte, name string) error {
+	hdr := &tar.Header{Name: name, Mode: 0644, Size: int64(len(data)), ModTime: time.Now(), Typeflag: tar.TypeReg}
+	if err := w.tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	_, err := w.tw.Write(data)
+	return err
+}
+
+func (w *writer) Close() error {
+	if err := w.tw.Close(); err != nil {
+		return err
+	}
+	return w.gz.Close()
+}
+
+// Entry 离线安装包中的一个文件
+type Entry struct {
+	Name   string `json:"name"`
+	Size   int64  `json:"size"`
+	Sha256 string `json:"sha256"`
+}
+
+// Content 离线安装包的内容
+type Content struct {
+	Meta    *Meta   `json:"meta"`
+	Entries []Entry `json:"entries"`
+	// 与 bundle.json 中记录的 sha256 不一致或缺少的安装包
+	Problems []string `json:"problems,omitempty"`
+}
+
+// Inspect 读取离线安装包, 列出包含的文件, 并按 bundle.json 校验每个安装包
+func Inspect(file string) (*Content, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s 不是 tar.gz 文件: %v", file, err)
+	}
+	defer gz.Close()
+
+	c := &Content{}
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("读取 %s 失败: %v", file, err)
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		if path.Base(hdr.Name) == MetaFile && strings.Count(hdr.Name, "/") == 1 {
+			var m Meta
+			if err := json.NewDecoder(tr).Decode(&m); err != nil {
+				return nil, fmt.Errorf("解析 %s 失败: %v", hdr.Name, err)
+			}
+			c.Meta = &m
+			continue
+		}
+		s := sha256.New()
+		n, err := io.Copy(s, tr)
+		if err != nil {
+			return nil, fmt.Errorf("读取 %s 失败: %v", hdr.Name, err)
+		}
+		c.Entries = append(c.Entries, Entry{Name: hdr.Name, Size: n, Sha256: hex.EncodeToString(s.Sum(nil))})
+	}
+	if c.Meta == nil {
+		return nil, fmt.Errorf("%s 中没有 %s, 不是 dbup 离线安装包", file, MetaFile)
+	}
+
+	digests := make(map[string]string)
+	for _, e := range c.Entries {
+		// 去掉根目录
+		if i := strings.Index(e.Name, "/"); i >= 0 {
+			digests[e.Name[i+1:]] = e.Sha256
+		}
+	}
+	for _, p := range c.Meta.Packages {
+		name := path.Join(fmt.Sprintf(global.PlatformDirFormat, p.Arch), "package", p.Kind, p.Name)
+		got, ok := digests[name]
+		switch {
+		case !ok:
+			c.Problems = append(c.Problems, fmt.Sprintf("缺少安装包 %s", name))
+		case got != p.Sha256:
+			c.Problems = append(c.Problems, fmt.Sprintf("安装包 %s 的 sha256 值为 %s, 应为 %s", name, got, p.Sha256))
+		}
+	}
+	return c, nil
+}
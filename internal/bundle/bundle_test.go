@@ -0,0 +1,95 @@
+package bundle
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func TestBuildInspect(t *testing.T) {
+	src := t.TempDir()
+	files := map[string]string{
+		"oasis-linux-amd64/package/pgsql/pgsql12_linux_amd64.tar.gz":          "pgsql",
+		"oasis-linux-amd64/package/mariadb/mariadb10.6.8-linux-amd64.tar.gz":  "10.6.8",
+		"oasis-linux-amd64/package/mariadb/mariadb10.11.8-linux-amd64.tar.gz": "10.11.8",
+		"oasis-linux-amd64/package/dbuplib/dbuplib_linux_amd64.tar.gz":        "dbuplib",
+		"oasis-linux-amd64/systemd/postgres.service.template":                 "systemd",
+		"oasis-linux-arm64/package/mariadb/mariadb10.11.8-linux-arm64.tar.gz": "arm64",
+	}
+	for name, content := range files {
+		file := filepath.Join(src, name)
+		if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(file, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	engines, err := ParseEngines([]string{"mariadb=10.11.8", "pgsql"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := filepath.Join(t.TempDir(), "dbup-v1.tar.gz")
+	if _, err := Build(Options{Source: src, Version: "v1", Arches: []string{"amd64", "arm64"}, Engines: engines, Out: out}); err == nil {
+		t.Fatal("arm64 没有 pgsql 安装包时应该报错")
+	}
+	if _, err := os.Stat(out); !os.IsNotExist(err) {
+		t.Fatal("失败时不应该留下文件")
+	}
+
+	meta, err := Build(Options{Source: src, Version: "v1", Arches: []string{"amd64"}, Engines: engines, Out: out})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// mariadb 10.11.8, pgsql 12, dbuplib
+	if len(meta.Packages) != 3 {
+		t.Fatalf("安装包数量不正确: %+v", meta.Packages)
+	}
+
+	c, err := Inspect(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(c.Problems) > 0 || c.Meta.Version != "v1" {
+		t.Fatalf("离线安装包内容不正确: %+v", c)
+	}
+	// 3 个安装包, md5, manifest, systemd 模板
+	if len(c.Entries) != 6 {
+		t.Fatalf("文件数量不正确: %+v", c.Entries)
+	}
+}
+
+func TestSourceDirArch(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("需要 ELF 格式的测试程序")
+	}
+	// dbup 安装目录, bin/dbup 使用当前的测试程序
+	src := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(src, "package"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Join(src, "bin"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadFile(os.Args[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(src, "bin", "dbup"), data, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	if dir, err := sourceDir(src, runtime.GOARCH); err != nil || dir != src {
+		t.Fatalf("相同架构应该使用安装目录: %s, %v", dir, err)
+	}
+	other := "arm64"
+	if runtime.GOARCH == "arm64" {
+		other = "amd64"
+	}
+	if _, err := sourceDir(src, other); err == nil {
+		t.Fatalf("安装目录的架构与 %s 不同时应该报错", other)
+	}
+}
@@ -0,0 +1,91 @@
+package credential
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestStore(t *testing.T) {
+	dir := t.TempDir()
+	s, err := Open(dir, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Set("pgsql5432", FieldPassword, "secret")
+	if err := s.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := ioutil.ReadFile(filepath.Join(dir, StoreFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(b), "secret") {
+		t.Fatalf("凭据库中不应该出现明文密码")
+	}
+
+	s, err = Open(dir, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := s.Get("pgsql5432", FieldPassword); v != "secret" {
+		t.Fatalf("读取凭据失败, 实际: %q", v)
+	}
+
+	key, err := GenerateMasterKey(filepath.Join(t.TempDir(), "new.key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Rotate(key); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Open(dir, false); err == nil {
+		t.Fatalf("更换主密钥后使用旧主密钥应该解密失败")
+	}
+	os.Setenv(MasterKeyEnv, string(key.Key))
+	defer os.Unsetenv(MasterKeyEnv)
+	if s, err = Open(dir, false); err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := s.Get("pgsql5432", FieldPassword); v != "secret" {
+		t.Fatalf("更换主密钥后读取凭据失败, 实际: %q", v)
+	}
+}
+
+func TestMigrate(t *testing.T) {
+	dir := t.TempDir()
+	info := filepath.Join(dir, "redis6379")
+	if err := ioutil.WriteFile(info, []byte("port = 6379\npassword = p@ss\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	names, err := Migrate(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(names) != 1 || names[0] != "redis6379" {
+		t.Fatalf("迁移结果不正确: %v", names)
+	}
+
+	b, err := ioutil.ReadFile(info)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(b), "p@ss") || !strings.Contains(string(b), "6379") {
+		t.Fatalf("迁移后 info 文件内容不正确: %s", b)
+	}
+	s, err := Open(dir, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := s.Get("redis6379", FieldPassword); v != "p@ss" {
+		t.Fatalf("迁移后读取凭据失败, 实际: %q", v)
+	}
+
+	if names, err := Migrate(dir); err != nil || len(names) != 0 {
+		t.Fatalf("重复迁移应该没有变化: %v, %v", names, err)
+	}
+}
@@ -0,0 +1,94 @@
+package credential
+
+import (
+	"crypto/rand"
+	"dbup/internal/output"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// 主密钥来源, 优先级从高到低:
+// 环境变量 DBUP_MASTER_KEY, 命令行参数 --master-key-file, 环境变量 DBUP_MASTER_KEY_FILE, 默认文件 ~/.dbup/master.key
+const (
+	MasterKeyEnv     = "DBUP_MASTER_KEY"
+	MasterKeyFileEnv = "DBUP_MASTER_KEY_FILE"
+	MasterKeyFile    = "master.key"
+)
+
+var masterKeyFile string
+
+// SetMasterKeyFile 指定主密钥文件, 对应命令行参数 --master-key-file
+func SetMasterKeyFile(file string) {
+	masterKeyFile = file
+}
+
+// MasterKey 主密钥
+type MasterKey struct {
+	Key  []byte
+	File string // 来自环境变量时为空
+}
+
+// keyFile 返回主密钥文件路径
+func keyFile(dir string) string {
+	if masterKeyFile != "" {
+		return masterKeyFile
+	}
+	if f := os.Getenv(MasterKeyFileEnv); f != "" {
+		return f
+	}
+	return filepath.Join(dir, MasterKeyFile)
+}
+
+// LoadMasterKey 读取主密钥. create 为 true 且使用默认文件时, 文件不存在则自动生成
+func LoadMasterKey(dir string, create bool) (*MasterKey, error) {
+	if k := os.Getenv(MasterKeyEnv); k != "" {
+		return &MasterKey{Key: []byte(k)}, nil
+	}
+
+	file := keyFile(dir)
+	b, err := ioutil.ReadFile(file)
+	if os.IsNotExist(err) && create && file == filepath.Join(dir, MasterKeyFile) {
+		return GenerateMasterKey(file)
+	}
+	if err != nil {
+		return nil, output.Errorf(output.CodeCredential, "读取主密钥文件 %s 失败: %v, 请通过 --master-key-file 或环境变量 %s, %s 指定主密钥", file, err, MasterKeyFileEnv, MasterKeyEnv)
+	}
+	key := strings.TrimRight(string(b), "\r\n")
+	if key == "" {
+		return nil, fmt.Errorf("主密钥文件 %s 内容为空", file)
+	}
+	return &MasterKey{Key: []byte(key), File: file}, nil
+}
+
+// GenerateMasterKey 生成随机主密钥并写入文件, 文件权限为 0600
+func GenerateMasterKey(file string) (*MasterKey, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return nil, fmt.Errorf("生成主密钥失败: %v", err)
+	}
+	key := hex.EncodeToString(b)
+	if err := os.MkdirAll(filepath.Dir(file), 0700); err != nil {
+		return nil, fmt.Errorf("创建目录 %s 失败: %v", filepath.Dir(file), err)
+	}
+	if err := writeFile(file, []byte(key+"\n")); err != nil {
+		return nil, fmt.Errorf("写入主密钥文件 %s 失败: %v", file, err)
+	}
+	return &MasterKey{Key: []byte(key), File: file}, nil
+}
+
+// writeFile 先写临时文件再改名, 避免写入过程中断导致文件损坏
+func writeFile(file string, data []byte) error {
+	tmp := file + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp, 0600); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, file)
+}
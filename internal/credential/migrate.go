@@ -0,0 +1,80 @@
+package credential
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/ini.v1"
+)
+
+// Migrate 将 dir 目录下 info 文件中明文保存的密码迁移到凭据库, 并从 info 文件中删除, 返回迁移过的实例名称.
+// info 文件中名称包含 password 的配置项都视为密码
+func Migrate(dir string) ([]string, error) {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("读取目录 %s 失败: %v", dir, err)
+	}
+
+	store, err := Open(dir, true)
+	if err != nil {
+		return nil, err
+	}
+
+	type pending struct {
+		file string
+		cfg  *ini.File
+		keys []string
+	}
+	var todo []pending
+	for _, f := range files {
+		if f.IsDir() || !isInfoFile(f.Name()) {
+			continue
+		}
+		file := filepath.Join(dir, f.Name())
+		cfg, err := ini.LoadSources(ini.LoadOptions{IgnoreInlineComment: true}, file)
+		if err != nil {
+			// 不是 ini 格式, 不是 info 文件
+			continue
+		}
+		var keys []string
+		for _, key := range cfg.Section("").Keys() {
+			if strings.Contains(key.Name(), "password") && key.Value() != "" {
+				store.Set(f.Name(), key.Name(), key.Value())
+				keys = append(keys, key.Name())
+			}
+		}
+		if len(keys) > 0 {
+			todo = append(todo, pending{file: file, cfg: cfg, keys: keys})
+		}
+	}
+	if len(todo) == 0 {
+		return nil, nil
+	}
+
+	// 先保存凭据库, 成功后再删除 info 文件中的密码, 避免中途失败丢失密码
+	if err := store.Save(); err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, p := range todo {
+		for _, key := range p.keys {
+			p.cfg.Section("").DeleteKey(key)
+		}
+		if err := p.cfg.SaveTo(p.file); err != nil {
+			return names, fmt.Errorf("保存 info 文件 %s 失败: %v", p.file, err)
+		}
+		names = append(names, filepath.Base(p.file))
+	}
+	return names, nil
+}
+
+// isInfoFile dbup 自己的文件都不是 info 文件
+func isInfoFile(name string) bool {
+	switch name {
+	case StoreFile, MasterKeyFile, "known_hosts", "inventory.json":
+		return false
+	}
+	return !strings.HasPrefix(name, ".") && !strings.HasSuffix(name, ".tmp")
+}
@@ -0,0 +1,234 @@
+package credential
+
+// 凭据库: 安装时生成的数据库密码不再明文写入 ~/.dbup 下的 info 文件,
+// 而是使用主密钥加密后统一保存在 ~/.dbup/credentials 中.
+// 凭据按实例名称(与 info 文件同名, 例如 pgsql5432, redis6379)分组, 每个实例下保存若干字段(例如 password, admin-password)
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"dbup/internal/environment"
+	"dbup/internal/output"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+// StoreFile 凭据库文件名, 位于 ~/.dbup 目录下
+const StoreFile = "credentials"
+
+// 常用字段
+const (
+	FieldUsername      = "username"
+	FieldPassword      = "password"
+	FieldAdminUser     = "admin-user"
+	FieldAdminPassword = "admin-password"
+	FieldReplPassword  = "repl-password"
+)
+
+const storeVersion = 1
+
+// 同一进程内读取-修改-保存凭据库时加锁
+var storeLock sync.Mutex
+
+// envelope 凭据库文件格式
+type envelope struct {
+	Version int    `json:"version"`
+	Salt    []byte `json:"salt"`
+	Nonce   []byte `json:"nonce"`
+	Data    []byte `json:"data"`
+}
+
+type Store struct {
+	dir     string
+	key     *MasterKey
+	entries map[string]map[string]string
+}
+
+// Name 实例名称, 与 info 文件名一致
+func Name(kind string, port int) string {
+	return fmt.Sprintf("%s%d", kind, port)
+}
+
+// Open 打开 dir 目录下的凭据库, 文件不存在时返回空的凭据库.
+// create 为 true 时, 默认主密钥文件不存在会自动生成
+func Open(dir string, create bool) (*Store, error) {
+	s := &Store{dir: dir, entries: make(map[string]map[string]string)}
+	file := s.file()
+	_, err := os.Stat(file)
+	if os.IsNotExist(err) && !create {
+		return s, nil
+	}
+
+	if s.key, err = LoadMasterKey(dir, create); err != nil {
+		return nil, err
+	}
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// OpenDefault 打开 ~/.dbup 下的凭据库
+func OpenDefault(create bool) (*Store, error) {
+	return Open(environment.GlobalEnv().DbupInfoPath, create)
+}
+
+func (s *Store) file() string {
+	return filepath.Join(s.dir, StoreFile)
+}
+
+func (s *Store) load() error {
+	b, err := ioutil.ReadFile(s.file())
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("读取凭据库 %s 失败: %v", s.file(), err)
+	}
+	var e envelope
+	if err := json.Unmarshal(b, &e); err != nil {
+		return fmt.Errorf("解析凭据库 %s 失败: %v", s.file(), err)
+	}
+	if e.Version != storeVersion {
+		return fmt.Errorf("不支持的凭据库版本: %d", e.Version)
+	}
+	gcm, err := newGCM(s.key.Key, e.Salt)
+	if err != nil {
+		return err
+	}
+	plain, err := gcm.Open(nil, e.Nonce, e.Data, nil)
+	if err != nil {
+		return output.Errorf(output.CodeCredential, "解密凭据库 %s 失败, 请确认主密钥是否正确", s.file())
+	}
+	if err := json.Unmarshal(plain, &s.entries); err != nil {
+		return fmt.Errorf("解析凭据库 %s 失败: %v", s.file(), err)
+	}
+	return nil
+}
+
+// Save 加密保存, 每次保存都使用新的盐和随机数
+func (s *Store) Save() error {
+	if s.key == nil {
+		var err error
+		if s.key, err = LoadMasterKey(s.dir, true); err != nil {
+			return err
+		}
+	}
+	plain, err := json.Marshal(s.entries)
+	if err != nil {
+		return err
+	}
+	e := envelope{Version: storeVersion, Salt: make([]byte, 16)}
+	if _, err := rand.Read(e.Salt); err != nil {
+		return err
+	}
+	gcm, err := newGCM(s.key.Key, e.Salt)
+	if err != nil {
+		return err
+	}
+	e.Nonce = make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(e.Nonce); err != nil {
+		return err
+	}
+	e.Data = gcm.Seal(nil, e.Nonce, plain, nil)
+
+	b, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(s.dir, 0755); err != nil {
+		return fmt.Errorf("创建目录 %s 失败: %v", s.dir, err)
+	}
+	if err := writeFile(s.file(), b); err != nil {
+		return fmt.Errorf("保存凭据库 %s 失败: %v", s.file(), err)
+	}
+	return nil
+}
+
+// Rotate 使用新的主密钥重新加密凭据库
+func (s *Store) Rotate(key *MasterKey) error {
+	s.key = key
+	return s.Save()
+}
+
+func (s *Store) Get(name, field string) (string, bool) {
+	v, ok := s.entries[name][field]
+	return v, ok
+}
+
+func (s *Store) Set(name, field, value string) {
+	if s.entries[name] == nil {
+		s.entries[name] = make(map[string]string)
+	}
+	s.entries[name][field] = value
+}
+
+// Entry 返回实例下的全部字段
+func (s *Store) Entry(name string) map[string]string {
+	return s.entries[name]
+}
+
+func (s *Store) Delete(name string) {
+	delete(s.entries, name)
+}
+
+// Names 返回排序后的实例名称
+func (s *Store) Names() []string {
+	var names []string
+	for name := range s.entries {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func newGCM(key, salt []byte) (cipher.AEAD, error) {
+	dk, err := scrypt.Key(key, salt, 1<<15, 8, 1, 32)
+	if err != nil {
+		return nil, fmt.Errorf("派生加密密钥失败: %v", err)
+	}
+	block, err := aes.NewCipher(dk)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// Put 将实例的凭据写入默认凭据库, 值为空的字段不保存
+func Put(name string, fields map[string]string) error {
+	storeLock.Lock()
+	defer storeLock.Unlock()
+
+	s, err := OpenDefault(true)
+	if err != nil {
+		return err
+	}
+	for field, value := range fields {
+		if value != "" {
+			s.Set(name, field, value)
+		}
+	}
+	if err := s.Save(); err != nil {
+		return err
+	}
+	output.SetCredentialsFile(s.file())
+	return nil
+}
+
+// Lookup 从默认凭据库读取实例的凭据, 凭据库不存在时返回 false
+func Lookup(name, field string) (string, bool, error) {
+	s, err := OpenDefault(false)
+	if err != nil {
+		return "", false, err
+	}
+	v, ok := s.Get(name, field)
+	return v, ok, nil
+}
@@ -0,0 +1,155 @@
+package deployconf
+
+import (
+	"bytes"
+	"dbup/internal/global"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Convert 把旧的 ini 部署配置转换成 YAML. 无法转换的配置项作为问题返回, 不写入结果
+func Convert(filename, engine string) ([]byte, []global.DeployProblem, error) {
+	f, problems, err := global.ReadDeployFile(filename)
+	if err != nil {
+		return nil, nil, err
+	}
+	if f.Format != global.DeployFormatINI {
+		return nil, nil, fmt.Errorf("%s 已经是 YAML 格式", filename)
+	}
+	if engine == "" {
+		engine = f.Engine
+	}
+	if engine == "" {
+		return nil, nil, fmt.Errorf("无法确定引擎, 请使用 --engine 指定, 支持: %s", strings.Join(global.DeployEngines, ", "))
+	}
+	schema, err := Lookup(engine)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	c := &converter{f: f, problems: problems, root: &yaml.Node{Kind: yaml.MappingNode}}
+	c.add(c.root, "engine", scalar(engine, reflect.String))
+	c.server(schema)
+	if s := f.Section(engine); s != nil {
+		params := &yaml.Node{Kind: yaml.MappingNode}
+		for _, k := range s.Keys {
+			c.add(params, k.Name, scalar(k.Value, schema.Params[k.Name]))
+		}
+		c.add(c.root, engine, params)
+	}
+	if s := f.Section(""); s != nil {
+		for _, k := range s.Keys {
+			c.add(c.root, k.Name, scalar(k.Value, topKeys[k.Name]))
+		}
+	}
+	for _, s := range f.Sections {
+		if s.Name != "" && s.Name != "server" && s.Name != engine && !strings.HasPrefix(s.Name, global.ReplicaSectionPrefix) {
+			c.problem(s.Line, "未知的 section: %s, 没有转换", s.Name)
+		}
+	}
+
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(&yaml.Node{Kind: yaml.DocumentNode, Content: []*yaml.Node{c.root}}); err != nil {
+		return nil, c.problems, err
+	}
+	return buf.Bytes(), c.problems, nil
+}
+
+type converter struct {
+	f        *global.DeployFile
+	problems []global.DeployProblem
+	root     *yaml.Node
+}
+
+func (c *converter) problem(line int, format string, args ...interface{}) {
+	c.problems = append(c.problems, global.DeployProblem{File: c.f.Name, Line: line, Message: fmt.Sprintf(format, args...)})
+}
+
+func (c *converter) add(m *yaml.Node, key string, value *yaml.Node) {
+	m.Content = append(m.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: key}, value)
+}
+
+// server [server] 拆分成 ssh, master, replicas 和 hosts
+func (c *converter) server(schema *Schema) {
+	sshKeys := make(map[string]string)
+	for k, iniKey := range global.DeploySSHKeys {
+		sshKeys[iniKey] = k
+	}
+
+	ssh := &yaml.Node{Kind: yaml.MappingNode}
+	var master, hosts *yaml.Node
+	var replicas []*yaml.Node
+	if s := c.f.Section("server"); s != nil {
+		for _, k := range s.Keys {
+			switch {
+			case sshKeys[k.Name] != "":
+				c.add(ssh, sshKeys[k.Name], scalar(k.Value, schema.Server[k.Name]))
+			case k.Name == "master":
+				master = scalar(k.Value, reflect.String)
+			case k.Name == "slaves":
+				for _, host := range strings.Split(k.Value, ",") {
+					if host = strings.TrimSpace(host); host != "" {
+						r := &yaml.Node{Kind: yaml.MappingNode}
+						c.add(r, "host", scalar(host, reflect.String))
+						replicas = append(replicas, r)
+					}
+				}
+			case k.Name == "address":
+				hosts = &yaml.Node{Kind: yaml.SequenceNode, Style: yaml.FlowStyle}
+				for _, host := range strings.Split(k.Value, ",") {
+					if host = strings.TrimSpace(host); host != "" {
+						hosts.Content = append(hosts.Content, scalar(host, reflect.String))
+					}
+				}
+			default:
+				c.problem(k.Line, "未知的配置项: [server] %s, 没有转换", k.Name)
+			}
+		}
+	}
+	for _, s := range c.f.Sections {
+		if !strings.HasPrefix(s.Name, global.ReplicaSectionPrefix) {
+			continue
+		}
+		r := &yaml.Node{Kind: yaml.MappingNode}
+		c.add(r, "name", scalar(strings.TrimPrefix(s.Name, global.ReplicaSectionPrefix), reflect.String))
+		for _, k := range s.Keys {
+			c.add(r, k.Name, scalar(k.Value, replicaKeys[k.Name]))
+		}
+		replicas = append(replicas, r)
+	}
+
+	if len(ssh.Content) > 0 {
+		c.add(c.root, "ssh", ssh)
+	}
+	if master != nil {
+		c.add(c.root, "master", master)
+	}
+	if len(replicas) > 0 {
+		c.add(c.root, "replicas", &yaml.Node{Kind: yaml.SequenceNode, Content: replicas})
+	}
+	if hosts != nil {
+		c.add(c.root, "hosts", hosts)
+	}
+}
+
+// scalar 按配置项的类型生成 YAML 的值, 转换不了的保留为字符串
+func scalar(value string, kind reflect.Kind) *yaml.Node {
+	n := &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: value}
+	switch kind {
+	case reflect.Int, reflect.Int64:
+		if _, err := strconv.Atoi(value); err == nil {
+			n.Tag = "!!int"
+		}
+	case reflect.Bool:
+		if b, ok := parseBool(value); ok {
+			n.Tag, n.Value = "!!bool", strconv.FormatBool(b)
+		}
+	}
+	return n
+}
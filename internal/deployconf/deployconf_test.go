@@ -0,0 +1,82 @@
+package deployconf
+
+import (
+	pgconfig "dbup/internal/pgsql/config"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+const pgsqlINI = `[server]
+master = 10.0.0.1
+slaves = 10.0.0.2
+ssh-port = 22
+ssh-password = secret ; 注释
+[slave.b]
+host = 10.0.0.3
+port = 5433
+sync = true
+[pgsql]
+port = 5432
+memory-size = 1GB
+`
+
+func write(t *testing.T, name, content string) string {
+	t.Helper()
+	file := filepath.Join(t.TempDir(), name)
+	if err := ioutil.WriteFile(file, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return file
+}
+
+func TestConvert(t *testing.T) {
+	data, problems, err := Convert(write(t, "deploy.ini", pgsqlINI), "")
+	if err != nil || len(problems) > 0 {
+		t.Fatalf("转换失败: %v, %v", err, problems)
+	}
+	file := write(t, "deploy.yaml", string(data))
+	if problems, err := Validate(file, ""); err != nil || len(problems) > 0 {
+		t.Fatalf("转换后的配置检查不通过: %v, %v\n%s", err, problems, data)
+	}
+
+	var p pgconfig.Parameter
+	if err := p.Load(file); err != nil {
+		t.Fatal(err)
+	}
+	if p.Server.Master != "10.0.0.1" || p.Server.Password != "secret" || p.Pgsql.Port != 5432 || len(p.Replicas) != 2 {
+		t.Fatalf("YAML 加载结果不正确: %+v", p)
+	}
+	if r := p.Replicas[1]; r.Name != "b" || r.Port != 5433 || !r.Sync {
+		t.Fatalf("从节点不正确: %+v", r)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	file := write(t, "deploy.yaml", `engine: redis
+ssh:
+  usr: root
+master: 10.0.0.1
+replicas:
+  - host: 10.0.0.2
+    port: abc
+  - name: c
+    host: 10.0.0.3
+    upstream: x
+redis:
+  port: 6379
+`)
+	problems, err := Validate(file, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []int{3, 7, 8}
+	if len(problems) != len(want) {
+		t.Fatalf("问题数量不正确: %v", problems)
+	}
+	for i, p := range problems {
+		if p.Line != want[i] {
+			t.Fatalf("第 %d 个问题的行号为 %d, 应为 %d: %v", i, p.Line, want[i], p)
+		}
+	}
+}
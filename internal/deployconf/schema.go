@@ -0,0 +1,56 @@
+package deployconf
+
+// 部署配置的检查和格式转换. 两种格式读取后都是 ini 的 section 和 key, 按各引擎部署配置结构体的 ini 标签检查
+
+import (
+	"dbup/internal/global"
+	mariadbconfig "dbup/internal/mariadb/config"
+	pgconfig "dbup/internal/pgsql/config"
+	redisconfig "dbup/internal/redis/config"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// Schema 一种引擎的部署配置
+type Schema struct {
+	Engine   string
+	Server   map[string]reflect.Kind // [server] 的配置项
+	Params   map[string]reflect.Kind // 引擎参数 section 的配置项
+	Replicas bool                    // 主从部署: master 加 replicas; 否则为 hosts 列出的所有节点
+}
+
+// 默认 section 中的配置项
+var topKeys = map[string]reflect.Kind{"yes": reflect.Bool, "no-rollback": reflect.Bool}
+
+var schemas = map[string]*Schema{
+	"pgsql":   {Engine: "pgsql", Server: iniKeys(pgconfig.Server{}), Params: iniKeys(pgconfig.Prepare{}), Replicas: true},
+	"redis":   {Engine: "redis", Server: iniKeys(redisconfig.Server{}), Params: iniKeys(redisconfig.Parameters{}), Replicas: true},
+	"mariadb": {Engine: "mariadb", Server: iniKeys(mariadbconfig.Server{}), Params: iniKeys(mariadbconfig.MariaDBOptions{})},
+}
+
+// replicaKeys [slave.<名称>] 的配置项
+var replicaKeys = iniKeys(global.Replica{})
+
+// Lookup 返回引擎的部署配置
+func Lookup(engine string) (*Schema, error) {
+	s, ok := schemas[engine]
+	if !ok {
+		return nil, fmt.Errorf("不支持的引擎: %s, 支持: %s", engine, strings.Join(global.DeployEngines, ", "))
+	}
+	return s, nil
+}
+
+// iniKeys 结构体中带 ini 标签的字段及其类型
+func iniKeys(v interface{}) map[string]reflect.Kind {
+	keys := make(map[string]reflect.Kind)
+	t := reflect.TypeOf(v)
+	for i := 0; i < t.NumField(); i++ {
+		tag := strings.Split(t.Field(i).Tag.Get("ini"), ",")[0]
+		if tag == "" || tag == "-" {
+			continue
+		}
+		keys[tag] = t.Field(i).Type.Kind()
+	}
+	return keys
+}
@@ -0,0 +1,332 @@
+package deployconf
+
+import (
+	"dbup/internal/global"
+	"dbup/internal/utils"
+	"dbup/internal/utils/sshutil"
+	"fmt"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var memoryRegexp = regexp.MustCompile(`^[0-9.]+\s*[MGmg][Bb]?$`)
+
+// Validate 检查部署配置, 一次返回所有问题. engine 为空时使用配置中的 engine, 或按引擎参数的 section 推断
+func Validate(filename, engine string) ([]global.DeployProblem, error) {
+	f, problems, err := global.ReadDeployFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	v := &validator{f: f, problems: problems}
+	if engine != "" && f.Engine != "" && engine != f.Engine {
+		v.problem(0, "配置中的引擎 %s 与指定的引擎 %s 不一致", f.Engine, engine)
+	}
+	if engine == "" {
+		engine = f.Engine
+	}
+	if engine == "" && len(v.problems) > 0 {
+		// 格式错误时读不到引擎, 只报告格式错误
+		return v.problems, nil
+	}
+	if engine == "" {
+		v.problem(0, "无法确定引擎, 请在配置中指定 engine 或使用 --engine, 支持: %s", strings.Join(global.DeployEngines, ", "))
+		return v.problems, nil
+	}
+	if v.schema, err = Lookup(engine); err != nil {
+		v.problem(0, "%v", err)
+		return v.problems, nil
+	}
+
+	v.sections()
+	v.server()
+	v.params()
+	if v.schema.Replicas {
+		v.replicas()
+	}
+	sort.SliceStable(v.problems, func(i, j int) bool { return v.problems[i].Line < v.problems[j].Line })
+	return v.problems, nil
+}
+
+type validator struct {
+	f        *global.DeployFile
+	schema   *Schema
+	problems []global.DeployProblem
+}
+
+func (v *validator) problem(line int, format string, args ...interface{}) {
+	v.problems = append(v.problems, global.DeployProblem{File: v.f.Name, Line: line, Message: fmt.Sprintf(format, args...)})
+}
+
+// name 配置项在原文件中的写法
+func (v *validator) name(section, key string) string {
+	if v.f.Format == global.DeployFormatINI {
+		if section == "" {
+			return key
+		}
+		return fmt.Sprintf("[%s] %s", section, key)
+	}
+	switch {
+	case section == "":
+		return key
+	case section == "server":
+		if key == "address" {
+			return "hosts"
+		}
+		for k, iniKey := range global.DeploySSHKeys {
+			if iniKey == key {
+				return "ssh." + k
+			}
+		}
+		return key
+	case strings.HasPrefix(section, global.ReplicaSectionPrefix):
+		return fmt.Sprintf("replicas(%s).%s", strings.TrimPrefix(section, global.ReplicaSectionPrefix), key)
+	}
+	return section + "." + key
+}
+
+func (v *validator) value(section, key string) *global.DeployKey {
+	if s := v.f.Section(section); s != nil {
+		return s.Key(key)
+	}
+	return nil
+}
+
+// sections 检查未知的 section 和配置项, 以及配置项的类型
+func (v *validator) sections() {
+	for _, s := range v.f.Sections {
+		var keys map[string]reflect.Kind
+		switch {
+		case s.Name == "":
+			keys = topKeys
+		case s.Name == "server":
+			keys = v.schema.Server
+		case s.Name == v.schema.Engine:
+			keys = v.schema.Params
+		case strings.HasPrefix(s.Name, global.ReplicaSectionPrefix) && v.schema.Replicas:
+			keys = replicaKeys
+		case strings.HasPrefix(s.Name, global.ReplicaSectionPrefix):
+			v.problem(s.Line, "%s 部署不支持 replicas, 请在 hosts 中列出所有节点", v.schema.Engine)
+			continue
+		default:
+			v.problem(s.Line, "未知的 section: %s", s.Name)
+			continue
+		}
+		for _, k := range s.Keys {
+			kind, ok := keys[k.Name]
+			if !ok {
+				v.problem(k.Line, "未知的配置项: %s", v.name(s.Name, k.Name))
+				continue
+			}
+			v.kind(s.Name, k, kind)
+		}
+	}
+}
+
+func (v *validator) kind(section string, k *global.DeployKey, kind reflect.Kind) {
+	switch kind {
+	case reflect.Int, reflect.Int64:
+		if _, err := strconv.Atoi(k.Value); err != nil && k.Value != "" {
+			v.problem(k.Line, "%s 必须是整数: %s", v.name(section, k.Name), k.Value)
+		}
+	case reflect.Bool:
+		if _, ok := parseBool(k.Value); !ok {
+			v.problem(k.Line, "%s 必须是 true 或 false: %s", v.name(section, k.Name), k.Value)
+		}
+	}
+}
+
+// parseBool 与 ini 映射时的布尔值规则一致
+func parseBool(s string) (bool, bool) {
+	switch strings.ToLower(s) {
+	case "", "1", "t", "true", "y", "yes", "on":
+		return true, true
+	case "0", "f", "false", "n", "no", "off":
+		return false, true
+	}
+	return false, false
+}
+
+func (v *validator) host(line int, name, host string) {
+	if err := utils.IsIPv4(host); err != nil && !utils.IsHostName(host) {
+		v.problem(line, "%s (%s) 即不是一个 IP 地址, 又解析主机名失败", name, host)
+	}
+}
+
+func (v *validator) port(section, key string) {
+	k := v.value(section, key)
+	if k == nil || k.Value == "" {
+		return
+	}
+	if port, err := strconv.Atoi(k.Value); err == nil && (port < 1 || port > 65535) {
+		v.problem(k.Line, "%s 端口号(%d)不正确, 端口号必须在 1 ~ 65535 之间", v.name(section, key), port)
+	}
+}
+
+func (v *validator) line(section string) int {
+	if s := v.f.Section(section); s != nil {
+		return s.Line
+	}
+	return 0
+}
+
+func (v *validator) server() {
+	if v.schema.Replicas {
+		if k := v.value("server", "master"); k == nil || k.Value == "" {
+			v.problem(v.line("server"), "没有指定主库: %s", v.name("server", "master"))
+		} else {
+			v.host(k.Line, v.name("server", "master"), k.Value)
+		}
+	} else {
+		k := v.value("server", "address")
+		if k == nil || k.Value == "" {
+			v.problem(v.line("server"), "没有指定部署节点: %s", v.name("server", "address"))
+		} else {
+			hosts := strings.Split(k.Value, ",")
+			if len(hosts) < 2 {
+				v.problem(k.Line, "%s 必须为两个或以上地址", v.name("server", "address"))
+			}
+			seen := make(map[string]bool)
+			for _, host := range hosts {
+				host = strings.TrimSpace(host)
+				if seen[host] {
+					v.problem(k.Line, "%s 中的地址重复: %s", v.name("server", "address"), host)
+				}
+				seen[host] = true
+				v.host(k.Line, v.name("server", "address"), host)
+			}
+		}
+	}
+
+	v.port("server", "ssh-port")
+	o := sshutil.Options{}
+	for key, field := range map[string]*string{
+		"ssh-host-key-check":  &o.HostKeyCheck,
+		"ssh-known-hosts":     &o.KnownHosts,
+		"ssh-proxy-jump":      &o.ProxyJump,
+		"ssh-auth":            &o.Auth,
+		"ssh-password":        &o.Password,
+		"ssh-keyfile":         &o.KeyFile,
+		"ssh-passphrase-file": &o.PassphraseFile,
+	} {
+		if k := v.value("server", key); k != nil {
+			*field = k.Value
+		}
+	}
+	if err := o.Validator(); err != nil {
+		v.problem(v.line("server"), "ssh 配置错误: %v", err)
+	}
+}
+
+func (v *validator) params() {
+	e := v.schema.Engine
+	if v.f.Section(e) == nil {
+		v.problem(0, "没有 %s 参数", e)
+		return
+	}
+	v.port(e, "port")
+	for _, key := range []string{"memory-size", "memory"} {
+		if k := v.value(e, key); k != nil && k.Value != "" && v.schema.Params[key] == reflect.String && !memoryRegexp.MatchString(k.Value) {
+			v.problem(k.Line, "%s 必须包含单位后缀(MB 或 GB): %s", v.name(e, key), k.Value)
+		}
+	}
+}
+
+// replica 一个从节点及其所在的行
+type replica struct {
+	global.Replica
+	line int
+}
+
+func (v *validator) replicas() {
+	port := 0
+	if k := v.value(v.schema.Engine, "port"); k != nil {
+		port, _ = strconv.Atoi(k.Value)
+	}
+
+	var replicas []*replica
+	if k := v.value("server", "slaves"); k != nil {
+		for _, host := range strings.Split(k.Value, ",") {
+			if host = strings.TrimSpace(host); host != "" {
+				replicas = append(replicas, &replica{Replica: global.Replica{Name: host, Host: host}, line: k.Line})
+			}
+		}
+	}
+	for _, s := range v.f.Sections {
+		if !strings.HasPrefix(s.Name, global.ReplicaSectionPrefix) {
+			continue
+		}
+		r := &replica{Replica: global.Replica{Name: strings.TrimPrefix(s.Name, global.ReplicaSectionPrefix)}, line: s.Line}
+		for _, k := range s.Keys {
+			switch k.Name {
+			case "host":
+				r.Host = k.Value
+			case "port":
+				r.Port, _ = strconv.Atoi(k.Value)
+			case "sync":
+				r.Sync, _ = parseBool(k.Value)
+			case "upstream":
+				r.Upstream = k.Value
+			}
+		}
+		replicas = append(replicas, r)
+	}
+	if len(replicas) == 0 {
+		v.problem(v.line("server"), "没有指定从库: replicas")
+		return
+	}
+
+	byName := make(map[string]*replica)
+	addrs := make(map[string]string)
+	if k := v.value("server", "master"); k != nil && port > 0 {
+		addrs[fmt.Sprintf("%s:%d", k.Value, port)] = "主库"
+	}
+	for _, r := range replicas {
+		if _, ok := byName[r.Name]; ok {
+			v.problem(r.line, "从节点名称重复: %s", r.Name)
+			continue
+		}
+		byName[r.Name] = r
+		if r.Host == "" {
+			v.problem(r.line, "从节点 %s 没有指定 host", r.Name)
+			continue
+		}
+		v.host(r.line, fmt.Sprintf("从节点 %s 的 host", r.Name), r.Host)
+		if r.Port == 0 {
+			r.Port = port
+		}
+		if r.Port != 0 {
+			if r.Port < 1025 || r.Port > 65535 {
+				v.problem(r.line, "从节点 %s 的端口号(%d)不正确, 端口号必须在 1025 ~ 65535 之间", r.Name, r.Port)
+			}
+			if other, ok := addrs[r.Addr()]; ok {
+				v.problem(r.line, "从节点 %s 与 %s 的地址 %s 相同", r.Name, other, r.Addr())
+			}
+			addrs[r.Addr()] = r.Name
+		}
+	}
+
+	for _, r := range replicas {
+		if r.Upstream == "" {
+			continue
+		}
+		if _, ok := byName[r.Upstream]; !ok {
+			v.problem(r.line, "从节点 %s 的上游 %s 不存在", r.Name, r.Upstream)
+			continue
+		}
+		if r.Sync {
+			v.problem(r.line, "从节点 %s 为级联复制, 不能设置为同步复制", r.Name)
+		}
+		// 沿上游向上查找, 超过从节点数量还没有到达主库说明有循环
+		u := r
+		for n := 0; u != nil && u.Upstream != ""; n++ {
+			if n >= len(replicas) {
+				v.problem(r.line, "从节点 %s 的上游有循环", r.Name)
+				break
+			}
+			u = byName[u.Upstream]
+		}
+	}
+}
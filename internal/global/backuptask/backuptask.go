@@ -0,0 +1,234 @@
+package backuptask
+
+// 备份定时任务: mariadb 和 mongodb 的备份任务都是在 root 的 crontab 中每天执行一次备份子命令,
+// 定时任务行末尾的注释 #<前缀>-<端口>-<任务名称> 用于列出和删除; 密码写在只有 root 可读的密码文件中
+
+import (
+	"bufio"
+	"dbup/internal/environment"
+	"dbup/internal/output"
+	"dbup/internal/utils"
+	"dbup/internal/utils/command"
+	"dbup/internal/utils/logger"
+	"dbup/internal/utils/secretfile"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// RegexpTime 任务每天开始时间的格式
+const RegexpTime = "([01]\\d|2[0-3]):([0-5]\\d)"
+
+// Cron 备份定时任务的公共部分, 各引擎的备份任务嵌入该结构体
+type Cron struct {
+	TaskName       string
+	TaskNameFormat string
+	TaskTime       string
+	BackupDir      string
+	Expire         int
+	RunCmd         string // 定时任务中执行的子命令, dbup 和 dbup-backup 不同
+	NamePrefix     string // 任务名称前缀, 区分不同引擎的任务
+	FilePrefix     string // 备份文件名前缀, 只删除这些过期的备份文件
+	CronFile       string // 定时任务文件
+}
+
+// SetName 按端口生成定时任务中的任务名称
+func (c *Cron) SetName(port int) {
+	c.TaskNameFormat = fmt.Sprintf("%s-%d-%s", c.NamePrefix, port, c.TaskName)
+}
+
+// DropExpire 删除备份目录中超过 Expire 天的备份文件
+func (c *Cron) DropExpire() error {
+	logger.Infof("删除过期备份\n")
+
+	nTime := time.Now().AddDate(0, 0, -c.Expire)
+	expireTime := time.Date(nTime.Year(), nTime.Month(), nTime.Day(), 0, 0, 0, 0, nTime.Location()).Unix()
+
+	f, err := os.Open(c.BackupDir)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	backupFiles, err := f.Readdir(-1)
+	if err != nil {
+		return err
+	}
+
+	for _, file := range backupFiles {
+		if !file.IsDir() && strings.HasPrefix(file.Name(), c.FilePrefix) && file.ModTime().Unix() < expireTime {
+			filename := filepath.Join(c.BackupDir, file.Name())
+			if err := os.Remove(filename); err != nil {
+				logger.Warningf("删除过期备份 %s 失败: %s\n", filename, err)
+			}
+		}
+	}
+	return nil
+}
+
+func (c *Cron) AddValidator() error {
+	logger.Infof("验证参数\n")
+	r, _ := regexp.Compile(RegexpTime)
+	if ok := r.MatchString(c.TaskTime); !ok {
+		return fmt.Errorf("时间(%s)格式不正确, 例: 凌晨2点6分执行 ( 02:06 )", c.TaskTime)
+	}
+	if c.BackupDir == "" {
+		return fmt.Errorf("请指定备份目录")
+	}
+	return nil
+}
+
+// taskItem 备份任务列表中的一项, 用于 json 格式输出
+type taskItem struct {
+	Name string `json:"name"`
+	Time string `json:"time"`
+	Port string `json:"port"`
+}
+
+func (c *Cron) LinuxList() error {
+	logger.Infof("列出定时任务列表\n")
+	// 检查任务是否存在
+	if !utils.IsExists(c.CronFile) {
+		return nil
+	}
+
+	file, err := os.Open(c.CronFile)
+	if err != nil {
+		return fmt.Errorf("打开计划任务文件失败: %v", err)
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.Contains(line, "#"+c.NamePrefix) {
+			cron := strings.Trim(line, " ")
+			time := strings.SplitN(cron, " ", 3)
+			tn := strings.Split(cron, "#")
+			tName := strings.SplitN(tn[len(tn)-1], "-", 3)
+			if len(time) < 2 {
+				return fmt.Errorf("获取备份时间异常\n")
+			}
+			if len(tName) < 3 {
+				return fmt.Errorf("获取备份任务名称异常\n")
+			}
+			output.Item("tasks", taskItem{Name: tName[2], Time: time[1] + ":" + time[0], Port: tName[1]}, "备份任务名: %s, 每天备份时间: %s:%s, 备份端口号: %s\n", tName[2], time[1], time[0], tName[1])
+		}
+	}
+	return nil
+}
+
+// SecretFile 定时任务使用的密码文件
+func (c *Cron) SecretFile() string {
+	return filepath.Join(environment.GlobalEnv().DbupInfoPath, secretfile.Dir, c.TaskNameFormat+".json")
+}
+
+// line 定时任务行, args 为引擎的备份参数, 备份目录、过期天数和密码文件由 Cron 添加
+func (c *Cron) line(program, args string) string {
+	HM := strings.Split(c.TaskTime, ":")
+	return fmt.Sprintf("%s %s * * * %s %s %s --password-file='%s' --backupdir='%s' --expire=%d #%s\n", HM[1], HM[0], program, c.RunCmd, args, c.SecretFile(), c.BackupDir, c.Expire, c.TaskNameFormat)
+}
+
+// LinuxAdd 添加定时任务, args 为引擎的备份参数, secrets 写入密码文件
+func (c *Cron) LinuxAdd(args string, secrets map[string]string) error {
+	if err := c.AddValidator(); err != nil {
+		return err
+	}
+
+	logger.Infof("添加定时任务: %s\n", c.TaskNameFormat)
+	// 检查任务是否存在
+	if utils.IsExists(c.CronFile) {
+		file, err := os.Open(c.CronFile)
+		if err != nil {
+			return fmt.Errorf("打开计划任务文件失败: %v", err)
+		}
+		defer file.Close()
+		scanner := bufio.NewScanner(file)
+		for scanner.Scan() {
+			line := scanner.Text()
+			if strings.Contains(line, "#"+c.TaskNameFormat) {
+				return fmt.Errorf("任务名称已经存在: %s", c.TaskName)
+			}
+		}
+	}
+
+	// 密码写入只有 root 可读的文件, 不直接写在定时任务中
+	if err := secretfile.Write(c.SecretFile(), secrets); err != nil {
+		return err
+	}
+
+	// 将任务写入定时文件
+	if err := command.CopyFile(c.CronFile); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(c.CronFile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666)
+	if err != nil {
+		return fmt.Errorf("打开计划任务文件失败: %v", err)
+	}
+	defer file.Close()
+
+	write := bufio.NewWriter(file)
+	if _, err := write.WriteString(c.line(environment.GlobalEnv().Program, args)); err != nil {
+		return err
+	}
+	if err := write.Flush(); err != nil {
+		return err
+	}
+
+	logger.Infof("设置备份任务成功\n")
+	output.Created(output.Resource{Kind: "backup-task", Name: c.TaskNameFormat})
+	return nil
+}
+
+func (c *Cron) LinuxDel() error {
+	logger.Infof("删除计划任务: %s \n", c.TaskNameFormat)
+
+	// 备份文件
+	if utils.IsExists(c.CronFile) {
+		if err := command.CopyFile(c.CronFile); err != nil {
+			return err
+		}
+	}
+
+	var lines []string
+
+	// 只读方式打开文件(读取)
+	file, err := os.Open(c.CronFile)
+	if err != nil {
+		return fmt.Errorf("打开计划任务文件失败: %v", err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.Contains(line, "#"+c.TaskNameFormat) {
+			continue
+		}
+		lines = append(lines, line)
+	}
+
+	// 打开文件(写入)
+	f, err := os.Create(c.CronFile)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	w := bufio.NewWriter(f)
+	for _, cron := range lines {
+		if _, err := fmt.Fprintln(w, cron); err != nil {
+			return err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	if err := os.Remove(c.SecretFile()); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("删除密码文件失败: %v", err)
+	}
+	logger.Successf("删除成功\n")
+	output.Removed(output.Resource{Kind: "backup-task", Name: c.TaskNameFormat})
+	return nil
+}
@@ -0,0 +1,52 @@
+package backuptask
+
+import (
+	"dbup/internal/environment"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCronLine(t *testing.T) {
+	environment.SetGlobalEnv(&environment.Environment{DbupInfoPath: "/root/.dbup"})
+	c := &Cron{TaskName: "daily", TaskTime: "02:06", BackupDir: "/backup", Expire: 7, RunCmd: "mariadb backup-task run", NamePrefix: "DbupMariaDBBackupTask"}
+	c.SetName(3306)
+	if c.TaskNameFormat != "DbupMariaDBBackupTask-3306-daily" {
+		t.Fatalf("任务名称不正确: %s", c.TaskNameFormat)
+	}
+	want := "06 02 * * * /usr/bin/dbup mariadb backup-task run --host=127.0.0.1 --port=3306 --password-file='/root/.dbup/secrets/DbupMariaDBBackupTask-3306-daily.json' --backupdir='/backup' --expire=7 #DbupMariaDBBackupTask-3306-daily\n"
+	if got := c.line("/usr/bin/dbup", "--host=127.0.0.1 --port=3306"); got != want {
+		t.Fatalf("定时任务行不正确:\n%s\n应为:\n%s", got, want)
+	}
+}
+
+func TestDropExpire(t *testing.T) {
+	dir := t.TempDir()
+	old := time.Now().AddDate(0, 0, -3)
+	files := map[string]time.Time{
+		"mariadb_backup_old.sql": old,
+		"mariadb_backup_new.sql": time.Now(),
+		"other_old.sql":          old,
+	}
+	for name, mtime := range files {
+		file := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(file, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(file, mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	c := &Cron{BackupDir: dir, Expire: 2, FilePrefix: "mariadb_backup_"}
+	if err := c.DropExpire(); err != nil {
+		t.Fatal(err)
+	}
+	for name, removed := range map[string]bool{"mariadb_backup_old.sql": true, "mariadb_backup_new.sql": false, "other_old.sql": false} {
+		if _, err := os.Stat(filepath.Join(dir, name)); os.IsNotExist(err) != removed {
+			t.Errorf("%s 删除状态不正确, 应该删除: %v", name, removed)
+		}
+	}
+}
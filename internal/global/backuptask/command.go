@@ -0,0 +1,123 @@
+package backuptask
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+// Task 各引擎的备份任务, 嵌入 Cron 并提供备份参数
+type Task interface {
+	CronTask() *Cron
+	BackupFlags(flags *pflag.FlagSet) // add 和 run 共用的备份参数, 与定时任务中的命令行一致
+	PortFlag(flags *pflag.FlagSet)    // del 使用的端口参数
+}
+
+// CronTask 返回任务的公共部分
+func (c *Cron) CronTask() *Cron {
+	return c
+}
+
+// Engine 一种引擎的备份任务命令, dbup 和 dbup-backup 共用
+type Engine struct {
+	Kind        string // 引擎类型, 用于命令说明
+	Use         string // 命令名称
+	RunCmd      string // 定时任务中执行的子命令, 为空时使用 New 返回的任务中的值
+	DefaultName string // 默认任务名称
+	DefaultTime string // 默认任务每天开始时间
+	New         func() Task
+	Do          func(ctx context.Context, action string, task Task) error
+	// PreRun add 和 run 执行前调用, 例如从凭据存储中读取默认的用户名和密码, 可以为空
+	PreRun func(cmd *cobra.Command, task Task) error
+}
+
+// Command 备份任务管理命令: list, add, del, run
+func Command(e Engine) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   e.Use,
+		Short: e.Kind + " 备份任务管理",
+	}
+	// 装载命令
+	cmd.AddCommand(
+		e.listCmd(),
+		e.addCmd(),
+		e.delCmd(),
+		e.runCmd(),
+	)
+	return cmd
+}
+
+func (e Engine) preRun(cmd *cobra.Command, task Task) error {
+	if e.PreRun == nil {
+		return nil
+	}
+	return e.PreRun(cmd, task)
+}
+
+func (e Engine) listCmd() *cobra.Command {
+	task := e.New()
+	cmd := &cobra.Command{
+		Use:   "list",
+		Short: e.Kind + " 备份任务列表",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return e.Do(cmd.Context(), "list", task)
+		},
+	}
+	return cmd
+}
+
+func (e Engine) addCmd() *cobra.Command {
+	task := e.New()
+	if e.RunCmd != "" {
+		task.CronTask().RunCmd = e.RunCmd
+	}
+	cmd := &cobra.Command{
+		Use:   "add",
+		Short: e.Kind + " 添加备份任务",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := e.preRun(cmd, task); err != nil {
+				return err
+			}
+			return e.Do(cmd.Context(), "add", task)
+		},
+	}
+	task.BackupFlags(cmd.Flags())
+	cmd.Flags().StringVarP(&task.CronTask().TaskName, "taskname", "n", e.DefaultName, "任务名称")
+	cmd.Flags().StringVarP(&task.CronTask().TaskTime, "tasktime", "t", e.DefaultTime, "任务每天开始时间")
+	return cmd
+}
+
+func (e Engine) delCmd() *cobra.Command {
+	task := e.New()
+	cmd := &cobra.Command{
+		Use:   "del",
+		Short: e.Kind + " 删除备份任务",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if task.CronTask().TaskName == "" {
+				return fmt.Errorf("请输入要删除的任务名称\n")
+			}
+			return e.Do(cmd.Context(), "del", task)
+		},
+	}
+	cmd.Flags().StringVarP(&task.CronTask().TaskName, "taskname", "n", "", "任务名称")
+	task.PortFlag(cmd.Flags())
+	return cmd
+}
+
+func (e Engine) runCmd() *cobra.Command {
+	task := e.New()
+	cmd := &cobra.Command{
+		Use:   "run",
+		Short: e.Kind + " 运行备份任务",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := e.preRun(cmd, task); err != nil {
+				return err
+			}
+			return e.Do(cmd.Context(), "run", task)
+		},
+	}
+	task.BackupFlags(cmd.Flags())
+	return cmd
+}
@@ -23,4 +23,7 @@ const (
 var MissSoLibrariesAndRepairPlanList = map[string]string{
 	"libcrypto.so": "yum install openssl openssl-libs compat-openssl*",
 	"libatomic.so": "yum install libatomic",
+	"libaio.so":    "yum install libaio",
+	"libz.so":      "yum install zlib",
+	"libcurl.so":   "yum install libcurl",
 }
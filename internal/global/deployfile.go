@@ -0,0 +1,390 @@
+package global
+
+// 部署配置文件: 兼容旧的 ini 格式, 新增各引擎统一的 YAML 格式.
+// YAML 读取后转换成与 ini 相同的 section 和 key, 由各引擎原有的 ini 映射加载, 两种格式的含义完全一致
+
+import (
+	"dbup/internal/utils/sshutil"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"gopkg.in/ini.v1"
+	"gopkg.in/yaml.v3"
+)
+
+// 部署配置格式
+const (
+	DeployFormatINI  = "ini"
+	DeployFormatYAML = "yaml"
+)
+
+// DeployEngines 支持 YAML 部署配置的引擎, 引擎参数在与引擎同名的 section 中
+var DeployEngines = []string{"pgsql", "redis", "mariadb"}
+
+// DeploySSHKeys YAML 中 ssh 下的配置项对应的 ini [server] 配置项
+var DeploySSHKeys = map[string]string{
+	"port":            "ssh-port",
+	"username":        "ssh-user",
+	"password":        "ssh-password",
+	"keyfile":         "ssh-keyfile",
+	"tmp-dir":         "tmp-dir",
+	"host-key-check":  "ssh-host-key-check",
+	"known-hosts":     "ssh-known-hosts",
+	"proxy-jump":      "ssh-proxy-jump",
+	"auth":            "ssh-auth",
+	"passphrase-file": "ssh-passphrase-file",
+}
+
+// DeployKey 部署配置中的一项, Line 为在文件中的行号
+type DeployKey struct {
+	Name  string
+	Value string
+	Line  int
+}
+
+// DeploySection 部署配置中的一个 section, 默认 section 的名称为空
+type DeploySection struct {
+	Name string
+	Line int
+	Keys []*DeployKey
+}
+
+// Key 返回 section 中的配置项, 不存在时返回 nil
+func (s *DeploySection) Key(name string) *DeployKey {
+	for _, k := range s.Keys {
+		if k.Name == name {
+			return k
+		}
+	}
+	return nil
+}
+
+func (s *DeploySection) set(name, value string, line int) {
+	if k := s.Key(name); k != nil {
+		k.Value, k.Line = value, line
+		return
+	}
+	s.Keys = append(s.Keys, &DeployKey{Name: name, Value: value, Line: line})
+}
+
+// DeployFile 读取后的部署配置
+type DeployFile struct {
+	Name     string
+	Format   string
+	Engine   string // YAML 中 engine 的值, 没有指定时按引擎参数的 section 推断
+	Sections []*DeploySection
+}
+
+// Section 返回 section, 不存在时返回 nil
+func (f *DeployFile) Section(name string) *DeploySection {
+	for _, s := range f.Sections {
+		if s.Name == name {
+			return s
+		}
+	}
+	return nil
+}
+
+func (f *DeployFile) section(name string, line int) *DeploySection {
+	if s := f.Section(name); s != nil {
+		return s
+	}
+	s := &DeploySection{Name: name, Line: line}
+	f.Sections = append(f.Sections, s)
+	return s
+}
+
+// INI 转换成 ini 对象
+func (f *DeployFile) INI() *ini.File {
+	cfg := ini.Empty()
+	for _, s := range f.Sections {
+		section := cfg.Section(s.Name)
+		for _, k := range s.Keys {
+			section.Key(k.Name).SetValue(k.Value)
+		}
+	}
+	return cfg
+}
+
+func (f *DeployFile) detectEngine() {
+	if f.Engine != "" {
+		return
+	}
+	for _, s := range f.Sections {
+		for _, e := range DeployEngines {
+			if s.Name == e {
+				f.Engine = e
+				return
+			}
+		}
+	}
+}
+
+// DeployProblem 部署配置中的一个问题
+type DeployProblem struct {
+	File    string `json:"file"`
+	Line    int    `json:"line,omitempty"`
+	Message string `json:"message"`
+}
+
+func (p DeployProblem) Error() string {
+	if p.Line > 0 {
+		return fmt.Sprintf("%s:%d: %s", p.File, p.Line, p.Message)
+	}
+	return fmt.Sprintf("%s: %s", p.File, p.Message)
+}
+
+// IsYAMLDeployFile 按扩展名判断是否为 YAML 部署配置
+func IsYAMLDeployFile(filename string) bool {
+	ext := strings.ToLower(filepath.Ext(filename))
+	return ext == ".yaml" || ext == ".yml"
+}
+
+// ReadDeployFile 读取部署配置, 记录每一项的行号. 格式错误不会中断读取, 都作为问题返回
+func ReadDeployFile(filename string) (*DeployFile, []DeployProblem, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, nil, fmt.Errorf("加载配置文件失败: %v", err)
+	}
+	f := &DeployFile{Name: filename, Format: DeployFormatINI}
+	var problems []DeployProblem
+	if IsYAMLDeployFile(filename) {
+		f.Format = DeployFormatYAML
+		problems = f.readYAML(data)
+	} else {
+		problems = f.readINI(data)
+	}
+	f.detectEngine()
+	return f, problems, nil
+}
+
+// LoadDeployFile 读取部署配置到 ini 对象, YAML 格式有问题时一次返回所有问题
+func LoadDeployFile(filename string) (*ini.File, error) {
+	if !IsYAMLDeployFile(filename) {
+		cfg, err := ini.LoadSources(ini.LoadOptions{SpaceBeforeInlineComment: true}, filename)
+		if err != nil {
+			return nil, fmt.Errorf("加载配置文件失败: %v", err)
+		}
+		return cfg, nil
+	}
+	f, problems, err := ReadDeployFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	if len(problems) > 0 {
+		var msgs []string
+		for _, p := range problems {
+			msgs = append(msgs, p.Error())
+		}
+		return nil, fmt.Errorf("加载配置文件失败:\n%s", strings.Join(msgs, "\n"))
+	}
+	return f.INI(), nil
+}
+
+// readINI 逐行读取 ini, 与加载时的 SpaceBeforeInlineComment 一致: 空格后的 # 或 ; 为注释
+func (f *DeployFile) readINI(data []byte) []DeployProblem {
+	var problems []DeployProblem
+	current := f.section("", 0)
+	for i, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || line[0] == '#' || line[0] == ';' {
+			continue
+		}
+		if line[0] == '[' {
+			end := strings.Index(line, "]")
+			if end < 0 {
+				problems = append(problems, DeployProblem{File: f.Name, Line: i + 1, Message: fmt.Sprintf("section 格式错误: %s", line)})
+				continue
+			}
+			current = f.section(strings.TrimSpace(line[1:end]), i+1)
+			continue
+		}
+		pos := strings.IndexAny(line, "=:")
+		if pos < 0 {
+			problems = append(problems, DeployProblem{File: f.Name, Line: i + 1, Message: fmt.Sprintf("配置项格式错误, 应为 key = value: %s", line)})
+			continue
+		}
+		value := line[pos+1:]
+		for _, c := range []string{" #", " ;", "\t#", "\t;"} {
+			if n := strings.Index(value, c); n >= 0 {
+				value = value[:n]
+			}
+		}
+		value = strings.TrimSpace(value)
+		if len(value) >= 2 && (value[0] == '"' || value[0] == '`') && value[len(value)-1] == value[0] {
+			value = value[1 : len(value)-1]
+		}
+		current.set(strings.TrimSpace(line[:pos]), value, i+1)
+	}
+	return problems
+}
+
+var yamlLineRegexp = regexp.MustCompile(`line (\d+)`)
+
+func (f *DeployFile) readYAML(data []byte) []DeployProblem {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		p := DeployProblem{File: f.Name, Message: err.Error()}
+		if m := yamlLineRegexp.FindStringSubmatch(err.Error()); m != nil {
+			p.Line, _ = strconv.Atoi(m[1])
+		}
+		return []DeployProblem{p}
+	}
+	if len(doc.Content) == 0 {
+		return []DeployProblem{{File: f.Name, Message: "配置文件为空"}}
+	}
+	root := doc.Content[0]
+	if root.Kind != yaml.MappingNode {
+		return []DeployProblem{{File: f.Name, Line: root.Line, Message: "配置文件的顶层必须是 key: value 的映射"}}
+	}
+
+	r := &yamlReader{f: f}
+	f.section("", 0)
+	server := f.section("server", 0)
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		key, value := root.Content[i], root.Content[i+1]
+		switch name := key.Value; {
+		case name == "engine":
+			f.Engine = r.scalar(name, value)
+		case name == "yes" || name == "no-rollback":
+			f.section("", 0).set(name, r.scalar(name, value), value.Line)
+		case name == "master":
+			server.set("master", r.scalar(name, value), value.Line)
+		case name == "hosts":
+			server.set("address", r.list(name, value), value.Line)
+		case name == "ssh":
+			r.ssh(server, value)
+		case name == "replicas":
+			r.replicas(value)
+		case isDeployEngine(name):
+			r.params(f.section(name, key.Line), value)
+		default:
+			r.problem(key.Line, "未知的配置项: %s", name)
+		}
+	}
+	return r.problems
+}
+
+func isDeployEngine(name string) bool {
+	for _, e := range DeployEngines {
+		if e == name {
+			return true
+		}
+	}
+	return false
+}
+
+type yamlReader struct {
+	f        *DeployFile
+	problems []DeployProblem
+}
+
+func (r *yamlReader) problem(line int, format string, args ...interface{}) {
+	r.problems = append(r.problems, DeployProblem{File: r.f.Name, Line: line, Message: fmt.Sprintf(format, args...)})
+}
+
+func (r *yamlReader) scalar(name string, n *yaml.Node) string {
+	if n.Kind != yaml.ScalarNode {
+		r.problem(n.Line, "%s 的值必须是字符串、数字或布尔值", name)
+		return ""
+	}
+	return n.Value
+}
+
+// list 列表转换成逗号分隔的字符串, 也可以直接写成逗号分隔的字符串
+func (r *yamlReader) list(name string, n *yaml.Node) string {
+	if n.Kind != yaml.SequenceNode {
+		return r.scalar(name, n)
+	}
+	var items []string
+	for _, item := range n.Content {
+		items = append(items, r.scalar(name, item))
+	}
+	return strings.Join(items, ",")
+}
+
+func (r *yamlReader) mapping(name string, n *yaml.Node) bool {
+	if n.Kind != yaml.MappingNode {
+		r.problem(n.Line, "%s 必须是 key: value 的映射", name)
+		return false
+	}
+	return true
+}
+
+func (r *yamlReader) ssh(server *DeploySection, n *yaml.Node) {
+	if !r.mapping("ssh", n) {
+		return
+	}
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		key, value := n.Content[i], n.Content[i+1]
+		iniKey, ok := DeploySSHKeys[key.Value]
+		if !ok {
+			r.problem(key.Line, "ssh 中未知的配置项: %s", key.Value)
+			continue
+		}
+		if key.Value == "proxy-jump" && value.Kind == yaml.SequenceNode {
+			var hosts sshutil.JumpHosts
+			if err := value.Decode(&hosts); err != nil {
+				r.problem(value.Line, "proxy-jump 格式错误: %v", err)
+				continue
+			}
+			server.set(iniKey, hosts.String(), value.Line)
+			continue
+		}
+		server.set(iniKey, r.scalar("ssh."+key.Value, value), value.Line)
+	}
+}
+
+// replicas 每个从节点转换成一个 [slave.<名称>] section, 没有指定名称时以主机为名称
+func (r *yamlReader) replicas(n *yaml.Node) {
+	if n.Kind != yaml.SequenceNode {
+		r.problem(n.Line, "replicas 必须是列表")
+		return
+	}
+	for _, item := range n.Content {
+		if !r.mapping("replicas 中的每一项", item) {
+			continue
+		}
+		name, host := "", ""
+		for i := 0; i+1 < len(item.Content); i += 2 {
+			switch item.Content[i].Value {
+			case "name":
+				name = r.scalar("name", item.Content[i+1])
+			case "host":
+				host = r.scalar("host", item.Content[i+1])
+			}
+		}
+		if name == "" {
+			name = host
+		}
+		if name == "" {
+			r.problem(item.Line, "从节点没有指定 host")
+			continue
+		}
+		if r.f.Section(ReplicaSectionPrefix+name) != nil {
+			r.problem(item.Line, "从节点名称重复: %s", name)
+			continue
+		}
+		section := r.f.section(ReplicaSectionPrefix+name, item.Line)
+		for i := 0; i+1 < len(item.Content); i += 2 {
+			key, value := item.Content[i], item.Content[i+1]
+			if key.Value != "name" {
+				section.set(key.Value, r.scalar(key.Value, value), value.Line)
+			}
+		}
+	}
+}
+
+func (r *yamlReader) params(section *DeploySection, n *yaml.Node) {
+	if !r.mapping(section.Name, n) {
+		return
+	}
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		key, value := n.Content[i], n.Content[i+1]
+		section.set(key.Value, r.list(section.Name+"."+key.Value, value), value.Line)
+	}
+}
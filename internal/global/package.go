@@ -0,0 +1,388 @@
+package global
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"dbup/internal/utils"
+	"dbup/internal/utils/logger"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// 安装包清单: 每行 "<sha256>  <类型>/<安装包>", 与 sha256sum 的输出格式相同; 清单的 Ed25519 签名以 base64 保存在签名文件中
+const (
+	ManifestFileName  = "manifest"
+	SignatureFileName = "manifest.sig"
+	manifestHeader    = "# dbup package manifest"
+)
+
+// PackageKeyFile 信任的安装包签名公钥, 每行一个 base64 编码的公钥
+const PackageKeyFile = "/etc/dbup/package.pub"
+
+// PackagePublicKey 编译时内置的安装包签名公钥(base64), 通过 -ldflags "-X dbup/internal/global.PackagePublicKey=..." 指定
+var PackagePublicKey string
+
+// Manifest 安装包清单, key 为 "<类型>/<安装包>"
+type Manifest struct {
+	Digests map[string]string
+}
+
+// ParseManifest 解析安装包清单
+func ParseManifest(data []byte) (*Manifest, error) {
+	m := &Manifest{Digests: make(map[string]string)}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 2 || len(fields[0]) != sha256.Size*2 {
+			return nil, fmt.Errorf("安装包清单第 %d 行格式错误: %s", n, line)
+		}
+		if _, err := hex.DecodeString(fields[0]); err != nil {
+			return nil, fmt.Errorf("安装包清单第 %d 行 sha256 值错误: %s", n, fields[0])
+		}
+		m.Digests[strings.TrimPrefix(fields[1], "*")] = strings.ToLower(fields[0])
+	}
+	return m, scanner.Err()
+}
+
+// Names 按名称排序的安装包列表
+func (m *Manifest) Names() []string {
+	names := make([]string, 0, len(m.Digests))
+	for name := range m.Digests {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Bytes 按安装包名称排序生成清单内容
+func (m *Manifest) Bytes() []byte {
+	var b bytes.Buffer
+	b.WriteString(manifestHeader + "\n")
+	for _, name := range m.Names() {
+		fmt.Fprintf(&b, "%s  %s\n", m.Digests[name], name)
+	}
+	return b.Bytes()
+}
+
+// BuildManifest 计算安装包目录下所有 <类型>/*.tar.gz 的 sha256, 生成清单
+func BuildManifest(packageDir string) (*Manifest, error) {
+	files, err := filepath.Glob(filepath.Join(packageDir, "*", "*.tar.gz"))
+	if err != nil {
+		return nil, err
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("目录 %s 下没有安装包", packageDir)
+	}
+	m := &Manifest{Digests: make(map[string]string)}
+	for _, f := range files {
+		digest, err := Sha256File(f)
+		if err != nil {
+			return nil, err
+		}
+		m.Digests[path.Join(filepath.Base(filepath.Dir(f)), filepath.Base(f))] = digest
+	}
+	return m, nil
+}
+
+// Sha256File 计算文件的 sha256
+func Sha256File(file string) (string, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// GenerateSigningKey 生成签名密钥, 返回 base64 编码的公钥和私钥
+func GenerateSigningKey() (string, string, error) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return "", "", err
+	}
+	return base64.StdEncoding.EncodeToString(pub), base64.StdEncoding.EncodeToString(priv.Seed()), nil
+}
+
+// LoadSigningKey 读取 base64 编码的私钥文件
+func LoadSigningKey(file string) (ed25519.PrivateKey, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("读取私钥文件失败: %v", err)
+	}
+	b, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil {
+		return nil, fmt.Errorf("私钥文件 %s 不是 base64 格式: %v", file, err)
+	}
+	switch len(b) {
+	case ed25519.SeedSize:
+		return ed25519.NewKeyFromSeed(b), nil
+	case ed25519.PrivateKeySize:
+		return ed25519.PrivateKey(b), nil
+	}
+	return nil, fmt.Errorf("私钥文件 %s 不是 Ed25519 私钥", file)
+}
+
+// ParsePublicKeys 解析公钥, 每行一个 base64 编码的公钥, 忽略空行和 # 开头的行
+func ParsePublicKeys(data string) ([]ed25519.PublicKey, error) {
+	var keys []ed25519.PublicKey
+	for _, line := range strings.Split(data, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		b, err := base64.StdEncoding.DecodeString(line)
+		if err != nil || len(b) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("公钥 %s 不是 base64 编码的 Ed25519 公钥", line)
+		}
+		keys = append(keys, ed25519.PublicKey(b))
+	}
+	return keys, nil
+}
+
+// TrustedPackageKeys 信任的签名公钥: 编译时内置的公钥和 PackageKeyFile 中的公钥
+func TrustedPackageKeys() ([]ed25519.PublicKey, error) {
+	keys, err := ParsePublicKeys(PackagePublicKey)
+	if err != nil {
+		return nil, err
+	}
+	if data, err := ioutil.ReadFile(PackageKeyFile); err == nil {
+		fileKeys, err := ParsePublicKeys(string(data))
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", PackageKeyFile, err)
+		}
+		keys = append(keys, fileKeys...)
+	} else if !os.IsNotExist(err) {
+		return nil, fmt.Errorf("读取公钥文件 %s 失败: %v", PackageKeyFile, err)
+	}
+	return keys, nil
+}
+
+// Sign 用私钥对清单签名, 返回签名文件的内容
+func (m *Manifest) Sign(key ed25519.PrivateKey) []byte {
+	return []byte(base64.StdEncoding.EncodeToString(ed25519.Sign(key, m.Bytes())) + "\n")
+}
+
+// SignManifest 生成清单并签名, 写入安装包目录下的清单和签名文件
+func SignManifest(packageDir string, key ed25519.PrivateKey) (*Manifest, error) {
+	m, err := BuildManifest(packageDir)
+	if err != nil {
+		return nil, err
+	}
+	if err := ioutil.WriteFile(filepath.Join(packageDir, ManifestFileName), m.Bytes(), 0644); err != nil {
+		return nil, err
+	}
+	if err := ioutil.WriteFile(filepath.Join(packageDir, SignatureFileName), m.Sign(key), 0644); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// LoadManifest 读取安装包目录下的清单并用 keys 验证签名. keys 为空时只读取清单, 不验证签名,
+// 清单不存在时返回 nil, 由调用方使用旧的 md5 校验; 配置了公钥时清单和签名都必须存在
+func LoadManifest(packageDir string, keys []ed25519.PublicKey) (*Manifest, error) {
+	data, err := ioutil.ReadFile(filepath.Join(packageDir, ManifestFileName))
+	if os.IsNotExist(err) {
+		if len(keys) > 0 {
+			return nil, fmt.Errorf("已配置安装包签名公钥, 但安装包目录中没有签名清单(%s)", ManifestFileName)
+		}
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("读取安装包清单失败: %v", err)
+	}
+	if len(keys) > 0 {
+		sigFile := filepath.Join(packageDir, SignatureFileName)
+		encoded, err := ioutil.ReadFile(sigFile)
+		if err != nil {
+			return nil, fmt.Errorf("读取安装包清单签名失败: %v", err)
+		}
+		sig, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(encoded)))
+		if err != nil {
+			return nil, fmt.Errorf("安装包清单签名 %s 格式错误: %v", sigFile, err)
+		}
+		if !verifySignature(keys, data, sig) {
+			return nil, fmt.Errorf("安装包清单签名验证失败, 清单被修改或不是可信的密钥签名")
+		}
+	}
+	return ParseManifest(data)
+}
+
+func verifySignature(keys []ed25519.PublicKey, data, sig []byte) bool {
+	for _, key := range keys {
+		if ed25519.Verify(key, data, sig) {
+			return true
+		}
+	}
+	return false
+}
+
+// loadTrustedManifest 按信任的公钥读取清单, 没有配置公钥时只校验 sha256
+func loadTrustedManifest(packageDir string) (*Manifest, error) {
+	keys, err := TrustedPackageKeys()
+	if err != nil {
+		return nil, err
+	}
+	m, err := LoadManifest(packageDir, keys)
+	if m != nil && len(keys) == 0 {
+		logger.Warningf("没有配置安装包签名公钥(%s), 只校验 sha256, 无法验证安装包来源\n", PackageKeyFile)
+	}
+	return m, err
+}
+
+// Verify 校验安装包的 sha256, name 为 "<类型>/<安装包>"
+func (m *Manifest) Verify(name, file string) error {
+	want, ok := m.Digests[name]
+	if !ok {
+		return fmt.Errorf("安装包清单中没有 %s", name)
+	}
+	got, err := Sha256File(file)
+	if err != nil {
+		return err
+	}
+	if got != want {
+		return fmt.Errorf("安装包 sha256 值验证不正确: \n清单中的 sha256 值为(%s) \n当前包实际 sha256 值为(%s)", want, got)
+	}
+	return nil
+}
+
+// checkMd5 按旧的 md5 文件校验安装包
+func checkMd5(packageDir, packageFullName, kinds string) error {
+	md5Vlues, err := GetMd5(filepath.Join(packageDir, Md5FileName), kinds, filepath.Base(packageFullName))
+	if err != nil {
+		return err
+	}
+
+	m, err := utils.CheckMd5sumByFile(packageFullName)
+	if err != nil {
+		return err
+	}
+
+	CurrentVlues := utils.CheckMd5sumByByte([]byte(Salt + m + "\n"))
+	if CurrentVlues != md5Vlues {
+		return fmt.Errorf("安装包md5值验证不正确: \n检测当前配置md5值为(%s) \n检测当前包实际md5值为(%s)", md5Vlues, CurrentVlues)
+	}
+	return nil
+}
+
+// ManifestFiles 安装包目录下存在的清单和签名文件, 部署时与 md5 文件一起复制到远程机器
+func ManifestFiles(packageDir string) []string {
+	var files []string
+	for _, f := range []string{ManifestFileName, SignatureFileName} {
+		if utils.IsExists(filepath.Join(packageDir, f)) {
+			files = append(files, f)
+		}
+	}
+	return files
+}
+
+// VerifyRemotePackage 校验复制到远程机器上的安装包. source 为本机 dbup 的根目录, remoteFile 为远程机器上的安装包,
+// run 在远程机器上执行命令并返回标准输出
+func VerifyRemotePackage(run func(cmd string) (string, error), source, kinds, remoteFile string) error {
+	packageDir := filepath.Join(source, "package")
+	packageName := path.Base(remoteFile)
+	localFile := filepath.Join(packageDir, kinds, packageName)
+
+	var want string
+	m, err := loadTrustedManifest(packageDir)
+	if err != nil {
+		return err
+	}
+	if m != nil {
+		var ok bool
+		if want, ok = m.Digests[path.Join(kinds, packageName)]; !ok {
+			return fmt.Errorf("安装包清单中没有 %s", path.Join(kinds, packageName))
+		}
+	} else {
+		if err := checkMd5(packageDir, localFile, kinds); err != nil {
+			return err
+		}
+		if want, err = Sha256File(localFile); err != nil {
+			return err
+		}
+	}
+
+	stdout, err := run(fmt.Sprintf("sha256sum '%s'", remoteFile))
+	if err != nil {
+		return fmt.Errorf("计算安装包 %s 的 sha256 失败: %v", remoteFile, err)
+	}
+	fields := strings.Fields(stdout)
+	if len(fields) == 0 || fields[0] != want {
+		return fmt.Errorf("复制后的安装包 %s sha256 值验证不正确: 应为(%s) 实际为(%s)", remoteFile, want, strings.TrimSpace(stdout))
+	}
+	return nil
+}
+
+// PackageCheck 一个安装包的校验结果
+type PackageCheck struct {
+	Name   string `json:"name"`
+	Method string `json:"method"`
+	Error  string `json:"error,omitempty"`
+}
+
+// VerifyPackageDir 校验安装包目录: 有清单时验证签名和清单中每个安装包的 sha256, 并列出不在清单中的安装包;
+// 没有清单时按旧的 md5 文件校验每个安装包
+func VerifyPackageDir(packageDir string, keys []ed25519.PublicKey) ([]PackageCheck, error) {
+	files, err := filepath.Glob(filepath.Join(packageDir, "*", "*.tar.gz"))
+	if err != nil {
+		return nil, err
+	}
+
+	if !utils.IsExists(filepath.Join(packageDir, ManifestFileName)) {
+		if len(keys) > 0 {
+			return nil, fmt.Errorf("已配置安装包签名公钥, 但安装包目录中没有签名清单(%s)", ManifestFileName)
+		}
+		var checks []PackageCheck
+		for _, f := range files {
+			kinds := filepath.Base(filepath.Dir(f))
+			c := PackageCheck{Name: path.Join(kinds, filepath.Base(f)), Method: "md5"}
+			if err := checkMd5(packageDir, f, kinds); err != nil {
+				c.Error = err.Error()
+			}
+			checks = append(checks, c)
+		}
+		return checks, nil
+	}
+
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("没有可用的签名公钥, 请指定公钥或配置 %s", PackageKeyFile)
+	}
+	m, err := LoadManifest(packageDir, keys)
+	if err != nil {
+		return nil, err
+	}
+	var checks []PackageCheck
+	for name := range m.Digests {
+		c := PackageCheck{Name: name, Method: "sha256"}
+		if err := m.Verify(name, filepath.Join(packageDir, filepath.FromSlash(name))); err != nil {
+			c.Error = err.Error()
+		}
+		checks = append(checks, c)
+	}
+	for _, f := range files {
+		name := path.Join(filepath.Base(filepath.Dir(f)), filepath.Base(f))
+		if _, ok := m.Digests[name]; !ok {
+			checks = append(checks, PackageCheck{Name: name, Method: "sha256", Error: "安装包不在清单中"})
+		}
+	}
+	sort.Slice(checks, func(i, j int) bool { return checks[i].Name < checks[j].Name })
+	return checks, nil
+}
@@ -0,0 +1,92 @@
+package global
+
+import (
+	"crypto/ed25519"
+	"dbup/internal/utils"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writePackage(t *testing.T, dir, kinds, name, content string) string {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Join(dir, kinds), 0755); err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(dir, kinds, name)
+	if err := ioutil.WriteFile(file, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return file
+}
+
+func TestManifest(t *testing.T) {
+	dir := t.TempDir()
+	file := writePackage(t, dir, "redis", "redis6_linux_amd64.tar.gz", "redis")
+	writePackage(t, dir, "pgsql", "pgsql12_linux_amd64.tar.gz", "pgsql")
+
+	pub, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := SignManifest(dir, priv); err != nil {
+		t.Fatal(err)
+	}
+	checks, err := VerifyPackageDir(dir, []ed25519.PublicKey{pub})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range checks {
+		if c.Error != "" {
+			t.Fatalf("%s 校验失败: %s", c.Name, c.Error)
+		}
+	}
+
+	other, _, _ := ed25519.GenerateKey(nil)
+	if _, err := LoadManifest(dir, []ed25519.PublicKey{other}); err == nil {
+		t.Fatal("其他密钥签名的清单不能通过验证")
+	}
+
+	if err := ioutil.WriteFile(file, []byte("modified"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	m, err := LoadManifest(dir, []ed25519.PublicKey{pub})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Verify("redis/redis6_linux_amd64.tar.gz", file); err == nil {
+		t.Fatal("被修改的安装包不能通过验证")
+	}
+}
+
+func TestLegacyMd5(t *testing.T) {
+	dir := t.TempDir()
+	file := writePackage(t, dir, "redis", "redis6_linux_amd64.tar.gz", "redis")
+	sum, err := utils.CheckMd5sumByFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	md5 := fmt.Sprintf("[redis]\nredis6_linux_amd64.tar.gz = %s\n", utils.CheckMd5sumByByte([]byte(Salt+sum+"\n")))
+	if err := ioutil.WriteFile(filepath.Join(dir, Md5FileName), []byte(md5), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	checks, err := VerifyPackageDir(dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(checks) != 1 || checks[0].Method != "md5" || checks[0].Error != "" {
+		t.Fatalf("旧的 md5 校验结果不正确: %+v", checks)
+	}
+
+	// 配置了公钥时不能退回到 md5 校验
+	pub, _, _ := ed25519.GenerateKey(nil)
+	if _, err := LoadManifest(dir, []ed25519.PublicKey{pub}); err == nil {
+		t.Fatal("配置了公钥时缺少清单应该报错")
+	}
+	if _, err := VerifyPackageDir(dir, []ed25519.PublicKey{pub}); err == nil {
+		t.Fatal("配置了公钥时缺少清单应该报错")
+	}
+}
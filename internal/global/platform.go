@@ -0,0 +1,160 @@
+package global
+
+import (
+	"dbup/internal/environment"
+	"dbup/internal/utils"
+	"dbup/internal/utils/command"
+	"dbup/internal/utils/logger"
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// PlatformDirFormat 每个架构的安装目录名, 与 oasis 仓库和离线安装包中的目录名相同
+const PlatformDirFormat = "oasis-linux-%s"
+
+// MinGlibcVersion 安装包编译时使用的 glibc 版本(el7), 低于该版本的机器无法运行 dbup 和数据库
+const MinGlibcVersion = "2.17"
+
+// uname -m 到安装包架构名的映射
+var machineArches = map[string]string{
+	"x86_64":  "amd64",
+	"amd64":   "amd64",
+	"aarch64": "arm64",
+	"arm64":   "arm64",
+}
+
+// 探测目标机器的命令, 每行为 key=value, 后面是 /etc/os-release 的内容
+const probePlatformCmd = `echo "os=$(uname -s)"; echo "machine=$(uname -m)"; ` +
+	`echo "libc=$(getconf GNU_LIBC_VERSION 2>/dev/null || ldd --version 2>&1 | head -n 1)"; ` +
+	`cat /etc/os-release 2>/dev/null`
+
+// Platform 目标机器的操作系统和架构, 用于选择 dbup 和安装包
+type Platform struct {
+	OS        string // 与 GOOS 相同, 如 linux
+	Arch      string // 与 GOARCH 相同, 如 amd64, arm64
+	Machine   string // uname -m 的结果
+	ID        string // /etc/os-release 中的 ID, 如 centos, kylin, ubuntu
+	VersionID string // /etc/os-release 中的 VERSION_ID
+	Name      string // /etc/os-release 中的 PRETTY_NAME
+	Glibc     string // glibc 版本
+}
+
+// LocalPlatform 当前机器(运行 dbup 的机器)的平台
+func LocalPlatform() *Platform {
+	return &Platform{OS: environment.GlobalEnv().GOOS, Arch: environment.GlobalEnv().GOARCH}
+}
+
+// ProbePlatform 在目标机器上探测 uname -m, /etc/os-release 和 glibc 版本, 不支持的平台返回错误
+func ProbePlatform(run func(cmd string) (string, error)) (*Platform, error) {
+	stdout, err := run(probePlatformCmd)
+	if err != nil {
+		return nil, fmt.Errorf("探测操作系统和架构失败: %v, 标准输出: %s", err, stdout)
+	}
+	return ParsePlatform(stdout)
+}
+
+// ParsePlatform 解析探测命令的输出
+func ParsePlatform(stdout string) (*Platform, error) {
+	values := make(map[string]string)
+	for _, line := range strings.Split(stdout, "\n") {
+		kv := strings.SplitN(strings.TrimSpace(line), "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		values[kv[0]] = strings.Trim(kv[1], `"'`)
+	}
+
+	p := &Platform{
+		OS:        strings.ToLower(values["os"]),
+		Machine:   values["machine"],
+		ID:        values["ID"],
+		VersionID: values["VERSION_ID"],
+		Name:      values["PRETTY_NAME"],
+	}
+	if p.OS != "linux" {
+		return p, fmt.Errorf("不支持的操作系统: %s", values["os"])
+	}
+	arch, ok := machineArches[p.Machine]
+	if !ok {
+		return p, fmt.Errorf("不支持的架构: %s", p.Machine)
+	}
+	p.Arch = arch
+
+	// getconf 输出 "glibc 2.17", ldd 输出 "ldd (GNU libc) 2.17"
+	libc := values["libc"]
+	fields := strings.Fields(libc)
+	if len(fields) == 0 || !(strings.HasPrefix(libc, "glibc") || strings.Contains(libc, "GNU libc") || strings.Contains(libc, "GLIBC")) {
+		return p, fmt.Errorf("没有找到 glibc, 不支持的 C 库: %s", libc)
+	}
+	p.Glibc = fields[len(fields)-1]
+	if command.CompareVersion(p.Glibc, MinGlibcVersion) < 0 {
+		return p, fmt.Errorf("glibc 版本 %s 低于安装包要求的 %s", p.Glibc, MinGlibcVersion)
+	}
+	return p, nil
+}
+
+// String 用于输出的平台信息
+func (p *Platform) String() string {
+	name := p.Name
+	if name == "" {
+		name = strings.TrimSpace(p.ID + " " + p.VersionID)
+	}
+	s := fmt.Sprintf("%s/%s", p.OS, p.Arch)
+	if name != "" {
+		s += ", " + name
+	}
+	if p.Glibc != "" {
+		s += ", glibc " + p.Glibc
+	}
+	return s
+}
+
+// PackageName 按平台格式化安装包文件名, format 的参数依次为版本、操作系统和架构
+func (p *Platform) PackageName(format, version string) string {
+	return fmt.Sprintf(format, version, p.OS, p.Arch)
+}
+
+// Source 返回该平台使用的安装目录(包含 bin/dbup 和 package), 并检查其中有 kind 类型的安装包 name.
+// 与本机架构相同时使用 source 本身, 否则在 source 的同级目录和子目录中查找 oasis-linux-<arch>
+func (p *Platform) Source(source, kind, name string) (string, error) {
+	dir := fmt.Sprintf(PlatformDirFormat, p.Arch)
+	var candidates []string
+	if p.Arch == environment.GlobalEnv().GOARCH {
+		candidates = append(candidates, source)
+	}
+	candidates = append(candidates, filepath.Join(filepath.Dir(source), dir), filepath.Join(source, dir))
+
+	for _, c := range candidates {
+		if !utils.IsExists(filepath.Join(c, "bin", "dbup")) {
+			continue
+		}
+		if utils.IsExists(filepath.Join(c, "package", kind, name)) {
+			return c, nil
+		}
+		return "", fmt.Errorf("目标机器为 %s, 在 %s 下没有找到安装包 %s", p, filepath.Join(c, "package", kind), name)
+	}
+	return "", fmt.Errorf("目标机器为 %s, 没有找到 %s 架构的 dbup, 请将 %s 目录放在 %s 旁边", p, p.Arch, dir, source)
+}
+
+// CheckPlatform 探测目标机器 host 的操作系统和架构, 返回对应平台 kind 类型安装包的来源目录和文件名.
+// *p 不为空时使用已经探测到的平台, 否则通过 run 在目标机器上探测后保存到 *p; pkgName 按平台生成安装包文件名
+func CheckPlatform(host string, p **Platform, run func(cmd string) ([]byte, error), source, kind string, pkgName func(p *Platform) string) (string, string, error) {
+	if *p == nil {
+		platform, err := ProbePlatform(func(cmd string) (string, error) {
+			out, err := run(cmd)
+			return string(out), err
+		})
+		if err != nil {
+			return "", "", fmt.Errorf("在机器: %s 上, %v", host, err)
+		}
+		logger.Infof("机器: %s, 平台: %s\n", host, platform)
+		*p = platform
+	}
+	pkg := pkgName(*p)
+	dir, err := (*p).Source(source, kind, pkg)
+	if err != nil {
+		return "", "", fmt.Errorf("在机器: %s 上, %v", host, err)
+	}
+	return dir, pkg, nil
+}
@@ -0,0 +1,75 @@
+package global
+
+import (
+	"dbup/internal/environment"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParsePlatform(t *testing.T) {
+	p, err := ParsePlatform("os=Linux\nmachine=aarch64\nlibc=glibc 2.28\nNAME=\"Kylin Linux Advanced Server\"\nID=\"kylin\"\nVERSION_ID=\"V10\"\nPRETTY_NAME=\"Kylin Linux Advanced Server V10 (Sword)\"\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.OS != "linux" || p.Arch != "arm64" || p.ID != "kylin" || p.VersionID != "V10" || p.Glibc != "2.28" {
+		t.Fatalf("解析结果不正确: %+v", p)
+	}
+	if name := p.PackageName("pgsql%s_%s_%s.tar.gz", "12"); name != "pgsql12_linux_arm64.tar.gz" {
+		t.Fatalf("安装包文件名不正确: %s", name)
+	}
+
+	// ldd 的输出格式
+	if p, err := ParsePlatform("os=Linux\nmachine=x86_64\nlibc=ldd (GNU libc) 2.17\nID=centos\n"); err != nil || p.Arch != "amd64" || p.Glibc != "2.17" {
+		t.Fatalf("解析 ldd 输出失败: %+v, %v", p, err)
+	}
+
+	for _, out := range []string{
+		"os=Linux\nmachine=ppc64le\nlibc=glibc 2.28\n",
+		"os=Linux\nmachine=x86_64\nlibc=glibc 2.12\n",
+		"os=Linux\nmachine=x86_64\nlibc=musl libc (x86_64)\n",
+		"os=Darwin\nmachine=arm64\nlibc=\n",
+	} {
+		if _, err := ParsePlatform(out); err == nil {
+			t.Fatalf("不支持的平台没有报错: %q", out)
+		}
+	}
+}
+
+func TestCheckPlatform(t *testing.T) {
+	environment.SetGlobalEnv(&environment.Environment{GOOS: "linux", GOARCH: "amd64"})
+	source := t.TempDir()
+	for _, file := range []string{"bin/dbup", "package/pgsql/pgsql12_linux_amd64.tar.gz"} {
+		if err := os.MkdirAll(filepath.Dir(filepath.Join(source, file)), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(source, file), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var p *Platform
+	runs := 0
+	run := func(cmd string) ([]byte, error) {
+		runs++
+		return []byte("os=Linux\nmachine=x86_64\nlibc=glibc 2.28\n"), nil
+	}
+	pkgName := func(p *Platform) string { return p.PackageName("pgsql%s_%s_%s.tar.gz", "12") }
+	for i := 0; i < 2; i++ {
+		dir, pkg, err := CheckPlatform("10.0.0.1", &p, run, source, "pgsql", pkgName)
+		if err != nil || dir != source || pkg != "pgsql12_linux_amd64.tar.gz" {
+			t.Fatalf("结果不正确: %s, %s, %v", dir, pkg, err)
+		}
+	}
+	if p == nil || p.Arch != "amd64" || runs != 1 {
+		t.Fatalf("应该只探测一次并保存平台: %+v, 探测 %d 次", p, runs)
+	}
+
+	p = nil
+	arm := func(cmd string) ([]byte, error) { return []byte("os=Linux\nmachine=aarch64\nlibc=glibc 2.28\n"), nil }
+	if _, _, err := CheckPlatform("10.0.0.2", &p, arm, source, "pgsql", pkgName); err == nil || !strings.Contains(err.Error(), "10.0.0.2") {
+		t.Fatalf("没有 arm64 的安装目录时应该报错并包含机器地址: %v", err)
+	}
+}
@@ -0,0 +1,175 @@
+package global
+
+import (
+	"dbup/internal/utils"
+	"fmt"
+	"sort"
+	"strings"
+
+	"gopkg.in/ini.v1"
+)
+
+// ReplicaSectionPrefix 主从部署配置中每个从节点一个 section: [slave.<名称>]
+const ReplicaSectionPrefix = "slave."
+
+// Replica 主从部署中的一个从节点
+type Replica struct {
+	Name     string `ini:"-"`
+	Host     string `ini:"host"`
+	Port     int    `ini:"port"`     // 为空时与主库相同
+	Dir      string `ini:"dir"`      // 为空时与主库相同
+	Priority int    `ini:"priority"` // 优先级, 数字越小越优先, 用于同步从节点的选择
+	Sync     bool   `ini:"sync"`     // 是否为同步复制, 默认异步
+	Upstream string `ini:"upstream"` // 级联复制的上游从节点名称, 为空时从主库复制
+}
+
+// Addr 从节点的 host:port
+func (r *Replica) Addr() string {
+	return fmt.Sprintf("%s:%d", r.Host, r.Port)
+}
+
+// ParseReplicas 读取部署配置中的从节点: [server] 中 slaves 列出的主机(以主机名为名称), 以及每个 [slave.<名称>] section
+func ParseReplicas(cfg *ini.File, slaves string) ([]*Replica, error) {
+	var replicas []*Replica
+	for _, host := range strings.Split(slaves, ",") {
+		if host = strings.TrimSpace(host); host != "" {
+			replicas = append(replicas, &Replica{Name: host, Host: host})
+		}
+	}
+	for _, section := range cfg.Sections() {
+		if !strings.HasPrefix(section.Name(), ReplicaSectionPrefix) {
+			continue
+		}
+		r := &Replica{Name: strings.TrimPrefix(section.Name(), ReplicaSectionPrefix)}
+		if err := section.MapTo(r); err != nil {
+			return nil, fmt.Errorf("[%s] 映射到结构体失败: %v", section.Name(), err)
+		}
+		replicas = append(replicas, r)
+	}
+	return replicas, nil
+}
+
+// SaveReplicas 把从节点写入部署配置的 [slave.<名称>] section, 与 ParseReplicas 对应.
+// slaves 中列出的主机由 ParseReplicas 从 [server] 读取, 不再写入 section
+func SaveReplicas(cfg *ini.File, slaves string, replicas []*Replica) error {
+	listed := make(map[string]bool)
+	for _, host := range strings.Split(slaves, ",") {
+		listed[strings.TrimSpace(host)] = true
+	}
+	for _, r := range replicas {
+		if r.Name == "" {
+			return fmt.Errorf("从节点名称不能为空")
+		}
+		if listed[r.Name] && r.Host == r.Name {
+			continue
+		}
+		section, err := cfg.NewSection(ReplicaSectionPrefix + r.Name)
+		if err != nil {
+			return err
+		}
+		if err := section.ReflectFrom(r); err != nil {
+			return fmt.Errorf("从节点 %s 映射到 [%s] 失败: %v", r.Name, section.Name(), err)
+		}
+	}
+	return nil
+}
+
+// ResolveReplicas 补全从节点默认的端口和目录, 验证名称、地址和上游, 并按复制顺序排序: 上游在前, 同一层按优先级排序
+func ResolveReplicas(master string, replicas []*Replica, port int, dir string) ([]*Replica, error) {
+	if len(replicas) == 0 {
+		return nil, fmt.Errorf("从库不能为空")
+	}
+
+	byName := make(map[string]*Replica)
+	addrs := map[string]string{fmt.Sprintf("%s:%d", master, port): "主库"}
+	for _, r := range replicas {
+		if r.Name == "" {
+			return nil, fmt.Errorf("从节点名称不能为空")
+		}
+		if _, ok := byName[r.Name]; ok {
+			return nil, fmt.Errorf("从节点名称重复: %s", r.Name)
+		}
+		byName[r.Name] = r
+
+		if r.Host == "" {
+			return nil, fmt.Errorf("从节点 %s 没有指定 host", r.Name)
+		}
+		if err := utils.IsIPv4(r.Host); err != nil {
+			if !utils.IsHostName(r.Host) {
+				return nil, fmt.Errorf("host (%s) 即不是一个 IP 地址(%v), 又解析主机名失败", r.Host, err)
+			}
+		}
+		if r.Port == 0 {
+			r.Port = port
+		}
+		if r.Port < 1025 || r.Port > 65535 {
+			return nil, fmt.Errorf("从节点 %s 的端口号(%d)不正确, 端口号必须在 1025 ~ 65535 之间", r.Name, r.Port)
+		}
+		if r.Dir == "" {
+			r.Dir = dir
+		}
+		if other, ok := addrs[r.Addr()]; ok {
+			return nil, fmt.Errorf("从节点 %s 与 %s 的地址 %s 相同", r.Name, other, r.Addr())
+		}
+		addrs[r.Addr()] = r.Name
+	}
+
+	for _, r := range replicas {
+		if r.Upstream == "" {
+			continue
+		}
+		if _, ok := byName[r.Upstream]; !ok {
+			return nil, fmt.Errorf("从节点 %s 的上游 %s 不存在", r.Name, r.Upstream)
+		}
+		if r.Sync {
+			return nil, fmt.Errorf("从节点 %s 为级联复制, 不能设置为同步复制", r.Name)
+		}
+	}
+
+	// 按层排序, 每一层的上游都在之前的层中; 剩下排不进去的从节点有循环
+	var ordered []*Replica
+	placed := map[string]bool{"": true}
+	for len(ordered) < len(replicas) {
+		var level []*Replica
+		for _, r := range replicas {
+			if !placed[r.Name] && placed[r.Upstream] {
+				level = append(level, r)
+			}
+		}
+		if len(level) == 0 {
+			var names []string
+			for _, r := range replicas {
+				if !placed[r.Name] {
+					names = append(names, r.Name)
+				}
+			}
+			return nil, fmt.Errorf("从节点的上游有循环: %s", strings.Join(names, ", "))
+		}
+		sort.SliceStable(level, func(i, j int) bool { return level[i].Priority < level[j].Priority })
+		for _, r := range level {
+			placed[r.Name] = true
+		}
+		ordered = append(ordered, level...)
+	}
+	return ordered, nil
+}
+
+// Downstream 直接从 upstream 复制的从节点, upstream 为空时为直接从主库复制的从节点
+func Downstream(replicas []*Replica, upstream string) []*Replica {
+	var children []*Replica
+	for _, r := range replicas {
+		if r.Upstream == upstream {
+			children = append(children, r)
+		}
+	}
+	return children
+}
+
+// ReplicaHosts 从节点的主机列表, 以逗号分隔
+func ReplicaHosts(replicas []*Replica) string {
+	var hosts []string
+	for _, r := range replicas {
+		hosts = append(hosts, r.Host)
+	}
+	return strings.Join(hosts, ",")
+}
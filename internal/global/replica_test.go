@@ -0,0 +1,84 @@
+package global
+
+import (
+	"testing"
+
+	"gopkg.in/ini.v1"
+)
+
+func TestResolveReplicas(t *testing.T) {
+	cfg, err := ini.Load([]byte(`
+[server]
+slaves = 10.0.0.2
+[slave.c]
+host = 10.0.0.4
+upstream = b
+[slave.b]
+host = 10.0.0.3
+port = 5433
+priority = 1
+sync = true
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	replicas, err := ParseReplicas(cfg, "10.0.0.2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	replicas, err = ResolveReplicas("10.0.0.1", replicas, 5432, "/opt/pgsql")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var names []string
+	for _, r := range replicas {
+		names = append(names, r.Name)
+	}
+	if got := ReplicaHosts(replicas); got != "10.0.0.2,10.0.0.3,10.0.0.4" {
+		t.Fatalf("从节点顺序不正确: %v", names)
+	}
+	if replicas[1].Addr() != "10.0.0.3:5433" || replicas[2].Port != 5432 || replicas[2].Dir != "/opt/pgsql" {
+		t.Fatalf("从节点默认值不正确: %+v, %+v", replicas[1], replicas[2])
+	}
+	if len(Downstream(replicas, "b")) != 1 {
+		t.Fatal("级联复制的下游不正确")
+	}
+
+	for _, bad := range [][]*Replica{
+		nil,
+		{{Name: "a", Host: "10.0.0.1"}},
+		{{Name: "a", Host: "10.0.0.2"}, {Name: "a", Host: "10.0.0.3"}},
+		{{Name: "a", Host: "10.0.0.2", Upstream: "x"}},
+		{{Name: "a", Host: "10.0.0.2", Upstream: "b"}, {Name: "b", Host: "10.0.0.3", Upstream: "a"}},
+		{{Name: "a", Host: "10.0.0.2"}, {Name: "b", Host: "10.0.0.3", Upstream: "a", Sync: true}},
+	} {
+		if _, err := ResolveReplicas("10.0.0.1", bad, 5432, ""); err == nil {
+			t.Fatalf("没有报错: %+v", bad)
+		}
+	}
+}
+
+func TestSaveReplicas(t *testing.T) {
+	replicas := []*Replica{
+		{Name: "10.0.0.2", Host: "10.0.0.2"},
+		{Name: "b", Host: "10.0.0.3", Port: 5433, Priority: 1, Sync: true},
+		{Name: "c", Host: "10.0.0.4", Dir: "/data/pgsql", Upstream: "b"},
+	}
+	cfg := ini.Empty()
+	if err := SaveReplicas(cfg, "10.0.0.2", replicas); err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := ParseReplicas(cfg, "10.0.0.2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(parsed) != 3 {
+		t.Fatalf("从节点数量不正确: %d", len(parsed))
+	}
+	for i, r := range parsed {
+		if *r != *replicas[i] {
+			t.Fatalf("从节点 %s 写入后读取不一致: %+v", replicas[i].Name, r)
+		}
+	}
+}
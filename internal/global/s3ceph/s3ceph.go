@@ -138,6 +138,29 @@ func (c *S3Ceph) ListObjectFromBucket(bucket string, prefix string) (result []S3
 
 }
 
+// ListAllObjects 同 ListObjectFromBucket, 按页列出前缀下的所有对象, 不受单次 1000 个的限制
+func (c *S3Ceph) ListAllObjects(bucket string, prefix string) (result []S3Object, err error) {
+	if !strings.HasPrefix(bucket, "/") {
+		bucket = "/" + bucket
+	}
+
+	params := &s3.ListObjectsInput{Bucket: aws.String(bucket), Prefix: aws.String(prefix)}
+
+	svc := s3.New(c.Session)
+	err = svc.ListObjectsPages(params, func(page *s3.ListObjectsOutput, lastPage bool) bool {
+		for _, item := range page.Contents {
+			result = append(result, S3Object{
+				Key:          aws.StringValue(item.Key),
+				LastModified: aws.TimeValue(item.LastModified),
+				Size:         aws.Int64Value(item.Size),
+				StorageClass: aws.StringValue(item.StorageClass),
+			})
+		}
+		return true
+	})
+	return result, err
+}
+
 func (c *S3Ceph) Upload(bucket string, localpath string, s3path string) error {
 	if !strings.HasPrefix(bucket, "/") {
 		bucket = "/" + bucket
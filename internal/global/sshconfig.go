@@ -4,6 +4,7 @@ package global
 
 import (
 	"dbup/internal/utils"
+	"dbup/internal/utils/sshutil"
 	"fmt"
 )
 
@@ -14,6 +15,26 @@ type SSHConfig struct {
 	Password string `yaml:"password"`
 	KeyFile  string `yaml:"keyfile"`
 	TmpDir   string `yaml:"tmp-dir"`
+	// 主机密钥校验策略: strict(默认), tofu, insecure
+	HostKeyCheck string `yaml:"host-key-check"`
+	KnownHosts   string `yaml:"known-hosts"`
+	// 跳板机列表, 按连接顺序排列
+	ProxyJump sshutil.JumpHosts `yaml:"proxy-jump"`
+	// 认证方式及尝试顺序, 逗号分隔: agent, publickey, password
+	Auth           string `yaml:"auth"`
+	PassphraseFile string `yaml:"passphrase-file"`
+}
+
+func (o *SSHConfig) SSHOptions() sshutil.Options {
+	return sshutil.Options{
+		HostKeyCheck:   o.HostKeyCheck,
+		KnownHosts:     o.KnownHosts,
+		JumpHosts:      o.ProxyJump,
+		Auth:           o.Auth,
+		Password:       o.Password,
+		KeyFile:        o.KeyFile,
+		PassphraseFile: o.PassphraseFile,
+	}
 }
 
 func (o *SSHConfig) Validator() error {
@@ -26,5 +47,5 @@ func (o *SSHConfig) Validator() error {
 	if o.Port < 1 || o.Port > 65535 {
 		return fmt.Errorf("端口号(%d), 不是一个正确的端口号. 端口号必须在 1025 ~ 65535 之间", o.Port)
 	}
-	return nil
+	return o.SSHOptions().Validator()
 }
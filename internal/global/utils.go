@@ -32,7 +32,7 @@ func GetMd5(filename, kind, packageName string) (string, error) {
 	return s, nil
 }
 
-// CheckPackage 检查tar.gz包是否存在, 并验证md5值
+// CheckPackage 检查tar.gz包是否存在, 并验证签名清单中的 sha256 值; 没有签名清单且没有配置公钥时验证旧的 md5 值
 func CheckPackage(path, packageFullName, kinds string) error {
 	logger.Infof("检查安装包文件: %s\n", packageFullName)
 
@@ -41,22 +41,17 @@ func CheckPackage(path, packageFullName, kinds string) error {
 		return fmt.Errorf("在指定路径(%s)未找到安装包, 停止安装", packageFullName)
 	}
 
-	md5Filename := filepath.Join(path, PackagePath, Md5FileName)
-	md5Vlues, err := GetMd5(md5Filename, kinds, packageName)
+	packageDir := filepath.Join(path, PackagePath)
+	m, err := loadTrustedManifest(packageDir)
 	if err != nil {
 		return err
 	}
-
-	m, err := utils.CheckMd5sumByFile(packageFullName)
-	if err != nil {
-		return err
+	if m != nil {
+		return m.Verify(kinds+"/"+packageName, packageFullName)
 	}
 
-	CurrentVlues := utils.CheckMd5sumByByte([]byte(Salt + m + "\n"))
-	if CurrentVlues != md5Vlues {
-		return fmt.Errorf("安装包md5值验证不正确: \n检测当前配置md5值为(%s) \n检测当前包实际md5值为(%s)", md5Vlues, CurrentVlues)
-	}
-	return nil
+	logger.Warningf("安装包目录中没有签名清单(%s), 使用旧的 md5 校验\n", ManifestFileName)
+	return checkMd5(packageDir, packageFullName, kinds)
 }
 
 // INILoadFromFile 从INI配置文件加载配置到结构体
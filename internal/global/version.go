@@ -0,0 +1,32 @@
+package global
+
+import (
+	"dbup/internal/environment"
+	"dbup/internal/utils"
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// CheckVersion 检查要安装的版本, version 为空时使用默认版本. supported 为支持的大版本,
+// version 与其中一个相同或以 "<大版本>." 开头时支持, 返回对应的大版本
+func CheckVersion(name, version, defaultVersion string, supported []string) (string, string, error) {
+	if version == "" {
+		version = defaultVersion
+	}
+	for _, major := range supported {
+		if version == major || strings.HasPrefix(version, major+".") {
+			return version, major, nil
+		}
+	}
+	return "", "", fmt.Errorf("不支持的 %s 版本: %s, 支持的版本: %s", name, version, strings.Join(supported, ", "))
+}
+
+// ServiceTemplateFile 大版本对应的 systemd 模板文件名: systemd 目录下存在 <名称>-<大版本>.service.template 时使用, 否则使用通用模板
+func ServiceTemplateFile(file, major string) string {
+	versioned := strings.TrimSuffix(file, ".service.template") + "-" + major + ".service.template"
+	if utils.IsExists(filepath.Join(environment.GlobalEnv().ProgramPath, ServiceTemplatePath, versioned)) {
+		return versioned
+	}
+	return file
+}
@@ -0,0 +1,24 @@
+package global
+
+import "testing"
+
+func TestCheckVersion(t *testing.T) {
+	supported := []string{"10.6", "10.11"}
+	cases := []struct {
+		version, want, major string
+		ok                   bool
+	}{
+		{"", "10.11.8", "10.11", true},
+		{"10.6", "10.6", "10.6", true},
+		{"10.6.15", "10.6.15", "10.6", true},
+		{"10.11.8", "10.11.8", "10.11", true},
+		{"10.1", "", "", false},
+		{"10.61", "", "", false},
+	}
+	for _, c := range cases {
+		v, major, err := CheckVersion("MariaDB", c.version, "10.11.8", supported)
+		if (err == nil) != c.ok || v != c.want || major != c.major {
+			t.Errorf("CheckVersion(%q) = %q, %q, %v", c.version, v, major, err)
+		}
+	}
+}
@@ -0,0 +1,260 @@
+package inventory
+
+// 实例清单: 记录本机上由 dbup 安装的实例, 以及从本机部署出去的集群, 保存在 ~/.dbup/inventory.json.
+// 单机安装/卸载时更新实例, 集群部署/删除集群时更新集群成员.
+// 远程机器上的实例由远程 dbup 安装时记录在远程机器自己的清单中, 查看时通过 ssh 汇总
+
+import (
+	"dbup/internal/environment"
+	"dbup/internal/output"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// File 清单文件名, 位于 ~/.dbup 目录下
+const File = "inventory.json"
+
+const fileVersion = 1
+
+// 同一进程内读取-修改-保存清单时加锁
+var lock sync.Mutex
+
+// Instance 一个数据库实例
+type Instance struct {
+	Host        string    `json:"host,omitempty"` // 本机记录的实例为空, 汇总远程机器时填写
+	Engine      string    `json:"engine"`
+	Version     string    `json:"version"`
+	Port        int       `json:"port"`
+	Dir         string    `json:"dir"`
+	DataDir     string    `json:"data_dir,omitempty"`
+	Role        string    `json:"role,omitempty"`
+	Service     string    `json:"service"`
+	Cluster     string    `json:"cluster,omitempty"` // 所属集群, 查看时根据集群成员填写
+	InstalledAt time.Time `json:"installed_at"`
+}
+
+// Name 实例名称, 与 info 文件名及凭据库中的名称一致, 例如 pgsql5432
+func (i Instance) Name() string {
+	return fmt.Sprintf("%s%d", i.Engine, i.Port)
+}
+
+// Member 集群成员
+type Member struct {
+	Host string `json:"host"`
+	Port int    `json:"port"`
+	Role string `json:"role,omitempty"`
+	Dir  string `json:"dir,omitempty"`
+}
+
+// Cluster 从本机部署的集群
+type Cluster struct {
+	Name       string    `json:"name"`
+	Engine     string    `json:"engine"`
+	Mode       string    `json:"mode"` // 部署方式, 例如 master-slave, repmgr, galera, redis-cluster
+	Members    []Member  `json:"members"`
+	DeployedAt time.Time `json:"deployed_at"`
+}
+
+// ClusterName 集群名称, 由引擎和第一个节点组成, 部署和删除集群时使用同一个配置文件可以得到相同的名称
+func ClusterName(engine, host string, port int) string {
+	return fmt.Sprintf("%s-%s:%d", engine, host, port)
+}
+
+type Inventory struct {
+	Version   int        `json:"version"`
+	Instances []Instance `json:"instances"`
+	Clusters  []Cluster  `json:"clusters"`
+}
+
+// Load 读取 dir 目录下的清单, 文件不存在时返回空清单
+func Load(dir string) (*Inventory, error) {
+	file := filepath.Join(dir, File)
+	b, err := ioutil.ReadFile(file)
+	if os.IsNotExist(err) {
+		return &Inventory{Version: fileVersion}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("读取实例清单 %s 失败: %v", file, err)
+	}
+	inv, err := Parse(b)
+	if err != nil {
+		return nil, fmt.Errorf("解析实例清单 %s 失败: %v", file, err)
+	}
+	return inv, nil
+}
+
+// LoadDefault 读取 ~/.dbup 下的清单
+func LoadDefault() (*Inventory, error) {
+	return Load(environment.GlobalEnv().DbupInfoPath)
+}
+
+// Parse 解析清单内容, 内容为空时返回空清单
+func Parse(b []byte) (*Inventory, error) {
+	inv := &Inventory{Version: fileVersion}
+	if len(b) == 0 {
+		return inv, nil
+	}
+	if err := json.Unmarshal(b, inv); err != nil {
+		return nil, err
+	}
+	if inv.Version != fileVersion {
+		return nil, fmt.Errorf("不支持的清单版本: %d", inv.Version)
+	}
+	return inv, nil
+}
+
+// Save 保存到 dir 目录下, 先写临时文件再改名
+func (inv *Inventory) Save(dir string) error {
+	inv.Version = fileVersion
+	b, err := json.MarshalIndent(inv, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("创建目录 %s 失败: %v", dir, err)
+	}
+	file := filepath.Join(dir, File)
+	if err := ioutil.WriteFile(file+".tmp", b, 0644); err != nil {
+		return fmt.Errorf("保存实例清单 %s 失败: %v", file, err)
+	}
+	if err := os.Rename(file+".tmp", file); err != nil {
+		return fmt.Errorf("保存实例清单 %s 失败: %v", file, err)
+	}
+	return nil
+}
+
+// Put 添加实例, 已有相同引擎和端口的实例时替换
+func (inv *Inventory) Put(inst Instance) {
+	for n, i := range inv.Instances {
+		if i.Engine == inst.Engine && i.Port == inst.Port {
+			inv.Instances[n] = inst
+			return
+		}
+	}
+	inv.Instances = append(inv.Instances, inst)
+	sort.Slice(inv.Instances, func(a, b int) bool {
+		if inv.Instances[a].Engine != inv.Instances[b].Engine {
+			return inv.Instances[a].Engine < inv.Instances[b].Engine
+		}
+		return inv.Instances[a].Port < inv.Instances[b].Port
+	})
+}
+
+// Delete 删除实例, 返回是否存在
+func (inv *Inventory) Delete(engine string, port int) bool {
+	for n, i := range inv.Instances {
+		if i.Engine == engine && i.Port == port {
+			inv.Instances = append(inv.Instances[:n], inv.Instances[n+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// PutCluster 添加集群, 已有同名集群时替换
+func (inv *Inventory) PutCluster(c Cluster) {
+	for n, old := range inv.Clusters {
+		if old.Name == c.Name {
+			inv.Clusters[n] = c
+			return
+		}
+	}
+	inv.Clusters = append(inv.Clusters, c)
+}
+
+// DeleteCluster 删除集群, 返回是否存在
+func (inv *Inventory) DeleteCluster(name string) bool {
+	for n, c := range inv.Clusters {
+		if c.Name == name {
+			inv.Clusters = append(inv.Clusters[:n], inv.Clusters[n+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// ClusterOf 返回 host:port 所属的集群名称, 不属于任何集群时为空
+func (inv *Inventory) ClusterOf(host string, port int) string {
+	for _, c := range inv.Clusters {
+		for _, m := range c.Members {
+			if m.Host == host && m.Port == port {
+				return c.Name
+			}
+		}
+	}
+	return ""
+}
+
+// update 读取默认清单, 修改后保存
+func update(fn func(inv *Inventory)) error {
+	lock.Lock()
+	defer lock.Unlock()
+
+	dir := environment.GlobalEnv().DbupInfoPath
+	inv, err := Load(dir)
+	if err != nil {
+		return err
+	}
+	fn(inv)
+	return inv.Save(dir)
+}
+
+// Record 安装完成后记录实例
+func Record(inst Instance) error {
+	if inst.InstalledAt.IsZero() {
+		inst.InstalledAt = time.Now()
+	}
+	if err := update(func(inv *Inventory) { inv.Put(inst) }); err != nil {
+		return err
+	}
+	output.Created(output.Resource{Kind: "instance", Name: inst.Name(), Engine: inst.Engine, Port: inst.Port, Path: inst.Dir, Service: inst.Service})
+	return nil
+}
+
+// Forget 卸载后删除实例记录
+func Forget(engine string, port int) error {
+	if err := update(func(inv *Inventory) { inv.Delete(engine, port) }); err != nil {
+		return err
+	}
+	output.Removed(output.Resource{Kind: "instance", Name: fmt.Sprintf("%s%d", engine, port), Engine: engine, Port: port})
+	return nil
+}
+
+// RecordCluster 部署集群完成后记录集群成员
+func RecordCluster(c Cluster) error {
+	if c.DeployedAt.IsZero() {
+		c.DeployedAt = time.Now()
+	}
+	if err := update(func(inv *Inventory) { inv.PutCluster(c) }); err != nil {
+		return err
+	}
+	output.Created(output.Resource{Kind: "cluster", Name: c.Name, Engine: c.Engine})
+	return nil
+}
+
+// ForgetCluster 删除集群后删除集群记录
+func ForgetCluster(name string) error {
+	if err := update(func(inv *Inventory) { inv.DeleteCluster(name) }); err != nil {
+		return err
+	}
+	output.Removed(output.Resource{Kind: "cluster", Name: name})
+	return nil
+}
+
+// Members 将逗号分隔的主机列表转换为集群成员, 空的主机会被忽略
+func Members(hosts string, port int, role, dir string) []Member {
+	var members []Member
+	for _, host := range strings.Split(hosts, ",") {
+		if host = strings.TrimSpace(host); host != "" {
+			members = append(members, Member{Host: host, Port: port, Role: role, Dir: dir})
+		}
+	}
+	return members
+}
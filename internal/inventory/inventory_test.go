@@ -0,0 +1,37 @@
+package inventory
+
+import "testing"
+
+func TestInventory(t *testing.T) {
+	dir := t.TempDir()
+	inv, err := Load(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	inv.Put(Instance{Engine: "redis", Port: 6379, Role: "master"})
+	inv.Put(Instance{Engine: "pgsql", Port: 5432})
+	inv.Put(Instance{Engine: "redis", Port: 6379, Role: "slave"})
+	inv.PutCluster(Cluster{
+		Name:    ClusterName("redis", "10.0.0.1", 6379),
+		Members: Members("10.0.0.1, 10.0.0.2,", 6379, "", ""),
+	})
+	if err := inv.Save(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	if inv, err = Load(dir); err != nil {
+		t.Fatal(err)
+	}
+	if len(inv.Instances) != 2 || inv.Instances[0].Name() != "pgsql5432" || inv.Instances[1].Role != "slave" {
+		t.Fatalf("实例记录不正确: %+v", inv.Instances)
+	}
+	if c := inv.ClusterOf("10.0.0.2", 6379); c != "redis-10.0.0.1:6379" {
+		t.Fatalf("集群成员不正确: %q", c)
+	}
+	if !inv.Delete("pgsql", 5432) || inv.Delete("pgsql", 5432) {
+		t.Fatalf("删除实例结果不正确")
+	}
+	if !inv.DeleteCluster("redis-10.0.0.1:6379") || inv.ClusterOf("10.0.0.2", 6379) != "" {
+		t.Fatalf("删除集群结果不正确")
+	}
+}
@@ -0,0 +1,28 @@
+package inventory
+
+import (
+	"bytes"
+	"dbup/internal/utils/command"
+	"fmt"
+)
+
+// Gather 通过 ssh 读取远程机器上的清单. 远程 dbup 以 root 身份执行, 清单位于 root 用户的 ~/.dbup 目录下
+func Gather(conn *command.Connection) (*Inventory, error) {
+	out, err := conn.Sudo(fmt.Sprintf("cat ~/.dbup/%s 2>/dev/null || true", File), "", "")
+	if err != nil {
+		return nil, fmt.Errorf("读取机器 %s 上的实例清单失败: %v", conn.Host, err)
+	}
+	// 输出中可能包含 sudo 的密码提示, 只取 json 部分
+	start, end := bytes.IndexByte(out, '{'), bytes.LastIndexByte(out, '}')
+	if start < 0 || end < start {
+		return Parse(nil)
+	}
+	inv, err := Parse(out[start : end+1])
+	if err != nil {
+		return nil, fmt.Errorf("解析机器 %s 上的实例清单失败: %v", conn.Host, err)
+	}
+	for n := range inv.Instances {
+		inv.Instances[n].Host = conn.Host
+	}
+	return inv, nil
+}
@@ -0,0 +1,343 @@
+package journal
+
+// 部署记录: 集群部署时按节点记录每个完成的步骤, 保存在 ~/.dbup/deploys/<id>/journal.json.
+// 部署中途失败并且没有回滚时, 可以使用 --resume <id> 跳过已经完成的步骤, 从失败的步骤继续部署
+
+import (
+	"crypto/sha256"
+	"dbup/internal/environment"
+	"dbup/internal/output"
+	"dbup/internal/utils/logger"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Dir 部署记录目录, 位于 ~/.dbup 下
+const Dir = "deploys"
+
+// File 部署记录文件名
+const File = "journal.json"
+
+// 部署状态
+const (
+	StatusRunning    = "running"
+	StatusSuccess    = "success"
+	StatusFailed     = "failed"
+	StatusRolledBack = "rolled-back"
+)
+
+// Step 一个节点上的部署步骤
+type Step struct {
+	Host   string    `json:"host"`
+	Name   string    `json:"name"`
+	Status string    `json:"status"`
+	Error  string    `json:"error,omitempty"`
+	At     time.Time `json:"at"`
+}
+
+// Journal 一次部署的记录
+type Journal struct {
+	ID         string    `json:"id"`
+	Engine     string    `json:"engine"`
+	Mode       string    `json:"mode"`
+	Config     string    `json:"config"`      // 部署配置文件的绝对路径
+	ConfigHash string    `json:"config_hash"` // 部署配置文件的 sha256, 继续部署时检查配置是否被修改
+	Status     string    `json:"status"`
+	Error      string    `json:"error,omitempty"`
+	Resumes    int       `json:"resumes,omitempty"`
+	StartedAt  time.Time `json:"started_at"`
+	FinishedAt time.Time `json:"finished_at,omitempty"`
+	Steps      []Step    `json:"steps"`
+
+	mu     sync.Mutex
+	saving sync.Mutex // 多个节点并发更新时, 按顺序写文件
+}
+
+// Root 部署记录的根目录
+func Root() string {
+	return filepath.Join(environment.GlobalEnv().DbupInfoPath, Dir)
+}
+
+// Start 开始一次新的部署, 生成部署记录
+func Start(engine, mode, configFile string) (*Journal, error) {
+	abs, err := filepath.Abs(configFile)
+	if err != nil {
+		return nil, err
+	}
+	hash, err := fileHash(abs)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	j := &Journal{
+		ID:         fmt.Sprintf("%s-%s", engine, now.Format("20060102150405")),
+		Engine:     engine,
+		Mode:       mode,
+		Config:     abs,
+		ConfigHash: hash,
+		Status:     StatusRunning,
+		StartedAt:  now,
+	}
+	// 同一秒内多次部署时加序号区分
+	for n := 2; isExists(j.dir()); n++ {
+		j.ID = fmt.Sprintf("%s-%s-%d", engine, now.Format("20060102150405"), n)
+	}
+	if err := j.save(); err != nil {
+		return nil, err
+	}
+	logger.Infof("部署记录: %s\n", j.ID)
+	output.Set("deploy_id", j.ID)
+	return j, nil
+}
+
+// Load 读取部署记录
+func Load(id string) (*Journal, error) {
+	file := filepath.Join(Root(), id, File)
+	b, err := ioutil.ReadFile(file)
+	if os.IsNotExist(err) {
+		return nil, output.Errorf(output.CodeNotFound, "部署记录 %s 不存在", id)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("读取部署记录 %s 失败: %v", file, err)
+	}
+	j := &Journal{}
+	if err := json.Unmarshal(b, j); err != nil {
+		return nil, fmt.Errorf("解析部署记录 %s 失败: %v", file, err)
+	}
+	return j, nil
+}
+
+// Resume 继续一次失败的部署, engine 和 mode 必须与部署记录一致
+func Resume(id, engine, mode string) (*Journal, error) {
+	j, err := Load(id)
+	if err != nil {
+		return nil, err
+	}
+	if j.Engine != engine || j.Mode != mode {
+		return nil, output.Errorf(output.CodeInvalidArgument, "部署记录 %s 是 %s %s 部署, 不能用于 %s %s 部署", id, j.Engine, j.Mode, engine, mode)
+	}
+	switch j.Status {
+	case StatusSuccess:
+		return nil, output.Errorf(output.CodeInvalidArgument, "部署 %s 已经成功, 不需要继续", id)
+	case StatusRolledBack:
+		return nil, output.Errorf(output.CodeInvalidArgument, "部署 %s 失败后已经回滚, 请重新部署", id)
+	}
+	if hash, err := fileHash(j.Config); err != nil {
+		return nil, err
+	} else if hash != j.ConfigHash {
+		logger.Warningf("部署配置文件 %s 在上次部署后被修改过\n", j.Config)
+		j.ConfigHash = hash
+	}
+
+	j.Status = StatusRunning
+	j.Error = ""
+	j.FinishedAt = time.Time{}
+	j.Resumes++
+	if err := j.save(); err != nil {
+		return nil, err
+	}
+	logger.Infof("继续部署: %s, 跳过已经完成的 %d 个步骤\n", j.ID, j.done())
+	output.Set("deploy_id", j.ID)
+	return j, nil
+}
+
+// List 按开始时间倒序列出所有部署记录
+func List() ([]*Journal, error) {
+	dirs, err := ioutil.ReadDir(Root())
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("读取目录 %s 失败: %v", Root(), err)
+	}
+	var list []*Journal
+	for _, d := range dirs {
+		if !d.IsDir() {
+			continue
+		}
+		j, err := Load(d.Name())
+		if err != nil {
+			logger.Warningf("%v\n", err)
+			continue
+		}
+		list = append(list, j)
+	}
+	sort.Slice(list, func(a, b int) bool { return list[a].StartedAt.After(list[b].StartedAt) })
+	return list, nil
+}
+
+// Done 步骤是否已经完成. j 为空时表示不记录部署步骤, 总是返回 false
+func (j *Journal) Done(host, name string) bool {
+	if j == nil {
+		return false
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	for _, s := range j.Steps {
+		if s.Host == host && s.Name == name {
+			return s.Status == StatusSuccess
+		}
+	}
+	return false
+}
+
+// Run 执行一个步骤并记录结果, 已经完成的步骤直接跳过
+func (j *Journal) Run(host, name string, fn func() error) error {
+	if j.Done(host, name) {
+		logger.Infof("跳过已经完成的步骤: %s %s\n", host, name)
+		return nil
+	}
+	err := fn()
+	if j == nil {
+		return err
+	}
+	step := Step{Host: host, Name: name, Status: StatusSuccess, At: time.Now()}
+	if err != nil {
+		step.Status = StatusFailed
+		step.Error = err.Error()
+	}
+	if e := j.record(step); e != nil {
+		logger.Warningf("更新部署记录失败: %v\n", e)
+	}
+	return err
+}
+
+// Undo 删除步骤记录, 步骤的结果被回滚后调用, 继续部署时重新执行该步骤
+func (j *Journal) Undo(host, name string) {
+	if j == nil {
+		return
+	}
+	j.mu.Lock()
+	for n, s := range j.Steps {
+		if s.Host == host && s.Name == name {
+			j.Steps = append(j.Steps[:n], j.Steps[n+1:]...)
+			break
+		}
+	}
+	j.mu.Unlock()
+	if err := j.save(); err != nil {
+		logger.Warningf("更新部署记录失败: %v\n", err)
+	}
+}
+
+// RolledBack 部署失败后已经回滚, 不能再继续部署
+func (j *Journal) RolledBack() {
+	if j == nil {
+		return
+	}
+	j.mu.Lock()
+	j.Status = StatusRolledBack
+	j.mu.Unlock()
+}
+
+// Finish 记录部署结果
+func (j *Journal) Finish(err error) {
+	if j == nil {
+		return
+	}
+	j.mu.Lock()
+	if err == nil {
+		j.Status = StatusSuccess
+	} else {
+		j.Error = err.Error()
+		if j.Status != StatusRolledBack {
+			j.Status = StatusFailed
+		}
+	}
+	j.FinishedAt = time.Now()
+	status := j.Status
+	j.mu.Unlock()
+
+	if e := j.save(); e != nil {
+		logger.Warningf("更新部署记录失败: %v\n", e)
+		return
+	}
+	if status == StatusFailed {
+		logger.Warningf("部署失败, 修复问题后可以使用 --resume %s 从失败的步骤继续部署\n", j.ID)
+	}
+}
+
+// FailedStep 最后一个失败的步骤, 没有时返回 nil
+func (j *Journal) FailedStep() *Step {
+	for n := len(j.Steps) - 1; n >= 0; n-- {
+		if j.Steps[n].Status == StatusFailed {
+			return &j.Steps[n]
+		}
+	}
+	return nil
+}
+
+func (j *Journal) record(step Step) error {
+	j.mu.Lock()
+	replaced := false
+	for n, s := range j.Steps {
+		if s.Host == step.Host && s.Name == step.Name {
+			j.Steps[n] = step
+			replaced = true
+			break
+		}
+	}
+	if !replaced {
+		j.Steps = append(j.Steps, step)
+	}
+	j.mu.Unlock()
+	return j.save()
+}
+
+func (j *Journal) done() int {
+	var n int
+	for _, s := range j.Steps {
+		if s.Status == StatusSuccess {
+			n++
+		}
+	}
+	return n
+}
+
+func (j *Journal) dir() string {
+	return filepath.Join(Root(), j.ID)
+}
+
+// save 先写临时文件再改名
+func (j *Journal) save() error {
+	j.saving.Lock()
+	defer j.saving.Unlock()
+	j.mu.Lock()
+	b, err := json.MarshalIndent(j, "", "  ")
+	j.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(j.dir(), 0700); err != nil {
+		return fmt.Errorf("创建目录 %s 失败: %v", j.dir(), err)
+	}
+	file := filepath.Join(j.dir(), File)
+	if err := ioutil.WriteFile(file+".tmp", b, 0600); err != nil {
+		return fmt.Errorf("保存部署记录 %s 失败: %v", file, err)
+	}
+	if err := os.Rename(file+".tmp", file); err != nil {
+		return fmt.Errorf("保存部署记录 %s 失败: %v", file, err)
+	}
+	return nil
+}
+
+func fileHash(file string) (string, error) {
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return "", fmt.Errorf("读取部署配置文件 %s 失败: %v", file, err)
+	}
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+func isExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
@@ -0,0 +1,62 @@
+package journal
+
+import (
+	"dbup/internal/environment"
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func TestJournal(t *testing.T) {
+	dir := t.TempDir()
+	environment.SetGlobalEnv(&environment.Environment{DbupInfoPath: dir})
+	cfg := filepath.Join(dir, "deploy.yaml")
+	if err := ioutil.WriteFile(cfg, []byte("server: {}\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	j, err := Start("pgsql", "master-slave", cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var runs int
+	step := func() error { runs++; return nil }
+	if err := j.Run("10.0.0.1", "install", step); err != nil {
+		t.Fatal(err)
+	}
+	failed := errors.New("复制失败")
+	if err := j.Run("10.0.0.2", "replication", func() error { return failed }); err != failed {
+		t.Fatalf("步骤错误不正确: %v", err)
+	}
+	j.Finish(failed)
+
+	if _, err := Resume(j.ID, "mongodb", "sharding"); err == nil {
+		t.Fatalf("不同引擎的部署记录不能继续")
+	}
+	r, err := Resume(j.ID, "pgsql", "master-slave")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := r.FailedStep(); s == nil || s.Host != "10.0.0.2" {
+		t.Fatalf("失败步骤不正确: %+v", s)
+	}
+	if err := r.Run("10.0.0.1", "install", step); err != nil || runs != 1 {
+		t.Fatalf("已经完成的步骤不应该重新执行: %d", runs)
+	}
+	if err := r.Run("10.0.0.2", "replication", step); err != nil || runs != 2 {
+		t.Fatalf("失败的步骤应该重新执行: %d", runs)
+	}
+	r.Finish(nil)
+
+	list, err := List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 1 || list[0].Status != StatusSuccess || list[0].Resumes != 1 || list[0].FailedStep() != nil {
+		t.Fatalf("部署记录不正确: %+v", list)
+	}
+	if _, err := Resume(j.ID, "pgsql", "master-slave"); err == nil {
+		t.Fatalf("已经成功的部署不能继续")
+	}
+}
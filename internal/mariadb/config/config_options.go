@@ -9,6 +9,7 @@ import (
 	"dbup/internal/utils/arrlib"
 	"dbup/internal/utils/command"
 	"dbup/internal/utils/logger"
+	"dbup/internal/utils/sshutil"
 	"fmt"
 	"os"
 	"path/filepath"
@@ -17,7 +18,6 @@ import (
 	"strings"
 
 	"github.com/shirou/gopsutil/mem"
-	"gopkg.in/ini.v1"
 )
 
 // type MariaDBGaleraOptions struct {
@@ -49,14 +49,18 @@ type MariaDBOptions struct {
 	TxIsolation         string
 	Join                string `ini:"join" validate:"ipPort"`
 	ResourceLimit       string `ini:"resource-limit"`
+	Tune                bool   `ini:"tune"`
 	BackupData          bool   `ini:"no"`
 	AddSlave            bool   `ini:"no"`
 	AutoIncrement       int    `ini:"no"`
 	Yes                 bool   `ini:"yes"`
 	NoRollback          bool   `ini:"no-rollback"`
 	Wsrepclusteraddress string `ini:"wsrep_cluster_address"`
+	Version             string `ini:"version"`
 	Backupuser          string
 	BackupPassword      string
+	SeedBackup          string `ini:"seed-backup"`        // 用本机上的 mariabackup 物理备份初始化从库, 不从主库导出
+	SeedRootPassword    string `ini:"seed-root-password"` // 物理备份中主库的 root 密码, 为空时与 Password 相同
 	Galera              bool
 	Onenode             bool
 }
@@ -172,6 +176,17 @@ func (option *MariaDBOptions) Validator() error {
 		}
 	}
 
+	if option.SeedBackup != "" {
+		if !strings.Contains(option.Join, ":") {
+			return fmt.Errorf("--seed-backup 只用于初始化从库, 请用 --join 指定主库地址 <IP:PORT>")
+		}
+		if !utils.IsDir(option.SeedBackup) {
+			return fmt.Errorf("--seed-backup 指定的物理备份目录 %s 不存在", option.SeedBackup)
+		}
+	} else if option.SeedRootPassword != "" {
+		return fmt.Errorf("--seed-root-password 只和 --seed-backup 一起使用")
+	}
+
 	// 验证并设置 transaction-isolation 为 READ-COMMITTED || REPEATABLE-READ
 	if option.TxIsolation == "" {
 		option.TxIsolation = "RC"
@@ -187,7 +202,7 @@ func (option *MariaDBOptions) Validator() error {
 		option.TxIsolation = "REPEATABLE-READ"
 	}
 
-	return nil
+	return option.CheckVersion()
 }
 
 // 检查本地操作系统环境
@@ -315,6 +330,26 @@ type Server struct {
 	Password string `ini:"ssh-password"`
 	KeyFile  string `ini:"ssh-keyfile"`
 	TmpDir   string `ini:"tmp-dir"`
+	// 主机密钥校验策略: strict(默认), tofu, insecure
+	HostKeyCheck string `ini:"ssh-host-key-check"`
+	KnownHosts   string `ini:"ssh-known-hosts"`
+	// 跳板机, 多个以逗号分隔: [user[:password]@]host[:port][?keyfile=/path/to/key]
+	ProxyJump string `ini:"ssh-proxy-jump"`
+	// 认证方式及尝试顺序, 逗号分隔: agent, publickey, password
+	Auth           string `ini:"ssh-auth"`
+	PassphraseFile string `ini:"ssh-passphrase-file"`
+}
+
+func (s *Server) SSHOptions() sshutil.Options {
+	return sshutil.Options{
+		HostKeyCheck:   s.HostKeyCheck,
+		KnownHosts:     s.KnownHosts,
+		ProxyJump:      s.ProxyJump,
+		Auth:           s.Auth,
+		Password:       s.Password,
+		KeyFile:        s.KeyFile,
+		PassphraseFile: s.PassphraseFile,
+	}
 }
 
 func (s *Server) SetDefault() {
@@ -395,11 +430,16 @@ type MariaDBDeployOptions struct {
 	NoRollback bool `ini:"no-rollback" comment:"监听IP，如果没有特殊要求请勿修改"`
 }
 
-// Load 从配置文件加载配置到Prepare实例
+// Load 从配置文件加载配置到Prepare实例, 支持 ini 和 YAML 格式
 func (o *MariaDBDeployOptions) Load(filename string) error {
-	return global.INILoadFromFile(filename, o, ini.LoadOptions{
-		SpaceBeforeInlineComment: true,
-	})
+	cfg, err := global.LoadDeployFile(filename)
+	if err != nil {
+		return err
+	}
+	if err = cfg.MapTo(o); err != nil {
+		return fmt.Errorf("将配置文件(%s)映射到结构体对象失败: %v", filename, err)
+	}
+	return nil
 }
 
 // 检查集群的模式
@@ -434,6 +474,10 @@ func (o *MariaDBDeployOptions) Validator() error {
 		return err
 	}
 
+	if err := o.MariaDB.CheckVersion(); err != nil {
+		return err
+	}
+
 	if o.MariaDB.Password == "" {
 		return fmt.Errorf("主从环境为保证一致性 root 账号密码不能为空")
 	} else {
@@ -465,8 +509,7 @@ func (o *MariaDBDeployOptions) GaleraValidator() error {
 		return err
 	}
 
-	// 检查 galera 成员通信端口
-	if err := o.GaleraPortCheck(); err != nil {
+	if err := o.MariaDB.CheckVersion(); err != nil {
 		return err
 	}
 
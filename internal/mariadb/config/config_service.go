@@ -36,7 +36,7 @@ func (s *MariaDBService) FormatBody(option *MariaDBOptions) error {
 		filepath.Join(option.Dir, "data", "mariadb.pid"),
 		fmt.Sprintf("/tmp/.mariadb%d.sock", option.Port),
 		option.Port)
-	description := fmt.Sprintf("MariaDB %s database server", DefaultMariaDBVersion)
+	description := fmt.Sprintf("MariaDB %s database server", option.Version)
 	s.Cfg.Section("Unit").Key("Description").SetValue(description)
 
 	s.Cfg.Section("Service").Key("User").SetValue(option.SystemUser)
@@ -66,7 +66,7 @@ func (s *MariaDBService) GaleraFormatBody(option *MariaDBOptions) error {
 		filepath.Join(option.Dir, "data", "mariadb.pid"),
 		fmt.Sprintf("/tmp/.mariadb%d.sock", option.Port),
 		option.Port)
-	description := fmt.Sprintf("MariaDB %s database server", DefaultMariaDBVersion)
+	description := fmt.Sprintf("MariaDB %s database server", option.Version)
 
 	s.Cfg.Section("Unit").Key("Description").SetValue(description)
 	s.Cfg.Section("Service").Key("User").SetValue(option.SystemUser)
@@ -31,6 +31,7 @@ const (
 	MariaDBReplicationPrivileges = "REPLICATION SLAVE, REPLICATION CLIENT, SLAVE MONITOR"
 
 	DefaultMariaDBUPgradeBinFile = "mariadb-upgrade"
+	DefaultMariaDBBackupBinFile  = "mariabackup"
 	MariaDBBackupUser            = "dbupbak"
 	MariaDBBackupPrivileges      = "ALL PRIVILEGES"
 )
@@ -55,3 +56,12 @@ const (
 const (
 	DeployTmpDir = "/tmp/tmpmariadb"
 )
+
+// mariadb backup 计划任务
+const (
+	BackupTaskLinuxCronFile   = "/var/spool/cron/root"
+	BackupTaskNamePrefix      = "DbupMariaDBBackupTask"
+	BackupTaskDefaultTaskName = "mariadb_backup"
+	BackupTaskDefaultTaskTime = "02:00"
+	BackupTaskFilePrefix      = "mariadb_backup_"
+)
@@ -0,0 +1,66 @@
+package config
+
+import (
+	"dbup/internal/global"
+	"fmt"
+	"strings"
+)
+
+// SupportedVersions 支持安装的 MariaDB 大版本
+var SupportedVersions = []string{"10.6", "10.11"}
+
+// DefaultMinorVersions 只指定大版本时使用的小版本, 安装包按完整版本号命名
+var DefaultMinorVersions = map[string]string{
+	"10.11": DefaultMariaDBVersion,
+}
+
+// CheckVersion 检查要安装的 MariaDB 版本, 为空时返回默认版本; 只指定大版本时使用该大版本默认的小版本
+func CheckVersion(version string) (string, error) {
+	v, major, err := global.CheckVersion("MariaDB", version, DefaultMariaDBVersion, SupportedVersions)
+	if err != nil {
+		return "", err
+	}
+	if v != major {
+		return v, nil
+	}
+	if full, ok := DefaultMinorVersions[major]; ok {
+		return full, nil
+	}
+	return "", fmt.Errorf("MariaDB %s 需要指定完整的版本号, 如: %s.0", major, major)
+}
+
+// MajorVersion 版本号对应的大版本, 如 10.11.8 为 10.11
+func MajorVersion(version string) string {
+	if parts := strings.SplitN(version, ".", 3); len(parts) > 2 {
+		return parts[0] + "." + parts[1]
+	}
+	return version
+}
+
+// PackageName 版本对应的安装包文件名, 版本为空时使用默认版本
+func PackageName(version string) string {
+	return PlatformPackageName(version, global.LocalPlatform())
+}
+
+// PlatformPackageName 目标机器平台对应的安装包文件名, 版本为空时使用默认版本
+func PlatformPackageName(version string, p *global.Platform) string {
+	if version == "" {
+		version = DefaultMariaDBVersion
+	}
+	return p.PackageName(PackageFile, version)
+}
+
+// ServiceTemplateFile 版本对应的 systemd 模板文件名
+func ServiceTemplateFile(version string) string {
+	return global.ServiceTemplateFile(MariaDBServiceTemplateFile, MajorVersion(version))
+}
+
+// CheckVersion 检查并设置要安装的版本
+func (option *MariaDBOptions) CheckVersion() error {
+	version, err := CheckVersion(option.Version)
+	if err != nil {
+		return err
+	}
+	option.Version = version
+	return nil
+}
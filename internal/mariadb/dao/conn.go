@@ -8,6 +8,7 @@ import (
 	"fmt"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	_ "github.com/go-sql-driver/mysql"
@@ -21,7 +22,7 @@ type MariaDBConn struct {
 	DBName   string
 	Charset  string
 	URI      string
-	Errornum int
+	Errornum int64 // Parallel_check_table 并发检查表时累加, 使用 atomic 操作
 	DB       *sql.DB
 }
 
@@ -98,6 +99,12 @@ func (p *MariaDBConn) ChangeMasterTo(host string, port int, user, password strin
 	return err
 }
 
+func (p *MariaDBConn) SetGtidSlavePos(gtid string) error {
+	sql := fmt.Sprintf("SET GLOBAL gtid_slave_pos = '%s';", gtid)
+	_, err := p.DB.Exec(sql)
+	return err
+}
+
 func (p *MariaDBConn) StartSlave() error {
 	sql := "start SLAVE ;"
 	_, err := p.DB.Query(sql)
@@ -156,10 +163,10 @@ func (p *MariaDBConn) Check_table(tableName string) error {
 	for rows.Next() {
 		err := rows.Scan(&TableName, &Op, &Msg_type, &Msg_text)
 		if err != nil {
-			logger.Errorf("CHECKS 表结果异常: %s", err)
+			return fmt.Errorf("CHECKS 表结果异常: %v", err)
 		}
 		if Msg_text != "OK" {
-			p.Errornum += 1
+			atomic.AddInt64(&p.Errornum, 1)
 			logger.Warningf("表 %s 存在异常: %s\n", TableName, Msg_text)
 		}
 	}
@@ -175,6 +182,8 @@ func (p *MariaDBConn) Parallel_check_table() error {
 
 	}
 	var wg sync.WaitGroup
+	var once sync.Once
+	var checkErr error                            // 第一个检查失败的表的错误
 	concurrency := 10                             // 最大并发数量
 	semaphore := make(chan struct{}, concurrency) // 控制并发的信号量
 
@@ -189,11 +198,11 @@ func (p *MariaDBConn) Parallel_check_table() error {
 			defer wg.Done()
 			defer func() { <-semaphore }() // 释放信号量
 			if err := p.Check_table(tableName); err != nil {
-				logger.Errorf("CHECKS 表异常: %s", err)
+				once.Do(func() { checkErr = fmt.Errorf("CHECKS 表异常: %v", err) })
 			} // 调用处理函数
 		}()
 
 	}
 	wg.Wait() // 等待所有goroutines完成
-	return nil
+	return checkErr
 }
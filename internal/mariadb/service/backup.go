@@ -2,32 +2,56 @@ package service
 
 import (
 	"dbup/internal/mariadb/config"
+	"dbup/internal/output"
 	"dbup/internal/utils/command"
 	"dbup/internal/utils/logger"
 	"fmt"
+	"path/filepath"
 )
 
 type Backup struct {
-	BackupCmd  string
-	BackupFile string
-	Host       string
-	Port       int
-	Username   string
-	Password   string
+	Type        string
+	BackupCmd   string
+	BackupFile  string
+	Host        string
+	Port        int
+	Username    string
+	Password    string
+	Dir         string // 物理备份: 实例安装目录, 使用其中的 mariabackup 和配置文件
+	Mariabackup string
+	BackupDir   string // 物理备份: 备份链目录
+	Incremental bool
+	Prepare     bool
+	PrepareDir  string
 }
 
 func NewBackup() *Backup {
-	return &Backup{}
+	return &Backup{Type: BackupTypeLogical}
 }
 
 func (b *Backup) Validator() error {
 	logger.Infof("验证参数\n")
-	if b.BackupFile == "" {
-		return fmt.Errorf("请指定备份文件名")
-	}
 	if b.Host == "" {
 		b.Host = config.DefaultMariaDBlocalhost
 	}
+	switch b.Type {
+	case BackupTypeLogical:
+		if b.BackupFile == "" {
+			return fmt.Errorf("请指定备份文件名")
+		}
+	case BackupTypePhysical:
+		if b.BackupDir == "" {
+			return fmt.Errorf("请指定物理备份目录")
+		}
+		if b.Dir == "" {
+			b.Dir = fmt.Sprintf(config.DefaultMariaDBBaseDir, b.Port)
+		}
+		if b.Mariabackup == "" {
+			b.Mariabackup = filepath.Join(b.Dir, config.DefaultMariaDBBinDir, config.DefaultMariaDBBackupBinFile)
+		}
+	default:
+		return fmt.Errorf("备份类型只能是 %s 或 %s", BackupTypeLogical, BackupTypePhysical)
+	}
 	return nil
 }
 
@@ -35,15 +59,20 @@ func (b *Backup) Run() error {
 	if err := b.Validator(); err != nil {
 		return err
 	}
+	if b.Type == BackupTypePhysical {
+		return b.runPhysical()
+	}
 
 	logger.Infof("备份开始\n")
 
-	cmd := fmt.Sprintf("%s  --host='%s' --port=%d --user='%s' --password='%s'  --all-databases  --single-transaction  --triggers --routines  --events  > '%s'", b.BackupCmd, b.Host, b.Port, b.Username, b.Password, b.BackupFile)
-	l := command.Local{Timeout: 259200}
+	cmd := fmt.Sprintf("%s  --host='%s' --port=%d --user='%s'  --all-databases  --single-transaction  --triggers --routines  --events  > '%s'", b.BackupCmd, b.Host, b.Port, b.Username, b.BackupFile)
+	// 通过环境变量传递密码, 备份任务由定时任务执行时密码也不出现在进程列表中
+	l := command.Local{Timeout: 259200, Env: []string{"MYSQL_PWD=" + b.Password}}
 	if _, stderr, err := l.Run(cmd); err != nil {
 		return fmt.Errorf("执行 mariadb 备份失败: %v, 标准错误输出: %s", err, stderr)
 	}
 
 	logger.Infof("备份完成\n")
+	output.Created(output.Resource{Kind: "backup", Engine: config.Kinds, Port: b.Port, Path: b.BackupFile})
 	return nil
 }
@@ -0,0 +1,351 @@
+package service
+
+import (
+	"bufio"
+	"dbup/internal/global"
+	"dbup/internal/mariadb/config"
+	"dbup/internal/output"
+	"dbup/internal/utils"
+	"dbup/internal/utils/command"
+	"dbup/internal/utils/logger"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 备份类型
+const (
+	BackupTypeLogical  = "logical"
+	BackupTypePhysical = "physical"
+)
+
+// 物理备份
+const (
+	PhysicalManifestFile = "dbup_backup.json" // 每个物理备份目录中的清单
+	PhysicalFull         = "full"
+	PhysicalIncremental  = "incremental"
+	physicalTimeFormat   = "20060102150405"
+)
+
+// mariabackup 生成的文件, 新版本改成了 mariadb_backup_ 前缀, 两种都读
+var (
+	checkpointsFiles = []string{"mariadb_backup_checkpoints", "xtrabackup_checkpoints"}
+	binlogInfoFiles  = []string{"mariadb_backup_binlog_info", "xtrabackup_binlog_info"}
+	backupInfoFiles  = []string{"mariadb_backup_info", "xtrabackup_info"}
+)
+
+// PhysicalManifest 物理备份清单, 记录备份链关系, LSN 范围和 GTID
+type PhysicalManifest struct {
+	Name       string    `json:"name"`
+	Type       string    `json:"type"`
+	Base       string    `json:"base,omitempty"` // 增量备份基于的上一个备份
+	FromLSN    uint64    `json:"from_lsn"`
+	ToLSN      uint64    `json:"to_lsn"`
+	GTID       string    `json:"gtid,omitempty"`
+	BinlogFile string    `json:"binlog_file,omitempty"`
+	BinlogPos  uint64    `json:"binlog_pos,omitempty"`
+	Version    string    `json:"version,omitempty"`
+	Prepared   bool      `json:"prepared"` // 已经 prepare, 可以直接恢复
+	StartTime  time.Time `json:"start_time"`
+	EndTime    time.Time `json:"end_time"`
+	dir        string
+}
+
+func LoadPhysicalManifest(dir string) (*PhysicalManifest, error) {
+	b, err := ioutil.ReadFile(filepath.Join(dir, PhysicalManifestFile))
+	if err != nil {
+		return nil, err
+	}
+	m := &PhysicalManifest{}
+	if err := json.Unmarshal(b, m); err != nil {
+		return nil, fmt.Errorf("解析备份清单 %s 失败: %v", filepath.Join(dir, PhysicalManifestFile), err)
+	}
+	m.dir = dir
+	return m, nil
+}
+
+func (m *PhysicalManifest) SaveTo(dir string) error {
+	b, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(dir, PhysicalManifestFile), append(b, '\n'), 0640)
+}
+
+// readBackupFiles 从 mariabackup 生成的文件中读取 LSN, binlog 位置, GTID 和版本
+func (m *PhysicalManifest) readBackupFiles(dir string) error {
+	checkpoints, err := readBackupFile(dir, checkpointsFiles)
+	if err != nil {
+		return err
+	}
+	kv := parseKeyValues(checkpoints)
+	if m.FromLSN, err = strconv.ParseUint(kv["from_lsn"], 10, 64); err != nil {
+		return fmt.Errorf("备份中的 from_lsn 不正确: %v", err)
+	}
+	if m.ToLSN, err = strconv.ParseUint(kv["to_lsn"], 10, 64); err != nil {
+		return fmt.Errorf("备份中的 to_lsn 不正确: %v", err)
+	}
+
+	// 没有开启 binlog 的实例没有这个文件
+	if binlog, err := readBackupFile(dir, binlogInfoFiles); err == nil {
+		s := strings.Split(strings.TrimSpace(binlog), "\t")
+		if len(s) >= 2 {
+			m.BinlogFile = s[0]
+			m.BinlogPos, _ = strconv.ParseUint(s[1], 10, 64)
+		}
+		if len(s) >= 3 {
+			m.GTID = s[2]
+		}
+	}
+	if info, err := readBackupFile(dir, backupInfoFiles); err == nil {
+		m.Version = strings.Split(parseKeyValues(info)["server_version"], "-")[0]
+	}
+	return nil
+}
+
+func readBackupFile(dir string, names []string) (string, error) {
+	for _, name := range names {
+		if b, err := ioutil.ReadFile(filepath.Join(dir, name)); err == nil {
+			return string(b), nil
+		}
+	}
+	return "", fmt.Errorf("备份目录 %s 中没有 %s", dir, names[0])
+}
+
+// parseKeyValues 解析 key = value 格式的内容
+func parseKeyValues(s string) map[string]string {
+	kv := make(map[string]string)
+	scanner := bufio.NewScanner(strings.NewReader(s))
+	for scanner.Scan() {
+		if s := strings.SplitN(scanner.Text(), "=", 2); len(s) == 2 {
+			kv[strings.TrimSpace(s[0])] = strings.TrimSpace(s[1])
+		}
+	}
+	return kv
+}
+
+// LatestPhysicalBackup 备份链目录中最新的一个没有 prepare 的备份
+func LatestPhysicalBackup(chainDir string) (*PhysicalManifest, error) {
+	infos, err := ioutil.ReadDir(chainDir)
+	if err != nil {
+		return nil, err
+	}
+	var latest *PhysicalManifest
+	for _, info := range infos {
+		if !info.IsDir() {
+			continue
+		}
+		m, err := LoadPhysicalManifest(filepath.Join(chainDir, info.Name()))
+		if err != nil || m.Prepared {
+			continue
+		}
+		if latest == nil || m.EndTime.After(latest.EndTime) {
+			latest = m
+		}
+	}
+	if latest == nil {
+		return nil, fmt.Errorf("目录 %s 中没有物理备份", chainDir)
+	}
+	return latest, nil
+}
+
+// PhysicalChain 按备份路径找到要恢复的备份链, 第一个是全量备份.
+// path 可以是备份链目录(使用最新的备份), 链中的一个备份, 或已经 prepare 的目录
+func PhysicalChain(path string) ([]*PhysicalManifest, error) {
+	m, err := LoadPhysicalManifest(path)
+	if os.IsNotExist(err) {
+		m, err = LatestPhysicalBackup(path)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if m.Prepared {
+		return []*PhysicalManifest{m}, nil
+	}
+
+	chain := []*PhysicalManifest{m}
+	for m.Type == PhysicalIncremental {
+		base, err := LoadPhysicalManifest(filepath.Join(filepath.Dir(m.dir), m.Base))
+		if err != nil {
+			return nil, fmt.Errorf("找不到增量备份 %s 基于的备份 %s: %v", m.Name, m.Base, err)
+		}
+		if base.ToLSN != m.FromLSN {
+			return nil, fmt.Errorf("增量备份 %s 的起始 LSN(%d) 与 %s 的结束 LSN(%d) 不连续", m.Name, m.FromLSN, base.Name, base.ToLSN)
+		}
+		chain = append([]*PhysicalManifest{base}, chain...)
+		m = base
+	}
+	if m.Type != PhysicalFull {
+		return nil, fmt.Errorf("备份 %s 的类型(%s)不正确", m.Name, m.Type)
+	}
+	return chain, nil
+}
+
+// runPhysical 使用 mariabackup 做全量或增量备份, 增量备份基于备份链目录中最新的备份
+func (b *Backup) runPhysical() error {
+	if b.Prepare {
+		_, err := PreparePhysical(b.Mariabackup, b.BackupDir, b.PrepareDir)
+		return err
+	}
+	defaultsFile := filepath.Join(b.Dir, config.DefaultMariaDBConfigDir, config.DefaultMariaDBConfigFile)
+	if !utils.IsExists(defaultsFile) {
+		return fmt.Errorf("找不到实例的配置文件 %s, 物理备份需要在实例所在的机器上执行", defaultsFile)
+	}
+	if err := os.MkdirAll(b.BackupDir, 0750); err != nil {
+		return err
+	}
+
+	m := &PhysicalManifest{Type: PhysicalFull, StartTime: time.Now()}
+	var base *PhysicalManifest
+	if b.Incremental {
+		var err error
+		if base, err = LatestPhysicalBackup(b.BackupDir); err != nil {
+			return fmt.Errorf("没有可以作为增量备份基础的备份, 请先做全量备份: %v", err)
+		}
+		m.Type = PhysicalIncremental
+		m.Base = base.Name
+	}
+	m.Name = fmt.Sprintf("%s_%s", m.Type, m.StartTime.Format(physicalTimeFormat))
+	target := filepath.Join(b.BackupDir, m.Name)
+
+	logger.Infof("%s备份开始: %s\n", m.Type, target)
+	cmd := fmt.Sprintf("%s --defaults-file='%s' --backup --target-dir='%s' --host='%s' --port=%d --user='%s'",
+		b.Mariabackup, defaultsFile, target, b.Host, b.Port, b.Username)
+	if base != nil {
+		cmd += fmt.Sprintf(" --incremental-basedir='%s'", filepath.Join(b.BackupDir, base.Name))
+	}
+	// 通过环境变量传递密码, 不出现在进程列表中
+	l := command.Local{Timeout: 259200, Env: []string{"MYSQL_PWD=" + b.Password}}
+	if _, stderr, err := l.Run(cmd); err != nil {
+		os.RemoveAll(target)
+		return fmt.Errorf("执行 mariabackup 备份失败: %v, 标准错误输出: %s", err, lastLines(stderr, 20))
+	}
+
+	m.EndTime = time.Now()
+	if err := m.readBackupFiles(target); err != nil {
+		return err
+	}
+	if err := m.SaveTo(target); err != nil {
+		return err
+	}
+
+	logger.Infof("备份完成, LSN: %d - %d, GTID: %s\n", m.FromLSN, m.ToLSN, m.GTID)
+	output.Set("manifest", m)
+	output.Created(output.Resource{Kind: "backup", Engine: config.Kinds, Port: b.Port, Path: target})
+	return nil
+}
+
+// PreparePhysical 复制备份链中的全量备份到 target, 依次应用增量备份, 生成可以直接恢复的目录.
+// 备份链本身不修改, 还可以继续做增量备份
+func PreparePhysical(mariabackup, backup, target string) (*PhysicalManifest, error) {
+	chain, err := PhysicalChain(backup)
+	if err != nil {
+		return nil, err
+	}
+	if chain[0].Prepared {
+		return nil, fmt.Errorf("%s 已经 prepare 过了, 可以直接恢复", backup)
+	}
+	if target == "" {
+		return nil, fmt.Errorf("请指定 prepare 的目标目录")
+	}
+	if utils.IsExists(target) {
+		return nil, fmt.Errorf("prepare 的目标目录 %s 已经存在", target)
+	}
+
+	l := command.Local{Timeout: 259200}
+	logger.Infof("复制全量备份 %s 到 %s\n", chain[0].Name, target)
+	if _, stderr, err := l.Run(fmt.Sprintf("cp -a '%s' '%s'", chain[0].dir, target)); err != nil {
+		return nil, fmt.Errorf("复制全量备份失败: %v, 标准错误输出: %s", err, stderr)
+	}
+	for _, m := range chain {
+		cmd := fmt.Sprintf("%s --prepare --target-dir='%s'", mariabackup, target)
+		if m.Type == PhysicalIncremental {
+			cmd += fmt.Sprintf(" --incremental-dir='%s'", m.dir)
+		}
+		logger.Infof("prepare %s\n", m.Name)
+		if _, stderr, err := l.Run(cmd); err != nil {
+			return nil, fmt.Errorf("执行 mariabackup prepare 失败: %v, 标准错误输出: %s", err, lastLines(stderr, 20))
+		}
+	}
+
+	m := *chain[len(chain)-1]
+	m.Prepared = true
+	if err := m.SaveTo(target); err != nil {
+		return nil, err
+	}
+	m.dir = target
+	logger.Infof("prepare 完成: %s, LSN: %d, GTID: %s\n", target, m.ToLSN, m.GTID)
+	output.Set("manifest", m)
+	return &m, nil
+}
+
+// restorePhysical 停止实例, 用备份替换数据目录后启动, 原数据目录改名保留并返回.
+// 没有 prepare 的备份链先在安装目录下 prepare 到临时目录
+func restorePhysical(mariabackup, dir string, port int, backup string) (*PhysicalManifest, string, error) {
+	chain, err := PhysicalChain(backup)
+	if err != nil {
+		return nil, "", err
+	}
+	ts := time.Now().Format(physicalTimeFormat)
+	m := chain[0]
+	mode := "--copy-back"
+	if !m.Prepared {
+		work := filepath.Join(dir, "restore_"+ts)
+		defer os.RemoveAll(work)
+		if m, err = PreparePhysical(mariabackup, backup, work); err != nil {
+			return nil, "", err
+		}
+		// 临时目录是复制出来的, 直接移动, 节省空间
+		mode = "--move-back"
+	}
+
+	service := fmt.Sprintf(config.ServiceFileName, port)
+	if !utils.IsExists(filepath.Join(global.ServicePath, service)) {
+		return nil, "", fmt.Errorf("端口 %d 的实例不存在, 请先使用 mariadb install 安装", port)
+	}
+	logger.Infof("停止实例\n")
+	if err := command.SystemCtl(service, "stop"); err != nil {
+		return nil, "", err
+	}
+
+	dataDir := filepath.Join(dir, config.DefaultMariaDBDataDir)
+	oldDir := dataDir + ".bak." + ts
+	logger.Infof("移走原数据目录到 %s\n", oldDir)
+	if err := os.Rename(dataDir, oldDir); err != nil {
+		return nil, "", err
+	}
+	if err := os.Mkdir(dataDir, 0750); err != nil {
+		return nil, oldDir, err
+	}
+
+	logger.Infof("恢复数据到 %s\n", dataDir)
+	l := command.Local{Timeout: 259200}
+	cmd := fmt.Sprintf("%s --defaults-file='%s' %s --target-dir='%s' --datadir='%s'",
+		mariabackup, filepath.Join(dir, config.DefaultMariaDBConfigDir, config.DefaultMariaDBConfigFile), mode, m.dir, dataDir)
+	if _, stderr, err := l.Run(cmd); err != nil {
+		return nil, oldDir, fmt.Errorf("执行 mariabackup 恢复失败: %v, 标准错误输出: %s", err, lastLines(stderr, 20))
+	}
+	if _, stderr, err := l.Run(fmt.Sprintf("chown -R --reference='%s' '%s'", dir, dataDir)); err != nil {
+		return nil, oldDir, fmt.Errorf("修改数据目录所属用户失败: %v, 标准错误输出: %s", err, stderr)
+	}
+
+	logger.Infof("启动实例\n")
+	if err := command.SystemCtl(service, "start"); err != nil {
+		return nil, oldDir, err
+	}
+	return m, oldDir, nil
+}
+
+// lastLines mariabackup 的输出很多, 出错时只返回最后几行
+func lastLines(b []byte, n int) string {
+	lines := strings.Split(strings.TrimSpace(string(b)), "\n")
+	if len(lines) > n {
+		lines = lines[len(lines)-n:]
+	}
+	return strings.Join(lines, "\n")
+}
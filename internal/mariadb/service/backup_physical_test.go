@@ -0,0 +1,74 @@
+package service
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestPhysicalChain(t *testing.T) {
+	chainDir := t.TempDir()
+	now := time.Now()
+	backups := []*PhysicalManifest{
+		{Name: "full_1", Type: PhysicalFull, FromLSN: 0, ToLSN: 100, EndTime: now},
+		{Name: "incremental_2", Type: PhysicalIncremental, Base: "full_1", FromLSN: 100, ToLSN: 200, EndTime: now.Add(time.Hour)},
+		{Name: "incremental_3", Type: PhysicalIncremental, Base: "incremental_2", FromLSN: 200, ToLSN: 300, EndTime: now.Add(2 * time.Hour)},
+	}
+	for _, m := range backups {
+		dir := filepath.Join(chainDir, m.Name)
+		if err := os.Mkdir(dir, 0750); err != nil {
+			t.Fatal(err)
+		}
+		if err := m.SaveTo(dir); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	chain, err := PhysicalChain(chainDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(chain) != 3 || chain[0].Name != "full_1" || chain[2].Name != "incremental_3" {
+		t.Fatalf("备份链目录解析错误: %v", chain)
+	}
+
+	chain, err = PhysicalChain(filepath.Join(chainDir, "incremental_2"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(chain) != 2 || chain[1].Name != "incremental_2" {
+		t.Fatalf("指定备份时解析错误: %v", chain)
+	}
+
+	// LSN 不连续
+	backups[2].FromLSN = 250
+	if err := backups[2].SaveTo(filepath.Join(chainDir, "incremental_3")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := PhysicalChain(chainDir); err == nil {
+		t.Fatal("LSN 不连续的备份链应该报错")
+	}
+}
+
+func TestPhysicalManifestReadBackupFiles(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"xtrabackup_checkpoints":     "backup_type = full-backuped\nfrom_lsn = 0\nto_lsn = 48213\nlast_lsn = 48213\n",
+		"mariadb_backup_binlog_info": "mariadb-bin.000003\t385\t0-1-15,1-2-7\n",
+		"mariadb_backup_info":        "tool_name = mariabackup\nserver_version = 10.11.8-MariaDB-log\n",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	m := &PhysicalManifest{}
+	if err := m.readBackupFiles(dir); err != nil {
+		t.Fatal(err)
+	}
+	if m.ToLSN != 48213 || m.BinlogFile != "mariadb-bin.000003" || m.BinlogPos != 385 || m.GTID != "0-1-15,1-2-7" || m.Version != "10.11.8" {
+		t.Fatalf("解析结果错误: %+v", m)
+	}
+}
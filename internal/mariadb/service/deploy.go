@@ -2,12 +2,15 @@ package service
 
 import (
 	"dbup/internal/environment"
+	"dbup/internal/inventory"
 	"dbup/internal/mariadb/config"
 	"dbup/internal/mariadb/dao"
+	"dbup/internal/plan"
+	"dbup/internal/preflight"
 	"dbup/internal/utils/command"
 	"dbup/internal/utils/logger"
+	"dbup/internal/utils/prompt"
 	"fmt"
-	"os"
 	"path"
 	"path/filepath"
 	"strings"
@@ -26,14 +29,7 @@ func NewmariadbDeploy() *MariaDBDeploy {
 
 func (d *MariaDBDeploy) Run(c string) error {
 	//初始化参数和配置环节
-	if err := d.option.Load(c); err != nil {
-		return err
-	}
-
-	d.option.MariaDB.Parameter()
-	d.option.Server.SetDefault()
-
-	if err := d.option.Validator(); err != nil {
+	if err := d.load(c); err != nil {
 		return err
 	}
 
@@ -69,9 +65,39 @@ func (d *MariaDBDeploy) Run(c string) error {
 		return err
 	}
 
+	if err := inventory.RecordCluster(d.cluster()); err != nil {
+		logger.Warningf("更新实例清单失败: %v\n", err)
+	}
 	return nil
 }
 
+// load 读取并验证部署配置
+func (d *MariaDBDeploy) load(c string) error {
+	if err := d.option.Load(c); err != nil {
+		return err
+	}
+
+	d.option.MariaDB.Parameter()
+	d.option.Server.SetDefault()
+
+	return d.option.Validator()
+}
+
+// Check 连接各节点执行部署前检查, 不做任何修改
+func (d *MariaDBDeploy) Check(c string) error {
+	if err := d.load(c); err != nil {
+		return err
+	}
+	s := d.option.Server
+
+	logger.Infof("检查部署节点环境\n")
+	p := preflight.New(plan.SSHDialer(s.User, s.Password, s.KeyFile, s.SshPort, s.SSHOptions()))
+	for _, host := range strings.Split(s.Address, ",") {
+		p.Add(host, config.Kinds, []int{d.option.MariaDB.Port}, d.option.MariaDB.Dir)
+	}
+	return p.Finish()
+}
+
 func (d *MariaDBDeploy) RemoveCluster(c string, yes bool) error {
 	// 初始化参数和配置环节
 	if err := d.option.Load(c); err != nil {
@@ -96,15 +122,9 @@ func (d *MariaDBDeploy) RemoveCluster(c string, yes bool) error {
 
 	if !yes {
 		logger.Warningf("删除集群是危险操作,会将整个集群中的数据完全删除, 不可恢复\n")
-		logger.Warningf("是否确认删除[y|n]:")
-
-		var yes string
-		if _, err := fmt.Scanln(&yes); err != nil {
+		if err := prompt.Confirm("是否确认删除"); err != nil {
 			return err
 		}
-		if strings.ToUpper(yes) != "Y" && strings.ToUpper(yes) != "YES" {
-			os.Exit(0)
-		}
 	}
 
 	logger.Infof("初始化删除对象\n")
@@ -128,9 +148,23 @@ func (d *MariaDBDeploy) RemoveCluster(c string, yes bool) error {
 
 	d.UNInstall()
 
+	if err := inventory.ForgetCluster(d.cluster().Name); err != nil {
+		logger.Warningf("更新实例清单失败: %v\n", err)
+	}
 	return nil
 }
 
+// cluster 集群成员, 用于记录到实例清单
+func (d *MariaDBDeploy) cluster() inventory.Cluster {
+	members := inventory.Members(d.option.Server.Address, d.option.MariaDB.Port, config.MariaDBSlaveRole, d.option.MariaDB.Dir)
+	c := inventory.Cluster{Engine: config.Kinds, Mode: "master-slave", Members: members}
+	if len(members) > 0 {
+		members[0].Role = config.MariaDBMasterRole
+		c.Name = inventory.ClusterName(config.Kinds, members[0].Host, members[0].Port)
+	}
+	return c
+}
+
 func (d *MariaDBDeploy) Init() error {
 	var err error
 	ips := strings.Split(d.option.Server.Address, ",")
@@ -139,7 +173,8 @@ func (d *MariaDBDeploy) Init() error {
 		d.option.Server.User,
 		d.option.Server.Password,
 		d.option.Server.SshPort,
-		d.option.MariaDB); err != nil {
+		d.option.MariaDB,
+		d.option.Server.SSHOptions()); err != nil {
 		return err
 	}
 	for _, slave := range ips[1:] {
@@ -148,7 +183,8 @@ func (d *MariaDBDeploy) Init() error {
 			d.option.Server.User,
 			d.option.Server.Password,
 			d.option.Server.SshPort,
-			d.option.MariaDB)
+			d.option.MariaDB,
+			d.option.Server.SSHOptions())
 		if err != nil {
 			return err
 		}
@@ -167,7 +203,8 @@ func (d *MariaDBDeploy) InitUseKeyFile() error {
 		d.option.Server.User,
 		d.option.Server.KeyFile,
 		d.option.Server.SshPort,
-		d.option.MariaDB); err != nil {
+		d.option.MariaDB,
+		d.option.Server.SSHOptions()); err != nil {
 		return err
 	}
 	for _, slave := range ips[1:] {
@@ -176,7 +213,8 @@ func (d *MariaDBDeploy) InitUseKeyFile() error {
 			d.option.Server.User,
 			d.option.Server.KeyFile,
 			d.option.Server.SshPort,
-			d.option.MariaDB)
+			d.option.MariaDB,
+			d.option.Server.SSHOptions())
 		if err != nil {
 			return err
 		}
@@ -352,7 +390,7 @@ func (d *MariaDBDeploy) InstallAndInitSlave() error {
 
 func (d *MariaDBDeploy) MMChangeSlave() error {
 	// fmt.Sprintf("cd %s; rm -rf *", filepath.ToSlash(i.TmpDir))
-	conn, err := command.NewConnection(d.master.Host, d.option.Server.User, d.option.Server.Password, d.option.Server.SshPort, 30)
+	conn, err := command.NewConnection(d.master.Host, d.option.Server.User, d.option.Server.Password, d.option.Server.SshPort, 30, d.option.Server.SSHOptions())
 	if err != nil {
 		return fmt.Errorf("在机器: %s 上, 建立ssh连接失败: %v", d.master.Host, err)
 	}
@@ -371,7 +409,12 @@ func (d *MariaDBDeploy) MMChangeSlave() error {
 }
 
 func (d *MariaDBDeploy) CheckMasterReplicaStatus() error {
-	conn, err := dao.NewMariaDBConn(d.master.Host, d.option.MariaDB.Port, d.option.MariaDB.Repluser, d.option.MariaDB.ReplPassword, "")
+	dialHost, dialPort, closeTunnel, err := d.master.Conn.DialAddress(d.option.MariaDB.Port)
+	if err != nil {
+		return err
+	}
+	defer closeTunnel()
+	conn, err := dao.NewMariaDBConn(dialHost, dialPort, d.option.MariaDB.Repluser, d.option.MariaDB.ReplPassword, "")
 	if err != nil {
 		return err
 	}
@@ -402,7 +445,12 @@ func (d *MariaDBDeploy) CheckMasterReplicaStatus() error {
 func (d *MariaDBDeploy) CheckReplicaSetStatus() error {
 
 	for _, slave := range d.slaves {
-		conn, err := dao.NewMariaDBConn(slave.Host, d.option.MariaDB.Port, d.option.MariaDB.Repluser, d.option.MariaDB.ReplPassword, "")
+		dialHost, dialPort, closeTunnel, err := slave.Conn.DialAddress(d.option.MariaDB.Port)
+		if err != nil {
+			return err
+		}
+		defer closeTunnel()
+		conn, err := dao.NewMariaDBConn(dialHost, dialPort, d.option.MariaDB.Repluser, d.option.MariaDB.ReplPassword, "")
 		if err != nil {
 			return err
 		}
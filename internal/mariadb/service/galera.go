@@ -1,9 +1,14 @@
 package service
 
 import (
+	"context"
 	"dbup/internal/environment"
+	"dbup/internal/inventory"
 	"dbup/internal/mariadb/config"
+	"dbup/internal/plan"
+	"dbup/internal/preflight"
 	"dbup/internal/utils/logger"
+	"dbup/internal/utils/parallel"
 	"fmt"
 	"path"
 	"path/filepath"
@@ -14,22 +19,34 @@ type GaleraDeploy struct {
 	option     config.MariaDBDeployOptions
 	masterhead *MariaDBInstance
 	masterlist []*MariaDBInstance
+	ctx        context.Context
 }
 
 func NewGaleraDeploy() *GaleraDeploy {
 	return &GaleraDeploy{}
 }
 
+// WithContext 设置部署使用的 ctx, ctx 取消后不再开始新的步骤. 回滚和清理不受 ctx 影响
+func (d *GaleraDeploy) WithContext(ctx context.Context) *GaleraDeploy {
+	d.ctx = ctx
+	return d
+}
+
+func (d *GaleraDeploy) context() context.Context {
+	if d.ctx == nil {
+		return context.Background()
+	}
+	return d.ctx
+}
+
 func (d *GaleraDeploy) Run(c string) error {
 
-	if err := d.option.Load(c); err != nil {
+	if err := d.load(c); err != nil {
 		return err
 	}
 
-	d.option.MariaDB.GaleraParameter()
-
-	// 验证 Galera 相关参数配置
-	if err := d.option.GaleraValidator(); err != nil {
+	// 检查 galera 成员通信端口
+	if err := d.option.GaleraPortCheck(); err != nil {
 		return err
 	}
 
@@ -67,9 +84,85 @@ func (d *GaleraDeploy) Run(c string) error {
 		return err
 	}
 
+	if err := inventory.RecordCluster(d.cluster()); err != nil {
+		logger.Warningf("更新实例清单失败: %v\n", err)
+	}
 	return nil
 }
 
+// load 读取并验证部署配置
+func (d *GaleraDeploy) load(c string) error {
+	if err := d.option.Load(c); err != nil {
+		return err
+	}
+
+	d.option.MariaDB.GaleraParameter()
+
+	// 验证 Galera 相关参数配置
+	return d.option.GaleraValidator()
+}
+
+// Plan 只读连接各节点, 输出部署计划和冲突, 不做任何修改
+func (d *GaleraDeploy) Plan(c string) error {
+	if err := d.load(c); err != nil {
+		return err
+	}
+	s := d.option.Server
+	port := d.option.MariaDB.Port
+	service := fmt.Sprintf(config.ServiceFileName, port)
+	files := []string{
+		"bin/dbup",
+		"package/md5",
+		path.Join("systemd", config.ServiceTemplateFile(d.option.MariaDB.Version)),
+		path.Join("package", "mariadb", config.PackageName(d.option.MariaDB.Version)),
+	}
+
+	logger.Infof("检查部署节点\n")
+	p := plan.New(config.Kinds, "galera", plan.SSHDialer(s.User, s.Password, s.KeyFile, s.SshPort, s.SSHOptions()))
+	ips := strings.Split(s.Address, ",")
+	for n, ip := range ips {
+		h := p.Host(ip)
+		h.Stage(s.TmpDir, files...)
+		h.Instance(config.Kinds, port, d.option.MariaDB.Dir, service)
+		h.CheckPort(config.DefaultGalerabaseport)
+		if n == 0 {
+			h.Add(plan.ActionReplication, "初始化 galera 集群, 集群地址 %s", s.Address)
+			h.Add(plan.ActionUser, "root, 使用配置文件中的密码")
+			continue
+		}
+		h.Add(plan.ActionReplication, "加入 galera 集群 %s", ips[0])
+	}
+	for _, h := range p.Hosts {
+		h.Add(plan.ActionCleanup, "删除临时目录 %s 中的文件", s.TmpDir)
+	}
+	return p.Finish()
+}
+
+// cluster 集群成员, 用于记录到实例清单. 删除集群与主从集群一样使用 MariaDBDeploy.RemoveCluster, 集群名称规则相同
+// Check 连接各节点执行部署前检查, 同时检查 galera 集群通信端口, 不做任何修改
+func (d *GaleraDeploy) Check(c string) error {
+	if err := d.load(c); err != nil {
+		return err
+	}
+	s := d.option.Server
+
+	logger.Infof("检查部署节点环境\n")
+	p := preflight.New(plan.SSHDialer(s.User, s.Password, s.KeyFile, s.SshPort, s.SSHOptions()))
+	for _, host := range strings.Split(s.Address, ",") {
+		p.Add(host, config.Kinds, []int{d.option.MariaDB.Port, config.DefaultGalerabaseport}, d.option.MariaDB.Dir)
+	}
+	return p.Finish()
+}
+
+func (d *GaleraDeploy) cluster() inventory.Cluster {
+	members := inventory.Members(d.option.Server.Address, d.option.MariaDB.Port, "galera", d.option.MariaDB.Dir)
+	c := inventory.Cluster{Engine: config.Kinds, Mode: "galera", Members: members}
+	if len(members) > 0 {
+		c.Name = inventory.ClusterName(config.Kinds, members[0].Host, members[0].Port)
+	}
+	return c
+}
+
 func (d *GaleraDeploy) Init() error {
 	var err error
 	ips := strings.Split(d.option.Server.Address, ",")
@@ -78,7 +171,8 @@ func (d *GaleraDeploy) Init() error {
 		d.option.Server.User,
 		d.option.Server.Password,
 		d.option.Server.SshPort,
-		d.option.MariaDB); err != nil {
+		d.option.MariaDB,
+		d.option.Server.SSHOptions()); err != nil {
 		return err
 	}
 	for _, masters := range ips[1:] {
@@ -87,7 +181,8 @@ func (d *GaleraDeploy) Init() error {
 			d.option.Server.User,
 			d.option.Server.Password,
 			d.option.Server.SshPort,
-			d.option.MariaDB)
+			d.option.MariaDB,
+			d.option.Server.SSHOptions())
 		if err != nil {
 			return err
 		}
@@ -106,7 +201,8 @@ func (d *GaleraDeploy) InitUseKeyFile() error {
 		d.option.Server.User,
 		d.option.Server.KeyFile,
 		d.option.Server.SshPort,
-		d.option.MariaDB); err != nil {
+		d.option.MariaDB,
+		d.option.Server.SSHOptions()); err != nil {
 		return err
 	}
 	for _, masters := range ips[1:] {
@@ -115,7 +211,8 @@ func (d *GaleraDeploy) InitUseKeyFile() error {
 			d.option.Server.User,
 			d.option.Server.KeyFile,
 			d.option.Server.SshPort,
-			d.option.MariaDB)
+			d.option.MariaDB,
+			d.option.Server.SSHOptions())
 		if err != nil {
 			return err
 		}
@@ -128,72 +225,44 @@ func (d *GaleraDeploy) InitUseKeyFile() error {
 
 func (d *GaleraDeploy) CheckTmpDir() error {
 	logger.Infof("检查目标机器的临时目录\n")
-	if err := d.masterhead.CheckTmpDir(); err != nil {
-		return err
-	}
-	for _, slave := range d.masterlist {
-		if err := slave.CheckTmpDir(); err != nil {
-			return err
-		}
-	}
-
-	return nil
+	return d.each(d.context(), "检查临时目录", d.members(), func(inst *MariaDBInstance) error {
+		return inst.CheckTmpDir()
+	})
 }
 
 func (d *GaleraDeploy) DropTmpDir() {
 	logger.Infof("删除目标机器的临时目录\n")
-	_ = d.masterhead.DropTmpDir()
-
-	for _, slave := range d.masterlist {
-		_ = slave.DropTmpDir()
-	}
+	_ = d.each(context.Background(), "删除临时目录", d.members(), func(inst *MariaDBInstance) error {
+		_ = inst.DropTmpDir()
+		return nil
+	})
 }
 
 func (d *GaleraDeploy) Scp() error {
 	logger.Infof("将所需文件复制到目标机器\n")
 	source := path.Join(environment.GlobalEnv().ProgramPath, "..")
-	logger.Infof("复制到: %s\n", d.masterhead.Host)
-	if err := d.masterhead.Scp(source); err != nil {
-		return err
-	}
-	for _, slave := range d.masterlist {
-		logger.Infof("复制到: %s\n", slave.Host)
-		if err := slave.Scp(source); err != nil {
-			return err
-		}
-	}
-
-	return nil
+	return d.each(d.context(), "复制文件", d.members(), func(inst *MariaDBInstance) error {
+		logger.Infof("复制到: %s\n", inst.Host)
+		return inst.Scp(source)
+	})
 }
 
 func (d *GaleraDeploy) CheckEnv() error {
-
 	logger.Infof("检查环境\n")
-	if err := d.masterhead.GaleraInstall(true, true, d.option.Server.Address); err != nil {
-		return err
-	}
-	for _, slave := range d.masterlist {
-		if err := slave.GaleraInstall(true, false, d.option.Server.Address); err != nil {
-			return err
-		}
-	}
-
-	return nil
+	return d.each(d.context(), "检查环境", d.members(), func(inst *MariaDBInstance) error {
+		return inst.GaleraInstall(true, inst == d.masterhead, d.option.Server.Address)
+	})
 }
 
+// Install 先安装并引导第一个节点, 其余节点都加入第一个节点, 之间互不依赖, 同时安装
 func (d *GaleraDeploy) Install() error {
 	logger.Infof("开始安装\n")
 	if err := d.masterhead.GaleraInstall(false, true, d.option.Server.Address); err != nil {
 		return err
 	}
-
-	for _, masters := range d.masterlist {
-		if err := masters.GaleraInstall(false, false, d.option.Server.Address); err != nil {
-			return err
-		}
-	}
-
-	return nil
+	return d.each(d.context(), "安装", d.masterlist, func(inst *MariaDBInstance) error {
+		return inst.GaleraInstall(false, false, d.option.Server.Address)
+	})
 }
 
 func (d *GaleraDeploy) InstallAndInitSlave() error {
@@ -214,13 +283,23 @@ func (d *GaleraDeploy) InstallAndInitSlave() error {
 
 func (d *GaleraDeploy) UNInstall() {
 	logger.Infof("开始卸载清理\n")
-	if err := d.masterhead.UNInstall(); err != nil {
-		logger.Warningf("卸载节点: %s 失败: %v\n", d.masterhead.Host, err)
-	}
-	for _, masters := range d.masterlist {
-		if err := masters.UNInstall(); err != nil {
-			logger.Warningf("卸载节点: %s 失败: %v\n", masters.Host, err)
-		}
-	}
+	// 卸载失败的节点由 parallel.Run 打印警告
+	_ = d.each(context.Background(), "卸载", d.members(), func(inst *MariaDBInstance) error {
+		return inst.UNInstall()
+	})
+}
+
+// members 集群的所有节点, 第一个为引导节点
+func (d *GaleraDeploy) members() []*MariaDBInstance {
+	return append([]*MariaDBInstance{d.masterhead}, d.masterlist...)
+}
 
+// each 在节点上并发执行 fn
+func (d *GaleraDeploy) each(ctx context.Context, name string, members []*MariaDBInstance, fn func(inst *MariaDBInstance) error) error {
+	var tasks []parallel.Task
+	for _, inst := range members {
+		inst := inst
+		tasks = append(tasks, parallel.Task{Host: inst.Host, Run: func() error { return fn(inst) }})
+	}
+	return parallel.Run(ctx, name, tasks)
 }
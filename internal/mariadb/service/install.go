@@ -6,11 +6,14 @@ import (
 	"bytes"
 	"dbup/internal/environment"
 	"dbup/internal/global"
+	"dbup/internal/inventory"
 	"dbup/internal/mariadb/config"
 	"dbup/internal/mariadb/dao"
+	"dbup/internal/tune"
 	"dbup/internal/utils"
 	"dbup/internal/utils/command"
 	"dbup/internal/utils/logger"
+	"dbup/internal/utils/prompt"
 	"errors"
 	"fmt"
 	"io/ioutil"
@@ -38,7 +41,7 @@ func NewMariaDBInstall(option *config.MariaDBOptions) *MariaDBInstall {
 		Option:          option,
 		Config:          config.NewMariaDBConfig(),
 		Gconfig:         config.NewMariaDBGaleraConfig(),
-		PackageFullName: filepath.Join(environment.GlobalEnv().ProgramPath, global.PackagePath, config.Kinds, fmt.Sprintf(config.PackageFile, config.DefaultMariaDBVersion, environment.GlobalEnv().GOOS, environment.GlobalEnv().GOARCH)),
+		PackageFullName: filepath.Join(environment.GlobalEnv().ProgramPath, global.PackagePath, config.Kinds, config.PackageName(option.Version)),
 	}
 }
 
@@ -48,7 +51,7 @@ func (i *MariaDBInstall) HandleArgs() error {
 		return err
 	}
 
-	if i.Service, err = config.NewMariaDBService(filepath.Join(environment.GlobalEnv().ProgramPath, global.ServiceTemplatePath, config.MariaDBServiceTemplateFile)); err != nil {
+	if i.Service, err = config.NewMariaDBService(filepath.Join(environment.GlobalEnv().ProgramPath, global.ServiceTemplatePath, config.ServiceTemplateFile(i.Option.Version))); err != nil {
 		return err
 	}
 
@@ -83,7 +86,6 @@ func (i *MariaDBInstall) Run() error {
 	}
 
 	if !i.Option.Yes {
-		var yes string
 		// if i.Option.Role == config.MariaDBSlaveRole {
 		// 	logger.Successf("\n")
 		// 	logger.Successf("本次安装实例为(%s)从节点\n", i.Option.Role)
@@ -93,13 +95,9 @@ func (i *MariaDBInstall) Run() error {
 		logger.Successf("端口: %d\n", i.Option.Port)
 		logger.Successf("root用户密码: %s\n", i.Option.Password)
 		logger.Successf("安装路径: %s\n", i.Option.Dir)
-		logger.Successf("是否确认安装[y|n]:")
-		if _, err := fmt.Scanln(&yes); err != nil {
+		if err := prompt.Confirm("是否确认安装"); err != nil {
 			return err
 		}
-		if strings.ToUpper(yes) != "Y" && strings.ToUpper(yes) != "YES" {
-			os.Exit(0)
-		}
 	}
 
 	if err := i.InstallAndInitDB(); err != nil {
@@ -320,6 +318,12 @@ func (i *MariaDBInstall) Install(service string) error {
 		}
 	}
 
+	if i.Option.Tune {
+		if err := tune.ApplyService("mariadb", service, ""); err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
 
@@ -520,10 +524,18 @@ func (i *MariaDBInstall) InitPrimary() error {
 }
 
 func (i *MariaDBInstall) DataSync() error {
+	if i.Option.SeedBackup != "" {
+		if err := i.SeedFromBackup(); err != nil {
+			return err
+		}
+	}
 	ipPort := strings.Split(i.Option.Join, ":")
 	i.Option.Join = ipPort[0]
 	masterport, _ := strconv.Atoi(ipPort[1])
 	i.Option.Port = masterport
+	if i.Option.SeedBackup != "" {
+		return nil
+	}
 
 	logger.Infof("开始同步主库 %s:%d 数据到新增的从节点 %s:%d\n", ipPort[0], masterport, i.Option.OwnerIP, i.Option.Port)
 
@@ -545,12 +557,53 @@ func (i *MariaDBInstall) DataSync() error {
 	return nil
 }
 
+// SeedFromBackup 用 mariabackup 物理备份替换从库的数据目录, 并按备份中的 GTID 设置复制起点.
+// 备份中的 mysql 库来自主库, 恢复后用主库的 root 密码(--seed-root-password, 默认与 --password 相同)连接,
+// 并把 i.Option.Password 改为主库的 root 密码, 之后建立复制和输出的安装信息都使用它
+func (i *MariaDBInstall) SeedFromBackup() error {
+	chain, err := PhysicalChain(i.Option.SeedBackup)
+	if err != nil {
+		return err
+	}
+	if chain[len(chain)-1].GTID == "" {
+		return fmt.Errorf("备份 %s 中没有 GTID, 不能用来初始化从库", i.Option.SeedBackup)
+	}
+
+	logger.Infof("使用物理备份 %s 初始化从库数据\n", i.Option.SeedBackup)
+	mariabackup := filepath.Join(i.Option.Dir, config.DefaultMariaDBBinDir, config.DefaultMariaDBBackupBinFile)
+	m, oldDir, err := restorePhysical(mariabackup, i.Option.Dir, i.Option.Port, i.Option.SeedBackup)
+	if err != nil {
+		return err
+	}
+	// 新安装实例的数据目录没有用了
+	os.RemoveAll(oldDir)
+
+	rootPassword := i.Option.Password
+	if i.Option.SeedRootPassword != "" {
+		rootPassword = i.Option.SeedRootPassword
+	}
+	conn, err := dao.NewMariaDBConn(config.DefaultMariaDBlocalhost, i.Option.Port, "root", rootPassword, "")
+	if err != nil {
+		return fmt.Errorf("使用主库的 root 密码连接恢复后的从库失败, 主库与从库 root 密码不同时请用 --seed-root-password 指定主库的 root 密码: %v", err)
+	}
+	defer conn.DB.Close()
+	if rootPassword != i.Option.Password {
+		logger.Warningf("从库使用备份中主库的 root 密码, --password 指定的密码不再生效\n")
+		i.Option.Password = rootPassword
+	}
+	logger.Infof("设置 gtid_slave_pos: %s\n", m.GTID)
+	if err := conn.SetGtidSlavePos(m.GTID); err != nil {
+		return fmt.Errorf("设置 gtid_slave_pos 失败: %v", err)
+	}
+	return nil
+}
+
 func (i *MariaDBInstall) InitSecondary() error {
 	if err := i.Changelocalpassword(); err != nil {
 		return err
 	}
 
-	if i.Option.AddSlave && i.Option.BackupData {
+	if (i.Option.AddSlave && i.Option.BackupData) || i.Option.SeedBackup != "" {
 		if err := i.DataSync(); err != nil {
 			return err
 		}
@@ -563,6 +616,21 @@ func (i *MariaDBInstall) InitSecondary() error {
 }
 
 func (i *MariaDBInstall) Info() {
+	role := i.Option.Role
+	if i.Option.Galera {
+		role = "galera"
+	}
+	if err := inventory.Record(inventory.Instance{
+		Engine:  config.Kinds,
+		Version: i.Option.Version,
+		Port:    i.Option.Port,
+		Dir:     i.Option.Dir,
+		DataDir: filepath.Join(i.Option.Dir, "data"),
+		Role:    role,
+		Service: fmt.Sprintf(config.ServiceFileName, i.Option.Port),
+	}); err != nil {
+		logger.Warningf("更新实例清单失败: %v\n", err)
+	}
 	if !i.Option.Galera {
 		logger.Successf("\n")
 		logger.Successf("MariaDB初始化[完成]\n")
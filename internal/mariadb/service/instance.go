@@ -2,24 +2,27 @@ package service
 
 import (
 	"dbup/internal/environment"
+	"dbup/internal/global"
 	"dbup/internal/mariadb/config"
 	"dbup/internal/utils/command"
+	"dbup/internal/utils/sshutil"
 	"fmt"
 	"path"
 	"path/filepath"
 )
 
 type MariaDBInstance struct {
-	DbupCmd string
-	Host    string
-	TmpDir  string
-	Inst    *MariaDBInstall
-	Conn    *command.Connection
+	DbupCmd  string
+	Host     string
+	TmpDir   string
+	Inst     *MariaDBInstall
+	Conn     *command.Connection
+	Platform *global.Platform // 目标机器的平台, 检查临时目录时探测
 	//spool
 }
 
-func NewmariaDBInstance(tmp, host, user, password string, port int, option config.MariaDBOptions) (*MariaDBInstance, error) {
-	conn, err := command.NewConnection(host, user, password, port, 30)
+func NewmariaDBInstance(tmp, host, user, password string, port int, option config.MariaDBOptions, opts ...sshutil.Options) (*MariaDBInstance, error) {
+	conn, err := command.NewConnection(host, user, password, port, 30, opts...)
 	if err != nil {
 		return nil, fmt.Errorf("在机器: %s 上, 建立ssh连接失败: %v", host, err)
 	}
@@ -29,8 +32,8 @@ func NewmariaDBInstance(tmp, host, user, password string, port int, option confi
 	return &MariaDBInstance{DbupCmd: cmd, Host: host, TmpDir: tmp, Inst: inst, Conn: conn}, nil
 }
 
-func NewmariaDBInstanceUseKeyFile(tmp, host, user, keyfile string, port int, option config.MariaDBOptions) (*MariaDBInstance, error) {
-	conn, err := command.NewConnectionUseKeyFile(host, user, keyfile, port, 30)
+func NewmariaDBInstanceUseKeyFile(tmp, host, user, keyfile string, port int, option config.MariaDBOptions, opts ...sshutil.Options) (*MariaDBInstance, error) {
+	conn, err := command.NewConnectionUseKeyFile(host, user, keyfile, port, 30, opts...)
 	if err != nil {
 		return nil, fmt.Errorf("在机器: %s 上, 建立ssh连接失败: %v", host, err)
 	}
@@ -41,6 +44,11 @@ func NewmariaDBInstanceUseKeyFile(tmp, host, user, keyfile string, port int, opt
 }
 
 func (i *MariaDBInstance) CheckTmpDir() error {
+	// 先确认所有目标机器都有对应平台的安装包, 再开始复制
+	if _, _, err := i.CheckPlatform(path.Join(environment.GlobalEnv().ProgramPath, "..")); err != nil {
+		return err
+	}
+
 	if i.Conn.IsExists(filepath.ToSlash(i.TmpDir)) {
 		if !i.Conn.IsDir(filepath.ToSlash(i.TmpDir)) {
 			return fmt.Errorf("在机器: %s 上, 目标文件(%s)已经存在", i.Host, i.TmpDir)
@@ -65,6 +73,11 @@ func (i *MariaDBInstance) DropTmpDir() error {
 }
 
 func (i *MariaDBInstance) Scp(source string) error {
+	source, mariadbPackage, err := i.CheckPlatform(source)
+	if err != nil {
+		return err
+	}
+
 	if err := i.Conn.MkdirAll(filepath.ToSlash(path.Join(i.TmpDir, "bin"))); err != nil {
 		return fmt.Errorf("在机器: %s 上, 创建目录(%s)失败: %v", i.Host, filepath.ToSlash(path.Join(i.TmpDir, "bin")), err)
 	}
@@ -83,16 +96,28 @@ func (i *MariaDBInstance) Scp(source string) error {
 	if err := i.Conn.Scp(path.Join(source, "package", "md5"), filepath.ToSlash(path.Join(i.TmpDir, "package", "md5"))); err != nil {
 		return fmt.Errorf("在机器: %s 上, scp文件(%s)失败: %v", i.Host, filepath.ToSlash(path.Join(i.TmpDir, "package", "md5")), err)
 	}
+	for _, f := range global.ManifestFiles(path.Join(source, "package")) {
+		if err := i.Conn.Scp(path.Join(source, "package", f), filepath.ToSlash(path.Join(i.TmpDir, "package", f))); err != nil {
+			return fmt.Errorf("在机器: %s 上, scp文件(%s)失败: %v", i.Host, filepath.ToSlash(path.Join(i.TmpDir, "package", f)), err)
+		}
+	}
 
-	if err := i.Conn.Scp(path.Join(source, "systemd", config.MariaDBServiceTemplateFile), filepath.ToSlash(path.Join(i.TmpDir, "systemd", config.MariaDBServiceTemplateFile))); err != nil {
-		return fmt.Errorf("在机器: %s 上, scp文件(%s)失败: %v", i.Host, filepath.ToSlash(path.Join(i.TmpDir, "systemd", config.MariaDBServiceTemplateFile)), err)
+	template := config.ServiceTemplateFile(i.Inst.Option.Version)
+	if err := i.Conn.Scp(path.Join(source, "systemd", template), filepath.ToSlash(path.Join(i.TmpDir, "systemd", template))); err != nil {
+		return fmt.Errorf("在机器: %s 上, scp文件(%s)失败: %v", i.Host, filepath.ToSlash(path.Join(i.TmpDir, "systemd", template)), err)
 	}
 
-	// TODO: 根据目标机器的操作系统类型选择包
-	mariadbPackage := fmt.Sprintf("mariadb%s-%s-%s.tar.gz", config.DefaultMariaDBVersion, environment.GlobalEnv().GOOS, environment.GlobalEnv().GOARCH)
 	if err := i.Conn.Scp(path.Join(source, "package", "mariadb", mariadbPackage), filepath.ToSlash(path.Join(i.TmpDir, "package", "mariadb", mariadbPackage))); err != nil {
 		return fmt.Errorf("在机器: %s 上, scp文件(%s)失败: %v", i.Host, filepath.ToSlash(path.Join(i.TmpDir, "package", "mariadb", mariadbPackage)), err)
 	}
+	// 复制后在远程机器上校验安装包, 远程安装时还会再按清单校验一次
+	run := func(cmd string) (string, error) {
+		out, err := i.Conn.Run(cmd)
+		return string(out), err
+	}
+	if err := global.VerifyRemotePackage(run, source, config.Kinds, filepath.ToSlash(path.Join(i.TmpDir, "package", "mariadb", mariadbPackage))); err != nil {
+		return fmt.Errorf("在机器: %s 上, %v", i.Host, err)
+	}
 
 	if err := i.Conn.Chmod(filepath.ToSlash(path.Join(i.TmpDir, "bin", i.DbupCmd)), 0755); err != nil {
 		return fmt.Errorf("在机器: %s 上, chmod目录(%s)权限失败: %v", i.Host, filepath.ToSlash(path.Join(i.TmpDir, "bin", i.DbupCmd)), err)
@@ -100,13 +125,19 @@ func (i *MariaDBInstance) Scp(source string) error {
 	return nil
 }
 
+// CheckPlatform 探测目标机器的操作系统和架构, 返回对应平台的安装目录和安装包文件名
+func (i *MariaDBInstance) CheckPlatform(source string) (string, string, error) {
+	return global.CheckPlatform(i.Host, &i.Platform, i.Conn.Run, source, config.Kinds, func(p *global.Platform) string {
+		return config.PlatformPackageName(i.Inst.Option.Version, p)
+	})
+}
+
 func (i *MariaDBInstance) Install(onlyCheck, addslave bool, autoincrement int) error {
-	cmd := fmt.Sprintf("%s mariadb install --repluser='%s'  --replpassword='%s' --yes --port=%d  --password='%s'  --autoincrement=%d --memory=%s --dir='%s'  --owner-ip='%s' --join='%s' --system-user='%s' --system-group='%s' --resource-limit='%s' --log='%s'",
+	cmd := fmt.Sprintf("%s mariadb install --version='%s' --repluser='%s' --yes --port=%d  --autoincrement=%d --memory=%s --dir='%s'  --owner-ip='%s' --join='%s' --system-user='%s' --system-group='%s' --resource-limit='%s' --log='%s'",
 		i.DbupCmd,
+		i.Inst.Option.Version,
 		i.Inst.Option.Repluser,
-		i.Inst.Option.ReplPassword,
 		i.Inst.Option.Port,
-		i.Inst.Option.Password,
 		autoincrement,
 		i.Inst.Option.Memory,
 		i.Inst.Option.Dir,
@@ -125,8 +156,12 @@ func (i *MariaDBInstance) Install(onlyCheck, addslave bool, autoincrement int) e
 		cmd = cmd + " --only-check"
 	}
 
+	if i.Inst.Option.Tune {
+		cmd = cmd + " --tune"
+	}
+
 	cmd = path.Join(i.TmpDir, "bin", cmd)
-	if stdout, err := i.Conn.Sudo(cmd, "", ""); err != nil {
+	if stdout, err := i.Conn.SudoWithSecrets(cmd, map[string]string{"password": i.Inst.Option.Password, "replpassword": i.Inst.Option.ReplPassword}); err != nil {
 		return fmt.Errorf("在机器: %s 上, 执行(%s)失败: %v, 标准输出: %s", i.Host, cmd, err, stdout)
 	}
 	// logger.Warningf("owner ip 是: %s | Join ip 是: %s | Password 是 %s", i.Host, i.Inst.Option.Join, i.Inst.Option.Password)
@@ -134,14 +169,12 @@ func (i *MariaDBInstance) Install(onlyCheck, addslave bool, autoincrement int) e
 }
 
 func (i *MariaDBInstance) InstallSlave(onlyCheck bool, addslave bool) error {
-	cmd := fmt.Sprintf("%s mariadb install  --yes --repluser='%s'  --replpassword='%s'  --bakuser=%s --bakpassword='%s' --port=%d  --password='%s'  --memory=%s --dir='%s'  --owner-ip='%s' --join='%s' --system-user='%s' --system-group='%s' --resource-limit='%s' --log='%s'",
+	cmd := fmt.Sprintf("%s mariadb install  --yes --version='%s' --repluser='%s'  --bakuser=%s --port=%d  --memory=%s --dir='%s'  --owner-ip='%s' --join='%s' --system-user='%s' --system-group='%s' --resource-limit='%s' --log='%s'",
 		i.DbupCmd,
+		i.Inst.Option.Version,
 		i.Inst.Option.Repluser,
-		i.Inst.Option.ReplPassword,
 		i.Inst.Option.Backupuser,
-		i.Inst.Option.BackupPassword,
 		i.Inst.Option.Port,
-		i.Inst.Option.Password,
 		i.Inst.Option.Memory,
 		i.Inst.Option.Dir,
 		i.Host,
@@ -155,12 +188,20 @@ func (i *MariaDBInstance) InstallSlave(onlyCheck bool, addslave bool) error {
 		cmd = cmd + " --add-slave=true --Backupdata=true"
 	}
 
+	if i.Inst.Option.SeedBackup != "" {
+		cmd = cmd + fmt.Sprintf(" --seed-backup='%s'", i.Inst.Option.SeedBackup)
+	}
+
 	if onlyCheck {
 		cmd = cmd + " --only-check"
 	}
 
+	if i.Inst.Option.Tune {
+		cmd = cmd + " --tune"
+	}
+
 	cmd = path.Join(i.TmpDir, "bin", cmd)
-	if stdout, err := i.Conn.Sudo(cmd, "", ""); err != nil {
+	if stdout, err := i.Conn.SudoWithSecrets(cmd, map[string]string{"password": i.Inst.Option.Password, "replpassword": i.Inst.Option.ReplPassword, "bakpassword": i.Inst.Option.BackupPassword, "seed-root-password": i.Inst.Option.SeedRootPassword}); err != nil {
 		return fmt.Errorf("在机器: %s 上, 执行(%s)失败: %v, 标准输出: %s", i.Host, cmd, err, stdout)
 	}
 
@@ -168,10 +209,10 @@ func (i *MariaDBInstance) InstallSlave(onlyCheck bool, addslave bool) error {
 }
 
 func (i *MariaDBInstance) GaleraInstall(onlyCheck bool, onenode bool, clusteraddress string) error {
-	cmd := fmt.Sprintf("%s mariadb install  --yes --port=%d  --password='%s'  --memory=%s --dir='%s'  --owner-ip='%s'  --system-user='%s' --system-group='%s' --resource-limit='%s' --log='%s'",
+	cmd := fmt.Sprintf("%s mariadb install  --yes --version='%s' --port=%d  --memory=%s --dir='%s'  --owner-ip='%s'  --system-user='%s' --system-group='%s' --resource-limit='%s' --log='%s'",
 		i.DbupCmd,
+		i.Inst.Option.Version,
 		i.Inst.Option.Port,
-		i.Inst.Option.Password,
 		i.Inst.Option.Memory,
 		i.Inst.Option.Dir,
 		i.Host,
@@ -193,8 +234,12 @@ func (i *MariaDBInstance) GaleraInstall(onlyCheck bool, onenode bool, clusteradd
 
 	cmd = cmd + fmt.Sprintf(" --cluster_address='%s' ", clusteraddress)
 
+	if i.Inst.Option.Tune {
+		cmd = cmd + " --tune"
+	}
+
 	cmd = path.Join(i.TmpDir, "bin", cmd)
-	if stdout, err := i.Conn.Sudo(cmd, "", ""); err != nil {
+	if stdout, err := i.Conn.SudoWithSecrets(cmd, map[string]string{"password": i.Inst.Option.Password}); err != nil {
 		return fmt.Errorf("在机器: %s 上, 执行(%s)失败: %v, 标准输出: %s", i.Host, cmd, err, stdout)
 	}
 	// logger.Warningf("owner ip 是: %s | Join ip 是: %s | Password 是 %s", i.Host, i.Inst.Option.Join, i.Inst.Option.Password)
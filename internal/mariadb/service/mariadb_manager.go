@@ -7,6 +7,7 @@ import (
 	"dbup/internal/utils"
 	"dbup/internal/utils/logger"
 	"fmt"
+	"net"
 	"path"
 	"path/filepath"
 	"strconv"
@@ -31,6 +32,10 @@ func (d *MariaDBManager) AddSlaveNode(ssh config.Server, m config.MariaDBOptions
 		return err
 	}
 
+	if err := m.CheckVersion(); err != nil {
+		return err
+	}
+
 	if ssh.TmpDir == "" {
 		ssh.TmpDir = config.DeployTmpDir
 	}
@@ -48,7 +53,8 @@ func (d *MariaDBManager) AddSlaveNode(ssh config.Server, m config.MariaDBOptions
 			ssh.User,
 			ssh.Password,
 			ssh.SshPort,
-			m)
+			m,
+			ssh.SSHOptions())
 		if err != nil {
 			return err
 		}
@@ -58,12 +64,17 @@ func (d *MariaDBManager) AddSlaveNode(ssh config.Server, m config.MariaDBOptions
 			ssh.User,
 			ssh.KeyFile,
 			ssh.SshPort,
-			m)
+			m,
+			ssh.SSHOptions())
 		if err != nil {
 			return err
 		}
 	}
 
+	if err := d.CheckMaster(node, m); err != nil {
+		return err
+	}
+
 	if err := node.CheckTmpDir(); err != nil {
 		return err
 	}
@@ -88,7 +99,7 @@ func (d *MariaDBManager) AddSlaveNode(ssh config.Server, m config.MariaDBOptions
 
 	time.Sleep(3 * time.Second)
 	logger.Infof("开始检查 Mariadb 从库同步状态\n")
-	if err := d.CheckSlavestatus(ssh, m); err != nil {
+	if err := d.CheckSlavestatus(node, m); err != nil {
 		return err
 	}
 
@@ -115,33 +126,9 @@ func (d *MariaDBManager) ParameterCheck(m config.MariaDBOptions) error {
 				return fmt.Errorf("--join 参数的地址部分即不是IP地址, 也不是有效的主机名")
 			}
 		}
-		ok, _ := utils.TcpGather(m.Join)
-		if !ok {
-			return fmt.Errorf("mariadb 指定的主库ip与端口服务 %s 连接异常", m.Join)
-		}
-
-		masterip := ipPort[0]
-		masterport, _ := strconv.Atoi(ipPort[1])
-
-		replconn, err := dao.NewMariaDBConn(masterip, masterport, m.Repluser, m.ReplPassword, "")
-		if err != nil {
-			return fmt.Errorf("mariadb 指定的复制账号 %s 连接异常", m.Repluser)
-		}
-
-		if err := replconn.Select(); err != nil {
-			return fmt.Errorf("账号 %s 连接失败: %v", m.Repluser, err)
-		}
-		replconn.DB.Close()
-
-		bakconn, err := dao.NewMariaDBConn(masterip, masterport, m.Backupuser, m.BackupPassword, "")
-		if err != nil {
-			return fmt.Errorf("mariadb 指定的备份账号 %s 连接异常", m.Backupuser)
-		}
-		if err := bakconn.Select(); err != nil {
-			return fmt.Errorf("账号 %s 连接失败: %v", m.Backupuser, err)
+		if _, err := strconv.Atoi(ipPort[1]); err != nil {
+			return fmt.Errorf("--join 参数的端口部分 %s 不是有效的端口", ipPort[1])
 		}
-		bakconn.DB.Close()
-
 	} else {
 		return fmt.Errorf("--join 必须指定同步主库的地址 <IP:PORT>")
 	}
@@ -155,8 +142,52 @@ func (d *MariaDBManager) ParameterCheck(m config.MariaDBOptions) error {
 	return nil
 }
 
-func (d *MariaDBManager) CheckSlavestatus(ssh config.Server, m config.MariaDBOptions) error {
-	conn, err := dao.NewMariaDBConn(ssh.Address, m.Port, m.Repluser, m.ReplPassword, "")
+// CheckMaster 检查主库的复制账号和备份账号. 主库通过从库所在机器的 ssh 连接访问, 经跳板机连接时控制机不需要能直连主库
+func (d *MariaDBManager) CheckMaster(node *MariaDBInstance, m config.MariaDBOptions) error {
+	ipPort := strings.Split(m.Join, ":")
+	masterip := ipPort[0]
+	masterport, _ := strconv.Atoi(ipPort[1])
+
+	dialHost, dialPort, closeTunnel, err := node.Conn.DialHost(masterip, masterport)
+	if err != nil {
+		return err
+	}
+	defer closeTunnel()
+
+	ok, _ := utils.TcpGather(net.JoinHostPort(dialHost, strconv.Itoa(dialPort)))
+	if !ok {
+		return fmt.Errorf("mariadb 指定的主库ip与端口服务 %s 连接异常", m.Join)
+	}
+
+	replconn, err := dao.NewMariaDBConn(dialHost, dialPort, m.Repluser, m.ReplPassword, "")
+	if err != nil {
+		return fmt.Errorf("mariadb 指定的复制账号 %s 连接异常", m.Repluser)
+	}
+
+	if err := replconn.Select(); err != nil {
+		return fmt.Errorf("账号 %s 连接失败: %v", m.Repluser, err)
+	}
+	replconn.DB.Close()
+
+	bakconn, err := dao.NewMariaDBConn(dialHost, dialPort, m.Backupuser, m.BackupPassword, "")
+	if err != nil {
+		return fmt.Errorf("mariadb 指定的备份账号 %s 连接异常", m.Backupuser)
+	}
+	if err := bakconn.Select(); err != nil {
+		return fmt.Errorf("账号 %s 连接失败: %v", m.Backupuser, err)
+	}
+	bakconn.DB.Close()
+
+	return nil
+}
+
+func (d *MariaDBManager) CheckSlavestatus(node *MariaDBInstance, m config.MariaDBOptions) error {
+	dialHost, dialPort, closeTunnel, err := node.Conn.DialAddress(m.Port)
+	if err != nil {
+		return err
+	}
+	defer closeTunnel()
+	conn, err := dao.NewMariaDBConn(dialHost, dialPort, m.Repluser, m.ReplPassword, "")
 	if err != nil {
 		return err
 	}
@@ -164,18 +195,18 @@ func (d *MariaDBManager) CheckSlavestatus(ssh config.Server, m config.MariaDBOpt
 
 	status, err := conn.ShowSlaveStatus()
 	if err != nil {
-		return fmt.Errorf("从库 %s:%d 同步状态异常: %s ", ssh.Address, m.Port, err)
+		return fmt.Errorf("从库 %s:%d 同步状态异常: %s ", node.Host, m.Port, err)
 	}
 
 	for k, v := range status {
 		if v.Valid {
 
 			if k == "Slave_IO_Running" && v.String != "Yes" {
-				return fmt.Errorf("从库 %s:%d IO线程同步状态异常", ssh.Address, m.Port)
+				return fmt.Errorf("从库 %s:%d IO线程同步状态异常", node.Host, m.Port)
 			}
 
 			if k == "Slave_SQL_Running" && v.String != "Yes" {
-				return fmt.Errorf("从库 %s:%d SQL线程同步状态异常", ssh.Address, m.Port)
+				return fmt.Errorf("从库 %s:%d SQL线程同步状态异常", node.Host, m.Port)
 			}
 
 		}
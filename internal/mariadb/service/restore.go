@@ -0,0 +1,388 @@
+package service
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"dbup/internal/mariadb/config"
+	"dbup/internal/mariadb/dao"
+	"dbup/internal/output"
+	"dbup/internal/utils/logger"
+	"dbup/internal/utils/prompt"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// 导入前关闭当前会话的 binlog, 恢复的数据不写入 binlog, 也不会同步到从库
+	restoreSessionSQL = "SET SESSION sql_log_bin=0;\n"
+	// 进度打印间隔
+	restoreProgressInterval = 10 * time.Second
+)
+
+var (
+	// mariadb-dump --all-databases 在每个库前输出: -- Current Database: `db`
+	currentDatabaseRegexp = regexp.MustCompile("^-- Current Database: `(.*)`\\s*$")
+	// 会话变量设置, 过滤掉的库中的这些语句仍然执行
+	sessionSetRegexp = regexp.MustCompile(`^/\*!\d+ SET `)
+)
+
+// mariadb 系统库, 检查表时也会跳过
+var systemDatabases = []string{"information_schema", "mysql", "performance_schema", "sys"}
+
+// Restore 把 mariadb-dump 生成的逻辑备份导入到新安装的或已有的实例,
+// 或用 mariabackup 物理备份替换实例的数据目录
+type Restore struct {
+	RestoreCmd  string
+	File        string
+	Databases   string // 只恢复的库, 逗号分隔, 为空时恢复全部
+	Host        string
+	Port        int
+	Username    string
+	Password    string
+	NoCheck     bool
+	Yes         bool
+	Physical    bool
+	BackupDir   string // 物理备份: 备份链目录, 链中的一个备份或已经 prepare 的目录
+	Dir         string // 物理备份: 实例安装目录
+	Mariabackup string
+	databases   map[string]bool
+	read        int64
+}
+
+func NewRestore() *Restore {
+	return &Restore{}
+}
+
+func (r *Restore) Validator() error {
+	logger.Infof("验证参数\n")
+	if r.Physical {
+		return r.validatorPhysical()
+	}
+	if r.File == "" {
+		return fmt.Errorf("请指定备份文件")
+	}
+	if _, err := os.Stat(r.File); err != nil {
+		return fmt.Errorf("备份文件不可用: %v", err)
+	}
+	if r.Port == 0 {
+		return fmt.Errorf("请指定端口号")
+	}
+	if r.Host == "" {
+		r.Host = config.DefaultMariaDBlocalhost
+	}
+	if r.RestoreCmd == "" {
+		r.RestoreCmd = "mariadb"
+	}
+	if r.Databases != "" {
+		r.databases = make(map[string]bool)
+		for _, db := range strings.Split(r.Databases, ",") {
+			if db = strings.TrimSpace(db); db != "" {
+				r.databases[db] = false
+			}
+		}
+	}
+	return nil
+}
+
+func (r *Restore) validatorPhysical() error {
+	if r.BackupDir == "" {
+		return fmt.Errorf("请指定物理备份目录")
+	}
+	if r.Port == 0 {
+		return fmt.Errorf("请指定端口号")
+	}
+	if r.File != "" || r.Databases != "" {
+		return fmt.Errorf("物理备份恢复整个实例, 不能指定 --file 和 --databases")
+	}
+	if r.Dir == "" {
+		r.Dir = fmt.Sprintf(config.DefaultMariaDBBaseDir, r.Port)
+	}
+	if r.Mariabackup == "" {
+		r.Mariabackup = filepath.Join(r.Dir, config.DefaultMariaDBBinDir, config.DefaultMariaDBBackupBinFile)
+	}
+	return nil
+}
+
+func (r *Restore) Run() error {
+	if err := r.Validator(); err != nil {
+		return err
+	}
+	if r.Physical {
+		return r.runPhysical()
+	}
+
+	conn, err := dao.NewMariaDBConn(r.Host, r.Port, r.Username, r.Password, "")
+	if err != nil {
+		return err
+	}
+	defer conn.DB.Close()
+
+	if !r.Yes {
+		existing, err := r.existingDatabases(conn)
+		if err != nil {
+			return err
+		}
+		logger.Successf("备份文件: %s\n", r.File)
+		logger.Successf("恢复到: %s:%d\n", r.Host, r.Port)
+		if r.databases != nil {
+			logger.Successf("只恢复的库: %s\n", r.Databases)
+		}
+		if len(existing) > 0 {
+			logger.Warningf("实例中已有的库会被备份中的同名库覆盖: %s\n", strings.Join(existing, ", "))
+		}
+		if r.restoreDatabase("mysql") {
+			logger.Warningf("备份中如果有 mysql 库, 用户和密码会被替换为备份中的\n")
+		}
+		if err := prompt.Confirm("是否确认恢复"); err != nil {
+			return err
+		}
+	}
+
+	logger.Infof("恢复开始\n")
+	start := time.Now()
+	if err := r.load(); err != nil {
+		return err
+	}
+	for db, found := range r.databases {
+		if !found {
+			logger.Warningf("备份文件中没有库: %s\n", db)
+		}
+	}
+	logger.Infof("导入完成, 用时 %s\n", time.Since(start).Round(time.Second))
+
+	if r.restoreDatabase("mysql") {
+		if err := conn.FlushUser(); err != nil {
+			return fmt.Errorf("刷新权限失败: %v", err)
+		}
+	}
+	if !r.NoCheck {
+		logger.Infof("检查表\n")
+		if err := conn.Parallel_check_table(); err != nil {
+			return err
+		}
+		if conn.Errornum > 0 {
+			return fmt.Errorf("有 %d 个表检查异常, 请查看上面的日志", conn.Errornum)
+		}
+	}
+
+	logger.Infof("恢复完成\n")
+	output.Created(output.Resource{Kind: "restore", Engine: config.Kinds, Host: r.Host, Port: r.Port, Path: r.File})
+	return nil
+}
+
+// runPhysical 应用增量备份链后替换实例的数据目录, 原数据目录改名保留
+func (r *Restore) runPhysical() error {
+	chain, err := PhysicalChain(r.BackupDir)
+	if err != nil {
+		return err
+	}
+	last := chain[len(chain)-1]
+
+	if !r.Yes {
+		logger.Successf("备份: %s\n", r.BackupDir)
+		for _, m := range chain {
+			logger.Successf("  %s(%s) LSN: %d - %d\n", m.Name, m.Type, m.FromLSN, m.ToLSN)
+		}
+		logger.Successf("GTID: %s\n", last.GTID)
+		logger.Successf("安装路径: %s\n", r.Dir)
+		logger.Warningf("实例会被停止, 原数据目录会被移走保留\n")
+		if err := prompt.Confirm("是否确认恢复"); err != nil {
+			return err
+		}
+	}
+
+	logger.Infof("恢复开始\n")
+	m, oldDir, err := restorePhysical(r.Mariabackup, r.Dir, r.Port, r.BackupDir)
+	if err != nil {
+		if oldDir != "" {
+			logger.Warningf("恢复失败, 原数据目录保存在: %s\n", oldDir)
+		}
+		return err
+	}
+
+	logger.Infof("恢复完成, 原数据目录保存在: %s\n", oldDir)
+	if m.GTID != "" {
+		logger.Infof("备份的 GTID: %s, 作为从库时可以 SET GLOBAL gtid_slave_pos 后 CHANGE MASTER TO ... MASTER_USE_GTID=slave_pos\n", m.GTID)
+	}
+	output.Set("manifest", m)
+	output.Created(output.Resource{Kind: "restore", Engine: config.Kinds, Port: r.Port, Path: filepath.Join(r.Dir, config.DefaultMariaDBDataDir)})
+	return nil
+}
+
+// restoreDatabase 是否恢复这个库
+func (r *Restore) restoreDatabase(db string) bool {
+	if r.databases == nil {
+		return true
+	}
+	_, ok := r.databases[db]
+	return ok
+}
+
+// existingDatabases 实例中已有的, 本次会恢复的非系统库
+func (r *Restore) existingDatabases(conn *dao.MariaDBConn) ([]string, error) {
+	rows, err := conn.DB.Query("SHOW DATABASES")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var dbs []string
+loop:
+	for rows.Next() {
+		var db string
+		if err := rows.Scan(&db); err != nil {
+			return nil, err
+		}
+		for _, s := range systemDatabases {
+			if db == s {
+				continue loop
+			}
+		}
+		if r.restoreDatabase(db) {
+			dbs = append(dbs, db)
+		}
+	}
+	return dbs, rows.Err()
+}
+
+// load 把备份文件通过 mariadb 客户端导入, .gz 结尾的文件先解压
+func (r *Restore) load() error {
+	f, err := os.Open(r.File)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	var in io.Reader = &countingReader{r: f, n: &r.read}
+	if strings.HasSuffix(r.File, ".gz") {
+		gz, err := gzip.NewReader(in)
+		if err != nil {
+			return fmt.Errorf("解压备份文件失败: %v", err)
+		}
+		defer gz.Close()
+		in = gz
+	}
+
+	cmd := exec.Command(r.RestoreCmd, "--host="+r.Host, "--port="+strconv.Itoa(r.Port), "--user="+r.Username)
+	// 通过环境变量传递密码, 不出现在进程列表中
+	cmd.Env = append(os.Environ(), "MYSQL_PWD="+r.Password)
+	stderr := new(bytes.Buffer)
+	cmd.Stderr = stderr
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("执行 %s 失败: %v", r.RestoreCmd, err)
+	}
+
+	done := make(chan struct{})
+	defer close(done)
+	go r.progress(info.Size(), done)
+
+	copyErr := r.copy(stdin, in)
+	stdin.Close()
+	if err := cmd.Wait(); err != nil {
+		return fmt.Errorf("执行 mariadb 恢复失败: %v, 标准错误输出: %s", err, stderr.String())
+	}
+	if copyErr != nil {
+		return fmt.Errorf("读取备份文件失败: %v", copyErr)
+	}
+	logger.Infof("已读取 %d MB / %d MB (100%%)\n", info.Size()/1024/1024, info.Size()/1024/1024)
+	return nil
+}
+
+// copy 写入会话设置和备份内容, 指定了库时只写入这些库的语句
+func (r *Restore) copy(w io.Writer, in io.Reader) error {
+	if r.databases == nil {
+		if _, err := io.WriteString(w, restoreSessionSQL); err != nil {
+			return err
+		}
+		_, err := io.Copy(w, in)
+		return err
+	}
+
+	br := bufio.NewReaderSize(in, 1024*1024)
+	// 第一个库之前是会话设置, 全部保留. 先缓存起来, 找到第一个库标记后再写入,
+	// 没有标记的备份不能按库恢复, 报错时还没有写入任何内容
+	head := bytes.NewBufferString(restoreSessionSQL)
+	keep, marked := true, false
+	for {
+		line, err := br.ReadString('\n')
+		if line != "" {
+			if m := currentDatabaseRegexp.FindStringSubmatch(line); m != nil {
+				db := strings.ReplaceAll(m[1], "``", "`")
+				_, keep = r.databases[db]
+				if keep {
+					r.databases[db] = true
+				}
+				if !marked {
+					if _, err := head.WriteTo(w); err != nil {
+						return err
+					}
+					marked = true
+				}
+			}
+			if !marked {
+				head.WriteString(line)
+			} else if keep || sessionSetRegexp.MatchString(line) {
+				if _, err := io.WriteString(w, line); err != nil {
+					return err
+				}
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if !marked {
+		return fmt.Errorf("备份文件中没有 \"-- Current Database\" 标记, 不能按库恢复, 请使用 --all-databases 生成的备份")
+	}
+	return nil
+}
+
+// progress 定时打印读取备份文件的字节数
+func (r *Restore) progress(size int64, done <-chan struct{}) {
+	ticker := time.NewTicker(restoreProgressInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			read := atomic.LoadInt64(&r.read)
+			var percent int64
+			if size > 0 {
+				percent = read * 100 / size
+			}
+			logger.Infof("已读取 %d MB / %d MB (%d%%)\n", read/1024/1024, size/1024/1024, percent)
+		}
+	}
+}
+
+// countingReader 统计读取的字节数
+type countingReader struct {
+	r io.Reader
+	n *int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	atomic.AddInt64(c.n, int64(n))
+	return n, err
+}
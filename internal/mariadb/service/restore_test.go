@@ -0,0 +1,51 @@
+package service
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestRestoreCopyDatabases(t *testing.T) {
+	dump := strings.Join([]string{
+		"/*!40101 SET NAMES utf8mb4 */;",
+		"--",
+		"-- Current Database: `app`",
+		"--",
+		"CREATE DATABASE `app`;",
+		"USE `app`;",
+		"INSERT INTO `t` VALUES (1);",
+		"/*!40101 SET character_set_client = @saved_cs_client */;",
+		"-- Current Database: `mysql`",
+		"USE `mysql`;",
+		"INSERT INTO `global_priv` VALUES ('localhost','root','{}');",
+		"/*!40103 SET TIME_ZONE=@OLD_TIME_ZONE */;",
+		"",
+	}, "\n")
+
+	r := &Restore{databases: map[string]bool{"app": false}}
+	var out bytes.Buffer
+	if err := r.copy(&out, strings.NewReader(dump)); err != nil {
+		t.Fatal(err)
+	}
+	got := out.String()
+	for _, s := range []string{restoreSessionSQL, "SET NAMES utf8mb4", "USE `app`;", "INSERT INTO `t`", "SET TIME_ZONE"} {
+		if !strings.Contains(got, s) {
+			t.Errorf("恢复内容中缺少: %s", s)
+		}
+	}
+	if strings.Contains(got, "global_priv") || strings.Contains(got, "USE `mysql`") {
+		t.Errorf("恢复了没有指定的库:\n%s", got)
+	}
+	if !r.databases["app"] {
+		t.Error("没有标记找到的库")
+	}
+
+	out.Reset()
+	if err := r.copy(&out, strings.NewReader("INSERT INTO `t` VALUES (1);\n")); err == nil {
+		t.Error("没有库标记的备份按库恢复时应该报错")
+	}
+	if out.Len() != 0 {
+		t.Errorf("没有库标记时不应该写入任何内容:\n%s", out.String())
+	}
+}
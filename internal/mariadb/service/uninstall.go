@@ -4,6 +4,7 @@ package service
 
 import (
 	"dbup/internal/global"
+	"dbup/internal/inventory"
 	"dbup/internal/mariadb/config"
 	"dbup/internal/utils"
 	"dbup/internal/utils/command"
@@ -47,5 +48,8 @@ func (i *MariaDBUNInstall) Uninstall() error {
 			logger.Warningf("删除安装目录成功\n")
 		}
 	}
+	if err := inventory.Forget(config.Kinds, i.Port); err != nil {
+		logger.Warningf("更新实例清单失败: %v\n", err)
+	}
 	return nil
 }
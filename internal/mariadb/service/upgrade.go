@@ -9,6 +9,7 @@ import (
 	"dbup/internal/utils"
 	"dbup/internal/utils/command"
 	"dbup/internal/utils/logger"
+	"dbup/internal/utils/prompt"
 	"fmt"
 	"os"
 	"path/filepath"
@@ -25,6 +26,7 @@ type UPgrade struct {
 	EmoloyDir   string
 	OldVersion  string
 	NewVersion  string
+	Version     string
 	Servicefile string
 	TxIsolation string
 	NoBackup    bool
@@ -139,16 +141,11 @@ func (u *UPgrade) Run() error {
 	// 非 最新版本 需要升级到 最新版本
 	if !strings.Contains(newMariadbVersion, "newVersion") {
 		if !u.Yes {
-			var yes string
 			logger.Warningf("升级版本需要重启实例: %s:%d\n", u.Host, u.Port)
 			// logger.Warningf("mariadb 从当前版本 %s 升级至 %s \n", u.OldVersion, u.NewVersion)
-			logger.Warningf("是否确认重启进行升级[y|n]:")
-			if _, err := fmt.Scanln(&yes); err != nil {
+			if err := prompt.Confirm("是否确认重启进行升级"); err != nil {
 				return err
 			}
-			if strings.ToUpper(yes) != "Y" && strings.ToUpper(yes) != "YES" {
-				os.Exit(0)
-			}
 		}
 
 		logger.Infof("开始升级\n")
@@ -160,15 +157,10 @@ func (u *UPgrade) Run() error {
 	} else {
 		// 已为 最新版本, 检测配置 jemalloc
 		if !u.Yes {
-			var yes string
 			logger.Warningf("配置jemalloc需要重启实例: %s:%d\n", u.Host, u.Port)
-			logger.Warningf("是否确认重启进行jemalloc配置[y|n]:")
-			if _, err := fmt.Scanln(&yes); err != nil {
+			if err := prompt.Confirm("是否确认重启进行jemalloc配置"); err != nil {
 				return err
 			}
-			if strings.ToUpper(yes) != "Y" && strings.ToUpper(yes) != "YES" {
-				os.Exit(0)
-			}
 		}
 
 		logger.Infof("开始配置 jemalloc, slave_parallel_threads\n")
@@ -192,8 +184,11 @@ func (u *UPgrade) RemoveTmp() {
 }
 
 func (u *UPgrade) InitDefaultpkg() error {
-	logger.Infof("开始检查默认升级版本 %s 的升级包\n", config.DefaultMariaDBVersion)
-	PackageFullName := filepath.Join(environment.GlobalEnv().ProgramPath, global.PackagePath, config.Kinds, fmt.Sprintf(config.PackageFile, config.DefaultMariaDBVersion, environment.GlobalEnv().GOOS, environment.GlobalEnv().GOARCH))
+	logger.Infof("开始检查升级版本 %s 的升级包\n", u.NewVersion)
+	PackageFullName := filepath.Join(environment.GlobalEnv().ProgramPath, global.PackagePath, config.Kinds, config.PackageName(u.NewVersion))
+	if err := global.CheckPackage(environment.GlobalEnv().ProgramPath, PackageFullName, config.Kinds); err != nil {
+		return err
+	}
 	u.EmoloyDir = filepath.Join(u.EmoloyDir, "mariadb_tmp_dir")
 	if !command.IsExists(u.EmoloyDir) {
 		if err := os.MkdirAll(u.EmoloyDir, 0755); err != nil {
@@ -205,8 +200,7 @@ func (u *UPgrade) InitDefaultpkg() error {
 			return err
 		}
 	} else {
-		logger.Errorf("新版本的安装主目录的临时路径 %s 已存在\n", u.EmoloyDir)
-		// return fmt.Errorf("新版本的安装主目录的临时路径 %s 已存在", u.EmoloyDir)
+		return fmt.Errorf("新版本的安装主目录的临时路径 %s 已存在", u.EmoloyDir)
 	}
 
 	return nil
@@ -220,7 +214,9 @@ func (u *UPgrade) VersionComparison() error {
 	}
 
 	if u.Default {
-		u.NewVersion = config.DefaultMariaDBVersion
+		if u.NewVersion, err = config.CheckVersion(u.Version); err != nil {
+			return err
+		}
 	} else {
 		u.NewVersion, err = command.MariadbVersion(u.EmoloyDir)
 		if err != nil {
@@ -24,6 +24,8 @@ type MongosOptions struct {
 	BindIP        string
 	Owner         string
 	ResourceLimit string
+	Tune          bool
+	Version       string
 	Yes           bool
 	NoRollback    bool
 }
@@ -254,6 +256,12 @@ func (M *MongoDBClusterOptions) Validator() error {
 		return fmt.Errorf("端口号(%d), 不是一个正确的端口号. 端口号必须在 1025 ~ 65535 之间", M.SSHConfig.Port)
 	}
 
+	version, err := CheckVersion(M.MongoConfig.Version)
+	if err != nil {
+		return err
+	}
+	M.MongoConfig.Version = version
+
 	return nil
 }
 
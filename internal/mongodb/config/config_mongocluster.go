@@ -1,6 +1,9 @@
 package config
 
-import "path/filepath"
+import (
+	"dbup/internal/utils/sshutil"
+	"path/filepath"
+)
 
 type Ssh_config struct {
 	Port     int    `yaml:"port"`
@@ -8,6 +11,26 @@ type Ssh_config struct {
 	Password string `yaml:"password"`
 	KeyFile  string `yaml:"keyfile"`
 	TmpDir   string `yaml:"tmp-dir"`
+	// 主机密钥校验策略: strict(默认), tofu, insecure
+	HostKeyCheck string `yaml:"host-key-check"`
+	KnownHosts   string `yaml:"known-hosts"`
+	// 跳板机列表, 按连接顺序排列
+	ProxyJump sshutil.JumpHosts `yaml:"proxy-jump"`
+	// 认证方式及尝试顺序, 逗号分隔: agent, publickey, password
+	Auth           string `yaml:"auth"`
+	PassphraseFile string `yaml:"passphrase-file"`
+}
+
+func (s *Ssh_config) SSHOptions() sshutil.Options {
+	return sshutil.Options{
+		HostKeyCheck:   s.HostKeyCheck,
+		KnownHosts:     s.KnownHosts,
+		JumpHosts:      s.ProxyJump,
+		Auth:           s.Auth,
+		Password:       s.Password,
+		KeyFile:        s.KeyFile,
+		PassphraseFile: s.PassphraseFile,
+	}
 }
 
 type Mongo_config struct {
@@ -18,8 +41,10 @@ type Mongo_config struct {
 	Ipv6           bool   `yaml:"ipv6"`
 	Bind_ip        string `yaml:"bind-ip"`
 	Resource_limit string `yaml:"resource-limit"`
+	Tune           bool   `yaml:"tune"`
 	System_user    string `yaml:"system-user"`
 	System_group   string `yaml:"system-group"`
+	Version        string `yaml:"version"`
 }
 
 type MongosNode struct {
@@ -10,6 +10,7 @@ import (
 	"dbup/internal/global"
 	"dbup/internal/utils"
 	"dbup/internal/utils/logger"
+	"dbup/internal/utils/sshutil"
 	"fmt"
 	"net"
 	"path/filepath"
@@ -38,6 +39,8 @@ type MongodbOptions struct {
 	Join          string `ini:"join" validate:"ipPort"`
 	Owner         string `ini:"owner"`
 	ResourceLimit string `ini:"resource-limit"`
+	Tune          bool   `ini:"tune"`
+	Version       string `ini:"version"`
 	Yes           bool   `ini:"yes"`
 	NoRollback    bool   `ini:"no-rollback"`
 }
@@ -189,6 +192,26 @@ type Server struct {
 	Password string `ini:"ssh-password"`
 	KeyFile  string `ini:"ssh-keyfile"`
 	TmpDir   string `ini:"tmp-dir"`
+	// 主机密钥校验策略: strict(默认), tofu, insecure
+	HostKeyCheck string `ini:"ssh-host-key-check"`
+	KnownHosts   string `ini:"ssh-known-hosts"`
+	// 跳板机, 多个以逗号分隔: [user[:password]@]host[:port][?keyfile=/path/to/key]
+	ProxyJump string `ini:"ssh-proxy-jump"`
+	// 认证方式及尝试顺序, 逗号分隔: agent, publickey, password
+	Auth           string `ini:"ssh-auth"`
+	PassphraseFile string `ini:"ssh-passphrase-file"`
+}
+
+func (s *Server) SSHOptions() sshutil.Options {
+	return sshutil.Options{
+		HostKeyCheck:   s.HostKeyCheck,
+		KnownHosts:     s.KnownHosts,
+		ProxyJump:      s.ProxyJump,
+		Auth:           s.Auth,
+		Password:       s.Password,
+		KeyFile:        s.KeyFile,
+		PassphraseFile: s.PassphraseFile,
+	}
 }
 
 func (s *Server) SetDefault() {
@@ -294,5 +317,5 @@ func (o *MongoDBDeployOptions) Validator() error {
 	if err := o.Ipv6Verify(); err != nil {
 		return err
 	}
-	return nil
+	return o.MongoDB.CheckVersion()
 }
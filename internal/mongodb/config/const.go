@@ -46,6 +46,15 @@ const (
 	DeployTmpDir = "/tmp/tmpmongodb"
 )
 
+// mongodb backup 计划任务
+const (
+	BackupTaskLinuxCronFile   = "/var/spool/cron/root"
+	BackupTaskNamePrefix      = "DbupMongoDBBackupTask"
+	BackupTaskDefaultTaskName = "mongodb_backup"
+	BackupTaskDefaultTaskTime = "02:00"
+	BackupTaskFilePrefix      = "mongodb_backup_"
+)
+
 const (
 	Mongos              = "mongos"
 	MongoConfig         = "config"
@@ -3,9 +3,14 @@
 package service
 
 import (
+	"dbup/internal/mongodb/config"
+	"dbup/internal/output"
 	"dbup/internal/utils/command"
 	"dbup/internal/utils/logger"
+	"encoding/json"
 	"fmt"
+	"io/ioutil"
+	"os"
 )
 
 // redis 备份
@@ -39,12 +44,38 @@ func (b *Backup) Run() error {
 	logger.Infof("备份开始\n")
 
 	// mongodump --authenticationDatabase="admin" --host="127.0.0.1" --port=35011 --username="monitor" --password="08b5411f848a2581a41672a759c87380" --numParallelCollections=16 --gzip --archive="test.20150716.gz"
-	cmd := fmt.Sprintf("%s --authenticationDatabase='%s' --host='%s' --port=%d --username='%s' --password='%s' --gzip --archive='%s'", b.BackupCmd, b.AuthDB, b.Host, b.Port, b.Username, b.Password, b.BackupFile)
+	// 密码写入只有当前用户可读的临时配置文件, 不出现在进程列表中
+	conf, err := b.writePasswordConfig()
+	if err != nil {
+		return err
+	}
+	defer os.Remove(conf)
+	cmd := fmt.Sprintf("%s --config='%s' --authenticationDatabase='%s' --host='%s' --port=%d --username='%s' --gzip --archive='%s'", b.BackupCmd, conf, b.AuthDB, b.Host, b.Port, b.Username, b.BackupFile)
 	l := command.Local{Timeout: 259200}
 	if _, stderr, err := l.Run(cmd); err != nil {
 		return fmt.Errorf("执行redis备份失败: %v, 标准错误输出: %s", err, stderr)
 	}
 
 	logger.Infof("备份完成\n")
+	output.Created(output.Resource{Kind: "backup", Engine: config.Kinds, Port: b.Port, Path: b.BackupFile})
 	return nil
 }
+
+// writePasswordConfig 生成 mongodump --config 使用的 yaml 文件, 权限为 0600
+func (b *Backup) writePasswordConfig() (string, error) {
+	f, err := ioutil.TempFile("", "mongodump-*.yaml")
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	// json 字符串同时也是合法的 yaml 字符串
+	password, err := json.Marshal(b.Password)
+	if err != nil {
+		return "", err
+	}
+	if _, err := fmt.Fprintf(f, "password: %s\n", password); err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+	return f.Name(), nil
+}
@@ -0,0 +1,62 @@
+package service
+
+import (
+	"dbup/internal/global/backuptask"
+	"dbup/internal/mongodb/config"
+	"dbup/internal/utils/logger"
+	"fmt"
+	"path/filepath"
+	"time"
+
+	"github.com/spf13/pflag"
+)
+
+// 备份定时任务
+type BackupTask struct {
+	backuptask.Cron
+	Backup *Backup
+}
+
+func NewBackupTask() *BackupTask {
+	return &BackupTask{
+		Cron: backuptask.Cron{
+			RunCmd:     "mongodb backup-task run",
+			NamePrefix: config.BackupTaskNamePrefix,
+			FilePrefix: config.BackupTaskFilePrefix,
+			CronFile:   config.BackupTaskLinuxCronFile,
+		},
+		Backup: NewBackup(),
+	}
+}
+
+func (t *BackupTask) Run() error {
+	logger.Infof("运行备份任务\n")
+	tm := time.Now().Format("20060102150405")
+	t.Backup.BackupFile = filepath.Join(t.BackupDir, config.BackupTaskFilePrefix+tm+".archive.gz")
+	if err := t.Backup.Run(); err != nil {
+		return err
+	}
+	return t.DropExpire()
+}
+
+func (t *BackupTask) LinuxAdd() error {
+	args := fmt.Sprintf("--command='%s' --host=%s --port=%d --auth-db='%s' --username='%s'", t.Backup.BackupCmd, t.Backup.Host, t.Backup.Port, t.Backup.AuthDB, t.Backup.Username)
+	return t.Cron.LinuxAdd(args, map[string]string{"password": t.Backup.Password})
+}
+
+// BackupFlags add 和 run 共用的备份参数, 与定时任务中的命令行一致
+func (t *BackupTask) BackupFlags(flags *pflag.FlagSet) {
+	flags.StringVarP(&t.Backup.Username, "username", "u", "", "用户名")
+	flags.StringVarP(&t.Backup.Password, "password", "p", "", "密码")
+	flags.StringVarP(&t.Backup.Host, "host", "H", "127.0.0.1", "mongodb 地址")
+	flags.StringVar(&t.Backup.AuthDB, "auth-db", "admin", "认证库名")
+	t.PortFlag(flags)
+	flags.StringVarP(&t.Backup.BackupCmd, "command", "c", "mongodump", "mongodb 备份命令")
+	flags.StringVarP(&t.BackupDir, "backupdir", "d", "", "mongodb 备份目录, 备份文件名为 mongodb_backup_<时间>.archive.gz")
+	flags.IntVarP(&t.Expire, "expire", "e", 0, "备份过期天数")
+}
+
+// PortFlag 备份端口参数, del 只需要端口和任务名称
+func (t *BackupTask) PortFlag(flags *pflag.FlagSet) {
+	flags.IntVarP(&t.Backup.Port, "port", "P", 27017, "mongodb 数据库监听端口")
+}
@@ -4,12 +4,17 @@ import (
 	"context"
 	"dbup/internal/environment"
 	"dbup/internal/global"
+	"dbup/internal/inventory"
+	"dbup/internal/journal"
 	"dbup/internal/mongodb/config"
 	"dbup/internal/mongodb/dao"
+	"dbup/internal/plan"
+	"dbup/internal/preflight"
 	"dbup/internal/utils/command"
 	"dbup/internal/utils/logger"
+	"dbup/internal/utils/parallel"
+	"dbup/internal/utils/prompt"
 	"fmt"
-	"os"
 	"path"
 	"strings"
 	"time"
@@ -25,25 +30,63 @@ type MongoDBClusterDeploy struct {
 	slaves  []*MongoDBInstance
 	arbiter *MongoDBInstance
 	mongos  []*MongoSInstance
+	journal *journal.Journal
+	ctx     context.Context
 }
 
+// clusterMode 分片集群在实例清单和部署记录中的部署方式
+const clusterMode = "sharding"
+
 func NewMongoClusterDeploy() *MongoDBClusterDeploy {
 	return &MongoDBClusterDeploy{}
 }
 
+// WithContext 设置部署使用的 ctx, ctx 取消后不再开始新的步骤. 回滚和清理不受 ctx 影响
+func (d *MongoDBClusterDeploy) WithContext(ctx context.Context) *MongoDBClusterDeploy {
+	d.ctx = ctx
+	return d
+}
+
+func (d *MongoDBClusterDeploy) context() context.Context {
+	if d.ctx == nil {
+		return context.Background()
+	}
+	return d.ctx
+}
+
 func (d *MongoDBClusterDeploy) Run(c string) error {
 	// 初始化参数和配置环节
-	if err := global.YAMLLoadFromFile(c, &d.coption); err != nil {
+	if err := d.load(c); err != nil {
 		return err
 	}
 
-	d.coption.SetDefault()
+	j, err := journal.Start(config.Kinds, clusterMode, c)
+	if err != nil {
+		return err
+	}
+	d.journal = j
+	err = d.deploy()
+	j.Finish(err)
+	return err
+}
 
-	// 验证集群的配置参数规范
-	if err := d.coption.Validators(); err != nil {
+// Resume 根据部署记录继续部署, 跳过已经安装完成的实例
+func (d *MongoDBClusterDeploy) Resume(id string) error {
+	j, err := journal.Resume(id, config.Kinds, clusterMode)
+	if err != nil {
+		return err
+	}
+	if err := d.load(j.Config); err != nil {
+		j.Finish(err)
 		return err
 	}
+	d.journal = j
+	err = d.deploy()
+	j.Finish(err)
+	return err
+}
 
+func (d *MongoDBClusterDeploy) deploy() error {
 	// 验证集群的IPV6环境配置
 	if d.coption.MongoConfig.Ipv6 {
 		logger.Infof("验证Mongodb集群IPV6环境\n")
@@ -66,9 +109,119 @@ func (d *MongoDBClusterDeploy) Run(c string) error {
 		return err
 	}
 
+	if err := inventory.RecordCluster(d.cluster()); err != nil {
+		logger.Warningf("更新实例清单失败: %v\n", err)
+	}
 	return nil
 }
 
+// load 读取并验证部署配置
+func (d *MongoDBClusterDeploy) load(c string) error {
+	if err := global.YAMLLoadFromFile(c, &d.coption); err != nil {
+		return err
+	}
+
+	d.coption.SetDefault()
+
+	// 验证集群的配置参数规范
+	return d.coption.Validators()
+}
+
+// Plan 只读连接各节点, 输出部署计划和冲突, 不做任何修改.
+// 部署顺序与 Run 相同: 先部署各个分片副本集, 再部署 config 副本集, 最后部署 mongos 并添加分片
+func (d *MongoDBClusterDeploy) Plan(c string) error {
+	if err := d.load(c); err != nil {
+		return err
+	}
+	s := d.coption.SSHConfig
+	files := []string{
+		"bin/dbup",
+		"package/md5",
+		path.Join("systemd", config.ServiceTemplateFile(d.coption.MongoConfig.Version)),
+		path.Join("package", "mongodb", config.PackageName(d.coption.MongoConfig.Version)),
+	}
+
+	logger.Infof("检查部署节点\n")
+	p := plan.New(config.Kinds, clusterMode, plan.SSHDialer(s.Username, s.Password, s.KeyFile, s.Port, s.SSHOptions()))
+	// replicaSet 安装副本集的各个成员, 在第一个成员上初始化副本集, 返回副本集地址
+	replicaSet := func(name string, hosts []string, ports []int, dirs []string) string {
+		var members []string
+		for n, host := range hosts {
+			h := p.Host(host)
+			h.Stage(s.TmpDir, files...)
+			h.Instance(config.Kinds, ports[n], dirs[n], fmt.Sprintf(config.ServiceFileName, ports[n]))
+			members = append(members, fmt.Sprintf("%s:%d", host, ports[n]))
+		}
+		first := p.Host(hosts[0])
+		first.Add(plan.ActionReplication, "初始化副本集 %s, 成员: %s", name, strings.Join(members, ","))
+		first.Add(plan.ActionUser, "管理用户 %s", d.coption.MongoConfig.Username)
+		return fmt.Sprintf("%s/%s", name, strings.Join(members, ","))
+	}
+
+	var shards []string
+	for n, shard := range d.coption.MongoShard {
+		if len(shard.Shard) == 0 {
+			continue
+		}
+		var hosts, dirs []string
+		var ports []int
+		for _, node := range shard.Shard {
+			hosts, ports, dirs = append(hosts, node.Host), append(ports, node.Port), append(dirs, node.Dir)
+		}
+		shards = append(shards, replicaSet(fmt.Sprintf("Shard%d-%d", n+1, ports[0]), hosts, ports, dirs))
+	}
+
+	var configDB string
+	if len(d.coption.MongoCfg) > 0 {
+		var hosts, dirs []string
+		var ports []int
+		for _, node := range d.coption.MongoCfg {
+			hosts, ports, dirs = append(hosts, node.Host), append(ports, node.Port), append(dirs, node.Dir)
+		}
+		configDB = replicaSet(fmt.Sprintf("Config-%d", ports[0]), hosts, ports, dirs)
+	}
+
+	for _, node := range d.coption.Mongos {
+		h := p.Host(node.Host)
+		h.Stage(s.TmpDir, files...)
+		h.Instance("mongos", node.Port, node.Dir, fmt.Sprintf(config.ServiceFileName, node.Port))
+		h.Add(plan.ActionReplication, "连接 config 副本集 %s", configDB)
+	}
+	if len(d.coption.Mongos) > 0 {
+		first := p.Host(d.coption.Mongos[0].Host)
+		for _, shard := range shards {
+			first.Add(plan.ActionReplication, "添加分片 %s", shard)
+		}
+	}
+	for _, h := range p.Hosts {
+		h.Add(plan.ActionCleanup, "删除临时目录 %s 中的文件", s.TmpDir)
+	}
+	return p.Finish()
+}
+
+// Check 连接各节点执行部署前检查, 不做任何修改
+func (d *MongoDBClusterDeploy) Check(c string) error {
+	if err := d.load(c); err != nil {
+		return err
+	}
+	s := d.coption.SSHConfig
+
+	logger.Infof("检查部署节点环境\n")
+	p := preflight.New(plan.SSHDialer(s.Username, s.Password, s.KeyFile, s.Port, s.SSHOptions()))
+	for _, shard := range d.coption.MongoShard {
+		for _, node := range shard.Shard {
+			p.Add(node.Host, config.Kinds, []int{node.Port}, node.Dir)
+		}
+	}
+	for _, node := range d.coption.MongoCfg {
+		p.Add(node.Host, config.Kinds, []int{node.Port}, node.Dir)
+	}
+	for _, node := range d.coption.Mongos {
+		p.Add(node.Host, config.Kinds, []int{node.Port}, node.Dir)
+	}
+	return p.Finish()
+}
+
 func (d *MongoDBClusterDeploy) RemoveCluster(c string, yes bool) error {
 	// 初始化参数和配置环节
 	if err := global.YAMLLoadFromFile(c, &d.coption); err != nil {
@@ -115,15 +268,9 @@ func (d *MongoDBClusterDeploy) RemoveCluster(c string, yes bool) error {
 
 	if !yes {
 		logger.Warningf("删除集群是危险操作,会将整个集群中的数据完全删除, 不可恢复\n")
-		logger.Warningf("是否确认删除[y|n]:")
-
-		var yes string
-		if _, err := fmt.Scanln(&yes); err != nil {
+		if err := prompt.Confirm("是否确认删除"); err != nil {
 			return err
 		}
-		if strings.ToUpper(yes) != "Y" && strings.ToUpper(yes) != "YES" {
-			os.Exit(0)
-		}
 	}
 
 	logger.Infof("初始化 Mongodb 分片集群的删除对象与删除操作\n")
@@ -140,9 +287,32 @@ func (d *MongoDBClusterDeploy) RemoveCluster(c string, yes bool) error {
 		return err
 	}
 
+	if err := inventory.ForgetCluster(d.cluster().Name); err != nil {
+		logger.Warningf("更新实例清单失败: %v\n", err)
+	}
 	return nil
 }
 
+// cluster 集群成员, 用于记录到实例清单
+func (d *MongoDBClusterDeploy) cluster() inventory.Cluster {
+	c := inventory.Cluster{Engine: config.Kinds, Mode: "sharding"}
+	for _, node := range d.coption.Mongos {
+		c.Members = append(c.Members, inventory.Member{Host: node.Host, Port: node.Port, Role: "mongos", Dir: node.Dir})
+	}
+	for _, node := range d.coption.MongoCfg {
+		c.Members = append(c.Members, inventory.Member{Host: node.Host, Port: node.Port, Role: "config", Dir: node.Dir})
+	}
+	for n, shard := range d.coption.MongoShard {
+		for _, node := range shard.Shard {
+			c.Members = append(c.Members, inventory.Member{Host: node.Host, Port: node.Port, Role: fmt.Sprintf("shard%d", n+1), Dir: node.Dir})
+		}
+	}
+	if len(c.Members) > 0 {
+		c.Name = inventory.ClusterName(config.Kinds, c.Members[0].Host, c.Members[0].Port)
+	}
+	return c
+}
+
 func (d *MongoDBClusterDeploy) ClusterInstall(role, mongoswitch string) error {
 	// 初始化副本配置
 	d.option.Server.Arbiter = ""
@@ -153,6 +323,8 @@ func (d *MongoDBClusterDeploy) ClusterInstall(role, mongoswitch string) error {
 	d.option.MongoDB.SystemUser = d.coption.MongoConfig.System_user
 	d.option.MongoDB.SystemGroup = d.coption.MongoConfig.System_group
 	d.option.MongoDB.ResourceLimit = d.coption.MongoConfig.Resource_limit
+	d.option.MongoDB.Tune = d.coption.MongoConfig.Tune
+	d.option.MongoDB.Version = d.coption.MongoConfig.Version
 	// d.option.Server.
 
 	switch role {
@@ -189,6 +361,8 @@ func (d *MongoDBClusterDeploy) ClusterInstall(role, mongoswitch string) error {
 		d.coption.Mongosoption.SystemUser = d.coption.MongoConfig.System_user
 		d.coption.Mongosoption.SystemGroup = d.coption.MongoConfig.System_group
 		d.coption.Mongosoption.ResourceLimit = d.coption.MongoConfig.Resource_limit
+		d.coption.Mongosoption.Tune = d.coption.MongoConfig.Tune
+		d.coption.Mongosoption.Version = d.coption.MongoConfig.Version
 		switch mongoswitch {
 		case config.Mongoclusterinstall:
 			if err := d.MongoSInit(); err != nil {
@@ -207,6 +381,9 @@ func (d *MongoDBClusterDeploy) ClusterInstall(role, mongoswitch string) error {
 func (d *MongoDBClusterDeploy) MongoShardInit() error {
 
 	for n, shard := range d.coption.MongoShard {
+		if err := d.context().Err(); err != nil {
+			return err
+		}
 
 		if d.coption.SSHConfig.Password != "" {
 			if err := d.Sinit(n, shard.Shard); err != nil {
@@ -441,7 +618,12 @@ func (d *MongoDBClusterDeploy) MongosInitverify() error {
 	m := d.coption.Mongos[:1]
 	msnode := m[len(m)-1]
 	sharlist := d.coption.Mongosoption.Shardlist
-	conn, err := dao.NewMongoClient(msnode.Host, msnode.Port, d.coption.Mongosoption.Username, d.coption.Mongosoption.Password, "admin")
+	dialHost, dialPort, closeTunnel, err := d.mongos[0].Conn.DialAddress(msnode.Port)
+	if err != nil {
+		return err
+	}
+	defer closeTunnel()
+	conn, err := dao.NewMongoClient(dialHost, dialPort, d.coption.Mongosoption.Username, d.coption.Mongosoption.Password, "admin")
 	if err != nil {
 		return err
 	}
@@ -492,7 +674,12 @@ func (d *MongoDBClusterDeploy) MongosInitShard(shardDB string) error {
 	m := d.coption.Mongos[:1]
 	msnode := m[len(m)-1]
 
-	conn, err := dao.NewMongoClient(msnode.Host, msnode.Port, d.coption.Mongosoption.Username, d.coption.Mongosoption.Password, "admin")
+	dialHost, dialPort, closeTunnel, err := d.mongos[0].Conn.DialAddress(msnode.Port)
+	if err != nil {
+		return err
+	}
+	defer closeTunnel()
+	conn, err := dao.NewMongoClient(dialHost, dialPort, d.coption.Mongosoption.Username, d.coption.Mongosoption.Password, "admin")
 	if err != nil {
 		return err
 	}
@@ -504,69 +691,69 @@ func (d *MongoDBClusterDeploy) MongosInitShard(shardDB string) error {
 	return nil
 }
 
+// MSInstall mongos 路由节点之间互不依赖, 同时安装
 func (d *MongoDBClusterDeploy) MSInstall() error {
 	logger.Infof("开始安装 Mongos\n")
-	for _, mongosnode := range d.mongos {
-		if err := mongosnode.Install(false, d.coption.Mongosoption.Ipv6); err != nil {
-			return err
-		}
-	}
-
-	return nil
+	return d.eachMongos(d.context(), "安装 Mongos", func(ms *MongoSInstance) error {
+		return d.journal.Run(ms.Host, installStep(ms.Inst.Option.Port), func() error {
+			return ms.Install(false, d.coption.Mongosoption.Ipv6)
+		})
+	})
 }
 
 func (d *MongoDBClusterDeploy) MSUNInstall() error {
 	logger.Infof("开始卸载 Mongos\n")
-	for _, mongosnode := range d.mongos {
-		if err := mongosnode.UNInstall(); err != nil {
+	return d.eachMongos(context.Background(), "卸载 Mongos", func(ms *MongoSInstance) error {
+		if err := ms.UNInstall(); err != nil {
 			return err
 		}
-	}
-
-	return nil
+		d.journal.Undo(ms.Host, installStep(ms.Inst.Option.Port))
+		return nil
+	})
 }
 
 func (d *MongoDBClusterDeploy) MSCheckTmpDir() error {
 	logger.Infof("检查 Mongos 目标机器的临时目录\n")
-
-	for _, mongosnode := range d.mongos {
-		if err := mongosnode.CheckTmpDir(); err != nil {
-			return err
-		}
-	}
-
-	return nil
+	return d.eachMongos(d.context(), "检查临时目录", func(ms *MongoSInstance) error {
+		return ms.CheckTmpDir()
+	})
 }
 
 func (d *MongoDBClusterDeploy) MSDropTmpDir() {
 	logger.Infof("删除目标机器的临时目录\n")
-
-	for _, mongosnode := range d.mongos {
-		_ = mongosnode.DropTmpDir()
-	}
-
+	_ = d.eachMongos(context.Background(), "删除临时目录", func(ms *MongoSInstance) error {
+		_ = ms.DropTmpDir()
+		return nil
+	})
 }
 
 func (d *MongoDBClusterDeploy) MScp() error {
 	logger.Infof("将 Mongos 所需文件复制到目标机器\n")
 	source := path.Join(environment.GlobalEnv().ProgramPath, "..")
-	for _, mongosnode := range d.mongos {
-		logger.Infof("复制到: %s\n", mongosnode.Host)
-		if err := mongosnode.Scp(source); err != nil {
-			return err
-		}
-	}
-	return nil
+	return d.eachMongos(d.context(), "复制文件", func(ms *MongoSInstance) error {
+		logger.Infof("复制到: %s\n", ms.Host)
+		return ms.Scp(source)
+	})
 }
 
 func (d *MongoDBClusterDeploy) MSCheckEnv() error {
 	logger.Infof("检查环境\n")
-	for _, mongosnode := range d.mongos {
-		if err := mongosnode.Install(true, d.coption.Mongosoption.Ipv6); err != nil {
-			return err
+	return d.eachMongos(d.context(), "检查环境", func(ms *MongoSInstance) error {
+		if d.journal.Done(ms.Host, installStep(ms.Inst.Option.Port)) {
+			return nil
 		}
+		return ms.Install(true, d.coption.Mongosoption.Ipv6)
+	})
+}
+
+// eachMongos 在所有 mongos 节点上并发执行 fn
+func (d *MongoDBClusterDeploy) eachMongos(ctx context.Context, name string, fn func(ms *MongoSInstance) error) error {
+	var tasks []parallel.Task
+	for _, ms := range d.mongos {
+		ms := ms
+		tasks = append(tasks, parallel.Task{Host: ms.Host, Run: func() error { return fn(ms) }})
 	}
-	return nil
+	return parallel.Run(ctx, name, tasks)
 }
 
 func (d *MongoDBClusterDeploy) MSinit(Nodelist []config.MongosNode) error {
@@ -583,7 +770,8 @@ func (d *MongoDBClusterDeploy) MSinit(Nodelist []config.MongosNode) error {
 			d.coption.SSHConfig.Username,
 			d.coption.SSHConfig.Password,
 			d.coption.SSHConfig.Port,
-			d.coption.Mongosoption)
+			d.coption.Mongosoption,
+			d.coption.SSHConfig.SSHOptions())
 		if err != nil {
 			return err
 		}
@@ -608,7 +796,8 @@ func (d *MongoDBClusterDeploy) MSinitUseKeyFile(Nodelist []config.MongosNode) er
 			d.coption.SSHConfig.Username,
 			d.coption.SSHConfig.Password,
 			d.coption.SSHConfig.Port,
-			d.coption.Mongosoption)
+			d.coption.Mongosoption,
+			d.coption.SSHConfig.SSHOptions())
 		if err != nil {
 			return err
 		}
@@ -663,7 +852,8 @@ func (d *MongoDBClusterDeploy) Sinit(num int, Nodelist []config.MongoShardNode)
 		d.coption.SSHConfig.Username,
 		d.coption.SSHConfig.Password,
 		d.coption.SSHConfig.Port,
-		d.option.MongoDB); err != nil {
+		d.option.MongoDB,
+		d.coption.SSHConfig.SSHOptions()); err != nil {
 		return err
 	}
 	for _, slave := range Nodelist[1:] {
@@ -674,7 +864,8 @@ func (d *MongoDBClusterDeploy) Sinit(num int, Nodelist []config.MongoShardNode)
 			d.coption.SSHConfig.Username,
 			d.coption.SSHConfig.Password,
 			d.coption.SSHConfig.Port,
-			d.option.MongoDB)
+			d.option.MongoDB,
+			d.coption.SSHConfig.SSHOptions())
 		if err != nil {
 			return err
 		}
@@ -691,7 +882,8 @@ func (d *MongoDBClusterDeploy) Sinit(num int, Nodelist []config.MongoShardNode)
 			d.coption.SSHConfig.Username,
 			d.coption.SSHConfig.Password,
 			d.coption.SSHConfig.Port,
-			d.option.MongoDB); err != nil {
+			d.option.MongoDB,
+			d.coption.SSHConfig.SSHOptions()); err != nil {
 			return err
 		}
 		d.arbiter.Inst.Option.Memory = 1
@@ -723,7 +915,8 @@ func (d *MongoDBClusterDeploy) Cinit(Nodelist []config.MongoConfigNode) error {
 		d.coption.SSHConfig.Username,
 		d.coption.SSHConfig.Password,
 		d.coption.SSHConfig.Port,
-		d.option.MongoDB); err != nil {
+		d.option.MongoDB,
+		d.coption.SSHConfig.SSHOptions()); err != nil {
 		return err
 	}
 	for _, slave := range Nodelist[1:] {
@@ -734,7 +927,8 @@ func (d *MongoDBClusterDeploy) Cinit(Nodelist []config.MongoConfigNode) error {
 			d.coption.SSHConfig.Username,
 			d.coption.SSHConfig.Password,
 			d.coption.SSHConfig.Port,
-			d.option.MongoDB)
+			d.option.MongoDB,
+			d.coption.SSHConfig.SSHOptions())
 		if err != nil {
 			return err
 		}
@@ -753,7 +947,8 @@ func (d *MongoDBClusterDeploy) Cinit(Nodelist []config.MongoConfigNode) error {
 			d.coption.SSHConfig.Username,
 			d.coption.SSHConfig.Password,
 			d.coption.SSHConfig.Port,
-			d.option.MongoDB); err != nil {
+			d.option.MongoDB,
+			d.coption.SSHConfig.SSHOptions()); err != nil {
 			return err
 		}
 		d.arbiter.Inst.Option.Memory = 1
@@ -782,7 +977,8 @@ func (d *MongoDBClusterDeploy) SinitUseKeyFile(num int, Nodelist []config.MongoS
 		d.option.Server.User,
 		d.option.Server.KeyFile,
 		d.option.Server.SshPort,
-		d.option.MongoDB); err != nil {
+		d.option.MongoDB,
+		d.coption.SSHConfig.SSHOptions()); err != nil {
 		return err
 	}
 	for _, slave := range Nodelist[1:] {
@@ -793,7 +989,8 @@ func (d *MongoDBClusterDeploy) SinitUseKeyFile(num int, Nodelist []config.MongoS
 			d.option.Server.User,
 			d.option.Server.KeyFile,
 			d.option.Server.SshPort,
-			d.option.MongoDB)
+			d.option.MongoDB,
+			d.coption.SSHConfig.SSHOptions())
 		if err != nil {
 			return err
 		}
@@ -811,9 +1008,8 @@ func (d *MongoDBClusterDeploy) SinitUseKeyFile(num int, Nodelist []config.MongoS
 			d.option.Server.User,
 			d.option.Server.KeyFile,
 			d.option.Server.SshPort,
-			d.option.MongoDB); err != nil {
-			fmt.Println(master.Host)
-			fmt.Println("arbiter的主", master.Host)
+			d.option.MongoDB,
+			d.coption.SSHConfig.SSHOptions()); err != nil {
 			return err
 		}
 		d.arbiter.Inst.Option.Memory = 1
@@ -839,7 +1035,8 @@ func (d *MongoDBClusterDeploy) CinitUseKeyFile(Nodelist []config.MongoConfigNode
 		d.option.Server.User,
 		d.option.Server.KeyFile,
 		d.option.Server.SshPort,
-		d.option.MongoDB); err != nil {
+		d.option.MongoDB,
+		d.coption.SSHConfig.SSHOptions()); err != nil {
 		return err
 	}
 	for _, slave := range Nodelist[1:] {
@@ -850,7 +1047,8 @@ func (d *MongoDBClusterDeploy) CinitUseKeyFile(Nodelist []config.MongoConfigNode
 			d.option.Server.User,
 			d.option.Server.KeyFile,
 			d.option.Server.SshPort,
-			d.option.MongoDB)
+			d.option.MongoDB,
+			d.coption.SSHConfig.SSHOptions())
 		if err != nil {
 			return err
 		}
@@ -865,9 +1063,8 @@ func (d *MongoDBClusterDeploy) CinitUseKeyFile(Nodelist []config.MongoConfigNode
 			d.option.Server.User,
 			d.option.Server.KeyFile,
 			d.option.Server.SshPort,
-			d.option.MongoDB); err != nil {
-			fmt.Println(master.Host)
-			fmt.Println("arbiter的主", master.Host)
+			d.option.MongoDB,
+			d.coption.SSHConfig.SSHOptions()); err != nil {
 			return err
 		}
 		d.arbiter.Inst.Option.Memory = 1
@@ -903,7 +1100,8 @@ func (d *MongoDBClusterDeploy) CheckClusterHosts() error {
 				d.coption.SSHConfig.Username,
 				d.coption.SSHConfig.Password,
 				d.coption.SSHConfig.Port,
-				d.option.MongoDB)
+				d.option.MongoDB,
+				d.coption.SSHConfig.SSHOptions())
 			if err != nil {
 				return err
 			}
@@ -919,7 +1117,8 @@ func (d *MongoDBClusterDeploy) CheckClusterHosts() error {
 				d.coption.SSHConfig.Username,
 				d.coption.SSHConfig.Password,
 				d.coption.SSHConfig.Port,
-				d.option.MongoDB)
+				d.option.MongoDB,
+				d.coption.SSHConfig.SSHOptions())
 			if err != nil {
 				return err
 			}
@@ -934,114 +1133,100 @@ func (d *MongoDBClusterDeploy) CheckClusterHosts() error {
 
 func (d *MongoDBClusterDeploy) CheckTmpDir() error {
 	logger.Infof("检查目标机器的临时目录\n")
-	if err := d.master.CheckTmpDir(); err != nil {
-		return err
-	}
-	for _, slave := range d.slaves {
-		if err := slave.CheckTmpDir(); err != nil {
-			return err
-		}
-	}
-
-	if d.arbiter != nil {
-		if err := d.arbiter.CheckTmpDir(); err != nil {
-			return err
-		}
-	}
-	return nil
+	return d.each(d.context(), "检查临时目录", func(m *MongoDBInstance) error {
+		return m.CheckTmpDir()
+	})
 }
 
 func (d *MongoDBClusterDeploy) Scp() error {
 	logger.Infof("将所需文件复制到目标机器\n")
 	source := path.Join(environment.GlobalEnv().ProgramPath, "..")
-	logger.Infof("复制到: %s\n", d.master.Host)
-	if err := d.master.Scp(source); err != nil {
-		return err
-	}
-	for _, slave := range d.slaves {
-		logger.Infof("复制到: %s\n", slave.Host)
-		if err := slave.Scp(source); err != nil {
-			return err
-		}
-	}
-
-	if d.arbiter != nil {
-		logger.Infof("复制到: %s\n", d.arbiter.Host)
-		if err := d.arbiter.Scp(source); err != nil {
-			return err
-		}
-	}
-	return nil
+	return d.each(d.context(), "复制文件", func(m *MongoDBInstance) error {
+		logger.Infof("复制到: %s\n", m.Host)
+		return m.Scp(source)
+	})
 }
 
 func (d *MongoDBClusterDeploy) DropTmpDir() {
 	logger.Infof("删除目标机器的临时目录\n")
-	_ = d.master.DropTmpDir()
-	for _, slave := range d.slaves {
-		_ = slave.DropTmpDir()
-	}
-	if d.arbiter != nil {
-		_ = d.arbiter.DropTmpDir()
-	}
-
+	_ = d.each(context.Background(), "删除临时目录", func(m *MongoDBInstance) error {
+		_ = m.DropTmpDir()
+		return nil
+	})
 }
 
+// CheckEnv 检查环境, 已经安装完成的实例不再检查
 func (d *MongoDBClusterDeploy) CheckEnv() error {
 	logger.Infof("检查环境\n")
-	if err := d.master.Install(true, false, false, d.coption.MongoConfig.Ipv6); err != nil {
-		return err
-	}
-	for _, slave := range d.slaves {
-		if err := slave.Install(true, false, false, d.coption.MongoConfig.Ipv6); err != nil {
-			return err
-		}
-	}
-	if d.arbiter != nil {
-		if err := d.arbiter.Install(true, true, false, d.coption.MongoConfig.Ipv6); err != nil {
-			return err
+	return d.each(d.context(), "检查环境", func(m *MongoDBInstance) error {
+		if d.journal.Done(m.Host, installStep(m.Inst.Option.Port)) {
+			return nil
 		}
-	}
-	return nil
+		return m.Install(true, m == d.arbiter, false, d.coption.MongoConfig.Ipv6)
+	})
 }
 
+// Install 从节点和仲裁节点通过主节点加入副本集, 需要依次修改副本集配置, 因此副本集内按顺序安装
 func (d *MongoDBClusterDeploy) Install() error {
 	logger.Infof("开始安装\n")
-	if err := d.master.Install(false, false, false, d.coption.MongoConfig.Ipv6); err != nil {
-		return err
-	}
-	for _, slave := range d.slaves {
-		if err := slave.Install(false, false, false, d.coption.MongoConfig.Ipv6); err != nil {
+	for _, m := range d.members() {
+		if err := d.context().Err(); err != nil {
 			return err
 		}
-	}
-	if d.arbiter != nil {
-		if err := d.arbiter.Install(false, true, false, d.coption.MongoConfig.Ipv6); err != nil {
+		m := m
+		if err := d.journal.Run(m.Host, installStep(m.Inst.Option.Port), func() error {
+			return m.Install(false, m == d.arbiter, false, d.coption.MongoConfig.Ipv6)
+		}); err != nil {
 			return err
 		}
 	}
 	return nil
 }
 
+// UNInstall 卸载当前副本集的实例, 同时从部署记录中删除这些实例的安装步骤, 继续部署时重新安装
 func (d *MongoDBClusterDeploy) UNInstall() {
 	logger.Infof("开始卸载清理\n")
-	if err := d.master.UNInstall(); err != nil {
-		logger.Warningf("卸载节点: %s 失败: %v\n", d.master.Host, err)
-	}
-	for _, slave := range d.slaves {
-		if err := slave.UNInstall(); err != nil {
-			logger.Warningf("卸载节点: %s 失败: %v\n", slave.Host, err)
+	// 卸载失败的节点由 parallel.Run 打印警告
+	_ = d.each(context.Background(), "卸载", func(m *MongoDBInstance) error {
+		if err := m.UNInstall(); err != nil {
+			return err
 		}
-	}
+		d.journal.Undo(m.Host, installStep(m.Inst.Option.Port))
+		return nil
+	})
+}
 
+// members 当前副本集的所有实例, 依次为主节点, 从节点, 仲裁节点
+func (d *MongoDBClusterDeploy) members() []*MongoDBInstance {
+	members := append([]*MongoDBInstance{d.master}, d.slaves...)
 	if d.arbiter != nil {
-		if err := d.arbiter.UNInstall(); err != nil {
-			logger.Warningf("卸载节点: %s 失败: %v\n", d.arbiter.Host, err)
-		}
+		members = append(members, d.arbiter)
 	}
+	return members
+}
+
+// each 在当前副本集的所有实例上并发执行 fn
+func (d *MongoDBClusterDeploy) each(ctx context.Context, name string, fn func(m *MongoDBInstance) error) error {
+	var tasks []parallel.Task
+	for _, m := range d.members() {
+		m := m
+		tasks = append(tasks, parallel.Task{Host: m.Host, Run: func() error { return fn(m) }})
+	}
+	return parallel.Run(ctx, name, tasks)
+}
+
+// installStep 部署记录中安装实例的步骤名称
+func installStep(port int) string {
+	return fmt.Sprintf("install %d", port)
 }
 
 func (d *MongoDBClusterDeploy) CheckReplicaSetStatus() error {
-	conn, err := dao.NewMongoClient(d.master.Host, d.master.Inst.Option.Port, d.master.Inst.Option.Username, d.master.Inst.Option.Password, "admin")
+	dialHost, dialPort, closeTunnel, err := d.master.Conn.DialAddress(d.master.Inst.Option.Port)
+	if err != nil {
+		return err
+	}
+	defer closeTunnel()
+	conn, err := dao.NewMongoClient(dialHost, dialPort, d.master.Inst.Option.Username, d.master.Inst.Option.Password, "admin")
 	if err != nil {
 		return err
 	}
@@ -1082,7 +1267,12 @@ func (d *MongoDBClusterDeploy) CheckReplicaSetStatus() error {
 
 func (d *MongoDBClusterDeploy) Info(role string) error {
 
-	conn, err := dao.NewMongoClient(d.master.Host, d.master.Inst.Option.Port, d.master.Inst.Option.Username, d.master.Inst.Option.Password, "admin")
+	dialHost, dialPort, closeTunnel, err := d.master.Conn.DialAddress(d.master.Inst.Option.Port)
+	if err != nil {
+		return err
+	}
+	defer closeTunnel()
+	conn, err := dao.NewMongoClient(dialHost, dialPort, d.master.Inst.Option.Username, d.master.Inst.Option.Password, "admin")
 	if err != nil {
 		return err
 	}
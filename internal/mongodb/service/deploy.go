@@ -8,11 +8,14 @@ package service
 import (
 	"context"
 	"dbup/internal/environment"
+	"dbup/internal/inventory"
 	"dbup/internal/mongodb/config"
 	"dbup/internal/mongodb/dao"
+	"dbup/internal/plan"
+	"dbup/internal/preflight"
 	"dbup/internal/utils/logger"
+	"dbup/internal/utils/prompt"
 	"fmt"
-	"os"
 	"path"
 	"strings"
 	"time"
@@ -33,14 +36,10 @@ func NewMongoDBDeploy() *MongoDBDeploy {
 
 func (d *MongoDBDeploy) Run(c string, noRollback bool) error {
 	// 初始化参数和配置环节
-	if err := d.option.Load(c); err != nil {
+	if err := d.load(c); err != nil {
 		return err
 	}
-	d.option.Server.SetDefault()
 	d.option.NoRollback = noRollback
-	if err := d.option.Validator(); err != nil {
-		return err
-	}
 
 	logger.Infof("初始化部署对象\n")
 	if d.option.Server.Password != "" {
@@ -78,9 +77,40 @@ func (d *MongoDBDeploy) Run(c string, noRollback bool) error {
 		}
 		return err
 	}
+	if err := inventory.RecordCluster(d.cluster()); err != nil {
+		logger.Warningf("更新实例清单失败: %v\n", err)
+	}
 	return nil
 }
 
+// load 读取并验证部署配置
+func (d *MongoDBDeploy) load(c string) error {
+	if err := d.option.Load(c); err != nil {
+		return err
+	}
+	d.option.Server.SetDefault()
+	return d.option.Validator()
+}
+
+// Check 连接各节点执行部署前检查, arbiter 节点与数据节点使用相同的端口和目录, 不做任何修改
+func (d *MongoDBDeploy) Check(c string) error {
+	if err := d.load(c); err != nil {
+		return err
+	}
+	s := d.option.Server
+
+	logger.Infof("检查部署节点环境\n")
+	p := preflight.New(plan.SSHDialer(s.User, s.Password, s.KeyFile, s.SshPort, s.SSHOptions()))
+	hosts := strings.Split(s.Address, ",")
+	if s.Arbiter != "" {
+		hosts = append(hosts, strings.Split(s.Arbiter, ",")...)
+	}
+	for _, host := range hosts {
+		p.Add(host, config.Kinds, []int{d.option.MongoDB.Port}, d.option.MongoDB.Dir)
+	}
+	return p.Finish()
+}
+
 func (d *MongoDBDeploy) RemoveCluster(c string, yes bool) error {
 	// 初始化参数和配置环节
 	if err := d.option.Load(c); err != nil {
@@ -105,15 +135,9 @@ func (d *MongoDBDeploy) RemoveCluster(c string, yes bool) error {
 
 	if !yes {
 		logger.Warningf("删除集群是危险操作,会将整个集群中的数据完全删除, 不可恢复\n")
-		logger.Warningf("是否确认删除[y|n]:")
-
-		var yes string
-		if _, err := fmt.Scanln(&yes); err != nil {
+		if err := prompt.Confirm("是否确认删除"); err != nil {
 			return err
 		}
-		if strings.ToUpper(yes) != "Y" && strings.ToUpper(yes) != "YES" {
-			os.Exit(0)
-		}
 	}
 
 	logger.Infof("初始化删除对象\n")
@@ -137,9 +161,23 @@ func (d *MongoDBDeploy) RemoveCluster(c string, yes bool) error {
 
 	d.UNInstall()
 
+	if err := inventory.ForgetCluster(d.cluster().Name); err != nil {
+		logger.Warningf("更新实例清单失败: %v\n", err)
+	}
 	return nil
 }
 
+// cluster 集群成员, 用于记录到实例清单
+func (d *MongoDBDeploy) cluster() inventory.Cluster {
+	members := inventory.Members(d.option.Server.Address, d.option.MongoDB.Port, "", d.option.MongoDB.Dir)
+	members = append(members, inventory.Members(d.option.Server.Arbiter, d.option.MongoDB.Port, config.MongoDBArbiter, d.option.MongoDB.Dir)...)
+	c := inventory.Cluster{Engine: config.Kinds, Mode: "replica-set", Members: members}
+	if len(members) > 0 {
+		c.Name = inventory.ClusterName(config.Kinds, members[0].Host, members[0].Port)
+	}
+	return c
+}
+
 func (d *MongoDBDeploy) InstallAndInitSlave() error {
 	if err := d.Install(); err != nil {
 		return err
@@ -173,7 +211,8 @@ func (d *MongoDBDeploy) Init() error {
 		d.option.Server.User,
 		d.option.Server.Password,
 		d.option.Server.SshPort,
-		d.option.MongoDB); err != nil {
+		d.option.MongoDB,
+		d.option.Server.SSHOptions()); err != nil {
 		return err
 	}
 	for _, slave := range ips[1:] {
@@ -182,7 +221,8 @@ func (d *MongoDBDeploy) Init() error {
 			d.option.Server.User,
 			d.option.Server.Password,
 			d.option.Server.SshPort,
-			d.option.MongoDB)
+			d.option.MongoDB,
+			d.option.Server.SSHOptions())
 		if err != nil {
 			return err
 		}
@@ -197,7 +237,8 @@ func (d *MongoDBDeploy) Init() error {
 			d.option.Server.User,
 			d.option.Server.Password,
 			d.option.Server.SshPort,
-			d.option.MongoDB); err != nil {
+			d.option.MongoDB,
+			d.option.Server.SSHOptions()); err != nil {
 			return err
 		}
 		d.arbiter.Inst.Option.Memory = 1
@@ -214,7 +255,8 @@ func (d *MongoDBDeploy) InitUseKeyFile() error {
 		d.option.Server.User,
 		d.option.Server.KeyFile,
 		d.option.Server.SshPort,
-		d.option.MongoDB); err != nil {
+		d.option.MongoDB,
+		d.option.Server.SSHOptions()); err != nil {
 		return err
 	}
 	for _, slave := range ips[1:] {
@@ -223,7 +265,8 @@ func (d *MongoDBDeploy) InitUseKeyFile() error {
 			d.option.Server.User,
 			d.option.Server.KeyFile,
 			d.option.Server.SshPort,
-			d.option.MongoDB)
+			d.option.MongoDB,
+			d.option.Server.SSHOptions())
 		if err != nil {
 			return err
 		}
@@ -238,7 +281,8 @@ func (d *MongoDBDeploy) InitUseKeyFile() error {
 			d.option.Server.User,
 			d.option.Server.KeyFile,
 			d.option.Server.SshPort,
-			d.option.MongoDB); err != nil {
+			d.option.MongoDB,
+			d.option.Server.SSHOptions()); err != nil {
 			fmt.Println(ips[0])
 			fmt.Println("arbiter的主", ips[0])
 			return err
@@ -382,7 +426,12 @@ func (d *MongoDBDeploy) UNInstall() {
 }
 
 func (d *MongoDBDeploy) CheckReplicaSetStatus() error {
-	conn, err := dao.NewMongoClient(d.master.Host, d.master.Inst.Option.Port, d.master.Inst.Option.Username, d.master.Inst.Option.Password, "admin")
+	dialHost, dialPort, closeTunnel, err := d.master.Conn.DialAddress(d.master.Inst.Option.Port)
+	if err != nil {
+		return err
+	}
+	defer closeTunnel()
+	conn, err := dao.NewMongoClient(dialHost, dialPort, d.master.Inst.Option.Username, d.master.Inst.Option.Password, "admin")
 	if err != nil {
 		return err
 	}
@@ -427,7 +476,12 @@ func (d *MongoDBDeploy) Info() error {
 		hostPorts = hostPorts + fmt.Sprintf(",%s:%d", slave.Host, d.option.MongoDB.Port)
 	}
 
-	conn, err := dao.NewMongoClient(d.master.Host, d.master.Inst.Option.Port, d.master.Inst.Option.Username, d.master.Inst.Option.Password, "admin")
+	dialHost, dialPort, closeTunnel, err := d.master.Conn.DialAddress(d.master.Inst.Option.Port)
+	if err != nil {
+		return err
+	}
+	defer closeTunnel()
+	conn, err := dao.NewMongoClient(dialHost, dialPort, d.master.Inst.Option.Username, d.master.Inst.Option.Password, "admin")
 	if err != nil {
 		return err
 	}
@@ -9,12 +9,15 @@ import (
 	"context"
 	"dbup/internal/environment"
 	"dbup/internal/global"
+	"dbup/internal/inventory"
 	"dbup/internal/mongodb/config"
 	"dbup/internal/mongodb/dao"
+	"dbup/internal/tune"
 	"dbup/internal/utils"
 	"dbup/internal/utils/arrlib"
 	"dbup/internal/utils/command"
 	"dbup/internal/utils/logger"
+	"dbup/internal/utils/prompt"
 	"errors"
 	"fmt"
 	"os"
@@ -50,7 +53,7 @@ func NewMongoDBInstall(option *config.MongodbOptions) *MongoDBInstall {
 	return &MongoDBInstall{
 		Option:          option,
 		Role:            config.MongoDBPrimary,
-		PackageFullName: filepath.Join(environment.GlobalEnv().ProgramPath, global.PackagePath, config.Kinds, fmt.Sprintf(config.PackageFile, config.DefaultMongoDBVersion, environment.GlobalEnv().GOOS, environment.GlobalEnv().GOARCH)),
+		PackageFullName: filepath.Join(environment.GlobalEnv().ProgramPath, global.PackagePath, config.Kinds, config.PackageName(option.Version)),
 	}
 }
 
@@ -137,7 +140,6 @@ func (i *MongoDBInstall) Run() error {
 		return err
 	}
 	if !i.Option.Yes {
-		var yes string
 		if i.Option.Join != "" {
 			logger.Successf("\n")
 			logger.Successf("本次安装实例为(SECONDARY)从节点\n")
@@ -149,13 +151,9 @@ func (i *MongoDBInstall) Run() error {
 		logger.Successf("用户: %s\n", i.Option.Username)
 		logger.Successf("密码: %s\n", i.Option.Password)
 		logger.Successf("安装路径: %s\n", i.Option.Dir)
-		logger.Successf("是否确认安装[y|n]:")
-		if _, err := fmt.Scanln(&yes); err != nil {
+		if err := prompt.Confirm("是否确认安装"); err != nil {
 			return err
 		}
-		if strings.ToUpper(yes) != "Y" && strings.ToUpper(yes) != "YES" {
-			os.Exit(0)
-		}
 	}
 
 	if err := i.InstallAndInitDB(); err != nil {
@@ -227,7 +225,7 @@ func (i *MongoDBInstall) HandleArgs() error {
 		i.Config = config.NewMongoDBConfig(i.Option, i.ReplSetName)
 	}
 
-	if i.Service, err = config.NewMongoDBService(filepath.Join(environment.GlobalEnv().ProgramPath, global.ServiceTemplatePath, config.MongoDBServiceTemplateFile)); err != nil {
+	if i.Service, err = config.NewMongoDBService(filepath.Join(environment.GlobalEnv().ProgramPath, global.ServiceTemplatePath, config.ServiceTemplateFile(i.Option.Version))); err != nil {
 		return err
 	}
 	return i.Service.FormatBody(i.Option, i.SysUser, i.SysGroup)
@@ -392,6 +390,12 @@ func (i *MongoDBInstall) Install(service string) error {
 		}
 	}
 
+	if i.Option.Tune {
+		if err := tune.ApplyService("mongodb", service, ""); err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
 
@@ -622,6 +626,17 @@ func (i *MongoDBInstall) CheckReplicaSetStatus() bool {
 }
 
 func (i *MongoDBInstall) Info() {
+	if err := inventory.Record(inventory.Instance{
+		Engine:  config.Kinds,
+		Version: i.Option.Version,
+		Port:    i.Option.Port,
+		Dir:     i.Option.Dir,
+		DataDir: filepath.Join(i.Option.Dir, config.DefaultMongoDBDataDir),
+		Role:    i.Role,
+		Service: fmt.Sprintf(config.ServiceFileName, i.Option.Port),
+	}); err != nil {
+		logger.Warningf("更新实例清单失败: %v\n", err)
+	}
 	logger.Successf("\n")
 	logger.Successf("MongoDB初始化[完成]\n")
 	logger.Successf("MongoDB端 口:%d\n", i.Option.Port)
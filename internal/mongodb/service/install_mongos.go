@@ -3,16 +3,18 @@ package service
 import (
 	"dbup/internal/environment"
 	"dbup/internal/global"
+	"dbup/internal/inventory"
 	"dbup/internal/mongodb/config"
+	"dbup/internal/tune"
 	"dbup/internal/utils"
 	"dbup/internal/utils/arrlib"
 	"dbup/internal/utils/command"
 	"dbup/internal/utils/logger"
+	"dbup/internal/utils/prompt"
 	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
-	"strings"
 
 	"gopkg.in/ini.v1"
 )
@@ -31,7 +33,7 @@ type MongoSInstall struct {
 func NewMongoSInstall(option *config.MongosOptions) *MongoSInstall {
 	return &MongoSInstall{
 		Option:          option,
-		PackageFullName: filepath.Join(environment.GlobalEnv().ProgramPath, global.PackagePath, config.Kinds, fmt.Sprintf(config.PackageFile, config.DefaultMongoDBVersion, environment.GlobalEnv().GOOS, environment.GlobalEnv().GOARCH)),
+		PackageFullName: filepath.Join(environment.GlobalEnv().ProgramPath, global.PackagePath, config.Kinds, config.PackageName(option.Version)),
 	}
 }
 
@@ -118,16 +120,11 @@ func (i *MongoSInstall) Run() error {
 		return err
 	}
 	if !i.Option.Yes {
-		var yes string
 		logger.Successf("MongoS 端口: %d\n", i.Option.Port)
 		logger.Successf("MongoS 安装路径: %s\n", i.Option.Dir)
-		logger.Successf("是否确认安装[y|n]:")
-		if _, err := fmt.Scanln(&yes); err != nil {
+		if err := prompt.Confirm("是否确认安装"); err != nil {
 			return err
 		}
-		if strings.ToUpper(yes) != "Y" && strings.ToUpper(yes) != "YES" {
-			os.Exit(0)
-		}
 	}
 
 	if err := i.InstallAndInitDB(); err != nil {
@@ -168,7 +165,7 @@ func (i *MongoSInstall) HandleArgs() error {
 	}
 
 	i.Config = config.NewMongoSConfig(i.Option)
-	if i.Service, err = config.NewMongoDBService(filepath.Join(environment.GlobalEnv().ProgramPath, global.ServiceTemplatePath, config.MongoDBServiceTemplateFile)); err != nil {
+	if i.Service, err = config.NewMongoDBService(filepath.Join(environment.GlobalEnv().ProgramPath, global.ServiceTemplatePath, config.ServiceTemplateFile(i.Option.Version))); err != nil {
 		return err
 	}
 	return i.Service.FormatMongosBody(i.Option, i.SysUser, i.SysGroup)
@@ -270,11 +267,27 @@ func (i *MongoSInstall) Install(service string) error {
 		}
 	}
 
+	if i.Option.Tune {
+		if err := tune.ApplyService("mongodb", service, ""); err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
 
 func (i *MongoSInstall) Info() {
 	var ip string
+	if err := inventory.Record(inventory.Instance{
+		Engine:  config.Kinds,
+		Version: i.Option.Version,
+		Port:    i.Option.Port,
+		Dir:     i.Option.Dir,
+		Role:    "mongos",
+		Service: fmt.Sprintf(config.ServiceFileName, i.Option.Port),
+	}); err != nil {
+		logger.Warningf("更新实例清单失败: %v\n", err)
+	}
 
 	if i.Option.BindIP == "0.0.0.0" || i.Option.BindIP == "0.0.0.0,::" {
 		ip = "127.0.0.1"
@@ -7,24 +7,27 @@ package service
 
 import (
 	"dbup/internal/environment"
+	"dbup/internal/global"
 	"dbup/internal/mongodb/config"
 	"dbup/internal/utils/command"
+	"dbup/internal/utils/sshutil"
 	"fmt"
 	"path"
 	"path/filepath"
 )
 
 type MongoDBInstance struct {
-	DbupCmd string
-	Host    string
-	TmpDir  string
-	Inst    *MongoDBInstall
-	Conn    *command.Connection
+	DbupCmd  string
+	Host     string
+	TmpDir   string
+	Inst     *MongoDBInstall
+	Conn     *command.Connection
+	Platform *global.Platform // 目标机器的平台, 检查临时目录时探测
 	//spool
 }
 
-func NewMongoDBInstance(tmp, host, user, password string, port int, option config.MongodbOptions) (*MongoDBInstance, error) {
-	conn, err := command.NewConnection(host, user, password, port, 300)
+func NewMongoDBInstance(tmp, host, user, password string, port int, option config.MongodbOptions, opts ...sshutil.Options) (*MongoDBInstance, error) {
+	conn, err := command.NewConnection(host, user, password, port, 300, opts...)
 	if err != nil {
 		return nil, fmt.Errorf("在机器: %s 上, 建立ssh连接失败: %v", host, err)
 	}
@@ -34,8 +37,8 @@ func NewMongoDBInstance(tmp, host, user, password string, port int, option confi
 	return &MongoDBInstance{DbupCmd: cmd, Host: host, TmpDir: tmp, Inst: inst, Conn: conn}, nil
 }
 
-func NewMongoDBInstanceUseKeyFile(tmp, host, user, keyfile string, port int, option config.MongodbOptions) (*MongoDBInstance, error) {
-	conn, err := command.NewConnectionUseKeyFile(host, user, keyfile, port, 300)
+func NewMongoDBInstanceUseKeyFile(tmp, host, user, keyfile string, port int, option config.MongodbOptions, opts ...sshutil.Options) (*MongoDBInstance, error) {
+	conn, err := command.NewConnectionUseKeyFile(host, user, keyfile, port, 300, opts...)
 	if err != nil {
 		return nil, fmt.Errorf("在机器: %s 上, 建立ssh连接失败: %v", host, err)
 	}
@@ -46,6 +49,11 @@ func NewMongoDBInstanceUseKeyFile(tmp, host, user, keyfile string, port int, opt
 }
 
 func (i *MongoDBInstance) CheckTmpDir() error {
+	// 先确认所有目标机器都有对应平台的安装包, 再开始复制
+	if _, _, err := i.CheckPlatform(path.Join(environment.GlobalEnv().ProgramPath, "..")); err != nil {
+		return err
+	}
+
 	if i.Conn.IsExists(filepath.ToSlash(i.TmpDir)) {
 		if !i.Conn.IsDir(filepath.ToSlash(i.TmpDir)) {
 			return fmt.Errorf("在机器: %s 上, 目标文件(%s)已经存在", i.Host, i.TmpDir)
@@ -69,6 +77,11 @@ func (i *MongoDBInstance) CheckHosts(hostname []string) error {
 }
 
 func (i *MongoDBInstance) Scp(source string) error {
+	source, mongodbPackage, err := i.CheckPlatform(source)
+	if err != nil {
+		return err
+	}
+
 	if err := i.Conn.MkdirAll(filepath.ToSlash(path.Join(i.TmpDir, "bin"))); err != nil {
 		return fmt.Errorf("在机器: %s 上, 创建目录(%s)失败: %v", i.Host, filepath.ToSlash(path.Join(i.TmpDir, "bin")), err)
 	}
@@ -87,16 +100,28 @@ func (i *MongoDBInstance) Scp(source string) error {
 	if err := i.Conn.Scp(path.Join(source, "package", "md5"), filepath.ToSlash(path.Join(i.TmpDir, "package", "md5"))); err != nil {
 		return fmt.Errorf("在机器: %s 上, scp文件(%s)失败: %v", i.Host, filepath.ToSlash(path.Join(i.TmpDir, "package", "md5")), err)
 	}
+	for _, f := range global.ManifestFiles(path.Join(source, "package")) {
+		if err := i.Conn.Scp(path.Join(source, "package", f), filepath.ToSlash(path.Join(i.TmpDir, "package", f))); err != nil {
+			return fmt.Errorf("在机器: %s 上, scp文件(%s)失败: %v", i.Host, filepath.ToSlash(path.Join(i.TmpDir, "package", f)), err)
+		}
+	}
 
-	if err := i.Conn.Scp(path.Join(source, "systemd", config.MongoDBServiceTemplateFile), filepath.ToSlash(path.Join(i.TmpDir, "systemd", config.MongoDBServiceTemplateFile))); err != nil {
-		return fmt.Errorf("在机器: %s 上, scp文件(%s)失败: %v", i.Host, filepath.ToSlash(path.Join(i.TmpDir, "systemd", config.MongoDBServiceTemplateFile)), err)
+	template := config.ServiceTemplateFile(i.Inst.Option.Version)
+	if err := i.Conn.Scp(path.Join(source, "systemd", template), filepath.ToSlash(path.Join(i.TmpDir, "systemd", template))); err != nil {
+		return fmt.Errorf("在机器: %s 上, scp文件(%s)失败: %v", i.Host, filepath.ToSlash(path.Join(i.TmpDir, "systemd", template)), err)
 	}
 
-	// TODO: 根据目标机器的操作系统类型选择包
-	mongodbPackage := fmt.Sprintf("mongodb%s-%s-%s.tar.gz", config.DefaultMongoDBVersion, environment.GlobalEnv().GOOS, environment.GlobalEnv().GOARCH)
 	if err := i.Conn.Scp(path.Join(source, "package", "mongodb", mongodbPackage), filepath.ToSlash(path.Join(i.TmpDir, "package", "mongodb", mongodbPackage))); err != nil {
 		return fmt.Errorf("在机器: %s 上, scp文件(%s)失败: %v", i.Host, filepath.ToSlash(path.Join(i.TmpDir, "package", "mongodb", mongodbPackage)), err)
 	}
+	// 复制后在远程机器上校验安装包, 远程安装时还会再按清单校验一次
+	run := func(cmd string) (string, error) {
+		out, err := i.Conn.Run(cmd)
+		return string(out), err
+	}
+	if err := global.VerifyRemotePackage(run, source, config.Kinds, filepath.ToSlash(path.Join(i.TmpDir, "package", "mongodb", mongodbPackage))); err != nil {
+		return fmt.Errorf("在机器: %s 上, %v", i.Host, err)
+	}
 
 	if err := i.Conn.Chmod(filepath.ToSlash(path.Join(i.TmpDir, "bin", i.DbupCmd)), 0755); err != nil {
 		return fmt.Errorf("在机器: %s 上, chmod目录(%s)权限失败: %v", i.Host, filepath.ToSlash(path.Join(i.TmpDir, "bin", i.DbupCmd)), err)
@@ -104,6 +129,13 @@ func (i *MongoDBInstance) Scp(source string) error {
 	return nil
 }
 
+// CheckPlatform 探测目标机器的操作系统和架构, 返回对应平台的安装目录和安装包文件名
+func (i *MongoDBInstance) CheckPlatform(source string) (string, string, error) {
+	return global.CheckPlatform(i.Host, &i.Platform, i.Conn.Run, source, config.Kinds, func(p *global.Platform) string {
+		return config.PlatformPackageName(i.Inst.Option.Version, p)
+	})
+}
+
 func (i *MongoDBInstance) DropTmpDir() error {
 	cmd := fmt.Sprintf("cd %s; rm -rf *", filepath.ToSlash(i.TmpDir))
 	if stdout, err := i.Conn.Run(cmd); err != nil {
@@ -113,11 +145,11 @@ func (i *MongoDBInstance) DropTmpDir() error {
 }
 
 func (i *MongoDBInstance) Install(onlyCheck bool, arbiter bool, noRollback bool, ipv6 bool) error {
-	cmd := fmt.Sprintf("%s mongodb install --yes --port=%d --username='%s' --password='%s' --replSetName='%s' --memory=%d --dir='%s' --bind-ip='%s' --owner='%s' --join='%s' --system-user='%s' --system-group='%s' --resource-limit='%s' --log='%s'",
+	cmd := fmt.Sprintf("%s mongodb install --yes --version='%s' --port=%d --username='%s' --replSetName='%s' --memory=%d --dir='%s' --bind-ip='%s' --owner='%s' --join='%s' --system-user='%s' --system-group='%s' --resource-limit='%s' --log='%s'",
 		i.DbupCmd,
+		i.Inst.Option.Version,
 		i.Inst.Option.Port,
 		i.Inst.Option.Username,
-		i.Inst.Option.Password,
 		i.Inst.Option.ReplSetName,
 		i.Inst.Option.Memory,
 		i.Inst.Option.Dir,
@@ -144,8 +176,12 @@ func (i *MongoDBInstance) Install(onlyCheck bool, arbiter bool, noRollback bool,
 	if ipv6 {
 		cmd = cmd + " --ipv6"
 	}
+
+	if i.Inst.Option.Tune {
+		cmd = cmd + " --tune"
+	}
 	cmd = path.Join(i.TmpDir, "bin", cmd)
-	if stdout, err := i.Conn.Sudo(cmd, "", ""); err != nil {
+	if stdout, err := i.Conn.SudoWithSecrets(cmd, map[string]string{"password": i.Inst.Option.Password}); err != nil {
 		return fmt.Errorf("在机器: %s 上, 执行(%s)失败: %v, 标准输出: %s", i.Host, cmd, err, stdout)
 	}
 	return nil
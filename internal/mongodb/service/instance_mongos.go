@@ -2,24 +2,27 @@ package service
 
 import (
 	"dbup/internal/environment"
+	"dbup/internal/global"
 	"dbup/internal/mongodb/config"
 	"dbup/internal/utils/command"
+	"dbup/internal/utils/sshutil"
 	"fmt"
 	"path"
 	"path/filepath"
 )
 
 type MongoSInstance struct {
-	DbupCmd string
-	Host    string
-	TmpDir  string
-	Inst    *MongoSInstall
-	Conn    *command.Connection
+	DbupCmd  string
+	Host     string
+	TmpDir   string
+	Inst     *MongoSInstall
+	Conn     *command.Connection
+	Platform *global.Platform // 目标机器的平台, 检查临时目录时探测
 	//spool
 }
 
-func NewMongoSInstance(tmp, host, user, password string, port int, option config.MongosOptions) (*MongoSInstance, error) {
-	conn, err := command.NewConnection(host, user, password, port, 30)
+func NewMongoSInstance(tmp, host, user, password string, port int, option config.MongosOptions, opts ...sshutil.Options) (*MongoSInstance, error) {
+	conn, err := command.NewConnection(host, user, password, port, 30, opts...)
 	if err != nil {
 		return nil, fmt.Errorf("在机器: %s 上, 建立ssh连接失败: %v", host, err)
 	}
@@ -29,8 +32,8 @@ func NewMongoSInstance(tmp, host, user, password string, port int, option config
 	return &MongoSInstance{DbupCmd: cmd, Host: host, TmpDir: tmp, Inst: inst, Conn: conn}, nil
 }
 
-func NewMongoSInstanceUseKeyFile(tmp, host, user, keyfile string, port int, option config.MongosOptions) (*MongoSInstance, error) {
-	conn, err := command.NewConnectionUseKeyFile(host, user, keyfile, port, 30)
+func NewMongoSInstanceUseKeyFile(tmp, host, user, keyfile string, port int, option config.MongosOptions, opts ...sshutil.Options) (*MongoSInstance, error) {
+	conn, err := command.NewConnectionUseKeyFile(host, user, keyfile, port, 30, opts...)
 	if err != nil {
 		return nil, fmt.Errorf("在机器: %s 上, 建立ssh连接失败: %v", host, err)
 	}
@@ -41,6 +44,11 @@ func NewMongoSInstanceUseKeyFile(tmp, host, user, keyfile string, port int, opti
 }
 
 func (i *MongoSInstance) CheckTmpDir() error {
+	// 先确认所有目标机器都有对应平台的安装包, 再开始复制
+	if _, _, err := i.CheckPlatform(path.Join(environment.GlobalEnv().ProgramPath, "..")); err != nil {
+		return err
+	}
+
 	if i.Conn.IsExists(filepath.ToSlash(i.TmpDir)) {
 		if !i.Conn.IsDir(filepath.ToSlash(i.TmpDir)) {
 			return fmt.Errorf("在机器: %s 上, 目标文件(%s)已经存在", i.Host, i.TmpDir)
@@ -57,6 +65,11 @@ func (i *MongoSInstance) CheckTmpDir() error {
 }
 
 func (i *MongoSInstance) Scp(source string) error {
+	source, mongodbPackage, err := i.CheckPlatform(source)
+	if err != nil {
+		return err
+	}
+
 	if err := i.Conn.MkdirAll(filepath.ToSlash(path.Join(i.TmpDir, "bin"))); err != nil {
 		return fmt.Errorf("在机器: %s 上, 创建目录(%s)失败: %v", i.Host, filepath.ToSlash(path.Join(i.TmpDir, "bin")), err)
 	}
@@ -75,16 +88,28 @@ func (i *MongoSInstance) Scp(source string) error {
 	if err := i.Conn.Scp(path.Join(source, "package", "md5"), filepath.ToSlash(path.Join(i.TmpDir, "package", "md5"))); err != nil {
 		return fmt.Errorf("在机器: %s 上, scp文件(%s)失败: %v", i.Host, filepath.ToSlash(path.Join(i.TmpDir, "package", "md5")), err)
 	}
+	for _, f := range global.ManifestFiles(path.Join(source, "package")) {
+		if err := i.Conn.Scp(path.Join(source, "package", f), filepath.ToSlash(path.Join(i.TmpDir, "package", f))); err != nil {
+			return fmt.Errorf("在机器: %s 上, scp文件(%s)失败: %v", i.Host, filepath.ToSlash(path.Join(i.TmpDir, "package", f)), err)
+		}
+	}
 
-	if err := i.Conn.Scp(path.Join(source, "systemd", config.MongoDBServiceTemplateFile), filepath.ToSlash(path.Join(i.TmpDir, "systemd", config.MongoDBServiceTemplateFile))); err != nil {
-		return fmt.Errorf("在机器: %s 上, scp文件(%s)失败: %v", i.Host, filepath.ToSlash(path.Join(i.TmpDir, "systemd", config.MongoDBServiceTemplateFile)), err)
+	template := config.ServiceTemplateFile(i.Inst.Option.Version)
+	if err := i.Conn.Scp(path.Join(source, "systemd", template), filepath.ToSlash(path.Join(i.TmpDir, "systemd", template))); err != nil {
+		return fmt.Errorf("在机器: %s 上, scp文件(%s)失败: %v", i.Host, filepath.ToSlash(path.Join(i.TmpDir, "systemd", template)), err)
 	}
 
-	// TODO: 根据目标机器的操作系统类型选择包
-	mongodbPackage := fmt.Sprintf("mongodb%s-%s-%s.tar.gz", config.DefaultMongoDBVersion, environment.GlobalEnv().GOOS, environment.GlobalEnv().GOARCH)
 	if err := i.Conn.Scp(path.Join(source, "package", "mongodb", mongodbPackage), filepath.ToSlash(path.Join(i.TmpDir, "package", "mongodb", mongodbPackage))); err != nil {
 		return fmt.Errorf("在机器: %s 上, scp文件(%s)失败: %v", i.Host, filepath.ToSlash(path.Join(i.TmpDir, "package", "mongodb", mongodbPackage)), err)
 	}
+	// 复制后在远程机器上校验安装包, 远程安装时还会再按清单校验一次
+	run := func(cmd string) (string, error) {
+		out, err := i.Conn.Run(cmd)
+		return string(out), err
+	}
+	if err := global.VerifyRemotePackage(run, source, config.Kinds, filepath.ToSlash(path.Join(i.TmpDir, "package", "mongodb", mongodbPackage))); err != nil {
+		return fmt.Errorf("在机器: %s 上, %v", i.Host, err)
+	}
 
 	if err := i.Conn.Chmod(filepath.ToSlash(path.Join(i.TmpDir, "bin", i.DbupCmd)), 0755); err != nil {
 		return fmt.Errorf("在机器: %s 上, chmod目录(%s)权限失败: %v", i.Host, filepath.ToSlash(path.Join(i.TmpDir, "bin", i.DbupCmd)), err)
@@ -92,6 +117,13 @@ func (i *MongoSInstance) Scp(source string) error {
 	return nil
 }
 
+// CheckPlatform 探测目标机器的操作系统和架构, 返回对应平台的安装目录和安装包文件名
+func (i *MongoSInstance) CheckPlatform(source string) (string, string, error) {
+	return global.CheckPlatform(i.Host, &i.Platform, i.Conn.Run, source, config.Kinds, func(p *global.Platform) string {
+		return config.PlatformPackageName(i.Inst.Option.Version, p)
+	})
+}
+
 func (i *MongoSInstance) DropTmpDir() error {
 	cmd := fmt.Sprintf("cd %s; rm -rf *", filepath.ToSlash(i.TmpDir))
 	if stdout, err := i.Conn.Run(cmd); err != nil {
@@ -101,12 +133,12 @@ func (i *MongoSInstance) DropTmpDir() error {
 }
 
 func (i *MongoSInstance) Install(onlyCheck bool, ipv6 bool) error {
-	cmd := fmt.Sprintf("%s mongodb msinstall --yes --ConfigDB='%s' --port=%d --username='%s' --password='%s' --dir='%s' --bind-ip='%s' --owner='%s'  --system-user='%s' --system-group='%s' --resource-limit='%s' --log='%s'",
+	cmd := fmt.Sprintf("%s mongodb msinstall --yes --version='%s' --ConfigDB='%s' --port=%d --username='%s' --dir='%s' --bind-ip='%s' --owner='%s'  --system-user='%s' --system-group='%s' --resource-limit='%s' --log='%s'",
 		i.DbupCmd,
+		i.Inst.Option.Version,
 		i.Inst.Option.ConfigDB,
 		i.Inst.Option.Port,
 		i.Inst.Option.Username,
-		i.Inst.Option.Password,
 		i.Inst.Option.Dir,
 		i.Inst.Option.BindIP,
 		i.Host,
@@ -123,8 +155,12 @@ func (i *MongoSInstance) Install(onlyCheck bool, ipv6 bool) error {
 		cmd = cmd + " --ipv6"
 	}
 
+	if i.Inst.Option.Tune {
+		cmd = cmd + " --tune"
+	}
+
 	cmd = path.Join(i.TmpDir, "bin", cmd)
-	if stdout, err := i.Conn.Sudo(cmd, "", ""); err != nil {
+	if stdout, err := i.Conn.SudoWithSecrets(cmd, map[string]string{"password": i.Inst.Option.Password}); err != nil {
 		return fmt.Errorf("在机器: %s 上, 执行(%s)失败: %v, 标准输出: %s", i.Host, cmd, err, stdout)
 	}
 	return nil
@@ -42,7 +42,8 @@ func (d *MongoDBManager) AddSlaveNode(ssho global.SSHConfig, o config.MongodbOpt
 			ssho.Username,
 			ssho.Password,
 			ssho.Port,
-			o)
+			o,
+			ssho.SSHOptions())
 		if err != nil {
 			return err
 		}
@@ -52,7 +53,8 @@ func (d *MongoDBManager) AddSlaveNode(ssho global.SSHConfig, o config.MongodbOpt
 			ssho.Username,
 			ssho.KeyFile,
 			ssho.Port,
-			o)
+			o,
+			ssho.SSHOptions())
 		if err != nil {
 			return err
 		}
@@ -7,6 +7,7 @@ package service
 
 import (
 	"dbup/internal/global"
+	"dbup/internal/inventory"
 	"dbup/internal/mongodb/config"
 	"dbup/internal/utils"
 	"dbup/internal/utils/command"
@@ -56,6 +57,9 @@ func (i *MongoDBUNInstall) Uninstall() error {
 			logger.Warningf("删除安装目录成功\n")
 		}
 	}
+	if err := inventory.Forget(config.Kinds, i.Port); err != nil {
+		logger.Warningf("更新实例清单失败: %v\n", err)
+	}
 	return nil
 }
 
@@ -89,5 +93,8 @@ func (i *MongoSUNInstall) MSuninstall() error {
 			logger.Warningf("删除安装目录成功\n")
 		}
 	}
+	if err := inventory.Forget(config.Kinds, i.Port); err != nil {
+		logger.Warningf("更新实例清单失败: %v\n", err)
+	}
 	return nil
 }
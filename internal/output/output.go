@@ -0,0 +1,181 @@
+package output
+
+// 命令的输出格式. 默认 text: 彩色的中文提示信息打印到标准输出;
+// json: 提示信息改为打印到标准错误输出, 命令结束后在标准输出打印一个结构化的结果文档, 供自动化工具解析
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+)
+
+// 输出格式
+const (
+	Text = "text"
+	JSON = "json"
+)
+
+// 结果状态
+const (
+	StatusSuccess = "success"
+	StatusFailed  = "failed"
+)
+
+// 错误码
+const (
+	CodeFailed          = "FAILED"
+	CodeInvalidArgument = "INVALID_ARGUMENT"
+	CodeNotFound        = "NOT_FOUND"
+	CodeSSH             = "SSH_ERROR"
+	CodeCredential      = "CREDENTIAL_ERROR"
+	CodeConflict        = "CONFLICT"
+	CodeCanceled        = "CANCELED"
+)
+
+// Error 带错误码的错误
+type Error struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+// Errorf 生成带错误码的错误
+func Errorf(code, format string, args ...interface{}) error {
+	return &Error{Code: code, Message: fmt.Sprintf(format, args...)}
+}
+
+// Code 返回错误码, 没有错误码的错误为 CodeFailed, ctx 取消或超时为 CodeCanceled
+func Code(err error) string {
+	var e *Error
+	if errors.As(err, &e) {
+		return e.Code
+	}
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return CodeCanceled
+	}
+	return CodeFailed
+}
+
+// Resource 命令创建或删除的资源
+type Resource struct {
+	Kind    string `json:"kind"` // instance, cluster, file, backup-task
+	Name    string `json:"name,omitempty"`
+	Engine  string `json:"engine,omitempty"`
+	Host    string `json:"host,omitempty"`
+	Port    int    `json:"port,omitempty"`
+	Path    string `json:"path,omitempty"`
+	Service string `json:"service,omitempty"`
+}
+
+// Result 结果文档
+type Result struct {
+	Command         string                 `json:"command"`
+	Status          string                 `json:"status"`
+	Created         []Resource             `json:"created,omitempty"`
+	Removed         []Resource             `json:"removed,omitempty"`
+	CredentialsFile string                 `json:"credentials_file,omitempty"`
+	Data            map[string]interface{} `json:"data,omitempty"`
+	Errors          []*Error               `json:"errors,omitempty"`
+}
+
+var (
+	format = Text
+	mu     sync.Mutex
+	result = &Result{}
+)
+
+// SetFormat 设置输出格式, 对应命令行参数 --output
+func SetFormat(f string) error {
+	switch f {
+	case Text, JSON:
+		format = f
+		return nil
+	}
+	return Errorf(CodeInvalidArgument, "不支持的输出格式: %s, 可选: %s, %s", f, Text, JSON)
+}
+
+// IsJSON 是否输出 json 结果文档
+func IsJSON() bool {
+	return format == JSON
+}
+
+// Created 记录创建的资源
+func Created(r Resource) {
+	mu.Lock()
+	defer mu.Unlock()
+	result.Created = append(result.Created, r)
+}
+
+// Removed 记录删除的资源
+func Removed(r Resource) {
+	mu.Lock()
+	defer mu.Unlock()
+	result.Removed = append(result.Removed, r)
+}
+
+// SetCredentialsFile 记录保存密码的凭据库文件
+func SetCredentialsFile(file string) {
+	mu.Lock()
+	defer mu.Unlock()
+	result.CredentialsFile = file
+}
+
+// Set 设置结果数据
+func Set(key string, value interface{}) {
+	mu.Lock()
+	defer mu.Unlock()
+	if result.Data == nil {
+		result.Data = make(map[string]interface{})
+	}
+	result.Data[key] = value
+}
+
+// Append 向结果数据中的列表追加一项
+func Append(key string, value interface{}) {
+	mu.Lock()
+	defer mu.Unlock()
+	if result.Data == nil {
+		result.Data = make(map[string]interface{})
+	}
+	list, _ := result.Data[key].([]interface{})
+	result.Data[key] = append(list, value)
+}
+
+// Item 输出列表中的一项: json 格式时追加到结果数据中, 否则按 text 格式打印到标准输出
+func Item(key string, value interface{}, text string, args ...interface{}) {
+	if IsJSON() {
+		Append(key, value)
+		return
+	}
+	fmt.Printf(text, args...)
+}
+
+// Write 将结果文档写入 w, err 不为空时状态为失败
+func Write(w io.Writer, command string, err error) error {
+	mu.Lock()
+	defer mu.Unlock()
+	result.Command = command
+	result.Status = StatusSuccess
+	if err != nil {
+		result.Status = StatusFailed
+		result.Errors = append(result.Errors, &Error{Code: Code(err), Message: err.Error()})
+	}
+	b, e := json.MarshalIndent(result, "", "  ")
+	if e != nil {
+		return e
+	}
+	_, e = fmt.Fprintln(w, string(b))
+	return e
+}
+
+// Print 将结果文档打印到标准输出
+func Print(command string, err error) error {
+	return Write(os.Stdout, command, err)
+}
@@ -0,0 +1,27 @@
+package output
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+)
+
+func TestWrite(t *testing.T) {
+	Created(Resource{Kind: "instance", Name: "pgsql5432", Engine: "pgsql", Port: 5432})
+	Set("version", "1.0")
+
+	var buf bytes.Buffer
+	if err := Write(&buf, "dbup pgsql install", Errorf(CodeNotFound, "没有 %s", "pgsql5432")); err != nil {
+		t.Fatal(err)
+	}
+	var r Result
+	if err := json.Unmarshal(buf.Bytes(), &r); err != nil {
+		t.Fatalf("结果文档不是合法的 json: %v", err)
+	}
+	if r.Status != StatusFailed || len(r.Errors) != 1 || r.Errors[0].Code != CodeNotFound {
+		t.Fatalf("错误信息不正确: %+v", r)
+	}
+	if len(r.Created) != 1 || r.Created[0].Name != "pgsql5432" || r.Data["version"] != "1.0" {
+		t.Fatalf("结果数据不正确: %+v", r)
+	}
+}
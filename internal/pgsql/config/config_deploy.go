@@ -7,8 +7,10 @@ package config
 
 import (
 	"dbup/internal/environment"
+	"dbup/internal/global"
 	"dbup/internal/utils"
 	"dbup/internal/utils/logger"
+	"dbup/internal/utils/sshutil"
 	"fmt"
 	"path/filepath"
 	"strings"
@@ -24,6 +26,26 @@ type Server struct {
 	Password string `ini:"ssh-password"`
 	KeyFile  string `ini:"ssh-keyfile"`
 	TmpDir   string `ini:"tmp-dir"`
+	// 主机密钥校验策略: strict(默认), tofu, insecure
+	HostKeyCheck string `ini:"ssh-host-key-check"`
+	KnownHosts   string `ini:"ssh-known-hosts"`
+	// 跳板机, 多个以逗号分隔: [user[:password]@]host[:port][?keyfile=/path/to/key]
+	ProxyJump string `ini:"ssh-proxy-jump"`
+	// 认证方式及尝试顺序, 逗号分隔: agent, publickey, password
+	Auth           string `ini:"ssh-auth"`
+	PassphraseFile string `ini:"ssh-passphrase-file"`
+}
+
+func (s *Server) SSHOptions() sshutil.Options {
+	return sshutil.Options{
+		HostKeyCheck:   s.HostKeyCheck,
+		KnownHosts:     s.KnownHosts,
+		ProxyJump:      s.ProxyJump,
+		Auth:           s.Auth,
+		Password:       s.Password,
+		KeyFile:        s.KeyFile,
+		PassphraseFile: s.PassphraseFile,
+	}
 }
 
 func (s *Server) SetDefault() {
@@ -45,8 +67,24 @@ func (s *Server) Validator() error {
 		}
 	}
 
+	// 端口
+	if s.SshPort < 1 || s.SshPort > 65535 {
+		return fmt.Errorf("端口号(%d), 不是一个正确的端口号. 端口号必须在 1025 ~ 65535 之间", s.SshPort)
+	}
+
+	// mshost := append(slaves, s.Master)
+	// // 如果为IPV6环境，则验证
+	// if err := utils.Ipv6Check(mshost); err != nil {
+	// 	return err
+	// }
+
+	return nil
+}
+
+// ValidatorSlaves 验证 slaves 中列出的从库, 用于只支持 slaves 配置的高可用部署
+func (s *Server) ValidatorSlaves() error {
 	slaves := strings.Split(s.Slaves, ",")
-	if len(slaves) == 0 {
+	if s.Slaves == "" {
 		return fmt.Errorf("从库不能为空")
 	}
 	if len(slaves) > 2 {
@@ -59,18 +97,6 @@ func (s *Server) Validator() error {
 			}
 		}
 	}
-
-	// 端口
-	if s.SshPort < 1 || s.SshPort > 65535 {
-		return fmt.Errorf("端口号(%d), 不是一个正确的端口号. 端口号必须在 1025 ~ 65535 之间", s.SshPort)
-	}
-
-	// mshost := append(slaves, s.Master)
-	// // 如果为IPV6环境，则验证
-	// if err := utils.Ipv6Check(mshost); err != nil {
-	// 	return err
-	// }
-
 	return nil
 }
 
@@ -202,20 +228,23 @@ type Parameter struct {
 	Pgsql      Prepare `ini:"pgsql"`
 	Yes        bool    `ini:"yes"`
 	NoRollback bool    `ini:"no-rollback"`
+	// 从节点, 来自 [server] 的 slaves 和每个 [slave.<名称>] section, 按复制顺序排列
+	Replicas []*global.Replica `ini:"-"`
 }
 
-// Load 从配置文件加载配置到Prepare实例
+// Load 从配置文件加载配置到Prepare实例, 支持 ini 和 YAML 格式
 func (p *Parameter) Load(filename string) error {
-	cfg, err := ini.LoadSources(ini.LoadOptions{
-		SpaceBeforeInlineComment: true,
-	}, filename)
+	cfg, err := global.LoadDeployFile(filename)
 	if err != nil {
-		return fmt.Errorf("加载配置文件失败: %v", err)
+		return err
 	}
 
 	if err = cfg.MapTo(p); err != nil {
 		return fmt.Errorf("配置文件映射到结构体失败: %v", err)
 	}
+	if p.Replicas, err = global.ParseReplicas(cfg, p.Server.Slaves); err != nil {
+		return fmt.Errorf("读取从节点配置失败: %v", err)
+	}
 	return nil
 }
 
@@ -225,6 +254,9 @@ func (p *Parameter) SlaveTo(filename string) error {
 	if err := ini.ReflectFrom(cfg, p); err != nil {
 		return fmt.Errorf("部署配置映射到(%s)文件错误: %v", filename, err)
 	}
+	if err := global.SaveReplicas(cfg, p.Server.Slaves, p.Replicas); err != nil {
+		return fmt.Errorf("从节点写入(%s)文件错误: %v", filename, err)
+	}
 	if err := cfg.SaveTo(filename); err != nil {
 		return fmt.Errorf("部署配置保存到(%s)文件错误: %v", filename, err)
 	}
@@ -239,5 +271,42 @@ func (p *Parameter) Validator() error {
 	if err := p.Pgsql.Validator(); err != nil {
 		return err
 	}
+	version, err := CheckVersion(p.Pgsql.Version)
+	if err != nil {
+		return err
+	}
+	p.Pgsql.Version = version
+	return p.ResolveReplicas()
+}
+
+// ResolveReplicas 补全并验证从节点, 没有指定端口和目录的从节点使用 [pgsql] 中的值
+func (p *Parameter) ResolveReplicas() error {
+	replicas, err := global.ResolveReplicas(p.Server.Master, p.Replicas, p.Pgsql.Port, p.Pgsql.Dir)
+	if err != nil {
+		return err
+	}
+	p.Replicas = replicas
 	return nil
 }
+
+// ReplicaPrepare 从节点的安装参数: 在 [pgsql] 的基础上使用从节点的端口和目录
+func (p *Parameter) ReplicaPrepare(r *global.Replica) Prepare {
+	pre := p.Pgsql
+	pre.Port = r.Port
+	pre.Dir = r.Dir
+	return pre
+}
+
+// SynchronousStandbyNames 主库的 synchronous_standby_names: 同步从节点按优先级排列, 同一时刻一个同步从节点, 没有同步从节点时为空
+func (p *Parameter) SynchronousStandbyNames() string {
+	var names []string
+	for _, r := range global.Downstream(p.Replicas, "") {
+		if r.Sync {
+			names = append(names, fmt.Sprintf("\"%s\"", r.Name))
+		}
+	}
+	if len(names) == 0 {
+		return ""
+	}
+	return fmt.Sprintf("FIRST 1 (%s)", strings.Join(names, ", "))
+}
@@ -77,6 +77,7 @@ type PGAutoFailoverPGNode struct {
 	Address               string `ini:"address" comment:"IP白名单，列入白名单的IP地址能够连接该数据库，无特殊要求请勿修改"`
 	MemorySize            string `ini:"memory-size" comment:"内存配置，建议内存配置不超过系统物理内存总量的50%,单位后缀可以为{MB,GB}"`
 	ResourceLimit         string `ini:"resource-limit"`
+	Tune                  bool   `ini:"tune" comment:"是否按 pgsql 的内置配置调优操作系统(sysctl, 透明大页, limits.d, LimitNOFILE), 可以用 dbup tune revert 恢复"`
 	Libraries             string `ini:"libraries"`
 	AllNode               string `ini:"allnode"`
 	Yes                   bool   `ini:"yes" comment:"监听IP，如果没有特殊要求请勿修改"`
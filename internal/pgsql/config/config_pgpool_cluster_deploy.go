@@ -9,6 +9,7 @@ import (
 	"dbup/internal/environment"
 	"dbup/internal/utils"
 	"dbup/internal/utils/logger"
+	"dbup/internal/utils/sshutil"
 	"fmt"
 	"path/filepath"
 	"strings"
@@ -25,6 +26,26 @@ type PGPoolClusterServer struct {
 	SshPassword string `ini:"ssh-password"`
 	SshKeyFile  string `ini:"ssh-keyfile"`
 	TmpDir      string `ini:"tmp-dir"`
+	// 主机密钥校验策略: strict(默认), tofu, insecure
+	SshHostKeyCheck string `ini:"ssh-host-key-check"`
+	SshKnownHosts   string `ini:"ssh-known-hosts"`
+	// 跳板机, 多个以逗号分隔: [user[:password]@]host[:port][?keyfile=/path/to/key]
+	SshProxyJump string `ini:"ssh-proxy-jump"`
+	// 认证方式及尝试顺序, 逗号分隔: agent, publickey, password
+	SshAuth           string `ini:"ssh-auth"`
+	SshPassphraseFile string `ini:"ssh-passphrase-file"`
+}
+
+func (s *PGPoolClusterServer) SSHOptions() sshutil.Options {
+	return sshutil.Options{
+		HostKeyCheck:   s.SshHostKeyCheck,
+		KnownHosts:     s.SshKnownHosts,
+		ProxyJump:      s.SshProxyJump,
+		Auth:           s.SshAuth,
+		Password:       s.SshPassword,
+		KeyFile:        s.SshKeyFile,
+		PassphraseFile: s.SshPassphraseFile,
+	}
 }
 
 func (s *PGPoolClusterServer) SetDefault() {
@@ -44,26 +44,29 @@ type PgsqlConfig struct {
 	WalLogHints                string `ini:"wal_log_hints"`
 	MaxWalSize                 string `ini:"max_wal_size"`
 	MinWalSize                 string `ini:"min_wal_size"`
-	// WalKeepSize                string `ini:"wal_keep_size"`
-	WalKeepSegments         string `ini:"wal_keep_segments"`
-	LoggingCollector        string `ini:"logging_collector"`
-	LogDestination          string `ini:"log_destination"`
-	LogDirectory            string `ini:"log_directory"`
-	LogFilename             string `ini:"log_filename"`
-	LogRotationAge          string `ini:"log_rotation_age"`
-	LogDuration             string `ini:"log_duration"`
-	LogTruncateOnRotation   string `ini:"log_truncate_on_rotation"`
-	LogMinDurationStatement string `ini:"log_min_duration_statement"`
-	LogCheckpoints          string `ini:"log_checkpoints"`
-	LogConnections          string `ini:"log_connections"`
-	LogDisconnections       string `ini:"log_disconnections"`
-	LogLockWaits            string `ini:"log_lock_waits"`
-	LogStatement            string `ini:"log_statement"`
-	LogLinePrefix           string `ini:"log_line_prefix"`
-	LogTimezone             string `ini:"log_timezone"`
-	LogMinMessages          string `ini:"log_min_messages"`
-	LogMinErrorStatement    string `ini:"log_min_error_statement"`
-	ClientMinMessages       string `ini:"client_min_messages"`
+	WalKeepSize                string `ini:"wal_keep_size,omitempty"`
+	WalKeepSegments            string `ini:"wal_keep_segments,omitempty"`
+	ArchiveMode                string `ini:"archive_mode,omitempty"`
+	ArchiveCommand             string `ini:"archive_command,omitempty"`
+	ArchiveTimeout             string `ini:"archive_timeout,omitempty"`
+	LoggingCollector           string `ini:"logging_collector"`
+	LogDestination             string `ini:"log_destination"`
+	LogDirectory               string `ini:"log_directory"`
+	LogFilename                string `ini:"log_filename"`
+	LogRotationAge             string `ini:"log_rotation_age"`
+	LogDuration                string `ini:"log_duration"`
+	LogTruncateOnRotation      string `ini:"log_truncate_on_rotation"`
+	LogMinDurationStatement    string `ini:"log_min_duration_statement"`
+	LogCheckpoints             string `ini:"log_checkpoints"`
+	LogConnections             string `ini:"log_connections"`
+	LogDisconnections          string `ini:"log_disconnections"`
+	LogLockWaits               string `ini:"log_lock_waits"`
+	LogStatement               string `ini:"log_statement"`
+	LogLinePrefix              string `ini:"log_line_prefix"`
+	LogTimezone                string `ini:"log_timezone"`
+	LogMinMessages             string `ini:"log_min_messages"`
+	LogMinErrorStatement       string `ini:"log_min_error_statement"`
+	ClientMinMessages          string `ini:"client_min_messages"`
 	//LogStatementSampleRate       string `ini:"log_statement_sample_rate"`  // 好像pgsql13才开始支持这个参数
 	SharedPreloadLibraries       string `ini:"shared_preload_libraries" comment:"# shared_preload_libraries       = 'timescaledb'"`
 	PgStatStatementsMax          string `ini:"pg_stat_statements.max"`
@@ -187,6 +190,12 @@ func (c *PgsqlConfig) HandleConfig(pre *Prepare, logDir string) error {
 	} else {
 		c.SharedPreloadLibraries = "'pg_stat_statements," + pre.Libraries + "'"
 	}
+	if pre.WalArchive != "" {
+		c.ArchiveMode = "on"
+		c.ArchiveCommand = WalArchiveCommand
+		c.ArchiveTimeout = WalArchiveTimeout
+	}
+	c.SetVersion(pre.Version)
 
 	return nil
 }
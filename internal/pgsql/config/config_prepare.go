@@ -25,6 +25,7 @@ import (
 // 安装时读取的配置文件
 type Prepare struct {
 	RepmgrDeployMode      string `ini:"repmgr-deploy-mode"`
+	Version               string `ini:"version" comment:"PostgreSQL 大版本, 默认为 12"`
 	SystemUser            string `ini:"system-user"`
 	SystemGroup           string `ini:"system-group"`
 	BindIP                string `ini:"bind-ip" comment:"监听IP，如果没有特殊要求请勿修改"`
@@ -38,6 +39,13 @@ type Prepare struct {
 	Address               string `ini:"address" comment:"IP白名单，列入白名单的IP地址能够连接该数据库，无特殊要求请勿修改"`
 	MemorySize            string `ini:"memory-size" comment:"内存配置，建议内存配置不超过系统物理内存总量的50%，避免使用过程中系统物理内存耗尽造成内存溢出，默认为操作系统的50%，请根据实际部署环境进行调整，单位后缀可以为{MB,GB}"`
 	ResourceLimit         string `ini:"resource-limit"`
+	Tune                  bool   `ini:"tune" comment:"是否按 pgsql 的内置配置调优操作系统(sysctl, 透明大页, limits.d, LimitNOFILE), 可以用 dbup tune revert 恢复"`
+	WalArchive            string `ini:"wal-archive" comment:"WAL 归档位置, 本地目录或 s3://bucket/前缀, 为空时不开启归档"`
+	WalArchiveCompress    bool   `ini:"wal-archive-compress" comment:"WAL 归档时是否 gzip 压缩"`
+	WalArchiveEndpoint    string `ini:"wal-archive-endpoint"`
+	WalArchiveAccessKey   string `ini:"wal-archive-access-key"`
+	WalArchiveSecretKey   string `ini:"wal-archive-secret-key"`
+	WalArchiveS3Mode      string `ini:"wal-archive-s3-mode"`
 	Ipv6                  bool   `ini:"ipv6"`
 	Libraries             string `ini:"libraries"`
 	RepmgrOwnerIP         string `ini:"repmgr-owner-ip"`
@@ -308,6 +316,12 @@ func (p *Prepare) Validator() error {
 		return err
 	}
 
+	if p.WalArchive != "" {
+		if err := NewWalArchiveConfig(p).Validator(); err != nil {
+			return err
+		}
+	}
+
 	if strings.Contains(p.Libraries, "repmgr") {
 		if p.RepmgrNodeID == 0 || p.RepmgrUser == "" || p.RepmgrPassword == "" || p.RepmgrDBName == "" {
 			return fmt.Errorf("请正确设置repmgr相关参数")
@@ -0,0 +1,112 @@
+package config
+
+import (
+	"dbup/internal/global"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/ini.v1"
+)
+
+// WAL 归档
+const (
+	WalArchiveConfFile = "wal_archive.conf" // 安装目录下的归档配置, archive_command 使用
+	WalRestoreConfFile = "wal_restore.conf" // 安装目录下的恢复配置, restore_command 使用
+	WalArchiveS3Prefix = "s3://"
+	WalArchiveTimeout  = "5min"
+	DbupFileName       = "dbup"
+)
+
+// archive_command 和 restore_command 在数据目录中执行, 使用相对路径, 安装目录不同的从库和恢复的实例也可以使用
+const (
+	WalArchiveCommand = "'../server/bin/dbup pgsql wal-archive push --config=../wal_archive.conf %p %f'"
+	WalRestoreCommand = "'../server/bin/dbup pgsql wal-archive fetch --config=../wal_restore.conf %f %p'"
+)
+
+// WalArchiveConfig WAL 归档配置, 归档到本地目录或 S3
+type WalArchiveConfig struct {
+	Target    string `ini:"target"`
+	Compress  bool   `ini:"compress"`
+	EndPoint  string `ini:"endpoint"`
+	AccessKey string `ini:"access-key"`
+	SecretKey string `ini:"secret-key"`
+	Mode      string `ini:"mode"`
+}
+
+// NewWalArchiveConfig 按安装参数生成归档配置
+func NewWalArchiveConfig(pre *Prepare) *WalArchiveConfig {
+	return &WalArchiveConfig{
+		Target:    pre.WalArchive,
+		Compress:  pre.WalArchiveCompress,
+		EndPoint:  pre.WalArchiveEndpoint,
+		AccessKey: pre.WalArchiveAccessKey,
+		SecretKey: pre.WalArchiveSecretKey,
+		Mode:      pre.WalArchiveS3Mode,
+	}
+}
+
+// WalArchiveConfPath 实例的归档配置文件
+func WalArchiveConfPath(dir string) string {
+	return filepath.Join(dir, WalArchiveConfFile)
+}
+
+func (c *WalArchiveConfig) IsS3() bool {
+	return strings.HasPrefix(c.Target, WalArchiveS3Prefix)
+}
+
+// Bucket S3 的 bucket 和 key 前缀
+func (c *WalArchiveConfig) Bucket() (string, string) {
+	s := strings.SplitN(strings.TrimPrefix(c.Target, WalArchiveS3Prefix), "/", 2)
+	if len(s) == 1 {
+		return s[0], ""
+	}
+	return s[0], strings.Trim(s[1], "/")
+}
+
+func (c *WalArchiveConfig) Validator() error {
+	if c.Target == "" {
+		return fmt.Errorf("请指定 WAL 归档位置, 本地目录或 s3://bucket/前缀")
+	}
+	if !c.IsS3() {
+		if !filepath.IsAbs(c.Target) {
+			return fmt.Errorf("WAL 归档目录(%s)必须是绝对路径", c.Target)
+		}
+		return nil
+	}
+	if bucket, _ := c.Bucket(); bucket == "" {
+		return fmt.Errorf("WAL 归档位置(%s)中没有 bucket", c.Target)
+	}
+	if c.Mode == "" {
+		c.Mode = "normal"
+	}
+	if c.Mode != "SkipVerify" && c.Mode != "normal" {
+		return fmt.Errorf("S3 连接模式只能是 normal 或 SkipVerify")
+	}
+	if c.EndPoint == "" {
+		return fmt.Errorf("请指定 S3 连接地址")
+	}
+	if c.AccessKey == "" {
+		return fmt.Errorf("请指定 S3 accesskey")
+	}
+	if c.SecretKey == "" {
+		return fmt.Errorf("请指定 S3 secretkey")
+	}
+	return nil
+}
+
+func (c *WalArchiveConfig) Load(filename string) error {
+	if err := global.INILoadFromFile(filename, c, ini.LoadOptions{}); err != nil {
+		return err
+	}
+	return c.Validator()
+}
+
+// SaveTo 保存归档配置, 配置中有 S3 密钥, 只有所属用户可读
+func (c *WalArchiveConfig) SaveTo(filename string) error {
+	if err := global.INISaveToFile(filename, c); err != nil {
+		return err
+	}
+	return os.Chmod(filename, 0600)
+}
@@ -0,0 +1,53 @@
+package config
+
+import (
+	"dbup/internal/global"
+	"fmt"
+	"strconv"
+)
+
+// SupportedVersions 支持安装的 PostgreSQL 大版本, 安装包按大版本命名
+var SupportedVersions = []string{"12", "13", "14", "15", "16"}
+
+// LatestMinorVersions 各大版本安装包中的小版本, 升级前用于比较版本
+var LatestMinorVersions = map[string]string{
+	DefaultPGVersion: DefaultPGinfoVersion,
+}
+
+// InfoVersion 记录到实例清单中的版本, 有小版本时使用小版本
+func InfoVersion(version string) string {
+	if v, ok := LatestMinorVersions[version]; ok {
+		return v
+	}
+	return version
+}
+
+// CheckVersion 检查要安装的 PostgreSQL 版本, 为空时返回默认版本. 安装包按大版本命名, 只能指定大版本
+func CheckVersion(version string) (string, error) {
+	v, major, err := global.CheckVersion("PostgreSQL", version, DefaultPGVersion, SupportedVersions)
+	if err != nil {
+		return "", err
+	}
+	if v != major {
+		return "", fmt.Errorf("PostgreSQL 只需要指定大版本, 如: %s", major)
+	}
+	return v, nil
+}
+
+// PackageName 版本对应的安装包文件名
+func PackageName(version string) string {
+	return PlatformPackageName(version, global.LocalPlatform())
+}
+
+// PlatformPackageName 目标机器平台对应的安装包文件名
+func PlatformPackageName(version string, p *global.Platform) string {
+	return p.PackageName(PackageFile, version)
+}
+
+// SetVersion 按大版本调整配置: 13 开始 wal_keep_segments 改为 wal_keep_size
+func (c *PgsqlConfig) SetVersion(version string) {
+	if major, _ := strconv.Atoi(version); major >= 13 {
+		c.WalKeepSize = "10GB"
+		c.WalKeepSegments = ""
+	}
+}
@@ -8,6 +8,7 @@ package dao
 import (
 	"database/sql"
 	"fmt"
+	"strings"
 
 	_ "github.com/lib/pq"
 )
@@ -127,6 +128,15 @@ func (p *PgConn) AlterUserExpireAt(username, expireAt string) error {
 	return err
 }
 
+// SetSynchronousStandbyNames 设置同步从库, names 为空时关闭同步复制
+func (p *PgConn) SetSynchronousStandbyNames(names string) error {
+	sql := fmt.Sprintf("ALTER SYSTEM SET synchronous_standby_names = '%s';", strings.Replace(names, "'", "''", -1))
+	if _, err := p.DB.Exec(sql); err != nil {
+		return fmt.Errorf("设置 synchronous_standby_names 失败: %v", err)
+	}
+	return p.ReloadConfig()
+}
+
 func (p *PgConn) AlterPassword(username, password string) error {
 	sql := fmt.Sprintf("ALTER USER %s WITH PASSWORD '%s';", username, password)
 	_, err := p.DB.Query(sql)
@@ -7,7 +7,6 @@ package pgsql
 
 import (
 	"dbup/internal/environment"
-	"dbup/internal/global"
 	"dbup/internal/pgsql/config"
 	"dbup/internal/pgsql/services"
 	"fmt"
@@ -22,33 +21,10 @@ func NewPgsql() *Pgsql {
 	return &Pgsql{}
 }
 
-func (p *Pgsql) Install(pre config.Prepare, cfgFile, packageName string, onlyCheck, onlyInstall bool) error {
-	inst := services.NewInstall()
-	return inst.Run(pre, cfgFile, packageName, onlyCheck, onlyInstall)
-}
-
-func (p *Pgsql) InstallSlave(pre config.Prepare, master string) error {
-	inst := services.NewInstall()
-	return inst.RunSlave(pre, master)
-}
-
-func (p *Pgsql) AddSlave(ssho global.SSHConfig, pre config.Prepare, master string) error {
-	inst := services.NewPGManager()
-	return inst.AddSlave(ssho, pre, master)
-}
-
-func (p *Pgsql) UNInstall(uninst *services.UNInstall) error {
-	return uninst.Uninstall()
-}
-
 func (p *Pgsql) PGautoUNInstall(uninst *services.UNInstall) error {
 	return uninst.PGautofaileoverUninstall()
 }
 
-func (p *Pgsql) Backup(backup *services.Backup) error {
-	return backup.Run()
-}
-
 func (p *Pgsql) BackupTables(backup *services.BackupTables, tables, list string) error {
 	return backup.Run(tables, list)
 }
@@ -77,16 +53,6 @@ func (p *Pgsql) BackupTask(action string, task *services.BackupTask) error {
 	}
 }
 
-func (p *Pgsql) Deploy(c string) error {
-	d := services.NewDeploy()
-	return d.Run(c)
-}
-
-func (p *Pgsql) RemoveDeploy(c string, yes bool) error {
-	d := services.NewDeploy()
-	return d.RemoveDeploy(c, yes)
-}
-
 func (p *Pgsql) UserCreate(m *services.PGManager) error {
 	if err := m.InitConn(); err != nil {
 		return err
@@ -96,15 +62,6 @@ func (p *Pgsql) UserCreate(m *services.PGManager) error {
 	return m.UserCreate()
 }
 
-func (p *Pgsql) UserGrant(m *services.PGManager) error {
-	if err := m.InitConn(); err != nil {
-		return err
-	}
-	defer m.Conn.DB.Close()
-	return m.UserGrant()
-	// return m.AutofailoverChangehba()
-}
-
 func (p *Pgsql) UserGrantPGdata(m *services.PGManager) error {
 	if err := m.InitConn(); err != nil {
 		return err
@@ -114,22 +71,22 @@ func (p *Pgsql) UserGrantPGdata(m *services.PGManager) error {
 	return m.AutofailoverGrantuser()
 }
 
-func (p *Pgsql) DatabaseCreate(m *services.PGManager) error {
+func (p *Pgsql) CheckSlaves(m *services.PGManager, s string) error {
 	if err := m.InitConn(); err != nil {
 		return err
 	}
 	defer m.Conn.DB.Close()
 
-	return m.DatabaseCreate()
+	return m.CheckSlaves(s)
 }
 
-func (p *Pgsql) CheckSlaves(m *services.PGManager, s string) error {
+func (p *Pgsql) SyncStandby(m *services.PGManager, names string) error {
 	if err := m.InitConn(); err != nil {
 		return err
 	}
 	defer m.Conn.DB.Close()
 
-	return m.CheckSlaves(s)
+	return m.SyncStandby(names)
 }
 
 func (p *Pgsql) CheckSelect(m *services.PGManager) error {
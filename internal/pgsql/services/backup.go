@@ -6,6 +6,8 @@
 package services
 
 import (
+	"dbup/internal/output"
+	"dbup/internal/pgsql/config"
 	"dbup/internal/pgsql/dao"
 	"dbup/internal/utils/command"
 	"dbup/internal/utils/diskutil"
@@ -78,5 +80,6 @@ func (b *Backup) Run() error {
 	}
 
 	logger.Infof("备份完成\n")
+	output.Created(output.Resource{Kind: "backup", Engine: config.Kinds, Port: b.Port, Path: b.BackupDir})
 	return nil
 }
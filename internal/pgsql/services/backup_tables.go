@@ -6,6 +6,8 @@
 package services
 
 import (
+	"dbup/internal/output"
+	"dbup/internal/pgsql/config"
 	"dbup/internal/utils"
 	"dbup/internal/utils/command"
 	"dbup/internal/utils/logger"
@@ -105,5 +107,6 @@ func (b *BackupTables) Run(tables, list string) error {
 	}
 
 	logger.Infof("备份完成\n")
+	output.Created(output.Resource{Kind: "backup", Engine: config.Kinds, Port: b.Port, Path: b.BackupFile})
 	return nil
 }
@@ -8,10 +8,12 @@ package services
 import (
 	"bufio"
 	"dbup/internal/environment"
+	"dbup/internal/output"
 	"dbup/internal/pgsql/config"
 	"dbup/internal/utils"
 	"dbup/internal/utils/command"
 	"dbup/internal/utils/logger"
+	"dbup/internal/utils/secretfile"
 	"fmt"
 	"os"
 	"path/filepath"
@@ -30,6 +32,7 @@ type BackupTask struct {
 	SysPrivilegesLevel string
 	BackupDir          string
 	Expire             int
+	WalArchiveConfig   string // 不为空时删除过期备份后, 按最早的备份清理 WAL 归档
 	Backup             *Backup
 }
 
@@ -47,7 +50,23 @@ func (t *BackupTask) Run() error {
 	if err := t.Backup.Run(); err != nil {
 		return err
 	}
-	return t.DropExpire()
+	if err := t.DropExpire(); err != nil {
+		return err
+	}
+	return t.PruneWal()
+}
+
+// PruneWal 删除比最早的基础备份还早的 WAL 归档
+func (t *BackupTask) PruneWal() error {
+	if t.WalArchiveConfig == "" {
+		return nil
+	}
+	logger.Infof("清理 WAL 归档\n")
+	w, err := NewWalArchive(t.WalArchiveConfig)
+	if err != nil {
+		return err
+	}
+	return w.Prune(t.BackupDir, false)
 }
 
 func (t *BackupTask) DropExpire() error {
@@ -87,6 +106,13 @@ func (t *BackupTask) AddValidator() error {
 	return nil
 }
 
+// taskItem 备份任务列表中的一项, 用于 json 格式输出
+type taskItem struct {
+	Name string `json:"name"`
+	Time string `json:"time"`
+	Port string `json:"port"`
+}
+
 func (t *BackupTask) WindowsList() error {
 	logger.Infof("列出定时任务列表\n")
 	cmd := "schtasks /Query /FO CSV /V /NH"
@@ -114,7 +140,7 @@ func (t *BackupTask) WindowsList() error {
 			if len(tName) < 3 {
 				return fmt.Errorf("获取备份任务名称异常\n")
 			}
-			fmt.Printf("备份任务名: %s, 每天备份时间: %s:%s, 备份端口号: %s\n", tName[2], time[0], time[1], tName[1])
+			output.Item("tasks", taskItem{Name: tName[2], Time: time[0] + ":" + time[1], Port: tName[1]}, "备份任务名: %s, 每天备份时间: %s:%s, 备份端口号: %s\n", tName[2], time[0], time[1], tName[1])
 		}
 	}
 	return nil
@@ -125,13 +151,18 @@ func (t *BackupTask) WindowsAdd() error {
 		return err
 	}
 	logger.Infof("添加定时任务\n")
+	// 密码写入密码文件, 不直接写在计划任务中
+	if err := secretfile.Write(t.secretFile(), map[string]string{"password": t.Backup.Password}); err != nil {
+		return err
+	}
 	// TODO: 普通用户不能加 /RL HIGHEST 参数, 管理员用户没有密码, 所以还没有测试
-	cmd := fmt.Sprintf("schtasks /create /tn %s /ru %s /rp %s /RL %s /tr %s\" \"pgsql\" \"backup-task\" \"run\" \"--port=%d\" \"--command=%s\" \"--user=%s\" \"--password=%s\" \"--backupdir=%s\" \"--expire=%d /sc daily /st %s", t.TaskNameFormat, t.SysUser, t.SysPassword, t.SysPrivilegesLevel, environment.GlobalEnv().Program, t.Backup.Port, t.Backup.BackupCmd, t.Backup.Username, t.Backup.Password, t.BackupDir, t.Expire, t.TaskTime)
+	cmd := fmt.Sprintf("schtasks /create /tn %s /ru %s /rp %s /RL %s /tr %s\" \"pgsql\" \"backup-task\" \"run\" \"--port=%d\" \"--command=%s\" \"--user=%s\" \"--password-file=%s\" \"--backupdir=%s\" \"--expire=%d /sc daily /st %s", t.TaskNameFormat, t.SysUser, t.SysPassword, t.SysPrivilegesLevel, environment.GlobalEnv().Program, t.Backup.Port, t.Backup.BackupCmd, t.Backup.Username, t.secretFile(), t.BackupDir, t.Expire, t.TaskTime)
 	l := command.Local{}
 	if _, stderr, err := l.WinRun(cmd); err != nil {
 		return fmt.Errorf("创建备份任务失败: %v, 标准错误输出: %s", err, stderr)
 	}
 	logger.Infof("创建备份任务成功\n")
+	output.Created(output.Resource{Kind: "backup-task", Name: t.TaskNameFormat})
 	return nil
 }
 
@@ -142,7 +173,11 @@ func (t *BackupTask) WindowsDel() error {
 	if _, stderr, err := l.WinRun(cmd); err != nil {
 		return fmt.Errorf("删除备份任务失败: %v, 标准错误输出: %s", err, stderr)
 	}
+	if err := os.Remove(t.secretFile()); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("删除密码文件失败: %v", err)
+	}
 	logger.Infof("设置备份任务成功\n")
+	output.Removed(output.Resource{Kind: "backup-task", Name: t.TaskNameFormat})
 	return nil
 }
 
@@ -172,7 +207,7 @@ func (t *BackupTask) LinuxList() error {
 			if len(tName) < 3 {
 				return fmt.Errorf("获取备份任务名称异常\n")
 			}
-			fmt.Printf("备份任务名: %s, 每天备份时间: %s:%s, 备份端口号: %s\n", tName[2], time[1], time[0], tName[1])
+			output.Item("tasks", taskItem{Name: tName[2], Time: time[1] + ":" + time[0], Port: tName[1]}, "备份任务名: %s, 每天备份时间: %s:%s, 备份端口号: %s\n", tName[2], time[1], time[0], tName[1])
 		}
 	}
 
@@ -195,6 +230,11 @@ func (t *BackupTask) LinuxList() error {
 	return nil
 }
 
+// secretFile 定时任务使用的密码文件
+func (t *BackupTask) secretFile() string {
+	return filepath.Join(environment.GlobalEnv().DbupInfoPath, secretfile.Dir, t.TaskNameFormat+".json")
+}
+
 func (t *BackupTask) LinuxAdd() error {
 	if err := t.AddValidator(); err != nil {
 		return err
@@ -218,6 +258,11 @@ func (t *BackupTask) LinuxAdd() error {
 		}
 	}
 
+	// 密码写入只有 root 可读的文件, 不直接写在定时任务中
+	if err := secretfile.Write(t.secretFile(), map[string]string{"password": t.Backup.Password}); err != nil {
+		return err
+	}
+
 	// 将任务写入定时文件
 	if err := command.CopyFile(config.BackupTaskLinuxCronFile); err != nil {
 		return err
@@ -228,7 +273,11 @@ func (t *BackupTask) LinuxAdd() error {
 	}
 	defer file.Close()
 
-	line := fmt.Sprintf("%s %s * * * %s pgsql backup-task run --port=%d --command='%s' --user='%s' --password='%s' --backupdir='%s' --expire=%d #%s\n", HM[1], HM[0], environment.GlobalEnv().Program, t.Backup.Port, t.Backup.BackupCmd, t.Backup.Username, t.Backup.Password, t.BackupDir, t.Expire, t.TaskNameFormat)
+	var prune string
+	if t.WalArchiveConfig != "" {
+		prune = fmt.Sprintf(" --wal-archive-config='%s'", t.WalArchiveConfig)
+	}
+	line := fmt.Sprintf("%s %s * * * %s pgsql backup-task run --port=%d --command='%s' --user='%s' --password-file='%s' --backupdir='%s' --expire=%d%s #%s\n", HM[1], HM[0], environment.GlobalEnv().Program, t.Backup.Port, t.Backup.BackupCmd, t.Backup.Username, t.secretFile(), t.BackupDir, t.Expire, prune, t.TaskNameFormat)
 	write := bufio.NewWriter(file)
 	if _, err := write.WriteString(line); err != nil {
 		return err
@@ -243,6 +292,7 @@ func (t *BackupTask) LinuxAdd() error {
 	//	return fmt.Errorf("添加备份任务失败: %v, 标准错误输出: %s", err, stderr)
 	//}
 	logger.Infof("设置备份任务成功\n")
+	output.Created(output.Resource{Kind: "backup-task", Name: t.TaskNameFormat})
 	return nil
 }
 
@@ -289,6 +339,10 @@ func (t *BackupTask) LinuxDel() error {
 	if err := w.Flush(); err != nil {
 		return err
 	}
+	if err := os.Remove(t.secretFile()); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("删除密码文件失败: %v", err)
+	}
 	logger.Successf("删除成功\n")
+	output.Removed(output.Resource{Kind: "backup-task", Name: t.TaskNameFormat})
 	return nil
 }
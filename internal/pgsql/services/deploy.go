@@ -6,45 +6,86 @@
 package services
 
 import (
+	"context"
+	"dbup/internal/credential"
 	"dbup/internal/environment"
+	"dbup/internal/global"
+	"dbup/internal/inventory"
+	"dbup/internal/journal"
 	"dbup/internal/pgsql/config"
+	"dbup/internal/plan"
+	"dbup/internal/preflight"
 	"dbup/internal/utils"
 	"dbup/internal/utils/logger"
+	"dbup/internal/utils/parallel"
+	"dbup/internal/utils/prompt"
 	"fmt"
-	"os"
 	"path"
-	"strings"
+	"strconv"
 	"time"
 )
 
+// deployMode 主从部署在实例清单和部署记录中的部署方式
+const deployMode = "master-slave"
+
 type Deploy struct {
-	Param  config.Parameter
-	master *Instance
-	slaves []*Instance
+	Param   config.Parameter
+	master  *Instance
+	slaves  []*Instance
+	journal *journal.Journal
+	ctx     context.Context
 }
 
 func NewDeploy() *Deploy {
 	return &Deploy{}
 }
 
+// WithContext 设置部署使用的 ctx, ctx 取消后不再开始新的步骤. 回滚和清理不受 ctx 影响
+func (d *Deploy) WithContext(ctx context.Context) *Deploy {
+	d.ctx = ctx
+	return d
+}
+
+func (d *Deploy) context() context.Context {
+	if d.ctx == nil {
+		return context.Background()
+	}
+	return d.ctx
+}
+
 func (d *Deploy) Run(c string) error {
 	// 初始化参数和配置环节
-	if err := d.Param.Load(c); err != nil {
+	if err := d.load(c); err != nil {
 		return err
 	}
-	d.Param.Server.SetDefault()
-	if err := d.Param.Validator(); err != nil {
+
+	j, err := journal.Start(config.Kinds, deployMode, c)
+	if err != nil {
 		return err
 	}
+	d.journal = j
+	err = d.deploy()
+	j.Finish(err)
+	return err
+}
 
-	if d.Param.Pgsql.SystemUser == "" {
-		d.Param.Pgsql.SystemUser = config.DefaultPGAdminUser
+// Resume 根据部署记录继续部署, 跳过已经完成的步骤
+func (d *Deploy) Resume(id string) error {
+	j, err := journal.Resume(id, config.Kinds, deployMode)
+	if err != nil {
+		return err
 	}
-
-	if d.Param.Pgsql.SystemGroup == "" {
-		d.Param.Pgsql.SystemGroup = config.DefaultPGAdminUser
+	if err := d.load(j.Config); err != nil {
+		j.Finish(err)
+		return err
 	}
+	d.journal = j
+	err = d.deploy()
+	j.Finish(err)
+	return err
+}
 
+func (d *Deploy) deploy() error {
 	logger.Infof("初始化部署对象\n")
 	if d.Param.Server.Password != "" {
 		if err := d.Init(); err != nil {
@@ -69,13 +110,93 @@ func (d *Deploy) Run(c string) error {
 		return err
 	}
 	if err := d.InstallAndInitSlave(); err != nil {
-		logger.Warningf("安装失败, 开始回滚\n")
-		d.UNInstall()
+		if !d.Param.NoRollback {
+			logger.Warningf("安装失败, 开始回滚\n")
+			d.UNInstall()
+			d.journal.RolledBack()
+		}
 		return err
 	}
+	if err := inventory.RecordCluster(d.cluster()); err != nil {
+		logger.Warningf("更新实例清单失败: %v\n", err)
+	}
 	return nil
 }
 
+// load 读取并验证部署配置
+func (d *Deploy) load(c string) error {
+	if err := d.Param.Load(c); err != nil {
+		return err
+	}
+	d.Param.Server.SetDefault()
+	if err := d.Param.Validator(); err != nil {
+		return err
+	}
+
+	if d.Param.Pgsql.SystemUser == "" {
+		d.Param.Pgsql.SystemUser = config.DefaultPGAdminUser
+	}
+
+	if d.Param.Pgsql.SystemGroup == "" {
+		d.Param.Pgsql.SystemGroup = config.DefaultPGAdminUser
+	}
+	return nil
+}
+
+// Plan 只读连接各节点, 输出部署计划和冲突, 不做任何修改
+func (d *Deploy) Plan(c string) error {
+	if err := d.load(c); err != nil {
+		return err
+	}
+	s := d.Param.Server
+	port := d.Param.Pgsql.Port
+	service := fmt.Sprintf(config.ServiceFileName, port)
+	files := []string{
+		"bin/dbup",
+		"package/md5",
+		path.Join("systemd", global.ServiceTemplateFile(config.PostgresServiceTemplateFile, d.Param.Pgsql.Version)),
+		path.Join("package", "pgsql", config.PackageName(d.Param.Pgsql.Version)),
+	}
+
+	logger.Infof("检查部署节点\n")
+	p := plan.New(config.Kinds, deployMode, plan.SSHDialer(s.User, s.Password, s.KeyFile, s.SshPort, s.SSHOptions()))
+	master := p.Host(s.Master)
+	master.Stage(d.tmpDir(s.Master, port), files...)
+	master.Instance(config.Kinds, port, d.Param.Pgsql.Dir, service)
+	master.Add(plan.ActionUser, "管理用户 %s", d.Param.Pgsql.Username)
+	master.Add(plan.ActionUser, "复制用户 %s, 允许 %s 连接", config.DefaultPGReplUser, global.ReplicaHosts(d.Param.Replicas))
+	if names := d.Param.SynchronousStandbyNames(); names != "" {
+		master.Add(plan.ActionReplication, "设置同步从库 synchronous_standby_names = '%s'", names)
+	}
+	for _, r := range d.Param.Replicas {
+		h := p.Host(r.Host)
+		h.Stage(d.tmpDir(r.Host, r.Port), files...)
+		h.Instance(config.Kinds, r.Port, r.Dir, fmt.Sprintf(config.ServiceFileName, r.Port))
+		host, upstreamPort := d.upstream(r)
+		h.Add(plan.ActionReplication, "从 %s:%d 复制数据并启动从库 %s", host, upstreamPort, r.Name)
+	}
+	for _, h := range p.Hosts {
+		h.Add(plan.ActionCleanup, "删除临时目录 %s 中的文件", s.TmpDir)
+	}
+	return p.Finish()
+}
+
+// Check 连接各节点执行部署前检查, 不做任何修改
+func (d *Deploy) Check(c string) error {
+	if err := d.load(c); err != nil {
+		return err
+	}
+	s := d.Param.Server
+
+	logger.Infof("检查部署节点环境\n")
+	p := preflight.New(plan.SSHDialer(s.User, s.Password, s.KeyFile, s.SshPort, s.SSHOptions()))
+	p.Add(s.Master, config.Kinds, []int{d.Param.Pgsql.Port}, d.Param.Pgsql.Dir)
+	for _, r := range d.Param.Replicas {
+		p.Add(r.Host, config.Kinds, []int{r.Port}, r.Dir)
+	}
+	return p.Finish()
+}
+
 func (d *Deploy) RemoveDeploy(c string, yes bool) error {
 	// 初始化参数和配置环节
 	if err := d.Param.Load(c); err != nil {
@@ -92,23 +213,20 @@ func (d *Deploy) RemoveDeploy(c string, yes bool) error {
 	if d.Param.Pgsql.Dir == "" {
 		return fmt.Errorf("请指定数据目录")
 	}
+	if err := d.Param.ResolveReplicas(); err != nil {
+		return err
+	}
 
 	logger.Warningf("要删除的集群节点以及数据目录: %s:%d %s\n", d.Param.Server.Master, d.Param.Pgsql.Port, d.Param.Pgsql.Dir)
-	for _, ip := range strings.Split(d.Param.Server.Slaves, ",") {
-		logger.Warningf("要删除的集群节点以及数据目录: %s:%d %s\n", ip, d.Param.Pgsql.Port, d.Param.Pgsql.Dir)
+	for _, r := range d.Param.Replicas {
+		logger.Warningf("要删除的集群节点以及数据目录: %s %s\n", r.Addr(), r.Dir)
 	}
 
 	if !yes {
 		logger.Warningf("删除集群是危险操作,会将整个集群中的数据完全删除, 不可恢复\n")
-		logger.Warningf("是否确认删除[y|n]:")
-
-		var yes string
-		if _, err := fmt.Scanln(&yes); err != nil {
+		if err := prompt.Confirm("是否确认删除"); err != nil {
 			return err
 		}
-		if strings.ToUpper(yes) != "Y" && strings.ToUpper(yes) != "YES" {
-			os.Exit(0)
-		}
 	}
 
 	logger.Infof("初始化删除对象\n")
@@ -131,9 +249,36 @@ func (d *Deploy) RemoveDeploy(c string, yes bool) error {
 	}
 
 	d.UNInstall()
+	if err := inventory.ForgetCluster(d.cluster().Name); err != nil {
+		logger.Warningf("更新实例清单失败: %v\n", err)
+	}
 	return nil
 }
 
+// cluster 集群成员, 用于记录到实例清单
+func (d *Deploy) cluster() inventory.Cluster {
+	members := inventory.Members(d.Param.Server.Master, d.Param.Pgsql.Port, "master", d.Param.Pgsql.Dir)
+	for _, r := range d.Param.Replicas {
+		members = append(members, inventory.Member{Host: r.Host, Port: r.Port, Role: "slave", Dir: r.Dir})
+	}
+	return inventory.Cluster{
+		Name:    inventory.ClusterName(config.Kinds, d.Param.Server.Master, d.Param.Pgsql.Port),
+		Engine:  config.Kinds,
+		Mode:    deployMode,
+		Members: members,
+	}
+}
+
+// upstream 从节点复制数据的来源: 主库, 或级联复制的上游从节点
+func (d *Deploy) upstream(r *global.Replica) (string, int) {
+	for _, u := range d.Param.Replicas {
+		if u.Name == r.Upstream {
+			return u.Host, u.Port
+		}
+	}
+	return d.Param.Server.Master, d.Param.Pgsql.Port
+}
+
 func (d *Deploy) InstallAndInitSlave() error {
 	if err := d.Install(); err != nil {
 		return err
@@ -144,17 +289,30 @@ func (d *Deploy) InstallAndInitSlave() error {
 	//	return err
 	//}
 
-	PGReplPass := utils.GeneratePasswd(config.DefaultPGPassLength)
-	if err := d.master.CreateReplUser(d.Param.Server.Slaves, PGReplPass); err != nil {
+	PGReplPass, err := d.replPassword()
+	if err != nil {
+		return err
+	}
+	if err := d.journal.Run(d.key(d.master), "create-repl-user", func() error {
+		return d.master.CreateReplUser(global.ReplicaHosts(d.Param.Replicas), PGReplPass)
+	}); err != nil {
 		return err
 	}
 	if err := d.ReplicaSlave(PGReplPass); err != nil {
 		return err
 	}
+	if names := d.Param.SynchronousStandbyNames(); names != "" {
+		logger.Infof("设置同步从库: %s\n", names)
+		if err := d.journal.Run(d.key(d.master), "sync-standby", func() error {
+			return d.master.SyncStandby(names)
+		}); err != nil {
+			return err
+		}
+	}
 
 	logger.Infof("5秒后检查集群状态\n")
 	time.Sleep(5 * time.Second)
-	if err := d.master.CheckSlaves(d.Param.Server.Slaves); err != nil {
+	if err := d.CheckSlaves(); err != nil {
 		return err
 	}
 
@@ -165,25 +323,98 @@ func (d *Deploy) InstallAndInitSlave() error {
 	return nil
 }
 
+// replPassword 生成复制用户密码并保存到凭据库, 继续部署时使用已经保存的密码
+func (d *Deploy) replPassword() (string, error) {
+	name := d.cluster().Name
+	if d.journal != nil && d.journal.Resumes > 0 {
+		pass, ok, err := credential.Lookup(name, credential.FieldReplPassword)
+		if err != nil {
+			return "", err
+		}
+		if ok {
+			return pass, nil
+		}
+	}
+	pass := utils.GeneratePasswd(config.DefaultPGPassLength)
+	return pass, credential.Put(name, map[string]string{credential.FieldReplPassword: pass})
+}
+
+// CheckSlaves 主库检查直接复制的从库, 有下游的从库检查级联复制的从库
+func (d *Deploy) CheckSlaves() error {
+	if err := d.master.CheckSlaves(global.ReplicaHosts(global.Downstream(d.Param.Replicas, ""))); err != nil {
+		return err
+	}
+	for i, r := range d.Param.Replicas {
+		if children := global.Downstream(d.Param.Replicas, r.Name); len(children) > 0 {
+			if err := d.slaves[i].CheckSlaves(global.ReplicaHosts(children)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// tmpDir 节点上的临时目录, 同一台机器上部署多个实例时每个实例使用以端口命名的子目录
+func (d *Deploy) tmpDir(host string, port int) string {
+	if d.shared(host) {
+		return path.Join(d.Param.Server.TmpDir, strconv.Itoa(port))
+	}
+	return d.Param.Server.TmpDir
+}
+
+// shared 机器上是否部署了多个实例
+func (d *Deploy) shared(host string) bool {
+	n := 0
+	if host == d.Param.Server.Master {
+		n++
+	}
+	for _, r := range d.Param.Replicas {
+		if r.Host == host {
+			n++
+		}
+	}
+	return n > 1
+}
+
+// key 实例在部署记录中的名称, 同一台机器上有多个实例时加上端口. 并发任务按机器串行, 使用 inst.Host
+func (d *Deploy) key(inst *Instance) string {
+	if d.shared(inst.Host) {
+		return fmt.Sprintf("%s:%d", inst.Host, inst.Inst.port)
+	}
+	return inst.Host
+}
+
+// prepare 实例的安装参数
+func (d *Deploy) prepare(inst *Instance) config.Prepare {
+	for i, slave := range d.slaves {
+		if slave == inst {
+			return d.Param.ReplicaPrepare(d.Param.Replicas[i])
+		}
+	}
+	return d.Param.Pgsql
+}
+
 func (d *Deploy) Init() error {
 	var err error
-	if d.master, err = NewInstance(d.Param.Server.TmpDir,
+	if d.master, err = NewInstance(d.tmpDir(d.Param.Server.Master, d.Param.Pgsql.Port),
 		d.Param.Server.Master,
 		d.Param.Server.User,
 		d.Param.Server.Password,
 		d.Param.Server.SshPort,
 		d.Param.Pgsql,
-		0); err != nil {
+		0,
+		d.Param.Server.SSHOptions()); err != nil {
 		return err
 	}
-	for _, slave := range strings.Split(d.Param.Server.Slaves, ",") {
-		s, err := NewInstance(d.Param.Server.TmpDir,
-			slave,
+	for _, r := range d.Param.Replicas {
+		s, err := NewInstance(d.tmpDir(r.Host, r.Port),
+			r.Host,
 			d.Param.Server.User,
 			d.Param.Server.Password,
 			d.Param.Server.SshPort,
-			d.Param.Pgsql,
-			0)
+			d.Param.ReplicaPrepare(r),
+			0,
+			d.Param.Server.SSHOptions())
 		if err != nil {
 			return err
 		}
@@ -194,23 +425,25 @@ func (d *Deploy) Init() error {
 
 func (d *Deploy) InitUseKeyFile() error {
 	var err error
-	if d.master, err = NewInstanceUseKeyFile(d.Param.Server.TmpDir,
+	if d.master, err = NewInstanceUseKeyFile(d.tmpDir(d.Param.Server.Master, d.Param.Pgsql.Port),
 		d.Param.Server.Master,
 		d.Param.Server.User,
 		d.Param.Server.KeyFile,
 		d.Param.Server.SshPort,
 		d.Param.Pgsql,
-		0); err != nil {
+		0,
+		d.Param.Server.SSHOptions()); err != nil {
 		return err
 	}
-	for _, slave := range strings.Split(d.Param.Server.Slaves, ",") {
-		s, err := NewInstanceUseKeyFile(d.Param.Server.TmpDir,
-			slave,
+	for _, r := range d.Param.Replicas {
+		s, err := NewInstanceUseKeyFile(d.tmpDir(r.Host, r.Port),
+			r.Host,
 			d.Param.Server.User,
 			d.Param.Server.KeyFile,
 			d.Param.Server.SshPort,
-			d.Param.Pgsql,
-			0)
+			d.Param.ReplicaPrepare(r),
+			0,
+			d.Param.Server.SSHOptions())
 		if err != nil {
 			return err
 		}
@@ -221,39 +454,26 @@ func (d *Deploy) InitUseKeyFile() error {
 
 func (d *Deploy) CheckTmpDir() error {
 	logger.Infof("检查目标机器的临时目录\n")
-	if err := d.master.CheckTmpDir(); err != nil {
-		return err
-	}
-	for _, slave := range d.slaves {
-		if err := slave.CheckTmpDir(); err != nil {
-			return err
-		}
-	}
-	return nil
+	return d.each(d.context(), "检查临时目录", func(inst *Instance) error {
+		return inst.CheckTmpDir()
+	})
 }
 
 func (d *Deploy) Scp() error {
 	logger.Infof("将所需文件复制到目标机器\n")
 	source := path.Join(environment.GlobalEnv().ProgramPath, "..")
-	logger.Infof("复制到: %s\n", d.master.Host)
-	if err := d.master.Scp(source); err != nil {
-		return err
-	}
-	for _, slave := range d.slaves {
-		logger.Infof("复制到: %s\n", slave.Host)
-		if err := slave.Scp(source); err != nil {
-			return err
-		}
-	}
-	return nil
+	return d.each(d.context(), "复制文件", func(inst *Instance) error {
+		logger.Infof("复制到: %s\n", inst.Host)
+		return inst.Scp(source)
+	})
 }
 
 func (d *Deploy) DropTmpDir() {
 	logger.Infof("删除目标机器的临时目录\n")
-	_ = d.master.DropTmpDir()
-	for _, slave := range d.slaves {
-		_ = slave.DropTmpDir()
-	}
+	_ = d.each(context.Background(), "删除临时目录", func(inst *Instance) error {
+		_ = inst.DropTmpDir()
+		return nil
+	})
 }
 
 //func (d *Deploy) LoopScp() error {
@@ -305,60 +525,69 @@ func (d *Deploy) DropTmpDir() {
 //	return nil
 //}
 
+// CheckEnv 检查环境, 已经安装完成的节点不再检查
 func (d *Deploy) CheckEnv() error {
 	logger.Infof("检查环境\n")
-	if err := d.master.Install(d.Param.Pgsql, true, false, d.Param.Pgsql.Ipv6); err != nil {
-		return err
-	}
-	for _, slave := range d.slaves {
-		if err := slave.Install(d.Param.Pgsql, true, false, d.Param.Pgsql.Ipv6); err != nil {
-			return err
+	return d.each(d.context(), "检查环境", func(inst *Instance) error {
+		if d.journal.Done(d.key(inst), "install") {
+			return nil
 		}
-	}
-	return nil
+		return inst.Install(d.prepare(inst), true, false, d.Param.Pgsql.Ipv6)
+	})
 }
 
+// Install 主库和从库同时安装, 从库只安装不初始化, 之后由 ReplicaSlave 从主库复制数据
 func (d *Deploy) Install() error {
 	logger.Infof("开始安装\n")
-	if err := d.master.Install(d.Param.Pgsql, false, false, d.Param.Pgsql.Ipv6); err != nil {
-		return err
-	}
-	for _, slave := range d.slaves {
-		if err := slave.Install(d.Param.Pgsql, false, true, d.Param.Pgsql.Ipv6); err != nil {
-			return err
-		}
-	}
-	return nil
+	return d.each(d.context(), "安装", func(inst *Instance) error {
+		return d.journal.Run(d.key(inst), "install", func() error {
+			return inst.Install(d.prepare(inst), false, inst != d.master, d.Param.Pgsql.Ipv6)
+		})
+	})
 }
 
 func (d *Deploy) UNInstall() {
 	logger.Infof("开始卸载清理\n")
-	if err := d.master.UNInstall(d.Param.Pgsql); err != nil {
-		logger.Warningf("卸载节点: %s 失败: %v\n", d.master.Host, err)
-	}
-	for _, slave := range d.slaves {
-		if err := slave.UNInstall(d.Param.Pgsql); err != nil {
-			logger.Warningf("卸载节点: %s 失败: %v\n", slave.Host, err)
-		}
+	// 卸载失败的节点由 parallel.Run 打印警告
+	_ = d.each(context.Background(), "卸载", func(inst *Instance) error {
+		return inst.UNInstall(d.prepare(inst))
+	})
+}
+
+// each 在主库和所有从库上并发执行 fn
+func (d *Deploy) each(ctx context.Context, name string, fn func(inst *Instance) error) error {
+	var tasks []parallel.Task
+	for _, inst := range append([]*Instance{d.master}, d.slaves...) {
+		inst := inst
+		tasks = append(tasks, parallel.Task{Host: inst.Host, Run: func() error { return fn(inst) }})
 	}
+	return parallel.Run(ctx, name, tasks)
 }
 
 func (d *Deploy) ReplicaSlave(PGReplPass string) error {
 	logger.Infof("初始化从库\n")
-	for _, slave := range d.slaves {
+	for i, slave := range d.slaves {
 		//if err := slave.SystemCtl("stop"); err != nil {
 		//	return err
 		//}
 		//if err := slave.RemoveData(); err != nil {
 		//	return err
 		//}
-		if err := slave.Replication(d.Param.Server.Master, PGReplPass); err != nil {
-			return err
-		}
-		if err := slave.ChownData(d.Param.Pgsql.SystemUser, d.Param.Pgsql.SystemGroup); err != nil {
+		if err := d.context().Err(); err != nil {
 			return err
 		}
-		if err := slave.SystemCtl("start"); err != nil {
+		slave, r := slave, d.Param.Replicas[i]
+		host, port := d.upstream(r)
+		logger.Infof("从库 %s 从 %s:%d 复制数据\n", r.Name, host, port)
+		if err := d.journal.Run(d.key(slave), "replication", func() error {
+			if err := slave.Replication(host, port, r.Name, PGReplPass); err != nil {
+				return err
+			}
+			if err := slave.ChownData(d.Param.Pgsql.SystemUser, d.Param.Pgsql.SystemGroup); err != nil {
+				return err
+			}
+			return slave.SystemCtl("start")
+		}); err != nil {
 			return err
 		}
 	}
@@ -9,11 +9,14 @@ import (
 	"bufio"
 	"dbup/internal/environment"
 	"dbup/internal/global"
+	"dbup/internal/inventory"
 	"dbup/internal/pgsql/config"
 	"dbup/internal/pgsql/dao"
+	"dbup/internal/tune"
 	"dbup/internal/utils"
 	"dbup/internal/utils/command"
 	"dbup/internal/utils/logger"
+	"dbup/internal/utils/prompt"
 	"errors"
 	"fmt"
 	"os"
@@ -81,18 +84,13 @@ func (i *Install) Run(pre config.Prepare, cfgFile, packageName string, onlyCheck
 	}
 
 	if !i.prepare.Yes {
-		var yes string
 		logger.Successf("端口: %d\n", i.port)
 		logger.Successf("用户: %s\n", i.prepare.Username)
 		logger.Successf("数据库名: %s\n", i.prepare.Username)
 		logger.Successf("安装路径: %s\n", i.basePath)
-		logger.Successf("是否确认安装[y|n]:")
-		if _, err := fmt.Scanln(&yes); err != nil {
+		if err := prompt.Confirm("是否确认安装"); err != nil {
 			return err
 		}
-		if strings.ToUpper(yes) != "Y" && strings.ToUpper(yes) != "YES" {
-			os.Exit(0)
-		}
 	}
 
 	if err := i.InstallAndInitDB(onlyInstall); err != nil {
@@ -128,7 +126,6 @@ func (i *Install) RunSlave(pre config.Prepare, masterNode string) error {
 	}
 
 	if !i.prepare.Yes {
-		var yes string
 		logger.Successf("\n")
 		logger.Successf("本次安装实例为从节点:\n")
 		logger.Successf("要同步数据的主库节点为: %s\n", masterNode)
@@ -137,13 +134,9 @@ func (i *Install) RunSlave(pre config.Prepare, masterNode string) error {
 		logger.Successf("用户: %s\n", i.prepare.Username)
 		logger.Successf("数据库名: %s\n", i.prepare.Username)
 		logger.Successf("安装路径: %s\n", i.basePath)
-		logger.Successf("是否确认安装[y|n]:")
-		if _, err := fmt.Scanln(&yes); err != nil {
+		if err := prompt.Confirm("是否确认安装"); err != nil {
 			return err
 		}
-		if strings.ToUpper(yes) != "Y" && strings.ToUpper(yes) != "YES" {
-			os.Exit(0)
-		}
 	}
 
 	if err := i.InstallSlave(master, port); err != nil {
@@ -158,6 +151,7 @@ func (i *Install) RunSlave(pre config.Prepare, masterNode string) error {
 	// if err := i.Info(); err != nil {
 	// 	return err
 	// }
+	i.record("slave")
 
 	return nil
 }
@@ -328,6 +322,9 @@ func (i *Install) InitAndCheckSlave() error {
 		return fmt.Errorf("端口号被占用: %d", i.prepare.Port)
 	}
 
+	if err := i.HandleVersion(); err != nil {
+		return err
+	}
 	i.HandleArgs("")
 
 	// if err := i.config.HandleConfig(i.prepare, filepath.Join(i.dataPath, "log")); err != nil {
@@ -374,6 +371,10 @@ func (i *Install) InitAndCheck(pre config.Prepare, cfgFile, packageName string)
 	if err := i.config.HandleConfig(i.prepare, filepath.Join(i.dataPath, "log")); err != nil {
 		return err
 	}
+	// 调优时按 shared_buffers 预留了大页, 优先使用大页
+	if i.prepare.Tune {
+		i.config.HugePages = "try"
+	}
 	//i.HandlePgHba()
 	if err := i.HandleSystemd(); err != nil {
 		return err
@@ -446,10 +447,36 @@ func (i *Install) MergePrepareArgs(pre config.Prepare) {
 	if pre.Libraries != "" {
 		i.prepare.Libraries = pre.Libraries
 	}
+	if pre.Version != "" {
+		i.prepare.Version = pre.Version
+	}
 
 	if pre.Yes {
 		i.prepare.Yes = true
 	}
+	if pre.Tune {
+		i.prepare.Tune = true
+	}
+
+	if pre.WalArchive != "" {
+		i.prepare.WalArchive = pre.WalArchive
+	}
+	if pre.WalArchiveCompress {
+		i.prepare.WalArchiveCompress = true
+	}
+	if pre.WalArchiveEndpoint != "" {
+		i.prepare.WalArchiveEndpoint = pre.WalArchiveEndpoint
+	}
+	if pre.WalArchiveAccessKey != "" {
+		i.prepare.WalArchiveAccessKey = pre.WalArchiveAccessKey
+	}
+	if pre.WalArchiveSecretKey != "" {
+		i.prepare.WalArchiveSecretKey = pre.WalArchiveSecretKey
+	}
+	if pre.WalArchiveS3Mode != "" {
+		i.prepare.WalArchiveS3Mode = pre.WalArchiveS3Mode
+	}
+
 	if pre.NoRollback {
 		i.prepare.NoRollback = true
 	}
@@ -483,6 +510,17 @@ func (i *Install) HandlePrepareArgs(pre config.Prepare, cfgFile string) error {
 
 	i.MergePrepareArgs(pre)
 	i.prepare.InitArgs()
+	return i.HandleVersion()
+}
+
+// HandleVersion 检查要安装的版本
+func (i *Install) HandleVersion() error {
+	version, err := config.CheckVersion(i.prepare.Version)
+	if err != nil {
+		return err
+	}
+	i.prepare.Version = version
+	i.version = version
 	return nil
 }
 
@@ -494,7 +532,7 @@ func (i *Install) HandleArgs(packageName string) {
 
 	i.packageFullName = packageName
 	if i.packageFullName == "" {
-		i.packageFullName = filepath.Join(environment.GlobalEnv().ProgramPath, global.PackagePath, config.Kinds, fmt.Sprintf(config.PackageFile, config.DefaultPGVersion, environment.GlobalEnv().GOOS, environment.GlobalEnv().GOARCH))
+		i.packageFullName = filepath.Join(environment.GlobalEnv().ProgramPath, global.PackagePath, config.Kinds, config.PackageName(i.version))
 	}
 
 	i.port = i.prepare.Port
@@ -597,7 +635,7 @@ func (i *Install) HandleArgs(packageName string) {
 
 func (i *Install) HandleSystemd() error {
 	var err error
-	if i.service, err = config.NewPostgresService(filepath.Join(environment.GlobalEnv().ProgramPath, global.ServiceTemplatePath, config.PostgresServiceTemplateFile)); err != nil {
+	if i.service, err = config.NewPostgresService(filepath.Join(environment.GlobalEnv().ProgramPath, global.ServiceTemplatePath, global.ServiceTemplateFile(config.PostgresServiceTemplateFile, i.version))); err != nil {
 		return err
 	}
 
@@ -736,6 +774,21 @@ func (i *Install) Mkdir() error {
 	return nil
 }
 
+// record 记录到实例清单, 失败不影响安装结果
+func (i *Install) record(role string) {
+	if err := inventory.Record(inventory.Instance{
+		Engine:  config.Kinds,
+		Version: config.InfoVersion(i.version),
+		Port:    i.port,
+		Dir:     i.basePath,
+		DataDir: i.dataPath,
+		Role:    role,
+		Service: i.serviceFileName,
+	}); err != nil {
+		logger.Warningf("更新实例清单失败: %v\n", err)
+	}
+}
+
 func (i *Install) ChownDir(path string) error {
 	cmd := fmt.Sprintf("chown -R %s:%s %s", i.adminUser, i.adminGroup, path)
 	l := command.Local{}
@@ -882,6 +935,12 @@ func (i *Install) Install() error {
 		}
 	}
 
+	if i.prepare.WalArchive != "" {
+		if err := i.SetupWalArchive(); err != nil {
+			return err
+		}
+	}
+
 	if err := i.ChownDir(i.basePath); err != nil {
 		return err
 	}
@@ -889,6 +948,39 @@ func (i *Install) Install() error {
 	return i.SystemdInit()
 }
 
+// SetupWalArchive 把 dbup 复制到程序目录, 生成 WAL 归档配置, archive_command 通过它们归档
+func (i *Install) SetupWalArchive() error {
+	logger.Infof("配置 WAL 归档: %s\n", i.prepare.WalArchive)
+	if err := i.CopyDbup(); err != nil {
+		return err
+	}
+
+	c := config.NewWalArchiveConfig(i.prepare)
+	if !c.IsS3() {
+		if err := os.MkdirAll(c.Target, 0700); err != nil {
+			return err
+		}
+		if err := i.ChownDir(c.Target); err != nil {
+			return err
+		}
+	}
+	return c.SaveTo(config.WalArchiveConfPath(i.basePath))
+}
+
+// CopyDbup 把当前的 dbup 复制到程序目录, 供 archive_command 和 restore_command 使用
+func (i *Install) CopyDbup() error {
+	target := filepath.Join(i.serverBinPath, config.DbupFileName)
+	if environment.GlobalEnv().Program == target {
+		return nil
+	}
+	cmd := fmt.Sprintf("cp -f %s %s", environment.GlobalEnv().Program, target)
+	l := command.Local{}
+	if _, stderr, err := l.Run(cmd); err != nil {
+		return fmt.Errorf("复制 dbup 到程序目录失败: %v, 标准错误输出: %s", err, stderr)
+	}
+	return os.Chmod(target, 0755)
+}
+
 func (i *Install) LibComplement(NoLiblist []global.MissSoLibrariesfile) error {
 	LibList := []string{"libssl.so.10", "libcrypto.so.10", "libtinfo.so.5", "libncurses.so.5"}
 	SySLibs := []string{"/lib64", "/lib"}
@@ -955,6 +1047,12 @@ func (i *Install) SystemdInit() error {
 			return err
 		}
 	}
+
+	if i.prepare.Tune {
+		if err := tune.ApplyService("pgsql", i.serviceFileName, i.prepare.MemorySize); err != nil {
+			return err
+		}
+	}
 	// }
 	return nil
 }
@@ -1095,18 +1193,21 @@ func (i *Install) CreateDBUser() error {
 func (i *Install) Info() error {
 	filename := filepath.Join(environment.GlobalEnv().DbupInfoPath, fmt.Sprintf("%s%d", config.Kinds, i.port))
 	info := config.PgsqlInfo{
-		Port:      i.port,
-		Host:      "127.0.0.1",
-		Socket:    config.DefaultPGSocketPath,
-		Username:  i.prepare.Username,
-		Password:  i.prepare.Password,
-		Database:  i.prepare.Username,
-		DeployDir: i.serverPath,
-		DataDir:   i.dataPath,
+		Port:          i.port,
+		Host:          "127.0.0.1",
+		Socket:        config.DefaultPGSocketPath,
+		Username:      i.prepare.Username,
+		Password:      i.prepare.Password,
+		AdminUser:     i.adminUser,
+		AdminPassword: i.adminPassword,
+		Database:      i.prepare.Username,
+		DeployDir:     i.serverPath,
+		DataDir:       i.dataPath,
 	}
 	if err := info.SlaveTo(filename); err != nil {
 		return err
 	}
+	i.record("master")
 
 	//logger.Successf("\n")
 	logger.Successf("PG初始化[完成]\n")
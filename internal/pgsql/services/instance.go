@@ -7,8 +7,10 @@ package services
 
 import (
 	"dbup/internal/environment"
+	"dbup/internal/global"
 	"dbup/internal/pgsql/config"
 	"dbup/internal/utils/command"
+	"dbup/internal/utils/sshutil"
 	"fmt"
 	"path"
 	"path/filepath"
@@ -16,17 +18,18 @@ import (
 )
 
 type Instance struct {
-	DbupCmd string
-	Host    string
-	NodeID  int
-	TmpDir  string
-	Inst    *Install
-	Conn    *command.Connection
+	DbupCmd  string
+	Host     string
+	NodeID   int
+	TmpDir   string
+	Inst     *Install
+	Conn     *command.Connection
+	Platform *global.Platform // 目标机器的平台, 检查临时目录时探测
 	//spool
 }
 
-func NewInstance(tmp, host, user, password string, port int, pre config.Prepare, nodeID int) (*Instance, error) {
-	conn, err := command.NewConnection(host, user, password, port, 30)
+func NewInstance(tmp, host, user, password string, port int, pre config.Prepare, nodeID int, opts ...sshutil.Options) (*Instance, error) {
+	conn, err := command.NewConnection(host, user, password, port, 30, opts...)
 	if err != nil {
 		return nil, fmt.Errorf("在机器: %s 上, 建立ssh连接失败: %v", host, err)
 	}
@@ -41,8 +44,8 @@ func NewInstance(tmp, host, user, password string, port int, pre config.Prepare,
 	return &Instance{DbupCmd: cmd, Host: host, NodeID: nodeID, TmpDir: tmp, Inst: inst, Conn: conn}, nil
 }
 
-func NewInstanceUseKeyFile(tmp, host, user, keyfile string, port int, pre config.Prepare, nodeID int) (*Instance, error) {
-	conn, err := command.NewConnectionUseKeyFile(host, user, keyfile, port, 30)
+func NewInstanceUseKeyFile(tmp, host, user, keyfile string, port int, pre config.Prepare, nodeID int, opts ...sshutil.Options) (*Instance, error) {
+	conn, err := command.NewConnectionUseKeyFile(host, user, keyfile, port, 30, opts...)
 	if err != nil {
 		return nil, fmt.Errorf("在机器: %s 上, 建立ssh连接失败: %v", host, err)
 	}
@@ -58,6 +61,11 @@ func NewInstanceUseKeyFile(tmp, host, user, keyfile string, port int, pre config
 }
 
 func (i *Instance) CheckTmpDir() error {
+	// 先确认所有目标机器都有对应平台的安装包, 再开始复制
+	if _, _, err := i.CheckPlatform(path.Join(environment.GlobalEnv().ProgramPath, "..")); err != nil {
+		return err
+	}
+
 	if i.Conn.IsExists(filepath.ToSlash(i.TmpDir)) {
 		if !i.Conn.IsDir(filepath.ToSlash(i.TmpDir)) {
 			return fmt.Errorf("在机器: %s 上, 目标文件(%s)已经存在", i.Host, i.TmpDir)
@@ -74,6 +82,11 @@ func (i *Instance) CheckTmpDir() error {
 }
 
 func (i *Instance) Scp(source string) error {
+	source, pgsqlPackage, err := i.CheckPlatform(source)
+	if err != nil {
+		return err
+	}
+
 	if err := i.Conn.MkdirAll(filepath.ToSlash(path.Join(i.TmpDir, "bin"))); err != nil {
 		return fmt.Errorf("在机器: %s 上, 创建目录(%s)失败: %v", i.Host, filepath.ToSlash(path.Join(i.TmpDir, "bin")), err)
 	}
@@ -92,16 +105,28 @@ func (i *Instance) Scp(source string) error {
 	if err := i.Conn.Scp(path.Join(source, "package", "md5"), filepath.ToSlash(path.Join(i.TmpDir, "package", "md5"))); err != nil {
 		return fmt.Errorf("在机器: %s 上, scp文件(%s)失败: %v", i.Host, filepath.ToSlash(path.Join(i.TmpDir, "package", "md5")), err)
 	}
+	for _, f := range global.ManifestFiles(path.Join(source, "package")) {
+		if err := i.Conn.Scp(path.Join(source, "package", f), filepath.ToSlash(path.Join(i.TmpDir, "package", f))); err != nil {
+			return fmt.Errorf("在机器: %s 上, scp文件(%s)失败: %v", i.Host, filepath.ToSlash(path.Join(i.TmpDir, "package", f)), err)
+		}
+	}
 
-	if err := i.Conn.Scp(path.Join(source, "systemd", config.PostgresServiceTemplateFile), filepath.ToSlash(path.Join(i.TmpDir, "systemd", config.PostgresServiceTemplateFile))); err != nil {
-		return fmt.Errorf("在机器: %s 上, scp文件(%s)失败: %v", i.Host, filepath.ToSlash(path.Join(i.TmpDir, "systemd", config.PostgresServiceTemplateFile)), err)
+	template := global.ServiceTemplateFile(config.PostgresServiceTemplateFile, i.Inst.version)
+	if err := i.Conn.Scp(path.Join(source, "systemd", template), filepath.ToSlash(path.Join(i.TmpDir, "systemd", template))); err != nil {
+		return fmt.Errorf("在机器: %s 上, scp文件(%s)失败: %v", i.Host, filepath.ToSlash(path.Join(i.TmpDir, "systemd", template)), err)
 	}
 
-	// TODO: 根据目标机器的操作系统类型选择包
-	pgsqlPackage := fmt.Sprintf("pgsql12_%s_%s.tar.gz", environment.GlobalEnv().GOOS, environment.GlobalEnv().GOARCH)
 	if err := i.Conn.Scp(path.Join(source, "package", "pgsql", pgsqlPackage), filepath.ToSlash(path.Join(i.TmpDir, "package", "pgsql", pgsqlPackage))); err != nil {
 		return fmt.Errorf("在机器: %s 上, scp文件(%s)失败: %v", i.Host, filepath.ToSlash(path.Join(i.TmpDir, "package", "pgsql", pgsqlPackage)), err)
 	}
+	// 复制后在远程机器上校验安装包, 远程安装时还会再按清单校验一次
+	run := func(cmd string) (string, error) {
+		out, err := i.Conn.Run(cmd)
+		return string(out), err
+	}
+	if err := global.VerifyRemotePackage(run, source, config.Kinds, filepath.ToSlash(path.Join(i.TmpDir, "package", "pgsql", pgsqlPackage))); err != nil {
+		return fmt.Errorf("在机器: %s 上, %v", i.Host, err)
+	}
 
 	if err := i.Conn.Chmod(filepath.ToSlash(path.Join(i.TmpDir, "bin", i.DbupCmd)), 0755); err != nil {
 		return fmt.Errorf("在机器: %s 上, chmod目录(%s)权限失败: %v", i.Host, filepath.ToSlash(path.Join(i.TmpDir, "bin", i.DbupCmd)), err)
@@ -109,6 +134,13 @@ func (i *Instance) Scp(source string) error {
 	return nil
 }
 
+// CheckPlatform 探测目标机器的操作系统和架构, 返回对应平台的安装目录和安装包文件名
+func (i *Instance) CheckPlatform(source string) (string, string, error) {
+	return global.CheckPlatform(i.Host, &i.Platform, i.Conn.Run, source, config.Kinds, func(p *global.Platform) string {
+		return config.PlatformPackageName(i.Inst.version, p)
+	})
+}
+
 func (i *Instance) DropTmpDir() error {
 	cmd := fmt.Sprintf("cd %s; rm -rf *", filepath.ToSlash(i.TmpDir))
 	if stdout, err := i.Conn.Run(cmd); err != nil {
@@ -118,13 +150,12 @@ func (i *Instance) DropTmpDir() error {
 }
 
 func (i *Instance) Install(p config.Prepare, onlyCheck, onlyInstall bool, ipv6 bool) error {
-	cmd := fmt.Sprintf("%s pgsql install --yes --port=%d --admin-password='%s' --admin-password-expire-at='%s' --username='%s' --password='%s' --memory-size='%s' --dir='%s' --bind-ip='%s' --address='%s' --libraries='%s' --system-user='%s' --system-group='%s'  --resource-limit='%s' --log='%s'",
+	cmd := fmt.Sprintf("%s pgsql install --yes --version='%s' --port=%d --admin-password-expire-at='%s' --username='%s' --memory-size='%s' --dir='%s' --bind-ip='%s' --address='%s' --libraries='%s' --system-user='%s' --system-group='%s'  --resource-limit='%s' --log='%s'",
 		i.DbupCmd,
+		i.Inst.version,
 		p.Port,
-		p.AdminPassword,
 		p.AdminPasswordExpireAt,
 		p.Username,
-		p.Password,
 		p.MemorySize,
 		p.Dir,
 		p.BindIP,
@@ -146,17 +177,35 @@ func (i *Instance) Install(p config.Prepare, onlyCheck, onlyInstall bool, ipv6 b
 	if ipv6 {
 		cmd = cmd + " --ipv6"
 	}
+
+	if p.Tune {
+		cmd = cmd + " --tune"
+	}
+
+	secrets := map[string]string{"admin-password": p.AdminPassword, "password": p.Password}
+	if p.WalArchive != "" {
+		cmd = cmd + fmt.Sprintf(" --wal-archive='%s' --wal-archive-endpoint='%s' --wal-archive-access-key='%s' --wal-archive-s3-mode='%s'",
+			p.WalArchive,
+			p.WalArchiveEndpoint,
+			p.WalArchiveAccessKey,
+			p.WalArchiveS3Mode)
+		if p.WalArchiveCompress {
+			cmd = cmd + " --wal-archive-compress"
+		}
+		if p.WalArchiveSecretKey != "" {
+			secrets["wal-archive-secret-key"] = p.WalArchiveSecretKey
+		}
+	}
 	cmd = path.Join(i.TmpDir, "bin", cmd)
-	if stdout, err := i.Conn.Sudo(cmd, "", ""); err != nil {
+	if stdout, err := i.Conn.SudoWithSecrets(cmd, secrets); err != nil {
 		return fmt.Errorf("在机器: %s 上, 执行(%s)失败: %v, 标准输出: %s", i.Host, cmd, err, stdout)
 	}
 	return nil
 }
 
 func (i *Instance) PrimaryInstall(p config.Prepare, onlyCheck bool) error {
-	cmd := fmt.Sprintf("%s  pgsql-mha PrimaryInstall --yes --admin-password='%s' --dir='%s' --port=%d --log='%s'",
+	cmd := fmt.Sprintf("%s  pgsql-mha PrimaryInstall --yes --dir='%s' --port=%d --log='%s'",
 		i.DbupCmd,
-		p.AdminPassword,
 		p.Dir,
 		p.Port,
 		filepath.ToSlash(path.Join(environment.GlobalEnv().HomePath, "dbup_pgsql_install.log")))
@@ -166,7 +215,7 @@ func (i *Instance) PrimaryInstall(p config.Prepare, onlyCheck bool) error {
 	}
 
 	cmd = path.Join(i.TmpDir, "bin", cmd)
-	if stdout, err := i.Conn.Sudo(cmd, "", ""); err != nil {
+	if stdout, err := i.Conn.SudoWithSecrets(cmd, map[string]string{"admin-password": p.AdminPassword}); err != nil {
 		return fmt.Errorf("在机器: %s 上, 执行(%s)失败: %v, 标准输出: %s", i.Host, cmd, err, stdout)
 	}
 
@@ -174,11 +223,11 @@ func (i *Instance) PrimaryInstall(p config.Prepare, onlyCheck bool) error {
 }
 
 func (i *Instance) InstallSlave(p config.Prepare, master string) error {
-	cmd := fmt.Sprintf("%s pgsql install-slave --yes --port=%d --username='%s' --password='%s' --dir='%s' --master='%s' --system-user='%s' --system-group='%s' --resource-limit='%s' --log='%s'",
+	cmd := fmt.Sprintf("%s pgsql install-slave --yes --version='%s' --port=%d --username='%s' --dir='%s' --master='%s' --system-user='%s' --system-group='%s' --resource-limit='%s' --log='%s'",
 		i.DbupCmd,
+		i.Inst.version,
 		p.Port,
 		p.Username,
-		p.Password,
 		p.Dir,
 		master,
 		p.SystemUser,
@@ -186,8 +235,77 @@ func (i *Instance) InstallSlave(p config.Prepare, master string) error {
 		p.ResourceLimit,
 		filepath.ToSlash(path.Join(environment.GlobalEnv().HomePath, "dbup_pgsql_install.log")))
 
+	if p.Tune {
+		cmd = cmd + " --tune"
+	}
 	cmd = path.Join(i.TmpDir, "bin", cmd)
-	if stdout, err := i.Conn.Sudo(cmd, "", ""); err != nil {
+	if stdout, err := i.Conn.SudoWithSecrets(cmd, map[string]string{"password": p.Password}); err != nil {
+		return fmt.Errorf("在机器: %s 上, 执行(%s)失败: %v, 标准输出: %s", i.Host, cmd, err, stdout)
+	}
+	return nil
+}
+
+// BackupVersion 读取远程机器上备份目录中的 PG_VERSION
+func (i *Instance) BackupVersion(backupDir string) (string, error) {
+	cmd := fmt.Sprintf("cat '%s'", path.Join(backupDir, PGVersionFile))
+	stdout, err := i.Conn.Sudo(cmd, "", "")
+	if err != nil {
+		return "", fmt.Errorf("在机器: %s 上, 备份目录(%s)不是 pg_basebackup 的 plain 格式备份: %v", i.Host, backupDir, err)
+	}
+	return strings.TrimSpace(string(stdout)), nil
+}
+
+// Restore 在远程机器上执行 dbup pgsql restore
+func (i *Instance) Restore(r *Restore) error {
+	p := r.Prepare
+	cmd := fmt.Sprintf("%s pgsql restore --yes --backup-dir='%s' --version='%s' --port=%d --dir='%s' --system-user='%s' --system-group='%s' --memory-size='%s' --bind-ip='%s' --resource-limit='%s' --wait=%d --log='%s'",
+		i.DbupCmd,
+		r.BackupDir,
+		i.Inst.version,
+		p.Port,
+		p.Dir,
+		p.SystemUser,
+		p.SystemGroup,
+		p.MemorySize,
+		p.BindIP,
+		p.ResourceLimit,
+		r.Wait,
+		filepath.ToSlash(path.Join(environment.GlobalEnv().HomePath, "dbup_pgsql_restore.log")))
+
+	if r.KeepStandby {
+		cmd = cmd + " --keep-standby"
+	}
+	if p.Tune {
+		cmd = cmd + " --tune"
+	}
+	if p.NoRollback {
+		cmd = cmd + " --no-rollback"
+	}
+	if r.WalArchiveConfig != "" {
+		cmd = cmd + fmt.Sprintf(" --wal-archive-config='%s' --target-time='%s' --target-lsn='%s' --target-xid='%s' --target-action='%s'",
+			r.WalArchiveConfig,
+			r.TargetTime,
+			r.TargetLSN,
+			r.TargetXID,
+			r.TargetAction)
+	}
+
+	secrets := map[string]string{}
+	if p.WalArchive != "" {
+		cmd = cmd + fmt.Sprintf(" --wal-archive='%s' --wal-archive-endpoint='%s' --wal-archive-access-key='%s' --wal-archive-s3-mode='%s'",
+			p.WalArchive,
+			p.WalArchiveEndpoint,
+			p.WalArchiveAccessKey,
+			p.WalArchiveS3Mode)
+		if p.WalArchiveCompress {
+			cmd = cmd + " --wal-archive-compress"
+		}
+		if p.WalArchiveSecretKey != "" {
+			secrets["wal-archive-secret-key"] = p.WalArchiveSecretKey
+		}
+	}
+	cmd = path.Join(i.TmpDir, "bin", cmd)
+	if stdout, err := i.Conn.SudoWithSecrets(cmd, secrets); err != nil {
 		return fmt.Errorf("在机器: %s 上, 执行(%s)失败: %v, 标准输出: %s", i.Host, cmd, err, stdout)
 	}
 	return nil
@@ -251,29 +369,26 @@ func (i *Instance) UNInstall(p config.Prepare) error {
 //}
 
 func (i *Instance) CreateReplUser(slaves, PGReplPass string) error {
-	cmd1 := fmt.Sprintf("%s pgsql user create --host='%s' --port=%d --admin-user='%s' --admin-password='%s' --admin-database='%s' --user='%s' --password='%s' --role='%s' --log='%s'",
+	cmd1 := fmt.Sprintf("%s pgsql user create --host='%s' --port=%d --admin-user='%s' --admin-database='%s' --user='%s' --role='%s' --log='%s'",
 		i.DbupCmd,
 		config.DefaultPGSocketPath,
 		i.Inst.port,
 		i.Inst.prepare.SystemUser,
-		i.Inst.adminPassword,
 		config.DefaultPGAdminUser,
 		config.DefaultPGReplUser,
-		PGReplPass,
 		"replication",
 		filepath.ToSlash(path.Join(environment.GlobalEnv().HomePath, "dbup_pgsql_uninstall.log")))
 
 	cmd1 = path.Join(i.TmpDir, "bin", cmd1)
-	if stdout, err := i.Conn.Sudo(cmd1, "", ""); err != nil {
+	if stdout, err := i.Conn.SudoWithSecrets(cmd1, map[string]string{"admin-password": i.Inst.adminPassword, "password": PGReplPass}); err != nil {
 		return fmt.Errorf("在机器: %s 上, 执行(%s)失败: %v, 标准输出: %s", i.Host, cmd1, err, stdout)
 	}
 
-	cmd2 := fmt.Sprintf("%s pgsql user grant --host='%s' --port=%d --admin-user='%s' --admin-password='%s' --admin-database='%s' --user='%s' --dbname='%s' --address='%s' --log='%s'",
+	cmd2 := fmt.Sprintf("%s pgsql user grant --host='%s' --port=%d --admin-user='%s' --admin-database='%s' --user='%s' --dbname='%s' --address='%s' --log='%s'",
 		i.DbupCmd,
 		config.DefaultPGSocketPath,
 		i.Inst.port,
 		i.Inst.prepare.SystemUser,
-		i.Inst.adminPassword,
 		config.DefaultPGAdminUser,
 		config.DefaultPGReplUser,
 		"replication",
@@ -281,16 +396,15 @@ func (i *Instance) CreateReplUser(slaves, PGReplPass string) error {
 		filepath.ToSlash(path.Join(environment.GlobalEnv().HomePath, "dbup_pgsql_uninstall.log")))
 
 	cmd2 = path.Join(i.TmpDir, "bin", cmd2)
-	if stdout, err := i.Conn.Sudo(cmd2, "", ""); err != nil {
+	if stdout, err := i.Conn.SudoWithSecrets(cmd2, map[string]string{"admin-password": i.Inst.adminPassword}); err != nil {
 		return fmt.Errorf("在机器: %s 上, 执行(%s)失败: %v, 标准输出: %s", i.Host, cmd2, err, stdout)
 	}
 
-	cmd3 := fmt.Sprintf("%s pgsql user grant --host='%s' --port=%d --admin-user='%s' --admin-password='%s' --admin-database='%s' --user='%s' --dbname='%s' --address='%s' --log='%s'",
+	cmd3 := fmt.Sprintf("%s pgsql user grant --host='%s' --port=%d --admin-user='%s' --admin-database='%s' --user='%s' --dbname='%s' --address='%s' --log='%s'",
 		i.DbupCmd,
 		config.DefaultPGSocketPath,
 		i.Inst.port,
 		i.Inst.prepare.SystemUser,
-		i.Inst.adminPassword,
 		config.DefaultPGAdminUser,
 		config.DefaultPGReplUser,
 		"replication",
@@ -298,19 +412,18 @@ func (i *Instance) CreateReplUser(slaves, PGReplPass string) error {
 		filepath.ToSlash(path.Join(environment.GlobalEnv().HomePath, "dbup_pgsql_uninstall.log")))
 
 	cmd3 = path.Join(i.TmpDir, "bin", cmd3)
-	if stdout, err := i.Conn.Sudo(cmd3, "", ""); err != nil {
+	if stdout, err := i.Conn.SudoWithSecrets(cmd3, map[string]string{"admin-password": i.Inst.adminPassword}); err != nil {
 		return fmt.Errorf("在机器: %s 上, 执行(%s)失败: %v, 标准输出: %s", i.Host, cmd3, err, stdout)
 	}
 	return nil
 }
 
 func (i *Instance) UserGrant(user, dbname, ips string) error {
-	cmd2 := fmt.Sprintf("%s pgsql user grant --host='%s' --port=%d --admin-user='%s' --admin-password='%s' --admin-database='%s' --user='%s' --dbname='%s' --address='%s' --log='%s'",
+	cmd2 := fmt.Sprintf("%s pgsql user grant --host='%s' --port=%d --admin-user='%s' --admin-database='%s' --user='%s' --dbname='%s' --address='%s' --log='%s'",
 		i.DbupCmd,
 		config.DefaultPGSocketPath,
 		i.Inst.port,
 		i.Inst.prepare.SystemUser,
-		i.Inst.adminPassword,
 		config.DefaultPGAdminUser,
 		user,
 		dbname,
@@ -318,7 +431,7 @@ func (i *Instance) UserGrant(user, dbname, ips string) error {
 		filepath.ToSlash(path.Join(environment.GlobalEnv().HomePath, "dbup_pgsql_uninstall.log")))
 
 	cmd2 = path.Join(i.TmpDir, "bin", cmd2)
-	if stdout, err := i.Conn.Sudo(cmd2, "", ""); err != nil {
+	if stdout, err := i.Conn.SudoWithSecrets(cmd2, map[string]string{"admin-password": i.Inst.adminPassword}); err != nil {
 		return fmt.Errorf("在机器: %s 上, 执行(%s)失败: %v, 标准输出: %s", i.Host, cmd2, err, stdout)
 	}
 
@@ -327,45 +440,41 @@ func (i *Instance) UserGrant(user, dbname, ips string) error {
 
 func (i *Instance) CreateRepmgrUser(slaves string) error {
 
-	cmd0 := fmt.Sprintf("%s pgsql database create --host='%s' --port=%d --admin-user='%s' --admin-password='%s' --admin-database='%s' --dbname='%s' --log='%s'",
+	cmd0 := fmt.Sprintf("%s pgsql database create --host='%s' --port=%d --admin-user='%s' --admin-database='%s' --dbname='%s' --log='%s'",
 		i.DbupCmd,
 		config.DefaultPGSocketPath,
 		i.Inst.port,
 		i.Inst.prepare.SystemUser,
-		i.Inst.adminPassword,
 		config.DefaultPGAdminUser,
 		i.Inst.prepare.RepmgrDBName,
 		filepath.ToSlash(path.Join(environment.GlobalEnv().HomePath, "dbup_pgsql_uninstall.log")))
 
 	cmd0 = path.Join(i.TmpDir, "bin", cmd0)
-	if stdout, err := i.Conn.Sudo(cmd0, "", ""); err != nil {
+	if stdout, err := i.Conn.SudoWithSecrets(cmd0, map[string]string{"admin-password": i.Inst.adminPassword}); err != nil {
 		return fmt.Errorf("在机器: %s 上, 执行(%s)失败: %v, 标准输出: %s", i.Host, cmd0, err, stdout)
 	}
 
-	cmd1 := fmt.Sprintf("%s pgsql user create --host='%s' --port=%d --admin-user='%s' --admin-password='%s' --admin-database='%s' --user='%s' --password='%s' --role='%s' --log='%s'",
+	cmd1 := fmt.Sprintf("%s pgsql user create --host='%s' --port=%d --admin-user='%s' --admin-database='%s' --user='%s' --role='%s' --log='%s'",
 		i.DbupCmd,
 		config.DefaultPGSocketPath,
 		i.Inst.port,
 		i.Inst.prepare.SystemUser,
-		i.Inst.adminPassword,
 		config.DefaultPGAdminUser,
 		i.Inst.prepare.RepmgrUser,
-		i.Inst.prepare.RepmgrPassword,
 		"admin",
 		filepath.ToSlash(path.Join(environment.GlobalEnv().HomePath, "dbup_pgsql_uninstall.log")))
 
 	cmd1 = path.Join(i.TmpDir, "bin", cmd1)
-	if stdout, err := i.Conn.Sudo(cmd1, "", ""); err != nil {
+	if stdout, err := i.Conn.SudoWithSecrets(cmd1, map[string]string{"admin-password": i.Inst.adminPassword, "password": i.Inst.prepare.RepmgrPassword}); err != nil {
 		return fmt.Errorf("在机器: %s 上, 执行(%s)失败: %v, 标准输出: %s", i.Host, cmd1, err, stdout)
 	}
 
 	addr := "local,127.0.0.1," + i.Host + "," + slaves
-	cmd2 := fmt.Sprintf("%s pgsql user grant --host='%s' --port=%d --admin-user='%s' --admin-password='%s' --admin-database='%s' --user='%s' --dbname='%s' --address='%s' --log='%s'",
+	cmd2 := fmt.Sprintf("%s pgsql user grant --host='%s' --port=%d --admin-user='%s' --admin-database='%s' --user='%s' --dbname='%s' --address='%s' --log='%s'",
 		i.DbupCmd,
 		config.DefaultPGSocketPath,
 		i.Inst.port,
 		i.Inst.prepare.SystemUser,
-		i.Inst.adminPassword,
 		config.DefaultPGAdminUser,
 		i.Inst.prepare.RepmgrUser,
 		"replication",
@@ -373,16 +482,15 @@ func (i *Instance) CreateRepmgrUser(slaves string) error {
 		filepath.ToSlash(path.Join(environment.GlobalEnv().HomePath, "dbup_pgsql_uninstall.log")))
 
 	cmd2 = path.Join(i.TmpDir, "bin", cmd2)
-	if stdout, err := i.Conn.Sudo(cmd2, "", ""); err != nil {
+	if stdout, err := i.Conn.SudoWithSecrets(cmd2, map[string]string{"admin-password": i.Inst.adminPassword}); err != nil {
 		return fmt.Errorf("在机器: %s 上, 执行(%s)失败: %v, 标准输出: %s", i.Host, cmd2, err, stdout)
 	}
 
-	cmd3 := fmt.Sprintf("%s pgsql user grant --host='%s' --port=%d --admin-user='%s' --admin-password='%s' --admin-database='%s' --user='%s' --dbname='%s' --address='%s' --log='%s'",
+	cmd3 := fmt.Sprintf("%s pgsql user grant --host='%s' --port=%d --admin-user='%s' --admin-database='%s' --user='%s' --dbname='%s' --address='%s' --log='%s'",
 		i.DbupCmd,
 		config.DefaultPGSocketPath,
 		i.Inst.port,
 		i.Inst.prepare.SystemUser,
-		i.Inst.adminPassword,
 		config.DefaultPGAdminUser,
 		i.Inst.prepare.RepmgrUser,
 		i.Inst.prepare.RepmgrDBName,
@@ -390,7 +498,7 @@ func (i *Instance) CreateRepmgrUser(slaves string) error {
 		filepath.ToSlash(path.Join(environment.GlobalEnv().HomePath, "dbup_pgsql_uninstall.log")))
 
 	cmd3 = path.Join(i.TmpDir, "bin", cmd3)
-	if stdout, err := i.Conn.Sudo(cmd3, "", ""); err != nil {
+	if stdout, err := i.Conn.SudoWithSecrets(cmd3, map[string]string{"admin-password": i.Inst.adminPassword}); err != nil {
 		return fmt.Errorf("在机器: %s 上, 执行(%s)失败: %v, 标准输出: %s", i.Host, cmd3, err, stdout)
 	}
 
@@ -398,12 +506,11 @@ func (i *Instance) CreateRepmgrUser(slaves string) error {
 }
 
 func (i *Instance) GrantRepmgrSlaveUser(slaves string) error {
-	cmd1 := fmt.Sprintf("%s pgsql user grant --host='%s' --port=%d --admin-user='%s' --admin-password='%s' --admin-database='%s' --user='%s' --dbname='%s' --address='%s' --log='%s'",
+	cmd1 := fmt.Sprintf("%s pgsql user grant --host='%s' --port=%d --admin-user='%s' --admin-database='%s' --user='%s' --dbname='%s' --address='%s' --log='%s'",
 		i.DbupCmd,
 		config.DefaultPGSocketPath,
 		i.Inst.port,
 		i.Inst.prepare.SystemUser,
-		i.Inst.adminPassword,
 		config.DefaultPGAdminUser,
 		i.Inst.prepare.RepmgrUser,
 		"replication",
@@ -411,16 +518,15 @@ func (i *Instance) GrantRepmgrSlaveUser(slaves string) error {
 		filepath.ToSlash(path.Join(environment.GlobalEnv().HomePath, "dbup_pgsql_install.log")))
 
 	cmd1 = path.Join(i.TmpDir, "bin", cmd1)
-	if stdout, err := i.Conn.Sudo(cmd1, "", ""); err != nil {
+	if stdout, err := i.Conn.SudoWithSecrets(cmd1, map[string]string{"admin-password": i.Inst.adminPassword}); err != nil {
 		return fmt.Errorf("在机器: %s 上, 执行(%s)失败: %v, 标准输出: %s", i.Host, cmd1, err, stdout)
 	}
 
-	cmd2 := fmt.Sprintf("%s pgsql user grant --host='%s' --port=%d --admin-user='%s' --admin-password='%s' --admin-database='%s' --user='%s' --dbname='%s' --address='%s' --log='%s'",
+	cmd2 := fmt.Sprintf("%s pgsql user grant --host='%s' --port=%d --admin-user='%s' --admin-database='%s' --user='%s' --dbname='%s' --address='%s' --log='%s'",
 		i.DbupCmd,
 		config.DefaultPGSocketPath,
 		i.Inst.port,
 		i.Inst.prepare.SystemUser,
-		i.Inst.adminPassword,
 		config.DefaultPGAdminUser,
 		i.Inst.prepare.RepmgrUser,
 		i.Inst.prepare.RepmgrDBName,
@@ -428,57 +534,62 @@ func (i *Instance) GrantRepmgrSlaveUser(slaves string) error {
 		filepath.ToSlash(path.Join(environment.GlobalEnv().HomePath, "dbup_pgsql_install.log")))
 
 	cmd2 = path.Join(i.TmpDir, "bin", cmd2)
-	if stdout, err := i.Conn.Sudo(cmd2, "", ""); err != nil {
+	if stdout, err := i.Conn.SudoWithSecrets(cmd2, map[string]string{"admin-password": i.Inst.adminPassword}); err != nil {
 		return fmt.Errorf("在机器: %s 上, 执行(%s)失败: %v, 标准输出: %s", i.Host, cmd2, err, stdout)
 	}
 
 	return nil
 }
 
-func (i *Instance) RepmgrPrimaryRegister(p config.Prepare) error {
-	// sudo -u postgres /opt/pgsql5432/server/bin/repmgr -f /opt/pgsql5432/repmgr/repmgr.conf primary register
-	cmd := fmt.Sprintf("sudo -u %s PGPASSWORD='%s' %s -f %s primary register",
-		p.SystemUser,
-		p.RepmgrPassword,
-		filepath.Join(p.Dir, "server", "bin", "repmgr"),
-		filepath.Join(p.Dir, "repmgr", "repmgr.conf"))
+// pgpassLine .pgpass 中匹配所有连接的一行, 密码中的 : 和 \ 需要转义
+func pgpassLine(password string) string {
+	return "*:*:*:*:" + strings.NewReplacer(`\`, `\\`, `:`, `\:`).Replace(password) + "\n"
+}
 
+// sudoWithPgpass 把密码写入目标机器上属于 owner 的 .pgpass 临时文件, cmd 中的 %s 替换为文件路径后执行,
+// 命令中通过 PGPASSFILE 使用它, 密码不出现在命令行和错误信息中
+func (i *Instance) sudoWithPgpass(cmd, owner, password string) error {
+	file, clean, err := i.Conn.WriteSecretFile([]byte(pgpassLine(password)), owner)
+	if err != nil {
+		return err
+	}
+	defer clean()
+	cmd = fmt.Sprintf(cmd, file)
 	if stdout, err := i.Conn.Sudo(cmd, "", ""); err != nil {
 		return fmt.Errorf("在机器: %s 上, 执行(%s)失败: %v, 标准输出: %s", i.Host, cmd, err, stdout)
 	}
 	return nil
 }
 
-func (i *Instance) RepmgrStandbyRegister(p config.Prepare) error {
-	// sudo -u postgres /opt/pgsql5432/server/bin/repmgr -f /opt/pgsql5432/repmgr/repmgr.conf standby register
-	cmd := fmt.Sprintf("sudo -u %s PGPASSWORD='%s' %s -f %s standby register",
+func (i *Instance) RepmgrPrimaryRegister(p config.Prepare) error {
+	// sudo -u postgres PGPASSFILE=... /opt/pgsql5432/server/bin/repmgr -f /opt/pgsql5432/repmgr/repmgr.conf primary register
+	cmd := fmt.Sprintf("sudo -u %s PGPASSFILE='%%s' %s -f %s primary register",
 		p.SystemUser,
-		p.RepmgrPassword,
 		filepath.Join(p.Dir, "server", "bin", "repmgr"),
 		filepath.Join(p.Dir, "repmgr", "repmgr.conf"))
+	return i.sudoWithPgpass(cmd, p.SystemUser, p.RepmgrPassword)
+}
 
-	if stdout, err := i.Conn.Sudo(cmd, "", ""); err != nil {
-		return fmt.Errorf("在机器: %s 上, 执行(%s)失败: %v, 标准输出: %s", i.Host, cmd, err, stdout)
-	}
-	return nil
+func (i *Instance) RepmgrStandbyRegister(p config.Prepare) error {
+	// sudo -u postgres PGPASSFILE=... /opt/pgsql5432/server/bin/repmgr -f /opt/pgsql5432/repmgr/repmgr.conf standby register
+	cmd := fmt.Sprintf("sudo -u %s PGPASSFILE='%%s' %s -f %s standby register",
+		p.SystemUser,
+		filepath.Join(p.Dir, "server", "bin", "repmgr"),
+		filepath.Join(p.Dir, "repmgr", "repmgr.conf"))
+	return i.sudoWithPgpass(cmd, p.SystemUser, p.RepmgrPassword)
 }
 
 func (i *Instance) RepmgrStandbyClone(p config.Prepare, master string) error {
 	//  sudo -u postgres /opt/pgsql5432/server/bin/repmgr -f /opt/pgsql5432/repmgr/repmgr.conf -h 10.249.105.53 -p 5432 -U repmgr -d repmgr standby clone
-	cmd := fmt.Sprintf("sudo -u %s PGPASSWORD='%s' %s -f %s -h %s -p %d -U %s -d %s standby clone",
+	cmd := fmt.Sprintf("sudo -u %s PGPASSFILE='%%s' %s -f %s -h %s -p %d -U %s -d %s standby clone",
 		p.SystemUser,
-		p.RepmgrPassword,
 		filepath.Join(p.Dir, "server", "bin", "repmgr"),
 		filepath.Join(p.Dir, "repmgr", "repmgr.conf"),
 		master,
 		p.Port,
 		p.RepmgrUser,
 		p.RepmgrDBName)
-
-	if stdout, err := i.Conn.Sudo(cmd, "", ""); err != nil {
-		return fmt.Errorf("在机器: %s 上, 执行(%s)失败: %v, 标准输出: %s", i.Host, cmd, err, stdout)
-	}
-	return nil
+	return i.sudoWithPgpass(cmd, p.SystemUser, p.RepmgrPassword)
 }
 
 func (i *Instance) RepmgrDaemon(p config.Prepare) error {
@@ -552,14 +663,27 @@ func (i *Instance) ChownData(user, group string) error {
 	return nil
 }
 
-func (i *Instance) Replication(master, PGReplPass string) error {
-	cmd := fmt.Sprintf("PGPASSWORD=%s %s  -D %s -R -Fp -Xs -v  -p %d -h %s -U %s  -P",
-		PGReplPass,
+// Replication 从上游(主库或级联复制的从库)复制数据, name 写入 primary_conninfo 的 application_name, 用于同步复制
+func (i *Instance) Replication(upstream string, port int, name, PGReplPass string) error {
+	// -R 生成的 primary_conninfo 中记录 passfile, 从库连接上游时也使用这个文件, 所以放在安装目录下长期保留
+	pgpass := filepath.ToSlash(filepath.Join(i.Inst.basePath, config.PassHBAFile))
+	file, clean, err := i.Conn.WriteSecretFile([]byte(pgpassLine(PGReplPass)), i.Inst.prepare.SystemUser)
+	if err != nil {
+		return err
+	}
+	defer clean()
+	if stdout, err := i.Conn.Sudo(fmt.Sprintf("mv -f '%s' '%s'", file, pgpass), "", ""); err != nil {
+		return fmt.Errorf("在机器: %s 上, 写入 %s 失败: %v, 标准输出: %s", i.Host, pgpass, err, stdout)
+	}
+
+	cmd := fmt.Sprintf("PGPASSFILE='%s' %s  -D %s -R -Fp -Xs -v  -p %d -h %s -U %s -d 'application_name=%s' -P",
+		pgpass,
 		filepath.ToSlash(filepath.Join(i.Inst.serverBinPath, "pg_basebackup")),
 		i.Inst.dataPath,
-		i.Inst.port,
-		master,
-		config.DefaultPGReplUser)
+		port,
+		upstream,
+		config.DefaultPGReplUser,
+		name)
 	if stdout, err := i.Conn.Sudo(cmd, "", ""); err != nil {
 		return fmt.Errorf("在机器: %s 上, 执行(%s)失败: %v, 标准输出: %s", i.Host, cmd, err, stdout)
 	}
@@ -581,19 +705,36 @@ func (i *Instance) Replication(master, PGReplPass string) error {
 //	return true, nil
 //}
 
+// SyncStandby 设置主库的 synchronous_standby_names
+func (i *Instance) SyncStandby(names string) error {
+	cmd := fmt.Sprintf("%s pgsql sync-standby --host='%s' --port=%d --admin-user='%s' --admin-database='%s' --standby-names='%s' --log='%s'",
+		i.DbupCmd,
+		config.DefaultPGSocketPath,
+		i.Inst.port,
+		i.Inst.adminUser,
+		config.DefaultPGAdminUser,
+		names,
+		filepath.ToSlash(path.Join(environment.GlobalEnv().HomePath, "dbup_pgsql_manager.log")))
+
+	cmd = path.Join(i.TmpDir, "bin", cmd)
+	if stdout, err := i.Conn.SudoWithSecrets(cmd, map[string]string{"admin-password": i.Inst.adminPassword}); err != nil {
+		return fmt.Errorf("在机器: %s 上, 执行(%s)失败: %v, 标准输出: %s", i.Host, cmd, err, stdout)
+	}
+	return nil
+}
+
 func (i *Instance) CheckSlaves(s string) error {
-	cmd1 := fmt.Sprintf("%s pgsql check-slaves --host='%s' --port=%d --admin-user='%s' --admin-password='%s' --admin-database='%s' --log='%s' %s",
+	cmd1 := fmt.Sprintf("%s pgsql check-slaves --host='%s' --port=%d --admin-user='%s' --admin-database='%s' --log='%s' %s",
 		i.DbupCmd,
 		config.DefaultPGSocketPath,
 		i.Inst.port,
 		i.Inst.adminUser,
-		i.Inst.adminPassword,
 		config.DefaultPGAdminUser,
 		filepath.ToSlash(path.Join(environment.GlobalEnv().HomePath, "dbup_pgsql_manager.log")),
 		s)
 
 	cmd1 = path.Join(i.TmpDir, "bin", cmd1)
-	if stdout, err := i.Conn.Sudo(cmd1, "", ""); err != nil {
+	if stdout, err := i.Conn.SudoWithSecrets(cmd1, map[string]string{"admin-password": i.Inst.adminPassword}); err != nil {
 		return fmt.Errorf("在机器: %s 上, 执行(%s)失败: %v, 标准输出: %s", i.Host, cmd1, err, stdout)
 	}
 	return nil
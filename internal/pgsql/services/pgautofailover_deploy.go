@@ -2,11 +2,12 @@ package services
 
 import (
 	"dbup/internal/environment"
+	"dbup/internal/inventory"
 	"dbup/internal/pgsql/config"
 	"dbup/internal/utils"
 	"dbup/internal/utils/logger"
+	"dbup/internal/utils/prompt"
 	"fmt"
-	"os"
 	"path"
 	"path/filepath"
 	"strings"
@@ -40,15 +41,9 @@ func (d *PGAutoFailoverDeploy) Run(c string, n, y bool) error {
 	if d.NewPGdata {
 		if !y {
 			logger.Warningf("配置检测到有新增从节点 %s:%d 需要加入到集群中\n", d.Param.Server.NewPGnode, d.Param.Pgnode.Port)
-			logger.Warningf("请确认[y|n]:")
-
-			var yes string
-			if _, err := fmt.Scanln(&yes); err != nil {
+			if err := prompt.Confirm("请确认"); err != nil {
 				return err
 			}
-			if strings.ToUpper(yes) != "Y" && strings.ToUpper(yes) != "YES" {
-				os.Exit(0)
-			}
 		}
 
 		if err := d.Param.NewPGCheck(); err != nil {
@@ -96,6 +91,9 @@ func (d *PGAutoFailoverDeploy) Run(c string, n, y bool) error {
 		return err
 	}
 
+	if err := inventory.RecordCluster(d.cluster()); err != nil {
+		logger.Warningf("更新实例清单失败: %v\n", err)
+	}
 	return nil
 }
 
@@ -185,15 +183,9 @@ func (d *PGAutoFailoverDeploy) RemoveDeploy(c string, yes bool) error {
 
 	if !yes {
 		logger.Warningf("删除集群是危险操作,会将整个集群中的数据完全删除, 不可恢复\n")
-		logger.Warningf("是否确认删除[y|n]:")
-
-		var yes string
-		if _, err := fmt.Scanln(&yes); err != nil {
+		if err := prompt.Confirm("是否确认删除"); err != nil {
 			return err
 		}
-		if strings.ToUpper(yes) != "Y" && strings.ToUpper(yes) != "YES" {
-			os.Exit(0)
-		}
 	}
 
 	logger.Infof("初始化删除对象\n")
@@ -222,9 +214,25 @@ func (d *PGAutoFailoverDeploy) RemoveDeploy(c string, yes bool) error {
 	}
 	d.UNInstall()
 
+	if err := inventory.ForgetCluster(d.cluster().Name); err != nil {
+		logger.Warningf("更新实例清单失败: %v\n", err)
+	}
 	return nil
 }
 
+// cluster 集群成员, 用于记录到实例清单
+func (d *PGAutoFailoverDeploy) cluster() inventory.Cluster {
+	members := inventory.Members(d.Param.Server.Monitor, d.Param.Pgmonitor.Port, config.PGMonitor, d.Param.Pgmonitor.Dir)
+	members = append(members, inventory.Members(d.Param.Server.PGNode, d.Param.Pgnode.Port, config.PGNode, d.Param.Pgnode.Dir)...)
+	members = append(members, inventory.Members(d.Param.Server.NewPGnode, d.Param.Pgnode.Port, config.PGNode, d.Param.Pgnode.Dir)...)
+	return inventory.Cluster{
+		Name:    inventory.ClusterName(config.Kinds, d.Param.Server.Monitor, d.Param.Pgmonitor.Port),
+		Engine:  config.Kinds,
+		Mode:    "pg_auto_failover",
+		Members: members,
+	}
+}
+
 func (d *PGAutoFailoverDeploy) Init() error {
 	var err error
 	if !d.NewPGdata {
@@ -234,7 +242,8 @@ func (d *PGAutoFailoverDeploy) Init() error {
 			d.Param.Server.SshPassword,
 			d.Param.Server.SshPort,
 			d.Param.Pgmonitor,
-			0); err != nil {
+			0,
+			d.Param.Server.SSHOptions()); err != nil {
 			return err
 		}
 	} else {
@@ -246,7 +255,8 @@ func (d *PGAutoFailoverDeploy) Init() error {
 				d.Param.Server.SshPassword,
 				d.Param.Server.SshPort,
 				d.Param.Pgnode,
-				0)
+				0,
+				d.Param.Server.SSHOptions())
 			if err != nil {
 				return err
 			}
@@ -261,7 +271,8 @@ func (d *PGAutoFailoverDeploy) Init() error {
 		d.Param.Server.SshPassword,
 		d.Param.Server.SshPort,
 		d.Param.Pgnode,
-		0); err != nil {
+		0,
+		d.Param.Server.SSHOptions()); err != nil {
 		return err
 	}
 	for _, pgnode := range pgnodes[1:] {
@@ -271,7 +282,8 @@ func (d *PGAutoFailoverDeploy) Init() error {
 			d.Param.Server.SshPassword,
 			d.Param.Server.SshPort,
 			d.Param.Pgnode,
-			0)
+			0,
+			d.Param.Server.SSHOptions())
 		if err != nil {
 			return err
 		}
@@ -290,7 +302,8 @@ func (d *PGAutoFailoverDeploy) InitUseKeyFile() error {
 			d.Param.Server.SshKeyFile,
 			d.Param.Server.SshPort,
 			d.Param.Pgmonitor,
-			0); err != nil {
+			0,
+			d.Param.Server.SSHOptions()); err != nil {
 			return err
 		}
 	} else {
@@ -302,7 +315,8 @@ func (d *PGAutoFailoverDeploy) InitUseKeyFile() error {
 				d.Param.Server.SshKeyFile,
 				d.Param.Server.SshPort,
 				d.Param.Pgnode,
-				0)
+				0,
+				d.Param.Server.SSHOptions())
 			if err != nil {
 				return err
 			}
@@ -317,7 +331,8 @@ func (d *PGAutoFailoverDeploy) InitUseKeyFile() error {
 		d.Param.Server.SshKeyFile,
 		d.Param.Server.SshPort,
 		d.Param.Pgnode,
-		0); err != nil {
+		0,
+		d.Param.Server.SSHOptions()); err != nil {
 		return err
 	}
 	for _, pgnode := range pgnodes[1:] {
@@ -327,7 +342,8 @@ func (d *PGAutoFailoverDeploy) InitUseKeyFile() error {
 			d.Param.Server.SshKeyFile,
 			d.Param.Server.SshPort,
 			d.Param.Pgnode,
-			0)
+			0,
+			d.Param.Server.SSHOptions())
 		if err != nil {
 			return err
 		}
@@ -4,10 +4,13 @@ import (
 	"bufio"
 	"dbup/internal/environment"
 	"dbup/internal/global"
+	"dbup/internal/inventory"
 	"dbup/internal/pgsql/config"
+	"dbup/internal/tune"
 	"dbup/internal/utils"
 	"dbup/internal/utils/command"
 	"dbup/internal/utils/logger"
+	"dbup/internal/utils/prompt"
 	"errors"
 	"fmt"
 	"io"
@@ -78,17 +81,12 @@ func (i *PghaInstall) MonitorRun(mon config.PGAutoFailoverMonitor, onlyCheck boo
 	}
 
 	if !i.monitor.Yes {
-		var yes string
 		logger.Successf("开始安装 PgAutoFailover Monitor\n")
 		logger.Successf("端口: %d\n", i.port)
 		logger.Successf("安装路径: %s\n", i.monitor.Dir)
-		logger.Successf("是否确认安装[y|n]:")
-		if _, err := fmt.Scanln(&yes); err != nil {
+		if err := prompt.Confirm("是否确认安装"); err != nil {
 			return err
 		}
-		if strings.ToUpper(yes) != "Y" && strings.ToUpper(yes) != "YES" {
-			os.Exit(0)
-		}
 	}
 
 	if err := i.MonitorInstall(); err != nil {
@@ -101,6 +99,7 @@ func (i *PghaInstall) MonitorRun(mon config.PGAutoFailoverMonitor, onlyCheck boo
 		return err
 	}
 
+	i.record(config.PGMonitor)
 	return nil
 }
 
@@ -120,17 +119,12 @@ func (i *PghaInstall) PGdataRun(data config.PGAutoFailoverPGNode, onlyCheck, onl
 		}
 
 		if !i.pgnode.Yes {
-			var yes string
 			logger.Successf("开始安装 PgAutoFailover PGdata\n")
 			logger.Successf("端口: %d\n", i.port)
 			logger.Successf("安装路径: %s\n", i.pgnode.Dir)
-			logger.Successf("是否确认安装[y|n]:")
-			if _, err := fmt.Scanln(&yes); err != nil {
+			if err := prompt.Confirm("是否确认安装"); err != nil {
 				return err
 			}
-			if strings.ToUpper(yes) != "Y" && strings.ToUpper(yes) != "YES" {
-				os.Exit(0)
-			}
 		}
 
 		if err := i.PGdataInstall(); err != nil {
@@ -146,6 +140,7 @@ func (i *PghaInstall) PGdataRun(data config.PGAutoFailoverPGNode, onlyCheck, onl
 		if err := i.Info(); err != nil {
 			return err
 		}
+		i.record(config.PGNode)
 	}
 	return nil
 }
@@ -178,6 +173,10 @@ func (i *PghaInstall) PGdataCheck(data config.PGAutoFailoverPGNode, cfgFile stri
 	if err := i.config.PGdataHandleConfig(i.pgnode, filepath.Join(i.dataPath, "log")); err != nil {
 		return err
 	}
+	// 调优时按 shared_buffers 预留了大页, 优先使用大页
+	if i.pgnode.Tune {
+		i.config.HugePages = "try"
+	}
 
 	return nil
 }
@@ -579,6 +578,12 @@ func (i *PghaInstall) SystemdInit() error {
 		return err
 	}
 
+	if i.pgnode.Tune {
+		if err := tune.ApplyService("pgsql", i.serviceFileName, i.pgnode.MemorySize); err != nil {
+			return err
+		}
+	}
+
 	if err := command.SystemCtl(i.serviceFileName, "start"); err != nil {
 		return err
 	}
@@ -881,6 +886,9 @@ func (i *PghaInstall) MergePrepareArgs(pre config.PGAutoFailoverPGNode) {
 	i.pgnode.AllNode = pre.AllNode
 	i.pgnode.ResourceLimit = pre.ResourceLimit
 	// i.pgnode.ResourceLimit = pre.ResourceLimit
+	if pre.Tune {
+		i.pgnode.Tune = true
+	}
 
 	if pre.SystemUser != "" {
 		i.pgnode.SystemUser = pre.SystemUser
@@ -977,18 +985,35 @@ func (i *PghaInstall) Uninstall(role string) error {
 	return nil
 }
 
+// record 记录到实例清单, 失败不影响安装结果
+func (i *PghaInstall) record(role string) {
+	if err := inventory.Record(inventory.Instance{
+		Engine:  config.Kinds,
+		Version: config.DefaultPGinfoVersion,
+		Port:    i.port,
+		Dir:     i.basePath,
+		DataDir: i.dataPath,
+		Role:    role,
+		Service: i.serviceFileName,
+	}); err != nil {
+		logger.Warningf("更新实例清单失败: %v\n", err)
+	}
+}
+
 func (i *PghaInstall) Info() error {
 	if i.pgnode.Onenode {
 		filename := filepath.Join(environment.GlobalEnv().DbupInfoPath, fmt.Sprintf("%s%d", config.Kinds, i.port))
 		info := config.PgsqlInfo{
-			Port:      i.port,
-			Host:      "127.0.0.1",
-			Socket:    config.DefaultPGSocketPath,
-			Username:  i.pgnode.Username,
-			Password:  i.pgnode.Password,
-			Database:  i.pgnode.Username,
-			DeployDir: i.serverPath,
-			DataDir:   i.dataPath,
+			Port:          i.port,
+			Host:          "127.0.0.1",
+			Socket:        config.DefaultPGSocketPath,
+			Username:      i.pgnode.Username,
+			Password:      i.pgnode.Password,
+			AdminUser:     i.adminUser,
+			AdminPassword: i.adminPassword,
+			Database:      i.pgnode.Username,
+			DeployDir:     i.serverPath,
+			DataDir:       i.dataPath,
 		}
 		if err := info.SlaveTo(filename); err != nil {
 			return err
@@ -2,24 +2,27 @@ package services
 
 import (
 	"dbup/internal/environment"
+	"dbup/internal/global"
 	"dbup/internal/pgsql/config"
 	"dbup/internal/utils/command"
+	"dbup/internal/utils/sshutil"
 	"fmt"
 	"path"
 	"path/filepath"
 )
 
 type AutoInstance struct {
-	DbupCmd string
-	Host    string
-	NodeID  int
-	TmpDir  string
-	Inst    *PghaInstall
-	Conn    *command.Connection
+	DbupCmd  string
+	Host     string
+	NodeID   int
+	TmpDir   string
+	Inst     *PghaInstall
+	Conn     *command.Connection
+	Platform *global.Platform // 目标机器的平台, 检查临时目录时探测
 }
 
-func NewMonitorInstance(tmp, host, user, password string, port int, mon config.PGAutoFailoverMonitor, nodeID int) (*AutoInstance, error) {
-	conn, err := command.NewConnection(host, user, password, port, 30)
+func NewMonitorInstance(tmp, host, user, password string, port int, mon config.PGAutoFailoverMonitor, nodeID int, opts ...sshutil.Options) (*AutoInstance, error) {
+	conn, err := command.NewConnection(host, user, password, port, 30, opts...)
 	if err != nil {
 		return nil, fmt.Errorf("在机器: %s 上, 建立ssh连接失败: %v", host, err)
 	}
@@ -32,8 +35,8 @@ func NewMonitorInstance(tmp, host, user, password string, port int, mon config.P
 	return &AutoInstance{DbupCmd: cmd, Host: host, NodeID: nodeID, TmpDir: tmp, Inst: inst, Conn: conn}, nil
 }
 
-func NewPGdataInstance(tmp, host, user, password string, port int, pgdata config.PGAutoFailoverPGNode, nodeID int) (*AutoInstance, error) {
-	conn, err := command.NewConnection(host, user, password, port, 30)
+func NewPGdataInstance(tmp, host, user, password string, port int, pgdata config.PGAutoFailoverPGNode, nodeID int, opts ...sshutil.Options) (*AutoInstance, error) {
+	conn, err := command.NewConnection(host, user, password, port, 30, opts...)
 	if err != nil {
 		return nil, fmt.Errorf("在机器: %s 上, 建立ssh连接失败: %v", host, err)
 	}
@@ -48,8 +51,8 @@ func NewPGdataInstance(tmp, host, user, password string, port int, pgdata config
 	return &AutoInstance{DbupCmd: cmd, Host: host, NodeID: nodeID, TmpDir: tmp, Inst: inst, Conn: conn}, nil
 }
 
-func NewMonitorInstanceUseKeyFile(tmp, host, user, keyfile string, port int, mon config.PGAutoFailoverMonitor, nodeID int) (*AutoInstance, error) {
-	conn, err := command.NewConnectionUseKeyFile(host, user, keyfile, port, 30)
+func NewMonitorInstanceUseKeyFile(tmp, host, user, keyfile string, port int, mon config.PGAutoFailoverMonitor, nodeID int, opts ...sshutil.Options) (*AutoInstance, error) {
+	conn, err := command.NewConnectionUseKeyFile(host, user, keyfile, port, 30, opts...)
 	if err != nil {
 		return nil, fmt.Errorf("在机器: %s 上, 建立ssh连接失败: %v", host, err)
 	}
@@ -61,8 +64,8 @@ func NewMonitorInstanceUseKeyFile(tmp, host, user, keyfile string, port int, mon
 	return &AutoInstance{DbupCmd: cmd, Host: host, NodeID: nodeID, TmpDir: tmp, Inst: inst, Conn: conn}, nil
 }
 
-func NewPGdataInstanceUseKeyFile(tmp, host, user, keyfile string, port int, pgdata config.PGAutoFailoverPGNode, nodeID int) (*AutoInstance, error) {
-	conn, err := command.NewConnectionUseKeyFile(host, user, keyfile, port, 30)
+func NewPGdataInstanceUseKeyFile(tmp, host, user, keyfile string, port int, pgdata config.PGAutoFailoverPGNode, nodeID int, opts ...sshutil.Options) (*AutoInstance, error) {
+	conn, err := command.NewConnectionUseKeyFile(host, user, keyfile, port, 30, opts...)
 	if err != nil {
 		return nil, fmt.Errorf("在机器: %s 上, 建立ssh连接失败: %v", host, err)
 	}
@@ -77,6 +80,11 @@ func NewPGdataInstanceUseKeyFile(tmp, host, user, keyfile string, port int, pgda
 }
 
 func (i *AutoInstance) CheckTmpDir() error {
+	// 先确认所有目标机器都有对应平台的安装包, 再开始复制
+	if _, _, err := i.CheckPlatform(path.Join(environment.GlobalEnv().ProgramPath, "..")); err != nil {
+		return err
+	}
+
 	if i.Conn.IsExists(filepath.ToSlash(i.TmpDir)) {
 		if !i.Conn.IsDir(filepath.ToSlash(i.TmpDir)) {
 			return fmt.Errorf("在机器: %s 上, 目标文件(%s)已经存在", i.Host, i.TmpDir)
@@ -101,6 +109,11 @@ func (i *AutoInstance) DropTmpDir() error {
 }
 
 func (i *AutoInstance) Scp(source string) error {
+	source, pgsqlPackage, err := i.CheckPlatform(source)
+	if err != nil {
+		return err
+	}
+
 	if err := i.Conn.MkdirAll(filepath.ToSlash(path.Join(i.TmpDir, "bin"))); err != nil {
 		return fmt.Errorf("在机器: %s 上, 创建目录(%s)失败: %v", i.Host, filepath.ToSlash(path.Join(i.TmpDir, "bin")), err)
 	}
@@ -120,16 +133,27 @@ func (i *AutoInstance) Scp(source string) error {
 	if err := i.Conn.Scp(path.Join(source, "package", "md5"), filepath.ToSlash(path.Join(i.TmpDir, "package", "md5"))); err != nil {
 		return fmt.Errorf("在机器: %s 上, scp文件(%s)失败: %v", i.Host, filepath.ToSlash(path.Join(i.TmpDir, "package", "md5")), err)
 	}
+	for _, f := range global.ManifestFiles(path.Join(source, "package")) {
+		if err := i.Conn.Scp(path.Join(source, "package", f), filepath.ToSlash(path.Join(i.TmpDir, "package", f))); err != nil {
+			return fmt.Errorf("在机器: %s 上, scp文件(%s)失败: %v", i.Host, filepath.ToSlash(path.Join(i.TmpDir, "package", f)), err)
+		}
+	}
 
 	if err := i.Conn.Scp(path.Join(source, "systemd", config.PGHAServiceTemplateFile), filepath.ToSlash(path.Join(i.TmpDir, "systemd", config.PGHAServiceTemplateFile))); err != nil {
 		return fmt.Errorf("在机器: %s 上, scp文件(%s)失败: %v", i.Host, filepath.ToSlash(path.Join(i.TmpDir, "systemd", config.PGHAServiceTemplateFile)), err)
 	}
 
-	// TODO: 根据目标机器的操作系统类型选择包
-	pgsqlPackage := fmt.Sprintf("pgsql%s_%s_%s.tar.gz", config.DefaultPGVersion, environment.GlobalEnv().GOOS, environment.GlobalEnv().GOARCH)
 	if err := i.Conn.Scp(path.Join(source, "package", "pgsql", pgsqlPackage), filepath.ToSlash(path.Join(i.TmpDir, "package", "pgsql", pgsqlPackage))); err != nil {
 		return fmt.Errorf("在机器: %s 上, scp文件(%s)失败: %v", i.Host, filepath.ToSlash(path.Join(i.TmpDir, "package", "pgsql", pgsqlPackage)), err)
 	}
+	// 复制后在远程机器上校验安装包, 远程安装时还会再按清单校验一次
+	run := func(cmd string) (string, error) {
+		out, err := i.Conn.Run(cmd)
+		return string(out), err
+	}
+	if err := global.VerifyRemotePackage(run, source, config.Kinds, filepath.ToSlash(path.Join(i.TmpDir, "package", "pgsql", pgsqlPackage))); err != nil {
+		return fmt.Errorf("在机器: %s 上, %v", i.Host, err)
+	}
 
 	if err := i.Conn.Chmod(filepath.ToSlash(path.Join(i.TmpDir, "bin", i.DbupCmd)), 0755); err != nil {
 		return fmt.Errorf("在机器: %s 上, chmod目录(%s)权限失败: %v", i.Host, filepath.ToSlash(path.Join(i.TmpDir, "bin", i.DbupCmd)), err)
@@ -137,6 +161,13 @@ func (i *AutoInstance) Scp(source string) error {
 	return nil
 }
 
+// CheckPlatform 探测目标机器的操作系统和架构, 返回对应平台的安装目录和安装包文件名
+func (i *AutoInstance) CheckPlatform(source string) (string, string, error) {
+	return global.CheckPlatform(i.Host, &i.Platform, i.Conn.Run, source, config.Kinds, func(p *global.Platform) string {
+		return config.PlatformPackageName(config.DefaultPGVersion, p)
+	})
+}
+
 func (i *AutoInstance) MonitorInstall(m config.PGAutoFailoverMonitor, onlyCheck bool) error {
 	cmd := fmt.Sprintf("%s pgsql-mha MonitorCreate  --dir='%s' --host='%s' --port=%d  --system-user='%s' --system-group='%s' --yes --log='%s'",
 		i.DbupCmd,
@@ -160,17 +191,15 @@ func (i *AutoInstance) MonitorInstall(m config.PGAutoFailoverMonitor, onlyCheck
 }
 
 func (i *AutoInstance) PGdataInstall(p config.PGAutoFailoverPGNode, pghost string, onlyCheck, onenode bool) error {
-	cmd := fmt.Sprintf("%s pgsql-mha PGdataCreate --yes  --monitor-host='%s'  --monitor-port=%d  --allnode='%s' --port=%d --host='%s' --admin-password='%s' --admin-password-expire-at='%s' --username='%s'  --password='%s' --memory-size='%s' --dir='%s' --bind-ip='%s' --address='%s' --libraries='%s'  --resource-limit='%s'  --system-user='%s' --system-group='%s' --log='%s'",
+	cmd := fmt.Sprintf("%s pgsql-mha PGdataCreate --yes  --monitor-host='%s'  --monitor-port=%d  --allnode='%s' --port=%d --host='%s' --admin-password-expire-at='%s' --username='%s' --memory-size='%s' --dir='%s' --bind-ip='%s' --address='%s' --libraries='%s'  --resource-limit='%s'  --system-user='%s' --system-group='%s' --log='%s'",
 		i.DbupCmd,
 		p.Mhost,
 		p.Mport,
 		p.AllNode,
 		p.Port,
 		pghost,
-		p.AdminPassword,
 		p.AdminPasswordExpireAt,
 		p.Username,
-		p.Password,
 		p.MemorySize,
 		p.Dir,
 		p.BindIP,
@@ -189,9 +218,13 @@ func (i *AutoInstance) PGdataInstall(p config.PGAutoFailoverPGNode, pghost strin
 		cmd = cmd + " --onenode"
 	}
 
+	if p.Tune {
+		cmd = cmd + " --tune"
+	}
+
 	cmd = path.Join(i.TmpDir, "bin", cmd)
 	// logger.Warningf("Node 安装命令: %s\n", cmd)
-	if stdout, err := i.Conn.Sudo(cmd, "", ""); err != nil {
+	if stdout, err := i.Conn.SudoWithSecrets(cmd, map[string]string{"admin-password": p.AdminPassword, "password": p.Password}); err != nil {
 		return fmt.Errorf("在机器: %s 上, 执行(%s)失败: %v, 标准输出: %s", i.Host, cmd, err, stdout)
 	}
 	return nil
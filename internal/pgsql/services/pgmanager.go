@@ -10,6 +10,7 @@ import (
 	"bytes"
 	"dbup/internal/environment"
 	"dbup/internal/global"
+	"dbup/internal/output"
 	"dbup/internal/pgsql/config"
 	"dbup/internal/pgsql/dao"
 	"dbup/internal/utils"
@@ -251,10 +252,20 @@ func (p *PGManager) CheckSlaves(slaves string) error {
 	if len(repls) == 0 || len(slave) != len(repls) {
 		return fmt.Errorf("检查从库(%s)数量不正常", repls)
 	}
+	output.Set("slaves", repls)
 
 	return nil
 }
 
+// SyncStandby 设置主库的同步从库
+func (p *PGManager) SyncStandby(names string) error {
+	if err := p.Conn.SetSynchronousStandbyNames(names); err != nil {
+		return err
+	}
+	logger.Successf("synchronous_standby_names = '%s'\n", names)
+	return nil
+}
+
 func (p *PGManager) CheckSelect() error {
 	return p.Conn.Select()
 }
@@ -283,7 +294,8 @@ func (p *PGManager) AddSlave(ssho global.SSHConfig, pre config.Prepare, master s
 			ssho.Password,
 			ssho.Port,
 			pre,
-			0)
+			0,
+			ssho.SSHOptions())
 		if err != nil {
 			return err
 		}
@@ -294,7 +306,8 @@ func (p *PGManager) AddSlave(ssho global.SSHConfig, pre config.Prepare, master s
 			ssho.KeyFile,
 			ssho.Port,
 			pre,
-			0)
+			0,
+			ssho.SSHOptions())
 		if err != nil {
 			return err
 		}
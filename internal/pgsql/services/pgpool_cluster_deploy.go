@@ -7,10 +7,11 @@ package services
 
 import (
 	"dbup/internal/environment"
+	"dbup/internal/inventory"
 	"dbup/internal/pgsql/config"
 	"dbup/internal/utils/logger"
+	"dbup/internal/utils/prompt"
 	"fmt"
-	"os"
 	"path"
 	"strings"
 	"time"
@@ -76,6 +77,9 @@ func (d *PGPoolClusterDeploy) Run(c string) error {
 		}
 		return err
 	}
+	if err := inventory.RecordCluster(d.cluster()); err != nil {
+		logger.Warningf("更新实例清单失败: %v\n", err)
+	}
 	return nil
 }
 
@@ -113,15 +117,9 @@ func (d *PGPoolClusterDeploy) RemovePGPoolClusterDeploy(c string, yes bool) erro
 
 	if !yes {
 		logger.Warningf("删除集群是危险操作,会将整个集群中的数据完全删除, 不可恢复\n")
-		logger.Warningf("是否确认删除[y|n]:")
-
-		var yes string
-		if _, err := fmt.Scanln(&yes); err != nil {
+		if err := prompt.Confirm("是否确认删除"); err != nil {
 			return err
 		}
-		if strings.ToUpper(yes) != "Y" && strings.ToUpper(yes) != "YES" {
-			os.Exit(0)
-		}
 	}
 
 	logger.Infof("初始化删除对象\n")
@@ -146,9 +144,25 @@ func (d *PGPoolClusterDeploy) RemovePGPoolClusterDeploy(c string, yes bool) erro
 
 	d.UNInstall()
 
+	if err := inventory.ForgetCluster(d.cluster().Name); err != nil {
+		logger.Warningf("更新实例清单失败: %v\n", err)
+	}
 	return nil
 }
 
+// cluster 集群成员, 用于记录到实例清单
+func (d *PGPoolClusterDeploy) cluster() inventory.Cluster {
+	members := inventory.Members(d.Param.Server.Master, d.Param.Pgsql.Port, "master", d.Param.Pgsql.Dir)
+	members = append(members, inventory.Members(d.Param.Server.Slave, d.Param.Pgsql.Port, "slave", d.Param.Pgsql.Dir)...)
+	members = append(members, inventory.Members(d.Param.Server.PGPools, d.Param.PGPool.Port, config.PGPOOLKinds, d.Param.PGPool.Dir)...)
+	return inventory.Cluster{
+		Name:    inventory.ClusterName(config.Kinds, d.Param.Server.Master, d.Param.Pgsql.Port),
+		Engine:  config.Kinds,
+		Mode:    "pgpool",
+		Members: members,
+	}
+}
+
 func (d *PGPoolClusterDeploy) InitArgs() {
 	d.Param.Server.SetDefault()
 	d.Param.Pgsql.InitArgs()
@@ -171,7 +185,8 @@ func (d *PGPoolClusterDeploy) InitIns() error {
 		d.Param.Server.SshPassword,
 		d.Param.Server.SshPort,
 		d.Param.Pgsql,
-		0); err != nil {
+		0,
+		d.Param.Server.SSHOptions()); err != nil {
 		return err
 	}
 	if d.PGSlave, err = NewInstance(d.Param.Server.TmpDir,
@@ -180,7 +195,8 @@ func (d *PGPoolClusterDeploy) InitIns() error {
 		d.Param.Server.SshPassword,
 		d.Param.Server.SshPort,
 		d.Param.Pgsql,
-		0); err != nil {
+		0,
+		d.Param.Server.SSHOptions()); err != nil {
 		return err
 	}
 	for i, pgpool := range strings.Split(d.Param.Server.PGPools, ",") {
@@ -193,7 +209,8 @@ func (d *PGPoolClusterDeploy) InitIns() error {
 			d.Param.Server.SshUser,
 			d.Param.Server.SshPassword,
 			d.Param.Server.SshPort,
-			d.Param.PGPool)
+			d.Param.PGPool,
+			d.Param.Server.SSHOptions())
 		if err != nil {
 			return err
 		}
@@ -211,7 +228,8 @@ func (d *PGPoolClusterDeploy) InitInsUseKeyFile() error {
 		d.Param.Server.SshKeyFile,
 		d.Param.Server.SshPort,
 		d.Param.Pgsql,
-		0); err != nil {
+		0,
+		d.Param.Server.SSHOptions()); err != nil {
 		return err
 	}
 	if d.PGSlave, err = NewInstanceUseKeyFile(d.Param.Server.TmpDir,
@@ -220,7 +238,8 @@ func (d *PGPoolClusterDeploy) InitInsUseKeyFile() error {
 		d.Param.Server.SshKeyFile,
 		d.Param.Server.SshPort,
 		d.Param.Pgsql,
-		0); err != nil {
+		0,
+		d.Param.Server.SSHOptions()); err != nil {
 		return err
 	}
 	for i, pgpool := range strings.Split(d.Param.Server.PGPools, ",") {
@@ -234,7 +253,8 @@ func (d *PGPoolClusterDeploy) InitInsUseKeyFile() error {
 			d.Param.Server.SshUser,
 			d.Param.Server.SshKeyFile,
 			d.Param.Server.SshPort,
-			d.Param.PGPool)
+			d.Param.PGPool,
+			d.Param.Server.SSHOptions())
 		if err != nil {
 			return err
 		}
@@ -8,10 +8,12 @@ package services
 import (
 	"dbup/internal/environment"
 	"dbup/internal/global"
+	"dbup/internal/inventory"
 	"dbup/internal/pgsql/config"
 	"dbup/internal/utils"
 	"dbup/internal/utils/command"
 	"dbup/internal/utils/logger"
+	"dbup/internal/utils/prompt"
 	"errors"
 	"fmt"
 	"os"
@@ -70,16 +72,11 @@ func (i *PgPoolInstall) Run(pre config.PgPoolParameter, cfgFile, packageName str
 	}
 
 	if !i.parameter.Yes {
-		var yes string
 		logger.Successf("端口: %d\n", i.port)
 		logger.Successf("安装路径: %s\n", i.basePath)
-		logger.Successf("是否确认安装[y|n]:")
-		if _, err := fmt.Scanln(&yes); err != nil {
+		if err := prompt.Confirm("是否确认安装"); err != nil {
 			return err
 		}
-		if strings.ToUpper(yes) != "Y" && strings.ToUpper(yes) != "YES" {
-			os.Exit(0)
-		}
 	}
 
 	if err := i.Install(); err != nil {
@@ -499,6 +496,15 @@ func (i *PgPoolInstall) CreateDBUser() error {
 
 func (i *PgPoolInstall) Info() error {
 	//TODO: 完成info函数
+	if err := inventory.Record(inventory.Instance{
+		Engine:  config.PGPOOLKinds,
+		Version: config.DefaultPGPOOLVersion,
+		Port:    i.port,
+		Dir:     i.basePath,
+		Service: i.serviceFileName,
+	}); err != nil {
+		logger.Warningf("更新实例清单失败: %v\n", err)
+	}
 	logger.Successf("完成pgpool单机版本安装\n")
 	return nil
 }
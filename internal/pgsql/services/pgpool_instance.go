@@ -7,24 +7,27 @@ package services
 
 import (
 	"dbup/internal/environment"
+	"dbup/internal/global"
 	"dbup/internal/pgsql/config"
 	"dbup/internal/utils/command"
+	"dbup/internal/utils/sshutil"
 	"fmt"
 	"path"
 	"path/filepath"
 )
 
 type PGPoolInstance struct {
-	DbupCmd string
-	Host    string
-	TmpDir  string
-	Inst    *PgPoolInstall
-	Conn    *command.Connection
+	DbupCmd  string
+	Host     string
+	TmpDir   string
+	Inst     *PgPoolInstall
+	Conn     *command.Connection
+	Platform *global.Platform // 目标机器的平台, 检查临时目录时探测
 	//spool
 }
 
-func NewPGPoolInstance(tmp, host, user, password string, port int, param config.PgPoolParameter) (*PGPoolInstance, error) {
-	conn, err := command.NewConnection(host, user, password, port, 30)
+func NewPGPoolInstance(tmp, host, user, password string, port int, param config.PgPoolParameter, opts ...sshutil.Options) (*PGPoolInstance, error) {
+	conn, err := command.NewConnection(host, user, password, port, 30, opts...)
 	if err != nil {
 		return nil, fmt.Errorf("在机器: %s 上, 建立ssh连接失败: %v", host, err)
 	}
@@ -38,8 +41,8 @@ func NewPGPoolInstance(tmp, host, user, password string, port int, param config.
 	return &PGPoolInstance{DbupCmd: cmd, Host: host, TmpDir: tmp, Inst: inst, Conn: conn}, nil
 }
 
-func NewPGPoolInstanceUseKeyFile(tmp, host, user, keyfile string, port int, param config.PgPoolParameter) (*PGPoolInstance, error) {
-	conn, err := command.NewConnectionUseKeyFile(host, user, keyfile, port, 30)
+func NewPGPoolInstanceUseKeyFile(tmp, host, user, keyfile string, port int, param config.PgPoolParameter, opts ...sshutil.Options) (*PGPoolInstance, error) {
+	conn, err := command.NewConnectionUseKeyFile(host, user, keyfile, port, 30, opts...)
 	if err != nil {
 		return nil, fmt.Errorf("在机器: %s 上, 建立ssh连接失败: %v", host, err)
 	}
@@ -54,6 +57,11 @@ func NewPGPoolInstanceUseKeyFile(tmp, host, user, keyfile string, port int, para
 }
 
 func (i *PGPoolInstance) CheckTmpDir() error {
+	// 先确认所有目标机器都有对应平台的安装包, 再开始复制
+	if _, _, err := i.CheckPlatform(path.Join(environment.GlobalEnv().ProgramPath, "..")); err != nil {
+		return err
+	}
+
 	if i.Conn.IsExists(filepath.ToSlash(i.TmpDir)) {
 		if !i.Conn.IsDir(filepath.ToSlash(i.TmpDir)) {
 			return fmt.Errorf("在机器: %s 上, 目标文件已经存在", i.Host)
@@ -70,6 +78,11 @@ func (i *PGPoolInstance) CheckTmpDir() error {
 }
 
 func (i *PGPoolInstance) Scp(source string) error {
+	source, pgpoolPackage, err := i.CheckPlatform(source)
+	if err != nil {
+		return err
+	}
+
 	if err := i.Conn.MkdirAll(filepath.ToSlash(path.Join(i.TmpDir, "bin"))); err != nil {
 		return fmt.Errorf("在机器: %s 上, 创建目录(%s)失败: %v", i.Host, filepath.ToSlash(path.Join(i.TmpDir, "bin")), err)
 	}
@@ -88,16 +101,27 @@ func (i *PGPoolInstance) Scp(source string) error {
 	if err := i.Conn.Scp(path.Join(source, "package", "md5"), filepath.ToSlash(path.Join(i.TmpDir, "package", "md5"))); err != nil {
 		return fmt.Errorf("在机器: %s 上, scp文件(%s)失败: %v", i.Host, filepath.ToSlash(path.Join(i.TmpDir, "package", "md5")), err)
 	}
+	for _, f := range global.ManifestFiles(path.Join(source, "package")) {
+		if err := i.Conn.Scp(path.Join(source, "package", f), filepath.ToSlash(path.Join(i.TmpDir, "package", f))); err != nil {
+			return fmt.Errorf("在机器: %s 上, scp文件(%s)失败: %v", i.Host, filepath.ToSlash(path.Join(i.TmpDir, "package", f)), err)
+		}
+	}
 
 	if err := i.Conn.Scp(path.Join(source, "systemd", config.PGPoolServiceTemplateFile), filepath.ToSlash(path.Join(i.TmpDir, "systemd", config.PGPoolServiceTemplateFile))); err != nil {
 		return fmt.Errorf("在机器: %s 上, scp文件(%s)失败: %v", i.Host, filepath.ToSlash(path.Join(i.TmpDir, "systemd", config.PGPoolServiceTemplateFile)), err)
 	}
 
-	// TODO: 根据目标机器的操作系统类型选择包
-	pgpoolPackage := fmt.Sprintf("pgpool4.2_%s_%s.tar.gz", environment.GlobalEnv().GOOS, environment.GlobalEnv().GOARCH)
 	if err := i.Conn.Scp(path.Join(source, "package", "pgpool", pgpoolPackage), filepath.ToSlash(path.Join(i.TmpDir, "package", "pgpool", pgpoolPackage))); err != nil {
 		return fmt.Errorf("在机器: %s 上, scp文件(%s)失败: %v", i.Host, filepath.ToSlash(path.Join(i.TmpDir, "package", "pgpool", pgpoolPackage)), err)
 	}
+	// 复制后在远程机器上校验安装包, 远程安装时还会再按清单校验一次
+	run := func(cmd string) (string, error) {
+		out, err := i.Conn.Run(cmd)
+		return string(out), err
+	}
+	if err := global.VerifyRemotePackage(run, source, config.PGPOOLKinds, filepath.ToSlash(path.Join(i.TmpDir, "package", "pgpool", pgpoolPackage))); err != nil {
+		return fmt.Errorf("在机器: %s 上, %v", i.Host, err)
+	}
 
 	if err := i.Conn.Chmod(filepath.ToSlash(path.Join(i.TmpDir, "bin", i.DbupCmd)), 0755); err != nil {
 		return fmt.Errorf("在机器: %s 上, chmod目录(%s)权限失败: %v", i.Host, filepath.ToSlash(path.Join(i.TmpDir, "bin", i.DbupCmd)), err)
@@ -105,6 +129,13 @@ func (i *PGPoolInstance) Scp(source string) error {
 	return nil
 }
 
+// CheckPlatform 探测目标机器的操作系统和架构, 返回对应平台的安装目录和安装包文件名
+func (i *PGPoolInstance) CheckPlatform(source string) (string, string, error) {
+	return global.CheckPlatform(i.Host, &i.Platform, i.Conn.Run, source, config.PGPOOLKinds, func(p *global.Platform) string {
+		return p.PackageName(config.PGPOOLPackageFile, config.DefaultPGPOOLVersion)
+	})
+}
+
 func (i *PGPoolInstance) DropTmpDir() error {
 	cmd := fmt.Sprintf("cd %s; rm -rf *", filepath.ToSlash(i.TmpDir))
 	if stdout, err := i.Conn.Run(cmd); err != nil {
@@ -114,7 +145,7 @@ func (i *PGPoolInstance) DropTmpDir() error {
 }
 
 func (i *PGPoolInstance) Install(onlyCheck bool) error {
-	cmd := fmt.Sprintf("%s pgsql pgpool-install --yes --port=%d --pcp-port=%d --wd-port=%d --heart-port=%d --bind-ip='%s' --pcp-bind-ip='%s' --address='%s' --username='%s' --password='%s' --dir='%s' --pgpool-ip='%s' --pg-port=%d --pg-dir='%s' --pg-master='%s' --pg-slave='%s' --node-id=%d --resource-limit='%s' --log='%s'",
+	cmd := fmt.Sprintf("%s pgsql pgpool-install --yes --port=%d --pcp-port=%d --wd-port=%d --heart-port=%d --bind-ip='%s' --pcp-bind-ip='%s' --address='%s' --username='%s' --dir='%s' --pgpool-ip='%s' --pg-port=%d --pg-dir='%s' --pg-master='%s' --pg-slave='%s' --node-id=%d --resource-limit='%s' --log='%s'",
 		i.DbupCmd,
 		i.Inst.parameter.Port,
 		i.Inst.parameter.PcpPort,
@@ -124,7 +155,6 @@ func (i *PGPoolInstance) Install(onlyCheck bool) error {
 		i.Inst.parameter.PcpBindIP,
 		i.Inst.parameter.Address,
 		i.Inst.parameter.Username,
-		i.Inst.parameter.Password,
 		i.Inst.parameter.Dir,
 		i.Inst.parameter.PGPoolIP,
 		i.Inst.parameter.PGPort,
@@ -140,7 +170,7 @@ func (i *PGPoolInstance) Install(onlyCheck bool) error {
 	}
 
 	cmd = path.Join(i.TmpDir, "bin", cmd)
-	if stdout, err := i.Conn.Sudo(cmd, "", ""); err != nil {
+	if stdout, err := i.Conn.SudoWithSecrets(cmd, map[string]string{"password": i.Inst.parameter.Password}); err != nil {
 		return fmt.Errorf("在机器: %s 上, 执行(%s)失败: %v, 标准输出: %s", i.Host, cmd, err, stdout)
 	}
 	return nil
@@ -161,17 +191,16 @@ func (i *PGPoolInstance) UNInstall() error {
 }
 
 func (i *PGPoolInstance) CheckSelect(port int, username, password, dbname string) error {
-	cmd1 := fmt.Sprintf("%s pgsql check-select --host='%s' --port=%d --admin-user='%s' --admin-password='%s' --admin-database='%s' --log='%s'",
+	cmd1 := fmt.Sprintf("%s pgsql check-select --host='%s' --port=%d --admin-user='%s' --admin-database='%s' --log='%s'",
 		i.DbupCmd,
 		"127.0.0.1",
 		port,
 		username,
-		password,
 		dbname,
 		filepath.ToSlash(path.Join(environment.GlobalEnv().HomePath, "dbup_pgsql_manager.log")))
 
 	cmd1 = path.Join(i.TmpDir, "bin", cmd1)
-	if stdout, err := i.Conn.Sudo(cmd1, "", ""); err != nil {
+	if stdout, err := i.Conn.SudoWithSecrets(cmd1, map[string]string{"admin-password": password}); err != nil {
 		return fmt.Errorf("在机器: %s 上, 执行(%s)失败: %v, 标准输出: %s", i.Host, cmd1, err, stdout)
 	}
 	return nil
@@ -7,6 +7,7 @@ package services
 
 import (
 	"dbup/internal/global"
+	"dbup/internal/inventory"
 	"dbup/internal/pgsql/config"
 	"dbup/internal/utils"
 	"dbup/internal/utils/command"
@@ -51,5 +52,8 @@ func (i PGPoolUNInstall) Uninstall() error {
 			logger.Warningf("删除安装目录成功\n")
 		}
 	}
+	if err := inventory.Forget(config.PGPOOLKinds, i.Port); err != nil {
+		logger.Warningf("更新实例清单失败: %v\n", err)
+	}
 	return nil
 }
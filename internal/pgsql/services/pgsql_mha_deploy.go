@@ -7,11 +7,12 @@ package services
 
 import (
 	"dbup/internal/environment"
+	"dbup/internal/inventory"
 	"dbup/internal/pgsql/config"
 	"dbup/internal/utils"
 	"dbup/internal/utils/logger"
+	"dbup/internal/utils/prompt"
 	"fmt"
-	"os"
 	"path"
 	"path/filepath"
 	"strings"
@@ -37,6 +38,9 @@ func (d *PGSqlMHADeploy) Run(c string) error {
 	if err := d.Param.Validator(); err != nil {
 		return err
 	}
+	if err := d.Param.Server.ValidatorSlaves(); err != nil {
+		return err
+	}
 
 	if d.Param.Pgsql.SystemUser == "" {
 		d.Param.Pgsql.SystemUser = config.DefaultPGAdminUser
@@ -80,6 +84,9 @@ func (d *PGSqlMHADeploy) Run(c string) error {
 		return err
 	}
 
+	if err := inventory.RecordCluster(d.cluster()); err != nil {
+		logger.Warningf("更新实例清单失败: %v\n", err)
+	}
 	return nil
 }
 
@@ -92,6 +99,9 @@ func (d *PGSqlMHADeploy) RemoveDeploy(c string, yes bool) error {
 	if err := d.Param.Server.Validator(); err != nil {
 		return err
 	}
+	if err := d.Param.Server.ValidatorSlaves(); err != nil {
+		return err
+	}
 	if d.Param.Pgsql.Port == 0 {
 		return fmt.Errorf("请指定端口号")
 	}
@@ -109,15 +119,9 @@ func (d *PGSqlMHADeploy) RemoveDeploy(c string, yes bool) error {
 
 	if !yes {
 		logger.Warningf("删除集群是危险操作,会将整个集群中的数据完全删除, 不可恢复\n")
-		logger.Warningf("是否确认删除[y|n]:")
-
-		var yes string
-		if _, err := fmt.Scanln(&yes); err != nil {
+		if err := prompt.Confirm("是否确认删除"); err != nil {
 			return err
 		}
-		if strings.ToUpper(yes) != "Y" && strings.ToUpper(yes) != "YES" {
-			os.Exit(0)
-		}
 	}
 
 	logger.Infof("初始化删除对象\n")
@@ -140,9 +144,22 @@ func (d *PGSqlMHADeploy) RemoveDeploy(c string, yes bool) error {
 	}
 
 	d.UNInstall()
+	if err := inventory.ForgetCluster(d.cluster().Name); err != nil {
+		logger.Warningf("更新实例清单失败: %v\n", err)
+	}
 	return nil
 }
 
+// cluster 集群成员, 用于记录到实例清单
+func (d *PGSqlMHADeploy) cluster() inventory.Cluster {
+	return inventory.Cluster{
+		Name:    inventory.ClusterName(config.Kinds, d.Param.Server.Master, d.Param.Pgsql.Port),
+		Engine:  config.Kinds,
+		Mode:    "repmgr",
+		Members: append(inventory.Members(d.Param.Server.Master, d.Param.Pgsql.Port, "primary", d.Param.Pgsql.Dir), inventory.Members(d.Param.Server.Slaves, d.Param.Pgsql.Port, "standby", d.Param.Pgsql.Dir)...),
+	}
+}
+
 func (d *PGSqlMHADeploy) InitRepmgrArg() error {
 
 	if d.Param.Pgsql.RepmgrDeployMode != "" {
@@ -267,7 +284,8 @@ func (d *PGSqlMHADeploy) Init() error {
 		d.Param.Server.Password,
 		d.Param.Server.SshPort,
 		d.Param.Pgsql,
-		1001); err != nil {
+		1001,
+		d.Param.Server.SSHOptions()); err != nil {
 		return err
 	}
 	for i, slave := range strings.Split(d.Param.Server.Slaves, ",") {
@@ -277,7 +295,8 @@ func (d *PGSqlMHADeploy) Init() error {
 			d.Param.Server.Password,
 			d.Param.Server.SshPort,
 			d.Param.Pgsql,
-			1002+i)
+			1002+i,
+			d.Param.Server.SSHOptions())
 		if err != nil {
 			return err
 		}
@@ -294,7 +313,8 @@ func (d *PGSqlMHADeploy) InitUseKeyFile() error {
 		d.Param.Server.KeyFile,
 		d.Param.Server.SshPort,
 		d.Param.Pgsql,
-		1001); err != nil {
+		1001,
+		d.Param.Server.SSHOptions()); err != nil {
 		return err
 	}
 	for i, slave := range strings.Split(d.Param.Server.Slaves, ",") {
@@ -306,7 +326,8 @@ func (d *PGSqlMHADeploy) InitUseKeyFile() error {
 			d.Param.Server.KeyFile,
 			d.Param.Server.SshPort,
 			d.Param.Pgsql,
-			1002+i)
+			1002+i,
+			d.Param.Server.SSHOptions())
 		if err != nil {
 			return err
 		}
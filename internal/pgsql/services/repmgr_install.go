@@ -7,12 +7,12 @@ import (
 	"dbup/internal/utils"
 	"dbup/internal/utils/command"
 	"dbup/internal/utils/logger"
+	"dbup/internal/utils/prompt"
 	"fmt"
 	"math/rand"
 	"os"
 	"os/user"
 	"path/filepath"
-	"strings"
 	"time"
 )
 
@@ -43,17 +43,12 @@ func (p *Repmgr_Install) PrimaryRun(dbport int, onlyCheck bool) error {
 	}
 
 	if !p.repprepare.Yes {
-		var yes string
 		logger.Successf("Repmgr 主节点安装端口: %d\n", dbport)
 		logger.Successf("Repmgr 主节点安装主路径: %s\n", p.repprepare.Dir)
 		logger.Warningf("Repmgr 主节点安装需要重启 postgresql 实例来加载主配置文件\n")
-		logger.Successf("是否确认安装[y|n]:")
-		if _, err := fmt.Scanln(&yes); err != nil {
+		if err := prompt.Confirm("是否确认安装"); err != nil {
 			return err
 		}
-		if strings.ToUpper(yes) != "Y" && strings.ToUpper(yes) != "YES" {
-			os.Exit(0)
-		}
 	}
 
 	if err := p.InstallAndInitDB(); err != nil {
@@ -78,17 +73,12 @@ func (p *Repmgr_Install) StandbyRun(masterip string, masterport int) error {
 	}
 
 	if !p.repprepare.Yes {
-		var yes string
 		logger.Successf("Repmgr 从节点默认安装端口: %d\n", p.masterport)
 		logger.Successf("Repmgr 从节点安装主路径: %s\n", p.repprepare.Dir)
 		logger.Warningf("Repmgr 从节点初始化需要全量拉取主库数据恢复从库, 数据量较大时间会比较长\n")
-		logger.Successf("是否确认安装[y|n]:")
-		if _, err := fmt.Scanln(&yes); err != nil {
+		if err := prompt.Confirm("是否确认安装"); err != nil {
 			return err
 		}
-		if strings.ToUpper(yes) != "Y" && strings.ToUpper(yes) != "YES" {
-			os.Exit(0)
-		}
 	}
 
 	if err := p.InstallStandbyDB(); err != nil {
@@ -155,7 +145,7 @@ func (p *Repmgr_Install) StartstandbyFailNode(pgport int) error {
 	}
 
 	if err := p.RepmgrStartPostgreSql(); err != nil {
-		logger.Errorf("PostgreSql 服务启动失败: %s\n", err)
+		return fmt.Errorf("PostgreSql 服务启动失败: %v", err)
 	}
 
 	time.Sleep(3 * time.Second)
@@ -390,12 +380,12 @@ func (p *Repmgr_Install) PrimaryPgrestart(servicename, servicefile string) error
 		}
 		time.Sleep(2 * time.Second)
 		if err := p.RepmgrStartPostgreSql(); err != nil {
-			logger.Errorf("PostgreSql 服务启动失败: %s\n", err)
+			return fmt.Errorf("PostgreSql 服务启动失败: %v", err)
 		}
 
 	} else {
 		if err := p.RepmgrRestartPostgreSql(); err != nil {
-			logger.Errorf("PostgreSql 服务重启失败: %s\n", err)
+			return fmt.Errorf("PostgreSql 服务重启失败: %v", err)
 		}
 	}
 
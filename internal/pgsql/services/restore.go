@@ -0,0 +1,471 @@
+package services
+
+import (
+	"bufio"
+	"dbup/internal/environment"
+	"dbup/internal/global"
+	"dbup/internal/output"
+	"dbup/internal/pgsql/config"
+	"dbup/internal/utils"
+	"dbup/internal/utils/command"
+	"dbup/internal/utils/logger"
+	"dbup/internal/utils/prompt"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	StandbySignalFile  = "standby.signal"
+	RecoverySignalFile = "recovery.signal"
+	AutoConfFileName   = "postgresql.auto.conf"
+	PGVersionFile      = "PG_VERSION"
+)
+
+// Restore 把 pg_basebackup -Fp 生成的备份目录恢复成一个新实例, 或恢复到一个已经停止的实例
+type Restore struct {
+	BackupDir   string
+	KeepStandby bool // 保留 standby.signal, 恢复后作为从库启动
+	Wait        int  // 等待实例可用的秒数
+	Prepare     config.Prepare
+
+	// 按时间点恢复: 基础备份之后从 WAL 归档中取回 WAL 重放到恢复目标, 没有指定目标时重放全部归档
+	WalArchiveConfig string
+	TargetTime       string
+	TargetLSN        string
+	TargetXID        string
+	TargetAction     string
+
+	inst   *Install
+	exists bool   // 目标为已安装并停止的实例
+	oldDir string // 已有实例原来的数据目录移到的位置
+}
+
+func NewRestore() *Restore {
+	return &Restore{Wait: 120, TargetAction: "promote"}
+}
+
+// BackupVersion 读取备份目录中的 PG_VERSION
+func BackupVersion(backupDir string) (string, error) {
+	b, err := os.ReadFile(filepath.Join(backupDir, PGVersionFile))
+	if err != nil {
+		return "", fmt.Errorf("备份目录(%s)不是 pg_basebackup 的 plain 格式备份: %v", backupDir, err)
+	}
+	return strings.TrimSpace(string(b)), nil
+}
+
+func (r *Restore) Validator() error {
+	logger.Infof("验证参数\n")
+	if r.BackupDir == "" {
+		return fmt.Errorf("请指定要恢复的备份目录")
+	}
+	if !utils.IsDir(r.BackupDir) {
+		return fmt.Errorf("备份目录(%s)不存在", r.BackupDir)
+	}
+	version, err := BackupVersion(r.BackupDir)
+	if err != nil {
+		return err
+	}
+	if !utils.IsExists(filepath.Join(r.BackupDir, "backup_label")) {
+		return fmt.Errorf("备份目录(%s)中没有 backup_label, 不是完整的 pg_basebackup 备份", r.BackupDir)
+	}
+	if r.Prepare.Version == "" {
+		r.Prepare.Version = version
+	}
+	if v, err := config.CheckVersion(r.Prepare.Version); err != nil {
+		return err
+	} else if v != version {
+		return fmt.Errorf("备份的版本为 %s, 与指定的版本 %s 不一致", version, r.Prepare.Version)
+	}
+	if r.Prepare.Port < 1025 || r.Prepare.Port > 65535 {
+		return fmt.Errorf("端口号(%d), 不是一个正确的端口号. 端口号必须在 1025 ~ 65535 之间", r.Prepare.Port)
+	}
+	if r.Wait <= 0 {
+		r.Wait = 120
+	}
+	return r.ValidatorTarget()
+}
+
+// ValidatorTarget 检查按时间点恢复的参数
+func (r *Restore) ValidatorTarget() error {
+	targets := 0
+	for _, t := range []string{r.TargetTime, r.TargetLSN, r.TargetXID} {
+		if strings.Contains(t, "'") {
+			return fmt.Errorf("恢复目标中不能包含单引号: %s", t)
+		}
+		if t != "" {
+			targets++
+		}
+	}
+	if targets > 1 {
+		return fmt.Errorf("--target-time, --target-lsn, --target-xid 只能指定一个")
+	}
+	if targets == 1 && r.WalArchiveConfig == "" {
+		return fmt.Errorf("按时间点恢复需要通过 --wal-archive-config 指定 WAL 归档配置")
+	}
+	if r.WalArchiveConfig == "" {
+		return nil
+	}
+	if r.KeepStandby {
+		return fmt.Errorf("从 WAL 归档恢复时不能保留 %s", StandbySignalFile)
+	}
+	switch r.TargetAction {
+	case "":
+		r.TargetAction = "promote"
+	case "promote", "pause", "shutdown":
+	default:
+		return fmt.Errorf("--target-action 只能是 promote, pause 或 shutdown")
+	}
+	return (&config.WalArchiveConfig{}).Load(r.WalArchiveConfig)
+}
+
+// InitAndCheck 按安装的规则生成配置和启动文件, 并确定恢复到新实例还是已停止的实例
+func (r *Restore) InitAndCheck() error {
+	r.inst = NewInstall()
+	if err := r.inst.HandlePrepareArgs(r.Prepare, ""); err != nil {
+		return err
+	}
+	r.inst.HandleArgs("")
+	i := r.inst
+
+	// 已有实例开启了 WAL 归档时, 重新生成的配置文件中保留 archive_command
+	if c := config.WalArchiveConfPath(i.basePath); i.prepare.WalArchive == "" && utils.IsExists(c) {
+		archive := &config.WalArchiveConfig{}
+		if err := archive.Load(c); err != nil {
+			return err
+		}
+		i.prepare.WalArchive = archive.Target
+	}
+
+	if err := i.config.HandleConfig(i.prepare, filepath.Join(i.dataPath, "log")); err != nil {
+		return err
+	}
+	if i.prepare.Tune {
+		i.config.HugePages = "try"
+	}
+	if err := i.HandleSystemd(); err != nil {
+		return err
+	}
+
+	if utils.PortInUse(i.port) {
+		return fmt.Errorf("端口号被占用: %d, 恢复到已有实例前请先停止实例: systemctl stop %s", i.port, i.serviceFileName)
+	}
+	if err := i.prepare.ValidatorMemorySize(); err != nil {
+		return err
+	}
+
+	binExists := utils.IsExists(i.serverFileFullName)
+	serviceExists := utils.IsExists(i.serviceFileFullName)
+	switch {
+	case binExists && serviceExists:
+		r.exists = true
+		if utils.IsExists(filepath.Join(i.dataPath, "postmaster.pid")) {
+			return fmt.Errorf("数据目录(%s)中存在 postmaster.pid, 请确认实例已经停止", i.dataPath)
+		}
+		version, err := command.PGsqlVersion(i.basePath)
+		if err != nil {
+			return err
+		}
+		if strings.Split(version, ".")[0] != i.version {
+			return fmt.Errorf("已有实例的版本为 %s, 与备份的版本 %s 不一致", version, i.version)
+		}
+		return nil
+	case serviceExists:
+		return fmt.Errorf("启动文件(%s)已经存在, 但安装目录(%s)中没有 pgsql 程序", i.serviceFileFullName, i.basePath)
+	case binExists:
+		return fmt.Errorf("安装目录(%s)中已有 pgsql 程序, 但启动文件(%s)不存在", i.basePath, i.serviceFileFullName)
+	}
+
+	if err := utils.ValidatorDir(i.basePath); err != nil {
+		return err
+	}
+	return global.CheckPackage(environment.GlobalEnv().ProgramPath, i.packageFullName, config.Kinds)
+}
+
+func (r *Restore) Run() error {
+	if err := r.Validator(); err != nil {
+		return err
+	}
+	if err := r.InitAndCheck(); err != nil {
+		return err
+	}
+	i := r.inst
+
+	if !i.prepare.Yes {
+		if err := r.confirm(i, r.exists); err != nil {
+			return err
+		}
+	}
+
+	if err := r.restore(); err != nil {
+		if r.exists {
+			if r.oldDir != "" {
+				logger.Warningf("恢复失败, 原数据目录保存在: %s\n", r.oldDir)
+			}
+		} else if !i.prepare.NoRollback {
+			logger.Warningf("恢复失败, 开始回滚\n")
+			i.Uninstall()
+		}
+		return err
+	}
+
+	role := "master"
+	if r.KeepStandby {
+		role = "slave"
+	}
+	i.record(role)
+	output.Created(output.Resource{Kind: "instance", Engine: config.Kinds, Port: i.port, Path: i.basePath, Service: i.serviceFileName})
+
+	logger.Successf("恢复完成\n")
+	logger.Successf("PG端 口:%d\n", i.port)
+	logger.Successf("数据目录:%s\n", i.dataPath)
+	logger.Successf("启动方式:systemctl start %s\n", i.serviceFileName)
+	logger.Successf("关闭方式:systemctl stop %s\n", i.serviceFileName)
+	return nil
+}
+
+// confirm 打印恢复信息并确认, exists 表示恢复到已有实例
+func (r *Restore) confirm(i *Install, exists bool) error {
+	logger.Successf("备份目录: %s\n", r.BackupDir)
+	logger.Successf("端口: %d\n", i.port)
+	logger.Successf("安装路径: %s\n", i.basePath)
+	if exists {
+		logger.Warningf("恢复到已有实例, 原数据目录 %s 会被移走保留\n", i.dataPath)
+	}
+	if r.KeepStandby {
+		logger.Successf("保留 %s, 恢复后作为从库启动\n", StandbySignalFile)
+	}
+	return prompt.Confirm("是否确认恢复")
+}
+
+func (r *Restore) restore() error {
+	i := r.inst
+	if r.exists {
+		if err := i.CreateUser(); err != nil {
+			return err
+		}
+		if utils.IsExists(i.dataPath) {
+			r.oldDir = path.Clean(i.dataPath) + ".bak." + time.Now().Format("20060102150405")
+			logger.Infof("移走原数据目录: %s 到 %s\n", i.dataPath, r.oldDir)
+			if err := os.Rename(i.dataPath, r.oldDir); err != nil {
+				return err
+			}
+		}
+		if err := os.MkdirAll(i.dataPath, 0700); err != nil {
+			return err
+		}
+		if err := i.SystemdInit(); err != nil {
+			return err
+		}
+		if r.Prepare.WalArchive != "" {
+			if err := i.SetupWalArchive(); err != nil {
+				return err
+			}
+		}
+	} else if err := i.Install(); err != nil {
+		return err
+	}
+
+	logger.Infof("复制备份: %s 到 %s\n", r.BackupDir, i.dataPath)
+	cmd := fmt.Sprintf("cp -a '%s/.' '%s/'", filepath.Clean(r.BackupDir), i.dataPath)
+	l := command.Local{Timeout: 259200}
+	if _, stderr, err := l.Run(cmd); err != nil {
+		return fmt.Errorf("复制备份失败: %v, 标准错误输出: %s", err, stderr)
+	}
+
+	if err := r.handleStandby(); err != nil {
+		return err
+	}
+	if r.WalArchiveConfig != "" {
+		if err := r.handleRecovery(); err != nil {
+			return err
+		}
+	}
+
+	// 按本机的端口, 目录和内存重新生成配置文件, 备份中的配置文件移走保留
+	if err := i.MakeConfigFile(i.configFileFullName); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Join(i.dataPath, "log"), 0700); err != nil {
+		return err
+	}
+
+	if err := i.ChownDir(i.basePath); err != nil {
+		return err
+	}
+	if err := os.Chmod(i.dataPath, 0700); err != nil {
+		return err
+	}
+
+	logger.Infof("启动实例\n")
+	if err := i.SystemdLaunch(); err != nil {
+		return err
+	}
+	return r.waitReady()
+}
+
+// handleStandby 不保留从库身份时删除 standby.signal 和 postgresql.auto.conf 中的 primary_conninfo
+func (r *Restore) handleStandby() error {
+	signal := filepath.Join(r.inst.dataPath, StandbySignalFile)
+	if r.KeepStandby {
+		if !utils.IsExists(signal) {
+			logger.Warningf("备份中没有 %s, 实例会作为主库启动\n", StandbySignalFile)
+		}
+		return nil
+	}
+
+	if utils.IsExists(signal) {
+		logger.Infof("删除 %s\n", StandbySignalFile)
+		if err := os.Remove(signal); err != nil {
+			return err
+		}
+	}
+
+	autoConf := filepath.Join(r.inst.dataPath, AutoConfFileName)
+	f, err := os.Open(autoConf)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	var lines []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if !strings.HasPrefix(strings.TrimSpace(scanner.Text()), "primary_conninfo") {
+			lines = append(lines, scanner.Text())
+		}
+	}
+	f.Close()
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	return os.WriteFile(autoConf, []byte(strings.Join(lines, "\n")+"\n"), 0600)
+}
+
+// handleRecovery 生成 recovery.signal, 在 postgresql.auto.conf 中写入 restore_command 和恢复目标
+func (r *Restore) handleRecovery() error {
+	i := r.inst
+	logger.Infof("从 WAL 归档恢复, 归档配置: %s\n", r.WalArchiveConfig)
+	if err := i.CopyDbup(); err != nil {
+		return err
+	}
+	c := &config.WalArchiveConfig{}
+	if err := c.Load(r.WalArchiveConfig); err != nil {
+		return err
+	}
+	if err := c.SaveTo(filepath.Join(i.basePath, config.WalRestoreConfFile)); err != nil {
+		return err
+	}
+
+	settings := []string{"# dbup pgsql restore", "restore_command = " + config.WalRestoreCommand}
+	switch {
+	case r.TargetTime != "":
+		settings = append(settings, fmt.Sprintf("recovery_target_time = '%s'", r.TargetTime))
+	case r.TargetLSN != "":
+		settings = append(settings, fmt.Sprintf("recovery_target_lsn = '%s'", r.TargetLSN))
+	case r.TargetXID != "":
+		settings = append(settings, fmt.Sprintf("recovery_target_xid = '%s'", r.TargetXID))
+	}
+	if len(settings) > 2 {
+		settings = append(settings, fmt.Sprintf("recovery_target_action = '%s'", r.TargetAction))
+		logger.Infof("恢复目标: %s\n", strings.TrimPrefix(settings[2], "recovery_"))
+	}
+
+	f, err := os.OpenFile(filepath.Join(i.dataPath, AutoConfFileName), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if _, err := f.WriteString(strings.Join(settings, "\n") + "\n"); err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(i.dataPath, RecoverySignalFile), nil, 0600)
+}
+
+// waitReady 用 pg_isready 等待实例可以接受连接
+func (r *Restore) waitReady() error {
+	i := r.inst
+	cmd := fmt.Sprintf("'%s' -h '%s' -p %d", filepath.Join(i.serverBinPath, "pg_isready"), config.DefaultPGSocketPath, i.port)
+	l := command.Local{}
+	var stdout []byte
+	var err error
+	for n := 0; n < r.Wait; n += 2 {
+		if stdout, _, err = l.Run(cmd); err == nil {
+			logger.Successf("实例已经可以接受连接\n")
+			return nil
+		}
+		time.Sleep(2 * time.Second)
+	}
+	return fmt.Errorf("等待 %d 秒后实例仍不能接受连接: %s, 请查看日志: %s", r.Wait, strings.TrimSpace(string(stdout)), filepath.Join(i.dataPath, "log"))
+}
+
+// RunRemote 通过 ssh 在远程机器上恢复, 备份目录为远程机器上的目录
+func (r *Restore) RunRemote(ssho global.SSHConfig) error {
+	logger.Infof("验证参数\n")
+	if err := ssho.Validator(); err != nil {
+		return err
+	}
+	if r.BackupDir == "" {
+		return fmt.Errorf("请指定要恢复的备份目录")
+	}
+	if ssho.TmpDir == "" {
+		ssho.TmpDir = config.DeployTmpDir
+	}
+	if ssho.Password == "" && ssho.KeyFile == "" {
+		ssho.KeyFile = filepath.Join(environment.GlobalEnv().HomePath, ".ssh", "id_rsa")
+	}
+
+	var node *Instance
+	var err error
+	if ssho.Password != "" {
+		node, err = NewInstance(ssho.TmpDir, ssho.Host, ssho.Username, ssho.Password, ssho.Port, r.Prepare, 0, ssho.SSHOptions())
+	} else {
+		node, err = NewInstanceUseKeyFile(ssho.TmpDir, ssho.Host, ssho.Username, ssho.KeyFile, ssho.Port, r.Prepare, 0, ssho.SSHOptions())
+	}
+	if err != nil {
+		return err
+	}
+
+	// 没有指定版本时按备份的版本选择安装包
+	if r.Prepare.Version == "" {
+		version, err := node.BackupVersion(r.BackupDir)
+		if err != nil {
+			return err
+		}
+		node.Inst.prepare.Version = version
+		if err := node.Inst.HandleVersion(); err != nil {
+			return err
+		}
+		node.Inst.HandleArgs("")
+	}
+
+	// 目标机器上的恢复使用 --yes, 在控制机上确认
+	if !r.Prepare.Yes {
+		exists := node.Conn.IsExists(filepath.ToSlash(node.Inst.serverFileFullName)) && node.Conn.IsExists(filepath.ToSlash(node.Inst.serviceFileFullName))
+		if err := r.confirm(node.Inst, exists); err != nil {
+			return err
+		}
+	}
+
+	if err := node.CheckTmpDir(); err != nil {
+		return err
+	}
+	defer node.DropTmpDir()
+
+	logger.Infof("将安装包复制到目标机器\n")
+	if err := node.Scp(path.Join(environment.GlobalEnv().ProgramPath, "..")); err != nil {
+		return err
+	}
+
+	logger.Infof("开始恢复 PGSQL 实例\n")
+	if err := node.Restore(r); err != nil {
+		logger.Warningf("恢复失败\n")
+		return err
+	}
+	logger.Successf("实例: %s:%d 恢复成功\n", ssho.Host, node.Inst.port)
+	output.Created(output.Resource{Kind: "instance", Engine: config.Kinds, Host: ssho.Host, Port: node.Inst.port, Path: node.Inst.basePath, Service: node.Inst.serviceFileName})
+	return nil
+}
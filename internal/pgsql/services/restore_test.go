@@ -0,0 +1,41 @@
+package services
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRestoreHandleStandby(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, StandbySignalFile), nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	autoConf := filepath.Join(dir, AutoConfFileName)
+	if err := os.WriteFile(autoConf, []byte("# Do not edit this file manually!\nprimary_conninfo = 'host=10.0.0.1 port=5432'\nwork_mem = '8MB'\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	r := &Restore{KeepStandby: true, inst: &Install{dataPath: dir}}
+	if err := r.handleStandby(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, StandbySignalFile)); err != nil {
+		t.Fatalf("保留从库时删除了 %s", StandbySignalFile)
+	}
+
+	r.KeepStandby = false
+	if err := r.handleStandby(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, StandbySignalFile)); !os.IsNotExist(err) {
+		t.Fatalf("没有删除 %s", StandbySignalFile)
+	}
+	b, err := os.ReadFile(autoConf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := string(b); got != "# Do not edit this file manually!\nwork_mem = '8MB'\n" {
+		t.Fatalf("%s 内容不正确: %q", AutoConfFileName, got)
+	}
+}
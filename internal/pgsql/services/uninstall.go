@@ -7,6 +7,7 @@ package services
 
 import (
 	"dbup/internal/global"
+	"dbup/internal/inventory"
 	"dbup/internal/pgsql/config"
 	"dbup/internal/utils"
 	"dbup/internal/utils/command"
@@ -66,6 +67,9 @@ func (i UNInstall) Uninstall() error {
 			logger.Warningf("删除安装目录成功\n")
 		}
 	}
+	if err := inventory.Forget(config.Kinds, i.Port); err != nil {
+		logger.Warningf("更新实例清单失败: %v\n", err)
+	}
 	return nil
 }
 
@@ -148,5 +152,8 @@ func (i UNInstall) PGautofaileoverUninstall() error {
 			logger.Warningf("删除安装目录成功\n")
 		}
 	}
+	if err := inventory.Forget(config.Kinds, i.Port); err != nil {
+		logger.Warningf("更新实例清单失败: %v\n", err)
+	}
 	return nil
 }
@@ -7,7 +7,9 @@ import (
 	"dbup/internal/utils"
 	"dbup/internal/utils/command"
 	"dbup/internal/utils/logger"
+	"dbup/internal/utils/prompt"
 	"fmt"
+	"io/ioutil"
 	"os"
 	"path/filepath"
 	"regexp"
@@ -18,6 +20,7 @@ type UPgrade struct {
 	Dir         string
 	Port        int
 	OldVersion  string
+	Major       string
 	Servicename string
 	// EmoloyDir   string
 	Yes bool
@@ -69,38 +72,57 @@ func (u *UPgrade) VersionComparison() error {
 		return err
 	}
 
-	Bigversion := strings.Split(u.OldVersion, ".")[0]
-	if Bigversion != config.DefaultPGVersion {
-		return fmt.Errorf("升级只支持同一个大版本 Postgresql %s 中进行小版本迭代升级", config.DefaultPGVersion)
+	// 升级包按大版本选择, 只在同一个大版本中进行小版本迭代升级
+	if u.Major, err = config.CheckVersion(strings.Split(u.OldVersion, ".")[0]); err != nil {
+		return fmt.Errorf("升级只支持同一个大版本中进行小版本迭代升级: %v", err)
 	}
 
-	result := command.CompareVersion(u.OldVersion, config.DefaultPGinfoVersion)
+	newVersion, ok := config.LatestMinorVersions[u.Major]
+	if !ok {
+		// 没有记录小版本的大版本, 从升级包中的 postgres 读取
+		if newVersion, err = u.packageVersion(); err != nil {
+			return fmt.Errorf("无法获取升级包的版本, 不能确认是否为升级: %v", err)
+		}
+	}
+	result := command.CompareVersion(u.OldVersion, newVersion)
 	switch result {
 	case 1:
-		return fmt.Errorf(" postgresql 老版本 %s 不能大于新版本 %s ", u.OldVersion, config.DefaultPGinfoVersion)
+		return fmt.Errorf(" postgresql 老版本 %s 不能大于新版本 %s ", u.OldVersion, newVersion)
 	case 0:
-		return fmt.Errorf(" postgresql 老版本 %s 不能等于新版本 %s ", u.OldVersion, config.DefaultPGinfoVersion)
+		return fmt.Errorf(" postgresql 老版本 %s 不能等于新版本 %s ", u.OldVersion, newVersion)
 	}
 
 	// logger.Infof("检测当前版本: %s\n", u.OldVersion)
 	return nil
 }
 
+// packageVersion 把升级包解压到临时目录, 执行其中的 postgres --version 获取版本
+func (u *UPgrade) packageVersion() (string, error) {
+	packageFullName := filepath.Join(environment.GlobalEnv().ProgramPath, global.PackagePath, config.Kinds, config.PackageName(u.Major))
+	if err := global.CheckPackage(environment.GlobalEnv().ProgramPath, packageFullName, config.Kinds); err != nil {
+		return "", err
+	}
+	tmpDir, err := ioutil.TempDir("", "dbup-pgsql-upgrade-")
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(tmpDir)
+	if err := utils.UntarGz(packageFullName, tmpDir); err != nil {
+		return "", err
+	}
+	return command.PGsqlVersion(tmpDir)
+}
+
 func (u *UPgrade) Run() error {
 	if err := u.Validator(); err != nil {
 		return err
 	}
 
 	if !u.Yes {
-		var yes string
 		logger.Warningf("升级版本需要重启本地 pgsql 实例,端口:%d\n", u.Port)
-		logger.Warningf("是否确认重启进行升级[y|n]:")
-		if _, err := fmt.Scanln(&yes); err != nil {
+		if err := prompt.Confirm("是否确认重启进行升级"); err != nil {
 			return err
 		}
-		if strings.ToUpper(yes) != "Y" && strings.ToUpper(yes) != "YES" {
-			os.Exit(0)
-		}
 	}
 
 	logger.Infof("开始升级\n")
@@ -114,8 +136,11 @@ func (u *UPgrade) Run() error {
 }
 
 func (u *UPgrade) InitDefaultpkg() error {
-	logger.Infof("开始检查默认升级版本 %s 的升级包\n", config.DefaultPGinfoVersion)
-	PackageFullName := filepath.Join(environment.GlobalEnv().ProgramPath, global.PackagePath, config.Kinds, fmt.Sprintf(config.PackageFile, config.DefaultPGVersion, environment.GlobalEnv().GOOS, environment.GlobalEnv().GOARCH))
+	logger.Infof("开始检查 PostgreSQL %s 的升级包\n", u.Major)
+	PackageFullName := filepath.Join(environment.GlobalEnv().ProgramPath, global.PackagePath, config.Kinds, config.PackageName(u.Major))
+	if err := global.CheckPackage(environment.GlobalEnv().ProgramPath, PackageFullName, config.Kinds); err != nil {
+		return err
+	}
 
 	// 解压安装包
 	logger.Infof("解压升级包: %s 到 %s \n", PackageFullName, u.Dir)
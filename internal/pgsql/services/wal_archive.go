@@ -0,0 +1,444 @@
+package services
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"dbup/internal/global/s3ceph"
+	"dbup/internal/output"
+	"dbup/internal/pgsql/config"
+	"dbup/internal/utils"
+	"dbup/internal/utils/logger"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+const gzipSuffix = ".gz"
+
+var (
+	// WAL 段, 部分段, 备份标签和时间线历史文件
+	walNameRegexp = regexp.MustCompile(`^([0-9A-F]{24})(\.partial|\.[0-9A-F]{8}\.backup)?$|^[0-9A-F]{8}\.history$`)
+	walSegRegexp  = regexp.MustCompile(`^[0-9A-F]{24}$`)
+	// backup_label 中的起始 WAL, 例: START WAL LOCATION: 0/2000028 (file 000000010000000000000002)
+	startWalRegexp = regexp.MustCompile(`^START WAL LOCATION: .* \(file ([0-9A-F]{24})\)`)
+)
+
+// WalFile 归档中的一个文件
+type WalFile struct {
+	Name    string    `json:"name"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mod_time"`
+}
+
+// WAL 文件名, 去掉压缩后缀
+func (f WalFile) wal() string {
+	return strings.TrimSuffix(f.Name, gzipSuffix)
+}
+
+// WalArchive 把 WAL 归档到本地目录或 S3, 从归档中取回, 查看归档状态和按基础备份清理
+type WalArchive struct {
+	Config *config.WalArchiveConfig
+	s3     *s3ceph.S3Ceph
+}
+
+func NewWalArchive(cfgFile string) (*WalArchive, error) {
+	c := &config.WalArchiveConfig{}
+	if err := c.Load(cfgFile); err != nil {
+		return nil, err
+	}
+	w := &WalArchive{Config: c}
+	if c.IsS3() {
+		var err error
+		if w.s3, err = s3ceph.NewS3Ceph(c.EndPoint, c.AccessKey, c.SecretKey, c.Mode); err != nil {
+			return nil, err
+		}
+	}
+	return w, nil
+}
+
+// archiveName 归档后的文件名
+func (w *WalArchive) archiveName(name string) string {
+	if w.Config.Compress {
+		return name + gzipSuffix
+	}
+	return name
+}
+
+// key S3 上的对象名
+func (w *WalArchive) key(name string) string {
+	_, prefix := w.Config.Bucket()
+	return path.Join(prefix, name)
+}
+
+// Push 归档一个 WAL 文件, 由 archive_command 调用: push %p %f
+func (w *WalArchive) Push(walPath, name string) error {
+	if !walNameRegexp.MatchString(name) {
+		return fmt.Errorf("不是 WAL 文件名: %s", name)
+	}
+	if w.s3 != nil {
+		return w.pushS3(walPath, name)
+	}
+	return w.pushLocal(walPath, name)
+}
+
+func (w *WalArchive) pushLocal(walPath, name string) error {
+	target := filepath.Join(w.Config.Target, w.archiveName(name))
+	if utils.IsExists(target) {
+		// 重复归档内容相同的文件时返回成功, 内容不同时报错, 不覆盖已有的归档
+		same, err := sameContent(walPath, target)
+		if err != nil {
+			return err
+		}
+		if same {
+			return nil
+		}
+		return fmt.Errorf("归档中已经存在内容不同的文件: %s", target)
+	}
+
+	tmp := target + ".tmp"
+	if err := writeWal(walPath, tmp, w.Config.Compress); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, target)
+}
+
+// pushS3 上传到 S3, 同名对象会被覆盖
+func (w *WalArchive) pushS3(walPath, name string) error {
+	bucket, _ := w.Config.Bucket()
+	source := walPath
+	if w.Config.Compress {
+		tmp, err := ioutil.TempFile("", name+".*"+gzipSuffix)
+		if err != nil {
+			return err
+		}
+		tmp.Close()
+		defer os.Remove(tmp.Name())
+		if err := writeWal(walPath, tmp.Name(), true); err != nil {
+			return err
+		}
+		source = tmp.Name()
+	}
+	return w.s3.Upload(bucket, source, w.key(w.archiveName(name)))
+}
+
+// Fetch 从归档中取回一个 WAL 文件, 由 restore_command 调用: fetch %f %p. 归档中没有时返回错误, pgsql 据此结束恢复
+func (w *WalArchive) Fetch(name, target string) error {
+	if !walNameRegexp.MatchString(name) {
+		return fmt.Errorf("不是 WAL 文件名: %s", name)
+	}
+	if w.s3 != nil {
+		return w.fetchS3(name, target)
+	}
+	// 归档中的文件可能是压缩前或压缩后的, 与当前是否压缩无关
+	for _, n := range []string{name, name + gzipSuffix} {
+		source := filepath.Join(w.Config.Target, n)
+		if utils.IsExists(source) {
+			return readWal(source, target)
+		}
+	}
+	return fmt.Errorf("归档中没有 %s", name)
+}
+
+func (w *WalArchive) fetchS3(name, target string) error {
+	bucket, _ := w.Config.Bucket()
+	objs, err := w.s3.ListObjectFromBucket(bucket, w.key(name))
+	if err != nil {
+		return err
+	}
+	for _, n := range []string{name, name + gzipSuffix} {
+		for _, obj := range objs {
+			if obj.Key != w.key(n) {
+				continue
+			}
+			if !strings.HasSuffix(n, gzipSuffix) {
+				return w.s3.Download(bucket, obj.Key, target)
+			}
+			tmp, err := ioutil.TempFile("", n+".*")
+			if err != nil {
+				return err
+			}
+			tmp.Close()
+			defer os.Remove(tmp.Name())
+			if err := w.s3.Download(bucket, obj.Key, tmp.Name()); err != nil {
+				return err
+			}
+			return readWal(tmp.Name(), target)
+		}
+	}
+	return fmt.Errorf("归档中没有 %s", name)
+}
+
+// List 列出归档中的 WAL 文件, 按文件名排序
+func (w *WalArchive) List() ([]WalFile, error) {
+	var files []WalFile
+	if w.s3 != nil {
+		bucket, prefix := w.Config.Bucket()
+		if prefix != "" {
+			prefix += "/"
+		}
+		objs, err := w.s3.ListAllObjects(bucket, prefix)
+		if err != nil {
+			return nil, err
+		}
+		for _, obj := range objs {
+			f := WalFile{Name: strings.TrimPrefix(obj.Key, prefix), Size: obj.Size, ModTime: obj.LastModified}
+			if walNameRegexp.MatchString(f.wal()) {
+				files = append(files, f)
+			}
+		}
+	} else {
+		infos, err := ioutil.ReadDir(w.Config.Target)
+		if err != nil {
+			return nil, err
+		}
+		for _, info := range infos {
+			f := WalFile{Name: info.Name(), Size: info.Size(), ModTime: info.ModTime()}
+			if !info.IsDir() && walNameRegexp.MatchString(f.wal()) {
+				files = append(files, f)
+			}
+		}
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].Name < files[j].Name })
+	return files, nil
+}
+
+func (w *WalArchive) remove(f WalFile) error {
+	if w.s3 != nil {
+		bucket, _ := w.Config.Bucket()
+		return w.s3.DeleteObject(bucket, w.key(f.Name))
+	}
+	return os.Remove(filepath.Join(w.Config.Target, f.Name))
+}
+
+// walStatus 归档状态, 用于 json 格式输出
+type walStatus struct {
+	Target       string    `json:"target"`
+	Compress     bool      `json:"compress"`
+	Segments     int       `json:"segments"`
+	Size         int64     `json:"size"`
+	First        string    `json:"first,omitempty"`
+	Last         string    `json:"last,omitempty"`
+	LastArchived time.Time `json:"last_archived,omitempty"`
+	Pending      int       `json:"pending"`
+}
+
+// Status 查看归档状态. dataDir 不为空时统计实例中等待归档的 WAL 数量
+func (w *WalArchive) Status(dataDir string) error {
+	files, err := w.List()
+	if err != nil {
+		return err
+	}
+	s := walStatus{Target: w.Config.Target, Compress: w.Config.Compress, Pending: -1}
+	for _, f := range files {
+		s.Size += f.Size
+		if f.ModTime.After(s.LastArchived) {
+			s.LastArchived = f.ModTime
+		}
+		if !walSegRegexp.MatchString(f.wal()) {
+			continue
+		}
+		s.Segments++
+		if s.First == "" {
+			s.First = f.wal()
+		}
+		s.Last = f.wal()
+	}
+	if dataDir != "" {
+		if s.Pending, err = pendingWal(dataDir); err != nil {
+			logger.Warningf("统计等待归档的 WAL 失败: %v\n", err)
+		}
+	}
+
+	output.Set("wal_archive", s)
+	logger.Successf("归档位置: %s\n", s.Target)
+	logger.Successf("是否压缩: %v\n", s.Compress)
+	logger.Successf("WAL 段数: %d\n", s.Segments)
+	logger.Successf("占用空间: %d MB\n", s.Size/1024/1024)
+	if s.Segments > 0 {
+		logger.Successf("最早的段: %s\n", s.First)
+		logger.Successf("最新的段: %s\n", s.Last)
+		logger.Successf("最后归档: %s\n", s.LastArchived.Local().Format("2006-01-02 15:04:05"))
+	}
+	if s.Pending >= 0 {
+		logger.Successf("等待归档: %d\n", s.Pending)
+		if s.Pending > 10 {
+			logger.Warningf("等待归档的 WAL 较多, 请检查 archive_command 是否执行失败: %s\n", filepath.Join(dataDir, "log"))
+		}
+	}
+	return nil
+}
+
+// pendingWal 数据目录中 pg_wal/archive_status 下等待归档的 WAL 数量
+func pendingWal(dataDir string) (int, error) {
+	infos, err := ioutil.ReadDir(filepath.Join(dataDir, "pg_wal", "archive_status"))
+	if err != nil {
+		return -1, err
+	}
+	n := 0
+	for _, info := range infos {
+		if strings.HasSuffix(info.Name(), ".ready") {
+			n++
+		}
+	}
+	return n, nil
+}
+
+// Prune 删除比备份目录中最早的基础备份还早的 WAL, 时间线历史文件全部保留
+func (w *WalArchive) Prune(backupDir string, dryRun bool) error {
+	start, backup, err := OldestBackupWal(backupDir)
+	if err != nil {
+		return err
+	}
+	logger.Infof("最早的基础备份: %s, 起始 WAL: %s\n", backup, start)
+
+	files, err := w.List()
+	if err != nil {
+		return err
+	}
+	var count int
+	var size int64
+	for _, f := range files {
+		wal := f.wal()
+		if strings.HasSuffix(wal, ".history") || !walOlder(wal, start) {
+			continue
+		}
+		if dryRun {
+			output.Item("removed", f, "可以删除: %s\n", f.Name)
+		} else {
+			if err := w.remove(f); err != nil {
+				return fmt.Errorf("删除归档 %s 失败: %v", f.Name, err)
+			}
+			output.Item("removed", f, "删除: %s\n", f.Name)
+		}
+		count++
+		size += f.Size
+	}
+	if dryRun {
+		logger.Successf("可以删除 %d 个文件, %d MB\n", count, size/1024/1024)
+	} else {
+		logger.Successf("删除了 %d 个文件, %d MB\n", count, size/1024/1024)
+	}
+	return nil
+}
+
+// walOlder WAL 文件是否在 start 段之前. 与 pg_archivecleanup 一样只比较日志号和段号, 不比较时间线
+func walOlder(wal, start string) bool {
+	return wal[8:24] < start[8:24]
+}
+
+// OldestBackupWal 备份目录中 pg_backup_* 基础备份最早的起始 WAL 段
+func OldestBackupWal(backupDir string) (string, string, error) {
+	infos, err := ioutil.ReadDir(backupDir)
+	if err != nil {
+		return "", "", err
+	}
+	var start, backup string
+	for _, info := range infos {
+		if !info.IsDir() || !strings.HasPrefix(info.Name(), "pg_backup_") {
+			continue
+		}
+		wal, err := backupStartWal(filepath.Join(backupDir, info.Name()))
+		if err != nil {
+			logger.Warningf("跳过备份 %s: %v\n", info.Name(), err)
+			continue
+		}
+		if start == "" || walOlder(wal, start) {
+			start, backup = wal, info.Name()
+		}
+	}
+	if start == "" {
+		return "", "", fmt.Errorf("备份目录(%s)中没有可用的基础备份, 不清理 WAL 归档", backupDir)
+	}
+	return start, backup, nil
+}
+
+// backupStartWal 从 backup_label 中读取备份的起始 WAL 段
+func backupStartWal(dir string) (string, error) {
+	f, err := os.Open(filepath.Join(dir, "backup_label"))
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if m := startWalRegexp.FindStringSubmatch(scanner.Text()); m != nil {
+			return m[1], nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return "", err
+	}
+	return "", fmt.Errorf("backup_label 中没有 START WAL LOCATION")
+}
+
+// writeWal 复制或压缩 WAL 文件, 写完后落盘
+func writeWal(source, target string, compress bool) error {
+	in, err := os.Open(source)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	if compress {
+		gz := gzip.NewWriter(out)
+		if _, err := io.Copy(gz, in); err != nil {
+			return err
+		}
+		if err := gz.Close(); err != nil {
+			return err
+		}
+	} else if _, err := io.Copy(out, in); err != nil {
+		return err
+	}
+	return out.Sync()
+}
+
+// readWal 把归档中的文件解压或复制到 target
+func readWal(source, target string) error {
+	b, err := readArchived(source)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(target, b, 0600)
+}
+
+// readArchived 读取归档中的文件, .gz 结尾的先解压
+func readArchived(filename string) ([]byte, error) {
+	b, err := ioutil.ReadFile(filename)
+	if err != nil || !strings.HasSuffix(filename, gzipSuffix) {
+		return b, err
+	}
+	gz, err := gzip.NewReader(bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+	defer gz.Close()
+	return ioutil.ReadAll(gz)
+}
+
+// sameContent WAL 文件与归档中的文件内容是否相同
+func sameContent(walPath, archived string) (bool, error) {
+	a, err := ioutil.ReadFile(walPath)
+	if err != nil {
+		return false, err
+	}
+	b, err := readArchived(archived)
+	if err != nil {
+		return false, err
+	}
+	return bytes.Equal(a, b), nil
+}
@@ -0,0 +1,65 @@
+package services
+
+import (
+	"bytes"
+	"dbup/internal/pgsql/config"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWalArchiveLocal(t *testing.T) {
+	dir := t.TempDir()
+	target := filepath.Join(dir, "archive")
+	if err := os.Mkdir(target, 0700); err != nil {
+		t.Fatal(err)
+	}
+	w := &WalArchive{Config: &config.WalArchiveConfig{Target: target, Compress: true}}
+
+	seg1, seg2 := "000000010000000000000001", "000000010000000000000003"
+	data := bytes.Repeat([]byte("wal"), 1024)
+	for _, name := range []string{seg1, seg2, "00000002.history"} {
+		src := filepath.Join(dir, name)
+		if err := os.WriteFile(src, data, 0600); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Push(src, name); err != nil {
+			t.Fatal(err)
+		}
+		// 重复归档相同内容的文件返回成功
+		if err := w.Push(src, name); err != nil {
+			t.Fatalf("重复归档 %s: %v", name, err)
+		}
+	}
+
+	restored := filepath.Join(dir, "RECOVERYXLOG")
+	if err := w.Fetch(seg1, restored); err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := os.ReadFile(restored); !bytes.Equal(b, data) {
+		t.Fatal("取回的 WAL 内容与归档前不同")
+	}
+
+	backup := filepath.Join(dir, "backup", "pg_backup_20210102150405")
+	if err := os.MkdirAll(backup, 0700); err != nil {
+		t.Fatal(err)
+	}
+	label := "START WAL LOCATION: 0/2000028 (file 000000010000000000000002)\nCHECKPOINT LOCATION: 0/2000060\n"
+	if err := os.WriteFile(filepath.Join(backup, "backup_label"), []byte(label), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Prune(filepath.Dir(backup), false); err != nil {
+		t.Fatal(err)
+	}
+	files, err := w.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, f := range files {
+		names = append(names, f.wal())
+	}
+	if len(names) != 2 || names[0] != seg2 || names[1] != "00000002.history" {
+		t.Fatalf("清理后的归档: %v", names)
+	}
+}
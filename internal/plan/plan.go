@@ -0,0 +1,282 @@
+package plan
+
+// 部署计划: 集群部署命令指定 --plan 时, 只解析配置文件并以只读方式连接各个节点,
+// 按节点列出部署时将要执行的操作, 同时检查端口占用、目录非空、启动文件已存在等冲突, 不做任何修改
+
+import (
+	"dbup/internal/global"
+	"dbup/internal/output"
+	"dbup/internal/utils/command"
+	"dbup/internal/utils/sshutil"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// 操作类型
+const (
+	ActionMkdir       = "mkdir"
+	ActionCopy        = "copy"
+	ActionInstall     = "install"
+	ActionService     = "service"
+	ActionReplication = "replication"
+	ActionUser        = "user"
+	ActionCleanup     = "cleanup"
+)
+
+var actionNames = map[string]string{
+	ActionMkdir:       "创建目录",
+	ActionCopy:        "复制文件",
+	ActionInstall:     "安装实例",
+	ActionService:     "安装服务",
+	ActionReplication: "建立复制",
+	ActionUser:        "创建用户",
+	ActionCleanup:     "清理",
+}
+
+// Action 一个部署操作
+type Action struct {
+	Kind   string `json:"kind"`
+	Detail string `json:"detail"`
+}
+
+// Host 一个节点上的部署操作和冲突
+type Host struct {
+	Host      string   `json:"host"`
+	Actions   []Action `json:"actions"`
+	Conflicts []string `json:"conflicts,omitempty"`
+
+	conn   remote
+	ports  map[int]bool
+	staged bool
+}
+
+// Plan 部署计划
+type Plan struct {
+	Engine string  `json:"engine"`
+	Mode   string  `json:"mode"`
+	Hosts  []*Host `json:"hosts"`
+
+	dial func(host string) (remote, error)
+}
+
+// Dialer 建立到节点的 ssh 连接
+type Dialer func(host string) (*command.Connection, error)
+
+// remote 检查节点时使用的只读操作, 由 *command.Connection 实现
+type remote interface {
+	IsExists(path string) bool
+	IsDir(path string) bool
+	IsEmpty(path string) (bool, error)
+	ReadFile(name string) ([]byte, error)
+	Close() error
+}
+
+// SSHDialer 使用部署配置中的 ssh 参数建立连接, 没有密码时使用私钥
+func SSHDialer(user, password, keyfile string, port int, opts sshutil.Options) Dialer {
+	return func(host string) (*command.Connection, error) {
+		if password != "" {
+			return command.NewConnection(host, user, password, port, 30, opts)
+		}
+		return command.NewConnectionUseKeyFile(host, user, keyfile, port, 30, opts)
+	}
+}
+
+func New(engine, mode string, dial Dialer) *Plan {
+	return &Plan{Engine: engine, Mode: mode, dial: func(host string) (remote, error) {
+		conn, err := dial(host)
+		if err != nil {
+			return nil, err
+		}
+		return conn, nil
+	}}
+}
+
+// Host 返回节点, 第一次出现时建立连接. 连接失败记录为冲突, 之后对该节点的检查不再执行
+func (p *Plan) Host(host string) *Host {
+	for _, h := range p.Hosts {
+		if h.Host == host {
+			return h
+		}
+	}
+	h := &Host{Host: host}
+	p.Hosts = append(p.Hosts, h)
+	conn, err := p.dial(host)
+	if err != nil {
+		h.Conflictf("建立ssh连接失败: %v", err)
+		return h
+	}
+	h.conn = conn
+	return h
+}
+
+// Add 添加操作
+func (h *Host) Add(kind, format string, args ...interface{}) {
+	h.Actions = append(h.Actions, Action{Kind: kind, Detail: fmt.Sprintf(format, args...)})
+}
+
+// Conflictf 记录冲突
+func (h *Host) Conflictf(format string, args ...interface{}) {
+	h.Conflicts = append(h.Conflicts, fmt.Sprintf(format, args...))
+}
+
+// Stage 复制部署所需文件到临时目录, 每个节点只复制一次
+func (h *Host) Stage(tmpDir string, files ...string) {
+	if h.staged {
+		return
+	}
+	h.staged = true
+	h.CheckDir(tmpDir)
+	h.Add(ActionMkdir, "%s", filepath.ToSlash(tmpDir))
+	for _, file := range files {
+		h.Add(ActionCopy, "%s", filepath.ToSlash(path.Join(tmpDir, file)))
+	}
+}
+
+// Instance 安装一个实例: 创建安装目录, 安装 systemd 启动文件. 同时检查端口、目录和启动文件
+func (h *Host) Instance(engine string, port int, dir, service string) {
+	h.CheckPort(port)
+	h.CheckDir(dir)
+	h.CheckService(service)
+	h.Add(ActionInstall, "%s 端口 %d", engine, port)
+	h.Add(ActionMkdir, "%s", dir)
+	h.Add(ActionService, "%s", filepath.ToSlash(filepath.Join(global.ServicePath, service)))
+}
+
+// CheckPort 检查端口是否已经在监听
+func (h *Host) CheckPort(port int) {
+	if h.conn == nil {
+		return
+	}
+	if h.ports == nil {
+		ports, err := listening(h.conn)
+		if err != nil {
+			h.Conflictf("读取监听端口失败: %v", err)
+			return
+		}
+		h.ports = ports
+	}
+	if h.ports[port] {
+		h.Conflictf("端口 %d 已被占用", port)
+	}
+}
+
+// CheckDir 检查目录是否已经存在并且不为空
+func (h *Host) CheckDir(dir string) {
+	if h.conn == nil {
+		return
+	}
+	dir = filepath.ToSlash(dir)
+	if !h.conn.IsExists(dir) {
+		return
+	}
+	if !h.conn.IsDir(dir) {
+		h.Conflictf("%s 已经存在并且不是目录", dir)
+		return
+	}
+	empty, err := h.conn.IsEmpty(dir)
+	if err != nil {
+		h.Conflictf("判断目录 %s 是否为空失败: %v", dir, err)
+		return
+	}
+	if !empty {
+		h.Conflictf("目录 %s 不为空", dir)
+	}
+}
+
+// CheckService 检查 systemd 启动文件是否已经存在
+func (h *Host) CheckService(service string) {
+	if h.conn == nil {
+		return
+	}
+	for _, dir := range []string{global.ServicePath, "/etc/systemd/system"} {
+		file := path.Join(dir, service)
+		if h.conn.IsExists(file) {
+			h.Conflictf("启动文件 %s 已经存在", file)
+		}
+	}
+}
+
+// Conflicts 冲突总数
+func (p *Plan) Conflicts() int {
+	var n int
+	for _, h := range p.Hosts {
+		n += len(h.Conflicts)
+	}
+	return n
+}
+
+// Finish 输出部署计划并关闭连接, 存在冲突时返回错误
+func (p *Plan) Finish() error {
+	for _, h := range p.Hosts {
+		if h.conn != nil {
+			h.conn.Close()
+		}
+	}
+	if output.IsJSON() {
+		output.Set("plan", p)
+	} else {
+		p.Print()
+	}
+	if n := p.Conflicts(); n > 0 {
+		return output.Errorf(output.CodeConflict, "部署计划存在 %d 处冲突, 请处理后再部署", n)
+	}
+	return nil
+}
+
+// Print 按节点打印部署计划
+func (p *Plan) Print() {
+	fmt.Printf("部署计划: %s %s\n", p.Engine, p.Mode)
+	for _, h := range p.Hosts {
+		fmt.Printf("\n[%s]\n", h.Host)
+		for n, a := range h.Actions {
+			fmt.Printf("  %2d. %s: %s\n", n+1, actionNames[a.Kind], a.Detail)
+		}
+		for _, c := range h.Conflicts {
+			fmt.Printf("  冲突: %s\n", c)
+		}
+	}
+	fmt.Println()
+	if n := p.Conflicts(); n > 0 {
+		fmt.Printf("共 %d 个节点, %d 处冲突\n", len(p.Hosts), n)
+		return
+	}
+	fmt.Printf("共 %d 个节点, 没有冲突\n", len(p.Hosts))
+}
+
+// listening 读取 /proc/net/tcp 和 /proc/net/tcp6, 返回处于监听状态的端口
+func listening(conn remote) (map[int]bool, error) {
+	ports := make(map[int]bool)
+	for _, file := range []string{"/proc/net/tcp", "/proc/net/tcp6"} {
+		b, err := conn.ReadFile(file)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		ParseListening(string(b), ports)
+	}
+	return ports, nil
+}
+
+// ParseListening 解析 /proc/net/tcp 格式的内容, 状态 0A 为 LISTEN, 监听的端口保存到 ports
+func ParseListening(content string, ports map[int]bool) {
+	for _, line := range strings.Split(content, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 4 || fields[3] != "0A" {
+			continue
+		}
+		local := fields[1]
+		n := strings.LastIndex(local, ":")
+		if n < 0 {
+			continue
+		}
+		var port int
+		if _, err := fmt.Sscanf(local[n+1:], "%X", &port); err == nil {
+			ports[port] = true
+		}
+	}
+}
@@ -0,0 +1,112 @@
+package plan
+
+import (
+	"dbup/internal/global"
+	"fmt"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestParseListening(t *testing.T) {
+	content := `  sl  local_address rem_address   st tx_queue rx_queue tr tm->when retrnsmt   uid  timeout inode
+   0: 00000000:1538 00000000:0000 0A 00000000:00000000 00:00000000 00000000   26        0 20462 1 0000000000000000 100 0 0 10 0
+   1: 0100007F:18EB 0100007F:1538 01 00000000:00000000 00:00000000 00000000   26        0 20463 1 0000000000000000 100 0 0 10 0
+   2: 00000000000000000000000000000000:18EB 00000000000000000000000000000000:0000 0A 00000000:00000000 00:00000000 00000000     0        0 20464 1 0000000000000000 100 0 0 10 0
+`
+	ports := make(map[int]bool)
+	ParseListening(content, ports)
+	if !ports[5432] || !ports[6379] || len(ports) != 2 {
+		t.Fatalf("解析监听端口不正确: %v", ports)
+	}
+}
+
+// fakeRemote 模拟节点: dirs 为目录及其中的文件数, files 为文件内容
+type fakeRemote struct {
+	dirs   map[string]int
+	files  map[string]string
+	closed bool
+}
+
+func (f *fakeRemote) IsExists(path string) bool {
+	_, dir := f.dirs[path]
+	_, file := f.files[path]
+	return dir || file
+}
+
+func (f *fakeRemote) IsDir(path string) bool {
+	_, ok := f.dirs[path]
+	return ok
+}
+
+func (f *fakeRemote) IsEmpty(path string) (bool, error) {
+	return f.dirs[path] == 0, nil
+}
+
+func (f *fakeRemote) ReadFile(name string) ([]byte, error) {
+	content, ok := f.files[name]
+	if !ok {
+		return nil, os.ErrNotExist
+	}
+	return []byte(content), nil
+}
+
+func (f *fakeRemote) Close() error {
+	f.closed = true
+	return nil
+}
+
+func TestPlan(t *testing.T) {
+	// 主库所在机器上 5432 端口已被占用, 安装目录不为空; 从库所在机器没有冲突; 第三台机器连接失败
+	master := &fakeRemote{
+		dirs:  map[string]int{"/tmp/dbup": 0, "/opt/pgsql": 3},
+		files: map[string]string{"/proc/net/tcp": "  sl  local_address\n   0: 00000000:1538 00000000:0000 0A\n"},
+	}
+	slave := &fakeRemote{dirs: map[string]int{}, files: map[string]string{}}
+	remotes := map[string]*fakeRemote{"10.0.0.1": master, "10.0.0.2": slave}
+	p := &Plan{Engine: "pgsql", Mode: "master-slave", dial: func(host string) (remote, error) {
+		if r, ok := remotes[host]; ok {
+			return r, nil
+		}
+		return nil, fmt.Errorf("连接超时")
+	}}
+
+	for _, host := range []string{"10.0.0.1", "10.0.0.2", "10.0.0.3"} {
+		h := p.Host(host)
+		h.Stage("/tmp/dbup", "bin/dbup")
+		h.Instance("pgsql", 5432, "/opt/pgsql", "postgres5432.service")
+	}
+	if h := p.Host("10.0.0.1"); h != p.Hosts[0] || len(p.Hosts) != 3 {
+		t.Fatalf("同一节点应该只有一个: %d", len(p.Hosts))
+	}
+
+	wantActions := []Action{
+		{ActionMkdir, "/tmp/dbup"},
+		{ActionCopy, "/tmp/dbup/bin/dbup"},
+		{ActionInstall, "pgsql 端口 5432"},
+		{ActionMkdir, "/opt/pgsql"},
+		{ActionService, global.ServicePath + "/postgres5432.service"},
+	}
+	for _, h := range p.Hosts {
+		if !reflect.DeepEqual(h.Actions, wantActions) {
+			t.Errorf("%s 的操作不正确: %+v", h.Host, h.Actions)
+		}
+	}
+	wantConflicts := map[string][]string{
+		"10.0.0.1": {"端口 5432 已被占用", "目录 /opt/pgsql 不为空"},
+		"10.0.0.2": nil,
+		"10.0.0.3": {"建立ssh连接失败: 连接超时"},
+	}
+	for _, h := range p.Hosts {
+		if !reflect.DeepEqual(h.Conflicts, wantConflicts[h.Host]) {
+			t.Errorf("%s 的冲突不正确: %q", h.Host, h.Conflicts)
+		}
+	}
+
+	if err := p.Finish(); err == nil || p.Conflicts() != 3 {
+		t.Fatalf("有冲突时应该返回错误: %v, %d", err, p.Conflicts())
+	}
+	if !master.closed || !slave.closed {
+		t.Fatal("Finish 应该关闭连接")
+	}
+}
@@ -0,0 +1,344 @@
+package preflight
+
+import (
+	"dbup/internal/global"
+	"dbup/internal/plan"
+	"dbup/internal/utils/command"
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 检查项
+const (
+	CheckSSH        = "ssh"
+	CheckKernel     = "kernel"
+	CheckTHP        = "thp"
+	CheckSwappiness = "swappiness"
+	CheckUlimit     = "ulimit"
+	CheckClock      = "clock"
+	CheckDisk       = "disk"
+	CheckLibs       = "libs"
+	CheckPort       = "port"
+	CheckSELinux    = "selinux"
+	CheckFirewall   = "firewall"
+)
+
+var checkNames = map[string]string{
+	CheckSSH:        "ssh 连接",
+	CheckKernel:     "内核版本",
+	CheckTHP:        "透明大页",
+	CheckSwappiness: "swappiness",
+	CheckUlimit:     "ulimit",
+	CheckClock:      "时钟同步",
+	CheckDisk:       "磁盘空间",
+	CheckLibs:       "共享库",
+	CheckPort:       "端口",
+	CheckSELinux:    "SELinux",
+	CheckFirewall:   "防火墙",
+}
+
+// Requirement 引擎对节点环境的要求
+type Requirement struct {
+	Kernel     string   // 最低内核版本
+	Swappiness int      // vm.swappiness 建议的上限
+	NoFile     int      // ulimit -n 建议的下限
+	NProc      int      // ulimit -u 建议的下限
+	DiskGB     uint64   // 目标目录所在磁盘的最小剩余空间, 低于两倍时警告
+	Libs       []string // 安装包没有自带, 需要系统提供的共享库
+}
+
+// Requirements 各引擎的环境要求, 没有列出的引擎使用 pgsql 的要求
+var Requirements = map[string]Requirement{
+	"pgsql":   {Kernel: "3.10", Swappiness: 10, NoFile: 65535, NProc: 65535, DiskGB: 10, Libs: []string{"libz.so.1"}},
+	"redis":   {Kernel: "3.10", Swappiness: 10, NoFile: 65535, NProc: 65535, DiskGB: 5, Libs: []string{"libatomic.so"}},
+	"mariadb": {Kernel: "3.10", Swappiness: 10, NoFile: 65535, NProc: 65535, DiskGB: 10, Libs: []string{"libaio.so", "libz.so.1"}},
+	"mongodb": {Kernel: "3.10", Swappiness: 10, NoFile: 64000, NProc: 64000, DiskGB: 10, Libs: []string{"libcrypto.so", "libcurl.so"}},
+}
+
+// 允许的时钟偏差
+const maxClockSkew = 2 * time.Second
+
+func requirement(engine string) Requirement {
+	if r, ok := Requirements[engine]; ok {
+		return r
+	}
+	return Requirements["pgsql"]
+}
+
+// check 检查一个节点上的一种引擎, remote 为 true 时同时检查与本机的时钟偏差
+func check(run Runner, t *target, remote bool) []Result {
+	req := requirement(t.engine)
+	results := []Result{
+		checkKernel(run, req),
+		checkTHP(run),
+		checkSwappiness(run, req),
+		checkUlimit(run, req),
+		checkClock(run, remote),
+	}
+	for _, dir := range t.dirs {
+		results = append(results, checkDisk(run, req, dir))
+	}
+	results = append(results,
+		checkLibs(run, req),
+		checkPorts(run, t.ports),
+		checkSELinux(run),
+		checkFirewall(run, t.ports),
+	)
+	return results
+}
+
+func pass(name, format string, args ...interface{}) Result {
+	return Result{Check: name, Status: StatusPass, Detail: fmt.Sprintf(format, args...)}
+}
+
+func warn(name, format string, args ...interface{}) Result {
+	return Result{Check: name, Status: StatusWarn, Detail: fmt.Sprintf(format, args...)}
+}
+
+func fail(name, format string, args ...interface{}) Result {
+	return Result{Check: name, Status: StatusFail, Detail: fmt.Sprintf(format, args...)}
+}
+
+func checkKernel(run Runner, req Requirement) Result {
+	out, err := run("uname -r")
+	if err != nil {
+		return fail(CheckKernel, "读取内核版本失败: %v", err)
+	}
+	release := strings.TrimSpace(out)
+	version := release
+	if n := strings.IndexAny(release, "-_+"); n > 0 {
+		version = release[:n]
+	}
+	if command.CompareVersion(version, req.Kernel) < 0 {
+		return fail(CheckKernel, "内核版本 %s 低于 %s", release, req.Kernel)
+	}
+	return pass(CheckKernel, "%s", release)
+}
+
+// checkTHP 透明大页应为 never, 各数据库都建议关闭
+func checkTHP(run Runner) Result {
+	out, _ := run("cat /sys/kernel/mm/transparent_hugepage/enabled 2>/dev/null || true")
+	mode := selected(out)
+	switch mode {
+	case "":
+		return pass(CheckTHP, "不支持透明大页")
+	case "never":
+		return pass(CheckTHP, "never")
+	}
+	return warn(CheckTHP, "透明大页为 %s, 建议设置为 never", mode)
+}
+
+// selected 返回 "always madvise [never]" 格式中选中的值
+func selected(s string) string {
+	start, end := strings.Index(s, "["), strings.Index(s, "]")
+	if start < 0 || end < start {
+		return strings.TrimSpace(s)
+	}
+	return s[start+1 : end]
+}
+
+func checkSwappiness(run Runner, req Requirement) Result {
+	out, err := run("cat /proc/sys/vm/swappiness")
+	if err != nil {
+		return warn(CheckSwappiness, "读取 vm.swappiness 失败: %v", err)
+	}
+	v, err := strconv.Atoi(strings.TrimSpace(out))
+	if err != nil {
+		return warn(CheckSwappiness, "vm.swappiness 格式错误: %s", strings.TrimSpace(out))
+	}
+	if v > req.Swappiness {
+		return warn(CheckSwappiness, "vm.swappiness 为 %d, 建议不超过 %d", v, req.Swappiness)
+	}
+	return pass(CheckSwappiness, "%d", v)
+}
+
+// checkUlimit 检查登录用户的文件句柄数和进程数(软限制), 服务由 systemd 启动时以启动文件中的 Limit 为准
+func checkUlimit(run Runner, req Requirement) Result {
+	out, err := run("cat /proc/self/limits")
+	if err != nil {
+		return warn(CheckUlimit, "读取 ulimit 失败: %v", err)
+	}
+	limits := []struct {
+		name  string
+		title string
+		min   int
+		value string
+	}{
+		{name: "nofile", title: "Max open files", min: req.NoFile},
+		{name: "nproc", title: "Max processes", min: req.NProc},
+	}
+	var low, values []string
+	for _, l := range limits {
+		for _, line := range strings.Split(out, "\n") {
+			if strings.HasPrefix(line, l.title) {
+				if fields := strings.Fields(strings.TrimPrefix(line, l.title)); len(fields) > 0 {
+					l.value = fields[0]
+				}
+			}
+		}
+		values = append(values, fmt.Sprintf("%s %s", l.name, l.value))
+		if l.value == "unlimited" {
+			continue
+		}
+		if v, err := strconv.Atoi(l.value); err != nil || v < l.min {
+			low = append(low, fmt.Sprintf("%s 为 %s, 建议不低于 %d", l.name, l.value, l.min))
+		}
+	}
+	if len(low) > 0 {
+		return warn(CheckUlimit, "%s", strings.Join(low, "; "))
+	}
+	return pass(CheckUlimit, "%s", strings.Join(values, ", "))
+}
+
+// checkClock 检查是否开启了 NTP 同步, 远程节点同时检查与本机的时钟偏差
+func checkClock(run Runner, remote bool) Result {
+	before := time.Now()
+	out, err := run("date +%s; timedatectl status 2>/dev/null || true")
+	if err != nil {
+		return warn(CheckClock, "读取时钟状态失败: %v", err)
+	}
+	lines := strings.SplitN(out, "\n", 2)
+	if remote {
+		// 以执行命令前后的中点作为本机时间, 减小网络延迟的影响
+		now := before.Add(time.Since(before) / 2)
+		if sec, err := strconv.ParseInt(strings.TrimSpace(lines[0]), 10, 64); err == nil {
+			skew := time.Unix(sec, 0).Sub(now)
+			if skew < 0 {
+				skew = -skew
+			}
+			if skew > maxClockSkew {
+				return fail(CheckClock, "与本机的时钟偏差为 %s", skew.Round(time.Second))
+			}
+		}
+	}
+	status := ""
+	if len(lines) == 2 {
+		status = strings.ToLower(lines[1])
+	}
+	switch {
+	case strings.Contains(status, "synchronized: yes"):
+		return pass(CheckClock, "已同步")
+	case strings.Contains(status, "synchronized: no"):
+		return warn(CheckClock, "时钟没有同步, 请检查 chronyd 或 ntpd")
+	}
+	return warn(CheckClock, "无法确定时钟同步状态")
+}
+
+// checkDisk 检查目标目录所在磁盘的剩余空间, 目录不存在时检查最近的已存在的上级目录
+func checkDisk(run Runner, req Requirement, dir string) Result {
+	dir = filepath.ToSlash(dir)
+	cmd := fmt.Sprintf(`d='%s'; while [ ! -d "$d" ]; do d=$(dirname "$d"); done; df -Pk "$d" | tail -n 1`, dir)
+	out, err := run(cmd)
+	if err != nil {
+		return fail(CheckDisk, "读取 %s 所在磁盘的剩余空间失败: %v", dir, err)
+	}
+	fields := strings.Fields(out)
+	if len(fields) < 4 {
+		return fail(CheckDisk, "df 输出格式错误: %s", strings.TrimSpace(out))
+	}
+	kb, err := strconv.ParseUint(fields[3], 10, 64)
+	if err != nil {
+		return fail(CheckDisk, "df 输出格式错误: %s", strings.TrimSpace(out))
+	}
+	gb := kb / 1024 / 1024
+	switch {
+	case gb < req.DiskGB:
+		return fail(CheckDisk, "%s 剩余 %dGB, 至少需要 %dGB", dir, gb, req.DiskGB)
+	case gb < 2*req.DiskGB:
+		return warn(CheckDisk, "%s 剩余 %dGB, 建议不少于 %dGB", dir, gb, 2*req.DiskGB)
+	}
+	return pass(CheckDisk, "%s 剩余 %dGB", dir, gb)
+}
+
+func checkLibs(run Runner, req Requirement) Result {
+	if len(req.Libs) == 0 {
+		return pass(CheckLibs, "不需要")
+	}
+	out, err := run("ldconfig -p 2>/dev/null || /sbin/ldconfig -p")
+	if err != nil {
+		return warn(CheckLibs, "读取共享库缓存失败: %v", err)
+	}
+	var missing []string
+	for _, lib := range req.Libs {
+		if strings.Contains(out, lib) {
+			continue
+		}
+		m := "缺少 " + lib
+		for k, v := range global.MissSoLibrariesAndRepairPlanList {
+			if strings.HasPrefix(lib, k) {
+				m = fmt.Sprintf("缺少 %s, 需要: %s", lib, v)
+				break
+			}
+		}
+		missing = append(missing, m)
+	}
+	if len(missing) > 0 {
+		return fail(CheckLibs, "%s", strings.Join(missing, "; "))
+	}
+	return pass(CheckLibs, "%s", strings.Join(req.Libs, ", "))
+}
+
+func checkPorts(run Runner, ports []int) Result {
+	if len(ports) == 0 {
+		return pass(CheckPort, "没有指定端口")
+	}
+	out, err := run("cat /proc/net/tcp /proc/net/tcp6 2>/dev/null || true")
+	if err != nil {
+		return fail(CheckPort, "读取监听端口失败: %v", err)
+	}
+	listening := make(map[int]bool)
+	plan.ParseListening(out, listening)
+	var used, free []string
+	for _, port := range ports {
+		if listening[port] {
+			used = append(used, strconv.Itoa(port))
+		} else {
+			free = append(free, strconv.Itoa(port))
+		}
+	}
+	if len(used) > 0 {
+		return fail(CheckPort, "端口 %s 已被占用", strings.Join(used, ","))
+	}
+	return pass(CheckPort, "端口 %s 可用", strings.Join(free, ","))
+}
+
+// checkSELinux 开启 SELinux 时数据目录和端口需要额外授权
+func checkSELinux(run Runner) Result {
+	out, _ := run("getenforce 2>/dev/null || true")
+	mode := strings.TrimSpace(out)
+	switch mode {
+	case "":
+		return pass(CheckSELinux, "未安装")
+	case "Enforcing":
+		return warn(CheckSELinux, "SELinux 为 Enforcing, 需要为数据目录和端口授权, 或设置为 Permissive")
+	}
+	return pass(CheckSELinux, "%s", mode)
+}
+
+// checkFirewall 防火墙开启时需要放通数据库端口
+func checkFirewall(run Runner, ports []int) Result {
+	out, _ := run("systemctl is-active firewalld 2>/dev/null; ufw status 2>/dev/null | head -n 1; true")
+	var active []string
+	for _, line := range strings.Split(out, "\n") {
+		switch strings.TrimSpace(line) {
+		case "active":
+			active = append(active, "firewalld")
+		case "Status: active":
+			active = append(active, "ufw")
+		}
+	}
+	if len(active) == 0 {
+		return pass(CheckFirewall, "未开启")
+	}
+	var list []string
+	for _, port := range ports {
+		list = append(list, strconv.Itoa(port))
+	}
+	if len(list) == 0 {
+		return warn(CheckFirewall, "%s 已开启, 请确认已放通数据库端口", strings.Join(active, ", "))
+	}
+	return warn(CheckFirewall, "%s 已开启, 请确认已放通端口 %s", strings.Join(active, ", "), strings.Join(list, ","))
+}
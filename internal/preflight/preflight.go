@@ -0,0 +1,183 @@
+package preflight
+
+// 部署前检查: 按节点和引擎检查内核版本、透明大页、swappiness、ulimit、时钟同步、目标目录所在磁盘的剩余空间、
+// 共享库、端口占用、SELinux 和防火墙, 输出 pass/warn/fail 表格. 只读取节点状态, 不做任何修改
+
+import (
+	"dbup/internal/environment"
+	"dbup/internal/output"
+	"dbup/internal/plan"
+	"dbup/internal/utils/command"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+)
+
+// 检查结果状态
+const (
+	StatusPass = "pass"
+	StatusWarn = "warn"
+	StatusFail = "fail"
+)
+
+// Runner 在节点上执行 shell 命令, 返回标准输出
+type Runner func(cmd string) (string, error)
+
+// LocalRunner 在本机执行命令
+func LocalRunner() Runner {
+	return func(cmd string) (string, error) {
+		l := command.Local{Timeout: 30}
+		stdout, stderr, err := l.Run(cmd)
+		if err != nil {
+			return string(stdout), fmt.Errorf("%v, 标准错误: %s", err, stderr)
+		}
+		return string(stdout), nil
+	}
+}
+
+// ConnRunner 通过 ssh 连接在远程节点上执行命令
+func ConnRunner(conn *command.Connection) Runner {
+	return func(cmd string) (string, error) {
+		stdout, err := conn.Run(cmd)
+		return string(stdout), err
+	}
+}
+
+// Result 一项检查的结果
+type Result struct {
+	Host   string `json:"host"`
+	Engine string `json:"engine"`
+	Check  string `json:"check"`
+	Status string `json:"status"`
+	Detail string `json:"detail"`
+}
+
+// target 一个节点上要检查的一种引擎, 同一节点上同一引擎的多个实例合并检查
+type target struct {
+	host   string
+	engine string
+	ports  []int
+	dirs   []string
+}
+
+// Preflight 部署前检查
+type Preflight struct {
+	Results []Result `json:"results"`
+
+	targets []*target
+	dial    plan.Dialer
+}
+
+// New 创建检查, dial 为空时检查本机
+func New(dial plan.Dialer) *Preflight {
+	return &Preflight{dial: dial}
+}
+
+// Add 添加要检查的节点, 引擎的端口和目标目录
+func (p *Preflight) Add(host, engine string, ports []int, dirs ...string) {
+	// 没有指定的端口和目录不检查
+	var validPorts []int
+	for _, port := range ports {
+		if port > 0 {
+			validPorts = append(validPorts, port)
+		}
+	}
+	var validDirs []string
+	for _, dir := range dirs {
+		if dir != "" {
+			validDirs = append(validDirs, dir)
+		}
+	}
+	ports, dirs = validPorts, validDirs
+	for _, t := range p.targets {
+		if t.host == host && t.engine == engine {
+			t.ports = append(t.ports, ports...)
+			t.dirs = append(t.dirs, dirs...)
+			return
+		}
+	}
+	p.targets = append(p.targets, &target{host: host, engine: engine, ports: ports, dirs: dirs})
+}
+
+// Run 依次检查各个节点, 同一节点只建立一次连接
+func (p *Preflight) Run() {
+	runners := make(map[string]Runner)
+	var conns []*command.Connection
+	defer func() {
+		for _, conn := range conns {
+			conn.Close()
+		}
+	}()
+
+	for _, t := range p.targets {
+		run, ok := runners[t.host]
+		if !ok {
+			if p.dial == nil {
+				run = LocalRunner()
+			} else if conn, err := p.dial(t.host); err != nil {
+				p.Results = append(p.Results, Result{Host: t.host, Engine: t.engine, Check: CheckSSH, Status: StatusFail, Detail: fmt.Sprintf("建立ssh连接失败: %v", err)})
+			} else {
+				conns = append(conns, conn)
+				run = ConnRunner(conn)
+			}
+			runners[t.host] = run
+		}
+		if run == nil {
+			continue
+		}
+		for _, c := range check(run, t, p.dial != nil) {
+			c.Host, c.Engine = t.host, t.engine
+			p.Results = append(p.Results, c)
+		}
+	}
+}
+
+// Count 指定状态的检查项数量
+func (p *Preflight) Count(status string) int {
+	var n int
+	for _, r := range p.Results {
+		if r.Status == status {
+			n++
+		}
+	}
+	return n
+}
+
+// Finish 执行检查并输出结果, 有检查项失败时返回错误
+func (p *Preflight) Finish() error {
+	p.Run()
+	if output.IsJSON() {
+		output.Set("checks", p.Results)
+	} else {
+		p.Print()
+	}
+	if n := p.Count(StatusFail); n > 0 {
+		return output.Errorf(output.CodeFailed, "部署前检查有 %d 项未通过, 请处理后再部署", n)
+	}
+	return nil
+}
+
+// Print 按节点和引擎打印检查结果
+func (p *Preflight) Print() {
+	sort.SliceStable(p.Results, func(i, j int) bool {
+		return p.Results[i].Host < p.Results[j].Host
+	})
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "HOST\tENGINE\tCHECK\tSTATUS\tDETAIL")
+	for _, r := range p.Results {
+		detail := strings.Join(strings.Fields(r.Detail), " ")
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", r.Host, r.Engine, checkNames[r.Check], r.Status, detail)
+	}
+	w.Flush()
+	fmt.Printf("\n共 %d 项检查, 通过 %d, 警告 %d, 失败 %d\n", len(p.Results), p.Count(StatusPass), p.Count(StatusWarn), p.Count(StatusFail))
+}
+
+// LocalHost 检查本机时结果中的节点名称
+func LocalHost() string {
+	if hi := environment.GlobalEnv().HostInfo; hi != nil && hi.Hostname != "" {
+		return hi.Hostname
+	}
+	return "localhost"
+}
@@ -0,0 +1,51 @@
+package preflight
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCheck(t *testing.T) {
+	outputs := map[string]string{
+		"uname -r":             "3.10.0-1160.el7.x86_64\n",
+		"cat /sys/kernel/mm":   "always madvise [never]\n",
+		"cat /proc/sys/vm":     "1\n",
+		"cat /proc/self/limit": "Max processes             4096                 4096                 processes\nMax open files            1024                 4096                 files\n",
+		"date +%s":             "1700000000\n   System clock synchronized: yes\n",
+		"d='/data/pgsql5432'":  "/dev/sdb1 104857600 52428800 8388608 87% /data\n",
+		"ldconfig -p":          "\tlibz.so.1 (libc6,x86-64) => /lib64/libz.so.1\n",
+		"cat /proc/net/tcp":    "   0: 00000000:1538 00000000:0000 0A 00000000:00000000 00:00000000 00000000   26        0 20462 1\n",
+		"getenforce":           "Enforcing\n",
+		"systemctl is-active":  "inactive\n",
+	}
+	run := func(cmd string) (string, error) {
+		for prefix, out := range outputs {
+			if strings.HasPrefix(cmd, prefix) {
+				return out, nil
+			}
+		}
+		return "", nil
+	}
+
+	status := make(map[string]string)
+	for _, r := range check(run, &target{engine: "pgsql", ports: []int{5432}, dirs: []string{"/data/pgsql5432"}}, false) {
+		status[r.Check] = r.Status
+	}
+	expected := map[string]string{
+		CheckKernel:     StatusPass,
+		CheckTHP:        StatusPass,
+		CheckSwappiness: StatusPass,
+		CheckUlimit:     StatusWarn,
+		CheckClock:      StatusPass,
+		CheckDisk:       StatusFail,
+		CheckLibs:       StatusPass,
+		CheckPort:       StatusFail,
+		CheckSELinux:    StatusWarn,
+		CheckFirewall:   StatusPass,
+	}
+	for name, s := range expected {
+		if status[name] != s {
+			t.Fatalf("%s 检查结果为 %s, 应为 %s", name, status[name], s)
+		}
+	}
+}
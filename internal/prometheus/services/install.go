@@ -263,7 +263,9 @@ func (i *Install) installConsul() error {
 		return err
 	}
 
-	i.Info(config.Consul, port)
+	if err := i.Info(config.Consul, port); err != nil {
+		return err
+	}
 	return nil
 }
 
@@ -278,7 +280,7 @@ func (i *Install) MakeConfigFile(filename string) error {
 	return i.config.SaveTo(filename)
 }
 
-func (i *Install) Info(app string, port int) {
+func (i *Install) Info(app string, port int) error {
 	filename := filepath.Join(environment.GlobalEnv().DbupInfoPath, fmt.Sprintf("%s%d", app, port))
 
 	if app == config.Grafana {
@@ -289,7 +291,7 @@ func (i *Install) Info(app string, port int) {
 			InstallPath: i.getServerPath(app),
 		}
 		if err := info.SaveTo(filename); err != nil {
-			logger.Errorf("写入配置文件 %s 失败:%s", filename, err.Error())
+			return fmt.Errorf("写入配置文件 %s 失败: %v", filename, err)
 		}
 	} else if app == config.Prometheus {
 		info := config.PrometheusInfo{
@@ -297,7 +299,7 @@ func (i *Install) Info(app string, port int) {
 			InstallPath: i.getServerPath(app),
 		}
 		if err := info.SaveTo(filename); err != nil {
-			logger.Errorf("写入配置文件 %s 失败:%s", filename, err.Error())
+			return fmt.Errorf("写入配置文件 %s 失败: %v", filename, err)
 		}
 	}
 
@@ -311,6 +313,7 @@ func (i *Install) Info(app string, port int) {
 	logger.Successf("启动方式:systemctl start %s\n", i.getServiceFileName(app, port))
 	logger.Successf("关闭方式:systemctl stop %s\n", i.getServiceFileName(app, port))
 	logger.Successf("重启方式:systemctl restart %s\n", i.getServiceFileName(app, port))
+	return nil
 }
 
 func (i *Install) installPrometheus() (err error) {
@@ -352,7 +355,9 @@ func (i *Install) installPrometheus() (err error) {
 		return err
 	}
 
-	i.Info(config.Prometheus, port)
+	if err := i.Info(config.Prometheus, port); err != nil {
+		return err
+	}
 	return nil
 }
 
@@ -422,7 +427,9 @@ func (i *Install) installGrafana() (err error) {
 		return fmt.Errorf("初始化 grafana 密码失败,err:%s", err.Error())
 	}
 
-	i.Info(config.Grafana, port)
+	if err := i.Info(config.Grafana, port); err != nil {
+		return err
+	}
 	return nil
 
 }
@@ -477,7 +484,9 @@ func (i *Install) installNodeExporter() (err error) {
 		return err
 	}
 
-	i.Info(config.NodeExporter, port)
+	if err := i.Info(config.NodeExporter, port); err != nil {
+		return err
+	}
 	return nil
 }
 
@@ -27,6 +27,7 @@ type RedisConfig struct {
 	Modules         []string
 	Cluster         string
 	Save            string
+	Version         string
 }
 
 func NewRedisConfig() *RedisConfig {
@@ -107,6 +108,7 @@ func (c *RedisConfig) FormatBody() {
 		c.RequirePass,
 		c.MasterAuth,
 		c.Cluster)
+	c.formatVersion()
 	for _, module := range c.Modules {
 		c.Body = c.Body + fmt.Sprintf("\nloadmodule %s", module)
 	}
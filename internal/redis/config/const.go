@@ -18,6 +18,8 @@ const (
 	DefaultRedisSystemUser  = "redis"
 	DefaultRedisSystemGroup = "redis"
 
+	DefaultMinReplicasMaxLag = 10 // 同步从节点允许的最大延迟(秒)
+
 	RedisServiceTemplateFile = "redis.service.template"
 )
 
@@ -7,11 +7,12 @@ package config
 
 import (
 	"dbup/internal/environment"
+	"dbup/internal/global"
 	"dbup/internal/utils"
 	"dbup/internal/utils/logger"
+	"dbup/internal/utils/sshutil"
 	"fmt"
 	"path/filepath"
-	"strings"
 
 	"gopkg.in/ini.v1"
 )
@@ -24,6 +25,26 @@ type Server struct {
 	Password string `ini:"ssh-password"`
 	KeyFile  string `ini:"ssh-keyfile"`
 	TmpDir   string `ini:"tmp-dir"`
+	// 主机密钥校验策略: strict(默认), tofu, insecure
+	HostKeyCheck string `ini:"ssh-host-key-check"`
+	KnownHosts   string `ini:"ssh-known-hosts"`
+	// 跳板机, 多个以逗号分隔: [user[:password]@]host[:port][?keyfile=/path/to/key]
+	ProxyJump string `ini:"ssh-proxy-jump"`
+	// 认证方式及尝试顺序, 逗号分隔: agent, publickey, password
+	Auth           string `ini:"ssh-auth"`
+	PassphraseFile string `ini:"ssh-passphrase-file"`
+}
+
+func (s *Server) SSHOptions() sshutil.Options {
+	return sshutil.Options{
+		HostKeyCheck:   s.HostKeyCheck,
+		KnownHosts:     s.KnownHosts,
+		ProxyJump:      s.ProxyJump,
+		Auth:           s.Auth,
+		Password:       s.Password,
+		KeyFile:        s.KeyFile,
+		PassphraseFile: s.PassphraseFile,
+	}
 }
 
 func (s *Server) SetDefault() {
@@ -45,21 +66,6 @@ func (s *Server) Validator() error {
 		}
 	}
 
-	slaves := strings.Split(s.Slaves, ",")
-	if len(slaves) == 0 {
-		return fmt.Errorf("从库不能为空")
-	}
-	if len(slaves) > 2 {
-		return fmt.Errorf("暂时只支持最多两个从节点")
-	}
-	for _, slave := range slaves {
-		if err := utils.IsIPv4(slave); err != nil {
-			if !utils.IsHostName(slave) {
-				return fmt.Errorf("host (%s) 即不是一个 IP 地址(%v), 又解析主机名失败\n", slave, err)
-			}
-		}
-	}
-
 	// 端口
 	if s.SshPort < 1 || s.SshPort > 65535 {
 		return fmt.Errorf("端口号(%d), 不是一个正确的端口号. 端口号必须在 1025 ~ 65535 之间", s.SshPort)
@@ -76,20 +82,23 @@ type Parameter struct {
 	Redis      Parameters `ini:"redis"`
 	Yes        bool       `ini:"yes" comment:"监听IP，如果没有特殊要求请勿修改"`
 	NoRollback bool       `ini:"no-rollback" comment:"监听IP，如果没有特殊要求请勿修改"`
+	// 从节点, 来自 [server] 的 slaves 和每个 [slave.<名称>] section, 按复制顺序排列
+	Replicas []*global.Replica `ini:"-"`
 }
 
-// Load 从配置文件加载配置到Prepare实例
+// Load 从配置文件加载配置到Prepare实例, 支持 ini 和 YAML 格式
 func (p *Parameter) Load(filename string) error {
-	cfg, err := ini.LoadSources(ini.LoadOptions{
-		SpaceBeforeInlineComment: true,
-	}, filename)
+	cfg, err := global.LoadDeployFile(filename)
 	if err != nil {
-		return fmt.Errorf("加载配置文件失败: %v", err)
+		return err
 	}
 
 	if err = cfg.MapTo(p); err != nil {
 		return fmt.Errorf("配置文件映射到结构体失败: %v", err)
 	}
+	if p.Replicas, err = global.ParseReplicas(cfg, p.Server.Slaves); err != nil {
+		return fmt.Errorf("读取从节点配置失败: %v", err)
+	}
 	return nil
 }
 
@@ -99,6 +108,9 @@ func (p *Parameter) SlaveTo(filename string) error {
 	if err := ini.ReflectFrom(cfg, p); err != nil {
 		return fmt.Errorf("部署配置映射到(%s)文件错误: %v", filename, err)
 	}
+	if err := global.SaveReplicas(cfg, p.Server.Slaves, p.Replicas); err != nil {
+		return fmt.Errorf("从节点写入(%s)文件错误: %v", filename, err)
+	}
 	if err := cfg.SaveTo(filename); err != nil {
 		return fmt.Errorf("部署配置保存到(%s)文件错误: %v", filename, err)
 	}
@@ -115,3 +127,40 @@ func (p *Parameter) Validator() error {
 	// }
 	return nil
 }
+
+// ResolveReplicas 补全并验证从节点, 需要在 [redis] 的端口和目录确定之后执行.
+// 没有指定目录的从节点使用 [redis] 中的目录; [redis] 使用默认目录时, 端口不同的从节点使用自己端口的默认目录
+func (p *Parameter) ResolveReplicas() error {
+	if p.Redis.Dir == fmt.Sprintf("%s%d", DefaultRedisDir, p.Redis.Port) {
+		for _, r := range p.Replicas {
+			if r.Dir == "" && r.Port != 0 {
+				r.Dir = fmt.Sprintf("%s%d", DefaultRedisDir, r.Port)
+			}
+		}
+	}
+	replicas, err := global.ResolveReplicas(p.Server.Master, p.Replicas, p.Redis.Port, p.Redis.Dir)
+	if err != nil {
+		return err
+	}
+	p.Replicas = replicas
+	return nil
+}
+
+// ReplicaParameters 从节点的安装参数: 在 [redis] 的基础上使用从节点的端口和目录
+func (p *Parameter) ReplicaParameters(r *global.Replica) Parameters {
+	pre := p.Redis
+	pre.Port = r.Port
+	pre.Dir = r.Dir
+	return pre
+}
+
+// SyncReplicas 同步从节点的数量, 用于主库的 min-replicas-to-write
+func (p *Parameter) SyncReplicas() int {
+	n := 0
+	for _, r := range p.Replicas {
+		if r.Sync {
+			n++
+		}
+	}
+	return n
+}
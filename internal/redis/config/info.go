@@ -6,7 +6,11 @@
 package config
 
 import (
+	"dbup/internal/credential"
+	"dbup/internal/output"
 	"fmt"
+	"path/filepath"
+
 	"gopkg.in/ini.v1"
 )
 
@@ -15,19 +19,26 @@ type PgsqlInfo struct {
 	Port      int    `ini:"port"`
 	Host      string `ini:"host"`
 	Socket    string `ini:"socket"`
-	Password  string `ini:"password"`
+	Password  string `ini:"password,omitempty"`
 	DeployDir string `ini:"deploydir"`
 	DataDir   string `ini:"datadir"`
 }
 
-// SaveTo 将info信息保存到磁盘
+// SaveTo 将info信息保存到磁盘, 密码加密保存到凭据库中, 不写入info文件
 func (p *PgsqlInfo) SlaveTo(filename string) error {
+	if err := credential.Put(filepath.Base(filename), map[string]string{credential.FieldPassword: p.Password}); err != nil {
+		return fmt.Errorf("密码保存到凭据库失败: %v", err)
+	}
+	info := *p
+	info.Password = ""
+
 	cfg := ini.Empty(ini.LoadOptions{IgnoreInlineComment: true})
-	if err := ini.ReflectFrom(cfg, p); err != nil {
+	if err := ini.ReflectFrom(cfg, &info); err != nil {
 		return fmt.Errorf("部署配置映射到(%s)文件错误: %v", filename, err)
 	}
 	if err := cfg.SaveTo(filename); err != nil {
 		return fmt.Errorf("部署配置保存到(%s)文件错误: %v", filename, err)
 	}
+	output.Created(output.Resource{Kind: "file", Name: filepath.Base(filename), Path: filename})
 	return nil
 }
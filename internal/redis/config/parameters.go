@@ -34,6 +34,8 @@ type Parameters struct {
 	Master          string `ini:"master" comment:"单机安装同步主库的IP:PORT"`
 	Cluster         bool   `ini:"cluster" comment:"是否为集群模式"`
 	ResourceLimit   string `ini:"resource-limit"`
+	Tune            bool   `ini:"tune" comment:"是否按 redis 的内置配置调优操作系统(sysctl, 透明大页, limits.d, LimitNOFILE), 可以用 dbup tune revert 恢复"`
+	Version         string `ini:"version" comment:"Redis 大版本, 默认为 6"`
 	Yes             bool   `ini:"yes" comment:"监听IP，如果没有特殊要求请勿修改"`
 	NoRollback      bool   `ini:"no-rollback" comment:"监听IP，如果没有特殊要求请勿修改"`
 }
@@ -160,6 +162,12 @@ func (p *Parameters) Validator() error {
 		return fmt.Errorf("%s, 不支持的key淘汰策略", p.MaxmemoryPolicy)
 	}
 
+	version, err := CheckVersion(p.Version)
+	if err != nil {
+		return err
+	}
+	p.Version = version
+
 	return nil
 }
 
@@ -8,6 +8,7 @@ package config
 import (
 	"dbup/internal/environment"
 	"dbup/internal/utils"
+	"dbup/internal/utils/sshutil"
 	"fmt"
 	"path"
 	"path/filepath"
@@ -22,6 +23,26 @@ type RedisClusterSSHConfig struct {
 	Password string `yaml:"password"`
 	KeyFile  string `yaml:"keyfile"`
 	TmpDir   string `yaml:"tmp-dir"`
+	// 主机密钥校验策略: strict(默认), tofu, insecure
+	HostKeyCheck string `yaml:"host-key-check"`
+	KnownHosts   string `yaml:"known-hosts"`
+	// 跳板机列表, 按连接顺序排列
+	ProxyJump sshutil.JumpHosts `yaml:"proxy-jump"`
+	// 认证方式及尝试顺序, 逗号分隔: agent, publickey, password
+	Auth           string `yaml:"auth"`
+	PassphraseFile string `yaml:"passphrase-file"`
+}
+
+func (s *RedisClusterSSHConfig) SSHOptions() sshutil.Options {
+	return sshutil.Options{
+		HostKeyCheck:   s.HostKeyCheck,
+		KnownHosts:     s.KnownHosts,
+		JumpHosts:      s.ProxyJump,
+		Auth:           s.Auth,
+		Password:       s.Password,
+		KeyFile:        s.KeyFile,
+		PassphraseFile: s.PassphraseFile,
+	}
 }
 
 func (s *RedisClusterSSHConfig) SetDefault() {
@@ -42,8 +63,12 @@ type RedisClusterConfig struct {
 	Memory        string `yaml:"memory"`
 	Module        string `yaml:"module"`
 	ResourceLimit string `yaml:"resource-limit"`
+	// 是否按 redis 的内置配置调优操作系统
+	Tune bool `yaml:"tune"`
 	// Appendonly      string `yaml:"appendonly"`
 	MaxmemoryPolicy string `yaml:"maxmemory-policy"`
+	// Redis 大版本, 为空时使用默认版本
+	Version string `yaml:"version"`
 }
 
 func (c *RedisClusterConfig) Validator() error {
@@ -62,6 +87,12 @@ func (c *RedisClusterConfig) Validator() error {
 			}
 		}
 	}
+
+	version, err := CheckVersion(c.Version)
+	if err != nil {
+		return err
+	}
+	c.Version = version
 	return nil
 }
 
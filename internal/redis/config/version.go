@@ -0,0 +1,51 @@
+package config
+
+import (
+	"dbup/internal/global"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// SupportedVersions 支持安装的 Redis 大版本, 安装包按大版本命名
+var SupportedVersions = []string{"6", "7"}
+
+// CheckVersion 检查要安装的 Redis 版本, 为空时返回默认版本. 安装包按大版本命名, 只能指定大版本
+func CheckVersion(version string) (string, error) {
+	v, major, err := global.CheckVersion("Redis", version, DefaultRedisVersion, SupportedVersions)
+	if err != nil {
+		return "", err
+	}
+	if v != major {
+		return "", fmt.Errorf("Redis 只需要指定大版本, 如: %s", major)
+	}
+	return v, nil
+}
+
+// PackageName 版本对应的安装包文件名
+func PackageName(version string) string {
+	return PlatformPackageName(version, global.LocalPlatform())
+}
+
+// PlatformPackageName 目标机器平台对应的安装包文件名
+func PlatformPackageName(version string, p *global.Platform) string {
+	return p.PackageName(PackageFile, version)
+}
+
+// 7.0 开始 ziplist 相关参数改名为 listpack
+var listpackReplacer = strings.NewReplacer(
+	"hash-max-ziplist-", "hash-max-listpack-",
+	"zset-max-ziplist-", "zset-max-listpack-",
+	"list-max-ziplist-entries 512\nlist-max-ziplist-value 64", "list-max-listpack-size -2",
+)
+
+// formatVersion 按大版本调整配置文件内容
+func (c *RedisConfig) formatVersion() {
+	if c.Version == "" {
+		return
+	}
+	c.Body = strings.Replace(c.Body, "#Verredis60", fmt.Sprintf("#Verredis%s0", c.Version), 1)
+	if major, _ := strconv.Atoi(c.Version); major >= 7 {
+		c.Body = listpackReplacer.Replace(c.Body)
+	}
+}
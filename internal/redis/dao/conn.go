@@ -47,6 +47,17 @@ func (c *RedisClient) SlaveOf(master string, port int) error {
 	return nil
 }
 
+// ConfigSet 修改参数并写入配置文件
+func (c *RedisClient) ConfigSet(key string, value interface{}) error {
+	if _, err := c.Conn.Do("CONFIG", "SET", key, value); err != nil {
+		return fmt.Errorf("设置 %s 失败: %v", key, err)
+	}
+	if _, err := c.Conn.Do("CONFIG", "REWRITE"); err != nil {
+		return err
+	}
+	return nil
+}
+
 func (c *RedisClient) ClusterID() (string, error) {
 	return redis.String(c.Conn.Do("cluster", "myid"))
 }
@@ -21,34 +21,6 @@ func NewRedis() *Redis {
 	return &Redis{}
 }
 
-func (r *Redis) Install(param config.Parameters, cfgFile string, onlyCheck bool) error {
-	inst := services.NewInstall()
-	return inst.Run(param, cfgFile, onlyCheck)
-}
-
-func (r *Redis) RedisAddSlave(o config.RedisClusterAddNodeOption) error {
-	d := services.NewRedisManager()
-	return d.AddSlaveNode(o)
-}
-
-func (r *Redis) UNInstall(uninst *services.UNInstall) error {
-	return uninst.Uninstall()
-}
-
-func (r *Redis) Deploy(c string) error {
-	d := services.NewDeploy()
-	return d.Run(c)
-}
-
-func (r *Redis) RemoveDeploy(c string, yes bool) error {
-	d := services.NewDeploy()
-	return d.RemoveDeploy(c, yes)
-}
-
-func (r *Redis) Backup(backup *services.Backup) error {
-	return backup.Run()
-}
-
 func (r *Redis) BackupTask(action string, task *services.BackupTask) error {
 	if action == "run" {
 		return task.Run()
@@ -67,16 +39,6 @@ func (r *Redis) BackupTask(action string, task *services.BackupTask) error {
 	}
 }
 
-func (r *Redis) RedisClusterDeploy(c string) error {
-	d := services.NewRedisClusterDeploy()
-	return d.Run(c)
-}
-
-func (r *Redis) RedisClusterRemoveDeploy(c string, yes bool) error {
-	d := services.NewRedisClusterDeploy()
-	return d.RemoveCluster(c, yes)
-}
-
 func (r *Redis) RedisClusterAddMaster(o config.RedisClusterAddNodeOption) error {
 	d := services.NewRedisClusterManager()
 	return d.AddNode(o, "master")
@@ -6,6 +6,8 @@
 package services
 
 import (
+	"dbup/internal/output"
+	"dbup/internal/redis/config"
 	"dbup/internal/utils/command"
 	"dbup/internal/utils/logger"
 	"fmt"
@@ -39,12 +41,14 @@ func (b *Backup) Run() error {
 
 	logger.Infof("备份开始\n")
 
-	cmd := fmt.Sprintf("%s -h %s -p %d -a %s --rdb %s", b.BackupCmd, b.Host, b.Port, b.Password, b.BackupFile)
-	l := command.Local{Timeout: 259200}
+	cmd := fmt.Sprintf("%s -h %s -p %d --rdb %s", b.BackupCmd, b.Host, b.Port, b.BackupFile)
+	// 通过环境变量传递密码, 不出现在进程列表中
+	l := command.Local{Timeout: 259200, Env: []string{"REDISCLI_AUTH=" + b.Password}}
 	if _, stderr, err := l.Run(cmd); err != nil {
 		return fmt.Errorf("执行redis备份失败: %v, 标准错误输出: %s", err, stderr)
 	}
 
 	logger.Infof("备份完成\n")
+	output.Created(output.Resource{Kind: "backup", Engine: config.Kinds, Port: b.Port, Path: b.BackupFile})
 	return nil
 }
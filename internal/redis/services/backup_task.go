@@ -8,10 +8,12 @@ package services
 import (
 	"bufio"
 	"dbup/internal/environment"
+	"dbup/internal/output"
 	"dbup/internal/redis/config"
 	"dbup/internal/utils"
 	"dbup/internal/utils/command"
 	"dbup/internal/utils/logger"
+	"dbup/internal/utils/secretfile"
 	"fmt"
 	"os"
 	"path/filepath"
@@ -64,7 +66,7 @@ func (t *BackupTask) DropExpire() error {
 
 	backupFiles, err := f.Readdir(-1)
 	if err != nil {
-		fmt.Println(err)
+		return fmt.Errorf("读取备份目录 %s 失败: %v", t.BackupDir, err)
 	}
 
 	for _, file := range backupFiles {
@@ -87,6 +89,13 @@ func (t *BackupTask) AddValidator() error {
 	return nil
 }
 
+// taskItem 备份任务列表中的一项, 用于 json 格式输出
+type taskItem struct {
+	Name string `json:"name"`
+	Time string `json:"time"`
+	Port string `json:"port"`
+}
+
 func (t *BackupTask) LinuxList() error {
 	logger.Infof("列出定时任务列表\n")
 
@@ -114,12 +123,17 @@ func (t *BackupTask) LinuxList() error {
 			if len(tName) < 3 {
 				return fmt.Errorf("获取备份任务名称异常\n")
 			}
-			fmt.Printf("备份任务名: %s, 每天备份时间: %s:%s, 备份端口号: %s\n", tName[2], time[1], time[0], tName[1])
+			output.Item("tasks", taskItem{Name: tName[2], Time: time[1] + ":" + time[0], Port: tName[1]}, "备份任务名: %s, 每天备份时间: %s:%s, 备份端口号: %s\n", tName[2], time[1], time[0], tName[1])
 		}
 	}
 	return nil
 }
 
+// secretFile 定时任务使用的密码文件
+func (t *BackupTask) secretFile() string {
+	return filepath.Join(environment.GlobalEnv().DbupInfoPath, secretfile.Dir, t.TaskNameFormat+".json")
+}
+
 func (t *BackupTask) LinuxAdd() error {
 	if err := t.AddValidator(); err != nil {
 		return err
@@ -143,6 +157,11 @@ func (t *BackupTask) LinuxAdd() error {
 		}
 	}
 
+	// 密码写入只有 root 可读的文件, 不直接写在定时任务中
+	if err := secretfile.Write(t.secretFile(), map[string]string{"password": t.Backup.Password}); err != nil {
+		return err
+	}
+
 	// 将任务写入定时文件
 	if err := command.CopyFile(config.BackupTaskLinuxCronFile); err != nil {
 		return err
@@ -153,7 +172,7 @@ func (t *BackupTask) LinuxAdd() error {
 	}
 	defer file.Close()
 
-	line := fmt.Sprintf("%s %s * * * %s redis backup-task run --command='%s' --host=%s --port=%d --password='%s' --backupdir='%s' --expire=%d #%s\n", HM[1], HM[0], environment.GlobalEnv().Program, t.Backup.BackupCmd, t.Backup.Host, t.Backup.Port, t.Backup.Password, t.BackupDir, t.Expire, t.TaskNameFormat)
+	line := fmt.Sprintf("%s %s * * * %s redis backup-task run --command='%s' --host=%s --port=%d --password-file='%s' --backupdir='%s' --expire=%d #%s\n", HM[1], HM[0], environment.GlobalEnv().Program, t.Backup.BackupCmd, t.Backup.Host, t.Backup.Port, t.secretFile(), t.BackupDir, t.Expire, t.TaskNameFormat)
 	write := bufio.NewWriter(file)
 	if _, err := write.WriteString(line); err != nil {
 		return err
@@ -168,6 +187,7 @@ func (t *BackupTask) LinuxAdd() error {
 	//	return fmt.Errorf("添加备份任务失败: %v, 标准错误输出: %s", err, stderr)
 	//}
 	logger.Infof("设置备份任务成功\n")
+	output.Created(output.Resource{Kind: "backup-task", Name: t.TaskNameFormat})
 	return nil
 }
 
@@ -214,6 +234,10 @@ func (t *BackupTask) LinuxDel() error {
 	if err := w.Flush(); err != nil {
 		return err
 	}
+	if err := os.Remove(t.secretFile()); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("删除密码文件失败: %v", err)
+	}
 	logger.Successf("删除成功\n")
+	output.Removed(output.Resource{Kind: "backup-task", Name: t.TaskNameFormat})
 	return nil
 }
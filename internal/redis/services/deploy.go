@@ -7,12 +7,16 @@ package services
 
 import (
 	"dbup/internal/environment"
+	"dbup/internal/global"
+	"dbup/internal/inventory"
+	"dbup/internal/plan"
+	"dbup/internal/preflight"
 	"dbup/internal/redis/config"
 	"dbup/internal/utils/logger"
+	"dbup/internal/utils/prompt"
 	"fmt"
-	"os"
 	"path"
-	"strings"
+	"strconv"
 	"time"
 )
 
@@ -28,17 +32,10 @@ func NewDeploy() *Deploy {
 
 func (d *Deploy) Run(c string) error {
 	// 初始化参数和配置环节
-	if err := d.param.Load(c); err != nil {
-		return err
-	}
-
-	d.param.Server.SetDefault()
-
-	if err := d.param.Validator(); err != nil {
+	if err := d.load(c); err != nil {
 		return err
 	}
 
-	d.param.Redis.InitArgs()
 	logger.Infof("初始化部署对象\n")
 	if d.param.Server.Password != "" {
 		if err := d.Init(); err != nil {
@@ -68,9 +65,44 @@ func (d *Deploy) Run(c string) error {
 		return err
 	}
 
+	if err := inventory.RecordCluster(d.cluster()); err != nil {
+		logger.Warningf("更新实例清单失败: %v\n", err)
+	}
 	return nil
 }
 
+// load 读取并验证部署配置
+func (d *Deploy) load(c string) error {
+	if err := d.param.Load(c); err != nil {
+		return err
+	}
+
+	d.param.Server.SetDefault()
+
+	if err := d.param.Validator(); err != nil {
+		return err
+	}
+
+	d.param.Redis.InitArgs()
+	return d.param.ResolveReplicas()
+}
+
+// Check 连接各节点执行部署前检查, 不做任何修改
+func (d *Deploy) Check(c string) error {
+	if err := d.load(c); err != nil {
+		return err
+	}
+	s := d.param.Server
+
+	logger.Infof("检查部署节点环境\n")
+	p := preflight.New(plan.SSHDialer(s.User, s.Password, s.KeyFile, s.SshPort, s.SSHOptions()))
+	p.Add(s.Master, config.Kinds, []int{d.param.Redis.Port}, d.param.Redis.Dir)
+	for _, r := range d.param.Replicas {
+		p.Add(r.Host, config.Kinds, []int{r.Port}, r.Dir)
+	}
+	return p.Finish()
+}
+
 func (d *Deploy) RemoveDeploy(c string, yes bool) error {
 	// 初始化参数和配置环节
 	if err := d.param.Load(c); err != nil {
@@ -90,23 +122,20 @@ func (d *Deploy) RemoveDeploy(c string, yes bool) error {
 	if d.param.Redis.Dir == "" {
 		return fmt.Errorf("请指定要删除集群的数据目录\n")
 	}
+	if err := d.param.ResolveReplicas(); err != nil {
+		return err
+	}
 
 	logger.Warningf("要删除的集群节点以及数据目录: %s:%d %s\n", d.param.Server.Master, d.param.Redis.Port, d.param.Redis.Dir)
-	for _, ip := range strings.Split(d.param.Server.Slaves, ",") {
-		logger.Warningf("要删除的集群节点以及数据目录: %s:%d %s\n", ip, d.param.Redis.Port, d.param.Redis.Dir)
+	for _, r := range d.param.Replicas {
+		logger.Warningf("要删除的集群节点以及数据目录: %s %s\n", r.Addr(), r.Dir)
 	}
 
 	if !yes {
 		logger.Warningf("删除集群是危险操作,会将整个集群中的数据完全删除, 不可恢复\n")
-		logger.Warningf("是否确认删除[y|n]:")
-
-		var yes string
-		if _, err := fmt.Scanln(&yes); err != nil {
+		if err := prompt.Confirm("是否确认删除"); err != nil {
 			return err
 		}
-		if strings.ToUpper(yes) != "Y" && strings.ToUpper(yes) != "YES" {
-			os.Exit(0)
-		}
 	}
 
 	logger.Infof("初始化删除对象\n")
@@ -132,9 +161,53 @@ func (d *Deploy) RemoveDeploy(c string, yes bool) error {
 
 	d.UNInstall()
 
+	if err := inventory.ForgetCluster(d.cluster().Name); err != nil {
+		logger.Warningf("更新实例清单失败: %v\n", err)
+	}
 	return nil
 }
 
+// cluster 集群成员, 用于记录到实例清单
+func (d *Deploy) cluster() inventory.Cluster {
+	members := inventory.Members(d.param.Server.Master, d.param.Redis.Port, "master", d.param.Redis.Dir)
+	for _, r := range d.param.Replicas {
+		members = append(members, inventory.Member{Host: r.Host, Port: r.Port, Role: "slave", Dir: r.Dir})
+	}
+	return inventory.Cluster{
+		Name:    inventory.ClusterName(config.Kinds, d.param.Server.Master, d.param.Redis.Port),
+		Engine:  config.Kinds,
+		Mode:    "master-slave",
+		Members: members,
+	}
+}
+
+// upstream 从节点复制数据的来源: 主库, 或级联复制的上游从节点
+func (d *Deploy) upstream(r *global.Replica) (string, int) {
+	for _, u := range d.param.Replicas {
+		if u.Name == r.Upstream {
+			return u.Host, u.Port
+		}
+	}
+	return d.param.Server.Master, d.param.Redis.Port
+}
+
+// tmpDir 节点上的临时目录, 同一台机器上部署多个实例时每个实例使用以端口命名的子目录
+func (d *Deploy) tmpDir(host string, port int) string {
+	n := 0
+	if host == d.param.Server.Master {
+		n++
+	}
+	for _, r := range d.param.Replicas {
+		if r.Host == host {
+			n++
+		}
+	}
+	if n > 1 {
+		return path.Join(d.param.Server.TmpDir, strconv.Itoa(port))
+	}
+	return d.param.Server.TmpDir
+}
+
 func (d *Deploy) InstallAndInitSlave() error {
 	if err := d.Install(); err != nil {
 		return err
@@ -144,6 +217,17 @@ func (d *Deploy) InstallAndInitSlave() error {
 		return err
 	}
 
+	// 同步从节点: 主库至少有这么多个从库的延迟不超过 min-replicas-max-lag 秒时才允许写入
+	if n := d.param.SyncReplicas(); n > 0 {
+		logger.Infof("设置主库 min-replicas-to-write: %d\n", n)
+		if err := d.master.ConfigSet("min-replicas-max-lag", config.DefaultMinReplicasMaxLag); err != nil {
+			return err
+		}
+		if err := d.master.ConfigSet("min-replicas-to-write", n); err != nil {
+			return err
+		}
+	}
+
 	logger.Infof("5秒后检查集群状态\n")
 	time.Sleep(5 * time.Second)
 	for _, slave := range d.slaves {
@@ -159,21 +243,23 @@ func (d *Deploy) InstallAndInitSlave() error {
 
 func (d *Deploy) Init() error {
 	var err error
-	if d.master, err = NewInstance(d.param.Server.TmpDir,
+	if d.master, err = NewInstance(d.tmpDir(d.param.Server.Master, d.param.Redis.Port),
 		d.param.Server.Master,
 		d.param.Server.User,
 		d.param.Server.Password,
 		d.param.Server.SshPort,
-		d.param.Redis); err != nil {
+		d.param.Redis,
+		d.param.Server.SSHOptions()); err != nil {
 		return err
 	}
-	for _, slave := range strings.Split(d.param.Server.Slaves, ",") {
-		s, err := NewInstance(d.param.Server.TmpDir,
-			slave,
+	for _, r := range d.param.Replicas {
+		s, err := NewInstance(d.tmpDir(r.Host, r.Port),
+			r.Host,
 			d.param.Server.User,
 			d.param.Server.Password,
 			d.param.Server.SshPort,
-			d.param.Redis)
+			d.param.ReplicaParameters(r),
+			d.param.Server.SSHOptions())
 		if err != nil {
 			return err
 		}
@@ -184,21 +270,23 @@ func (d *Deploy) Init() error {
 
 func (d *Deploy) InitUseKeyFile() error {
 	var err error
-	if d.master, err = NewInstanceUseKeyFile(d.param.Server.TmpDir,
+	if d.master, err = NewInstanceUseKeyFile(d.tmpDir(d.param.Server.Master, d.param.Redis.Port),
 		d.param.Server.Master,
 		d.param.Server.User,
 		d.param.Server.KeyFile,
 		d.param.Server.SshPort,
-		d.param.Redis); err != nil {
+		d.param.Redis,
+		d.param.Server.SSHOptions()); err != nil {
 		return err
 	}
-	for _, slave := range strings.Split(d.param.Server.Slaves, ",") {
-		s, err := NewInstanceUseKeyFile(d.param.Server.TmpDir,
-			slave,
+	for _, r := range d.param.Replicas {
+		s, err := NewInstanceUseKeyFile(d.tmpDir(r.Host, r.Port),
+			r.Host,
 			d.param.Server.User,
 			d.param.Server.KeyFile,
 			d.param.Server.SshPort,
-			d.param.Redis)
+			d.param.ReplicaParameters(r),
+			d.param.Server.SSHOptions())
 		if err != nil {
 			return err
 		}
@@ -284,11 +372,19 @@ func (d *Deploy) UNInstall() {
 
 func (d *Deploy) ReplicaSlave() error {
 	logger.Infof("初始化从库\n")
-	for _, slave := range d.slaves {
-		if err := slave.Replication(d.param.Server.Master, d.master.Inst.port); err != nil {
+	for i, slave := range d.slaves {
+		r := d.param.Replicas[i]
+		host, port := d.upstream(r)
+		logger.Infof("从库 %s 从 %s:%d 复制数据\n", r.Name, host, port)
+		if err := slave.Replication(host, port); err != nil {
 			return err
 		}
-
+		// 哨兵切换时优先提升 replica-priority 小的从库, 0 表示不参与切换, 所以只设置大于 0 的优先级
+		if r.Priority > 0 {
+			if err := slave.ConfigSet("replica-priority", r.Priority); err != nil {
+				return err
+			}
+		}
 	}
 	return nil
 }
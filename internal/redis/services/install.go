@@ -8,12 +8,15 @@ package services
 import (
 	"dbup/internal/environment"
 	"dbup/internal/global"
+	"dbup/internal/inventory"
 	"dbup/internal/redis/config"
 	"dbup/internal/redis/dao"
+	"dbup/internal/tune"
 	"dbup/internal/utils"
 	"dbup/internal/utils/arrlib"
 	"dbup/internal/utils/command"
 	"dbup/internal/utils/logger"
+	"dbup/internal/utils/prompt"
 	"errors"
 	"fmt"
 	"os"
@@ -72,7 +75,6 @@ func (i *Install) Run(param config.Parameters, cfgFile string, onlyCheck bool) e
 	}
 
 	if !i.parameters.Yes {
-		var yes string
 		if !i.parameters.Cluster && i.parameters.Master != "" {
 			logger.Successf("\n")
 			logger.Successf("本次安装实例为从节点\n")
@@ -82,13 +84,9 @@ func (i *Install) Run(param config.Parameters, cfgFile string, onlyCheck bool) e
 
 		logger.Successf("端口: %d\n", i.port)
 		logger.Successf("安装路径: %s\n", i.basePath)
-		logger.Successf("是否确认安装[y|n]:")
-		if _, err := fmt.Scanln(&yes); err != nil {
+		if err := prompt.Confirm("是否确认安装"); err != nil {
 			return err
 		}
-		if strings.ToUpper(yes) != "Y" && strings.ToUpper(yes) != "YES" {
-			os.Exit(0)
-		}
 	}
 
 	if err := i.Install(); err != nil {
@@ -176,6 +174,9 @@ func (i *Install) MergePrepareArgs(param config.Parameters) {
 
 	i.parameters.Master = param.Master
 	i.parameters.ResourceLimit = param.ResourceLimit
+	if param.Tune {
+		i.parameters.Tune = true
+	}
 	i.parameters.Appendonly = param.Appendonly
 	i.parameters.MaxmemoryPolicy = param.MaxmemoryPolicy
 
@@ -209,6 +210,10 @@ func (i *Install) MergePrepareArgs(param config.Parameters) {
 		i.parameters.Cluster = true
 	}
 
+	if param.Version != "" {
+		i.parameters.Version = param.Version
+	}
+
 	if param.Yes {
 		i.parameters.Yes = true
 	}
@@ -220,7 +225,8 @@ func (i *Install) MergePrepareArgs(param config.Parameters) {
 func (i *Install) Init() {
 	i.SysUser = i.parameters.SystemUser
 	i.SysGroup = i.parameters.SystemGroup
-	i.packageFullName = filepath.Join(environment.GlobalEnv().ProgramPath, global.PackagePath, config.Kinds, fmt.Sprintf(config.PackageFile, config.DefaultRedisVersion, environment.GlobalEnv().GOOS, environment.GlobalEnv().GOARCH))
+	i.version = i.parameters.Version
+	i.packageFullName = filepath.Join(environment.GlobalEnv().ProgramPath, global.PackagePath, config.Kinds, config.PackageName(i.version))
 	i.port = i.parameters.Port
 	i.basePath = i.parameters.Dir
 	i.serverPath = filepath.Join(i.basePath, config.ServerDir)
@@ -245,6 +251,7 @@ func (i *Install) HandleConfig() error {
 	i.config.Dir = i.dataPath
 	i.config.Appendonly = i.parameters.Appendonly
 	i.config.MaxmemoryPolicy = i.parameters.MaxmemoryPolicy
+	i.config.Version = i.version
 
 	if i.parameters.Cluster {
 		i.config.Cluster = "yes"
@@ -288,7 +295,7 @@ func (i *Install) HandleConfig() error {
 
 func (i *Install) HandleSystemd() error {
 	var err error
-	if i.service, err = config.NewRedisService(filepath.Join(environment.GlobalEnv().ProgramPath, global.ServiceTemplatePath, config.RedisServiceTemplateFile)); err != nil {
+	if i.service, err = config.NewRedisService(filepath.Join(environment.GlobalEnv().ProgramPath, global.ServiceTemplatePath, global.ServiceTemplateFile(config.RedisServiceTemplateFile, i.version))); err != nil {
 		return err
 	}
 	i.service.User = i.SysUser
@@ -365,6 +372,12 @@ func (i *Install) Install() error {
 		}
 	}
 
+	if i.parameters.Tune {
+		if err := tune.ApplyService("redis", i.serviceFileName, ""); err != nil {
+			return err
+		}
+	}
+
 	if err := command.SystemCtl(i.serviceFileName, "start"); err != nil {
 		return err
 	}
@@ -480,6 +493,23 @@ func (i *Install) Info() error {
 	if err := info.SlaveTo(filename); err != nil {
 		return err
 	}
+	role := "master"
+	if i.parameters.Cluster {
+		role = "cluster"
+	} else if i.parameters.Master != "" {
+		role = "slave"
+	}
+	if err := inventory.Record(inventory.Instance{
+		Engine:  config.Kinds,
+		Version: i.version,
+		Port:    i.port,
+		Dir:     i.basePath,
+		DataDir: i.dataPath,
+		Role:    role,
+		Service: i.serviceFileName,
+	}); err != nil {
+		logger.Warningf("更新实例清单失败: %v\n", err)
+	}
 
 	//logger.Successf("\n")
 	logger.Successf("Redis 初始化[完成]\n")
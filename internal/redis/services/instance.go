@@ -7,25 +7,28 @@ package services
 
 import (
 	"dbup/internal/environment"
+	"dbup/internal/global"
 	"dbup/internal/redis/config"
 	"dbup/internal/redis/dao"
 	"dbup/internal/utils/newssh"
+	"dbup/internal/utils/sshutil"
 	"fmt"
 	"path"
 	"path/filepath"
 )
 
 type Instance struct {
-	DbupCmd string
-	Host    string
-	TmpDir  string
-	Inst    *Install
-	Conn    *newssh.Connection
+	DbupCmd  string
+	Host     string
+	TmpDir   string
+	Inst     *Install
+	Conn     *newssh.Connection
+	Platform *global.Platform // 目标机器的平台, 检查临时目录时探测
 	//spool
 }
 
-func NewInstance(tmp, host, user, password string, port int, pre config.Parameters) (*Instance, error) {
-	conn, err := newssh.NewConnection(host, user, password, port, 600)
+func NewInstance(tmp, host, user, password string, port int, pre config.Parameters, opts ...sshutil.Options) (*Instance, error) {
+	conn, err := newssh.NewConnection(host, user, password, port, 600, opts...)
 	if err != nil {
 		return nil, fmt.Errorf("在机器: %s 上, 建立ssh连接失败: %v", host, err)
 	}
@@ -40,8 +43,8 @@ func NewInstance(tmp, host, user, password string, port int, pre config.Paramete
 	return &Instance{DbupCmd: cmd, Host: host, TmpDir: tmp, Inst: inst, Conn: conn}, nil
 }
 
-func NewInstanceUseKeyFile(tmp, host, user, keyfile string, port int, pre config.Parameters) (*Instance, error) {
-	conn, err := newssh.NewConnectionUseKeyFile(host, user, keyfile, port, 600)
+func NewInstanceUseKeyFile(tmp, host, user, keyfile string, port int, pre config.Parameters, opts ...sshutil.Options) (*Instance, error) {
+	conn, err := newssh.NewConnectionUseKeyFile(host, user, keyfile, port, 600, opts...)
 	if err != nil {
 		return nil, fmt.Errorf("在机器: %s 上, 建立ssh连接失败: %v", host, err)
 	}
@@ -57,6 +60,11 @@ func NewInstanceUseKeyFile(tmp, host, user, keyfile string, port int, pre config
 }
 
 func (i *Instance) CheckTmpDir() error {
+	// 先确认所有目标机器都有对应平台的安装包, 再开始复制
+	if _, _, err := i.CheckPlatform(path.Join(environment.GlobalEnv().ProgramPath, "..")); err != nil {
+		return err
+	}
+
 	if i.Conn.IsExists(filepath.ToSlash(i.TmpDir)) {
 		if !i.Conn.IsDir(filepath.ToSlash(i.TmpDir)) {
 			return fmt.Errorf("在机器: %s 上, 目标文件(%s)已经存在", i.Host, i.TmpDir)
@@ -73,6 +81,11 @@ func (i *Instance) CheckTmpDir() error {
 }
 
 func (i *Instance) Scp(source string) error {
+	source, redisPackage, err := i.CheckPlatform(source)
+	if err != nil {
+		return err
+	}
+
 	if err := i.Conn.MkdirAll(filepath.ToSlash(path.Join(i.TmpDir, "bin"))); err != nil {
 		return fmt.Errorf("在机器: %s 上, 创建目录(%s)失败: %v", i.Host, filepath.ToSlash(path.Join(i.TmpDir, "bin")), err)
 	}
@@ -91,16 +104,31 @@ func (i *Instance) Scp(source string) error {
 	if err := i.Conn.Scp(path.Join(source, "package", "md5"), filepath.ToSlash(path.Join(i.TmpDir, "package", "md5"))); err != nil {
 		return fmt.Errorf("在机器: %s 上, scp文件(%s)失败: %v", i.Host, filepath.ToSlash(path.Join(i.TmpDir, "package", "md5")), err)
 	}
+	for _, f := range global.ManifestFiles(path.Join(source, "package")) {
+		if err := i.Conn.Scp(path.Join(source, "package", f), filepath.ToSlash(path.Join(i.TmpDir, "package", f))); err != nil {
+			return fmt.Errorf("在机器: %s 上, scp文件(%s)失败: %v", i.Host, filepath.ToSlash(path.Join(i.TmpDir, "package", f)), err)
+		}
+	}
 
-	if err := i.Conn.Scp(path.Join(source, "systemd", config.RedisServiceTemplateFile), filepath.ToSlash(path.Join(i.TmpDir, "systemd", config.RedisServiceTemplateFile))); err != nil {
-		return fmt.Errorf("在机器: %s 上, scp文件(%s)失败: %v", i.Host, filepath.ToSlash(path.Join(i.TmpDir, "systemd", config.RedisServiceTemplateFile)), err)
+	template := global.ServiceTemplateFile(config.RedisServiceTemplateFile, i.Inst.version)
+	if err := i.Conn.Scp(path.Join(source, "systemd", template), filepath.ToSlash(path.Join(i.TmpDir, "systemd", template))); err != nil {
+		return fmt.Errorf("在机器: %s 上, scp文件(%s)失败: %v", i.Host, filepath.ToSlash(path.Join(i.TmpDir, "systemd", template)), err)
 	}
 
-	// TODO: 根据目标机器的操作系统类型选择包
-	redisPackage := fmt.Sprintf("redis6_%s_%s.tar.gz", environment.GlobalEnv().GOOS, environment.GlobalEnv().GOARCH)
 	if err := i.Conn.Scp(path.Join(source, "package", "redis", redisPackage), filepath.ToSlash(path.Join(i.TmpDir, "package", "redis", redisPackage))); err != nil {
 		return fmt.Errorf("在机器: %s 上, scp文件(%s)失败: %v", i.Host, filepath.ToSlash(path.Join(i.TmpDir, "package", "pgsql", redisPackage)), err)
 	}
+	// 复制后在远程机器上校验安装包, 远程安装时还会再按清单校验一次
+	run := func(cmd string) (string, error) {
+		stdout, stderr, err := i.Conn.Run(cmd)
+		if err != nil {
+			return "", fmt.Errorf("%v, 标准错误: %s", err, stderr)
+		}
+		return string(stdout), nil
+	}
+	if err := global.VerifyRemotePackage(run, source, config.Kinds, filepath.ToSlash(path.Join(i.TmpDir, "package", "redis", redisPackage))); err != nil {
+		return fmt.Errorf("在机器: %s 上, %v", i.Host, err)
+	}
 
 	if err := i.Conn.Chmod(filepath.ToSlash(path.Join(i.TmpDir, "bin", i.DbupCmd)), 0755); err != nil {
 		return fmt.Errorf("在机器: %s 上, chmod目录(%s)权限失败: %v", i.Host, filepath.ToSlash(path.Join(i.TmpDir, "bin", i.DbupCmd)), err)
@@ -108,6 +136,19 @@ func (i *Instance) Scp(source string) error {
 	return nil
 }
 
+// CheckPlatform 探测目标机器的操作系统和架构, 返回对应平台的安装目录和安装包文件名
+func (i *Instance) CheckPlatform(source string) (string, string, error) {
+	return global.CheckPlatform(i.Host, &i.Platform, func(cmd string) ([]byte, error) {
+		stdout, stderr, err := i.Conn.Run(cmd)
+		if err != nil {
+			return stdout, fmt.Errorf("%v, 标准错误: %s", err, stderr)
+		}
+		return stdout, nil
+	}, source, config.Kinds, func(p *global.Platform) string {
+		return config.PlatformPackageName(i.Inst.version, p)
+	})
+}
+
 func (i *Instance) DropTmpDir() error {
 	cmd := fmt.Sprintf("cd %s; rm -rf *", filepath.ToSlash(i.TmpDir))
 	if stdout, stderr, err := i.Conn.Run(cmd); err != nil {
@@ -117,10 +158,10 @@ func (i *Instance) DropTmpDir() error {
 }
 
 func (i *Instance) Install(cluster bool, onlyCheck bool, ipv6 bool) error {
-	cmd := fmt.Sprintf("%s redis install --yes --port=%d --password='%s' --memory-size='%s' --dir='%s' --maxmemory-policy='%s' --module='%s' --master='%s' --system-user='%s' --system-group='%s' --resource-limit='%s' --log='%s'",
+	cmd := fmt.Sprintf("%s redis install --yes --version='%s' --port=%d --memory-size='%s' --dir='%s' --maxmemory-policy='%s' --module='%s' --master='%s' --system-user='%s' --system-group='%s' --resource-limit='%s' --log='%s'",
 		i.DbupCmd,
+		i.Inst.version,
 		i.Inst.parameters.Port,
-		i.Inst.parameters.Password,
 		i.Inst.parameters.MemorySize,
 		i.Inst.parameters.Dir,
 		i.Inst.parameters.MaxmemoryPolicy,
@@ -142,8 +183,12 @@ func (i *Instance) Install(cluster bool, onlyCheck bool, ipv6 bool) error {
 	if ipv6 {
 		cmd = cmd + " --ipv6"
 	}
+
+	if i.Inst.parameters.Tune {
+		cmd = cmd + " --tune"
+	}
 	cmd = path.Join(i.TmpDir, "bin", cmd)
-	if stdout, stderr, err := i.Conn.Sudo(cmd); err != nil {
+	if stdout, stderr, err := i.Conn.SudoWithSecrets(cmd, map[string]string{"password": i.Inst.parameters.Password}); err != nil {
 		return fmt.Errorf("在机器: %s 上, 执行(%s)失败: %v, 标准输出: %s, 标准错误: %s", i.Host, cmd, err, stdout, stderr)
 	}
 	return nil
@@ -171,17 +216,27 @@ func (i *Instance) SystemCtl(action string) error {
 	return nil
 }
 
-func (i *Instance) ClusterCreate(nodes string, replica int) error {
-	cli := fmt.Sprintf("%s/server/bin/redis-cli", i.Inst.parameters.Dir)
-	cmd := fmt.Sprintf("%s -a '%s' --cluster create %s --cluster-replicas %d --cluster-yes", cli, i.Inst.parameters.Password, nodes, replica)
+// redisCli 在目标机器上执行 redis-cli, 密码写入只有 root 可读的临时文件, 由远程的 shell 读取后通过 REDISCLI_AUTH 传递,
+// 不出现在命令行和错误信息中
+func (i *Instance) redisCli(args string) error {
+	file, clean, err := i.Conn.WriteSecretFile([]byte(i.Inst.parameters.Password), "root")
+	if err != nil {
+		return err
+	}
+	defer clean()
+	// Sudo 把命令放在双引号中, \$ 留给 sudo 启动的 bash 展开
+	cmd := fmt.Sprintf("REDISCLI_AUTH=\\$(cat '%s') %s/server/bin/redis-cli %s", file, i.Inst.parameters.Dir, args)
 	if stdout, stderr, err := i.Conn.Sudo(cmd); err != nil {
 		return fmt.Errorf("在机器: %s 上, 执行(%s)失败: %v, 标准输出: %s, 标准错误: %s", i.Host, cmd, err, stdout, stderr)
 	}
 	return nil
 }
 
+func (i *Instance) ClusterCreate(nodes string, replica int) error {
+	return i.redisCli(fmt.Sprintf("--cluster create %s --cluster-replicas %d --cluster-yes", nodes, replica))
+}
+
 func (i *Instance) ClusterAddNode(node, cluster, role, masterID string) error {
-	cli := fmt.Sprintf("%s/server/bin/redis-cli", i.Inst.parameters.Dir)
 	slaveCmd := ""
 	if role == "slave" {
 		slaveCmd = "--cluster-slave"
@@ -190,20 +245,11 @@ func (i *Instance) ClusterAddNode(node, cluster, role, masterID string) error {
 		}
 	}
 
-	cmd := fmt.Sprintf("%s -a '%s' --cluster add-node %s %s %s --cluster-yes", cli, i.Inst.parameters.Password, node, cluster, slaveCmd)
-	if stdout, stderr, err := i.Conn.Sudo(cmd); err != nil {
-		return fmt.Errorf("在机器: %s 上, 执行(%s)失败: %v, 标准输出: %s, 标准错误: %s", i.Host, cmd, err, stdout, stderr)
-	}
-	return nil
+	return i.redisCli(fmt.Sprintf("--cluster add-node %s %s %s --cluster-yes", node, cluster, slaveCmd))
 }
 
 func (i *Instance) ClusterReBalance(cluster string) error {
-	cli := fmt.Sprintf("%s/server/bin/redis-cli", i.Inst.parameters.Dir)
-	cmd := fmt.Sprintf("%s -a '%s' --cluster rebalance %s --cluster-use-empty-masters --cluster-yes", cli, i.Inst.parameters.Password, cluster)
-	if stdout, stderr, err := i.Conn.Sudo(cmd); err != nil {
-		return fmt.Errorf("在机器: %s 上, 执行(%s)失败: %v, 标准输出: %s, 标准错误: %s", i.Host, cmd, err, stdout, stderr)
-	}
-	return nil
+	return i.redisCli(fmt.Sprintf("--cluster rebalance %s --cluster-use-empty-masters --cluster-yes", cluster))
 }
 
 //func (i *Instance) ClusterFix(cluster string) error {
@@ -216,7 +262,12 @@ func (i *Instance) ClusterReBalance(cluster string) error {
 //}
 
 func (i *Instance) Replication(master string, port int) error {
-	conn, err := dao.NewRedisConn(i.Host, i.Inst.port, i.Inst.parameters.Password)
+	dialHost, dialPort, closeTunnel, err := i.Conn.DialAddress(i.Inst.port)
+	if err != nil {
+		return err
+	}
+	defer closeTunnel()
+	conn, err := dao.NewRedisConn(dialHost, dialPort, i.Inst.parameters.Password)
 	if err != nil {
 		return err
 	}
@@ -224,8 +275,31 @@ func (i *Instance) Replication(master string, port int) error {
 	return conn.SlaveOf(master, port)
 }
 
+// ConfigSet 修改实例参数并写入配置文件
+func (i *Instance) ConfigSet(key string, value interface{}) error {
+	dialHost, dialPort, closeTunnel, err := i.Conn.DialAddress(i.Inst.port)
+	if err != nil {
+		return err
+	}
+	defer closeTunnel()
+	conn, err := dao.NewRedisConn(dialHost, dialPort, i.Inst.parameters.Password)
+	if err != nil {
+		return err
+	}
+	defer conn.Conn.Close()
+	if err := conn.ConfigSet(key, value); err != nil {
+		return fmt.Errorf("在机器: %s 上, %v", i.Host, err)
+	}
+	return nil
+}
+
 func (i *Instance) CheckSlaves() error {
-	conn, err := dao.NewRedisConn(i.Host, i.Inst.port, i.Inst.parameters.Password)
+	dialHost, dialPort, closeTunnel, err := i.Conn.DialAddress(i.Inst.port)
+	if err != nil {
+		return err
+	}
+	defer closeTunnel()
+	conn, err := dao.NewRedisConn(dialHost, dialPort, i.Inst.parameters.Password)
 	if err != nil {
 		return err
 	}
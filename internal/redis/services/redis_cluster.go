@@ -6,13 +6,18 @@
 package services
 
 import (
+	"context"
 	"dbup/internal/environment"
 	"dbup/internal/global"
+	"dbup/internal/inventory"
+	"dbup/internal/plan"
+	"dbup/internal/preflight"
 	"dbup/internal/redis/config"
 	"dbup/internal/utils"
 	"dbup/internal/utils/logger"
+	"dbup/internal/utils/parallel"
+	"dbup/internal/utils/prompt"
 	"fmt"
-	"os"
 	"path"
 	"strings"
 )
@@ -24,33 +29,32 @@ type RedisClusterDeploy struct {
 	masters   []*Instance
 	slaves    []*Instance
 	replica   int
+	ctx       context.Context
 }
 
 func NewRedisClusterDeploy() *RedisClusterDeploy {
 	return &RedisClusterDeploy{ScpStatus: make(map[string]bool), replica: 1}
 }
 
-func (d *RedisClusterDeploy) Run(c string) error {
-	// 初始化参数和配置环节
-	if err := global.YAMLLoadFromFile(c, &d.Option); err != nil {
-		return err
-	}
+// WithContext 设置部署使用的 ctx, ctx 取消后不再开始新的步骤. 回滚和清理不受 ctx 影响
+func (d *RedisClusterDeploy) WithContext(ctx context.Context) *RedisClusterDeploy {
+	d.ctx = ctx
+	return d
+}
 
-	if err := d.Option.Validator(); err != nil {
-		return err
+func (d *RedisClusterDeploy) context() context.Context {
+	if d.ctx == nil {
+		return context.Background()
 	}
+	return d.ctx
+}
 
-	d.Option.SetDefault()
-	d.GetHostList()
-
-	if err := d.Option.CheckDuplicate(); err != nil {
+func (d *RedisClusterDeploy) Run(c string) error {
+	// 初始化参数和配置环节
+	if err := d.load(c); err != nil {
 		return err
 	}
 
-	if len(d.Option.Slave) == 0 {
-		d.replica = 0
-	}
-
 	logger.Infof("初始化部署对象\n")
 	if d.Option.SSHConfig.Password != "" {
 		if err := d.Init(); err != nil {
@@ -83,9 +87,82 @@ func (d *RedisClusterDeploy) Run(c string) error {
 		}
 		return err
 	}
+	if err := inventory.RecordCluster(d.cluster()); err != nil {
+		logger.Warningf("更新实例清单失败: %v\n", err)
+	}
+	return nil
+}
+
+// load 读取并验证部署配置
+func (d *RedisClusterDeploy) load(c string) error {
+	if err := global.YAMLLoadFromFile(c, &d.Option); err != nil {
+		return err
+	}
+
+	if err := d.Option.Validator(); err != nil {
+		return err
+	}
+
+	d.Option.SetDefault()
+	d.GetHostList()
+
+	if err := d.Option.CheckDuplicate(); err != nil {
+		return err
+	}
+
+	if len(d.Option.Slave) == 0 {
+		d.replica = 0
+	}
 	return nil
 }
 
+// Plan 只读连接各节点, 输出部署计划和冲突, 不做任何修改
+func (d *RedisClusterDeploy) Plan(c string) error {
+	if err := d.load(c); err != nil {
+		return err
+	}
+	s := d.Option.SSHConfig
+	files := []string{
+		"bin/dbup",
+		"package/md5",
+		path.Join("systemd", global.ServiceTemplateFile(config.RedisServiceTemplateFile, d.Option.RedisConfig.Version)),
+		path.Join("package", "redis", config.PackageName(d.Option.RedisConfig.Version)),
+	}
+
+	logger.Infof("检查部署节点\n")
+	p := plan.New(config.Kinds, "redis-cluster", plan.SSHDialer(s.Username, s.Password, s.KeyFile, s.Port, s.SSHOptions()))
+	var nodes []string
+	for _, node := range append(append([]config.RedisClusterNode{}, d.Option.Master...), d.Option.Slave...) {
+		h := p.Host(node.Host)
+		h.Stage(s.TmpDir, files...)
+		h.Instance(config.Kinds, node.Port, node.Dir, fmt.Sprintf(config.ServiceFileName, node.Port))
+		nodes = append(nodes, fmt.Sprintf("%s:%d", node.Host, node.Port))
+	}
+	if len(d.Option.Master) > 0 {
+		first := p.Host(d.Option.Master[0].Host)
+		first.Add(plan.ActionReplication, "创建集群, 每个主节点 %d 个从节点: %s", d.replica, strings.Join(nodes, " "))
+	}
+	for _, h := range p.Hosts {
+		h.Add(plan.ActionCleanup, "删除临时目录 %s 中的文件", s.TmpDir)
+	}
+	return p.Finish()
+}
+
+// Check 连接各节点执行部署前检查, 同时检查集群总线端口, 不做任何修改
+func (d *RedisClusterDeploy) Check(c string) error {
+	if err := d.load(c); err != nil {
+		return err
+	}
+	s := d.Option.SSHConfig
+
+	logger.Infof("检查部署节点环境\n")
+	p := preflight.New(plan.SSHDialer(s.Username, s.Password, s.KeyFile, s.Port, s.SSHOptions()))
+	for _, node := range append(append([]config.RedisClusterNode{}, d.Option.Master...), d.Option.Slave...) {
+		p.Add(node.Host, config.Kinds, []int{node.Port, node.Port + 10000}, node.Dir)
+	}
+	return p.Finish()
+}
+
 func (d *RedisClusterDeploy) RemoveCluster(c string, yes bool) error {
 	// 初始化参数和配置环节
 	if err := global.YAMLLoadFromFile(c, &d.Option); err != nil {
@@ -119,15 +196,9 @@ func (d *RedisClusterDeploy) RemoveCluster(c string, yes bool) error {
 
 	if !yes {
 		logger.Warningf("删除集群是危险操作,会将整个集群中的数据完全删除, 不可恢复\n")
-		logger.Warningf("是否确认删除[y|n]:")
-
-		var yes string
-		if _, err := fmt.Scanln(&yes); err != nil {
+		if err := prompt.Confirm("是否确认删除"); err != nil {
 			return err
 		}
-		if strings.ToUpper(yes) != "Y" && strings.ToUpper(yes) != "YES" {
-			os.Exit(0)
-		}
 	}
 
 	logger.Infof("初始化删除对象\n")
@@ -153,9 +224,27 @@ func (d *RedisClusterDeploy) RemoveCluster(c string, yes bool) error {
 
 	d.UNInstall()
 
+	if err := inventory.ForgetCluster(d.cluster().Name); err != nil {
+		logger.Warningf("更新实例清单失败: %v\n", err)
+	}
 	return nil
 }
 
+// cluster 集群成员, 用于记录到实例清单
+func (d *RedisClusterDeploy) cluster() inventory.Cluster {
+	c := inventory.Cluster{Engine: config.Kinds, Mode: "redis-cluster"}
+	for _, node := range d.Option.Master {
+		c.Members = append(c.Members, inventory.Member{Host: node.Host, Port: node.Port, Role: "master", Dir: node.Dir})
+	}
+	for _, node := range d.Option.Slave {
+		c.Members = append(c.Members, inventory.Member{Host: node.Host, Port: node.Port, Role: "slave", Dir: node.Dir})
+	}
+	if len(c.Members) > 0 {
+		c.Name = inventory.ClusterName(config.Kinds, c.Members[0].Host, c.Members[0].Port)
+	}
+	return c
+}
+
 func (d *RedisClusterDeploy) GetHostList() {
 	for _, node := range d.Option.Master {
 		d.ScpStatus[node.Host] = false
@@ -181,8 +270,11 @@ func (d *RedisClusterDeploy) Init() error {
 				MemorySize:      d.Option.RedisConfig.Memory,
 				Module:          d.Option.RedisConfig.Module,
 				ResourceLimit:   d.Option.RedisConfig.ResourceLimit,
+				Tune:            d.Option.RedisConfig.Tune,
 				MaxmemoryPolicy: d.Option.RedisConfig.MaxmemoryPolicy,
-			})
+				Version:         d.Option.RedisConfig.Version,
+			},
+			d.Option.SSHConfig.SSHOptions())
 		if err != nil {
 			return err
 		}
@@ -204,7 +296,10 @@ func (d *RedisClusterDeploy) Init() error {
 				MemorySize:    d.Option.RedisConfig.Memory,
 				Module:        d.Option.RedisConfig.Module,
 				ResourceLimit: d.Option.RedisConfig.ResourceLimit,
-			})
+				Tune:          d.Option.RedisConfig.Tune,
+				Version:       d.Option.RedisConfig.Version,
+			},
+			d.Option.SSHConfig.SSHOptions())
 		if err != nil {
 			return err
 		}
@@ -229,8 +324,11 @@ func (d *RedisClusterDeploy) InitUseKeyFile() error {
 				MemorySize:      d.Option.RedisConfig.Memory,
 				Module:          d.Option.RedisConfig.Module,
 				ResourceLimit:   d.Option.RedisConfig.ResourceLimit,
+				Tune:            d.Option.RedisConfig.Tune,
 				MaxmemoryPolicy: d.Option.RedisConfig.MaxmemoryPolicy,
-			})
+				Version:         d.Option.RedisConfig.Version,
+			},
+			d.Option.SSHConfig.SSHOptions())
 		if err != nil {
 			return err
 		}
@@ -252,7 +350,10 @@ func (d *RedisClusterDeploy) InitUseKeyFile() error {
 				MemorySize:    d.Option.RedisConfig.Memory,
 				Module:        d.Option.RedisConfig.Module,
 				ResourceLimit: d.Option.RedisConfig.ResourceLimit,
-			})
+				Tune:          d.Option.RedisConfig.Tune,
+				Version:       d.Option.RedisConfig.Version,
+			},
+			d.Option.SSHConfig.SSHOptions())
 		if err != nil {
 			return err
 		}
@@ -263,66 +364,43 @@ func (d *RedisClusterDeploy) InitUseKeyFile() error {
 
 func (d *RedisClusterDeploy) CheckTmpDir() error {
 	logger.Infof("检查目标机器的临时目录\n")
-	for _, slave := range d.masters {
-		if err := slave.CheckTmpDir(); err != nil {
-			return err
-		}
-	}
-	for _, slave := range d.slaves {
-		if err := slave.CheckTmpDir(); err != nil {
-			return err
-		}
-	}
-	return nil
+	return d.each(d.context(), "检查临时目录", func(inst *Instance) error {
+		return inst.CheckTmpDir()
+	})
 }
 
 func (d *RedisClusterDeploy) DropTmpDir() {
 	logger.Infof("删除目标机器的临时目录\n")
-	for _, master := range d.masters {
-		_ = master.DropTmpDir()
-	}
-	for _, slave := range d.slaves {
-		_ = slave.DropTmpDir()
-	}
+	_ = d.each(context.Background(), "删除临时目录", func(inst *Instance) error {
+		_ = inst.DropTmpDir()
+		return nil
+	})
 }
 
+// Scp 同一台机器上的多个实例使用同一个临时目录, 每台机器只复制一次
 func (d *RedisClusterDeploy) Scp() error {
 	logger.Infof("将所需文件复制到目标机器\n")
 	source := path.Join(environment.GlobalEnv().ProgramPath, "..")
-	for _, master := range d.masters {
-		if d.ScpStatus[master.Host] {
+	var tasks []parallel.Task
+	for _, inst := range append(append([]*Instance{}, d.masters...), d.slaves...) {
+		if d.ScpStatus[inst.Host] {
 			continue
 		}
-		logger.Infof("复制到: %s\n", master.Host)
-		if err := master.Scp(source); err != nil {
-			return err
-		}
-	}
-	for _, slave := range d.slaves {
-		if d.ScpStatus[slave.Host] {
-			continue
-		}
-		logger.Infof("复制到: %s\n", slave.Host)
-		if err := slave.Scp(source); err != nil {
-			return err
-		}
-	}
-	return nil
+		d.ScpStatus[inst.Host] = true
+		inst := inst
+		tasks = append(tasks, parallel.Task{Host: inst.Host, Run: func() error {
+			logger.Infof("复制到: %s\n", inst.Host)
+			return inst.Scp(source)
+		}})
+	}
+	return parallel.Run(d.context(), "复制文件", tasks)
 }
 
 func (d *RedisClusterDeploy) CheckEnv() error {
 	logger.Infof("检查环境\n")
-	for _, master := range d.masters {
-		if err := master.Install(true, true, false); err != nil {
-			return err
-		}
-	}
-	for _, slave := range d.slaves {
-		if err := slave.Install(true, true, false); err != nil {
-			return err
-		}
-	}
-	return nil
+	return d.each(d.context(), "检查环境", func(inst *Instance) error {
+		return inst.Install(true, true, false)
+	})
 }
 
 func (d *RedisClusterDeploy) InstallAndInitSlave() error {
@@ -347,33 +425,30 @@ func (d *RedisClusterDeploy) InstallAndInitSlave() error {
 	return nil
 }
 
+// Install 不同机器上的实例同时安装, 全部安装完成后由 CreateCluster 创建集群
 func (d *RedisClusterDeploy) Install() error {
 	logger.Infof("开始安装\n")
-	for _, master := range d.masters {
-		if err := master.Install(true, false, false); err != nil {
-			return err
-		}
-	}
-	for _, slave := range d.slaves {
-		if err := slave.Install(true, false, false); err != nil {
-			return err
-		}
-	}
-	return nil
+	return d.each(d.context(), "安装", func(inst *Instance) error {
+		return inst.Install(true, false, false)
+	})
 }
 
 func (d *RedisClusterDeploy) UNInstall() {
 	logger.Infof("开始卸载清理\n")
-	for _, master := range d.masters {
-		if err := master.UNInstall(); err != nil {
-			logger.Warningf("卸载节点: %s 失败: %v\n", master.Host, err)
-		}
-	}
-	for _, slave := range d.slaves {
-		if err := slave.UNInstall(); err != nil {
-			logger.Warningf("卸载节点: %s 失败: %v\n", slave.Host, err)
-		}
+	// 卸载失败的节点由 parallel.Run 打印警告
+	_ = d.each(context.Background(), "卸载", func(inst *Instance) error {
+		return inst.UNInstall()
+	})
+}
+
+// each 在所有实例上执行 fn, 不同机器并发执行, 同一台机器上的实例按顺序执行
+func (d *RedisClusterDeploy) each(ctx context.Context, name string, fn func(inst *Instance) error) error {
+	var tasks []parallel.Task
+	for _, inst := range append(append([]*Instance{}, d.masters...), d.slaves...) {
+		inst := inst
+		tasks = append(tasks, parallel.Task{Host: inst.Host, Run: func() error { return fn(inst) }})
 	}
+	return parallel.Run(ctx, name, tasks)
 }
 
 func (d *RedisClusterDeploy) CreateCluster() error {
@@ -117,7 +117,7 @@ func (b *RedisClusterBackup) Run() error {
 
 	if !b.BackupToS3 {
 		if b.Expire != 0 {
-			fmt.Println("删除本地过期备份")
+			logger.Infof("删除本地过期备份\n")
 			if err := b.RemoveLocalExpired(); err != nil {
 				return err
 			}
@@ -135,7 +135,7 @@ func (b *RedisClusterBackup) Run() error {
 	}
 
 	if b.Expire != 0 {
-		fmt.Println("删除S3过期备份")
+		logger.Infof("删除S3过期备份\n")
 		if err := b.RemoveFromS3Action(); err != nil {
 			return err
 		}
@@ -246,7 +246,7 @@ func (b *RedisClusterBackup) BackupToS3Action(masters []BackupInfo) error {
 		}
 	}
 
-	fmt.Println("上传到S3完成")
+	logger.Infof("上传到S3完成\n")
 	return nil
 }
 
@@ -270,6 +270,6 @@ func (b *RedisClusterBackup) RemoveFromS3Action() error {
 		}
 	}
 
-	fmt.Println("删除完成")
+	logger.Infof("删除S3过期备份完成\n")
 	return nil
 }
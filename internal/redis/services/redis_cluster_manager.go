@@ -71,7 +71,8 @@ func (d *RedisClusterManager) AddNode(o config.RedisClusterAddNodeOption, role s
 			o.SSHConfig.Username,
 			o.SSHConfig.Password,
 			o.SSHConfig.Port,
-			o.Parameter)
+			o.Parameter,
+			o.SSHConfig.SSHOptions())
 		if err != nil {
 			return err
 		}
@@ -81,7 +82,8 @@ func (d *RedisClusterManager) AddNode(o config.RedisClusterAddNodeOption, role s
 			o.SSHConfig.Username,
 			o.SSHConfig.KeyFile,
 			o.SSHConfig.Port,
-			o.Parameter)
+			o.Parameter,
+			o.SSHConfig.SSHOptions())
 		if err != nil {
 			return err
 		}
@@ -150,8 +152,8 @@ func (d *RedisClusterManager) RedisClusterFix(cli string, cluster string, passwo
 		return fmt.Errorf("解析 cluster 地址(%s)失败: %v", cluster, err)
 	}
 
-	cmd := fmt.Sprintf("echo yes | %s -a %s --cluster fix %s --cluster-fix-with-unreachable-masters --cluster-yes", cli, password, cluster)
-	l := command.Local{Timeout: 600}
+	cmd := fmt.Sprintf("echo yes | %s --cluster fix %s --cluster-fix-with-unreachable-masters --cluster-yes", cli, cluster)
+	l := command.Local{Timeout: 600, Env: []string{"REDISCLI_AUTH=" + password}}
 	if _, stderr, err := l.Run(cmd); err != nil {
 		return fmt.Errorf("执行 fix 修复失败: %v, 标准错误输出: %s", err, stderr)
 	}
@@ -39,7 +39,8 @@ func (d *RedisManager) AddSlaveNode(o config.RedisClusterAddNodeOption) error {
 			o.SSHConfig.Username,
 			o.SSHConfig.Password,
 			o.SSHConfig.Port,
-			o.Parameter)
+			o.Parameter,
+			o.SSHConfig.SSHOptions())
 		if err != nil {
 			return err
 		}
@@ -49,7 +50,8 @@ func (d *RedisManager) AddSlaveNode(o config.RedisClusterAddNodeOption) error {
 			o.SSHConfig.Username,
 			o.SSHConfig.KeyFile,
 			o.SSHConfig.Port,
-			o.Parameter)
+			o.Parameter,
+			o.SSHConfig.SSHOptions())
 		if err != nil {
 			return err
 		}
@@ -6,10 +6,9 @@ import (
 	"dbup/internal/redis/dao"
 	"dbup/internal/utils/command"
 	"dbup/internal/utils/logger"
+	"dbup/internal/utils/prompt"
 	"fmt"
-	"os"
 	"path/filepath"
-	"strings"
 )
 
 type UPgrade struct {
@@ -55,9 +54,9 @@ func (u *UPgrade) Validator() error {
 
 	switch result {
 	case 1:
-		logger.Errorf("Redis 老版本 %s 不能大于新版本 %s \n", OldVersion, u.NewVersion)
+		return fmt.Errorf("Redis 老版本 %s 不能大于新版本 %s", OldVersion, u.NewVersion)
 	case 0:
-		logger.Errorf("Redis 老版本 %s 不能等于新版本 %s \n", OldVersion, u.NewVersion)
+		return fmt.Errorf("Redis 老版本 %s 不能等于新版本 %s", OldVersion, u.NewVersion)
 	}
 
 	return nil
@@ -69,15 +68,10 @@ func (u *UPgrade) Run() error {
 	}
 
 	if !u.Yes {
-		var yes string
 		logger.Warningf("升级版本需要备份主节点AOF和重启实例: 127.0.0.1:%d\n", u.Port)
-		logger.Warningf("是否确认升级[y|n]:")
-		if _, err := fmt.Scanln(&yes); err != nil {
+		if err := prompt.Confirm("是否确认升级"); err != nil {
 			return err
 		}
-		if strings.ToUpper(yes) != "Y" && strings.ToUpper(yes) != "YES" {
-			os.Exit(0)
-		}
 	}
 
 	logger.Infof("开始升级\n")
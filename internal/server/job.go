@@ -0,0 +1,271 @@
+package server
+
+import (
+	"context"
+	"dbup/internal/output"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 任务状态
+const (
+	StatusQueued   = "queued"
+	StatusRunning  = "running"
+	StatusSuccess  = "success"
+	StatusFailed   = "failed"
+	StatusCanceled = "canceled"
+)
+
+// 内存中最多保留的已结束任务数, 超出后删除最早结束的任务
+const maxFinishedJobs = 500
+
+// LogLine 任务的一条提示信息
+type LogLine struct {
+	Time    time.Time `json:"time"`
+	Level   string    `json:"level"`
+	Message string    `json:"message"`
+}
+
+// Job 提交的一个任务
+type Job struct {
+	ID         string        `json:"id"`
+	Type       string        `json:"type"`
+	Status     string        `json:"status"`
+	Error      *output.Error `json:"error,omitempty"`
+	CreatedAt  time.Time     `json:"created_at"`
+	StartedAt  *time.Time    `json:"started_at,omitempty"`
+	FinishedAt *time.Time    `json:"finished_at,omitempty"`
+
+	run    func(ctx context.Context) error
+	ctx    context.Context
+	cancel context.CancelFunc
+	logs   []LogLine
+	// 有新的提示信息或任务结束时关闭并重新生成, 用于跟踪日志
+	changed chan struct{}
+}
+
+func (j *Job) finished() bool {
+	return j.Status == StatusSuccess || j.Status == StatusFailed || j.Status == StatusCanceled
+}
+
+// Manager 任务队列. 提示信息和交互确认都是进程级的设置, 所以任务按提交顺序依次执行
+type Manager struct {
+	mu      sync.Mutex
+	jobs    map[string]*Job
+	order   []string
+	queue   chan *Job
+	current *Job
+	seq     int
+}
+
+// NewManager 生成任务队列, 需要调用 Run 开始执行
+func NewManager() *Manager {
+	return &Manager{
+		jobs:  make(map[string]*Job),
+		queue: make(chan *Job, 1024),
+	}
+}
+
+// Submit 提交任务, 队列已满时返回错误
+func (m *Manager) Submit(typ string, run func(ctx context.Context) error) (Job, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.seq++
+	now := time.Now()
+	ctx, cancel := context.WithCancel(context.Background())
+	j := &Job{
+		ID:        fmt.Sprintf("%s-%d", now.Format("20060102150405"), m.seq),
+		Type:      typ,
+		Status:    StatusQueued,
+		CreatedAt: now,
+		run:       run,
+		ctx:       ctx,
+		cancel:    cancel,
+		changed:   make(chan struct{}),
+	}
+	select {
+	case m.queue <- j:
+	default:
+		cancel()
+		return Job{}, output.Errorf(output.CodeConflict, "等待执行的任务过多, 请稍后再提交")
+	}
+	m.jobs[j.ID] = j
+	m.order = append(m.order, j.ID)
+	return *j, nil
+}
+
+// Run 依次执行队列中的任务, ctx 取消后返回, 正在执行的任务会被取消
+func (m *Manager) Run(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case j := <-m.queue:
+			m.execute(ctx, j)
+		}
+	}
+}
+
+func (m *Manager) execute(ctx context.Context, j *Job) {
+	m.mu.Lock()
+	if j.Status != StatusQueued {
+		// 排队时已经取消
+		m.mu.Unlock()
+		return
+	}
+	now := time.Now()
+	j.Status = StatusRunning
+	j.StartedAt = &now
+	m.current = j
+	m.notify(j)
+	m.mu.Unlock()
+
+	stop := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			j.cancel()
+		case <-stop:
+		}
+	}()
+	err := m.runJob(j)
+	close(stop)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.current = nil
+	m.finish(j, err)
+}
+
+// runJob 执行任务, 任务中的 panic 作为任务失败, 不影响服务
+func (m *Manager) runJob(j *Job) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("任务异常退出: %v", r)
+		}
+	}()
+	return j.run(j.ctx)
+}
+
+// finish 记录任务结果, 调用时需要持有 m.mu
+func (m *Manager) finish(j *Job, err error) {
+	now := time.Now()
+	j.FinishedAt = &now
+	switch {
+	case err == nil:
+		j.Status = StatusSuccess
+	case output.Code(err) == output.CodeCanceled:
+		j.Status = StatusCanceled
+		j.Error = &output.Error{Code: output.CodeCanceled, Message: err.Error()}
+	default:
+		j.Status = StatusFailed
+		j.Error = &output.Error{Code: output.Code(err), Message: err.Error()}
+	}
+	j.cancel()
+	m.notify(j)
+	m.prune()
+}
+
+// notify 唤醒跟踪日志的请求, 调用时需要持有 m.mu
+func (m *Manager) notify(j *Job) {
+	close(j.changed)
+	j.changed = make(chan struct{})
+}
+
+// prune 删除超出数量的已结束任务, 调用时需要持有 m.mu
+func (m *Manager) prune() {
+	finished := 0
+	for _, id := range m.order {
+		if m.jobs[id].finished() {
+			finished++
+		}
+	}
+	if finished <= maxFinishedJobs {
+		return
+	}
+	order := m.order[:0]
+	for _, id := range m.order {
+		if finished > maxFinishedJobs && m.jobs[id].finished() {
+			delete(m.jobs, id)
+			finished--
+			continue
+		}
+		order = append(order, id)
+	}
+	m.order = order
+}
+
+// Cancel 取消任务. 排队中的任务直接结束; 执行中的任务在当前步骤完成后结束
+func (m *Manager) Cancel(id string) (Job, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	j, ok := m.jobs[id]
+	if !ok {
+		return Job{}, output.Errorf(output.CodeNotFound, "任务 %s 不存在", id)
+	}
+	if j.finished() {
+		return Job{}, output.Errorf(output.CodeConflict, "任务 %s 已经结束, 状态: %s", id, j.Status)
+	}
+	if j.Status == StatusQueued {
+		m.finish(j, output.Errorf(output.CodeCanceled, "任务在执行前取消"))
+	} else {
+		j.cancel()
+	}
+	return *j, nil
+}
+
+// Get 返回任务状态
+func (m *Manager) Get(id string) (Job, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	j, ok := m.jobs[id]
+	if !ok {
+		return Job{}, output.Errorf(output.CodeNotFound, "任务 %s 不存在", id)
+	}
+	return *j, nil
+}
+
+// List 按提交顺序返回所有任务
+func (m *Manager) List() []Job {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	jobs := make([]Job, 0, len(m.order))
+	for _, id := range m.order {
+		jobs = append(jobs, *m.jobs[id])
+	}
+	return jobs
+}
+
+// Logs 返回任务从第 from 条开始的提示信息, 是否已经结束, 以及有新信息时会关闭的 channel
+func (m *Manager) Logs(id string, from int) ([]LogLine, bool, <-chan struct{}, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	j, ok := m.jobs[id]
+	if !ok {
+		return nil, false, nil, output.Errorf(output.CodeNotFound, "任务 %s 不存在", id)
+	}
+	var lines []LogLine
+	if from < len(j.logs) {
+		lines = append(lines, j.logs[from:]...)
+	}
+	return lines, j.finished(), j.changed, nil
+}
+
+// Log 记录执行中任务的提示信息, 作为 logger 的 Handler. 没有执行中的任务时返回 false
+func (m *Manager) Log(level, message string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.current == nil {
+		return false
+	}
+	m.current.logs = append(m.current.logs, LogLine{
+		Time:    time.Now(),
+		Level:   level,
+		Message: strings.TrimRight(message, "\n"),
+	})
+	m.notify(m.current)
+	return true
+}
@@ -0,0 +1,301 @@
+package server
+
+import (
+	"context"
+	"dbup/internal/environment"
+	"dbup/internal/global"
+	mariadbconfig "dbup/internal/mariadb/config"
+	mongoconfig "dbup/internal/mongodb/config"
+	pgconfig "dbup/internal/pgsql/config"
+	redisconfig "dbup/internal/redis/config"
+	"dbup/pkg/dbup"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// 部署任务的配置文件保存在 ~/.dbup/serve 下, 配置文件中有密码, 任务结束后删除
+const configDir = "serve"
+
+// jobType 一种任务. params 生成带默认值的参数, 请求中的 params 覆盖其中的字段; check 检查请求中的参数, 可以为空; run 执行任务
+type jobType struct {
+	params func() interface{}
+	check  func(params interface{}) error
+	run    func(ctx context.Context, params interface{}) error
+}
+
+// deployJob 部署任务: 参数与部署配置文件的结构相同, 保存为配置文件后按配置文件部署
+func deployJob(params func() interface{}, save func(params interface{}, file string) error, deploy func(ctx context.Context, file string) error) jobType {
+	return jobType{
+		params: params,
+		run: func(ctx context.Context, params interface{}) error {
+			file, err := saveConfig(params, save)
+			if file != "" {
+				defer os.Remove(file)
+			}
+			if err != nil {
+				return err
+			}
+			return deploy(ctx, file)
+		},
+	}
+}
+
+// backupJob 备份任务. 备份命令在服务端执行, 只能使用默认值, 请求中指定其他 BackupCmd 时报错
+func backupJob(params func() interface{}, cmd func(params interface{}) string, run func(ctx context.Context, params interface{}) error) jobType {
+	return jobType{
+		params: params,
+		check: func(p interface{}) error {
+			if c, def := cmd(p), cmd(params()); c != def {
+				return fmt.Errorf("不能指定 BackupCmd: %s, 备份任务只能使用 %s", c, def)
+			}
+			return nil
+		},
+		run: run,
+	}
+}
+
+func saveConfig(params interface{}, save func(params interface{}, file string) error) (string, error) {
+	dir := filepath.Join(environment.GlobalEnv().DbupInfoPath, configDir)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", err
+	}
+	f, err := ioutil.TempFile(dir, "deploy-*.conf")
+	if err != nil {
+		return "", err
+	}
+	f.Close()
+	// 配置文件中有密码
+	if err := os.Chmod(f.Name(), 0600); err != nil {
+		return f.Name(), err
+	}
+	return f.Name(), save(params, f.Name())
+}
+
+func saveINI(params interface{}, file string) error {
+	return global.INISaveToFile(file, params)
+}
+
+// savePgsqlDeploy 和 saveRedisDeploy 除了结构体的字段, 还把 Replicas 写入 [slave.<名称>] section
+func savePgsqlDeploy(params interface{}, file string) error {
+	return params.(*pgconfig.Parameter).SlaveTo(file)
+}
+
+func saveRedisDeploy(params interface{}, file string) error {
+	return params.(*redisconfig.Parameter).SlaveTo(file)
+}
+
+func saveYAML(params interface{}, file string) error {
+	return global.YAMLSaveToFile(file, params)
+}
+
+// 参数的默认值与对应命令行参数的默认值相同. 提交任务即视为确认, 不需要指定 Yes
+var jobTypes = map[string]jobType{
+	"pgsql.install": {
+		params: func() interface{} {
+			return &dbup.PgsqlPrepare{SystemUser: pgconfig.DefaultPGAdminUser, SystemGroup: pgconfig.DefaultPGAdminUser}
+		},
+		run: func(ctx context.Context, p interface{}) error {
+			return dbup.PgsqlInstall(ctx, *p.(*dbup.PgsqlPrepare), "", "", false, false)
+		},
+	},
+	"pgsql.add-slave": {
+		params: func() interface{} {
+			return &pgsqlAddSlave{
+				SSH:     dbup.SSHConfig{Port: 22},
+				Prepare: dbup.PgsqlPrepare{SystemUser: pgconfig.DefaultPGAdminUser, SystemGroup: pgconfig.DefaultPGAdminUser},
+			}
+		},
+		run: func(ctx context.Context, p interface{}) error {
+			o := p.(*pgsqlAddSlave)
+			return dbup.PgsqlAddSlave(ctx, o.SSH, o.Prepare, o.Master)
+		},
+	},
+	"pgsql.backup": backupJob(
+		func() interface{} {
+			return &dbup.PgsqlBackupOptions{Username: "pguser", Host: "127.0.0.1", Port: 5432, BackupCmd: "pg_basebackup"}
+		},
+		func(p interface{}) string { return p.(*dbup.PgsqlBackupOptions).BackupCmd },
+		func(ctx context.Context, p interface{}) error {
+			return dbup.PgsqlBackup(ctx, p.(*dbup.PgsqlBackupOptions))
+		},
+	),
+	"pgsql.upgrade": {
+		params: func() interface{} { return &dbup.PgsqlUpgradeOptions{} },
+		run: func(ctx context.Context, p interface{}) error {
+			return dbup.PgsqlUpgrade(ctx, p.(*dbup.PgsqlUpgradeOptions))
+		},
+	},
+	"pgsql.cluster-deploy": deployJob(
+		func() interface{} { return &pgconfig.Parameter{} },
+		savePgsqlDeploy,
+		dbup.PgsqlDeploy,
+	),
+
+	"redis.install": {
+		params: func() interface{} {
+			return &dbup.RedisParameters{SystemUser: redisconfig.DefaultRedisSystemUser, SystemGroup: redisconfig.DefaultRedisSystemGroup, MaxmemoryPolicy: "noeviction"}
+		},
+		run: func(ctx context.Context, p interface{}) error {
+			return dbup.RedisInstall(ctx, *p.(*dbup.RedisParameters), "", false)
+		},
+	},
+	"redis.add-slave": {
+		params: func() interface{} {
+			o := &dbup.RedisAddNodeOptions{TmpDir: redisconfig.RedisClusterDeployTmpDir}
+			o.SSHConfig.Port = 22
+			o.Parameter.SystemUser = redisconfig.DefaultRedisSystemUser
+			o.Parameter.SystemGroup = redisconfig.DefaultRedisSystemGroup
+			return o
+		},
+		run: func(ctx context.Context, p interface{}) error {
+			return dbup.RedisAddSlave(ctx, *p.(*dbup.RedisAddNodeOptions))
+		},
+	},
+	"redis.backup": backupJob(
+		func() interface{} {
+			return &dbup.RedisBackupOptions{Host: "127.0.0.1", BackupCmd: "redis-cli"}
+		},
+		func(p interface{}) string { return p.(*dbup.RedisBackupOptions).BackupCmd },
+		func(ctx context.Context, p interface{}) error {
+			return dbup.RedisBackup(ctx, p.(*dbup.RedisBackupOptions))
+		},
+	),
+	"redis.upgrade": {
+		params: func() interface{} { return &dbup.RedisUpgradeOptions{} },
+		run: func(ctx context.Context, p interface{}) error {
+			return dbup.RedisUpgrade(ctx, p.(*dbup.RedisUpgradeOptions))
+		},
+	},
+	"redis.cluster-deploy": deployJob(
+		func() interface{} { return &redisconfig.Parameter{} },
+		saveRedisDeploy,
+		dbup.RedisDeploy,
+	),
+	"redis-cluster.deploy": deployJob(
+		func() interface{} { return &redisconfig.RedisClusterOption{} },
+		saveYAML,
+		dbup.RedisClusterDeploy,
+	),
+
+	"mongodb.install": {
+		params: func() interface{} {
+			return &dbup.MongodbOptions{SystemUser: mongoconfig.DefaultMongoDBSystemUser, SystemGroup: mongoconfig.DefaultMongoDBSystemGroup, Memory: 1}
+		},
+		run: func(ctx context.Context, p interface{}) error {
+			return dbup.MongodbInstall(ctx, *p.(*dbup.MongodbOptions), false)
+		},
+	},
+	"mongodb.add-slave": {
+		params: func() interface{} {
+			return &mongodbAddSlave{
+				SSH:     dbup.SSHConfig{Port: 22},
+				Options: dbup.MongodbOptions{SystemUser: mongoconfig.DefaultMongoDBSystemUser, SystemGroup: mongoconfig.DefaultMongoDBSystemGroup, Memory: 1},
+			}
+		},
+		run: func(ctx context.Context, p interface{}) error {
+			o := p.(*mongodbAddSlave)
+			return dbup.MongodbAddSlave(ctx, o.SSH, o.Options)
+		},
+	},
+	"mongodb.backup": backupJob(
+		func() interface{} {
+			return &dbup.MongodbBackupOptions{Host: "127.0.0.1", BackupCmd: "mongodump"}
+		},
+		func(p interface{}) string { return p.(*dbup.MongodbBackupOptions).BackupCmd },
+		func(ctx context.Context, p interface{}) error {
+			return dbup.MongodbBackup(ctx, p.(*dbup.MongodbBackupOptions))
+		},
+	),
+	"mongodb.replication-deploy": deployJob(
+		func() interface{} { return &mongoconfig.MongoDBDeployOptions{} },
+		saveINI,
+		func(ctx context.Context, file string) error {
+			return dbup.MongodbDeploy(ctx, file, false)
+		},
+	),
+	"mongodb.cluster-deploy": deployJob(
+		func() interface{} { return &mongoconfig.MongoDBClusterOptions{} },
+		saveYAML,
+		dbup.MongodbClusterDeploy,
+	),
+
+	"mariadb.install": {
+		params: func() interface{} {
+			return &dbup.MariadbOptions{SystemUser: mariadbconfig.DefaultMariaDBSystemUser, SystemGroup: mariadbconfig.DefaultMariaDBSystemGroup, Memory: "128M", TxIsolation: "RC", AutoIncrement: 1}
+		},
+		run: func(ctx context.Context, p interface{}) error {
+			return dbup.MariadbInstall(ctx, *p.(*dbup.MariadbOptions), false)
+		},
+	},
+	"mariadb.add-slave": {
+		params: func() interface{} {
+			return &mariadbAddSlave{
+				Server:  dbup.MariadbServer{SshPort: 22},
+				Options: dbup.MariadbOptions{SystemUser: mariadbconfig.DefaultMariaDBSystemUser, SystemGroup: mariadbconfig.DefaultMariaDBSystemGroup, Memory: "1G"},
+			}
+		},
+		run: func(ctx context.Context, p interface{}) error {
+			o := p.(*mariadbAddSlave)
+			return dbup.MariadbAddSlave(ctx, o.Server, o.Options)
+		},
+	},
+	"mariadb.backup": backupJob(
+		func() interface{} {
+			return &dbup.MariadbBackupOptions{Port: 3306, BackupCmd: "mariadb-dump"}
+		},
+		func(p interface{}) string { return p.(*dbup.MariadbBackupOptions).BackupCmd },
+		func(ctx context.Context, p interface{}) error {
+			return dbup.MariadbBackup(ctx, p.(*dbup.MariadbBackupOptions))
+		},
+	),
+	"mariadb.upgrade": {
+		params: func() interface{} {
+			u := dbup.NewMariadbUpgradeOptions()
+			u.Host, u.Port, u.EmoloyDir, u.TxIsolation = "127.0.0.1", 3306, "/tmp", "RC"
+			return u
+		},
+		run: func(ctx context.Context, p interface{}) error {
+			return dbup.MariadbUpgrade(ctx, p.(*dbup.MariadbUpgradeOptions))
+		},
+	},
+	"mariadb.replication-deploy": deployJob(
+		func() interface{} { return &mariadbconfig.MariaDBDeployOptions{} },
+		saveINI,
+		dbup.MariadbDeploy,
+	),
+	"mariadb.galera": deployJob(
+		func() interface{} { return &mariadbconfig.MariaDBDeployOptions{} },
+		saveINI,
+		dbup.MariadbGaleraDeploy,
+	),
+}
+
+// 添加从库任务的参数: ssh 连接参数和实例参数
+type pgsqlAddSlave struct {
+	SSH     dbup.SSHConfig
+	Prepare dbup.PgsqlPrepare
+	Master  string
+}
+
+type mongodbAddSlave struct {
+	SSH     dbup.SSHConfig
+	Options dbup.MongodbOptions
+}
+
+type mariadbAddSlave struct {
+	Server  dbup.MariadbServer
+	Options dbup.MariadbOptions
+}
+
+// JobTypes 支持的任务类型
+func JobTypes() []string {
+	types := make([]string, 0, len(jobTypes))
+	for t := range jobTypes {
+		types = append(types, t)
+	}
+	sort.Strings(types)
+	return types
+}
@@ -0,0 +1,338 @@
+package server
+
+// dbup serve: 以 HTTP API 的方式提交安装、部署、备份、添加从库和升级任务.
+//
+//	GET  /v1/job-types               支持的任务类型
+//	POST /v1/jobs                    提交任务, 请求体: {"type": "pgsql.install", "params": {...}}
+//	GET  /v1/jobs                    任务列表
+//	GET  /v1/jobs/{id}               任务状态
+//	GET  /v1/jobs/{id}/logs          任务的提示信息, 每行一个 json 对象; follow=true 时持续输出直到任务结束
+//	POST /v1/jobs/{id}/cancel        取消任务
+//
+// params 的字段与对应命令使用的参数结构体相同, 部署任务的 params 与部署配置文件的结构相同.
+// 认证方式为静态 token(Authorization: Bearer <token>) 或 mTLS 客户端证书, 至少需要指定一种
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"dbup/internal/output"
+	"dbup/internal/utils/logger"
+	"dbup/pkg/dbup"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 请求体的最大长度
+const maxBodySize = 1 << 20
+
+// CodeUnauthenticated 没有通过认证
+const CodeUnauthenticated = "UNAUTHENTICATED"
+
+// Config 服务参数
+type Config struct {
+	Listen   string
+	Token    string
+	CertFile string
+	KeyFile  string
+	ClientCA string
+}
+
+// Validator 检查认证参数
+func (c Config) Validator() error {
+	if c.Token == "" && c.ClientCA == "" {
+		return output.Errorf(output.CodeInvalidArgument, "必须指定 token 或客户端 CA 证书, 不允许无认证访问")
+	}
+	if (c.CertFile == "") != (c.KeyFile == "") {
+		return output.Errorf(output.CodeInvalidArgument, "证书和私钥必须同时指定")
+	}
+	if c.ClientCA != "" && c.CertFile == "" {
+		return output.Errorf(output.CodeInvalidArgument, "使用客户端证书认证时必须指定服务端证书和私钥")
+	}
+	return nil
+}
+
+// Server 任务服务
+type Server struct {
+	cfg  Config
+	jobs *Manager
+}
+
+// New 生成任务服务
+func New(cfg Config) (*Server, error) {
+	if err := cfg.Validator(); err != nil {
+		return nil, err
+	}
+	return &Server{cfg: cfg, jobs: NewManager()}, nil
+}
+
+// Serve 开始监听并执行任务, ctx 取消后停止服务, 正在执行的任务会被取消
+func (s *Server) Serve(ctx context.Context) error {
+	srv := &http.Server{
+		Addr:              s.cfg.Listen,
+		Handler:           s.Handler(),
+		ReadHeaderTimeout: 30 * time.Second,
+	}
+	if s.cfg.ClientCA != "" {
+		pem, err := ioutil.ReadFile(s.cfg.ClientCA)
+		if err != nil {
+			return fmt.Errorf("读取客户端 CA 证书失败: %v", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("客户端 CA 证书 %s 中没有可用的证书", s.cfg.ClientCA)
+		}
+		srv.TLSConfig = &tls.Config{
+			ClientCAs:  pool,
+			ClientAuth: tls.RequireAndVerifyClientCert,
+			MinVersion: tls.VersionTLS12,
+		}
+	}
+
+	ln, err := net.Listen("tcp", s.cfg.Listen)
+	if err != nil {
+		return err
+	}
+
+	// 提交任务即视为确认; 提示信息记录到正在执行的任务中
+	dbup.SetConfirm(func(question string) (bool, error) { return true, nil })
+	dbup.SetLogger(func(level, message string) {
+		if !s.jobs.Log(level, message) {
+			fmt.Printf("[%s]%s", strings.ToUpper(level), message)
+		}
+	})
+	defer dbup.SetLogger(nil)
+	defer dbup.SetConfirm(nil)
+
+	jobsCtx, stopJobs := context.WithCancel(ctx)
+	jobsDone := make(chan struct{})
+	go func() {
+		s.jobs.Run(jobsCtx)
+		close(jobsDone)
+	}()
+	defer func() {
+		// 正在执行的任务取消后可能还需要回滚, 等待结束后再退出
+		stopJobs()
+		<-jobsDone
+	}()
+
+	errc := make(chan error, 1)
+	go func() {
+		if s.cfg.CertFile != "" {
+			logger.Successf("dbup serve 监听 https://%s\n", ln.Addr())
+			errc <- srv.ServeTLS(ln, s.cfg.CertFile, s.cfg.KeyFile)
+		} else {
+			logger.Warningf("dbup serve 监听 http://%s, 没有使用 TLS, token 以明文传输\n", ln.Addr())
+			errc <- srv.Serve(ln)
+		}
+	}()
+
+	select {
+	case err := <-errc:
+		return err
+	case <-ctx.Done():
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		return srv.Shutdown(shutdownCtx)
+	}
+}
+
+// Handler 返回 API 的 http.Handler
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/job-types", s.handleJobTypes)
+	mux.HandleFunc("/v1/jobs", s.handleJobs)
+	mux.HandleFunc("/v1/jobs/", s.handleJob)
+	return s.auth(mux)
+}
+
+func (s *Server) auth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.cfg.Token != "" {
+			token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if subtle.ConstantTimeCompare([]byte(token), []byte(s.cfg.Token)) != 1 {
+				writeError(w, output.Errorf(CodeUnauthenticated, "token 错误或没有指定"))
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (s *Server) handleJobTypes(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeMethodNotAllowed(w)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"types": JobTypes()})
+}
+
+// submitRequest 提交任务的请求体
+type submitRequest struct {
+	Type   string          `json:"type"`
+	Params json.RawMessage `json:"params"`
+}
+
+func (s *Server) handleJobs(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, http.StatusOK, map[string]interface{}{"jobs": s.jobs.List()})
+	case http.MethodPost:
+		job, err := s.submit(w, r)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		w.Header().Set("Location", "/v1/jobs/"+job.ID)
+		writeJSON(w, http.StatusAccepted, job)
+	default:
+		writeMethodNotAllowed(w)
+	}
+}
+
+func (s *Server) submit(w http.ResponseWriter, r *http.Request) (Job, error) {
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxBodySize))
+	if err != nil {
+		return Job{}, output.Errorf(output.CodeInvalidArgument, "读取请求失败: %v", err)
+	}
+	var req submitRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		return Job{}, output.Errorf(output.CodeInvalidArgument, "请求格式错误: %v", err)
+	}
+	t, ok := jobTypes[req.Type]
+	if !ok {
+		return Job{}, output.Errorf(output.CodeInvalidArgument, "不支持的任务类型 %q, 支持: %s", req.Type, strings.Join(JobTypes(), ", "))
+	}
+
+	params := t.params()
+	if len(req.Params) > 0 {
+		dec := json.NewDecoder(bytes.NewReader(req.Params))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(params); err != nil {
+			return Job{}, output.Errorf(output.CodeInvalidArgument, "任务参数错误: %v", err)
+		}
+	}
+	if t.check != nil {
+		if err := t.check(params); err != nil {
+			return Job{}, output.Errorf(output.CodeInvalidArgument, "任务参数错误: %v", err)
+		}
+	}
+	return s.jobs.Submit(req.Type, func(ctx context.Context) error {
+		return t.run(ctx, params)
+	})
+}
+
+// handleJob /v1/jobs/{id}, /v1/jobs/{id}/logs, /v1/jobs/{id}/cancel
+func (s *Server) handleJob(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/v1/jobs/"), "/")
+	id := parts[0]
+	action := ""
+	if len(parts) == 2 {
+		action = parts[1]
+	}
+	if id == "" || len(parts) > 2 {
+		writeError(w, output.Errorf(output.CodeNotFound, "%s 不存在", r.URL.Path))
+		return
+	}
+
+	switch {
+	case action == "" && r.Method == http.MethodGet:
+		job, err := s.jobs.Get(id)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, job)
+	case action == "logs" && r.Method == http.MethodGet:
+		s.streamLogs(w, r, id)
+	case action == "cancel" && r.Method == http.MethodPost:
+		job, err := s.jobs.Cancel(id)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusAccepted, job)
+	case action == "" || action == "logs" || action == "cancel":
+		writeMethodNotAllowed(w)
+	default:
+		writeError(w, output.Errorf(output.CodeNotFound, "%s 不存在", r.URL.Path))
+	}
+}
+
+// streamLogs 输出任务的提示信息, 每行一个 json 对象. from 跳过前面的行数, follow 持续输出直到任务结束
+func (s *Server) streamLogs(w http.ResponseWriter, r *http.Request, id string) {
+	from, _ := strconv.Atoi(r.URL.Query().Get("from"))
+	follow, _ := strconv.ParseBool(r.URL.Query().Get("follow"))
+	if from < 0 {
+		from = 0
+	}
+
+	lines, finished, changed, err := s.jobs.Logs(id, from)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.WriteHeader(http.StatusOK)
+	flusher, _ := w.(http.Flusher)
+	enc := json.NewEncoder(w)
+	for {
+		for _, l := range lines {
+			if err := enc.Encode(l); err != nil {
+				return
+			}
+		}
+		from += len(lines)
+		if flusher != nil {
+			flusher.Flush()
+		}
+		if !follow || finished {
+			return
+		}
+		select {
+		case <-r.Context().Done():
+			return
+		case <-changed:
+		}
+		if lines, finished, changed, err = s.jobs.Logs(id, from); err != nil {
+			// 任务已经被清理
+			return
+		}
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	_ = enc.Encode(v)
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	code := output.Code(err)
+	status := http.StatusInternalServerError
+	switch code {
+	case output.CodeInvalidArgument:
+		status = http.StatusBadRequest
+	case output.CodeNotFound:
+		status = http.StatusNotFound
+	case output.CodeConflict:
+		status = http.StatusConflict
+	case CodeUnauthenticated:
+		status = http.StatusUnauthorized
+	}
+	writeJSON(w, status, &output.Error{Code: code, Message: err.Error()})
+}
+
+func writeMethodNotAllowed(w http.ResponseWriter) {
+	writeJSON(w, http.StatusMethodNotAllowed, &output.Error{Code: output.CodeInvalidArgument, Message: "不支持的请求方法"})
+}
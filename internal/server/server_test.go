@@ -0,0 +1,109 @@
+package server
+
+import (
+	"context"
+	"dbup/internal/output"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHandler(t *testing.T) {
+	s, err := New(Config{Token: "secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := s.Handler()
+	do := func(method, path, token, body string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, path, strings.NewReader(body))
+		if token != "" {
+			r.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w
+	}
+
+	if w := do("GET", "/v1/jobs", "wrong", ""); w.Code != http.StatusUnauthorized {
+		t.Fatalf("token 错误时状态码为 %d", w.Code)
+	}
+	if w := do("POST", "/v1/jobs", "secret", `{"type":"pgsql.drop"}`); w.Code != http.StatusBadRequest {
+		t.Fatalf("不支持的任务类型状态码为 %d", w.Code)
+	}
+	if w := do("POST", "/v1/jobs", "secret", `{"type":"pgsql.backup","params":{"NoSuchField":1}}`); w.Code != http.StatusBadRequest {
+		t.Fatalf("未知参数状态码为 %d", w.Code)
+	}
+	if w := do("POST", "/v1/jobs", "secret", `{"type":"pgsql.backup","params":{"BackupCmd":"touch /tmp/x;"}}`); w.Code != http.StatusBadRequest {
+		t.Fatalf("指定备份命令状态码为 %d", w.Code)
+	}
+
+	// 没有调用 Run, 任务一直在排队
+	w := do("POST", "/v1/jobs", "secret", `{"type":"pgsql.backup","params":{"Port":5433}}`)
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("提交任务状态码为 %d: %s", w.Code, w.Body)
+	}
+	var job Job
+	if err := json.Unmarshal(w.Body.Bytes(), &job); err != nil {
+		t.Fatal(err)
+	}
+	if job.Status != StatusQueued {
+		t.Fatalf("任务状态为 %s", job.Status)
+	}
+	if w := do("POST", "/v1/jobs/"+job.ID+"/cancel", "secret", ""); w.Code != http.StatusAccepted {
+		t.Fatalf("取消任务状态码为 %d", w.Code)
+	}
+	if w := do("POST", "/v1/jobs/"+job.ID+"/cancel", "secret", ""); w.Code != http.StatusConflict {
+		t.Fatalf("重复取消状态码为 %d", w.Code)
+	}
+	if w := do("GET", "/v1/jobs/nope", "secret", ""); w.Code != http.StatusNotFound {
+		t.Fatalf("任务不存在时状态码为 %d", w.Code)
+	}
+}
+
+func TestManager(t *testing.T) {
+	m := NewManager()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go m.Run(ctx)
+
+	started := make(chan struct{})
+	job, err := m.Submit("test", func(ctx context.Context) error {
+		m.Log("info", "开始\n")
+		close(started)
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-started
+	if _, err := m.Cancel(job.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	deadline := time.After(5 * time.Second)
+	for {
+		lines, finished, changed, err := m.Logs(job.ID, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if finished {
+			if len(lines) != 1 || lines[0].Message != "开始" {
+				t.Fatalf("提示信息不正确: %v", lines)
+			}
+			break
+		}
+		select {
+		case <-changed:
+		case <-deadline:
+			t.Fatal("任务取消后没有结束")
+		}
+	}
+	j, _ := m.Get(job.ID)
+	if j.Status != StatusCanceled || j.Error == nil || j.Error.Code != output.CodeCanceled {
+		t.Fatalf("任务状态不正确: %+v", j)
+	}
+}
@@ -0,0 +1,176 @@
+package tune
+
+import (
+	mariadbconfig "dbup/internal/mariadb/config"
+	mongoconfig "dbup/internal/mongodb/config"
+	pgconfig "dbup/internal/pgsql/config"
+	redisconfig "dbup/internal/redis/config"
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Sysctl 一项内核参数
+type Sysctl struct {
+	Key   string
+	Value string
+}
+
+// Profile 引擎的操作系统调优配置
+type Profile struct {
+	Engine      string
+	Sysctl      []Sysctl
+	DisableTHP  bool   // 关闭透明大页
+	HugePages   bool   // 按 shared_buffers 预留大页
+	NoFile      int    // 文件句柄数, 写入 limits.d 和 systemd LimitNOFILE
+	NProc       int    // 进程数, 写入 limits.d 和 systemd LimitNPROC
+	ServiceName string // 引擎的 systemd 启动文件名格式, 参数为端口
+}
+
+// Profiles 内置的各引擎调优配置
+var Profiles = map[string]Profile{
+	"pgsql": {
+		Sysctl: []Sysctl{
+			{Key: "vm.swappiness", Value: "1"},
+			{Key: "kernel.sem", Value: "250 512000 100 2048"},
+			{Key: "vm.dirty_background_ratio", Value: "5"},
+			{Key: "vm.dirty_ratio", Value: "10"},
+		},
+		DisableTHP:  true,
+		HugePages:   true,
+		NoFile:      65535,
+		NProc:       65535,
+		ServiceName: pgconfig.ServiceFileName,
+	},
+	"redis": {
+		Sysctl: []Sysctl{
+			{Key: "vm.overcommit_memory", Value: "1"},
+			{Key: "vm.swappiness", Value: "1"},
+			{Key: "net.core.somaxconn", Value: "65535"},
+			{Key: "net.ipv4.tcp_max_syn_backlog", Value: "65535"},
+		},
+		DisableTHP:  true,
+		NoFile:      65535,
+		NProc:       65535,
+		ServiceName: redisconfig.ServiceFileName,
+	},
+	"mariadb": {
+		Sysctl: []Sysctl{
+			{Key: "vm.swappiness", Value: "1"},
+			{Key: "fs.aio-max-nr", Value: "1048576"},
+			{Key: "net.core.somaxconn", Value: "65535"},
+		},
+		NoFile:      65535,
+		NProc:       65535,
+		ServiceName: mariadbconfig.ServiceFileName,
+	},
+	"mongodb": {
+		Sysctl: []Sysctl{
+			{Key: "vm.swappiness", Value: "1"},
+			{Key: "vm.max_map_count", Value: "262144"},
+			{Key: "net.core.somaxconn", Value: "65535"},
+		},
+		DisableTHP:  true,
+		NoFile:      64000,
+		NProc:       64000,
+		ServiceName: mongoconfig.ServiceFileName,
+	},
+}
+
+// Engines 支持调优的引擎
+func Engines() []string {
+	var engines []string
+	for e := range Profiles {
+		engines = append(engines, e)
+	}
+	sort.Strings(engines)
+	return engines
+}
+
+// Lookup 返回引擎的调优配置
+func Lookup(engine string) (*Profile, error) {
+	p, ok := Profiles[engine]
+	if !ok {
+		return nil, fmt.Errorf("不支持的引擎: %s, 支持: %s", engine, strings.Join(Engines(), ", "))
+	}
+	p.Engine = engine
+	return &p, nil
+}
+
+// SysctlFile 渲染 sysctl.d 配置文件
+func (p *Profile) SysctlFile(entries []Sysctl) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "# 由 dbup tune apply --engine %s 生成, dbup tune revert --engine %s 时删除\n", p.Engine, p.Engine)
+	for _, s := range entries {
+		fmt.Fprintf(&b, "%s = %s\n", s.Key, s.Value)
+	}
+	return b.String()
+}
+
+// LimitsFile 渲染 limits.d 配置文件, 对不经过 systemd 启动的进程(如手动启动、登录会话)生效
+func (p *Profile) LimitsFile() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "# 由 dbup tune apply --engine %s 生成, dbup tune revert --engine %s 时删除\n", p.Engine, p.Engine)
+	for _, l := range []struct {
+		item  string
+		value int
+	}{{"nofile", p.NoFile}, {"nproc", p.NProc}} {
+		fmt.Fprintf(&b, "*    soft    %s    %d\n", l.item, l.value)
+		fmt.Fprintf(&b, "*    hard    %s    %d\n", l.item, l.value)
+	}
+	return b.String()
+}
+
+// DropInFile 渲染 systemd 服务的 drop-in 配置文件
+func (p *Profile) DropInFile() string {
+	return fmt.Sprintf("# 由 dbup tune apply --engine %s 生成, dbup tune revert --engine %s 时删除\n[Service]\nLimitNOFILE=%d\nLimitNPROC=%d\n",
+		p.Engine, p.Engine, p.NoFile, p.NProc)
+}
+
+// ServiceGlob 匹配引擎所有实例启动文件的通配符
+func (p *Profile) ServiceGlob() string {
+	return strings.Replace(p.ServiceName, "%d", "*", 1)
+}
+
+// THPServiceFile 开机时关闭透明大页的 systemd 启动文件
+func THPServiceFile() string {
+	return fmt.Sprintf(`# 由 dbup tune apply 生成, 所有引擎都 revert 后删除
+[Unit]
+Description=Disable Transparent Huge Pages (dbup)
+DefaultDependencies=no
+After=sysinit.target local-fs.target
+Before=basic.target
+
+[Service]
+Type=oneshot
+ExecStart=/bin/sh -c 'echo never > %s; echo never > %s'
+
+[Install]
+WantedBy=basic.target
+`, THPEnabledFile, THPDefragFile)
+}
+
+var memorySizeRegexp = regexp.MustCompile(`^([0-9.]+)\s*([MGmg])[Bb]?$`)
+
+// HugePages 根据 shared_buffers 的大小(如 8GB, 512MB)计算需要预留的大页数量, 多预留 10% 给其他共享内存
+func HugePages(memorySize string, pageKB int) (int, error) {
+	m := memorySizeRegexp.FindStringSubmatch(strings.TrimSpace(memorySize))
+	if m == nil {
+		return 0, fmt.Errorf("内存参数必须包含单位后缀(MB 或 GB): %s", memorySize)
+	}
+	size, err := strconv.ParseFloat(m[1], 64)
+	if err != nil {
+		return 0, fmt.Errorf("内存参数格式错误: %s", memorySize)
+	}
+	kb := size * 1024
+	if strings.ToUpper(m[2]) == "G" {
+		kb *= 1024
+	}
+	if pageKB <= 0 {
+		return 0, fmt.Errorf("大页大小错误: %d kB", pageKB)
+	}
+	return int(math.Ceil(kb * 1.1 / float64(pageKB))), nil
+}
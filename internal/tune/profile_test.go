@@ -0,0 +1,50 @@
+package tune
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestHugePages(t *testing.T) {
+	for _, c := range []struct {
+		size string
+		want int
+	}{
+		{"8GB", 4506},
+		{"512MB", 282},
+		{"1g", 564},
+	} {
+		n, err := HugePages(c.size, 2048)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != c.want {
+			t.Fatalf("%s 的大页数量为 %d, 应为 %d", c.size, n, c.want)
+		}
+	}
+	if _, err := HugePages("8", 2048); err == nil {
+		t.Fatal("没有单位后缀时没有报错")
+	}
+}
+
+func TestProfileFiles(t *testing.T) {
+	p, err := Lookup("redis")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(p.SysctlFile(p.Sysctl), "vm.overcommit_memory = 1\n") {
+		t.Fatalf("redis 没有设置 vm.overcommit_memory:\n%s", p.SysctlFile(p.Sysctl))
+	}
+	if !strings.Contains(p.LimitsFile(), "*    hard    nofile    65535\n") {
+		t.Fatalf("limits.d 配置不正确:\n%s", p.LimitsFile())
+	}
+	if !strings.Contains(p.DropInFile(), "[Service]\nLimitNOFILE=65535\n") {
+		t.Fatalf("drop-in 配置不正确:\n%s", p.DropInFile())
+	}
+	if p.ServiceGlob() != "redis*.service" {
+		t.Fatalf("启动文件通配符不正确: %s", p.ServiceGlob())
+	}
+	if _, err := Lookup("oracle"); err == nil {
+		t.Fatal("不支持的引擎没有报错")
+	}
+}
@@ -0,0 +1,415 @@
+package tune
+
+// 按引擎调优操作系统参数: sysctl, 透明大页, limits.d 和 systemd LimitNOFILE.
+// 所有修改都写入配置文件, 重启后仍然生效; 修改前的值记录在状态文件中, revert 时删除生成的文件并恢复原来的值.
+// 多个引擎修改同一个参数时都记录 dbup 修改之前的值, 最后一个 revert 的引擎恢复它
+
+import (
+	"dbup/internal/environment"
+	"dbup/internal/global"
+	"dbup/internal/utils/command"
+	"dbup/internal/utils/logger"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 生成的配置文件位置
+var (
+	SysctlDir      = "/etc/sysctl.d"
+	LimitsDir      = "/etc/security/limits.d"
+	DropInDir      = "/etc/systemd/system"
+	THPEnabledFile = "/sys/kernel/mm/transparent_hugepage/enabled"
+	THPDefragFile  = "/sys/kernel/mm/transparent_hugepage/defrag"
+)
+
+const (
+	// THPServiceName 开机关闭透明大页的启动文件名
+	THPServiceName = "dbup-disable-thp.service"
+	// DropInFileName 服务 drop-in 目录中的文件名
+	DropInFileName = "99-dbup-tune.conf"
+	// 默认的大页大小
+	defaultHugePageKB = 2048
+)
+
+// Options 调优参数
+type Options struct {
+	Engine     string
+	Services   []string // 写入 LimitNOFILE 的服务, 为空时使用本机上该引擎的所有实例
+	MemorySize string   // pgsql 的 shared_buffers, 用于计算预留的大页数量, 为空时不预留
+}
+
+// State 调优记录, 保存修改前的值和生成的文件
+type State struct {
+	Engine    string            `json:"engine"`
+	AppliedAt time.Time         `json:"applied_at"`
+	Sysctl    map[string]string `json:"sysctl"`               // 修改前的内核参数
+	THP       map[string]string `json:"thp"`                  // 修改前的透明大页设置
+	Files     []string          `json:"files"`                // 生成的文件
+	HugePages map[string]int    `json:"huge_pages,omitempty"` // 每个服务预留的大页数量, 手动指定且没有服务时 key 为空
+}
+
+// StateDir 调优记录目录
+func StateDir() string {
+	return filepath.Join(environment.GlobalEnv().DbupInfoPath, "tune")
+}
+
+func stateFile(engine string) string {
+	return filepath.Join(StateDir(), engine+".json")
+}
+
+// LoadState 读取引擎的调优记录, 没有调优过时返回 nil
+func LoadState(engine string) (*State, error) {
+	data, err := ioutil.ReadFile(stateFile(engine))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("读取调优记录失败: %v", err)
+	}
+	s := new(State)
+	if err := json.Unmarshal(data, s); err != nil {
+		return nil, fmt.Errorf("解析调优记录 %s 失败: %v", stateFile(engine), err)
+	}
+	return s, nil
+}
+
+func (s *State) save() error {
+	if err := os.MkdirAll(StateDir(), 0700); err != nil {
+		return fmt.Errorf("创建目录 %s 失败: %v", StateDir(), err)
+	}
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(stateFile(s.Engine), data, 0600)
+}
+
+func (s *State) addFile(name string) {
+	for _, f := range s.Files {
+		if f == name {
+			return
+		}
+	}
+	s.Files = append(s.Files, name)
+}
+
+// others 其他已调优引擎的记录
+func others(engine string) []*State {
+	var states []*State
+	for _, e := range Engines() {
+		if e == engine {
+			continue
+		}
+		if s, err := LoadState(e); err == nil && s != nil {
+			states = append(states, s)
+		}
+	}
+	return states
+}
+
+// original 其他引擎已经记录的 dbup 修改之前的值, 没有时返回 false
+func original(engine string, values func(s *State) map[string]string, key string) (string, bool) {
+	for _, o := range others(engine) {
+		if v, ok := values(o)[key]; ok {
+			return v, true
+		}
+	}
+	return "", false
+}
+
+// reservedHugePages 更新预留大页的记录并返回本机所有 pgsql 实例需要的大页总数, 已经删除的服务不再计算
+func reservedHugePages(o Options, s *State) (int, error) {
+	if s.HugePages == nil {
+		s.HugePages = make(map[string]int)
+	}
+	if o.MemorySize != "" {
+		n, err := HugePages(o.MemorySize, hugePageKB())
+		if err != nil {
+			return 0, err
+		}
+		if len(o.Services) == 0 {
+			s.HugePages[""] = n
+		}
+		for _, service := range o.Services {
+			s.HugePages[service] = n
+		}
+	}
+	total := 0
+	for service, n := range s.HugePages {
+		if service != "" && !command.IsExists(filepath.Join(global.ServicePath, service)) {
+			logger.Infof("服务 %s 已不存在, 不再为它预留大页\n", service)
+			delete(s.HugePages, service)
+			continue
+		}
+		total += n
+	}
+	return total, nil
+}
+
+// Apply 按引擎的内置配置调优本机, 重复执行时保留第一次记录的原始值
+func Apply(o Options) (*State, error) {
+	p, err := Lookup(o.Engine)
+	if err != nil {
+		return nil, err
+	}
+	s, err := LoadState(p.Engine)
+	if err != nil {
+		return nil, err
+	}
+	if s == nil {
+		s = &State{Engine: p.Engine, Sysctl: make(map[string]string), THP: make(map[string]string)}
+	}
+	s.AppliedAt = time.Now()
+
+	if err := applySysctl(p, o, s); err != nil {
+		return s, err
+	}
+	if p.DisableTHP {
+		if err := applyTHP(s); err != nil {
+			return s, err
+		}
+	}
+	if err := applyLimits(p, o, s); err != nil {
+		return s, err
+	}
+	return s, s.save()
+}
+
+func applySysctl(p *Profile, o Options, s *State) error {
+	entries := append([]Sysctl{}, p.Sysctl...)
+	if p.HugePages {
+		n, err := reservedHugePages(o, s)
+		if err != nil {
+			return err
+		}
+		if n > 0 {
+			logger.Infof("本机 %s 实例共预留大页: %d\n", p.Engine, n)
+			entries = append(entries, Sysctl{Key: "vm.nr_hugepages", Value: strconv.Itoa(n)})
+		}
+	}
+
+	sysctl := func(st *State) map[string]string { return st.Sysctl }
+	for _, e := range entries {
+		if _, ok := s.Sysctl[e.Key]; ok {
+			continue
+		}
+		// 其他引擎已经修改过的参数, 使用它记录的原始值
+		if old, ok := original(p.Engine, sysctl, e.Key); ok {
+			s.Sysctl[e.Key] = old
+			continue
+		}
+		old, err := sysctlValue(e.Key)
+		if err != nil {
+			return err
+		}
+		s.Sysctl[e.Key] = old
+	}
+
+	file := filepath.Join(SysctlDir, fmt.Sprintf("99-dbup-%s.conf", p.Engine))
+	logger.Infof("写入内核参数配置: %s\n", file)
+	if err := ioutil.WriteFile(file, []byte(p.SysctlFile(entries)), 0644); err != nil {
+		return fmt.Errorf("写入 %s 失败: %v", file, err)
+	}
+	s.addFile(file)
+	return runLocal(fmt.Sprintf("sysctl -p %s", file))
+}
+
+func applyTHP(s *State) error {
+	if !command.IsExists(THPEnabledFile) {
+		logger.Warningf("系统不支持透明大页, 跳过\n")
+		return nil
+	}
+	thp := func(st *State) map[string]string { return st.THP }
+	for _, f := range []string{THPEnabledFile, THPDefragFile} {
+		if _, ok := s.THP[f]; ok {
+			continue
+		}
+		if old, ok := original(s.Engine, thp, f); ok {
+			s.THP[f] = old
+			continue
+		}
+		data, err := ioutil.ReadFile(f)
+		if err != nil {
+			return fmt.Errorf("读取 %s 失败: %v", f, err)
+		}
+		s.THP[f] = selected(string(data))
+	}
+
+	file := filepath.Join(global.ServicePath, THPServiceName)
+	logger.Infof("关闭透明大页, 写入开机启动文件: %s\n", file)
+	if err := ioutil.WriteFile(file, []byte(THPServiceFile()), 0644); err != nil {
+		return fmt.Errorf("写入 %s 失败: %v", file, err)
+	}
+	s.addFile(file)
+	if err := command.SystemdReload(); err != nil {
+		return err
+	}
+	if err := command.SystemCtl(THPServiceName, "enable"); err != nil {
+		return err
+	}
+	return command.SystemCtl(THPServiceName, "start")
+}
+
+func applyLimits(p *Profile, o Options, s *State) error {
+	file := filepath.Join(LimitsDir, fmt.Sprintf("99-dbup-%s.conf", p.Engine))
+	logger.Infof("写入资源限制配置: %s\n", file)
+	if err := ioutil.WriteFile(file, []byte(p.LimitsFile()), 0644); err != nil {
+		return fmt.Errorf("写入 %s 失败: %v", file, err)
+	}
+	s.addFile(file)
+
+	services := o.Services
+	if len(services) == 0 {
+		matches, _ := filepath.Glob(filepath.Join(global.ServicePath, p.ServiceGlob()))
+		for _, m := range matches {
+			services = append(services, filepath.Base(m))
+		}
+	}
+	if len(services) == 0 {
+		return nil
+	}
+	for _, service := range services {
+		dir := filepath.Join(DropInDir, service+".d")
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return fmt.Errorf("创建目录 %s 失败: %v", dir, err)
+		}
+		file := filepath.Join(dir, DropInFileName)
+		logger.Infof("设置 %s 的 LimitNOFILE=%d, LimitNPROC=%d, 重启服务后生效\n", service, p.NoFile, p.NProc)
+		if err := ioutil.WriteFile(file, []byte(p.DropInFile()), 0644); err != nil {
+			return fmt.Errorf("写入 %s 失败: %v", file, err)
+		}
+		s.addFile(file)
+	}
+	return command.SystemdReload()
+}
+
+// Revert 删除引擎调优生成的文件, 恢复修改前的值. 其他引擎仍在使用的内核参数和透明大页设置保持不变
+func Revert(engine string) error {
+	if _, err := Lookup(engine); err != nil {
+		return err
+	}
+	s, err := LoadState(engine)
+	if err != nil {
+		return err
+	}
+	if s == nil {
+		return fmt.Errorf("没有找到 %s 的调优记录", engine)
+	}
+
+	inUse := make(map[string]bool)
+	thpInUse := false
+	for _, o := range others(engine) {
+		for key := range o.Sysctl {
+			inUse[key] = true
+		}
+		if len(o.THP) > 0 {
+			thpInUse = true
+		}
+	}
+
+	thpService := filepath.Join(global.ServicePath, THPServiceName)
+	if !thpInUse && len(s.THP) > 0 {
+		if err := command.SystemCtl(THPServiceName, "disable"); err != nil {
+			logger.Warningf("%v\n", err)
+		}
+	}
+	for _, f := range s.Files {
+		if f == thpService && thpInUse {
+			continue
+		}
+		logger.Infof("删除: %s\n", f)
+		if err := os.Remove(f); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("删除 %s 失败: %v", f, err)
+		}
+	}
+
+	keys := make([]string, 0, len(s.Sysctl))
+	for key := range s.Sysctl {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if inUse[key] {
+			logger.Warningf("%s 仍被其他引擎的调优使用, 不恢复\n", key)
+			continue
+		}
+		logger.Infof("恢复 %s = %s\n", key, s.Sysctl[key])
+		if err := runLocal(fmt.Sprintf("sysctl -w '%s=%s'", key, s.Sysctl[key])); err != nil {
+			return err
+		}
+	}
+
+	if !thpInUse {
+		for f, mode := range s.THP {
+			if mode == "" {
+				continue
+			}
+			logger.Infof("恢复透明大页设置 %s: %s\n", f, mode)
+			if err := ioutil.WriteFile(f, []byte(mode), 0644); err != nil {
+				return fmt.Errorf("写入 %s 失败: %v", f, err)
+			}
+		}
+	}
+
+	if err := command.SystemdReload(); err != nil {
+		return err
+	}
+	return os.Remove(stateFile(engine))
+}
+
+// sysctlValue 读取内核参数的当前值, 多个值之间用空格分隔
+func sysctlValue(key string) (string, error) {
+	l := command.Local{Timeout: 30}
+	stdout, stderr, err := l.Run(fmt.Sprintf("sysctl -n %s", key))
+	if err != nil {
+		return "", fmt.Errorf("读取内核参数 %s 失败: %v, 标准错误: %s", key, err, stderr)
+	}
+	return strings.Join(strings.Fields(string(stdout)), " "), nil
+}
+
+// hugePageKB 读取 /proc/meminfo 中的大页大小
+func hugePageKB() int {
+	data, err := ioutil.ReadFile("/proc/meminfo")
+	if err != nil {
+		return defaultHugePageKB
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		if fields := strings.Fields(line); len(fields) >= 2 && fields[0] == "Hugepagesize:" {
+			if n, err := strconv.Atoi(fields[1]); err == nil {
+				return n
+			}
+		}
+	}
+	return defaultHugePageKB
+}
+
+// selected 返回 "always madvise [never]" 格式中选中的值
+func selected(s string) string {
+	start, end := strings.Index(s, "["), strings.Index(s, "]")
+	if start < 0 || end < start {
+		return strings.TrimSpace(s)
+	}
+	return s[start+1 : end]
+}
+
+func runLocal(cmd string) error {
+	l := command.Local{Timeout: 60}
+	if _, stderr, err := l.Run(cmd); err != nil {
+		return fmt.Errorf("执行(%s)失败: %v, 标准错误输出: %s", cmd, err, stderr)
+	}
+	return nil
+}
+
+// ApplyService 安装流程中的调优步骤, 同时为新安装的服务设置 LimitNOFILE, 需要在启动服务之前执行
+func ApplyService(engine, service, memorySize string) error {
+	logger.Infof("按 %s 的内置配置调优操作系统\n", engine)
+	_, err := Apply(Options{Engine: engine, Services: []string{service}, MemorySize: memorySize})
+	return err
+}
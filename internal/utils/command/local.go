@@ -10,6 +10,7 @@ import (
 	"context"
 	"dbup/internal/utils"
 	"fmt"
+	"os"
 	"os/exec"
 	"time"
 )
@@ -17,8 +18,9 @@ import (
 // Local execute the command at local host.
 type Local struct {
 	Timeout int
-	User    string // sudo 用户名。 默认为空时, 执行sudo不传-u参数, 以默认root执行
-	Locale  string // the locale used when executing the command
+	User    string   // sudo 用户名。 默认为空时, 执行sudo不传-u参数, 以默认root执行
+	Locale  string   // the locale used when executing the command
+	Env     []string // 追加的环境变量, 用于传递密码等不能出现在进程列表中的参数
 }
 
 func (l *Local) Run(cmd string) ([]byte, []byte, error) {
@@ -39,6 +41,9 @@ func (l *Local) Run(cmd string) ([]byte, []byte, error) {
 	defer cancel()
 
 	command := exec.CommandContext(ctx, "/bin/sh", "-c", cmd)
+	if len(l.Env) > 0 {
+		command.Env = append(os.Environ(), l.Env...)
+	}
 
 	stdout := new(bytes.Buffer)
 	stderr := new(bytes.Buffer)
@@ -8,6 +8,8 @@ package command
 import (
 	"bufio"
 	"dbup/internal/utils"
+	"dbup/internal/utils/secretfile"
+	"dbup/internal/utils/sshutil"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -15,6 +17,7 @@ import (
 	"os"
 	"path"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -30,31 +33,32 @@ type Connection struct {
 	Password     string
 	KeyFile      string
 	SudoPassword string
+	proxied      bool
 	auth         []ssh.AuthMethod
 	clientConfig *ssh.ClientConfig
 	sshClient    *ssh.Client
 	*sftp.Client
 }
 
-func NewConnection(host, user, password string, port int, timeout int64) (*Connection, error) {
+func NewConnection(host, user, password string, port int, timeout int64, opts ...sshutil.Options) (*Connection, error) {
 	// fmt.Println(password)
 	// fmt.Println(len(password))
 	var err error
 	conn := &Connection{Host: host, Port: port, User: user, Password: password}
-	conn.auth = make([]ssh.AuthMethod, 0)
-	conn.auth = append(conn.auth, ssh.Password(password))
-	conn.clientConfig = &ssh.ClientConfig{
-		User:            user,
-		Auth:            conn.auth,
-		HostKeyCallback: ssh.HostKeyCallback(func(hostname string, remote net.Addr, key ssh.PublicKey) error { return nil }),
-		Timeout:         time.Duration(timeout) * time.Second,
+	opt := sshutil.FirstOptions(opts)
+	if conn.auth, err = opt.AuthMethods(password, ""); err != nil {
+		return nil, err
+	}
+	if conn.clientConfig, err = opt.ClientConfig(user, conn.auth, timeout); err != nil {
+		return nil, err
 	}
 
-	address := fmt.Sprintf("%s:%d", conn.Host, conn.Port)
+	address := net.JoinHostPort(conn.Host, strconv.Itoa(conn.Port))
 
-	if conn.sshClient, err = ssh.Dial("tcp", address, conn.clientConfig); err != nil {
+	if conn.sshClient, err = opt.Dial(address, conn.clientConfig); err != nil {
 		return nil, err
 	}
+	conn.proxied = opt.Proxied()
 
 	if conn.Client, err = sftp.NewClient(conn.sshClient); err != nil {
 		return nil, err
@@ -63,31 +67,23 @@ func NewConnection(host, user, password string, port int, timeout int64) (*Conne
 	return conn, nil
 }
 
-func NewConnectionUseKeyFile(host, user, keyfile string, port int, timeout int64) (*Connection, error) {
-	conn := &Connection{Host: host, Port: port, User: user, KeyFile: keyfile}
-	key, err := ioutil.ReadFile(keyfile)
-	if err != nil {
+func NewConnectionUseKeyFile(host, user, keyfile string, port int, timeout int64, opts ...sshutil.Options) (*Connection, error) {
+	var err error
+	opt := sshutil.FirstOptions(opts)
+	conn := &Connection{Host: host, Port: port, User: user, Password: opt.Password, KeyFile: keyfile}
+	if conn.auth, err = opt.AuthMethods("", keyfile); err != nil {
 		return nil, err
 	}
-	signer, err := ssh.ParsePrivateKey(key)
-	if err != nil {
+	if conn.clientConfig, err = opt.ClientConfig(user, conn.auth, timeout); err != nil {
 		return nil, err
 	}
 
-	conn.auth = make([]ssh.AuthMethod, 0)
-	conn.auth = append(conn.auth, ssh.PublicKeys(signer))
-	conn.clientConfig = &ssh.ClientConfig{
-		User:            user,
-		Auth:            conn.auth,
-		HostKeyCallback: ssh.HostKeyCallback(func(hostname string, remote net.Addr, key ssh.PublicKey) error { return nil }),
-		Timeout:         time.Duration(timeout) * time.Second,
-	}
-
-	address := fmt.Sprintf("%s:%d", conn.Host, conn.Port)
+	address := net.JoinHostPort(conn.Host, strconv.Itoa(conn.Port))
 
-	if conn.sshClient, err = ssh.Dial("tcp", address, conn.clientConfig); err != nil {
+	if conn.sshClient, err = opt.Dial(address, conn.clientConfig); err != nil {
 		return nil, err
 	}
+	conn.proxied = opt.Proxied()
 
 	if conn.Client, err = sftp.NewClient(conn.sshClient); err != nil {
 		return nil, err
@@ -96,6 +92,83 @@ func NewConnectionUseKeyFile(host, user, keyfile string, port int, timeout int64
 	return conn, nil
 }
 
+// Close 关闭 sftp 和 ssh 连接, 经跳板机连接时跳板机的连接随之关闭
+func (conn *Connection) Close() error {
+	conn.Client.Close()
+	return conn.sshClient.Close()
+}
+
+// ReadFile 读取远程文件的全部内容. proc 文件的大小为 0, 不能使用按文件大小读取的 WriteTo, 这里按流读取
+func (conn *Connection) ReadFile(name string) ([]byte, error) {
+	f, err := conn.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ioutil.ReadAll(f)
+}
+
+// DialAddress 返回控制机访问远程机器上 port 端口时应该使用的地址.
+// 经跳板机连接时, 控制机无法直连, 通过 ssh 隧道转发到本地端口; 使用完毕后需要调用返回的 close 函数
+func (conn *Connection) DialAddress(port int) (string, int, func(), error) {
+	return conn.DialHost(conn.Host, port)
+}
+
+// DialHost 与 DialAddress 相同, 用于访问远程机器可以访问到的其他机器, 例如从库所在机器访问主库.
+// 经跳板机连接时, 由远程机器通过 ssh 隧道转发
+func (conn *Connection) DialHost(host string, port int) (string, int, func(), error) {
+	if !conn.proxied {
+		return host, port, func() {}, nil
+	}
+	listener, err := sshutil.Forward(conn.sshClient, net.JoinHostPort(host, strconv.Itoa(port)))
+	if err != nil {
+		return "", 0, nil, fmt.Errorf("建立到 %s:%d 的 ssh 隧道失败: %v", host, port, err)
+	}
+	addr := listener.Addr().(*net.TCPAddr)
+	return addr.IP.String(), addr.Port, func() { listener.Close() }, nil
+}
+
+// WriteSecrets 将密码写入远程机器上只有 root 可读的临时文件, 返回文件路径, 供远程 dbup 通过 --password-file 读取.
+// 使用完毕后需要调用返回的 clean 函数删除文件
+func (conn *Connection) WriteSecrets(secrets map[string]string) (string, func(), error) {
+	b, err := secretfile.Marshal(secrets)
+	if err != nil {
+		return "", nil, err
+	}
+	return conn.WriteSecretFile(b, "root")
+}
+
+// WriteSecretFile 将 content 写入远程机器上权限为 0600、属主为 owner 的临时文件, 用于 .pgpass 等需要原始内容的密码文件.
+// 使用完毕后需要调用返回的 clean 函数删除文件
+func (conn *Connection) WriteSecretFile(content []byte, owner string) (string, func(), error) {
+	file := fmt.Sprintf("/tmp/.dbup-secrets-%d", time.Now().UnixNano())
+	clean := func() {
+		conn.Sudo(fmt.Sprintf("rm -f '%s'", file), "", "")
+	}
+
+	// 先创建空文件并修改权限, 再写入内容, 避免写入过程中被其他用户读取
+	f, err := conn.OpenFile(file, os.O_WRONLY|os.O_CREATE|os.O_EXCL)
+	if err != nil {
+		return "", nil, fmt.Errorf("创建密码文件 %s 失败: %v", file, err)
+	}
+	defer f.Close()
+	if err := f.Chmod(0600); err != nil {
+		clean()
+		return "", nil, fmt.Errorf("修改密码文件 %s 权限失败: %v", file, err)
+	}
+	if _, err := f.Write(content); err != nil {
+		clean()
+		return "", nil, fmt.Errorf("写入密码文件 %s 失败: %v", file, err)
+	}
+	if conn.User != owner {
+		if stdout, err := conn.Sudo(fmt.Sprintf("chown %s: '%s'", owner, file), "", ""); err != nil {
+			clean()
+			return "", nil, fmt.Errorf("修改密码文件 %s 属主失败: %v, 标准输出: %s", file, err, stdout)
+		}
+	}
+	return file, clean, nil
+}
+
 func (conn *Connection) Run(cmd string) ([]byte, error) {
 	cmd = fmt.Sprintf("PATH=$PATH:/usr/bin:/usr/sbin %s", cmd)
 	var in io.WriteCloser
@@ -157,6 +230,16 @@ func (conn *Connection) Sudo(cmd, user, password string) ([]byte, error) {
 	return conn.Run(cmd)
 }
 
+// SudoWithSecrets 以 root 身份执行远程 dbup 命令, secrets 写入临时文件后通过 --password-file 传递, 执行完毕后删除
+func (conn *Connection) SudoWithSecrets(cmd string, secrets map[string]string) ([]byte, error) {
+	file, clean, err := conn.WriteSecrets(secrets)
+	if err != nil {
+		return nil, err
+	}
+	defer clean()
+	return conn.Sudo(fmt.Sprintf("%s --password-file='%s'", cmd, file), "", "")
+}
+
 func (conn *Connection) Scp(source, target string) error {
 	// 如果source是文件, target是文件, 直接调用copy
 	// 如果source是文件, target是目录, target 需要加文件名: path.Join(target, path.Base(source))
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"github.com/rifflock/lfshook"
 	"github.com/sirupsen/logrus"
+	"io"
 	"io/ioutil"
 	"runtime"
 	"strings"
@@ -35,14 +36,34 @@ func SetLogFile(logPath string)  {
 	}
 }
 
+// SetOutput 提示信息的输出位置, 默认为标准输出
+func SetOutput(w io.Writer) {
+	stdHook.out = w
+}
+
 func SwitchLevelShow(b bool)  {
 	stdHook.showLevel = b
 }
 
-// 错误信息
+// 提示信息的级别
+const (
+	LevelInfo    = "info"
+	LevelSuccess = "success"
+	LevelWarning = "warning"
+	LevelError   = "error"
+)
+
+// Handler 接收提示信息的回调, 作为库使用时由调用方决定如何输出
+type Handler func(level, message string)
+
+// SetHandler 提示信息交给 h 处理, 不再输出到标准输出; h 为 nil 时恢复输出. 日志文件不受影响
+func SetHandler(h Handler) {
+	stdHook.handler = h
+}
+
+// 错误信息, 只输出不退出, 由调用方返回错误
 func Errorf(format string, args ...interface{}) {
 	logger.Errorf(format, args...)
-	logger.Exit(1)
 }
 
 // 警告信息
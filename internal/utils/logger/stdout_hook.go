@@ -2,6 +2,7 @@ package logger
 
 import (
 	"fmt"
+	"io"
 	"github.com/fatih/color"
 	"github.com/sirupsen/logrus"
 )
@@ -30,32 +31,46 @@ var labelMap = map[logrus.Level]string{
 	logrus.ErrorLevel: "[ERROR]",
 }
 
+// 交给 Handler 处理时的级别名称
+var levelNames = map[logrus.Level]string{
+	logrus.DebugLevel: LevelInfo,
+	logrus.InfoLevel:  LevelSuccess,
+	logrus.WarnLevel:  LevelWarning,
+	logrus.ErrorLevel: LevelError,
+}
+
 type stdoutHook struct {
 	showLevel bool
+	out       io.Writer
+	handler   Handler
 }
 
 func NewStdoutHook() *stdoutHook {
-	return &stdoutHook{showLevel: true}
+	return &stdoutHook{showLevel: true, out: color.Output}
 }
 
 func (h *stdoutHook) Fire(entry *logrus.Entry) error {
+	if h.handler != nil {
+		h.handler(levelNames[entry.Level], entry.Message)
+		return nil
+	}
 	label,_ := labelMap[entry.Level]
 
 	if c, ok := colorMap[entry.Level]; ok {
 		if h.showLevel {
-			_, _ = c.Printf("%s%s", label, entry.Message)
+			_, _ = c.Fprintf(h.out, "%s%s", label, entry.Message)
 		} else {
 			if entry.Level == logrus.ErrorLevel {
-				_, _ = c.Printf("Error: %s", entry.Message)
+				_, _ = c.Fprintf(h.out, "Error: %s", entry.Message)
 			} else {
-				_, _ = c.Printf("%s", entry.Message)
+				_, _ = c.Fprintf(h.out, "%s", entry.Message)
 			}
 		}
 	} else {
 		if h.showLevel {
-			fmt.Printf("%s%s", label, entry.Message)
+			fmt.Fprintf(h.out, "%s%s", label, entry.Message)
 		} else {
-			fmt.Printf("%s", entry.Message)
+			fmt.Fprintf(h.out, "%s", entry.Message)
 		}
 	}
 	return nil
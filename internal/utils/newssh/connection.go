@@ -8,13 +8,15 @@ package newssh
 import (
 	"bytes"
 	"dbup/internal/utils"
+	"dbup/internal/utils/secretfile"
+	"dbup/internal/utils/sshutil"
 	"fmt"
 	"io"
-	"io/ioutil"
 	"net"
 	"os"
 	"path"
 	"path/filepath"
+	"strconv"
 	"sync"
 	"time"
 
@@ -39,29 +41,30 @@ type Connection struct {
 	User         string
 	Password     string
 	KeyFile      string
+	proxied      bool
 	auth         []ssh.AuthMethod
 	clientConfig *ssh.ClientConfig
 	sshClient    *ssh.Client
 	*sftp.Client
 }
 
-func NewConnection(host, user, password string, port int, timeout int64) (*Connection, error) {
+func NewConnection(host, user, password string, port int, timeout int64, opts ...sshutil.Options) (*Connection, error) {
 	var err error
 	conn := &Connection{Host: host, Port: port, User: user, Password: password}
-	conn.auth = make([]ssh.AuthMethod, 0)
-	conn.auth = append(conn.auth, ssh.Password(password))
-	conn.clientConfig = &ssh.ClientConfig{
-		User:            user,
-		Auth:            conn.auth,
-		HostKeyCallback: ssh.HostKeyCallback(func(hostname string, remote net.Addr, key ssh.PublicKey) error { return nil }),
-		Timeout:         time.Duration(timeout) * time.Second,
+	opt := sshutil.FirstOptions(opts)
+	if conn.auth, err = opt.AuthMethods(password, ""); err != nil {
+		return nil, err
+	}
+	if conn.clientConfig, err = opt.ClientConfig(user, conn.auth, timeout); err != nil {
+		return nil, err
 	}
 
-	address := fmt.Sprintf("%s:%d", conn.Host, conn.Port)
+	address := net.JoinHostPort(conn.Host, strconv.Itoa(conn.Port))
 
-	if conn.sshClient, err = ssh.Dial("tcp", address, conn.clientConfig); err != nil {
+	if conn.sshClient, err = opt.Dial(address, conn.clientConfig); err != nil {
 		return nil, err
 	}
+	conn.proxied = opt.Proxied()
 
 	if conn.Client, err = sftp.NewClient(conn.sshClient); err != nil {
 		return nil, err
@@ -70,31 +73,23 @@ func NewConnection(host, user, password string, port int, timeout int64) (*Conne
 	return conn, nil
 }
 
-func NewConnectionUseKeyFile(host, user, keyfile string, port int, timeout int64) (*Connection, error) {
-	conn := &Connection{Host: host, Port: port, User: user, KeyFile: keyfile}
-	key, err := ioutil.ReadFile(keyfile)
-	if err != nil {
+func NewConnectionUseKeyFile(host, user, keyfile string, port int, timeout int64, opts ...sshutil.Options) (*Connection, error) {
+	var err error
+	opt := sshutil.FirstOptions(opts)
+	conn := &Connection{Host: host, Port: port, User: user, Password: opt.Password, KeyFile: keyfile}
+	if conn.auth, err = opt.AuthMethods("", keyfile); err != nil {
 		return nil, err
 	}
-	signer, err := ssh.ParsePrivateKey(key)
-	if err != nil {
+	if conn.clientConfig, err = opt.ClientConfig(user, conn.auth, timeout); err != nil {
 		return nil, err
 	}
 
-	conn.auth = make([]ssh.AuthMethod, 0)
-	conn.auth = append(conn.auth, ssh.PublicKeys(signer))
-	conn.clientConfig = &ssh.ClientConfig{
-		User:            user,
-		Auth:            conn.auth,
-		HostKeyCallback: ssh.HostKeyCallback(func(hostname string, remote net.Addr, key ssh.PublicKey) error { return nil }),
-		Timeout:         time.Duration(timeout) * time.Second,
-	}
-
-	address := fmt.Sprintf("%s:%d", conn.Host, conn.Port)
+	address := net.JoinHostPort(conn.Host, strconv.Itoa(conn.Port))
 
-	if conn.sshClient, err = ssh.Dial("tcp", address, conn.clientConfig); err != nil {
+	if conn.sshClient, err = opt.Dial(address, conn.clientConfig); err != nil {
 		return nil, err
 	}
+	conn.proxied = opt.Proxied()
 
 	if conn.Client, err = sftp.NewClient(conn.sshClient); err != nil {
 		return nil, err
@@ -103,6 +98,61 @@ func NewConnectionUseKeyFile(host, user, keyfile string, port int, timeout int64
 	return conn, nil
 }
 
+// DialAddress 返回控制机访问远程机器上 port 端口时应该使用的地址.
+// 经跳板机连接时, 控制机无法直连, 通过 ssh 隧道转发到本地端口; 使用完毕后需要调用返回的 close 函数
+func (conn *Connection) DialAddress(port int) (string, int, func(), error) {
+	if !conn.proxied {
+		return conn.Host, port, func() {}, nil
+	}
+	listener, err := sshutil.Forward(conn.sshClient, net.JoinHostPort(conn.Host, strconv.Itoa(port)))
+	if err != nil {
+		return "", 0, nil, fmt.Errorf("建立到 %s:%d 的 ssh 隧道失败: %v", conn.Host, port, err)
+	}
+	addr := listener.Addr().(*net.TCPAddr)
+	return addr.IP.String(), addr.Port, func() { listener.Close() }, nil
+}
+
+// WriteSecrets 将密码写入远程机器上只有 root 可读的临时文件, 返回文件路径, 供远程 dbup 通过 --password-file 读取.
+// 使用完毕后需要调用返回的 clean 函数删除文件
+func (conn *Connection) WriteSecrets(secrets map[string]string) (string, func(), error) {
+	b, err := secretfile.Marshal(secrets)
+	if err != nil {
+		return "", nil, err
+	}
+	return conn.WriteSecretFile(b, "root")
+}
+
+// WriteSecretFile 将 content 写入远程机器上权限为 0600、属主为 owner 的临时文件, 用于只包含密码本身的密码文件.
+// 使用完毕后需要调用返回的 clean 函数删除文件
+func (conn *Connection) WriteSecretFile(content []byte, owner string) (string, func(), error) {
+	file := fmt.Sprintf("/tmp/.dbup-secrets-%d", time.Now().UnixNano())
+	clean := func() {
+		conn.Sudo(fmt.Sprintf("rm -f '%s'", file))
+	}
+
+	// 先创建空文件并修改权限, 再写入内容, 避免写入过程中被其他用户读取
+	f, err := conn.OpenFile(file, os.O_WRONLY|os.O_CREATE|os.O_EXCL)
+	if err != nil {
+		return "", nil, fmt.Errorf("创建密码文件 %s 失败: %v", file, err)
+	}
+	defer f.Close()
+	if err := f.Chmod(0600); err != nil {
+		clean()
+		return "", nil, fmt.Errorf("修改密码文件 %s 权限失败: %v", file, err)
+	}
+	if _, err := f.Write(content); err != nil {
+		clean()
+		return "", nil, fmt.Errorf("写入密码文件 %s 失败: %v", file, err)
+	}
+	if conn.User != owner {
+		if stdout, stderr, err := conn.Sudo(fmt.Sprintf("chown %s: '%s'", owner, file)); err != nil {
+			clean()
+			return "", nil, fmt.Errorf("修改密码文件 %s 属主失败: %v, 标准输出: %s, 标准错误: %s", file, err, stdout, stderr)
+		}
+	}
+	return file, clean, nil
+}
+
 func (conn *Connection) Run(cmd string, opts ...RunOptions) (stdoutByte []byte, stderrByte []byte, err error) {
 	var opt RunOptions
 	var stdin io.WriteCloser
@@ -178,6 +228,16 @@ func (conn *Connection) Sudo(cmd string, opts ...SudoOptions) (stdoutByte []byte
 	return conn.Run(cmd, RunOptions{Watchers: opt.Watchers})
 }
 
+// SudoWithSecrets 执行远程 dbup 命令, secrets 写入临时文件后通过 --password-file 传递, 执行完毕后删除
+func (conn *Connection) SudoWithSecrets(cmd string, secrets map[string]string, opts ...SudoOptions) (stdoutByte []byte, stderrByte []byte, err error) {
+	file, clean, err := conn.WriteSecrets(secrets)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer clean()
+	return conn.Sudo(fmt.Sprintf("%s --password-file='%s'", cmd, file), opts...)
+}
+
 func (conn *Connection) Scp(source, target string) error {
 	// 如果source是文件, target是文件, 直接调用copy
 	// 如果source是文件, target是目录, target 需要加文件名: path.Join(target, path.Base(source))
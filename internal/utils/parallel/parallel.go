@@ -0,0 +1,135 @@
+package parallel
+
+// 集群部署时并发操作多个节点: 临时目录检查, 复制文件, 安装, 回滚卸载等各节点之间互不依赖的步骤.
+// 不同节点的任务并发执行, 同一节点上的多个任务(例如同一台机器上的多个实例)按顺序执行
+
+import (
+	"context"
+	"dbup/internal/utils/logger"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// DefaultLimit 默认同时操作的节点数
+const DefaultLimit = 5
+
+var limit = DefaultLimit
+
+// SetLimit 设置同时操作的节点数, 对应命令行参数 --parallel, 小于 1 时按顺序执行
+func SetLimit(n int) {
+	if n < 1 {
+		n = 1
+	}
+	limit = n
+}
+
+// Limit 同时操作的节点数
+func Limit() int {
+	return limit
+}
+
+// Task 一个节点上的任务
+type Task struct {
+	Host string
+	Run  func() error
+}
+
+// HostError 一个节点上的错误
+type HostError struct {
+	Host string
+	Err  error
+}
+
+func (e *HostError) Error() string {
+	return fmt.Sprintf("%s: %v", e.Host, e.Err)
+}
+
+// Errors 多个节点上的错误
+type Errors []*HostError
+
+func (e Errors) Error() string {
+	var s []string
+	for _, err := range e {
+		s = append(s, err.Error())
+	}
+	return fmt.Sprintf("%d 个节点失败: %s", len(e), strings.Join(s, "; "))
+}
+
+// Run 并发执行任务, 同时最多操作 Limit() 个节点, 每个节点完成或失败时打印进度.
+// 一个节点失败不影响其他节点, 全部结束后返回错误: 只有一个节点失败时返回该节点的原始错误, 否则返回 Errors.
+// ctx 取消后不再开始新的节点, 已经开始的节点执行完当前任务后结束, 返回 ctx.Err()
+func Run(ctx context.Context, name string, tasks []Task) error {
+	// 按节点分组, 保持节点第一次出现的顺序
+	var hosts []string
+	groups := make(map[string][]Task)
+	for _, t := range tasks {
+		if _, ok := groups[t.Host]; !ok {
+			hosts = append(hosts, t.Host)
+		}
+		groups[t.Host] = append(groups[t.Host], t)
+	}
+	if len(hosts) == 0 {
+		return nil
+	}
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	var errs Errors
+	var done int
+	sem := make(chan struct{}, limit)
+	for _, host := range hosts {
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			break
+		}
+		wg.Add(1)
+		go func(host string) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			var err error
+			for _, t := range groups[host] {
+				if err = ctx.Err(); err != nil {
+					break
+				}
+				if err = t.Run(); err != nil {
+					break
+				}
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			done++
+			if err != nil {
+				errs = append(errs, &HostError{Host: host, Err: err})
+				logger.Warningf("%s [%d/%d] %s 失败: %v\n", name, done, len(hosts), host, err)
+				return
+			}
+			logger.Infof("%s [%d/%d] %s 完成\n", name, done, len(hosts), host)
+		}(host)
+	}
+	wg.Wait()
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	// 按节点顺序返回错误
+	order := make(map[string]int)
+	for n, host := range hosts {
+		order[host] = n
+	}
+	sort.Slice(errs, func(a, b int) bool { return order[errs[a].Host] < order[errs[b].Host] })
+	switch len(errs) {
+	case 0:
+		return nil
+	case 1:
+		return errs[0].Err
+	}
+	return errs
+}
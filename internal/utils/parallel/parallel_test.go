@@ -0,0 +1,61 @@
+package parallel
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRun(t *testing.T) {
+	SetLimit(2)
+	defer SetLimit(DefaultLimit)
+
+	var mu sync.Mutex
+	var running, max int
+	order := make(map[string][]int)
+	task := func(host string, n int, err error) Task {
+		return Task{Host: host, Run: func() error {
+			mu.Lock()
+			running++
+			if running > max {
+				max = running
+			}
+			order[host] = append(order[host], n)
+			mu.Unlock()
+			time.Sleep(10 * time.Millisecond)
+			mu.Lock()
+			running--
+			mu.Unlock()
+			return err
+		}}
+	}
+
+	failed := errors.New("安装失败")
+	err := Run(context.Background(), "安装", []Task{
+		task("a", 1, nil), task("b", 1, nil), task("a", 2, nil),
+		task("c", 1, failed), task("d", 1, nil), task("d", 2, failed), task("d", 3, nil),
+	})
+	errs, ok := err.(Errors)
+	if !ok || len(errs) != 2 || errs[0].Host != "c" || errs[1].Host != "d" {
+		t.Fatalf("错误不正确: %v", err)
+	}
+	if max > 2 {
+		t.Fatalf("同时执行 %d 个节点, 超过限制", max)
+	}
+	if len(order["a"]) != 2 || order["a"][0] != 1 || len(order["d"]) != 2 {
+		t.Fatalf("同一节点上的任务执行顺序不正确: %v", order)
+	}
+
+	if err := Run(context.Background(), "安装", []Task{task("a", 1, failed)}); err != failed {
+		t.Fatalf("只有一个节点失败时应返回原始错误: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	runs := len(order["a"])
+	if err := Run(ctx, "安装", []Task{task("a", 3, nil)}); err != context.Canceled || len(order["a"]) != runs {
+		t.Fatalf("取消后不应该开始新的节点: %v", err)
+	}
+}
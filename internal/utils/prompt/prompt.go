@@ -0,0 +1,49 @@
+package prompt
+
+// 交互确认: 安装、卸载、删除集群等操作在没有指定 --yes 时需要确认.
+// 命令行从标准输入读取, 作为库使用时由调用方通过 SetConfirm 提供确认方式
+
+import (
+	"dbup/internal/output"
+	"dbup/internal/utils/logger"
+	"fmt"
+	"strings"
+)
+
+// ErrCanceled 没有确认, 操作已取消
+var ErrCanceled = output.Errorf(output.CodeCanceled, "操作已取消")
+
+// Func 确认回调, 返回是否继续
+type Func func(question string) (bool, error)
+
+var confirm Func = Terminal
+
+// SetConfirm 设置确认方式, f 为 nil 时恢复为从标准输入读取
+func SetConfirm(f Func) {
+	if f == nil {
+		f = Terminal
+	}
+	confirm = f
+}
+
+// Confirm 询问是否继续, 确认时返回 nil, 否则返回 ErrCanceled
+func Confirm(question string) error {
+	ok, err := confirm(question)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrCanceled
+	}
+	return nil
+}
+
+// Terminal 从标准输入读取, y 或 yes 表示确认
+func Terminal(question string) (bool, error) {
+	var yes string
+	logger.Successf("%s[y|n]:", question)
+	if _, err := fmt.Scanln(&yes); err != nil {
+		return false, err
+	}
+	return strings.ToUpper(yes) == "Y" || strings.ToUpper(yes) == "YES", nil
+}
@@ -0,0 +1,140 @@
+package secretfile
+
+// 密码等敏感参数不再直接拼接在命令行中(会出现在 ps 输出、shell 历史和日志文件中),
+// 而是写入只有 root 可读的文件, 或者通过标准输入传给 dbup.
+// 文件内容为 json 对象, key 为命令行参数名, value 为参数值, 例如:
+//
+//	{"admin-password": "xxx", "password": "yyy"}
+//
+// 为了方便手工使用, 文件内容不是 json 对象时, 整个文件内容(去掉末尾换行)作为 password 参数的值
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/pflag"
+)
+
+// DefaultFlag 文件内容不是 json 对象时, 对应的命令行参数
+const DefaultFlag = "password"
+
+// Dir 定时任务等长期使用的密码文件存放目录, 位于 ~/.dbup 目录下
+const Dir = "secrets"
+
+// Flags 可以从密码文件设置的命令行参数, 只有密码类参数, 其他参数不能通过密码文件修改
+var Flags = []string{
+	"password",
+	"admin-password",
+	"replpassword",
+	"bakpassword",
+	"seed-root-password",
+	"syspassword",
+	"ssh-password",
+	"grafana-password",
+	"mariadb-password",
+	"mongodb-password",
+	"redis-password",
+	"pass",
+	"secretkey",
+	"wal-archive-secret-key",
+}
+
+// Allowed 参数是否可以从密码文件设置
+func Allowed(name string) bool {
+	for _, f := range Flags {
+		if f == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Marshal 序列化为文件内容
+func Marshal(secrets map[string]string) ([]byte, error) {
+	return json.Marshal(secrets)
+}
+
+// Read 从 reader 中读取并解析
+func Read(r io.Reader) (map[string]string, error) {
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	return Parse(b)
+}
+
+// Parse 解析文件内容
+func Parse(b []byte) (map[string]string, error) {
+	trimmed := bytes.TrimSpace(b)
+	if len(trimmed) == 0 {
+		return nil, fmt.Errorf("密码内容为空")
+	}
+	if trimmed[0] != '{' {
+		return map[string]string{DefaultFlag: strings.TrimRight(string(b), "\r\n")}, nil
+	}
+	secrets := make(map[string]string)
+	if err := json.Unmarshal(trimmed, &secrets); err != nil {
+		return nil, fmt.Errorf("解析密码内容失败: %v", err)
+	}
+	return secrets, nil
+}
+
+// SetFlags 把密码设置到 flags 中的同名参数, 只能设置 Flags 中列出的密码参数
+func SetFlags(flags *pflag.FlagSet, secrets map[string]string) error {
+	for name, value := range secrets {
+		if !Allowed(name) {
+			return fmt.Errorf("密码文件中的 %s 不是密码参数, 密码文件只能设置: %s", name, strings.Join(Flags, ", "))
+		}
+		if flags.Lookup(name) == nil {
+			return fmt.Errorf("不支持参数 --%s, 请检查密码文件", name)
+		}
+		if err := flags.Set(name, value); err != nil {
+			return fmt.Errorf("设置参数 --%s 失败: %v", name, err)
+		}
+	}
+	return nil
+}
+
+// Load 读取密码文件
+func Load(file string) (map[string]string, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, fmt.Errorf("打开密码文件 %s 失败: %v", file, err)
+	}
+	defer f.Close()
+	secrets, err := Read(f)
+	if err != nil {
+		return nil, fmt.Errorf("读取密码文件 %s 失败: %v", file, err)
+	}
+	return secrets, nil
+}
+
+// Write 将密码写入本地文件, 文件权限为 0600, 目录不存在时以 0700 权限创建
+func Write(file string, secrets map[string]string) error {
+	b, err := Marshal(secrets)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(file), 0700); err != nil {
+		return fmt.Errorf("创建目录 %s 失败: %v", filepath.Dir(file), err)
+	}
+	f, err := os.OpenFile(file, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return fmt.Errorf("创建密码文件 %s 失败: %v", file, err)
+	}
+	defer f.Close()
+	// 文件已存在时 OpenFile 不会修改权限
+	if err := f.Chmod(0600); err != nil {
+		return fmt.Errorf("修改密码文件 %s 权限失败: %v", file, err)
+	}
+	if _, err := f.Write(b); err != nil {
+		return fmt.Errorf("写入密码文件 %s 失败: %v", file, err)
+	}
+	return nil
+}
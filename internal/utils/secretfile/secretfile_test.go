@@ -0,0 +1,61 @@
+package secretfile
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/spf13/pflag"
+)
+
+func TestWriteLoad(t *testing.T) {
+	file := filepath.Join(t.TempDir(), Dir, "task.json")
+	want := map[string]string{"admin-password": "a'b\"c", "password": "p@ss word"}
+	if err := Write(file, want); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Fatalf("密码文件权限应该为 0600, 实际: %o", info.Mode().Perm())
+	}
+
+	got, err := Load(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != len(want) || got["admin-password"] != want["admin-password"] || got["password"] != want["password"] {
+		t.Fatalf("读取结果不一致: %v", got)
+	}
+}
+
+func TestParsePlain(t *testing.T) {
+	got, err := Parse([]byte("secret\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got[DefaultFlag] != "secret" {
+		t.Fatalf("纯文本内容应该作为 %s 的值, 实际: %v", DefaultFlag, got)
+	}
+
+	if _, err := Parse([]byte("\n")); err == nil {
+		t.Fatalf("空内容应该报错")
+	}
+}
+
+func TestSetFlags(t *testing.T) {
+	flags := pflag.NewFlagSet("test", pflag.ContinueOnError)
+	password := flags.String("password", "", "")
+	flags.String("command", "mariadb-dump", "")
+	if err := SetFlags(flags, map[string]string{"password": "secret"}); err != nil {
+		t.Fatal(err)
+	}
+	if *password != "secret" {
+		t.Fatalf("password 应该被设置, 实际: %q", *password)
+	}
+	if err := SetFlags(flags, map[string]string{"command": "/tmp/evil"}); err == nil {
+		t.Fatal("密码文件不能设置非密码参数")
+	}
+}
@@ -0,0 +1,188 @@
+package sshutil
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"golang.org/x/crypto/ssh/terminal"
+)
+
+// ssh 认证方式
+const (
+	AuthAgent     = "agent"     // 通过 SSH_AUTH_SOCK 使用正在运行的 ssh-agent
+	AuthPublicKey = "publickey" // 使用私钥文件, 支持带密码的私钥
+	AuthPassword  = "password"  // 使用密码
+)
+
+// PassphraseEnv 私钥密码环境变量
+const PassphraseEnv = "DBUP_SSH_PASSPHRASE"
+
+var (
+	authLock sync.Mutex
+	// 同一个私钥只需要输入一次密码, 部署多台机器时复用
+	signers     = make(map[string]ssh.Signer)
+	agentClient agent.ExtendedAgent
+)
+
+func validateAuth(o Options) error {
+	if o.Auth == "" {
+		return nil
+	}
+	for _, method := range strings.Split(o.Auth, ",") {
+		switch strings.TrimSpace(method) {
+		case AuthAgent, AuthPublicKey, AuthPassword:
+		default:
+			return fmt.Errorf("不支持的 ssh 认证方式: %s, 可选值: %s, %s, %s", method, AuthAgent, AuthPublicKey, AuthPassword)
+		}
+	}
+	return nil
+}
+
+// AuthMethods 生成认证方式列表, 连接时按顺序尝试.
+// 配置了 Auth 时严格按配置的顺序, 缺少对应的凭据则报错;
+// 未配置时按 publickey, agent, password 的顺序使用已有的凭据, 默认私钥文件不存在时跳过;
+// 为了兼容只使用密码的部署, 未配置且指定了密码时不使用 ssh-agent, 避免 agent 中的密钥过多触发 MaxAuthTries
+func (o Options) AuthMethods(password, keyfile string) ([]ssh.AuthMethod, error) {
+	if err := validateAuth(o); err != nil {
+		return nil, err
+	}
+	if password == "" {
+		password = o.Password
+	}
+	if keyfile == "" {
+		keyfile = o.KeyFile
+	}
+
+	explicit := o.Auth != ""
+	order := []string{AuthPublicKey, AuthAgent, AuthPassword}
+	if explicit {
+		order = strings.Split(o.Auth, ",")
+	}
+
+	var auth []ssh.AuthMethod
+	for _, method := range order {
+		switch strings.TrimSpace(method) {
+		case AuthPublicKey:
+			if keyfile == "" {
+				if explicit {
+					return nil, fmt.Errorf("ssh 认证方式包含 %s, 但是没有指定私钥文件", AuthPublicKey)
+				}
+				continue
+			}
+			if _, err := os.Stat(keyfile); os.IsNotExist(err) && !explicit && (password != "" || agentAvailable()) {
+				continue
+			}
+			signer, err := o.keyFileSigner(keyfile)
+			if err != nil {
+				return nil, err
+			}
+			auth = append(auth, ssh.PublicKeys(signer))
+		case AuthAgent:
+			if !explicit && password != "" {
+				continue
+			}
+			if !agentAvailable() {
+				if explicit {
+					return nil, fmt.Errorf("ssh 认证方式包含 %s, 但是环境变量 SSH_AUTH_SOCK 未设置", AuthAgent)
+				}
+				continue
+			}
+			client, err := sshAgent()
+			if err != nil {
+				return nil, err
+			}
+			auth = append(auth, ssh.PublicKeysCallback(client.Signers))
+		case AuthPassword:
+			if password == "" {
+				if explicit {
+					return nil, fmt.Errorf("ssh 认证方式包含 %s, 但是没有指定 ssh 密码", AuthPassword)
+				}
+				continue
+			}
+			auth = append(auth, ssh.Password(password))
+		}
+	}
+
+	if len(auth) == 0 {
+		return nil, fmt.Errorf("没有可用的 ssh 认证方式, 请指定 ssh 密码、私钥文件或启动 ssh-agent")
+	}
+	return auth, nil
+}
+
+func agentAvailable() bool {
+	return os.Getenv("SSH_AUTH_SOCK") != ""
+}
+
+func sshAgent() (agent.ExtendedAgent, error) {
+	authLock.Lock()
+	defer authLock.Unlock()
+	if agentClient != nil {
+		return agentClient, nil
+	}
+	conn, err := net.Dial("unix", os.Getenv("SSH_AUTH_SOCK"))
+	if err != nil {
+		return nil, fmt.Errorf("连接 ssh-agent(%s) 失败: %v", os.Getenv("SSH_AUTH_SOCK"), err)
+	}
+	agentClient = agent.NewClient(conn)
+	return agentClient, nil
+}
+
+// keyFileSigner 读取私钥, 私钥带密码时依次从密码文件, 环境变量 DBUP_SSH_PASSPHRASE, 终端输入获取密码
+func (o Options) keyFileSigner(keyfile string) (ssh.Signer, error) {
+	authLock.Lock()
+	defer authLock.Unlock()
+	if signer, ok := signers[keyfile]; ok {
+		return signer, nil
+	}
+
+	key, err := ioutil.ReadFile(keyfile)
+	if err != nil {
+		return nil, err
+	}
+	signer, err := ssh.ParsePrivateKey(key)
+	var missing *ssh.PassphraseMissingError
+	if errors.As(err, &missing) {
+		var passphrase []byte
+		if passphrase, err = o.passphrase(keyfile); err != nil {
+			return nil, err
+		}
+		if signer, err = ssh.ParsePrivateKeyWithPassphrase(key, passphrase); err != nil {
+			return nil, fmt.Errorf("解密私钥 %s 失败: %v", keyfile, err)
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("解析私钥 %s 失败: %v", keyfile, err)
+	}
+	signers[keyfile] = signer
+	return signer, nil
+}
+
+func (o Options) passphrase(keyfile string) ([]byte, error) {
+	if o.PassphraseFile != "" {
+		b, err := ioutil.ReadFile(o.PassphraseFile)
+		if err != nil {
+			return nil, fmt.Errorf("读取私钥密码文件 %s 失败: %v", o.PassphraseFile, err)
+		}
+		return []byte(strings.TrimRight(string(b), "\r\n")), nil
+	}
+	if p := os.Getenv(PassphraseEnv); p != "" {
+		return []byte(p), nil
+	}
+	if !terminal.IsTerminal(int(os.Stdin.Fd())) {
+		return nil, fmt.Errorf("私钥 %s 需要密码, 请通过 --ssh-passphrase-file 或环境变量 %s 指定", keyfile, PassphraseEnv)
+	}
+	fmt.Fprintf(os.Stderr, "请输入私钥 %s 的密码: ", keyfile)
+	p, err := terminal.ReadPassword(int(os.Stdin.Fd()))
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return nil, fmt.Errorf("读取私钥密码失败: %v", err)
+	}
+	return p, nil
+}
@@ -0,0 +1,45 @@
+package sshutil
+
+import (
+	"io"
+	"net"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// Proxied 是否配置了跳板机
+func (o Options) Proxied() bool {
+	return o.ProxyJump != "" || len(o.JumpHosts) > 0
+}
+
+// Forward 在本地 127.0.0.1 的随机端口上监听, 将收到的连接经 ssh 隧道转发到 remote.
+// 经跳板机部署时, 控制机无法直接访问数据库端口, 验证主从状态等操作需要通过该隧道连接数据库
+func Forward(client *ssh.Client, remote string) (net.Listener, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+
+	go func() {
+		for {
+			local, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func(local net.Conn) {
+				defer local.Close()
+				target, err := client.Dial("tcp", remote)
+				if err != nil {
+					return
+				}
+				defer target.Close()
+
+				done := make(chan struct{}, 2)
+				go func() { io.Copy(target, local); done <- struct{}{} }()
+				go func() { io.Copy(local, target); done <- struct{}{} }()
+				<-done
+			}(local)
+		}
+	}()
+	return listener, nil
+}
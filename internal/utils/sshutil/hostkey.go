@@ -0,0 +1,163 @@
+package sshutil
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// 主机密钥校验策略
+const (
+	HostKeyStrict   = "strict"   // 只接受 known_hosts 中已记录的主机密钥(默认)
+	HostKeyTOFU     = "tofu"     // 首次连接时记录主机密钥, 之后严格校验
+	HostKeyInsecure = "insecure" // 不校验主机密钥, 仅用于测试环境
+)
+
+// DbupKnownHostsFile dbup 自己维护的 known_hosts 文件名, 位于 ~/.dbup 目录下
+const DbupKnownHostsFile = "known_hosts"
+
+var (
+	insecureSkipHostKey bool
+	knownHostsLock      sync.Mutex
+)
+
+// SetInsecureSkipHostKey 全局关闭主机密钥校验, 对应命令行参数 --insecure-skip-host-key
+func SetInsecureSkipHostKey(skip bool) {
+	insecureSkipHostKey = skip
+}
+
+func validateHostKeyCheck(o Options) error {
+	switch o.HostKeyCheck {
+	case "", HostKeyStrict, HostKeyTOFU, HostKeyInsecure:
+		return nil
+	default:
+		return fmt.Errorf("不支持的主机密钥校验策略: %s, 可选值: %s, %s, %s", o.HostKeyCheck, HostKeyStrict, HostKeyTOFU, HostKeyInsecure)
+	}
+}
+
+func (o Options) hostKeyPolicy() string {
+	if insecureSkipHostKey {
+		return HostKeyInsecure
+	}
+	if o.HostKeyCheck == "" {
+		return HostKeyStrict
+	}
+	return o.HostKeyCheck
+}
+
+// knownHostsFiles 返回用于校验的文件列表, 以及首次信任时写入的文件
+func (o Options) knownHostsFiles() ([]string, string) {
+	if o.KnownHosts != "" {
+		return []string{o.KnownHosts}, o.KnownHosts
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return nil, ""
+	}
+	dbupFile := filepath.Join(home, ".dbup", DbupKnownHostsFile)
+	return []string{filepath.Join(home, ".ssh", "known_hosts"), dbupFile}, dbupFile
+}
+
+// HostKeyCallback 根据校验策略生成 ssh.HostKeyCallback
+func (o Options) HostKeyCallback() (ssh.HostKeyCallback, error) {
+	if err := validateHostKeyCheck(o); err != nil {
+		return nil, err
+	}
+	policy := o.hostKeyPolicy()
+	if policy == HostKeyInsecure {
+		return ssh.InsecureIgnoreHostKey(), nil
+	}
+
+	files, trustFile := o.knownHostsFiles()
+	if len(files) == 0 {
+		return nil, fmt.Errorf("获取当前用户家目录失败, 无法定位 known_hosts 文件")
+	}
+
+	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		// 每次连接都重新读取文件, 以便看到同一进程中首次信任写入的记录
+		check, err := knownhostsCallback(files)
+		if err != nil {
+			return err
+		}
+
+		err = check(hostname, remote, key)
+		if err == nil {
+			return nil
+		}
+
+		var keyErr *knownhosts.KeyError
+		if !errors.As(err, &keyErr) {
+			return err
+		}
+
+		for _, want := range keyErr.Want {
+			if want.Key.Type() == key.Type() {
+				return fmt.Errorf("主机 %s 的密钥已变更, 可能存在中间人攻击! 收到 %s 指纹 %s, 与 %s:%d 中记录的 %s 不一致. 如确认主机已重装, 请删除该行记录后重试",
+					hostname, key.Type(), ssh.FingerprintSHA256(key), want.Filename, want.Line, ssh.FingerprintSHA256(want.Key))
+			}
+		}
+		if len(keyErr.Want) > 0 {
+			// 已记录了其它类型的密钥, 不允许通过首次信任追加新类型, 防止降级攻击
+			want := keyErr.Want[0]
+			return fmt.Errorf("主机 %s 提供的 %s 密钥(指纹 %s)未记录, %s:%d 中只记录了 %s 密钥. 请使用 ssh-keyscan -t %s 确认后手工加入",
+				hostname, key.Type(), ssh.FingerprintSHA256(key), want.Filename, want.Line, want.Key.Type(), key.Type())
+		}
+
+		if policy != HostKeyTOFU {
+			return fmt.Errorf("主机 %s 不在 known_hosts 中(%s 指纹 %s). 请先执行 ssh-keyscan 将其加入 %s, 或将 host-key-check 设置为 %s 以首次信任",
+				hostname, key.Type(), ssh.FingerprintSHA256(key), strings.Join(files, ", "), HostKeyTOFU)
+		}
+		return trustHostKey(trustFile, hostname, key)
+	}, nil
+}
+
+// knownhostsCallback 只读取已存在的文件, 都不存在时任何主机都视为未知
+func knownhostsCallback(files []string) (ssh.HostKeyCallback, error) {
+	knownHostsLock.Lock()
+	defer knownHostsLock.Unlock()
+
+	var exists []string
+	for _, f := range files {
+		if _, err := os.Stat(f); err == nil {
+			exists = append(exists, f)
+		}
+	}
+	if len(exists) == 0 {
+		return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+			return &knownhosts.KeyError{}
+		}, nil
+	}
+	check, err := knownhosts.New(exists...)
+	if err != nil {
+		return nil, fmt.Errorf("读取 known_hosts 文件失败: %v", err)
+	}
+	return check, nil
+}
+
+// trustHostKey 首次信任, 将主机密钥追加写入 known_hosts
+func trustHostKey(file, hostname string, key ssh.PublicKey) error {
+	knownHostsLock.Lock()
+	defer knownHostsLock.Unlock()
+
+	if err := os.MkdirAll(filepath.Dir(file), 0700); err != nil {
+		return fmt.Errorf("创建目录 %s 失败: %v", filepath.Dir(file), err)
+	}
+	f, err := os.OpenFile(file, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return fmt.Errorf("打开 known_hosts 文件 %s 失败: %v", file, err)
+	}
+	defer f.Close()
+
+	line := knownhosts.Line([]string{knownhosts.Normalize(hostname)}, key)
+	if _, err := f.WriteString(line + "\n"); err != nil {
+		return fmt.Errorf("写入 known_hosts 文件 %s 失败: %v", file, err)
+	}
+	return nil
+}
@@ -0,0 +1,58 @@
+package sshutil
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func newHostKey(t *testing.T) ssh.PublicKey {
+	pub, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := ssh.NewPublicKey(pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func TestHostKeyCallback(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "known_hosts")
+	remote := &net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 22}
+	key := newHostKey(t)
+
+	strict, err := Options{KnownHosts: file}.HostKeyCallback()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := strict("10.0.0.1:22", remote, key); err == nil {
+		t.Fatalf("strict 模式下未知主机应该校验失败")
+	}
+
+	tofu, err := Options{HostKeyCheck: HostKeyTOFU, KnownHosts: file}.HostKeyCallback()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tofu("10.0.0.1:22", remote, key); err != nil {
+		t.Fatalf("tofu 模式首次连接应该成功: %v", err)
+	}
+	if err := strict("10.0.0.1:22", remote, key); err != nil {
+		t.Fatalf("首次信任后 strict 模式应该校验成功: %v", err)
+	}
+
+	err = tofu("10.0.0.1:22", remote, newHostKey(t))
+	if err == nil || !strings.Contains(err.Error(), "密钥已变更") {
+		t.Fatalf("主机密钥变更后应该校验失败, 实际: %v", err)
+	}
+
+	if _, err := (Options{HostKeyCheck: "none"}).HostKeyCallback(); err == nil {
+		t.Fatalf("不支持的校验策略应该报错")
+	}
+}
@@ -0,0 +1,236 @@
+package sshutil
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// JumpHost 跳板机(堡垒机)配置, 多个跳板机按顺序组成一条代理链
+type JumpHost struct {
+	Host     string `yaml:"host"`
+	Port     int    `yaml:"port"`
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+	KeyFile  string `yaml:"keyfile"`
+}
+
+func (j JumpHost) Address() string {
+	return net.JoinHostPort(j.Host, strconv.Itoa(j.Port))
+}
+
+func (j JumpHost) String() string {
+	u := url.URL{Host: j.Address()}
+	if j.Username != "" {
+		u.User = url.User(j.Username)
+	}
+	if j.KeyFile != "" {
+		u.RawQuery = url.Values{"keyfile": []string{j.KeyFile}}.Encode()
+	}
+	return strings.TrimPrefix(u.String(), "//")
+}
+
+// JumpHosts 跳板机代理链.
+// 可以在 yaml 中写成列表, 也可以写成字符串, 字符串格式见 ParseJumpHosts
+type JumpHosts []JumpHost
+
+// ParseJumpHosts 解析跳板机字符串, 多个跳板机以逗号分隔, 按连接顺序排列. 每个跳板机的格式为:
+//
+//	[user[:password]@]host[:port][?keyfile=/path/to/key]
+//
+// 密码中的特殊字符需要使用 URL 编码, 例如 @ 写为 %40
+func ParseJumpHosts(spec string) (JumpHosts, error) {
+	var hosts JumpHosts
+	for _, hop := range strings.Split(spec, ",") {
+		hop = strings.TrimSpace(hop)
+		if hop == "" {
+			continue
+		}
+		u, err := url.Parse("ssh://" + strings.TrimPrefix(hop, "ssh://"))
+		if err != nil {
+			return nil, fmt.Errorf("跳板机配置(%s)格式错误: %v", hop, err)
+		}
+		j := JumpHost{Host: u.Hostname()}
+		if j.Host == "" {
+			return nil, fmt.Errorf("跳板机配置(%s)缺少主机地址", hop)
+		}
+		if u.Port() != "" {
+			if j.Port, err = strconv.Atoi(u.Port()); err != nil {
+				return nil, fmt.Errorf("跳板机配置(%s)端口错误: %v", hop, err)
+			}
+		}
+		if u.User != nil {
+			j.Username = u.User.Username()
+			j.Password, _ = u.User.Password()
+		}
+		j.KeyFile = u.Query().Get("keyfile")
+		hosts = append(hosts, j)
+	}
+	return hosts, nil
+}
+
+// String 实现 pflag.Value 接口, 以便直接绑定到命令行参数
+func (h *JumpHosts) String() string {
+	if h == nil {
+		return ""
+	}
+	var hops []string
+	for _, j := range *h {
+		hops = append(hops, j.String())
+	}
+	return strings.Join(hops, ",")
+}
+
+func (h *JumpHosts) Set(spec string) error {
+	hosts, err := ParseJumpHosts(spec)
+	if err != nil {
+		return err
+	}
+	*h = append(*h, hosts...)
+	return nil
+}
+
+func (h *JumpHosts) Type() string {
+	return "proxy-jump"
+}
+
+// UnmarshalYAML 同时支持字符串和列表两种写法
+func (h *JumpHosts) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var spec string
+	if err := unmarshal(&spec); err == nil {
+		hosts, err := ParseJumpHosts(spec)
+		if err != nil {
+			return err
+		}
+		*h = hosts
+		return nil
+	}
+	var hosts []JumpHost
+	if err := unmarshal(&hosts); err != nil {
+		return err
+	}
+	*h = hosts
+	return nil
+}
+
+// Validator 验证跳板机配置
+func (h JumpHosts) Validator() error {
+	for _, j := range h {
+		if j.Host == "" {
+			return fmt.Errorf("跳板机地址不能为空")
+		}
+		if j.Port < 0 || j.Port > 65535 {
+			return fmt.Errorf("跳板机 %s 的端口号(%d)不正确", j.Host, j.Port)
+		}
+	}
+	return nil
+}
+
+// jumpHosts 合并字符串与结构化两种方式配置的跳板机, 并补全默认值
+func (o Options) jumpHosts(user string) (JumpHosts, error) {
+	hosts, err := ParseJumpHosts(o.ProxyJump)
+	if err != nil {
+		return nil, err
+	}
+	hosts = append(hosts, o.JumpHosts...)
+	if err := hosts.Validator(); err != nil {
+		return nil, err
+	}
+	for i := range hosts {
+		if hosts[i].Port == 0 {
+			hosts[i].Port = 22
+		}
+		if hosts[i].Username == "" {
+			hosts[i].Username = user
+		}
+	}
+	return hosts, nil
+}
+
+// jumpAuth 跳板机的认证方式: 配置了密码或密钥则使用跳板机自己的, 否则沿用目标机器的认证方式
+func (o Options) jumpAuth(j JumpHost, target []ssh.AuthMethod) ([]ssh.AuthMethod, error) {
+	var auth []ssh.AuthMethod
+	if j.KeyFile != "" {
+		signer, err := o.keyFileSigner(j.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		auth = append(auth, ssh.PublicKeys(signer))
+	}
+	if j.Password != "" {
+		auth = append(auth, ssh.Password(j.Password))
+	}
+	if len(auth) == 0 {
+		return target, nil
+	}
+	return auth, nil
+}
+
+// Dial 建立 ssh 连接, 配置了跳板机时依次经过每一个跳板机转发到目标机器
+func (o Options) Dial(address string, config *ssh.ClientConfig) (*ssh.Client, error) {
+	hosts, err := o.jumpHosts(config.User)
+	if err != nil {
+		return nil, err
+	}
+	if len(hosts) == 0 {
+		return ssh.Dial("tcp", address, config)
+	}
+
+	var chain []*ssh.Client
+	closeChain := func() {
+		for i := len(chain) - 1; i >= 0; i-- {
+			chain[i].Close()
+		}
+	}
+
+	for _, j := range hosts {
+		auth, err := o.jumpAuth(j, config.Auth)
+		if err != nil {
+			closeChain()
+			return nil, fmt.Errorf("读取跳板机 %s 的密钥失败: %v", j.Address(), err)
+		}
+		hopConfig := *config
+		hopConfig.User = j.Username
+		hopConfig.Auth = auth
+
+		client, err := dialVia(chain, j.Address(), &hopConfig)
+		if err != nil {
+			closeChain()
+			return nil, fmt.Errorf("连接跳板机 %s 失败: %v", j.Address(), err)
+		}
+		chain = append(chain, client)
+	}
+
+	client, err := dialVia(chain, address, config)
+	if err != nil {
+		closeChain()
+		return nil, fmt.Errorf("经跳板机 %s 连接 %s 失败: %v", chain[len(chain)-1].RemoteAddr(), address, err)
+	}
+
+	// 目标连接断开后, 依次关闭跳板机连接
+	go func() {
+		client.Wait()
+		closeChain()
+	}()
+	return client, nil
+}
+
+func dialVia(chain []*ssh.Client, address string, config *ssh.ClientConfig) (*ssh.Client, error) {
+	if len(chain) == 0 {
+		return ssh.Dial("tcp", address, config)
+	}
+	conn, err := chain[len(chain)-1].Dial("tcp", address)
+	if err != nil {
+		return nil, err
+	}
+	c, chans, reqs, err := ssh.NewClientConn(conn, address, config)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return ssh.NewClient(c, chans, reqs), nil
+}
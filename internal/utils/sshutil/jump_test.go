@@ -0,0 +1,28 @@
+package sshutil
+
+import "testing"
+
+func TestParseJumpHosts(t *testing.T) {
+	hosts, err := ParseJumpHosts("ops:p%40ss@10.0.0.1:2222, 10.0.0.2?keyfile=/root/.ssh/jump")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(hosts) != 2 {
+		t.Fatalf("应该解析出两个跳板机, 实际: %d", len(hosts))
+	}
+	if h := hosts[0]; h.Host != "10.0.0.1" || h.Port != 2222 || h.Username != "ops" || h.Password != "p@ss" {
+		t.Fatalf("第一个跳板机解析错误: %+v", h)
+	}
+	if h := hosts[1]; h.Host != "10.0.0.2" || h.Port != 0 || h.KeyFile != "/root/.ssh/jump" {
+		t.Fatalf("第二个跳板机解析错误: %+v", h)
+	}
+
+	opt := Options{ProxyJump: "10.0.0.2"}
+	chain, err := opt.jumpHosts("root")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if chain[0].Port != 22 || chain[0].Username != "root" {
+		t.Fatalf("跳板机默认值错误: %+v", chain[0])
+	}
+}
@@ -0,0 +1,54 @@
+package sshutil
+
+import (
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// Options 建立 ssh 连接时的可选配置, 由各部署配置文件的 [server] / ssh-config 段生成
+type Options struct {
+	HostKeyCheck string    // 主机密钥校验策略: strict, tofu, insecure; 为空时使用 strict
+	KnownHosts   string    // 指定 known_hosts 文件; 为空时读取 ~/.ssh/known_hosts 与 ~/.dbup/known_hosts
+	ProxyJump    string    // 跳板机字符串, 用于 ini 配置文件和命令行参数, 格式见 ParseJumpHosts
+	JumpHosts    JumpHosts // 跳板机列表, 用于 yaml 配置文件; 与 ProxyJump 同时配置时先经过 ProxyJump
+	// 认证方式及尝试顺序, 逗号分隔: agent, publickey, password. 为空时按 publickey, agent, password 顺序使用已有的凭据, 详见 AuthMethods
+	Auth           string
+	Password       string // ssh 密码, 与私钥同时配置时可以按 Auth 的顺序依次尝试
+	KeyFile        string // ssh 私钥文件
+	PassphraseFile string // 私钥密码文件, 私钥带密码时使用; 未指定时读取环境变量 DBUP_SSH_PASSPHRASE 或在终端输入
+}
+
+// Validator 验证配置
+func (o Options) Validator() error {
+	if err := validateHostKeyCheck(o); err != nil {
+		return err
+	}
+	if err := validateAuth(o); err != nil {
+		return err
+	}
+	_, err := o.jumpHosts("")
+	return err
+}
+
+// FirstOptions 取可变参数中的第一个配置, 没有传入时返回默认配置
+func FirstOptions(opts []Options) Options {
+	if len(opts) >= 1 {
+		return opts[0]
+	}
+	return Options{}
+}
+
+// ClientConfig 生成 ssh.ClientConfig
+func (o Options) ClientConfig(user string, auth []ssh.AuthMethod, timeout int64) (*ssh.ClientConfig, error) {
+	callback, err := o.HostKeyCallback()
+	if err != nil {
+		return nil, err
+	}
+	return &ssh.ClientConfig{
+		User:            user,
+		Auth:            auth,
+		HostKeyCallback: callback,
+		Timeout:         time.Duration(timeout) * time.Second,
+	}, nil
+}
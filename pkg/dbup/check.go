@@ -0,0 +1,19 @@
+package dbup
+
+import (
+	"context"
+	"dbup/internal/output"
+	"dbup/internal/preflight"
+)
+
+// CheckHost 在本机执行部署前检查, ports 和 dirs 为要安装的实例使用的端口和目录, 可以为空
+func CheckHost(ctx context.Context, engine string, ports []int, dirs []string) error {
+	return run(ctx, func() error {
+		if _, ok := preflight.Requirements[engine]; !ok {
+			return output.Errorf(output.CodeInvalidArgument, "不支持的引擎: %s", engine)
+		}
+		p := preflight.New(nil)
+		p.Add(preflight.LocalHost(), engine, ports, dirs...)
+		return p.Finish()
+	})
+}
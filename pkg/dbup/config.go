@@ -0,0 +1,63 @@
+package dbup
+
+import (
+	"context"
+	"dbup/internal/deployconf"
+	"dbup/internal/output"
+	"dbup/internal/utils"
+	"dbup/internal/utils/logger"
+	"fmt"
+	"io/ioutil"
+)
+
+// ConfigValidate 检查部署配置(ini 或 YAML), 输出所有问题及其所在的文件和行号
+func ConfigValidate(ctx context.Context, file, engine string) error {
+	return run(ctx, func() error {
+		problems, err := deployconf.Validate(file, engine)
+		if err != nil {
+			return output.Errorf(output.CodeInvalidArgument, "%v", err)
+		}
+		output.Set("problems", problems)
+		if len(problems) == 0 {
+			logger.Successf("%s 检查通过\n", file)
+			return nil
+		}
+		if !output.IsJSON() {
+			for _, p := range problems {
+				fmt.Println(p.Error())
+			}
+		}
+		return output.Errorf(output.CodeInvalidArgument, "%s 有 %d 个问题", file, len(problems))
+	})
+}
+
+// ConfigConvert 把旧的 ini 部署配置转换成 YAML, out 为空时输出到标准输出
+func ConfigConvert(ctx context.Context, file, engine, out string) error {
+	return run(ctx, func() error {
+		data, problems, err := deployconf.Convert(file, engine)
+		if err != nil {
+			return output.Errorf(output.CodeInvalidArgument, "%v", err)
+		}
+		for _, p := range problems {
+			logger.Warningf("%s\n", p.Error())
+		}
+		output.Set("problems", problems)
+		if out == "" {
+			if output.IsJSON() {
+				output.Set("yaml", string(data))
+			} else {
+				fmt.Print(string(data))
+			}
+			return nil
+		}
+		if utils.IsExists(out) {
+			return output.Errorf(output.CodeConflict, "文件 %s 已存在", out)
+		}
+		// 部署配置中可能有密码
+		if err := ioutil.WriteFile(out, data, 0600); err != nil {
+			return err
+		}
+		logger.Successf("已转换为 %s, 可以执行 dbup config validate %s 检查\n", out, out)
+		return nil
+	})
+}
@@ -0,0 +1,134 @@
+// Package dbup 以库的方式使用 dbup: 安装、部署、备份和管理 pgsql, redis, mongodb, mariadb 实例.
+//
+// 所有操作都接收 context.Context, 失败时返回错误而不会退出进程, 错误码通过 Code 获取.
+// 集群部署在 ctx 取消后不再开始新的步骤, 已经开始的步骤会执行完成, 失败时按配置回滚;
+// 单机安装、卸载和备份只在开始前检查 ctx.
+//
+// 提示信息和交互确认默认输出到标准输出、从标准输入读取, 可以通过 SetLogger 和 SetConfirm 替换.
+// 这两个设置以及 SetParallel 对整个进程生效, 同一进程中同时执行的操作共用一份设置.
+package dbup
+
+import (
+	"context"
+	"dbup/internal/environment"
+	"dbup/internal/global"
+	"dbup/internal/output"
+	"dbup/internal/utils/logger"
+	"dbup/internal/utils/parallel"
+	"dbup/internal/utils/prompt"
+	"sync"
+)
+
+// 错误码
+const (
+	CodeFailed          = output.CodeFailed
+	CodeInvalidArgument = output.CodeInvalidArgument
+	CodeNotFound        = output.CodeNotFound
+	CodeSSH             = output.CodeSSH
+	CodeCredential      = output.CodeCredential
+	CodeConflict        = output.CodeConflict
+	CodeCanceled        = output.CodeCanceled
+)
+
+// 提示信息的级别
+const (
+	LevelInfo    = logger.LevelInfo
+	LevelSuccess = logger.LevelSuccess
+	LevelWarning = logger.LevelWarning
+	LevelError   = logger.LevelError
+)
+
+// Error 带错误码的错误
+type Error = output.Error
+
+// SSHConfig 远程机器的 ssh 连接参数
+type SSHConfig = global.SSHConfig
+
+// ErrCanceled 交互确认时选择不继续
+var ErrCanceled = prompt.ErrCanceled
+
+// Code 返回错误码. 没有错误码的错误为 CodeFailed, ctx 取消或超时为 CodeCanceled
+func Code(err error) string {
+	return output.Code(err)
+}
+
+// Logger 接收提示信息, level 为 LevelInfo, LevelSuccess, LevelWarning, LevelError 之一
+type Logger func(level, message string)
+
+// SetLogger 提示信息交给 l 处理, 不再输出到标准输出; l 为 nil 时恢复输出到标准输出
+func SetLogger(l Logger) {
+	if l == nil {
+		logger.SetHandler(nil)
+		return
+	}
+	logger.SetHandler(logger.Handler(l))
+}
+
+// Confirm 交互确认的回调, 返回 true 继续操作, false 取消操作, 此时操作返回 ErrCanceled
+type Confirm func(question string) (bool, error)
+
+// SetConfirm 设置交互确认的方式, c 为 nil 时恢复为从标准输入读取.
+// 只有没有指定 Yes 的操作才会确认
+func SetConfirm(c Confirm) {
+	if c == nil {
+		prompt.SetConfirm(nil)
+		return
+	}
+	prompt.SetConfirm(prompt.Func(c))
+}
+
+// SetParallel 集群部署时同时操作的节点数, 1 为按顺序执行
+func SetParallel(n int) {
+	parallel.SetLimit(n)
+}
+
+var envMu sync.Mutex
+
+// initEnv 第一次调用时收集本机环境信息, 命令行已经在解析参数后设置过
+func initEnv() error {
+	envMu.Lock()
+	defer envMu.Unlock()
+	if environment.GlobalEnv() != nil {
+		return nil
+	}
+	e, err := environment.NewEnvironment()
+	if err != nil {
+		return err
+	}
+	environment.SetGlobalEnv(e)
+	return nil
+}
+
+// run ctx 没有取消时执行 fn
+func run(ctx context.Context, fn func() error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if err := initEnv(); err != nil {
+		return err
+	}
+	return fn()
+}
+
+// runAsRoot 同 run, 安装和卸载需要 root 权限
+func runAsRoot(ctx context.Context, fn func() error) error {
+	return run(ctx, func() error {
+		if err := environment.MustRoot(); err != nil {
+			return err
+		}
+		return fn()
+	})
+}
+
+// confirmUninstall 卸载前确认
+func confirmUninstall(port int, dir string, yes bool) error {
+	if port == 0 || dir == "" {
+		return output.Errorf(output.CodeInvalidArgument, "必须手动指定 --port and --dir 参数")
+	}
+	if yes {
+		return nil
+	}
+	logger.Successf("端口: %d\n", port)
+	logger.Successf("数据路径: %s\n", dir)
+	return prompt.Confirm("是否确认卸载")
+}
@@ -0,0 +1,60 @@
+package dbup
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestCode(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	called := false
+	err := run(ctx, func() error {
+		called = true
+		return nil
+	})
+	if called {
+		t.Fatal("ctx 已经取消, 不应该执行")
+	}
+	if Code(err) != CodeCanceled {
+		t.Fatalf("code = %s, want %s", Code(err), CodeCanceled)
+	}
+	if Code(errors.New("x")) != CodeFailed {
+		t.Fatalf("没有错误码的错误应该为 %s", CodeFailed)
+	}
+}
+
+func TestConfirm(t *testing.T) {
+	defer SetConfirm(nil)
+
+	var asked string
+	SetConfirm(func(question string) (bool, error) {
+		asked = question
+		return false, nil
+	})
+	SetLogger(func(level, message string) {})
+	defer SetLogger(nil)
+
+	if err := confirmUninstall(6379, "/opt/redis6379", false); err != ErrCanceled {
+		t.Fatalf("err = %v, want ErrCanceled", err)
+	}
+	if asked == "" {
+		t.Fatal("没有调用确认回调")
+	}
+	if Code(ErrCanceled) != CodeCanceled {
+		t.Fatalf("code = %s, want %s", Code(ErrCanceled), CodeCanceled)
+	}
+
+	asked = ""
+	if err := confirmUninstall(6379, "/opt/redis6379", true); err != nil {
+		t.Fatal(err)
+	}
+	if asked != "" {
+		t.Fatal("指定 yes 时不应该确认")
+	}
+	if err := confirmUninstall(0, "", true); Code(err) != CodeInvalidArgument {
+		t.Fatalf("code = %s, want %s", Code(err), CodeInvalidArgument)
+	}
+}
@@ -0,0 +1,170 @@
+package dbup
+
+import (
+	"context"
+	"dbup/internal/environment"
+	"dbup/internal/mariadb/config"
+	"dbup/internal/mariadb/service"
+	"dbup/internal/output"
+)
+
+// MariadbOptions mariadb 单机安装参数
+type MariadbOptions = config.MariaDBOptions
+
+// MariadbServer mariadb 添加从库时远程机器的 ssh 连接参数
+type MariadbServer = config.Server
+
+// MariadbUninstallOptions mariadb 卸载参数
+type MariadbUninstallOptions = service.MariaDBUNInstall
+
+// MariadbBackupOptions mariadb 备份参数
+type MariadbBackupOptions = service.Backup
+
+// MariadbBackupTaskOptions mariadb 备份定时任务参数
+type MariadbBackupTaskOptions = service.BackupTask
+
+// MariadbRestoreOptions mariadb 逻辑备份恢复参数
+type MariadbRestoreOptions = service.Restore
+
+// MariadbUpgradeOptions mariadb 升级参数, 由 NewMariadbUpgradeOptions 生成
+type MariadbUpgradeOptions = service.UPgrade
+
+// NewMariadbUpgradeOptions 生成带默认升级内容的 mariadb 升级参数
+func NewMariadbUpgradeOptions() *MariadbUpgradeOptions {
+	return service.NewUPgrade()
+}
+
+// MariadbInstall 安装 mariadb 单机实例, option.Join 不为空时安装为从库; onlyCheck 只检查配置和环境
+func MariadbInstall(ctx context.Context, option MariadbOptions, onlyCheck bool) error {
+	return runAsRoot(ctx, func() error {
+		option.Parameter()
+		if err := option.Validator(); err != nil {
+			return err
+		}
+		if err := option.Environment(); err != nil {
+			return err
+		}
+		if onlyCheck {
+			return nil
+		}
+		return service.NewMariaDBInstall(&option).Run()
+	})
+}
+
+// MariadbAddSlave 通过 ssh 在远程机器上安装 mariadb 实例并同步 option.Join 主库
+func MariadbAddSlave(ctx context.Context, server MariadbServer, option MariadbOptions) error {
+	return run(ctx, func() error {
+		switch {
+		case server.Address == "":
+			return output.Errorf(output.CodeInvalidArgument, "请指定要创建从库的远程机器IP地址")
+		case option.Port == 0:
+			return output.Errorf(output.CodeInvalidArgument, "请指定要创建从库的端口号")
+		case option.Join == "":
+			return output.Errorf(output.CodeInvalidArgument, "请指定要加入的主库实例信息:<IP:PORT>")
+		case option.Password == "":
+			return output.Errorf(output.CodeInvalidArgument, "请指定 Mariadb 的超级管理员 root 用户密码")
+		case option.Repluser == "":
+			return output.Errorf(output.CodeInvalidArgument, "请指定 Mariadb 复制用户名")
+		case option.ReplPassword == "":
+			return output.Errorf(output.CodeInvalidArgument, "请指定 Mariadb 复制用户密码")
+		case option.Backupuser == "":
+			return output.Errorf(output.CodeInvalidArgument, "请指定 Mariadb 备份数据的用户名")
+		case option.BackupPassword == "":
+			return output.Errorf(output.CodeInvalidArgument, "请指定 Mariadb 备份数据的用户密码")
+		}
+		return service.NewMariaDBManager().AddSlaveNode(server, option)
+	})
+}
+
+// MariadbUninstall 卸载本机的 mariadb 实例, yes 为 false 时需要确认
+func MariadbUninstall(ctx context.Context, uninst *MariadbUninstallOptions, yes bool) error {
+	return runAsRoot(ctx, func() error {
+		if err := confirmUninstall(uninst.Port, uninst.BasePath, yes); err != nil {
+			return err
+		}
+		return uninst.Uninstall()
+	})
+}
+
+// MariadbBackup 备份 mariadb 实例, 物理备份需要 root 权限
+func MariadbBackup(ctx context.Context, backup *MariadbBackupOptions) error {
+	if backup.Type == service.BackupTypePhysical {
+		return runAsRoot(ctx, backup.Run)
+	}
+	return run(ctx, backup.Run)
+}
+
+// MariadbBackupTask 管理 mariadb 备份定时任务, action 为 list, add, del 或 run
+func MariadbBackupTask(ctx context.Context, action string, task *MariadbBackupTaskOptions) error {
+	return run(ctx, func() error {
+		if action == "run" {
+			return task.Run()
+		}
+		task.SetName(task.Backup.Port)
+		switch environment.GlobalEnv().GOOS + "_" + action {
+		case "linux_list":
+			return task.LinuxList()
+		case "linux_add":
+			return task.LinuxAdd()
+		case "linux_del":
+			return task.LinuxDel()
+		default:
+			return output.Errorf(output.CodeInvalidArgument, "不支持的操作系统或操作类型: %s %s", environment.GlobalEnv().GOOS, action)
+		}
+	})
+}
+
+// MariadbRestore 把 mariadb-dump 生成的逻辑备份导入到实例, restore.Physical 时从物理备份恢复, restore.Yes 为 false 时需要确认
+func MariadbRestore(ctx context.Context, restore *MariadbRestoreOptions) error {
+	if restore.Physical {
+		return runAsRoot(ctx, restore.Run)
+	}
+	return run(ctx, restore.Run)
+}
+
+// MariadbUpgrade 升级 mariadb 实例, upgrade.Yes 为 false 时需要确认
+func MariadbUpgrade(ctx context.Context, upgrade *MariadbUpgradeOptions) error {
+	return run(ctx, upgrade.Run)
+}
+
+// MariadbDeploy 按配置文件部署 mariadb 主从或主主集群
+func MariadbDeploy(ctx context.Context, cfgFile string) error {
+	return run(ctx, func() error {
+		return service.NewmariadbDeploy().Run(cfgFile)
+	})
+}
+
+// MariadbDeployCheck 按部署配置文件连接各节点执行部署前检查
+func MariadbDeployCheck(ctx context.Context, cfgFile string) error {
+	return run(ctx, func() error {
+		return service.NewmariadbDeploy().Check(cfgFile)
+	})
+}
+
+// MariadbRemoveDeploy 按配置文件删除 mariadb 集群(主从/galera), yes 为 false 时需要确认
+func MariadbRemoveDeploy(ctx context.Context, cfgFile string, yes bool) error {
+	return run(ctx, func() error {
+		return service.NewmariadbDeploy().RemoveCluster(cfgFile, yes)
+	})
+}
+
+// MariadbGaleraDeploy 按配置文件部署 mariadb Galera 集群
+func MariadbGaleraDeploy(ctx context.Context, cfgFile string) error {
+	return run(ctx, func() error {
+		return service.NewGaleraDeploy().WithContext(ctx).Run(cfgFile)
+	})
+}
+
+// MariadbGaleraDeployCheck 按部署配置文件连接各节点执行部署前检查
+func MariadbGaleraDeployCheck(ctx context.Context, cfgFile string) error {
+	return run(ctx, func() error {
+		return service.NewGaleraDeploy().Check(cfgFile)
+	})
+}
+
+// MariadbGaleraDeployPlan 只检查配置并只读连接各节点, 输出部署计划和冲突
+func MariadbGaleraDeployPlan(ctx context.Context, cfgFile string) error {
+	return run(ctx, func() error {
+		return service.NewGaleraDeploy().Plan(cfgFile)
+	})
+}
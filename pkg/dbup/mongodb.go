@@ -0,0 +1,180 @@
+package dbup
+
+import (
+	"context"
+	"dbup/internal/environment"
+	"dbup/internal/mongodb/config"
+	"dbup/internal/mongodb/service"
+	"dbup/internal/output"
+	"dbup/internal/utils"
+
+	"github.com/go-playground/validator"
+)
+
+// MongodbOptions mongodb 单机安装参数
+type MongodbOptions = config.MongodbOptions
+
+// MongodbUninstallOptions mongodb 卸载参数
+type MongodbUninstallOptions = service.MongoDBUNInstall
+
+// MongodbBackupOptions mongodb 备份参数
+type MongodbBackupOptions = service.Backup
+
+// MongodbBackupTaskOptions mongodb 备份定时任务参数
+type MongodbBackupTaskOptions = service.BackupTask
+
+// MongodbInstall 安装 mongodb 单机实例, option.Join 不为空时加入已有的副本集; onlyCheck 只检查配置和环境
+func MongodbInstall(ctx context.Context, option MongodbOptions, onlyCheck bool) error {
+	return runAsRoot(ctx, func() error {
+		if option.Username == "" || option.Password == "" {
+			return output.Errorf(output.CodeInvalidArgument, "请指定 MongoDB 的超级管理员用户名和密码")
+		}
+		if err := utils.CheckPasswordLever(option.Password); err != nil {
+			return err
+		}
+		if err := option.CheckSpecialChar(); err != nil {
+			return err
+		}
+		if err := validateMongodbOptions(&option); err != nil {
+			return err
+		}
+
+		rs := service.NewMongoDBInstall(&option)
+		if err := rs.CheckEnv(); err != nil {
+			return err
+		}
+		if onlyCheck {
+			return nil
+		}
+		return rs.Run()
+	})
+}
+
+// MongodbAddSlave 通过 ssh 在远程机器上安装 mongodb 实例并加入 option.Join 所在的副本集
+func MongodbAddSlave(ctx context.Context, ssho SSHConfig, option MongodbOptions) error {
+	return run(ctx, func() error {
+		if ssho.Host == "" {
+			return output.Errorf(output.CodeInvalidArgument, "请指定要创建从库的远程机器IP地址")
+		}
+		if option.Port == 0 {
+			return output.Errorf(output.CodeInvalidArgument, "请指定要创建从库的端口号")
+		}
+		if option.Join == "" {
+			return output.Errorf(output.CodeInvalidArgument, "请指定要加入的主库实例信息:<IP:PORT>")
+		}
+		if option.Username == "" || option.Password == "" {
+			return output.Errorf(output.CodeInvalidArgument, "请指定 MongoDB 的超级管理员用户名和密码")
+		}
+		if err := validateMongodbOptions(&option); err != nil {
+			return err
+		}
+		return service.NewMongoDBManager().AddSlaveNode(ssho, option)
+	})
+}
+
+// validateMongodbOptions 补全默认参数并校验
+func validateMongodbOptions(option *MongodbOptions) error {
+	option.InitArgs()
+	validate := validator.New()
+	if err := validate.RegisterValidation("ipPort", config.ValidateIPPort); err != nil {
+		return err
+	}
+	if err := validate.Struct(*option); err != nil {
+		return output.Errorf(output.CodeInvalidArgument, "%v", err)
+	}
+	if err := option.CheckVersion(); err != nil {
+		return output.Errorf(output.CodeInvalidArgument, "%v", err)
+	}
+	return nil
+}
+
+// MongodbUninstall 卸载本机的 mongodb 实例, yes 为 false 时需要确认
+func MongodbUninstall(ctx context.Context, uninst *MongodbUninstallOptions, yes bool) error {
+	return runAsRoot(ctx, func() error {
+		if err := confirmUninstall(uninst.Port, uninst.BasePath, yes); err != nil {
+			return err
+		}
+		return uninst.Uninstall()
+	})
+}
+
+// MongodbBackupTask 管理 mongodb 备份定时任务, action 为 list, add, del 或 run
+func MongodbBackupTask(ctx context.Context, action string, task *MongodbBackupTaskOptions) error {
+	return run(ctx, func() error {
+		if action == "run" {
+			return task.Run()
+		}
+		task.SetName(task.Backup.Port)
+		switch environment.GlobalEnv().GOOS + "_" + action {
+		case "linux_list":
+			return task.LinuxList()
+		case "linux_add":
+			return task.LinuxAdd()
+		case "linux_del":
+			return task.LinuxDel()
+		default:
+			return output.Errorf(output.CodeInvalidArgument, "不支持的操作系统或操作类型: %s %s", environment.GlobalEnv().GOOS, action)
+		}
+	})
+}
+
+// MongodbBackup 备份 mongodb 实例
+func MongodbBackup(ctx context.Context, backup *MongodbBackupOptions) error {
+	return run(ctx, backup.Run)
+}
+
+// MongodbDeploy 按配置文件部署 mongodb 副本集, noRollback 为 true 时失败不回滚
+func MongodbDeploy(ctx context.Context, cfgFile string, noRollback bool) error {
+	return run(ctx, func() error {
+		return service.NewMongoDBDeploy().Run(cfgFile, noRollback)
+	})
+}
+
+// MongodbDeployCheck 按部署配置文件连接各节点执行部署前检查
+func MongodbDeployCheck(ctx context.Context, cfgFile string) error {
+	return run(ctx, func() error {
+		return service.NewMongoDBDeploy().Check(cfgFile)
+	})
+}
+
+// MongodbRemoveDeploy 按配置文件删除 mongodb 副本集, yes 为 false 时需要确认
+func MongodbRemoveDeploy(ctx context.Context, cfgFile string, yes bool) error {
+	return run(ctx, func() error {
+		return service.NewMongoDBDeploy().RemoveCluster(cfgFile, yes)
+	})
+}
+
+// MongodbClusterDeploy 按配置文件部署 mongodb 分片集群
+func MongodbClusterDeploy(ctx context.Context, cfgFile string) error {
+	return run(ctx, func() error {
+		return service.NewMongoClusterDeploy().WithContext(ctx).Run(cfgFile)
+	})
+}
+
+// MongodbClusterDeployResume 根据部署记录继续失败的 mongodb 分片集群部署
+func MongodbClusterDeployResume(ctx context.Context, id string) error {
+	return run(ctx, func() error {
+		return service.NewMongoClusterDeploy().WithContext(ctx).Resume(id)
+	})
+}
+
+// MongodbClusterDeployPlan 只检查配置并只读连接各节点, 输出部署计划和冲突
+func MongodbClusterDeployPlan(ctx context.Context, cfgFile string) error {
+	return run(ctx, func() error {
+		return service.NewMongoClusterDeploy().Plan(cfgFile)
+	})
+}
+
+// MongodbClusterDeployCheck 按部署配置文件连接各节点执行部署前检查
+func MongodbClusterDeployCheck(ctx context.Context, cfgFile string) error {
+	return run(ctx, func() error {
+		return service.NewMongoClusterDeploy().Check(cfgFile)
+	})
+}
+
+// MongodbClusterRemoveDeploy 按配置文件删除 mongodb 分片集群, yes 为 false 时需要确认
+func MongodbClusterRemoveDeploy(ctx context.Context, cfgFile string, yes bool) error {
+	return run(ctx, func() error {
+		return service.NewMongoClusterDeploy().RemoveCluster(cfgFile, yes)
+	})
+}
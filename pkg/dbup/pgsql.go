@@ -0,0 +1,240 @@
+package dbup
+
+import (
+	"context"
+	"dbup/internal/output"
+	"dbup/internal/pgsql/config"
+	"dbup/internal/pgsql/services"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// PgsqlPrepare pgsql 单机安装参数
+type PgsqlPrepare = config.Prepare
+
+// PgsqlUninstallOptions pgsql 卸载参数
+type PgsqlUninstallOptions = services.UNInstall
+
+// PgsqlBackupOptions pgsql 备份参数
+type PgsqlBackupOptions = services.Backup
+
+// PgsqlUpgradeOptions pgsql 升级参数
+type PgsqlUpgradeOptions = services.UPgrade
+
+// PgsqlRestoreOptions pgsql 恢复参数
+type PgsqlRestoreOptions = services.Restore
+
+// PgsqlManager pgsql 用户和库管理的连接参数
+type PgsqlManager = services.PGManager
+
+// PgsqlInstall 安装 pgsql 单机实例. cfgFile 不为空时使用配置文件中的参数;
+// onlyCheck 只检查配置和环境; onlyInstall 只安装不初始化, 用于从库
+func PgsqlInstall(ctx context.Context, pre PgsqlPrepare, cfgFile, packageName string, onlyCheck, onlyInstall bool) error {
+	return runAsRoot(ctx, func() error {
+		return services.NewInstall().Run(pre, cfgFile, packageName, onlyCheck, onlyInstall)
+	})
+}
+
+// PgsqlInstallSlave 在本机安装 pgsql 从库, master 为主库地址<IP:PORT>
+func PgsqlInstallSlave(ctx context.Context, pre PgsqlPrepare, master string) error {
+	return runAsRoot(ctx, func() error {
+		return services.NewInstall().RunSlave(pre, master)
+	})
+}
+
+// PgsqlAddSlave 通过 ssh 在远程机器上添加 pgsql 从库, master 为主库地址<IP:PORT>
+func PgsqlAddSlave(ctx context.Context, ssho SSHConfig, pre PgsqlPrepare, master string) error {
+	return run(ctx, func() error {
+		if ssho.Host == "" {
+			return output.Errorf(output.CodeInvalidArgument, "请指定要创建从库的远程机器IP地址")
+		}
+		if master == "" {
+			return output.Errorf(output.CodeInvalidArgument, "请指定要要同步的主库的节点信息<IP:PORT>")
+		}
+		if pre.Port == 0 {
+			return output.Errorf(output.CodeInvalidArgument, "请指定 --port 端口号")
+		}
+		return services.NewPGManager().AddSlave(ssho, pre, master)
+	})
+}
+
+// PgsqlUninstall 卸载本机的 pgsql 实例, yes 为 false 时需要确认
+func PgsqlUninstall(ctx context.Context, uninst *PgsqlUninstallOptions, yes bool) error {
+	return runAsRoot(ctx, func() error {
+		if err := confirmUninstall(uninst.Port, uninst.BasePath, yes); err != nil {
+			return err
+		}
+		return uninst.Uninstall()
+	})
+}
+
+// PgsqlBackup 备份 pgsql 实例
+func PgsqlBackup(ctx context.Context, backup *PgsqlBackupOptions) error {
+	return run(ctx, backup.Run)
+}
+
+// PgsqlRestore 把 pg_basebackup 备份目录恢复成新实例或已停止的实例. ssho.Host 不为空时通过 ssh 在远程机器上恢复
+func PgsqlRestore(ctx context.Context, ssho SSHConfig, restore *PgsqlRestoreOptions) error {
+	if restore.Prepare.Port == 0 {
+		return output.Errorf(output.CodeInvalidArgument, "请指定 --port 端口号")
+	}
+	if ssho.Host == "" {
+		return runAsRoot(ctx, restore.Run)
+	}
+	return run(ctx, func() error {
+		return restore.RunRemote(ssho)
+	})
+}
+
+// PgsqlWalArchivePush 归档一个 WAL 文件, 由 archive_command 调用
+func PgsqlWalArchivePush(ctx context.Context, cfgFile, walPath, name string) error {
+	return run(ctx, func() error {
+		w, err := services.NewWalArchive(cfgFile)
+		if err != nil {
+			return err
+		}
+		return w.Push(walPath, name)
+	})
+}
+
+// PgsqlWalArchiveFetch 从归档中取回一个 WAL 文件, 由 restore_command 调用
+func PgsqlWalArchiveFetch(ctx context.Context, cfgFile, name, target string) error {
+	return run(ctx, func() error {
+		w, err := services.NewWalArchive(cfgFile)
+		if err != nil {
+			return err
+		}
+		return w.Fetch(name, target)
+	})
+}
+
+// PgsqlWalArchiveStatus 查看实例的 WAL 归档状态. cfgFile 为空时使用 dir 或 /opt/pgsql$PORT 下的归档配置
+func PgsqlWalArchiveStatus(ctx context.Context, cfgFile, dir string, port int) error {
+	cfgFile, err := walArchiveConfFile(cfgFile, dir, port)
+	if err != nil {
+		return err
+	}
+	return run(ctx, func() error {
+		w, err := services.NewWalArchive(cfgFile)
+		if err != nil {
+			return err
+		}
+		dataDir := filepath.Join(filepath.Dir(cfgFile), config.DataDir)
+		if _, err := os.Stat(dataDir); err != nil {
+			dataDir = ""
+		}
+		return w.Status(dataDir)
+	})
+}
+
+// PgsqlWalArchivePrune 删除比 backupDir 中最早的基础备份还早的 WAL 归档
+func PgsqlWalArchivePrune(ctx context.Context, cfgFile, dir string, port int, backupDir string, dryRun bool) error {
+	cfgFile, err := walArchiveConfFile(cfgFile, dir, port)
+	if err != nil {
+		return err
+	}
+	return run(ctx, func() error {
+		w, err := services.NewWalArchive(cfgFile)
+		if err != nil {
+			return err
+		}
+		return w.Prune(backupDir, dryRun)
+	})
+}
+
+// walArchiveConfFile 实例的归档配置文件, 依次按 cfgFile, dir, port 查找
+func walArchiveConfFile(cfgFile, dir string, port int) (string, error) {
+	if cfgFile != "" {
+		return cfgFile, nil
+	}
+	if dir == "" {
+		if port == 0 {
+			return "", output.Errorf(output.CodeInvalidArgument, "请指定 --config, --dir 或 --port")
+		}
+		dir = fmt.Sprintf("%s%d", config.DefaultPGDir, port)
+	}
+	return config.WalArchiveConfPath(dir), nil
+}
+
+// PgsqlUpgrade 使用当前版本的程序包升级 pgsql 实例, upgrade.Yes 为 false 时需要确认
+func PgsqlUpgrade(ctx context.Context, upgrade *PgsqlUpgradeOptions) error {
+	return run(ctx, upgrade.Run)
+}
+
+// PgsqlDeploy 按配置文件部署 pgsql 主从集群
+func PgsqlDeploy(ctx context.Context, cfgFile string) error {
+	return run(ctx, func() error {
+		return services.NewDeploy().WithContext(ctx).Run(cfgFile)
+	})
+}
+
+// PgsqlDeployResume 根据部署记录继续失败的 pgsql 主从集群部署
+func PgsqlDeployResume(ctx context.Context, id string) error {
+	return run(ctx, func() error {
+		return services.NewDeploy().WithContext(ctx).Resume(id)
+	})
+}
+
+// PgsqlDeployPlan 只检查配置并只读连接各节点, 输出部署计划和冲突
+func PgsqlDeployPlan(ctx context.Context, cfgFile string) error {
+	return run(ctx, func() error {
+		return services.NewDeploy().Plan(cfgFile)
+	})
+}
+
+// PgsqlDeployCheck 按部署配置文件连接各节点执行部署前检查
+func PgsqlDeployCheck(ctx context.Context, cfgFile string) error {
+	return run(ctx, func() error {
+		return services.NewDeploy().Check(cfgFile)
+	})
+}
+
+// PgsqlRemoveDeploy 按配置文件删除 pgsql 主从集群, yes 为 false 时需要确认
+func PgsqlRemoveDeploy(ctx context.Context, cfgFile string, yes bool) error {
+	return run(ctx, func() error {
+		return services.NewDeploy().RemoveDeploy(cfgFile, yes)
+	})
+}
+
+// PgsqlUserCreate 创建用户, 用户名和密码为 m.User, m.Password
+func PgsqlUserCreate(ctx context.Context, m *PgsqlManager) error {
+	return run(ctx, func() error {
+		if m.User == "" || m.Password == "" {
+			return output.Errorf(output.CodeInvalidArgument, "请指定要创建的用户名和密码")
+		}
+		if err := m.InitConn(); err != nil {
+			return err
+		}
+		defer m.Conn.DB.Close()
+		return m.UserCreate()
+	})
+}
+
+// PgsqlUserGrant 给用户 m.User 授权登录库 m.DBName, 授权地址为 m.Address
+func PgsqlUserGrant(ctx context.Context, m *PgsqlManager) error {
+	return run(ctx, func() error {
+		if m.User == "" || m.DBName == "" || m.Address == "" {
+			return output.Errorf(output.CodeInvalidArgument, "请指定要授权的用户名,库名,IP地址")
+		}
+		if err := m.InitConn(); err != nil {
+			return err
+		}
+		defer m.Conn.DB.Close()
+		return m.UserGrant()
+	})
+}
+
+// PgsqlDatabaseCreate 创建库 m.DBName
+func PgsqlDatabaseCreate(ctx context.Context, m *PgsqlManager) error {
+	return run(ctx, func() error {
+		if m.DBName == "" {
+			return output.Errorf(output.CodeInvalidArgument, "请指定要创建的库名")
+		}
+		if err := m.InitConn(); err != nil {
+			return err
+		}
+		defer m.Conn.DB.Close()
+		return m.DatabaseCreate()
+	})
+}
@@ -0,0 +1,110 @@
+package dbup
+
+import (
+	"context"
+	"dbup/internal/output"
+	"dbup/internal/redis/config"
+	"dbup/internal/redis/services"
+)
+
+// RedisParameters redis 单机安装参数
+type RedisParameters = config.Parameters
+
+// RedisAddNodeOptions redis 添加节点参数
+type RedisAddNodeOptions = config.RedisClusterAddNodeOption
+
+// RedisUninstallOptions redis 卸载参数
+type RedisUninstallOptions = services.UNInstall
+
+// RedisBackupOptions redis 备份参数
+type RedisBackupOptions = services.Backup
+
+// RedisUpgradeOptions redis 升级参数
+type RedisUpgradeOptions = services.UPgrade
+
+// RedisInstall 安装 redis 单机实例, param.Master 不为空时安装为从库.
+// cfgFile 不为空时使用配置文件中的参数; onlyCheck 只检查配置和环境
+func RedisInstall(ctx context.Context, param RedisParameters, cfgFile string, onlyCheck bool) error {
+	return runAsRoot(ctx, func() error {
+		return services.NewInstall().Run(param, cfgFile, onlyCheck)
+	})
+}
+
+// RedisAddSlave 通过 ssh 在远程机器上添加 redis 从库
+func RedisAddSlave(ctx context.Context, o RedisAddNodeOptions) error {
+	return run(ctx, func() error {
+		if o.Parameter.Port == 0 {
+			return output.Errorf(output.CodeInvalidArgument, "请指定 --port 端口号")
+		}
+		return services.NewRedisManager().AddSlaveNode(o)
+	})
+}
+
+// RedisUninstall 卸载本机的 redis 实例, yes 为 false 时需要确认
+func RedisUninstall(ctx context.Context, uninst *RedisUninstallOptions, yes bool) error {
+	return runAsRoot(ctx, func() error {
+		if err := confirmUninstall(uninst.Port, uninst.BasePath, yes); err != nil {
+			return err
+		}
+		return uninst.Uninstall()
+	})
+}
+
+// RedisBackup 备份 redis 实例
+func RedisBackup(ctx context.Context, backup *RedisBackupOptions) error {
+	return run(ctx, backup.Run)
+}
+
+// RedisUpgrade 升级 redis 实例, upgrade.Yes 为 false 时需要确认
+func RedisUpgrade(ctx context.Context, upgrade *RedisUpgradeOptions) error {
+	return run(ctx, upgrade.Run)
+}
+
+// RedisDeploy 按配置文件部署 redis 主从集群
+func RedisDeploy(ctx context.Context, cfgFile string) error {
+	return run(ctx, func() error {
+		return services.NewDeploy().Run(cfgFile)
+	})
+}
+
+// RedisDeployCheck 按部署配置文件连接各节点执行部署前检查
+func RedisDeployCheck(ctx context.Context, cfgFile string) error {
+	return run(ctx, func() error {
+		return services.NewDeploy().Check(cfgFile)
+	})
+}
+
+// RedisRemoveDeploy 按配置文件删除 redis 主从集群, yes 为 false 时需要确认
+func RedisRemoveDeploy(ctx context.Context, cfgFile string, yes bool) error {
+	return run(ctx, func() error {
+		return services.NewDeploy().RemoveDeploy(cfgFile, yes)
+	})
+}
+
+// RedisClusterDeploy 按配置文件部署 redis cluster 集群
+func RedisClusterDeploy(ctx context.Context, cfgFile string) error {
+	return run(ctx, func() error {
+		return services.NewRedisClusterDeploy().WithContext(ctx).Run(cfgFile)
+	})
+}
+
+// RedisClusterDeployPlan 只检查配置并只读连接各节点, 输出部署计划和冲突
+func RedisClusterDeployPlan(ctx context.Context, cfgFile string) error {
+	return run(ctx, func() error {
+		return services.NewRedisClusterDeploy().Plan(cfgFile)
+	})
+}
+
+// RedisClusterDeployCheck 按部署配置文件连接各节点执行部署前检查
+func RedisClusterDeployCheck(ctx context.Context, cfgFile string) error {
+	return run(ctx, func() error {
+		return services.NewRedisClusterDeploy().Check(cfgFile)
+	})
+}
+
+// RedisClusterRemoveDeploy 按配置文件删除 redis cluster 集群, yes 为 false 时需要确认
+func RedisClusterRemoveDeploy(ctx context.Context, cfgFile string, yes bool) error {
+	return run(ctx, func() error {
+		return services.NewRedisClusterDeploy().RemoveCluster(cfgFile, yes)
+	})
+}
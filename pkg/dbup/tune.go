@@ -0,0 +1,38 @@
+package dbup
+
+import (
+	"context"
+	"dbup/internal/output"
+	"dbup/internal/tune"
+	"dbup/internal/utils/logger"
+)
+
+// TuneApply 按引擎的内置配置调优本机的内核参数、透明大页和资源限制
+func TuneApply(ctx context.Context, o tune.Options) error {
+	return runAsRoot(ctx, func() error {
+		if _, err := tune.Lookup(o.Engine); err != nil {
+			return output.Errorf(output.CodeInvalidArgument, "%v", err)
+		}
+		s, err := tune.Apply(o)
+		if err != nil {
+			return err
+		}
+		output.Set("tune", s)
+		logger.Successf("%s 调优完成, 可以执行 dbup tune revert --engine %s 恢复\n", o.Engine, o.Engine)
+		return nil
+	})
+}
+
+// TuneRevert 恢复引擎调优前的系统设置
+func TuneRevert(ctx context.Context, engine string) error {
+	return runAsRoot(ctx, func() error {
+		if _, err := tune.Lookup(engine); err != nil {
+			return output.Errorf(output.CodeInvalidArgument, "%v", err)
+		}
+		if err := tune.Revert(engine); err != nil {
+			return err
+		}
+		logger.Successf("%s 的调优已恢复\n", engine)
+		return nil
+	})
+}